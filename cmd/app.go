@@ -7,8 +7,12 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/spf13/cobra"
+
+	"github.com/sageailabs/fouskoti/pkg/repository"
 )
 
 type contextKey string
@@ -21,6 +25,23 @@ type RootCommandOptions struct {
 
 	VersionCommandOptions
 	ExpandCommandOptions
+	ResolveCommandOptions
+	GraphCommandOptions
+	ListCommandOptions
+	CacheCommandOptions
+	BundleCommandOptions
+	VendorCommandOptions
+	DeprecationsCommandOptions
+	SummaryCommandOptions
+	SnapshotCommandOptions
+	SBOMCommandOptions
+	ImagesCommandOptions
+	DaemonCommandOptions
+	WalkCommandOptions
+	DriftCommandOptions
+	OutdatedCommandOptions
+	BumpCommandOptions
+	DependenciesCommandOptions
 }
 
 func parseLogLevel(level string) (slog.Level, error) {
@@ -41,6 +62,23 @@ func parseLogLevel(level string) (slog.Level, error) {
 	return result, nil
 }
 
+// NewSignalContext returns a context canceled on SIGINT or SIGTERM, letting
+// commands stop and clean up their temporary files.  A second signal
+// terminates the program immediately.
+func NewSignalContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(
+		context.Background(),
+		os.Interrupt,
+		syscall.SIGTERM,
+	)
+	go func() {
+		<-ctx.Done()
+		// Restore the default signal handling.
+		stop()
+	}()
+	return ctx, stop
+}
+
 func getContextAndLogger(cmd *cobra.Command) (context.Context, *slog.Logger) {
 	ctx := cmd.Context()
 	if ctx == nil {
@@ -57,12 +95,18 @@ func NewRootCommand(options *RootCommandOptions) *cobra.Command {
 	command := &cobra.Command{
 		Use:   os.Args[0],
 		Short: "Expands HelmRelease objects into generated templates",
+		// Errors are printed by the caller with the credentials masked.
+		SilenceErrors: true,
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 			ctx := cmd.Context()
 			if ctx == nil {
 				cmd.SilenceUsage = true
 				return fmt.Errorf("must pass context into command")
 			}
+			configFileNames, err := applyConfigDefaults(cmd)
+			if err != nil {
+				return err
+			}
 			logLevel, err := parseLogLevel(options.logLevel)
 			if err != nil {
 				err = fmt.Errorf(
@@ -86,9 +130,11 @@ func NewRootCommand(options *RootCommandOptions) *cobra.Command {
 					options.logFormat,
 				)
 			}
-			logger := slog.New(handler)
+			logger := slog.New(repository.NewRedactingHandler(credentialRedactor, handler))
 			cmd.SetContext(context.WithValue(ctx, contextKeyLogger, logger))
-			logger.Debug("Finished initialization")
+			logger.
+				With("configFiles", configFileNames).
+				Debug("Finished initialization")
 			return nil
 		},
 	}
@@ -108,6 +154,23 @@ func NewRootCommand(options *RootCommandOptions) *cobra.Command {
 	)
 	command.AddCommand(NewVersionCommand(&options.VersionCommandOptions))
 	command.AddCommand(NewExpandCommand(&options.ExpandCommandOptions))
+	command.AddCommand(NewResolveCommand(&options.ResolveCommandOptions))
+	command.AddCommand(NewGraphCommand(&options.GraphCommandOptions))
+	command.AddCommand(NewListCommand(&options.ListCommandOptions))
+	command.AddCommand(NewCacheCommand(&options.CacheCommandOptions))
+	command.AddCommand(NewBundleCommand(&options.BundleCommandOptions))
+	command.AddCommand(NewVendorCommand(&options.VendorCommandOptions))
+	command.AddCommand(NewDeprecationsCommand(&options.DeprecationsCommandOptions))
+	command.AddCommand(NewSummaryCommand(&options.SummaryCommandOptions))
+	command.AddCommand(NewSnapshotCommand(&options.SnapshotCommandOptions))
+	command.AddCommand(NewSBOMCommand(&options.SBOMCommandOptions))
+	command.AddCommand(NewImagesCommand(&options.ImagesCommandOptions))
+	command.AddCommand(NewDaemonCommand(&options.DaemonCommandOptions))
+	command.AddCommand(NewWalkCommand(&options.WalkCommandOptions))
+	command.AddCommand(NewDriftCommand(&options.DriftCommandOptions))
+	command.AddCommand(NewOutdatedCommand(&options.OutdatedCommandOptions))
+	command.AddCommand(NewBumpCommand(&options.BumpCommandOptions))
+	command.AddCommand(NewDependenciesCommand(&options.DependenciesCommandOptions))
 
 	return command
 }
@@ -0,0 +1,110 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sageailabs/fouskoti/pkg/repository"
+)
+
+type BumpCommandOptions struct {
+	sourceOptions
+	policy string
+	dryRun bool
+}
+
+const BumpCommandName = "bump"
+
+func writeBumpedReleasesTable(
+	output io.Writer,
+	releases []repository.BumpedRelease,
+) error {
+	writer := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+	_, err := fmt.Fprintln(writer, "FILE\tNAMESPACE\tNAME\tCHART\tVERSION\tNEW VERSION")
+	if err != nil {
+		return fmt.Errorf("unable to write output: %w", err)
+	}
+	for _, release := range releases {
+		_, err = fmt.Fprintf(
+			writer,
+			"%s\t%s\t%s\t%s\t%s\t%s\n",
+			release.FileName,
+			release.Namespace,
+			release.Name,
+			release.Chart,
+			release.Version,
+			release.NewVersion,
+		)
+		if err != nil {
+			return fmt.Errorf("unable to write output: %w", err)
+		}
+	}
+	return writer.Flush()
+}
+
+func NewBumpCommand(options *BumpCommandOptions) *cobra.Command {
+	command := &cobra.Command{
+		Use:   BumpCommandName + " <file>...",
+		Short: "Bumps the chart versions HelmRelease objects are pinned to in the files to the latest ones",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, logger := getContextAndLogger(cmd)
+			start := time.Now()
+			logger.Info("Starting bump command")
+
+			err := func() error {
+				policy, err := repository.ParseBumpPolicy(options.policy)
+				if err != nil {
+					return repository.NewClassifiedError(repository.ErrorClassInput, err)
+				}
+
+				expander, credentials, err := options.getExpander(ctx, logger)
+				if err != nil {
+					return err
+				}
+
+				expandOptions, err := options.getExpandOptions(credentials)
+				if err != nil {
+					return err
+				}
+
+				releases, err := expander.BumpChartVersions(
+					args,
+					policy,
+					options.dryRun,
+					expandOptions,
+				)
+				if err != nil {
+					return err
+				}
+				return writeBumpedReleasesTable(os.Stdout, releases)
+			}()
+			logger.With("duration", time.Since(start)).Info("Finished bump command")
+			return err
+		},
+		SilenceUsage: true,
+	}
+	addSourceFlags(command.PersistentFlags(), &options.sourceOptions)
+	command.PersistentFlags().StringVarP(
+		&options.policy,
+		"policy",
+		"",
+		string(repository.BumpPolicyMinor),
+		"Versions to bump to: the latest ones (major), the latest ones with the same major versions (minor), or the latest ones with the same minor versions (patch)",
+	)
+	command.PersistentFlags().BoolVarP(
+		&options.dryRun,
+		"dry-run",
+		"",
+		false,
+		"Report the chart versions to bump without changing the files",
+	)
+	return command
+}
@@ -0,0 +1,140 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sageailabs/fouskoti/pkg/repository"
+)
+
+type BundleCommandOptions struct {
+	fetchOptions
+	outputFileName string
+	chartCacheDir  string
+}
+
+const BundleCommandName = "bundle"
+const BundleCreateCommandName = "create"
+const BundleExtractCommandName = "extract"
+
+func newBundleCreateCommand(options *BundleCommandOptions) *cobra.Command {
+	command := &cobra.Command{
+		Use:   BundleCreateCommandName,
+		Short: "Creates an archive with all charts HelmRelease objects refer to",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, logger := getContextAndLogger(cmd)
+			start := time.Now()
+			logger.Info("Starting bundle create command")
+
+			err := func() error {
+				if options.outputFileName == "" {
+					return fmt.Errorf("--output is required")
+				}
+
+				input, err := getYAMLInputReader(args)
+				if err != nil {
+					return err
+				}
+				defer func() {
+					if err := input.Close(); err != nil {
+						logger.
+							With("error", err).
+							Error("Failed to close input")
+					}
+				}()
+
+				expander, credentials, err := options.getExpander(ctx, logger)
+				if err != nil {
+					return err
+				}
+
+				output, err := os.Create(options.outputFileName)
+				if err != nil {
+					return fmt.Errorf(
+						"unable to create bundle file %s: %w",
+						options.outputFileName,
+						err,
+					)
+				}
+				err = expander.CreateBundle(credentials, input, output)
+				if closeErr := output.Close(); err == nil && closeErr != nil {
+					err = fmt.Errorf(
+						"unable to write bundle file %s: %w",
+						options.outputFileName,
+						closeErr,
+					)
+				}
+				if err != nil {
+					// Don't leave a partial bundle behind.
+					_ = os.Remove(options.outputFileName)
+				}
+				return err
+			}()
+			logger.With("duration", time.Since(start)).Info("Finished bundle create command")
+			return err
+		},
+		SilenceUsage: true,
+	}
+	addFetchFlags(command.Flags(), &options.fetchOptions)
+	command.Flags().StringVarP(
+		&options.outputFileName,
+		"output",
+		"o",
+		"",
+		"Name of the bundle file to create",
+	)
+	return command
+}
+
+func newBundleExtractCommand(options *BundleCommandOptions) *cobra.Command {
+	command := &cobra.Command{
+		Use:   BundleExtractCommandName + " <bundle-file>",
+		Short: "Populates the chart cache from a bundle archive",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, logger := getContextAndLogger(cmd)
+			start := time.Now()
+			logger.Info("Starting bundle extract command")
+
+			err := func() error {
+				if options.chartCacheDir == "" {
+					return fmt.Errorf("--chart-cache-dir is required")
+				}
+				input, err := os.Open(args[0])
+				if err != nil {
+					return fmt.Errorf("unable to open bundle file %s: %w", args[0], err)
+				}
+				defer func() { _ = input.Close() }()
+
+				return repository.ExtractBundle(input, options.chartCacheDir)
+			}()
+			logger.With("duration", time.Since(start)).Info("Finished bundle extract command")
+			return err
+		},
+		SilenceUsage: true,
+	}
+	command.Flags().StringVarP(
+		&options.chartCacheDir,
+		"chart-cache-dir",
+		"",
+		getDefaultChartCacheDir(),
+		"Directory to cache Helm charts",
+	)
+	return command
+}
+
+func NewBundleCommand(options *BundleCommandOptions) *cobra.Command {
+	command := &cobra.Command{
+		Use:   BundleCommandName,
+		Short: "Transfers charts between chart caches",
+	}
+	command.AddCommand(newBundleCreateCommand(options))
+	command.AddCommand(newBundleExtractCommand(options))
+
+	return command
+}
@@ -0,0 +1,71 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package cmd
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+type CacheCommandOptions struct {
+	fetchOptions
+	chartCacheDir string
+}
+
+const CacheCommandName = "cache"
+const CacheWarmCommandName = "warm"
+
+func newCacheWarmCommand(options *CacheCommandOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   CacheWarmCommandName,
+		Short: "Downloads charts of HelmRelease objects into the chart cache without rendering them",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, logger := getContextAndLogger(cmd)
+			start := time.Now()
+			logger.Info("Starting cache warm command")
+
+			err := func() error {
+				input, err := getYAMLInputReader(args)
+				if err != nil {
+					return err
+				}
+				defer func() {
+					if err := input.Close(); err != nil {
+						logger.
+							With("error", err).
+							Error("Failed to close input")
+					}
+				}()
+
+				expander, credentials, err := options.getExpander(ctx, logger)
+				if err != nil {
+					return err
+				}
+
+				return expander.WarmCache(credentials, input, options.chartCacheDir)
+			}()
+			logger.With("duration", time.Since(start)).Info("Finished cache warm command")
+			return err
+		},
+		SilenceUsage: true,
+	}
+}
+
+func NewCacheCommand(options *CacheCommandOptions) *cobra.Command {
+	command := &cobra.Command{
+		Use:   CacheCommandName,
+		Short: "Manages the chart cache",
+	}
+	addFetchFlags(command.PersistentFlags(), &options.fetchOptions)
+	command.PersistentFlags().StringVarP(
+		&options.chartCacheDir,
+		"chart-cache-dir",
+		"",
+		getDefaultChartCacheDir(),
+		"Directory to cache Helm charts",
+	)
+	command.AddCommand(newCacheWarmCommand(options))
+
+	return command
+}
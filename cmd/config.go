@@ -0,0 +1,284 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+const configFileName = ".fouskoti.yaml"
+
+// Returns the user-level configuration file, $XDG_CONFIG_HOME/fouskoti/config.yaml
+// or ~/.config/fouskoti/config.yaml on Linux, if it exists.
+func getUserConfigFileName() string {
+	userConfigDir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	fileName := filepath.Join(userConfigDir, "fouskoti", "config.yaml")
+	if _, err := os.Stat(fileName); err != nil {
+		return ""
+	}
+	return fileName
+}
+
+// Returns the repository-local configuration file found in the current
+// directory or its parents up to the root of the Git repository, if any.
+func getLocalConfigFileName() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("unable to get current directory: %w", err)
+	}
+	for {
+		fileName := filepath.Join(dir, configFileName)
+		if _, err := os.Stat(fileName); err == nil {
+			return fileName, nil
+		}
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return "", nil
+		}
+		parentDir := filepath.Dir(dir)
+		if parentDir == dir {
+			return "", nil
+		}
+		dir = parentDir
+	}
+}
+
+func readConfigFile(fileName string) (map[string]any, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read configuration file %s: %w", fileName, err)
+	}
+	config := map[string]any{}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf(
+			"unable to parse configuration file %s: %w",
+			fileName,
+			err,
+		)
+	}
+	return config, nil
+}
+
+// Converts a configuration value to the flag values to set: one for each list
+// item or a single one for scalars.
+func getConfigFlagValues(value any) ([]string, error) {
+	switch value := value.(type) {
+	case nil:
+		return []string{""}, nil
+	case []any:
+		result := make([]string, 0, len(value))
+		for _, item := range value {
+			if _, ok := item.(map[string]any); ok {
+				return nil, errors.New("list items must be scalar values")
+			}
+			result = append(result, fmt.Sprint(item))
+		}
+		return result, nil
+	case map[string]any:
+		return nil, errors.New("value must be a scalar or a list")
+	default:
+		return []string{fmt.Sprint(value)}, nil
+	}
+}
+
+// Options with file or directory paths, which are relative to the
+// configuration file setting them.
+var configPathOptions = map[string]bool{
+	"api-resources-file":  true,
+	"api-versions-file":   true,
+	"chart-cache-dir":     true,
+	"credentials-file":    true,
+	"daemon-socket":       true,
+	"flux-inventory":      true,
+	"from-lock":           true,
+	"image-policies-file": true,
+	"inventory":           true,
+	"kyverno-policy":      true,
+	"lookup-fixtures":     true,
+	"output-dir":          true,
+	"output-file":         true,
+	"policy-dir":          true,
+	"policy-report":       true,
+	"post-render-patch":   true,
+	"skip-releases-file":  true,
+	"storage-fixtures":    true,
+	"socket":              true,
+	"substitution-file":   true,
+	"vendor-dir":          true,
+	"write-lock":          true,
+}
+
+// Options with substitutions in the form <source>#[<ref>#]<path>, whose
+// paths are relative to the configuration file setting them.
+var configSubstitutionOptions = map[string]bool{
+	"chart-substitution": true,
+	"repo-substitution":  true,
+	"working-copy-subst": true,
+}
+
+// Resolves the relative path in the configuration value of the option against
+// the directory of the configuration file setting it.
+func getConfigPathValue(name string, value string, configDir string) string {
+	switch {
+	case configPathOptions[name]:
+		if value != "" && !filepath.IsAbs(value) {
+			return filepath.Join(configDir, value)
+		}
+	case configSubstitutionOptions[name]:
+		// The path is the last part, as the substitutions are parsed.
+		parts := strings.SplitN(value, "#", 3)
+		if len(parts) > 1 && parts[len(parts)-1] != "" && !filepath.IsAbs(parts[len(parts)-1]) {
+			parts[len(parts)-1] = filepath.Join(configDir, parts[len(parts)-1])
+			return strings.Join(parts, "#")
+		}
+	}
+	return value
+}
+
+func isKnownFlag(command *cobra.Command, name string) bool {
+	if command.Flags().Lookup(name) != nil ||
+		command.PersistentFlags().Lookup(name) != nil {
+		return true
+	}
+	for _, subcommand := range command.Commands() {
+		if isKnownFlag(subcommand, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the subcommand of the command with the name, if any.
+func getSubcommand(command *cobra.Command, name string) *cobra.Command {
+	for _, subcommand := range command.Commands() {
+		if subcommand.Name() == name {
+			return subcommand
+		}
+	}
+	return nil
+}
+
+// Returns the options in the configuration file which apply to the command:
+// the top-level ones, shared by all commands having them, overridden by the
+// ones in the sections of the command and of its parent commands, which are
+// maps keyed by the command names, e.g., bundle and create in it for the
+// bundle create command.  Sections of other commands are ignored.
+func getCommandConfig(cmd *cobra.Command, fileConfig map[string]any) (map[string]any, error) {
+	var commands []*cobra.Command
+	for command := cmd; command != nil; command = command.Parent() {
+		commands = append([]*cobra.Command{command}, commands...)
+	}
+	result := map[string]any{}
+	section := fileConfig
+	for index, command := range commands {
+		for _, name := range slices.Sorted(maps.Keys(section)) {
+			if _, ok := section[name].(map[string]any); ok && getSubcommand(command, name) != nil {
+				continue
+			}
+			if !isKnownFlag(command, name) {
+				if index == 0 {
+					return nil, fmt.Errorf("unknown option %s in configuration", name)
+				}
+				return nil, fmt.Errorf(
+					"unknown option %s in configuration section %s",
+					name,
+					strings.Join(getCommandNames(commands[1:index+1]), " "),
+				)
+			}
+			result[name] = section[name]
+		}
+		if index == len(commands)-1 {
+			break
+		}
+		section, _ = section[commands[index+1].Name()].(map[string]any)
+		if section == nil {
+			break
+		}
+	}
+	return result, nil
+}
+
+func getCommandNames(commands []*cobra.Command) []string {
+	names := make([]string, 0, len(commands))
+	for _, command := range commands {
+		names = append(names, command.Name())
+	}
+	return names
+}
+
+// Sets the flags of the command not given on the command line to the values
+// in the configuration files, keyed by the flag names.  Flags given on the
+// command line take precedence over the repository-local configuration file,
+// which takes precedence over the user-level one.  Top-level options are
+// shared by all commands having a flag of the name, and options of other
+// commands are ignored, so that a single file can configure all of them;
+// options in command sections only apply to the command, see
+// getCommandConfig.  Relative paths, including the paths of substitutions,
+// are resolved against the directory of the configuration file setting them.
+// Returns the names of the configuration files read.
+func applyConfigDefaults(cmd *cobra.Command) ([]string, error) {
+	localFileName, err := getLocalConfigFileName()
+	if err != nil {
+		return nil, err
+	}
+
+	var fileNames []string
+	config := map[string]any{}
+	// The directories of the configuration files setting each option.
+	configDirs := map[string]string{}
+	for _, fileName := range []string{getUserConfigFileName(), localFileName} {
+		if fileName == "" {
+			continue
+		}
+		fileConfig, err := readConfigFile(fileName)
+		if err != nil {
+			return nil, err
+		}
+		fileConfig, err = getCommandConfig(cmd, fileConfig)
+		if err != nil {
+			return nil, err
+		}
+		maps.Copy(config, fileConfig)
+		for name := range fileConfig {
+			configDirs[name] = filepath.Dir(fileName)
+		}
+		fileNames = append(fileNames, fileName)
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(config)) {
+		flag := cmd.Flags().Lookup(name)
+		if flag == nil {
+			continue
+		}
+		if flag.Changed {
+			continue
+		}
+		values, err := getConfigFlagValues(config[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid configuration option %s: %w", name, err)
+		}
+		for _, value := range values {
+			value = getConfigPathValue(name, value, configDirs[name])
+			if err := cmd.Flags().Set(name, value); err != nil {
+				return nil, fmt.Errorf(
+					"invalid configuration option %s value %s: %w",
+					name,
+					value,
+					err,
+				)
+			}
+		}
+	}
+	return fileNames, nil
+}
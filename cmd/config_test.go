@@ -0,0 +1,214 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"github.com/spf13/cobra"
+)
+
+var _ = ginkgo.Describe("Configuration files", func() {
+	var g gomega.Gomega
+	var rootDir string
+
+	type testOptions struct {
+		credentialsFileName     string
+		kubeVersion             string
+		skipReleases            []string
+		repoSubstitutions       []string
+		workingCopySubstitution string
+		output                  string
+	}
+
+	writeFile := func(fileName string, content string) {
+		g.Expect(os.MkdirAll(filepath.Dir(fileName), 0755)).To(gomega.Succeed())
+		g.Expect(os.WriteFile(fileName, []byte(content), 0644)).To(gomega.Succeed())
+	}
+
+	// Returns the options of a command run with the arguments after applying
+	// the configuration files.
+	applyConfig := func(args ...string) (*testOptions, []string, error) {
+		options := &testOptions{}
+		command := &cobra.Command{Use: "test"}
+		command.Flags().StringVar(&options.credentialsFileName, "credentials-file", "", "")
+		command.Flags().StringVar(&options.kubeVersion, "kube-version", "1.28", "")
+		command.Flags().StringArrayVar(&options.skipReleases, "skip-release", nil, "")
+		command.Flags().StringArrayVar(&options.repoSubstitutions, "repo-substitution", nil, "")
+		command.Flags().StringVar(&options.workingCopySubstitution, "working-copy-subst", "", "")
+		g.Expect(command.ParseFlags(args)).To(gomega.Succeed())
+		fileNames, err := applyConfigDefaults(command)
+		return options, fileNames, err
+	}
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+
+		var err error
+		rootDir, err = os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		ginkgo.DeferCleanup(os.RemoveAll, rootDir)
+		// The working directory is compared with paths in the temporary
+		// directory, which may be behind a symbolic link.
+		rootDir, err = filepath.EvalSymlinks(rootDir)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		workingDir, err := os.Getwd()
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		ginkgo.DeferCleanup(os.Chdir, workingDir)
+		savedConfigHome, found := os.LookupEnv("XDG_CONFIG_HOME")
+		ginkgo.DeferCleanup(func() {
+			if found {
+				os.Setenv("XDG_CONFIG_HOME", savedConfigHome)
+			} else {
+				os.Unsetenv("XDG_CONFIG_HOME")
+			}
+		})
+		os.Setenv("XDG_CONFIG_HOME", filepath.Join(rootDir, "config"))
+
+		g.Expect(os.MkdirAll(filepath.Join(rootDir, "repo", ".git"), 0755)).To(gomega.Succeed())
+		g.Expect(os.MkdirAll(filepath.Join(rootDir, "repo", "apps", "prod"), 0755)).To(gomega.Succeed())
+		g.Expect(os.Chdir(filepath.Join(rootDir, "repo", "apps", "prod"))).To(gomega.Succeed())
+	})
+
+	ginkgo.It("finds the local configuration file up to the root of the repository", func() {
+		writeFile(filepath.Join(rootDir, configFileName), "kube-version: \"1.27\"")
+		options, fileNames, err := applyConfig()
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(fileNames).To(gomega.BeEmpty())
+		g.Expect(options.kubeVersion).To(gomega.Equal("1.28"))
+
+		localFileName := filepath.Join(rootDir, "repo", "apps", configFileName)
+		writeFile(localFileName, "kube-version: \"1.30\"")
+		options, fileNames, err = applyConfig()
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(fileNames).To(gomega.Equal([]string{localFileName}))
+		g.Expect(options.kubeVersion).To(gomega.Equal("1.30"))
+	})
+
+	ginkgo.It("prefers the command line to the local and the user configuration", func() {
+		userFileName := filepath.Join(rootDir, "config", "fouskoti", "config.yaml")
+		writeFile(userFileName, "kube-version: \"1.29\"\nskip-release:\n- a/b\n- c/d")
+		localFileName := filepath.Join(rootDir, "repo", configFileName)
+		writeFile(localFileName, "kube-version: \"1.30\"")
+
+		options, fileNames, err := applyConfig()
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(fileNames).To(gomega.Equal([]string{userFileName, localFileName}))
+		g.Expect(options.kubeVersion).To(gomega.Equal("1.30"))
+		g.Expect(options.skipReleases).To(gomega.Equal([]string{"a/b", "c/d"}))
+
+		options, _, err = applyConfig("--kube-version=1.31")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(options.kubeVersion).To(gomega.Equal("1.31"))
+	})
+
+	ginkgo.It("resolves relative paths against the configuration file", func() {
+		writeFile(
+			filepath.Join(rootDir, "config", "fouskoti", "config.yaml"),
+			"credentials-file: credentials.yaml",
+		)
+		options, _, err := applyConfig()
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(options.credentialsFileName).To(gomega.Equal(
+			filepath.Join(rootDir, "config", "fouskoti", "credentials.yaml"),
+		))
+
+		writeFile(
+			filepath.Join(rootDir, "repo", configFileName),
+			"credentials-file: ci/credentials.yaml",
+		)
+		options, _, err = applyConfig()
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(options.credentialsFileName).To(gomega.Equal(
+			filepath.Join(rootDir, "repo", "ci", "credentials.yaml"),
+		))
+
+		writeFile(
+			filepath.Join(rootDir, "repo", configFileName),
+			"credentials-file: /etc/fouskoti/credentials.yaml",
+		)
+		options, _, err = applyConfig("--credentials-file=local.yaml")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(options.credentialsFileName).To(gomega.Equal("local.yaml"))
+		options, _, err = applyConfig()
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(options.credentialsFileName).To(gomega.Equal("/etc/fouskoti/credentials.yaml"))
+	})
+
+	ginkgo.It("resolves relative substitution paths against the configuration file", func() {
+		writeFile(filepath.Join(rootDir, "repo", configFileName), strings.Join([]string{
+			"repo-substitution:",
+			"- https://github.com/org/charts.git#../charts",
+			"- https://github.com/org/apps.git#main#/src/apps",
+			"working-copy-subst: flux-system/apps#.",
+		}, "\n"))
+		options, _, err := applyConfig()
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(options.repoSubstitutions).To(gomega.Equal([]string{
+			"https://github.com/org/charts.git#" + filepath.Join(rootDir, "charts"),
+			"https://github.com/org/apps.git#main#/src/apps",
+		}))
+		g.Expect(options.workingCopySubstitution).To(gomega.Equal(
+			"flux-system/apps#" + filepath.Join(rootDir, "repo"),
+		))
+
+		options, _, err = applyConfig("--working-copy-subst=flux-system/apps#.")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(options.workingCopySubstitution).To(gomega.Equal("flux-system/apps#."))
+	})
+
+	ginkgo.It("applies the options in the sections of the command", func() {
+		// Returns the options of the subcommand run after applying the
+		// configuration files.
+		applySectionConfig := func(name string) (*testOptions, error) {
+			options := &testOptions{}
+			root := &cobra.Command{Use: "fouskoti"}
+			root.PersistentFlags().StringVar(&options.kubeVersion, "kube-version", "1.28", "")
+			expand := &cobra.Command{Use: "expand"}
+			summary := &cobra.Command{Use: "summary"}
+			summary.Flags().StringVar(&options.output, "output", "table", "")
+			root.AddCommand(expand, summary)
+			command := map[string]*cobra.Command{"expand": expand, "summary": summary}[name]
+			g.Expect(command.ParseFlags(nil)).To(gomega.Succeed())
+			_, err := applyConfigDefaults(command)
+			return options, err
+		}
+
+		writeFile(filepath.Join(rootDir, "repo", configFileName), strings.Join([]string{
+			"kube-version: \"1.29\"",
+			"output: yaml",
+			"expand:",
+			"  kube-version: \"1.30\"",
+			"summary:",
+			"  output: json",
+		}, "\n"))
+		options, err := applySectionConfig("expand")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(options.kubeVersion).To(gomega.Equal("1.30"))
+		options, err = applySectionConfig("summary")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(options.kubeVersion).To(gomega.Equal("1.29"))
+		g.Expect(options.output).To(gomega.Equal("json"))
+
+		writeFile(filepath.Join(rootDir, "repo", configFileName), "expand:\n  output: json")
+		_, err = applySectionConfig("expand")
+		g.Expect(err).To(gomega.MatchError(
+			"unknown option output in configuration section expand",
+		))
+	})
+
+	ginkgo.It("rejects unknown options", func() {
+		writeFile(filepath.Join(rootDir, "repo", configFileName), "kube-verison: \"1.30\"")
+		_, _, err := applyConfig()
+		g.Expect(err).To(gomega.MatchError("unknown option kube-verison in configuration"))
+	})
+})
+
+func TestAll(t *testing.T) {
+	gomega.RegisterFailHandler(ginkgo.Fail)
+	ginkgo.RunSpecs(t, "Command Test Suite")
+}
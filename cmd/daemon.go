@@ -0,0 +1,497 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sageailabs/fouskoti/pkg/repository"
+)
+
+type DaemonCommandOptions struct {
+	socket      string
+	maxCacheAge time.Duration
+}
+
+const DaemonCommandName = "daemon"
+
+// daemonRequest is a command for the daemon to run the way the client would
+// have run it.
+type daemonRequest struct {
+	// Args are the command line arguments without the program name.
+	Args []string `json:"args"`
+	Dir  string   `json:"dir"`
+	// Env holds the variables of the environment of the client which the
+	// command reads.
+	Env   []string `json:"env"`
+	Stdin []byte   `json:"stdin"`
+}
+
+type daemonResponse struct {
+	Stdout   []byte `json:"stdout"`
+	Stderr   []byte `json:"stderr"`
+	ExitCode int    `json:"exitCode"`
+}
+
+func getDefaultDaemonSocket() string {
+	if socket := os.Getenv("FOUSKOTI_DAEMON_SOCKET"); socket != "" {
+		return socket
+	}
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		return filepath.Join(runtimeDir, "fouskoti.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("fouskoti-%d", os.Getuid()), "daemon.sock")
+}
+
+// Checks that the directory belongs to the user and is not accessible to
+// other users, who could otherwise replace the socket in it.
+func checkDaemonSocketDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return fmt.Errorf("unable to check socket directory %s: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("socket directory %s is not a directory", dir)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); !ok || int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("socket directory %s does not belong to the user", dir)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("socket directory %s is accessible to other users", dir)
+	}
+	return nil
+}
+
+// Checks that the socket belongs to the user and is in a directory which is
+// private to the user, as the requests carry the environment and the
+// standard input of the commands.
+func checkDaemonSocket(socket string) error {
+	if err := checkDaemonSocketDir(filepath.Dir(socket)); err != nil {
+		return err
+	}
+	info, err := os.Lstat(socket)
+	if err != nil {
+		return fmt.Errorf("unable to check socket %s: %w", socket, err)
+	}
+	if info.Mode().Type() != os.ModeSocket {
+		return fmt.Errorf("%s is not a socket", socket)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); !ok || int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("socket %s does not belong to the user", socket)
+	}
+	return nil
+}
+
+// daemonEnvVars are the environment variables the commands read, themselves
+// or through Git, Helm, and the AWS SDK, besides the ones with the prefixes
+// in daemonEnvVarPrefixes and the ones the credentials files reference.
+var daemonEnvVars = []string{
+	"HOME",
+	"TMPDIR",
+	"XDG_CACHE_HOME",
+	"XDG_CONFIG_HOME",
+	"XDG_DATA_HOME",
+	"SSH_AUTH_SOCK",
+	"SSH_KNOWN_HOSTS",
+	"SSL_CERT_FILE",
+	"SSL_CERT_DIR",
+	"HTTP_PROXY",
+	"HTTPS_PROXY",
+	"NO_PROXY",
+	"http_proxy",
+	"https_proxy",
+	"no_proxy",
+}
+
+var daemonEnvVarPrefixes = []string{"FOUSKOTI_", "HELM_", "AWS_"}
+
+// Returns the variables of the environment which the command reads, so that
+// the daemon gets none of the others.
+func getDaemonEnv(credentialsFileNames []string) ([]string, error) {
+	names, err := repository.GetCredentialsEnvVars(credentialsFileNames)
+	if err != nil {
+		return nil, err
+	}
+	names = append(names, daemonEnvVars...)
+	var result []string
+	for _, variable := range os.Environ() {
+		name, _, _ := strings.Cut(variable, "=")
+		hasPrefix := slices.ContainsFunc(daemonEnvVarPrefixes, func(prefix string) bool {
+			return strings.HasPrefix(name, prefix)
+		})
+		if hasPrefix || slices.Contains(names, name) {
+			result = append(result, variable)
+		}
+	}
+	return result, nil
+}
+
+func newDaemonClient(socket string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _ string, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+}
+
+// Runs the command with the arguments in the daemon listening on the socket
+// and writes its output.  Reports false without running the command when no
+// daemon is listening, or when the socket is not private to the user.
+func delegateToDaemon(
+	ctx context.Context,
+	logger *slog.Logger,
+	socket string,
+	args []string,
+	credentialsFileNames []string,
+	readStdin bool,
+) (bool, error) {
+	if err := checkDaemonSocket(socket); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			logger.
+				With("socket", socket).
+				Debug("No daemon to delegate to")
+		} else {
+			logger.
+				With("socket", socket).
+				With("error", err).
+				Warn("Not delegating to daemon, its socket is not private")
+		}
+		return false, nil
+	}
+	connection, err := net.Dial("unix", socket)
+	if err != nil {
+		logger.
+			With("socket", socket).
+			With("error", err).
+			Debug("No daemon to delegate to")
+		return false, nil
+	}
+	// Failures to close the probe connection are not interesting.
+	_ = connection.Close()
+
+	dir, err := os.Getwd()
+	if err != nil {
+		return true, fmt.Errorf("unable to get working directory: %w", err)
+	}
+	env, err := getDaemonEnv(credentialsFileNames)
+	if err != nil {
+		return true, err
+	}
+	request := daemonRequest{Args: args, Dir: dir, Env: env}
+	if readStdin {
+		request.Stdin, err = io.ReadAll(os.Stdin)
+		if err != nil {
+			return true, fmt.Errorf("unable to read standard input: %w", err)
+		}
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return true, fmt.Errorf("unable to encode daemon request: %w", err)
+	}
+
+	logger.With("socket", socket).Debug("Delegating to daemon")
+	httpRequest, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		"http://daemon/run",
+		bytes.NewReader(body),
+	)
+	if err != nil {
+		return true, fmt.Errorf("unable to create daemon request: %w", err)
+	}
+	httpResponse, err := newDaemonClient(socket).Do(httpRequest)
+	if err != nil {
+		return true, fmt.Errorf("unable to run command in daemon: %w", err)
+	}
+	defer func() {
+		// Failures to close the response are not interesting.
+		_ = httpResponse.Body.Close()
+	}()
+	if httpResponse.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(httpResponse.Body)
+		return true, fmt.Errorf(
+			"daemon failed to run command: %s",
+			strings.TrimSpace(string(message)),
+		)
+	}
+	var response daemonResponse
+	if err := json.NewDecoder(httpResponse.Body).Decode(&response); err != nil {
+		return true, fmt.Errorf("unable to decode daemon response: %w", err)
+	}
+
+	if _, err := os.Stdout.Write(response.Stdout); err != nil {
+		return true, fmt.Errorf("unable to write output: %w", err)
+	}
+	if _, err := os.Stderr.Write(response.Stderr); err != nil {
+		return true, fmt.Errorf("unable to write output: %w", err)
+	}
+	if response.ExitCode != 0 {
+		return true, &reportedError{exitCode: response.ExitCode}
+	}
+	return true, nil
+}
+
+// daemon runs expand commands for clients, keeping the charts they load in
+// memory for the later commands.
+type daemon struct {
+	// mutex serializes the commands, which share the working directory, the
+	// environment, and the standard streams of the process.
+	mutex       sync.Mutex
+	logger      *slog.Logger
+	maxCacheAge time.Duration
+	chartCache  *repository.ChartCache
+	cacheStart  time.Time
+}
+
+// Returns the chart cache, replaced with an empty one once it is older than
+// the maximum age, so that charts of Git branches and other moving
+// references are loaded again.
+func (daemon *daemon) getChartCache() *repository.ChartCache {
+	if daemon.chartCache == nil ||
+		(daemon.maxCacheAge > 0 && time.Since(daemon.cacheStart) > daemon.maxCacheAge) {
+		daemon.chartCache = repository.NewChartCache()
+		daemon.cacheStart = time.Now()
+	}
+	return daemon.chartCache
+}
+
+// Sets the working directory and the environment of the process to the ones
+// of the request and returns the function restoring them.
+func setProcessState(request *daemonRequest) (func(), error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get working directory: %w", err)
+	}
+	if err := os.Chdir(request.Dir); err != nil {
+		return nil, fmt.Errorf("unable to change directory to %s: %w", request.Dir, err)
+	}
+	env := os.Environ()
+	setEnv := func(env []string) {
+		os.Clearenv()
+		for _, variable := range env {
+			if name, value, found := strings.Cut(variable, "="); found {
+				// Failures are only possible for invalid names.
+				_ = os.Setenv(name, value)
+			}
+		}
+	}
+	setEnv(request.Env)
+	return func() {
+		setEnv(env)
+		// The directory was the working directory before.
+		_ = os.Chdir(dir)
+	}, nil
+}
+
+// Runs the command of the request with the standard streams of the process
+// redirected to temporary files.
+func (daemon *daemon) run(
+	ctx context.Context,
+	request *daemonRequest,
+) (*daemonResponse, error) {
+	daemon.mutex.Lock()
+	defer daemon.mutex.Unlock()
+
+	streamDir, err := os.MkdirTemp("", "fouskoti-daemon-")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create a directory for streams: %w", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(streamDir); err != nil {
+			daemon.logger.
+				With("error", err).
+				With("dir", streamDir).
+				Error("Unable to clean the stream directory")
+		}
+	}()
+	stdinFileName := filepath.Join(streamDir, "stdin")
+	if err := os.WriteFile(stdinFileName, request.Stdin, 0600); err != nil {
+		return nil, fmt.Errorf("unable to write standard input: %w", err)
+	}
+	streams := []**os.File{&os.Stdin, &os.Stdout, &os.Stderr}
+	files := make([]*os.File, len(streams))
+	for i, name := range []string{"stdin", "stdout", "stderr"} {
+		fileName := filepath.Join(streamDir, name)
+		if i == 0 {
+			files[i], err = os.Open(fileName)
+		} else {
+			files[i], err = os.Create(fileName)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to open %s: %w", name, err)
+		}
+		defer func() {
+			// Failures to close the stream files are not interesting.
+			_ = files[i].Close()
+		}()
+	}
+
+	restore, err := setProcessState(request)
+	if err != nil {
+		return nil, err
+	}
+	original := make([]*os.File, len(streams))
+	for i, stream := range streams {
+		original[i], *stream = *stream, files[i]
+	}
+	exitCode := func() int {
+		defer func() {
+			for i, stream := range streams {
+				*stream = original[i]
+			}
+			restore()
+		}()
+
+		options := RootCommandOptions{}
+		options.ExpandCommandOptions.chartCache = daemon.getChartCache()
+		command := NewRootCommand(&options)
+		found, _, err := command.Find(request.Args)
+		if err == nil && found.Name() != ExpandCommandName {
+			err = fmt.Errorf("the daemon only runs the %s command", ExpandCommandName)
+		}
+		if err == nil {
+			command.SetArgs(request.Args)
+			err = command.ExecuteContext(ctx)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", RedactError(err))
+			return GetExitCode(err)
+		}
+		return 0
+	}()
+
+	response := &daemonResponse{ExitCode: exitCode}
+	response.Stdout, err = os.ReadFile(filepath.Join(streamDir, "stdout"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read standard output: %w", err)
+	}
+	response.Stderr, err = os.ReadFile(filepath.Join(streamDir, "stderr"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read standard error: %w", err)
+	}
+	return response, nil
+}
+
+func (daemon *daemon) ServeHTTP(writer http.ResponseWriter, httpRequest *http.Request) {
+	if httpRequest.Method != http.MethodPost || httpRequest.URL.Path != "/run" {
+		http.NotFound(writer, httpRequest)
+		return
+	}
+	var request daemonRequest
+	if err := json.NewDecoder(httpRequest.Body).Decode(&request); err != nil {
+		http.Error(writer, fmt.Sprintf("invalid request: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	start := time.Now()
+	response, err := daemon.run(httpRequest.Context(), &request)
+	if err != nil {
+		daemon.logger.With("error", err).Error("Failed to run command")
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	daemon.logger.
+		With("args", request.Args).
+		With("exitCode", response.ExitCode).
+		With("duration", time.Since(start)).
+		Info("Ran command")
+	writer.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(writer).Encode(response); err != nil {
+		daemon.logger.With("error", err).Error("Failed to write response")
+	}
+}
+
+// Listens on the socket, replacing the socket file left behind by a daemon
+// which is no longer running.  The directory of the socket is created if it
+// doesn't exist, and has to be private to the user, so that the socket is
+// never accessible to other users.
+func listenOnDaemonSocket(socket string) (net.Listener, error) {
+	dir := filepath.Dir(socket)
+	if err := os.Mkdir(dir, 0700); err != nil && !errors.Is(err, os.ErrExist) {
+		return nil, fmt.Errorf("unable to create socket directory %s: %w", dir, err)
+	}
+	if err := checkDaemonSocketDir(dir); err != nil {
+		return nil, err
+	}
+	if connection, err := net.Dial("unix", socket); err == nil {
+		// Failures to close the probe connection are not interesting.
+		_ = connection.Close()
+		return nil, fmt.Errorf("a daemon is already listening on %s", socket)
+	}
+	if err := os.Remove(socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("unable to remove stale socket %s: %w", socket, err)
+	}
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen on %s: %w", socket, err)
+	}
+	return listener, nil
+}
+
+func NewDaemonCommand(options *DaemonCommandOptions) *cobra.Command {
+	command := &cobra.Command{
+		Use:   DaemonCommandName,
+		Short: "Runs expand commands delegated to it, keeping the loaded charts in memory between them",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, logger := getContextAndLogger(cmd)
+			logger.Info("Starting daemon command")
+
+			listener, err := listenOnDaemonSocket(options.socket)
+			if err != nil {
+				return err
+			}
+			server := &http.Server{
+				Handler: &daemon{logger: logger, maxCacheAge: options.maxCacheAge},
+			}
+			go func() {
+				<-ctx.Done()
+				if err := server.Shutdown(context.Background()); err != nil {
+					logger.With("error", err).Error("Failed to shut down daemon")
+				}
+			}()
+			logger.With("socket", options.socket).Info("Listening for commands")
+			err = server.Serve(listener)
+			if errors.Is(err, http.ErrServerClosed) {
+				err = nil
+			}
+			logger.Info("Finished daemon command")
+			return err
+		},
+		SilenceUsage: true,
+	}
+	command.PersistentFlags().StringVarP(
+		&options.socket,
+		"socket",
+		"",
+		getDefaultDaemonSocket(),
+		"Unix socket to listen for commands on",
+	)
+	command.PersistentFlags().DurationVarP(
+		&options.maxCacheAge,
+		"max-cache-age",
+		"",
+		10*time.Minute,
+		"Maximum age of the charts kept in memory before they are loaded again (0 means no limit)",
+	)
+
+	return command
+}
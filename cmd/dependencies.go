@@ -0,0 +1,67 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+type DependenciesCommandOptions struct {
+	sourceOptions
+}
+
+const DependenciesCommandName = "dependencies"
+
+func NewDependenciesCommand(options *DependenciesCommandOptions) *cobra.Command {
+	command := &cobra.Command{
+		Use:   DependenciesCommandName,
+		Short: "Reports the charts HelmRelease objects deploy as Renovate package dependencies",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, logger := getContextAndLogger(cmd)
+			start := time.Now()
+			logger.Info("Starting dependencies command")
+
+			err := func() error {
+				input, err := getYAMLInputReader(args)
+				if err != nil {
+					return err
+				}
+				defer func() {
+					if err := input.Close(); err != nil {
+						logger.
+							With("error", err).
+							Error("Failed to close input")
+					}
+				}()
+
+				expander, credentials, err := options.getExpander(ctx, logger)
+				if err != nil {
+					return err
+				}
+
+				expandOptions, err := options.getExpandOptions(credentials)
+				if err != nil {
+					return err
+				}
+
+				dependencies, err := expander.ListDependencies(input, expandOptions)
+				if err != nil {
+					return err
+				}
+
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(dependencies)
+			}()
+			logger.With("duration", time.Since(start)).Info("Finished dependencies command")
+			return err
+		},
+		SilenceUsage: true,
+	}
+	addSourceFlags(command.PersistentFlags(), &options.sourceOptions)
+	return command
+}
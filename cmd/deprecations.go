@@ -0,0 +1,153 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sageailabs/fouskoti/pkg/repository"
+)
+
+type DeprecationsCommandOptions struct {
+	expansionOptions
+	outputFormat string
+}
+
+const DeprecationsCommandName = "deprecations"
+
+func writeAPIDeprecationsTable(
+	output io.Writer,
+	deprecations []repository.APIDeprecation,
+) error {
+	writer := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+	_, err := fmt.Fprintln(
+		writer,
+		"RELEASE\tCHART\tTEMPLATE\tRESOURCE\tAPI VERSION\tSTATUS\tREPLACEMENT",
+	)
+	if err != nil {
+		return fmt.Errorf("unable to write output: %w", err)
+	}
+	for _, deprecation := range deprecations {
+		status := "deprecated in " + deprecation.DeprecatedIn
+		if deprecation.Removed {
+			status = "removed in " + deprecation.RemovedIn
+		}
+		resource := deprecation.Kind + "/" + deprecation.Name
+		if deprecation.Namespace != "" {
+			resource = deprecation.Namespace + "/" + resource
+		}
+		_, err = fmt.Fprintf(
+			writer,
+			"%s/%s\t%s-%s\t%s\t%s\t%s\t%s\t%s\n",
+			deprecation.ReleaseNamespace,
+			deprecation.ReleaseName,
+			deprecation.Chart,
+			deprecation.ChartVersion,
+			deprecation.Template,
+			resource,
+			deprecation.APIVersion,
+			status,
+			deprecation.Replacement,
+		)
+		if err != nil {
+			return fmt.Errorf("unable to write output: %w", err)
+		}
+	}
+	return writer.Flush()
+}
+
+func NewDeprecationsCommand(options *DeprecationsCommandOptions) *cobra.Command {
+	command := &cobra.Command{
+		Use:   DeprecationsCommandName,
+		Short: "Reports rendered resources using Kubernetes APIs deprecated or removed in the target version",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, logger := getContextAndLogger(cmd)
+			start := time.Now()
+			logger.Info("Starting deprecations command")
+
+			err := func() error {
+				switch options.outputFormat {
+				case "table", "json":
+				default:
+					return fmt.Errorf(
+						"invalid --output value %s (valid values are table or json)",
+						options.outputFormat,
+					)
+				}
+
+				input, err := getExpansionInputReader(args)
+				if err != nil {
+					return err
+				}
+				defer func() {
+					if err := input.Close(); err != nil {
+						logger.
+							With("error", err).
+							Error("Failed to close input")
+					}
+				}()
+
+				expander, credentials, err := options.getExpander(ctx, logger)
+				if err != nil {
+					return err
+				}
+
+				expandOptions, err := options.getExpandOptions(credentials)
+				if err != nil {
+					return err
+				}
+
+				deprecations, err := expander.FindAPIDeprecations(input, expandOptions)
+				if err != nil {
+					return err
+				}
+
+				if options.outputFormat == "json" {
+					encoder := json.NewEncoder(os.Stdout)
+					encoder.SetIndent("", "  ")
+					err = encoder.Encode(deprecations)
+				} else {
+					err = writeAPIDeprecationsTable(os.Stdout, deprecations)
+				}
+				if err != nil {
+					return err
+				}
+
+				removed := 0
+				for _, deprecation := range deprecations {
+					if deprecation.Removed {
+						removed++
+					}
+				}
+				if removed > 0 {
+					return fmt.Errorf(
+						"%d resources use APIs removed in Kubernetes %s",
+						removed,
+						options.kubeVersion,
+					)
+				}
+				return nil
+			}()
+			logger.With("duration", time.Since(start)).Info("Finished deprecations command")
+			return err
+		},
+		SilenceUsage: true,
+	}
+	addExpansionFlags(command.PersistentFlags(), &options.expansionOptions)
+	command.PersistentFlags().StringVarP(
+		&options.outputFormat,
+		"output",
+		"o",
+		"table",
+		"Output format (table or json)",
+	)
+
+	return command
+}
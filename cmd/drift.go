@@ -0,0 +1,137 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"helm.sh/helm/v4/pkg/engine"
+
+	"github.com/sageailabs/fouskoti/pkg/repository"
+)
+
+type DriftCommandOptions struct {
+	expansionOptions
+	storageFixturesFileName string
+}
+
+const DriftCommandName = "drift"
+
+// getHelmStorageProvider returns the provider of the Helm storage Secrets,
+// read from the fixtures file if there is one, or from the cluster.
+func getHelmStorageProvider(
+	fixturesFileName string,
+	kubeContext string,
+) (engine.ClientProvider, error) {
+	if fixturesFileName == "" {
+		return repository.NewClusterLookupProvider(kubeContext)
+	}
+	file, err := os.Open(fixturesFileName)
+	if err != nil {
+		return nil, repository.NewClassifiedError(repository.ErrorClassInput, fmt.Errorf(
+			"unable to open Helm storage fixtures file %s: %w",
+			fixturesFileName,
+			err,
+		))
+	}
+	defer func() { _ = file.Close() }()
+	provider, err := repository.NewFixtureLookupProvider(file)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"unable to read Helm storage fixtures from %s: %w",
+			fixturesFileName,
+			err,
+		)
+	}
+	return provider, nil
+}
+
+func NewDriftCommand(options *DriftCommandOptions) *cobra.Command {
+	command := &cobra.Command{
+		Use:   DriftCommandName,
+		Short: "Reports the HelmRelease objects rendering differently from the Helm releases deployed in the cluster",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, logger := getContextAndLogger(cmd)
+			start := time.Now()
+			logger.Info("Starting drift command")
+
+			err := func() error {
+				input, err := getExpansionInputReader(args)
+				if err != nil {
+					return err
+				}
+				defer func() {
+					if err := input.Close(); err != nil {
+						logger.
+							With("error", err).
+							Error("Failed to close input")
+					}
+				}()
+
+				expander, credentials, err := options.getExpander(ctx, logger)
+				if err != nil {
+					return err
+				}
+
+				expandOptions, err := options.getExpandOptions(credentials)
+				if err != nil {
+					return err
+				}
+
+				provider, err := getHelmStorageProvider(
+					options.storageFixturesFileName,
+					options.kubeContext,
+				)
+				if err != nil {
+					return err
+				}
+
+				drifts, err := expander.CheckDrift(input, provider, expandOptions)
+				if err != nil {
+					return err
+				}
+				for _, drift := range drifts {
+					text := drift.Diff
+					if drift.Missing {
+						text = fmt.Sprintf(
+							"Helm release %s/%s of HelmRelease %s/%s is not deployed\n",
+							drift.StorageNamespace,
+							drift.ReleaseName,
+							drift.Namespace,
+							drift.Name,
+						)
+					}
+					if _, err := fmt.Fprint(os.Stdout, text); err != nil {
+						return fmt.Errorf("unable to write output: %w", err)
+					}
+				}
+				if len(drifts) > 0 {
+					return repository.NewClassifiedError(
+						repository.ErrorClassValidation,
+						fmt.Errorf(
+							"%d Helm releases do not match the rendered resources",
+							len(drifts),
+						),
+					)
+				}
+				return nil
+			}()
+			logger.With("duration", time.Since(start)).Info("Finished drift command")
+			return err
+		},
+		SilenceUsage: true,
+	}
+	addExpansionFlags(command.PersistentFlags(), &options.expansionOptions)
+	command.PersistentFlags().StringVarP(
+		&options.storageFixturesFileName,
+		"storage-fixtures",
+		"",
+		"",
+		"Name of a YAML file with the Helm storage Secrets to compare with instead of the ones in the cluster of --kube-context",
+	)
+
+	return command
+}
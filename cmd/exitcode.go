@@ -0,0 +1,60 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/sageailabs/fouskoti/pkg/repository"
+)
+
+// Exit codes for the failure classes, so that CI pipelines can branch on them,
+// e.g. retry on fetch failures but not on template errors.
+const (
+	ExitCodeFailure    = 1
+	ExitCodeInput      = 2
+	ExitCodeAuth       = 3
+	ExitCodeFetch      = 4
+	ExitCodeRender     = 5
+	ExitCodeValidation = 6
+)
+
+// reportedError is the failure of a command which has been reported to the
+// user already, e.g., by the daemon which ran the command.
+type reportedError struct {
+	exitCode int
+}
+
+func (err *reportedError) Error() string {
+	return fmt.Sprintf("command failed with exit code %d", err.exitCode)
+}
+
+// IsReported tells whether the error returned by a command has been reported
+// to the user already.
+func IsReported(err error) bool {
+	var reported *reportedError
+	return errors.As(err, &reported)
+}
+
+// GetExitCode returns the exit code for the error returned by a command.
+func GetExitCode(err error) int {
+	var reported *reportedError
+	if errors.As(err, &reported) {
+		return reported.exitCode
+	}
+	switch repository.GetErrorClass(err) {
+	case repository.ErrorClassInput:
+		return ExitCodeInput
+	case repository.ErrorClassAuth:
+		return ExitCodeAuth
+	case repository.ErrorClassFetch:
+		return ExitCodeFetch
+	case repository.ErrorClassRender:
+		return ExitCodeRender
+	case repository.ErrorClassValidation:
+		return ExitCodeValidation
+	default:
+		return ExitCodeFailure
+	}
+}
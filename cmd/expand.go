@@ -3,25 +3,70 @@
 package cmd
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"os"
+	"slices"
 	"time"
 
-	"github.com/fluxcd/pkg/git"
-	"github.com/fluxcd/pkg/git/gogit"
 	"github.com/spf13/cobra"
-	"helm.sh/helm/v4/pkg/chart/common"
 
 	"github.com/sageailabs/fouskoti/pkg/repository"
 )
 
 type ExpandCommandOptions struct {
-	credentialsFileName     string
-	kubeVersion             string
-	apiVersions             []string
-	maxExpansions           int
-	workingCopySubstitution string
-	chartCacheDir           string
+	expansionOptions
+	writeLockFileName    string
+	fromLockFileName     string
+	inventoryFileName    string
+	fluxInventoryFile    string
+	fluxInventoryFormat  string
+	sortOrder            string
+	orderByDependsOn     bool
+	selector             string
+	releaseNamespace     string
+	releaseName          string
+	skipReleases         []string
+	skipReleasesFileName string
+	valuesOverlays       []string
+	setValues            []string
+	valuesFromEnv        bool
+	gitSources           []string
+	urlSources           []string
+	allowLocalSources    bool
+	defaultSourceURL     string
+	chartMetadata        bool
+	annotateExpansion    bool
+	ownerLabels          bool
+	failOnEmpty          bool
+	createNamespaces     bool
+	commonLabels         []string
+	commonAnnotations    []string
+	dropHelmTests        bool
+	crds                 string
+	postRenderPatches    []string
+	resolveImagePolicies bool
+	imagePoliciesFile    string
+	imageRewrites        []string
+	vendorDir            string
+	stream               bool
+	preserveInput        bool
+	normalize            bool
+	reproducible         bool
+	outputFileName       string
+	outputDir            string
+	splitBy              string
+	kustomization        bool
+	kustomizationLabels  map[string]string
+	policyDir            string
+	policyReportFileName string
+	kyvernoPolicies      []string
+	kyvernoInputPolicies bool
+	daemonSocket         string
+	noDaemon             bool
+	// chartCache is the chart cache of the daemon running the command.
+	chartCache *repository.ChartCache
 }
 
 const ExpandCommandName = "expand"
@@ -36,131 +81,668 @@ func NewExpandCommand(options *ExpandCommandOptions) *cobra.Command {
 			logger.Info("Starting expand command")
 
 			err := func() error {
-				kubeVersion, err := common.ParseKubeVersion(options.kubeVersion)
+				hasRemoteInputs := len(options.gitSources) > 0 || len(options.urlSources) > 0
+				// Commands run by the daemon never delegate, nor do the ones
+				// reading values from environment variables, which the daemon
+				// doesn't get.
+				if !options.noDaemon && !options.valuesFromEnv && options.chartCache == nil {
+					delegated, err := delegateToDaemon(
+						ctx,
+						logger,
+						options.daemonSocket,
+						os.Args[1:],
+						options.credentialsFileNames,
+						slices.Contains(args, "-") || (len(args) == 0 && !hasRemoteInputs),
+					)
+					if delegated || err != nil {
+						return err
+					}
+				}
+
+				expander, credentials, err := options.getExpander(ctx, logger)
+				if err != nil {
+					return err
+				}
+
+				var inputs []io.Reader
+				if hasRemoteInputs && options.offline {
+					return fmt.Errorf("--offline cannot be used with --from-git or --from-url")
+				}
+				if options.outputFileName != "" && options.outputDir != "" {
+					return fmt.Errorf("--output-file cannot be used with --output-dir")
+				}
+				// Standard input is only read by default without remote inputs.
+				if len(args) > 0 || !hasRemoteInputs {
+					fileInput, err := getExpansionInputReader(args)
+					if err != nil {
+						return err
+					}
+					defer func() {
+						if err := fileInput.Close(); err != nil {
+							logger.
+								With("error", err).
+								Error("Failed to close input")
+						}
+					}()
+					inputs = append(inputs, fileInput)
+				}
+				if hasRemoteInputs {
+					remoteInput, err := readRemoteInputs(
+						expander,
+						credentials,
+						options.gitSources,
+						options.urlSources,
+					)
+					if err != nil {
+						return err
+					}
+					inputs = append(inputs, remoteInput)
+				}
+				var input io.Reader = io.MultiReader(inputs...)
+
+				expandOptions, err := options.getExpandOptions(credentials)
+				if err != nil {
+					return err
+				}
+
+				fluxInventoryFormat, err := repository.ParseFluxInventoryFormat(
+					options.fluxInventoryFormat,
+				)
+				if err != nil {
+					return fmt.Errorf(
+						"invalid --flux-inventory-format value %s: %w",
+						options.fluxInventoryFormat,
+						err,
+					)
+				}
+
+				sortOrder, err := repository.ParseSortOrder(options.sortOrder)
+				if err != nil {
+					return fmt.Errorf(
+						"invalid --sort value %s: %w",
+						options.sortOrder,
+						err,
+					)
+				}
+
+				outputSplit, err := repository.ParseOutputSplit(options.splitBy)
+				if err != nil {
+					return fmt.Errorf(
+						"invalid --split-by value %s: %w",
+						options.splitBy,
+						err,
+					)
+				}
+
+				crdsMode, err := repository.ParseCRDsMode(options.crds)
+				if err != nil {
+					return fmt.Errorf("invalid --crds value %s: %w", options.crds, err)
+				}
+
+				releaseFilter, err := repository.NewReleaseFilter(
+					options.selector,
+					options.releaseNamespace,
+					options.releaseName,
+				)
 				if err != nil {
 					return fmt.Errorf(
-						"invalid --kube-version value %s: %w",
-						options.kubeVersion,
+						"invalid --selector value %s: %w",
+						options.selector,
 						err,
 					)
 				}
 
-				input, err := getYAMLInputReader(args)
+				skipList, err := readReleaseSkipList(
+					options.skipReleases,
+					options.skipReleasesFileName,
+				)
+				if err != nil {
+					return err
+				}
+
+				valuesOverrides, err := readValuesOverrides(
+					options.valuesOverlays,
+					options.setValues,
+				)
 				if err != nil {
 					return err
 				}
-				defer func() {
-					if err := input.Close(); err != nil {
-						logger.
-							With("error", err).
-							Error("Failed to close input")
+
+				commonLabels, err := parseKeyValues("common-label", options.commonLabels)
+				if err != nil {
+					return err
+				}
+				commonAnnotations, err := parseKeyValues(
+					"common-annotation",
+					options.commonAnnotations,
+				)
+				if err != nil {
+					return err
+				}
+
+				postRenderPatches, err := readPostRenderPatches(options.postRenderPatches)
+				if err != nil {
+					return err
+				}
+
+				imageRewrites, err := parseKeyValues("rewrite-image", options.imageRewrites)
+				if err != nil {
+					return err
+				}
+
+				var imagePolicies repository.ImagePolicies
+				if options.imagePoliciesFile != "" {
+					imagePolicies, err = readImagePolicies(options.imagePoliciesFile)
+					if err != nil {
+						return err
 					}
-				}()
+				}
 
-				credentials := repository.Credentials{}
+				var lock *repository.Lock
+				if options.fromLockFileName != "" {
+					lock, err = readLock(options.fromLockFileName)
+					if err != nil {
+						return err
+					}
+				}
+				var vendorManifest *repository.VendorManifest
+				if options.vendorDir != "" {
+					vendorManifest, err = repository.ReadVendorManifest(options.vendorDir)
+					if err != nil {
+						return err
+					}
+				}
+				var policyChecker *repository.PolicyChecker
+				var onReleaseExpanded func(release *repository.ExpandedRelease)
+				if options.policyDir != "" {
+					policies, err := repository.ReadPolicies(options.policyDir)
+					if err != nil {
+						return err
+					}
+					policyChecker = repository.NewPolicyChecker(policies)
+				}
+				kyvernoPolicies := []*repository.KyvernoPolicy{}
+				for _, path := range options.kyvernoPolicies {
+					policies, err := repository.ReadKyvernoPolicyFiles(path)
+					if err != nil {
+						return err
+					}
+					kyvernoPolicies = append(kyvernoPolicies, policies...)
+				}
+				if options.kyvernoInputPolicies {
+					// The input is read twice, to collect the policies first.
+					data, err := io.ReadAll(input)
+					if err != nil {
+						return fmt.Errorf("unable to read input: %w", err)
+					}
+					policies, err := repository.ReadKyvernoPolicies(bytes.NewReader(data))
+					if err != nil {
+						return err
+					}
+					kyvernoPolicies = append(kyvernoPolicies, policies...)
+					input = bytes.NewReader(data)
+				}
+				if len(kyvernoPolicies) > 0 || options.kyvernoInputPolicies {
+					if policyChecker == nil {
+						policyChecker = repository.NewPolicyChecker(nil)
+					}
+					policyChecker.WithKyvernoPolicies(kyvernoPolicies)
+				}
+				if policyChecker != nil {
+					onReleaseExpanded = policyChecker.Check
+				}
+				var lockBuffer *bytes.Buffer
+				var lockOutput io.Writer
+				if options.writeLockFileName != "" {
+					lockBuffer = &bytes.Buffer{}
+					lockOutput = lockBuffer
+				}
+
+				var inventoryBuffer *bytes.Buffer
+				var inventoryOutput io.Writer
+				if options.inventoryFileName != "" {
+					inventoryBuffer = &bytes.Buffer{}
+					inventoryOutput = inventoryBuffer
+				}
+
+				var fluxInventoryBuffer *bytes.Buffer
+				var fluxInventoryOutput io.Writer
+				if options.fluxInventoryFile != "" {
+					fluxInventoryBuffer = &bytes.Buffer{}
+					fluxInventoryOutput = fluxInventoryBuffer
+				}
 
-				if options.credentialsFileName != "" {
-					credsFile, err := os.Open(options.credentialsFileName)
+				expandOptions.Lock = lock
+				expandOptions.LockOutput = lockOutput
+				expandOptions.InventoryOutput = inventoryOutput
+				expandOptions.FluxInventoryOutput = fluxInventoryOutput
+				expandOptions.FluxInventoryFormat = fluxInventoryFormat
+				expandOptions.SortOrder = sortOrder
+				expandOptions.OrderByDependencies = options.orderByDependsOn
+				expandOptions.ReleaseFilter = releaseFilter
+				expandOptions.SkipList = skipList
+				expandOptions.AllowLocalSources = options.allowLocalSources
+				expandOptions.DefaultSourceURL = options.defaultSourceURL
+				expandOptions.ChartMetadata = options.chartMetadata
+				expandOptions.ExpansionAnnotations = options.annotateExpansion
+				expandOptions.OwnerLabels = options.ownerLabels
+				expandOptions.FailOnEmpty = options.failOnEmpty
+				expandOptions.CreateNamespaces = options.createNamespaces
+				expandOptions.CommonLabels = commonLabels
+				expandOptions.CommonAnnotations = commonAnnotations
+				expandOptions.KeepTestHooks = !options.dropHelmTests
+				expandOptions.CRDs = crdsMode
+				expandOptions.PostRenderPatches = postRenderPatches
+				expandOptions.ResolveImagePolicies = options.resolveImagePolicies ||
+					options.imagePoliciesFile != ""
+				expandOptions.ImagePolicies = imagePolicies
+				expandOptions.ImageRewrites = imageRewrites
+				expandOptions.VendorManifest = vendorManifest
+				expandOptions.ValuesOverrides = valuesOverrides
+				expandOptions.ValuesFromEnv = options.valuesFromEnv
+				expandOptions.Streaming = options.stream
+				expandOptions.PreserveInput = options.preserveInput
+				expandOptions.Normalize = options.normalize
+				expandOptions.Reproducible = options.reproducible
+				if options.reproducible {
+					expandOptions.DigestOutput = os.Stderr
+				}
+				expandOptions.OutputDir = options.outputDir
+				expandOptions.OutputSplit = outputSplit
+				expandOptions.Kustomization = options.kustomization
+				expandOptions.KustomizationLabels = options.kustomizationLabels
+				expandOptions.OnReleaseExpanded = onReleaseExpanded
+				expandOptions.ChartCache = options.chartCache
+				if options.outputFileName != "" {
+					err = writeFileAtomically(options.outputFileName, func(output io.Writer) error {
+						return expander.Expand(input, output, expandOptions)
+					})
+				} else {
+					err = expander.Expand(input, os.Stdout, expandOptions)
+				}
+				if err != nil {
+					return err
+				}
+
+				if lockBuffer != nil {
+					err = os.WriteFile(options.writeLockFileName, lockBuffer.Bytes(), 0644)
 					if err != nil {
 						return fmt.Errorf(
-							"unable to open credentials file %s: %w",
-							options.credentialsFileName,
+							"unable to write lock file %s: %w",
+							options.writeLockFileName,
 							err,
 						)
 					}
-					defer func() { _ = credsFile.Close() }()
-
-					credentials, err = repository.ReadCredentials(credsFile)
+				}
+				if inventoryBuffer != nil {
+					err = os.WriteFile(options.inventoryFileName, inventoryBuffer.Bytes(), 0644)
 					if err != nil {
 						return fmt.Errorf(
-							"unable to read credentials from %s: %w",
-							options.credentialsFileName,
+							"unable to write inventory file %s: %w",
+							options.inventoryFileName,
 							err,
 						)
 					}
 				}
-
-				gitRepoSubstitution, err := repository.ParseGitRepoSubstitution(
-					options.workingCopySubstitution,
-				)
-				if err != nil {
-					return fmt.Errorf(
-						"invalid --working-copy-subst value %s: %w",
-						options.workingCopySubstitution,
-						err,
+				if fluxInventoryBuffer != nil {
+					err = os.WriteFile(options.fluxInventoryFile, fluxInventoryBuffer.Bytes(), 0644)
+					if err != nil {
+						return fmt.Errorf(
+							"unable to write Flux inventory file %s: %w",
+							options.fluxInventoryFile,
+							err,
+						)
+					}
+				}
+				if policyChecker != nil {
+					return reportPolicyViolations(
+						policyChecker.Violations(),
+						options.policyReportFileName,
 					)
 				}
-
-				expander := repository.NewHelmReleaseExpander(
-					ctx,
-					logger,
-					func(
-						path string,
-						authOpts *git.AuthOptions,
-						clientOpts ...gogit.ClientOption,
-					) (repository.GitClientInterface, error) {
-						return gogit.NewClient(path, authOpts, clientOpts...)
-					},
-					repository.NewOciRepositoryClient,
-				)
-				return expander.ExpandHelmReleases(
-					credentials,
-					input,
-					os.Stdout,
-					kubeVersion,
-					options.apiVersions,
-					gitRepoSubstitution,
-					options.maxExpansions,
-					options.chartCacheDir,
-					true,
-				)
+				return nil
 			}()
 			logger.With("duration", time.Since(start)).Info("Finished expand command")
 			return err
 		},
 		SilenceUsage: true,
 	}
+	addExpansionFlags(command.PersistentFlags(), &options.expansionOptions)
 	command.PersistentFlags().StringVarP(
-		&options.credentialsFileName,
-		"credentials-file",
+		&options.writeLockFileName,
+		"write-lock",
 		"",
 		"",
-		"Name of the repository credentials file",
+		"Name of the file to record resolved chart versions and digests into",
 	)
 	command.PersistentFlags().StringVarP(
-		&options.kubeVersion,
-		"kube-version",
+		&options.fromLockFileName,
+		"from-lock",
+		"",
 		"",
-		"1.28",
-		"Kubernetes version used for Capabilities.KubeVersion in charts",
+		"Name of the lock file to render the exact recorded chart versions from",
 	)
-	command.PersistentFlags().StringSliceVarP(
-		&options.apiVersions,
-		"api-versions",
+	command.PersistentFlags().StringVarP(
+		&options.inventoryFileName,
+		"inventory",
+		"",
+		"",
+		"Name of the file to list the rendered resources and their digests in",
+	)
+	command.PersistentFlags().StringVarP(
+		&options.fluxInventoryFile,
+		"flux-inventory",
+		"",
+		"",
+		"Name of the file to list the objects of the output in as Flux inventories do",
+	)
+	command.PersistentFlags().StringVarP(
+		&options.fluxInventoryFormat,
+		"flux-inventory-format",
+		"",
+		string(repository.FluxInventoryFormatJSON),
+		"Format of the Flux inventory (json or configmap)",
+	)
+	command.PersistentFlags().StringVarP(
+		&options.sortOrder,
+		"sort",
+		"",
+		string(repository.DefaultSortOrder),
+		"Order of the generated resources (kind, install-order, or none)",
+	)
+	command.PersistentFlags().BoolVarP(
+		&options.orderByDependsOn,
+		"order-by-depends-on",
+		"",
+		false,
+		"Emit resources of HelmRelease objects after the resources of the HelmRelease objects they depend on",
+	)
+	command.PersistentFlags().StringVarP(
+		&options.selector,
+		"selector",
+		"l",
+		"",
+		"Label selector for HelmRelease objects to expand",
+	)
+	command.PersistentFlags().StringVarP(
+		&options.releaseNamespace,
+		"namespace",
+		"",
+		"",
+		"Namespace of HelmRelease objects to expand",
+	)
+	command.PersistentFlags().StringVarP(
+		&options.releaseName,
+		"release",
+		"",
+		"",
+		"Name of HelmRelease objects to expand",
+	)
+	command.PersistentFlags().StringArrayVarP(
+		&options.skipReleases,
+		"skip-release",
+		"",
+		[]string{},
+		"HelmRelease to pass through without expanding, as <namespace>/<name> (repeatable)",
+	)
+	command.PersistentFlags().StringVarP(
+		&options.skipReleasesFileName,
+		"skip-releases-file",
+		"",
+		"",
+		"Name of the file listing HelmReleases to skip, one <namespace>/<name> per line",
+	)
+	command.PersistentFlags().StringArrayVarP(
+		&options.valuesOverlays,
+		"values-overlay",
+		"",
+		[]string{},
+		"Values file to apply on top of the values of HelmReleases in the form <namespace>/<name>:<file> or <selector>:<file> (repeatable)",
+	)
+	command.PersistentFlags().StringArrayVarP(
+		&options.setValues,
+		"set",
+		"",
+		[]string{},
+		"Values to apply on top of the values of HelmReleases in the form <namespace>/<name>:<key>=<value> or <selector>:<key>=<value> (repeatable)",
+	)
+	command.PersistentFlags().BoolVarP(
+		&options.valuesFromEnv,
+		"values-from-env",
+		"",
+		false,
+		"Merge the YAML values of the environment variables named by the fouskoti.sage.com/values-env annotations of HelmReleases into their values",
+	)
+	command.PersistentFlags().StringArrayVarP(
+		&options.gitSources,
+		"from-git",
+		"",
+		[]string{},
+		"Read input YAML files from a Git repository in the form <repo-url>@<ref>[:<path>] (repeatable)",
+	)
+	command.PersistentFlags().StringArrayVarP(
+		&options.urlSources,
+		"from-url",
+		"",
+		[]string{},
+		"Read input YAML from an HTTP(S) URL (repeatable)",
+	)
+	command.PersistentFlags().BoolVarP(
+		&options.allowLocalSources,
+		"allow-local-sources",
+		"",
+		false,
+		"Allow GitRepository objects with file:// URLs, cloned from the local file system",
+	)
+	command.PersistentFlags().StringVarP(
+		&options.defaultSourceURL,
+		"default-source-url",
+		"",
+		"",
+		"URL of the chart sources, of the kind referenced, used for HelmRelease objects with chart sources missing from the input",
+	)
+	command.PersistentFlags().BoolVarP(
+		&options.chartMetadata,
+		"chart-metadata",
+		"",
+		false,
+		"Add a ConfigMap recording the chart name, version, app version, source URL, and digest of each expanded HelmRelease to the output",
+	)
+	command.PersistentFlags().BoolVarP(
+		&options.createNamespaces,
+		"create-namespaces",
+		"",
+		false,
+		"Add a Namespace object for the target namespace of each expanded HelmRelease to the output unless there is one already",
+	)
+	command.PersistentFlags().StringArrayVarP(
+		&options.commonLabels,
+		"common-label",
+		"",
+		[]string{},
+		"Label to set on every object generated from HelmRelease objects, as <key>=<value> (repeatable)",
+	)
+	command.PersistentFlags().StringArrayVarP(
+		&options.commonAnnotations,
+		"common-annotation",
+		"",
+		[]string{},
+		"Annotation to set on every object generated from HelmRelease objects, as <key>=<value> (repeatable)",
+	)
+	command.PersistentFlags().BoolVarP(
+		&options.dropHelmTests,
+		"drop-helm-tests",
+		"",
+		true,
+		"Leave out the resources annotated as Helm tests with helm.sh/hook: test from the output",
+	)
+	command.PersistentFlags().StringVarP(
+		&options.crds,
+		"crds",
+		"",
+		string(repository.CRDsModeNone),
+		"Add the custom resource definitions of the charts to the output according to the spec.install (install) or spec.upgrade (upgrade) CRD policies of each HelmRelease, or leave them out (none)",
+	)
+	command.PersistentFlags().StringArrayVarP(
+		&options.postRenderPatches,
+		"post-render-patch",
+		"",
+		[]string{},
+		"File with a list of strategic merge or JSON6902 patches with optional targets to apply to all of the resources rendered from HelmRelease objects (repeatable)",
+	)
+	command.PersistentFlags().BoolVarP(
+		&options.resolveImagePolicies,
+		"resolve-image-policies",
+		"",
+		false,
+		"Set the values of HelmRelease objects marked with Flux image policy markers to the latest images in the statuses of the ImagePolicy objects in the input",
+	)
+	command.PersistentFlags().StringVarP(
+		&options.imagePoliciesFile,
+		"image-policies-file",
+		"",
+		"",
+		"Name of a YAML file mapping image policies, as <namespace>:<name>, to their latest images, implying --resolve-image-policies",
+	)
+	command.PersistentFlags().StringArrayVarP(
+		&options.imageRewrites,
+		"rewrite-image",
 		"",
 		[]string{},
-		"Kubernetes api versions used for Capabilities.APIVersions in charts",
+		"Prefix of the images of containers in resources rendered from HelmRelease objects to replace, as <old-prefix>=<new-prefix> (repeatable)",
+	)
+	command.PersistentFlags().BoolVarP(
+		&options.annotateExpansion,
+		"annotate-expansion",
+		"",
+		false,
+		"Annotate generated resources with the expansion round and the HelmRelease which generated them",
+	)
+	command.PersistentFlags().BoolVarP(
+		&options.ownerLabels,
+		"owner-labels",
+		"",
+		false,
+		"Label resources rendered from HelmRelease objects with the helm.toolkit.fluxcd.io/name and /namespace labels helm-controller sets",
 	)
-	command.PersistentFlags().IntVarP(
-		&options.maxExpansions,
-		"max-expansions",
+	command.PersistentFlags().BoolVarP(
+		&options.failOnEmpty,
+		"fail-on-empty",
 		"",
-		1,
-		"Maximum number of expansions to perform recursively",
+		false,
+		"Fail on HelmRelease objects rendering no resources instead of warning about them",
 	)
 	command.PersistentFlags().StringVarP(
-		&options.workingCopySubstitution,
-		"working-copy-subst",
+		&options.vendorDir,
+		"vendor-dir",
+		"",
 		"",
+		"Directory with charts saved by the vendor command to render from in preference to the repositories",
+	)
+	command.PersistentFlags().BoolVarP(
+		&options.stream,
+		"stream",
+		"",
+		false,
+		"Write resources rendered from each HelmRelease as soon as they are available to bound memory use",
+	)
+	command.PersistentFlags().BoolVarP(
+		&options.preserveInput,
+		"preserve-input",
+		"",
+		false,
+		"Write the input documents passed through unchanged as they are in the input, with their comments and formatting",
+	)
+	command.PersistentFlags().BoolVarP(
+		&options.normalize,
+		"normalize",
+		"",
+		false,
+		"Write the output without comments, with sorted keys, and with lists of named containers, volumes, ports, and image pull secrets sorted by name, for semantically equal renders to be byte-identical",
+	)
+	command.PersistentFlags().BoolVarP(
+		&options.reproducible,
+		"reproducible",
 		"",
-		"Substitute working copy path for git repository in the form <repo-url>#[<branch>#]<path>",
+		false,
+		"Render charts with fixed time, seeded random values, and placeholder keys and certificates for identical inputs to render byte-identical output, and print its SHA-256 digest to the standard error",
 	)
 	command.PersistentFlags().StringVarP(
-		&options.chartCacheDir,
-		"chart-cache-dir",
+		&options.outputFileName,
+		"output-file",
 		"",
 		"",
-		"Directory to cache Helm charts",
+		"Name of the file to write the output into instead of the standard output, replaced only once the expansion succeeds",
+	)
+	command.PersistentFlags().StringVarP(
+		&options.outputDir,
+		"output-dir",
+		"",
+		"",
+		"Directory to write the output into, each object into a file of its own, instead of the standard output",
+	)
+	command.PersistentFlags().StringVarP(
+		&options.splitBy,
+		"split-by",
+		"",
+		string(repository.OutputSplitObject),
+		"Group the objects written into the output directory into files by object, namespace, release, or kind",
+	)
+	command.PersistentFlags().BoolVarP(
+		&options.kustomization,
+		"kustomization",
+		"",
+		false,
+		"Write a kustomization.yaml listing the files into the output directory",
+	)
+	command.PersistentFlags().StringToStringVarP(
+		&options.kustomizationLabels,
+		"kustomization-label",
+		"",
+		map[string]string{},
+		"Label for the generated kustomization.yaml to add to all of the objects, as <key>=<value> (repeatable)",
+	)
+	command.PersistentFlags().StringVarP(
+		&options.policyDir,
+		"policy-dir",
+		"",
+		"",
+		"Directory with policies to check the resources rendered from HelmRelease objects against",
+	)
+	command.PersistentFlags().StringVarP(
+		&options.policyReportFileName,
+		"policy-report",
+		"",
+		"",
+		"Name of the file to write policy violations to as JSON instead of failing on them",
+	)
+	command.PersistentFlags().StringArrayVarP(
+		&options.kyvernoPolicies,
+		"kyverno-policy",
+		"",
+		[]string{},
+		"Kyverno policy file or directory to check the resources rendered from HelmRelease objects against (repeatable)",
+	)
+	command.PersistentFlags().BoolVarP(
+		&options.kyvernoInputPolicies,
+		"kyverno-input-policies",
+		"",
+		false,
+		"Also check the rendered resources against the Kyverno policies in the input",
+	)
+	command.PersistentFlags().StringVarP(
+		&options.daemonSocket,
+		"daemon-socket",
+		"",
+		getDefaultDaemonSocket(),
+		"Unix socket of the daemon to delegate the command to when it is running",
+	)
+	command.PersistentFlags().BoolVarP(
+		&options.noDaemon,
+		"no-daemon",
+		"",
+		false,
+		"Run the command in this process even when a daemon is running",
 	)
 
 	return command
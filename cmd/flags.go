@@ -0,0 +1,389 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/spf13/pflag"
+	"helm.sh/helm/v4/pkg/chart/common"
+
+	"github.com/sageailabs/fouskoti/pkg/repository"
+)
+
+// Options of the commands fetching charts and chart sources.
+type fetchOptions struct {
+	credentialsFileNames        []string
+	maxConcurrentFetches        int
+	hostRequestsPerSecond       float64
+	maxConcurrentFetchesPerHost int
+	indexMaxAge                 time.Duration
+	gitFullClone                bool
+	gitAllBranches              bool
+	noTagCache                  bool
+	verifyCache                 bool
+}
+
+// Options substituting local working copies and charts for chart sources.
+type substitutionOptions struct {
+	workingCopySubstitution string
+	repoSubstitutions       []string
+	chartSubstitutions      []string
+	substitutionFileName    string
+}
+
+// Options of the commands loading the charts of HelmRelease objects from
+// their chart sources.
+type sourceOptions struct {
+	fetchOptions
+	substitutionOptions
+	chartCacheDir       string
+	offline             bool
+	strict              bool
+	allowMissingSources bool
+}
+
+// Options of the commands rendering the charts of HelmRelease objects.
+type expansionOptions struct {
+	sourceOptions
+	kubeVersion            string
+	apiVersions            []string
+	apiVersionsFileName    string
+	apiResourcesFileName   string
+	maxExpansions          int
+	noCrossNamespaceRefs   bool
+	lookupFixturesFileName string
+	lookupFromCluster      bool
+	kubeContext            string
+	failOnLookup           bool
+	legacyReleaseNames     bool
+}
+
+func addFetchFlags(flags *pflag.FlagSet, options *fetchOptions) {
+	flags.StringArrayVarP(
+		&options.credentialsFileNames,
+		"credentials-file",
+		"",
+		nil,
+		"Name of a repository credentials file or a directory of them, with later files overriding earlier ones (can be repeated)",
+	)
+	flags.IntVarP(
+		&options.maxConcurrentFetches,
+		"max-concurrent-fetches",
+		"",
+		0,
+		"Maximum number of concurrent Git clones and chart downloads (0 means no limit)",
+	)
+	flags.Float64VarP(
+		&options.hostRequestsPerSecond,
+		"max-requests-per-second-per-host",
+		"",
+		0,
+		"Maximum number of requests per second to each repository or registry host (0 means no limit)",
+	)
+	flags.IntVarP(
+		&options.maxConcurrentFetchesPerHost,
+		"max-concurrent-fetches-per-host",
+		"",
+		0,
+		"Maximum number of concurrent requests to each repository or registry host (0 means no limit)",
+	)
+	flags.DurationVarP(
+		&options.indexMaxAge,
+		"index-max-age",
+		"",
+		0,
+		"Maximum age of cached Helm repository indexes before they are revalidated (0 means never)",
+	)
+	flags.BoolVarP(
+		&options.gitFullClone,
+		"git-full-clone",
+		"",
+		false,
+		"Clone the whole history of Git repositories instead of only the checked out commit",
+	)
+	flags.BoolVarP(
+		&options.gitAllBranches,
+		"git-all-branches",
+		"",
+		false,
+		"Fetch all branches and tags of Git repositories instead of only the checked out one",
+	)
+	flags.BoolVarP(
+		&options.noTagCache,
+		"no-tag-cache",
+		"",
+		false,
+		"List the tags of charts in OCI registries for every HelmRelease instead of once per run",
+	)
+	flags.BoolVarP(
+		&options.verifyCache,
+		"verify-cache",
+		"",
+		false,
+		"Verify cached charts against the digests in their sources and download the ones published again",
+	)
+}
+
+func addSubstitutionFlags(flags *pflag.FlagSet, options *substitutionOptions) {
+	flags.StringVarP(
+		&options.workingCopySubstitution,
+		"working-copy-subst",
+		"",
+		"",
+		"Substitute working copy path for git repository in the form <repo-url>#[<branch>#]<path> or <namespace>/<name>#[<branch>#]<path>",
+	)
+	flags.StringArrayVarP(
+		&options.repoSubstitutions,
+		"repo-substitution",
+		"",
+		nil,
+		"Substitute working copy path for git repository in the form <repo-url>#[<branch>#]<path> or <namespace>/<name>#[<branch>#]<path> (can be repeated)",
+	)
+	flags.StringArrayVarP(
+		&options.chartSubstitutions,
+		"chart-substitution",
+		"",
+		nil,
+		"Substitute local chart directory or archive for charts in a Helm or OCI repository in the form <repo-url>#[<chart>#]<path> (can be repeated)",
+	)
+	flags.StringVarP(
+		&options.substitutionFileName,
+		"substitution-file",
+		"",
+		"",
+		"Name of the YAML file with substitutions for git repositories and charts",
+	)
+}
+
+func addChartCacheDirFlag(flags *pflag.FlagSet, chartCacheDir *string) {
+	flags.StringVarP(
+		chartCacheDir,
+		"chart-cache-dir",
+		"",
+		getDefaultChartCacheDir(),
+		"Directory to cache Helm charts (set to an empty value to disable the cache)",
+	)
+}
+
+func addSourceFlags(flags *pflag.FlagSet, options *sourceOptions) {
+	addFetchFlags(flags, &options.fetchOptions)
+	addSubstitutionFlags(flags, &options.substitutionOptions)
+	addChartCacheDirFlag(flags, &options.chartCacheDir)
+	flags.BoolVarP(
+		&options.offline,
+		"offline",
+		"",
+		false,
+		"Forbid network access and fail on charts, indexes, and repositories missing from the chart cache",
+	)
+	flags.BoolVarP(
+		&options.strict,
+		"strict",
+		"",
+		false,
+		"Fail on unknown fields in HelmRelease and Flux source objects in the input",
+	)
+	flags.BoolVarP(
+		&options.allowMissingSources,
+		"allow-missing-sources",
+		"",
+		false,
+		"Pass HelmRelease objects with chart sources missing from the input through without expansion instead of failing",
+	)
+}
+
+func addExpansionFlags(flags *pflag.FlagSet, options *expansionOptions) {
+	addSourceFlags(flags, &options.sourceOptions)
+	flags.StringVarP(
+		&options.kubeVersion,
+		"kube-version",
+		"",
+		"1.28",
+		"Kubernetes version used for Capabilities.KubeVersion in charts",
+	)
+	flags.StringSliceVarP(
+		&options.apiVersions,
+		"api-versions",
+		"",
+		[]string{},
+		"Kubernetes api versions used for Capabilities.APIVersions in charts",
+	)
+	flags.StringVarP(
+		&options.apiVersionsFileName,
+		"api-versions-file",
+		"",
+		"",
+		"Name of the file listing Kubernetes api versions for Capabilities.APIVersions, one per line (e.g. the output of kubectl api-versions)",
+	)
+	flags.StringVarP(
+		&options.apiResourcesFileName,
+		"api-resources-file",
+		"",
+		"",
+		"Name of the file with the output of kubectl api-resources or an OpenAPI document of a cluster to replace the default Capabilities.APIVersions with",
+	)
+	flags.IntVarP(
+		&options.maxExpansions,
+		"max-expansions",
+		"",
+		1,
+		"Maximum number of expansions to perform recursively",
+	)
+	flags.BoolVarP(
+		&options.noCrossNamespaceRefs,
+		"no-cross-namespace-refs",
+		"",
+		false,
+		"Fail on HelmRelease objects referencing chart sources in other namespaces, like Flux run with the same option",
+	)
+	flags.StringVarP(
+		&options.lookupFixturesFileName,
+		"lookup-fixtures",
+		"",
+		"",
+		"Name of the YAML file with objects for the lookup function in chart templates to return",
+	)
+	flags.BoolVarP(
+		&options.lookupFromCluster,
+		"lookup-from-cluster",
+		"",
+		false,
+		"Serve the lookup function in chart templates from the cluster of the kubeconfig context, reading objects only",
+	)
+	flags.StringVarP(
+		&options.kubeContext,
+		"kube-context",
+		"",
+		"",
+		"Name of the kubeconfig context for --lookup-from-cluster (the current context by default)",
+	)
+	flags.BoolVarP(
+		&options.failOnLookup,
+		"fail-on-lookup",
+		"",
+		false,
+		"Fail on charts using the lookup function in their templates",
+	)
+	flags.BoolVarP(
+		&options.legacyReleaseNames,
+		"legacy-release-names",
+		"",
+		false,
+		"Name Helm releases <target namespace>-<name> with the target namespace defaulting to the namespace of the HelmRelease, as earlier versions did, instead of the way helm-controller does",
+	)
+}
+
+// Reads the credentials file and returns the credentials together with an
+// expander fetching charts and chart sources as the options say.
+func (options *fetchOptions) getExpander(
+	ctx context.Context,
+	logger *slog.Logger,
+) (*repository.HelmReleaseExpander, repository.Credentials, error) {
+	credentials, mirrors, err := readCredentials(ctx, options.credentialsFileNames)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	expander := newHelmReleaseExpander(ctx, logger).
+		WithMaxConcurrentFetches(options.maxConcurrentFetches).
+		WithHostRateLimit(
+			options.hostRequestsPerSecond,
+			options.maxConcurrentFetchesPerHost,
+		).
+		WithIndexMaxAge(options.indexMaxAge).
+		WithGitCloneOptions(repository.GitCloneOptions{
+			FullClone:   options.gitFullClone,
+			AllBranches: options.gitAllBranches,
+		}).
+		WithTagCaching(!options.noTagCache).
+		WithCacheVerification(options.verifyCache).
+		WithMirrors(mirrors)
+	return expander, credentials, nil
+}
+
+func (options *substitutionOptions) read() (*repository.Substitutions, error) {
+	return readSubstitutions(
+		options.workingCopySubstitution,
+		options.repoSubstitutions,
+		options.chartSubstitutions,
+		options.substitutionFileName,
+	)
+}
+
+// Returns the expansion options for loading charts from their sources.
+func (options *sourceOptions) getExpandOptions(
+	credentials repository.Credentials,
+) (repository.ExpandOptions, error) {
+	substitutions, err := options.read()
+	if err != nil {
+		return repository.ExpandOptions{}, err
+	}
+	return repository.ExpandOptions{
+		Credentials:              credentials,
+		GitRepoSubstitutions:     substitutions.GitRepositories,
+		ChartSubstitutions:       substitutions.Charts,
+		ChartCacheDir:            options.chartCacheDir,
+		EnableChartInMemoryCache: true,
+		Offline:                  options.offline,
+		Strict:                   options.strict,
+		AllowMissingSources:      options.allowMissingSources,
+	}, nil
+}
+
+// Returns the expansion options for rendering charts, for the commands to
+// add their own settings to.
+func (options *expansionOptions) getExpandOptions(
+	credentials repository.Credentials,
+) (repository.ExpandOptions, error) {
+	kubeVersion, err := common.ParseKubeVersion(options.kubeVersion)
+	if err != nil {
+		return repository.ExpandOptions{}, repository.NewClassifiedError(
+			repository.ErrorClassInput,
+			fmt.Errorf(
+				"invalid --kube-version value %s: %w",
+				options.kubeVersion,
+				err,
+			),
+		)
+	}
+
+	apiVersions, err := readAPIVersions(
+		options.apiVersions,
+		options.apiVersionsFileName,
+	)
+	if err != nil {
+		return repository.ExpandOptions{}, err
+	}
+
+	apiResources, err := readAPIResources(options.apiResourcesFileName)
+	if err != nil {
+		return repository.ExpandOptions{}, err
+	}
+
+	lookupProvider, err := getLookupProvider(
+		options.lookupFixturesFileName,
+		options.lookupFromCluster,
+		options.kubeContext,
+		options.failOnLookup,
+	)
+	if err != nil {
+		return repository.ExpandOptions{}, err
+	}
+
+	expandOptions, err := options.sourceOptions.getExpandOptions(credentials)
+	if err != nil {
+		return repository.ExpandOptions{}, err
+	}
+	expandOptions.KubeVersion = kubeVersion
+	expandOptions.APIVersions = apiVersions
+	expandOptions.APIResources = apiResources
+	expandOptions.MaxExpansions = options.maxExpansions
+	expandOptions.NoCrossNamespaceRefs = options.noCrossNamespaceRefs
+	expandOptions.LookupProvider = lookupProvider
+	expandOptions.LegacyReleaseNames = options.legacyReleaseNames
+	return expandOptions, nil
+}
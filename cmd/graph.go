@@ -0,0 +1,99 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+type GraphCommandOptions struct {
+	fetchOptions
+	substitutionOptions
+	chartCacheDir string
+	outputFormat  string
+}
+
+const GraphCommandName = "graph"
+
+func NewGraphCommand(options *GraphCommandOptions) *cobra.Command {
+	command := &cobra.Command{
+		Use:   GraphCommandName,
+		Short: "Exports the graph of HelmRelease objects, their sources, charts, and chart dependencies",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, logger := getContextAndLogger(cmd)
+			start := time.Now()
+			logger.Info("Starting graph command")
+
+			err := func() error {
+				switch options.outputFormat {
+				case "dot", "json":
+				default:
+					return fmt.Errorf(
+						"invalid --output value %s (valid values are dot or json)",
+						options.outputFormat,
+					)
+				}
+
+				input, err := getYAMLInputReader(args)
+				if err != nil {
+					return err
+				}
+				defer func() {
+					if err := input.Close(); err != nil {
+						logger.
+							With("error", err).
+							Error("Failed to close input")
+					}
+				}()
+
+				expander, credentials, err := options.getExpander(ctx, logger)
+				if err != nil {
+					return err
+				}
+
+				substitutions, err := options.read()
+				if err != nil {
+					return err
+				}
+
+				graph, err := expander.BuildGraph(
+					credentials,
+					input,
+					substitutions.GitRepositories,
+					substitutions.Charts,
+					options.chartCacheDir,
+				)
+				if err != nil {
+					return err
+				}
+
+				if options.outputFormat == "json" {
+					encoder := json.NewEncoder(os.Stdout)
+					encoder.SetIndent("", "  ")
+					return encoder.Encode(graph)
+				}
+				return graph.WriteDOT(os.Stdout)
+			}()
+			logger.With("duration", time.Since(start)).Info("Finished graph command")
+			return err
+		},
+		SilenceUsage: true,
+	}
+	addFetchFlags(command.PersistentFlags(), &options.fetchOptions)
+	addSubstitutionFlags(command.PersistentFlags(), &options.substitutionOptions)
+	addChartCacheDirFlag(command.PersistentFlags(), &options.chartCacheDir)
+	command.PersistentFlags().StringVarP(
+		&options.outputFormat,
+		"output",
+		"o",
+		"dot",
+		"Output format (dot for Graphviz or json)",
+	)
+
+	return command
+}
@@ -0,0 +1,137 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sageailabs/fouskoti/pkg/repository"
+)
+
+type ImagesCommandOptions struct {
+	expansionOptions
+	outputFormat string
+	imagePaths   []string
+}
+
+const ImagesCommandName = "images"
+
+func writeImages(
+	output io.Writer,
+	images []repository.ReleaseImage,
+	format string,
+) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(images)
+	case "list":
+		for _, image := range images {
+			if _, err := fmt.Fprintln(output, image.Image); err != nil {
+				return fmt.Errorf("unable to write output: %w", err)
+			}
+		}
+		return nil
+	}
+
+	writer := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+	lines := []string{"IMAGE\tRELEASES"}
+	for _, image := range images {
+		lines = append(lines, image.Image+"\t"+strings.Join(image.Releases, ", "))
+	}
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(writer, line); err != nil {
+			return fmt.Errorf("unable to write output: %w", err)
+		}
+	}
+	return writer.Flush()
+}
+
+func NewImagesCommand(options *ImagesCommandOptions) *cobra.Command {
+	command := &cobra.Command{
+		Use:   ImagesCommandName,
+		Short: "Lists the container images referenced by the resources rendered from HelmRelease objects",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, logger := getContextAndLogger(cmd)
+			start := time.Now()
+			logger.Info("Starting images command")
+
+			err := func() error {
+				switch options.outputFormat {
+				case "table", "json", "list":
+				default:
+					return fmt.Errorf(
+						"invalid --output value %s (valid values are table, json, or list)",
+						options.outputFormat,
+					)
+				}
+
+				paths := []*repository.ImagePath{}
+				for _, value := range options.imagePaths {
+					path, err := repository.ParseImagePath(value)
+					if err != nil {
+						return fmt.Errorf("invalid --image-path value %s: %w", value, err)
+					}
+					paths = append(paths, path)
+				}
+
+				input, err := getExpansionInputReader(args)
+				if err != nil {
+					return err
+				}
+				defer func() {
+					if err := input.Close(); err != nil {
+						logger.
+							With("error", err).
+							Error("Failed to close input")
+					}
+				}()
+
+				expander, credentials, err := options.getExpander(ctx, logger)
+				if err != nil {
+					return err
+				}
+
+				expandOptions, err := options.getExpandOptions(credentials)
+				if err != nil {
+					return err
+				}
+
+				images, err := expander.ListImages(input, expandOptions, paths)
+				if err != nil {
+					return err
+				}
+				return writeImages(os.Stdout, images, options.outputFormat)
+			}()
+			logger.With("duration", time.Since(start)).Info("Finished images command")
+			return err
+		},
+		SilenceUsage: true,
+	}
+	addExpansionFlags(command.PersistentFlags(), &options.expansionOptions)
+	command.PersistentFlags().StringVarP(
+		&options.outputFormat,
+		"output",
+		"o",
+		"table",
+		"Output format (table, json, or list)",
+	)
+	command.PersistentFlags().StringArrayVarP(
+		&options.imagePaths,
+		"image-path",
+		"",
+		nil,
+		"Path to images in resources outside of container specs, as [<kind>=]<path>, e.g. Prometheus=.spec.image (can be repeated)",
+	)
+
+	return command
+}
@@ -0,0 +1,114 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sageailabs/fouskoti/pkg/repository"
+)
+
+type ListCommandOptions struct {
+	outputFormat string
+}
+
+const ListCommandName = "list"
+
+func writeListedReleasesTable(
+	output io.Writer,
+	releases []repository.ListedRelease,
+) error {
+	writer := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+	_, err := fmt.Fprintln(writer, "NAMESPACE\tNAME\tSOURCE\tCHART\tCONSTRAINT\tFOUND")
+	if err != nil {
+		return fmt.Errorf("unable to write output: %w", err)
+	}
+	for _, release := range releases {
+		found := "no"
+		if release.SourceFound {
+			found = "yes"
+		}
+		_, err = fmt.Fprintf(
+			writer,
+			"%s\t%s\t%s/%s/%s\t%s\t%s\t%s\n",
+			release.Namespace,
+			release.Name,
+			release.SourceKind,
+			release.SourceNamespace,
+			release.SourceName,
+			release.Chart,
+			release.VersionSpec,
+			found,
+		)
+		if err != nil {
+			return fmt.Errorf("unable to write output: %w", err)
+		}
+	}
+	return writer.Flush()
+}
+
+func NewListCommand(options *ListCommandOptions) *cobra.Command {
+	command := &cobra.Command{
+		Use:   ListCommandName,
+		Short: "Lists HelmRelease objects with their chart sources without fetching anything",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, logger := getContextAndLogger(cmd)
+			start := time.Now()
+			logger.Info("Starting list command")
+
+			err := func() error {
+				switch options.outputFormat {
+				case "table", "json":
+				default:
+					return fmt.Errorf(
+						"invalid --output value %s (valid values are table or json)",
+						options.outputFormat,
+					)
+				}
+
+				input, err := getYAMLInputReader(args)
+				if err != nil {
+					return err
+				}
+				defer func() {
+					if err := input.Close(); err != nil {
+						logger.
+							With("error", err).
+							Error("Failed to close input")
+					}
+				}()
+
+				releases, err := repository.ListHelmReleases(input)
+				if err != nil {
+					return err
+				}
+
+				if options.outputFormat == "json" {
+					encoder := json.NewEncoder(os.Stdout)
+					encoder.SetIndent("", "  ")
+					return encoder.Encode(releases)
+				}
+				return writeListedReleasesTable(os.Stdout, releases)
+			}()
+			logger.With("duration", time.Since(start)).Info("Finished list command")
+			return err
+		},
+		SilenceUsage: true,
+	}
+	command.PersistentFlags().StringVarP(
+		&options.outputFormat,
+		"output",
+		"o",
+		"table",
+		"Output format (table or json)",
+	)
+
+	return command
+}
@@ -0,0 +1,125 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sageailabs/fouskoti/pkg/repository"
+)
+
+type OutdatedCommandOptions struct {
+	sourceOptions
+	outputFormat string
+}
+
+const OutdatedCommandName = "outdated"
+
+func writeOutdatedReleasesTable(
+	output io.Writer,
+	releases []repository.OutdatedRelease,
+) error {
+	writer := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+	_, err := fmt.Fprintln(writer, "NAMESPACE\tNAME\tURL\tCHART\tCONSTRAINT\tCURRENT\tLATEST\tOUTDATED")
+	if err != nil {
+		return fmt.Errorf("unable to write output: %w", err)
+	}
+	for _, release := range releases {
+		outdated := "no"
+		if release.Outdated {
+			outdated = "yes"
+		}
+		_, err = fmt.Fprintf(
+			writer,
+			"%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			release.Namespace,
+			release.Name,
+			release.URL,
+			release.Chart,
+			release.VersionSpec,
+			release.Version,
+			release.LatestVersion,
+			outdated,
+		)
+		if err != nil {
+			return fmt.Errorf("unable to write output: %w", err)
+		}
+	}
+	return writer.Flush()
+}
+
+func NewOutdatedCommand(options *OutdatedCommandOptions) *cobra.Command {
+	command := &cobra.Command{
+		Use:   OutdatedCommandName,
+		Short: "Reports the chart versions HelmRelease objects resolve to together with the latest ones",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, logger := getContextAndLogger(cmd)
+			start := time.Now()
+			logger.Info("Starting outdated command")
+
+			err := func() error {
+				switch options.outputFormat {
+				case "table", "json":
+				default:
+					return fmt.Errorf(
+						"invalid --output value %s (valid values are table or json)",
+						options.outputFormat,
+					)
+				}
+
+				input, err := getYAMLInputReader(args)
+				if err != nil {
+					return err
+				}
+				defer func() {
+					if err := input.Close(); err != nil {
+						logger.
+							With("error", err).
+							Error("Failed to close input")
+					}
+				}()
+
+				expander, credentials, err := options.getExpander(ctx, logger)
+				if err != nil {
+					return err
+				}
+
+				expandOptions, err := options.getExpandOptions(credentials)
+				if err != nil {
+					return err
+				}
+
+				releases, err := expander.FindOutdatedReleases(input, expandOptions)
+				if err != nil {
+					return err
+				}
+
+				if options.outputFormat == "json" {
+					encoder := json.NewEncoder(os.Stdout)
+					encoder.SetIndent("", "  ")
+					return encoder.Encode(releases)
+				}
+				return writeOutdatedReleasesTable(os.Stdout, releases)
+			}()
+			logger.With("duration", time.Since(start)).Info("Finished outdated command")
+			return err
+		},
+		SilenceUsage: true,
+	}
+	addSourceFlags(command.PersistentFlags(), &options.sourceOptions)
+	command.PersistentFlags().StringVarP(
+		&options.outputFormat,
+		"output",
+		"o",
+		"table",
+		"Output format (table or json)",
+	)
+	return command
+}
@@ -0,0 +1,122 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sageailabs/fouskoti/pkg/repository"
+)
+
+type ResolveCommandOptions struct {
+	sourceOptions
+	outputFormat string
+}
+
+const ResolveCommandName = "resolve"
+
+func writeResolvedReleasesTable(
+	output io.Writer,
+	releases []repository.ResolvedRelease,
+) error {
+	writer := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+	_, err := fmt.Fprintln(writer, "NAMESPACE\tNAME\tSOURCE\tURL\tCHART\tCONSTRAINT\tVERSION")
+	if err != nil {
+		return fmt.Errorf("unable to write output: %w", err)
+	}
+	for _, release := range releases {
+		_, err = fmt.Fprintf(
+			writer,
+			"%s\t%s\t%s/%s/%s\t%s\t%s\t%s\t%s\n",
+			release.Namespace,
+			release.Name,
+			release.SourceKind,
+			release.SourceNamespace,
+			release.SourceName,
+			release.URL,
+			release.Chart,
+			release.VersionSpec,
+			release.Version,
+		)
+		if err != nil {
+			return fmt.Errorf("unable to write output: %w", err)
+		}
+	}
+	return writer.Flush()
+}
+
+func NewResolveCommand(options *ResolveCommandOptions) *cobra.Command {
+	command := &cobra.Command{
+		Use:   ResolveCommandName,
+		Short: "Reports chart versions HelmRelease objects resolve to without rendering them",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, logger := getContextAndLogger(cmd)
+			start := time.Now()
+			logger.Info("Starting resolve command")
+
+			err := func() error {
+				switch options.outputFormat {
+				case "table", "json":
+				default:
+					return fmt.Errorf(
+						"invalid --output value %s (valid values are table or json)",
+						options.outputFormat,
+					)
+				}
+
+				input, err := getYAMLInputReader(args)
+				if err != nil {
+					return err
+				}
+				defer func() {
+					if err := input.Close(); err != nil {
+						logger.
+							With("error", err).
+							Error("Failed to close input")
+					}
+				}()
+
+				expander, credentials, err := options.getExpander(ctx, logger)
+				if err != nil {
+					return err
+				}
+
+				expandOptions, err := options.getExpandOptions(credentials)
+				if err != nil {
+					return err
+				}
+
+				releases, err := expander.ResolveHelmReleases(input, expandOptions)
+				if err != nil {
+					return err
+				}
+
+				if options.outputFormat == "json" {
+					encoder := json.NewEncoder(os.Stdout)
+					encoder.SetIndent("", "  ")
+					return encoder.Encode(releases)
+				}
+				return writeResolvedReleasesTable(os.Stdout, releases)
+			}()
+			logger.With("duration", time.Since(start)).Info("Finished resolve command")
+			return err
+		},
+		SilenceUsage: true,
+	}
+	addSourceFlags(command.PersistentFlags(), &options.sourceOptions)
+	command.PersistentFlags().StringVarP(
+		&options.outputFormat,
+		"output",
+		"o",
+		"table",
+		"Output format (table or json)",
+	)
+	return command
+}
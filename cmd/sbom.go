@@ -0,0 +1,80 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sageailabs/fouskoti/pkg/repository"
+)
+
+type SBOMCommandOptions struct {
+	expansionOptions
+	format string
+}
+
+const SBOMCommandName = "sbom"
+
+func NewSBOMCommand(options *SBOMCommandOptions) *cobra.Command {
+	command := &cobra.Command{
+		Use:   SBOMCommandName,
+		Short: "Prints a software bill of materials listing the charts and container images of the expanded HelmRelease objects",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, logger := getContextAndLogger(cmd)
+			start := time.Now()
+			logger.Info("Starting sbom command")
+
+			err := func() error {
+				format, err := repository.ParseSBOMFormat(options.format)
+				if err != nil {
+					return fmt.Errorf("invalid --format value %s: %w", options.format, err)
+				}
+
+				input, err := getExpansionInputReader(args)
+				if err != nil {
+					return err
+				}
+				defer func() {
+					if err := input.Close(); err != nil {
+						logger.
+							With("error", err).
+							Error("Failed to close input")
+					}
+				}()
+
+				expander, credentials, err := options.getExpander(ctx, logger)
+				if err != nil {
+					return err
+				}
+
+				expandOptions, err := options.getExpandOptions(credentials)
+				if err != nil {
+					return err
+				}
+
+				sbom, err := expander.BuildSBOM(input, expandOptions)
+				if err != nil {
+					return err
+				}
+				return sbom.Write(os.Stdout, format, time.Now())
+			}()
+			logger.With("duration", time.Since(start)).Info("Finished sbom command")
+			return err
+		},
+		SilenceUsage: true,
+	}
+	addExpansionFlags(command.PersistentFlags(), &options.expansionOptions)
+	command.PersistentFlags().StringVarP(
+		&options.format,
+		"format",
+		"",
+		string(repository.SBOMFormatSPDX),
+		"SBOM format (spdx or cyclonedx)",
+	)
+
+	return command
+}
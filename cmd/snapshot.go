@@ -0,0 +1,114 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sageailabs/fouskoti/pkg/repository"
+)
+
+type SnapshotCommandOptions struct {
+	expansionOptions
+	updateDir string
+	verifyDir string
+}
+
+const SnapshotCommandName = "snapshot"
+
+func NewSnapshotCommand(options *SnapshotCommandOptions) *cobra.Command {
+	command := &cobra.Command{
+		Use:   SnapshotCommandName,
+		Short: "Writes or verifies snapshots of the resources rendered from each HelmRelease",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, logger := getContextAndLogger(cmd)
+			start := time.Now()
+			logger.Info("Starting snapshot command")
+
+			err := func() error {
+				if (options.updateDir == "") == (options.verifyDir == "") {
+					return repository.NewClassifiedError(
+						repository.ErrorClassInput,
+						fmt.Errorf("exactly one of --update and --verify is required"),
+					)
+				}
+
+				input, err := getExpansionInputReader(args)
+				if err != nil {
+					return err
+				}
+				defer func() {
+					if err := input.Close(); err != nil {
+						logger.
+							With("error", err).
+							Error("Failed to close input")
+					}
+				}()
+
+				expander, credentials, err := options.getExpander(ctx, logger)
+				if err != nil {
+					return err
+				}
+
+				expandOptions, err := options.getExpandOptions(credentials)
+				if err != nil {
+					return err
+				}
+
+				if options.updateDir != "" {
+					return expander.UpdateSnapshots(input, options.updateDir, expandOptions)
+				}
+
+				mismatches, err := expander.VerifySnapshots(
+					input,
+					options.verifyDir,
+					expandOptions,
+				)
+				if err != nil {
+					return err
+				}
+				for _, mismatch := range mismatches {
+					if _, err := fmt.Fprint(os.Stdout, mismatch.Diff); err != nil {
+						return fmt.Errorf("unable to write output: %w", err)
+					}
+				}
+				if len(mismatches) > 0 {
+					return repository.NewClassifiedError(
+						repository.ErrorClassValidation,
+						fmt.Errorf(
+							"%d snapshots in %s do not match the rendered resources (run with --update=%s to accept the changes)",
+							len(mismatches),
+							options.verifyDir,
+							options.verifyDir,
+						),
+					)
+				}
+				return nil
+			}()
+			logger.With("duration", time.Since(start)).Info("Finished snapshot command")
+			return err
+		},
+		SilenceUsage: true,
+	}
+	addExpansionFlags(command.PersistentFlags(), &options.expansionOptions)
+	command.PersistentFlags().StringVarP(
+		&options.updateDir,
+		"update",
+		"",
+		"",
+		"Directory to write the snapshots of the resources rendered from each HelmRelease to",
+	)
+	command.PersistentFlags().StringVarP(
+		&options.verifyDir,
+		"verify",
+		"",
+		"",
+		"Directory with the snapshots to verify the resources rendered from each HelmRelease against",
+	)
+
+	return command
+}
@@ -0,0 +1,156 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sageailabs/fouskoti/pkg/repository"
+)
+
+type SummaryCommandOptions struct {
+	expansionOptions
+	outputFormat string
+}
+
+const SummaryCommandName = "summary"
+
+func writeSummaryTable(output io.Writer, summary *repository.ExpansionSummary) error {
+	writer := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+	lines := []string{fmt.Sprintf("Total resources: %d", summary.TotalResources)}
+
+	lines = append(lines, "", "KIND\tCOUNT")
+	for _, kind := range slices.Sorted(maps.Keys(summary.Kinds)) {
+		lines = append(lines, fmt.Sprintf("%s\t%d", kind, summary.Kinds[kind]))
+	}
+
+	lines = append(lines, "", "NAMESPACE\tCOUNT")
+	for _, namespace := range slices.Sorted(maps.Keys(summary.Namespaces)) {
+		name := namespace
+		if name == "" {
+			name = "<none>"
+		}
+		lines = append(lines, fmt.Sprintf("%s\t%d", name, summary.Namespaces[namespace]))
+	}
+
+	lines = append(lines, "", "RELEASE\tCHART\tRESOURCES")
+	for _, release := range summary.Releases {
+		lines = append(lines, fmt.Sprintf(
+			"%s/%s\t%s-%s\t%d",
+			release.Namespace,
+			release.Name,
+			release.Chart,
+			release.ChartVersion,
+			release.Resources,
+		))
+	}
+
+	lines = append(lines, "", "CHART\tVERSIONS")
+	for _, chart := range summary.Charts {
+		lines = append(lines, chart.Chart+"\t"+strings.Join(chart.Versions, ", "))
+	}
+
+	lines = append(lines, "", "RESOURCE\tREQUESTS\tLIMITS")
+	names := slices.Concat(
+		slices.Collect(maps.Keys(summary.Requests)),
+		slices.Collect(maps.Keys(summary.Limits)),
+	)
+	slices.Sort(names)
+	for _, name := range slices.Compact(names) {
+		request, limit := summary.Requests[name], summary.Limits[name]
+		if request == "" {
+			request = "-"
+		}
+		if limit == "" {
+			limit = "-"
+		}
+		lines = append(lines, name+"\t"+request+"\t"+limit)
+	}
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(writer, line); err != nil {
+			return fmt.Errorf("unable to write output: %w", err)
+		}
+	}
+	return writer.Flush()
+}
+
+func NewSummaryCommand(options *SummaryCommandOptions) *cobra.Command {
+	command := &cobra.Command{
+		Use:   SummaryCommandName,
+		Short: "Prints an inventory of the resources produced by expanding HelmRelease objects",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, logger := getContextAndLogger(cmd)
+			start := time.Now()
+			logger.Info("Starting summary command")
+
+			err := func() error {
+				switch options.outputFormat {
+				case "table", "json":
+				default:
+					return fmt.Errorf(
+						"invalid --output value %s (valid values are table or json)",
+						options.outputFormat,
+					)
+				}
+
+				input, err := getExpansionInputReader(args)
+				if err != nil {
+					return err
+				}
+				defer func() {
+					if err := input.Close(); err != nil {
+						logger.
+							With("error", err).
+							Error("Failed to close input")
+					}
+				}()
+
+				expander, credentials, err := options.getExpander(ctx, logger)
+				if err != nil {
+					return err
+				}
+
+				expandOptions, err := options.getExpandOptions(credentials)
+				if err != nil {
+					return err
+				}
+
+				summary, err := expander.Summarize(input, expandOptions)
+				if err != nil {
+					return err
+				}
+
+				if options.outputFormat == "json" {
+					encoder := json.NewEncoder(os.Stdout)
+					encoder.SetIndent("", "  ")
+					return encoder.Encode(summary)
+				}
+				return writeSummaryTable(os.Stdout, summary)
+			}()
+			logger.With("duration", time.Since(start)).Info("Finished summary command")
+			return err
+		},
+		SilenceUsage: true,
+	}
+	addExpansionFlags(command.PersistentFlags(), &options.expansionOptions)
+	command.PersistentFlags().StringVarP(
+		&options.outputFormat,
+		"output",
+		"o",
+		"table",
+		"Output format (table or json)",
+	)
+
+	return command
+}
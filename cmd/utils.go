@@ -3,10 +3,24 @@
 package cmd
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/fluxcd/pkg/git"
+	"github.com/fluxcd/pkg/git/gogit"
+	"helm.sh/helm/v4/pkg/engine"
+
+	"github.com/sageailabs/fouskoti/pkg/repository"
 )
 
 func appendDocSeparator(inputs []io.Reader) []io.Reader {
@@ -39,10 +53,24 @@ func (reader *yamlInputReader) Read(p []byte) (int, error) {
 // Opens all input files and combines them in a single YAML
 // stream for reading.  Uses stdin if no args are provided.
 func getYAMLInputReader(args []string) (io.ReadCloser, error) {
+	return openYAMLInputs(args, false)
+}
+
+// Opens all input files and combines them in a single YAML stream for
+// expansion, with the files marked for the expansion errors to point to them.
+func getExpansionInputReader(args []string) (io.ReadCloser, error) {
+	return openYAMLInputs(args, true)
+}
+
+func openYAMLInputs(args []string, markFiles bool) (io.ReadCloser, error) {
 	var closers []io.Closer
 	var inputs []io.Reader
 	for _, arg := range args {
 		if arg == "-" {
+			if markFiles {
+				inputs = appendDocSeparator(inputs)
+				inputs = append(inputs, strings.NewReader(repository.MarkInputFile("<stdin>")))
+			}
 			inputs = append(inputs, os.Stdin)
 		} else {
 			inputs = appendDocSeparator(inputs)
@@ -50,10 +78,16 @@ func getYAMLInputReader(args []string) (io.ReadCloser, error) {
 			if err != nil {
 				// Failures to close input files are not interesting.
 				_ = (&yamlInputReader{closers: closers}).Close()
-				return nil, fmt.Errorf("unable to open input file %s: %w", arg, err)
+				return nil, repository.NewClassifiedError(
+					repository.ErrorClassInput,
+					fmt.Errorf("unable to open input file %s: %w", arg, err),
+				)
 			}
 			closers = append(closers, file)
 			inputs = appendDocSeparator(inputs)
+			if markFiles {
+				inputs = append(inputs, strings.NewReader(repository.MarkInputFile(arg)))
+			}
 			inputs = append(inputs, file)
 		}
 	}
@@ -65,3 +99,443 @@ func getYAMLInputReader(args []string) (io.ReadCloser, error) {
 		reader:  io.MultiReader(inputs...),
 	}, nil
 }
+
+// Masks the credentials read by the commands in the log and in the errors.
+var credentialRedactor = &repository.Redactor{}
+
+// RedactError masks credential material in the message of the error returned
+// by a command.
+func RedactError(err error) error {
+	return credentialRedactor.RedactError(err)
+}
+
+// Reads repository credentials and mirrors from the named file.  Returns
+// empty credentials and no mirrors if no file name is provided.
+func readCredentials(
+	ctx context.Context,
+	fileNames []string,
+) (repository.Credentials, repository.Mirrors, error) {
+	credentials, mirrors, err := repository.ReadCredentialsFiles(ctx, fileNames)
+	if err != nil {
+		return nil, nil, repository.NewClassifiedError(repository.ErrorClassAuth, err)
+	}
+	credentialRedactor.AddCredentials(credentials)
+	return credentials, mirrors, nil
+}
+
+// Combines the substitutions from the --working-copy-subst,
+// --repo-substitution, and --chart-substitution values and from the optional
+// substitution file.
+func readSubstitutions(
+	workingCopySubstitution string,
+	repoSubstitutions []string,
+	chartSubstitutions []string,
+	fileName string,
+) (*repository.Substitutions, error) {
+	result := &repository.Substitutions{}
+	if workingCopySubstitution != "" {
+		substitution, err := repository.ParseGitRepoSubstitution(workingCopySubstitution)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"invalid --working-copy-subst value %s: %w",
+				workingCopySubstitution,
+				err,
+			)
+		}
+		result.GitRepositories = append(result.GitRepositories, substitution)
+	}
+	for _, value := range repoSubstitutions {
+		substitution, err := repository.ParseGitRepoSubstitution(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --repo-substitution value %s: %w", value, err)
+		}
+		if substitution != nil {
+			result.GitRepositories = append(result.GitRepositories, substitution)
+		}
+	}
+	for _, value := range chartSubstitutions {
+		substitution, err := repository.ParseChartSubstitution(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --chart-substitution value %s: %w", value, err)
+		}
+		result.Charts = append(result.Charts, substitution)
+	}
+	if fileName == "" {
+		return result, nil
+	}
+
+	substFile, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"unable to open substitution file %s: %w",
+			fileName,
+			err,
+		)
+	}
+	defer func() { _ = substFile.Close() }()
+
+	substitutions, err := repository.ReadSubstitutions(substFile)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"unable to read substitutions from %s: %w",
+			fileName,
+			err,
+		)
+	}
+	result.GitRepositories = append(result.GitRepositories, substitutions.GitRepositories...)
+	result.Charts = append(result.Charts, substitutions.Charts...)
+	return result, nil
+}
+
+func newHelmReleaseExpander(
+	ctx context.Context,
+	logger *slog.Logger,
+) *repository.HelmReleaseExpander {
+	return repository.NewHelmReleaseExpander(
+		ctx,
+		logger,
+		func(
+			path string,
+			authOpts *git.AuthOptions,
+			clientOpts ...gogit.ClientOption,
+		) (repository.GitClientInterface, error) {
+			return gogit.NewClient(path, authOpts, clientOpts...)
+		},
+		repository.NewOciRepositoryClient,
+	)
+}
+
+func readLock(fileName string) (*repository.Lock, error) {
+	lockFile, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open lock file %s: %w", fileName, err)
+	}
+	defer func() { _ = lockFile.Close() }()
+
+	lock, err := repository.ReadLock(lockFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read lock from %s: %w", fileName, err)
+	}
+	return lock, nil
+}
+
+func readImagePolicies(fileName string) (repository.ImagePolicies, error) {
+	policiesFile, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open image policies file %s: %w", fileName, err)
+	}
+	defer func() { _ = policiesFile.Close() }()
+
+	policies, err := repository.ReadImagePolicies(policiesFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read image policies from %s: %w", fileName, err)
+	}
+	return policies, nil
+}
+
+func readPostRenderPatches(fileNames []string) ([]*repository.PostRenderPatch, error) {
+	var result []*repository.PostRenderPatch
+	for _, fileName := range fileNames {
+		patchFile, err := os.Open(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open post-render patch file %s: %w", fileName, err)
+		}
+		patches, err := repository.ReadPostRenderPatches(patchFile)
+		_ = patchFile.Close()
+		if err != nil {
+			return nil, fmt.Errorf(
+				"unable to read post-render patches from %s: %w",
+				fileName,
+				err,
+			)
+		}
+		result = append(result, patches...)
+	}
+	return result, nil
+}
+
+// Combines the --api-versions values with the API versions listed in the
+// optional API versions file.
+func readAPIVersions(apiVersions []string, fileName string) ([]string, error) {
+	if fileName == "" {
+		return apiVersions, nil
+	}
+
+	apiVersionsFile, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"unable to open API versions file %s: %w",
+			fileName,
+			err,
+		)
+	}
+	defer func() { _ = apiVersionsFile.Close() }()
+
+	fileAPIVersions, err := repository.ReadAPIVersions(apiVersionsFile)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"unable to read API versions from %s: %w",
+			fileName,
+			err,
+		)
+	}
+	return append(slices.Clone(apiVersions), fileAPIVersions...), nil
+}
+
+// Reads the API resources of a cluster from the named file.  Returns no
+// resources if no file name is provided.
+func readAPIResources(fileName string) ([]string, error) {
+	if fileName == "" {
+		return nil, nil
+	}
+
+	apiResourcesFile, err := os.Open(fileName)
+	if err != nil {
+		return nil, repository.NewClassifiedError(repository.ErrorClassInput, fmt.Errorf(
+			"unable to open API resources file %s: %w",
+			fileName,
+			err,
+		))
+	}
+	defer func() { _ = apiResourcesFile.Close() }()
+
+	apiResources, err := repository.ReadAPIResources(apiResourcesFile)
+	if err != nil {
+		return nil, repository.NewClassifiedError(repository.ErrorClassInput, fmt.Errorf(
+			"unable to read API resources from %s: %w",
+			fileName,
+			err,
+		))
+	}
+	return apiResources, nil
+}
+
+// Creates a skip list from the release references and the contents of the
+// optional skip list file.
+func readReleaseSkipList(
+	releases []string,
+	fileName string,
+) (repository.ReleaseSkipList, error) {
+	skipList, err := repository.NewReleaseSkipList(releases)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --skip-release value: %w", err)
+	}
+	if fileName == "" {
+		return skipList, nil
+	}
+
+	skipFile, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open skip list file %s: %w", fileName, err)
+	}
+	defer func() { _ = skipFile.Close() }()
+
+	if err := skipList.Read(skipFile); err != nil {
+		return nil, fmt.Errorf("unable to read skip list from %s: %w", fileName, err)
+	}
+	return skipList, nil
+}
+
+// Parses the --values-overlay and --set values into values overrides.  As with
+// helm, the --set values are applied after the values files.
+func readValuesOverrides(
+	valuesOverlays []string,
+	setValues []string,
+) ([]*repository.ValuesOverride, error) {
+	var result []*repository.ValuesOverride
+	for _, overlay := range valuesOverlays {
+		override, err := repository.ParseValuesOverlay(overlay)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --values-overlay value %s: %w", overlay, err)
+		}
+		result = append(result, override)
+	}
+	for _, value := range setValues {
+		override, err := repository.ParseSetOverride(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --set value %s: %w", value, err)
+		}
+		result = append(result, override)
+	}
+	return result, nil
+}
+
+// Returns the map of the <key>=<value> pairs given as the values of the flag.
+func parseKeyValues(flagName string, values []string) (map[string]string, error) {
+	result := map[string]string{}
+	for _, value := range values {
+		key, keyValue, found := strings.Cut(value, "=")
+		if !found || key == "" {
+			return nil, fmt.Errorf(
+				"invalid --%s value %s: expected <key>=<value>",
+				flagName,
+				value,
+			)
+		}
+		result[key] = keyValue
+	}
+	return result, nil
+}
+
+// Returns the provider for the lookup function in chart templates selected by
+// the --lookup-fixtures, --lookup-from-cluster, and --fail-on-lookup options,
+// or nil for the Helm default of empty lookup results.
+func getLookupProvider(
+	fixturesFileName string,
+	fromCluster bool,
+	kubeContext string,
+	failOnLookup bool,
+) (engine.ClientProvider, error) {
+	modes := 0
+	for _, selected := range []bool{fixturesFileName != "", fromCluster, failOnLookup} {
+		if selected {
+			modes++
+		}
+	}
+	if modes > 1 {
+		return nil, repository.NewClassifiedError(repository.ErrorClassInput, fmt.Errorf(
+			"only one of --lookup-fixtures, --lookup-from-cluster, and --fail-on-lookup can be used",
+		))
+	}
+	switch {
+	case fixturesFileName != "":
+		file, err := os.Open(fixturesFileName)
+		if err != nil {
+			return nil, repository.NewClassifiedError(repository.ErrorClassInput, fmt.Errorf(
+				"unable to open lookup fixtures file %s: %w",
+				fixturesFileName,
+				err,
+			))
+		}
+		defer func() { _ = file.Close() }()
+		provider, err := repository.NewFixtureLookupProvider(file)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"unable to read lookup fixtures from %s: %w",
+				fixturesFileName,
+				err,
+			)
+		}
+		return provider, nil
+	case fromCluster:
+		return repository.NewClusterLookupProvider(kubeContext)
+	case failOnLookup:
+		return repository.NewFailingLookupProvider(), nil
+	default:
+		return nil, nil
+	}
+}
+
+// Reads input YAML from Git sources and URLs and combines it in a single YAML
+// stream.
+func readRemoteInputs(
+	expander *repository.HelmReleaseExpander,
+	credentials repository.Credentials,
+	gitSources []string,
+	urls []string,
+) (io.Reader, error) {
+	result := &bytes.Buffer{}
+	for _, gitSource := range gitSources {
+		source, err := repository.ParseGitSource(gitSource)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --from-git value %s: %w", gitSource, err)
+		}
+		contents, err := expander.ReadGitSource(credentials, source)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read input from %s: %w", gitSource, err)
+		}
+		result.WriteString("\n---\n")
+		result.Write(contents)
+	}
+	for _, url := range urls {
+		contents, err := expander.ReadURLSource(credentials, url)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read input from %s: %w", url, err)
+		}
+		result.WriteString("\n---\n")
+		result.Write(contents)
+	}
+	return result, nil
+}
+
+// Returns the default chart cache directory: $FOUSKOTI_CACHE_DIR if set, or the
+// fouskoti subdirectory of the user cache directory ($XDG_CACHE_HOME or
+// ~/.cache on Linux).  Returns an empty string, disabling the file cache, if
+// the user cache directory cannot be determined.
+func getDefaultChartCacheDir() string {
+	if cacheDir := os.Getenv("FOUSKOTI_CACHE_DIR"); cacheDir != "" {
+		return cacheDir
+	}
+	userCacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(userCacheDir, "fouskoti")
+}
+
+// Writes the policy violations to the report file if one is given, otherwise
+// fails when there are any violations.
+func reportPolicyViolations(
+	violations []repository.PolicyViolation,
+	reportFileName string,
+) error {
+	if reportFileName != "" {
+		report, err := json.MarshalIndent(violations, "", "  ")
+		if err != nil {
+			return fmt.Errorf("unable to encode policy report: %w", err)
+		}
+		err = os.WriteFile(reportFileName, append(report, '\n'), 0644)
+		if err != nil {
+			return fmt.Errorf(
+				"unable to write policy report %s: %w",
+				reportFileName,
+				err,
+			)
+		}
+		return nil
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	messages := []string{}
+	for _, violation := range violations {
+		messages = append(messages, violation.String())
+	}
+	return repository.NewClassifiedError(repository.ErrorClassValidation, fmt.Errorf(
+		"rendered resources violate policies:\n  %s",
+		strings.Join(messages, "\n  "),
+	))
+}
+
+// Writes the named file with the function through a temporary file in the
+// same directory, renamed into place only once the function succeeds, so that
+// failures never leave a truncated file behind, and the previous contents, if
+// any, are kept.
+func writeFileAtomically(fileName string, write func(output io.Writer) error) error {
+	dir, base := filepath.Split(fileName)
+	file, err := os.CreateTemp(dir, ".tmp-"+base+"-")
+	if err != nil {
+		return fmt.Errorf("unable to create temporary file for %s: %w", fileName, err)
+	}
+	// Failures to remove temporary files are not interesting.
+	defer func() { _ = os.Remove(file.Name()) }()
+	output := bufio.NewWriter(file)
+	err = write(output)
+	if err == nil {
+		// Temporary files are only readable by their owners.
+		err = errors.Join(output.Flush(), file.Chmod(0644))
+		if err != nil {
+			err = fmt.Errorf("unable to write %s: %w", fileName, err)
+		}
+	}
+	if closeErr := file.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("unable to write %s: %w", fileName, closeErr)
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(file.Name(), fileName); err != nil {
+		return fmt.Errorf("unable to write %s: %w", fileName, err)
+	}
+	return nil
+}
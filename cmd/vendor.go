@@ -0,0 +1,62 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package cmd
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+type VendorCommandOptions struct {
+	fetchOptions
+	vendorDir string
+}
+
+const VendorCommandName = "vendor"
+
+func NewVendorCommand(options *VendorCommandOptions) *cobra.Command {
+	command := &cobra.Command{
+		Use:   VendorCommandName,
+		Short: "Saves charts of HelmRelease objects into a vendor directory",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, logger := getContextAndLogger(cmd)
+			start := time.Now()
+			logger.Info("Starting vendor command")
+
+			err := func() error {
+				input, err := getYAMLInputReader(args)
+				if err != nil {
+					return err
+				}
+				defer func() {
+					if err := input.Close(); err != nil {
+						logger.
+							With("error", err).
+							Error("Failed to close input")
+					}
+				}()
+
+				expander, credentials, err := options.getExpander(ctx, logger)
+				if err != nil {
+					return err
+				}
+
+				return expander.VendorCharts(credentials, input, options.vendorDir)
+			}()
+			logger.With("duration", time.Since(start)).Info("Finished vendor command")
+			return err
+		},
+		SilenceUsage: true,
+	}
+	addFetchFlags(command.PersistentFlags(), &options.fetchOptions)
+	command.PersistentFlags().StringVarP(
+		&options.vendorDir,
+		"vendor-dir",
+		"",
+		"vendor",
+		"Directory to save the charts and the vendor manifest into",
+	)
+
+	return command
+}
@@ -0,0 +1,75 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sageailabs/fouskoti/pkg/repository"
+)
+
+type WalkCommandOptions struct {
+	expansionOptions
+	source string
+}
+
+const WalkCommandName = "walk"
+
+func NewWalkCommand(options *WalkCommandOptions) *cobra.Command {
+	command := &cobra.Command{
+		Use:   WalkCommandName,
+		Short: "Expands the HelmRelease objects a Flux repository deploys, following its Flux Kustomizations",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, logger := getContextAndLogger(cmd)
+			start := time.Now()
+			logger.Info("Starting walk command")
+
+			err := func() error {
+				if options.source == "" {
+					return fmt.Errorf("--source is required")
+				}
+				if options.offline {
+					return fmt.Errorf("--offline cannot be used with --source")
+				}
+				source, err := repository.ParseGitSource(options.source)
+				if err != nil {
+					return fmt.Errorf("invalid --source value %s: %w", options.source, err)
+				}
+
+				expander, credentials, err := options.getExpander(ctx, logger)
+				if err != nil {
+					return err
+				}
+
+				contents, err := expander.Walk(credentials, source)
+				if err != nil {
+					return fmt.Errorf("unable to walk %s: %w", options.source, err)
+				}
+
+				expandOptions, err := options.getExpandOptions(credentials)
+				if err != nil {
+					return err
+				}
+				return expander.Expand(bytes.NewReader(contents), os.Stdout, expandOptions)
+			}()
+			logger.With("duration", time.Since(start)).Info("Finished walk command")
+			return err
+		},
+		SilenceUsage: true,
+	}
+	addExpansionFlags(command.PersistentFlags(), &options.expansionOptions)
+	command.PersistentFlags().StringVarP(
+		&options.source,
+		"source",
+		"",
+		"",
+		"Git repository to walk, in the form <url>@<ref>[:<path>], where the path is the entry path to build like a Flux Kustomization, the repository root by default",
+	)
+
+	return command
+}
@@ -3,6 +3,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"slices"
 
@@ -30,8 +31,13 @@ func main() {
 		os.Args = slices.Insert(os.Args, 1, cmd.ExpandCommandName)
 	}
 
-	err := rootCommand.Execute()
+	ctx, stop := cmd.NewSignalContext()
+	err := rootCommand.ExecuteContext(ctx)
+	stop()
 	if err != nil {
-		os.Exit(1)
+		if !cmd.IsReported(err) {
+			fmt.Fprintln(os.Stderr, "Error:", cmd.RedactError(err))
+		}
+		os.Exit(cmd.GetExitCode(err))
 	}
 }
@@ -0,0 +1,174 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// Prefixes of the credentials values referring to AWS Secrets Manager
+// secrets, by ARN or name, and to SSM Parameter Store parameters, by name or
+// ARN.
+const (
+	secretsManagerReferencePrefix = "aws-secretsmanager:"
+	ssmReferencePrefix            = "ssm:"
+)
+
+// awsRequestTimeout limits the time to fetch a secret or a parameter.
+const awsRequestTimeout = 30 * time.Second
+
+// awsSecretResolver resolves the references to AWS Secrets Manager secrets
+// and SSM Parameter Store parameters in credentials values with the default
+// AWS credential chain, loaded on first use, fetching each reference once.
+type awsSecretResolver struct {
+	config *aws.Config
+	values map[string]string
+}
+
+func newAWSSecretResolver() *awsSecretResolver {
+	return &awsSecretResolver{values: map[string]string{}}
+}
+
+func (resolver *awsSecretResolver) getConfig(ctx context.Context) (aws.Config, error) {
+	if resolver.config == nil {
+		awsConfig, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return aws.Config{}, fmt.Errorf("unable to load AWS configuration: %w", err)
+		}
+		if awsConfig.Credentials == nil {
+			return aws.Config{}, fmt.Errorf("no AWS credentials configured")
+		}
+		resolver.config = &awsConfig
+	}
+	return *resolver.config, nil
+}
+
+// getARNRegion returns the region of the ARN, or an empty string if the
+// identifier is not an ARN.
+func getARNRegion(identifier string) string {
+	parts := strings.SplitN(identifier, ":", 6)
+	if len(parts) < 6 || parts[0] != "arn" {
+		return ""
+	}
+	return parts[3]
+}
+
+// getRegion returns the region to look the identifier up in, the one of its
+// ARN or the configured one.
+func getRegion(awsConfig aws.Config, identifier string) (string, error) {
+	region := cmp.Or(getARNRegion(identifier), awsConfig.Region)
+	if region == "" {
+		return "", fmt.Errorf("no AWS region configured")
+	}
+	return region, nil
+}
+
+// getSecretValue fetches the string value of the Secrets Manager secret, in
+// the region of its ARN if it is one.
+func (resolver *awsSecretResolver) getSecretValue(ctx context.Context, secretID string) (string, error) {
+	awsConfig, err := resolver.getConfig(ctx)
+	if err != nil {
+		return "", err
+	}
+	region, err := getRegion(awsConfig, secretID)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, awsRequestTimeout)
+	defer cancel()
+	client := secretsmanager.NewFromConfig(awsConfig, func(options *secretsmanager.Options) {
+		options.Region = region
+	})
+	output, err := client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(secretID),
+	})
+	if err != nil {
+		return "", err
+	}
+	if output.SecretString == nil {
+		return "", fmt.Errorf("secret %s has no string value", secretID)
+	}
+	return *output.SecretString, nil
+}
+
+// getParameterValue fetches the decrypted value of the SSM Parameter Store
+// parameter, in the region of its ARN if it is one.
+func (resolver *awsSecretResolver) getParameterValue(ctx context.Context, name string) (string, error) {
+	awsConfig, err := resolver.getConfig(ctx)
+	if err != nil {
+		return "", err
+	}
+	region, err := getRegion(awsConfig, name)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, awsRequestTimeout)
+	defer cancel()
+	client := ssm.NewFromConfig(awsConfig, func(options *ssm.Options) {
+		options.Region = region
+	})
+	output, err := client.GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return "", err
+	}
+	if output.Parameter == nil || output.Parameter.Value == nil {
+		return "", fmt.Errorf("parameter %s has no value", name)
+	}
+	return *output.Parameter.Value, nil
+}
+
+// resolve returns the value the credentials value refers to, and whether it
+// is a reference at all.
+func (resolver *awsSecretResolver) resolve(ctx context.Context, value string) (string, bool, error) {
+	var get func(context.Context, string) (string, error)
+	var identifier string
+	if rest, found := strings.CutPrefix(value, secretsManagerReferencePrefix); found {
+		get, identifier = resolver.getSecretValue, rest
+	} else if rest, found := strings.CutPrefix(value, ssmReferencePrefix); found {
+		get, identifier = resolver.getParameterValue, rest
+	} else {
+		return value, false, nil
+	}
+	if identifier == "" {
+		return "", true, fmt.Errorf("missing identifier in %s", value)
+	}
+	if resolved, ok := resolver.values[value]; ok {
+		return resolved, true, nil
+	}
+	resolved, err := get(ctx, identifier)
+	if err != nil {
+		return "", true, fmt.Errorf("unable to resolve %s: %w", value, err)
+	}
+	resolver.values[value] = resolved
+	return resolved, true, nil
+}
+
+// resolveSecretReferences replaces the credentials values referring to AWS
+// secrets and parameters with their values.
+func (creds RepositoryCreds) resolveSecretReferences(
+	ctx context.Context,
+	resolver *awsSecretResolver,
+) error {
+	for key, value := range creds.Credentials {
+		resolved, isReference, err := resolver.resolve(ctx, value)
+		if err != nil {
+			return fmt.Errorf("unable to get credential %s: %w", key, err)
+		}
+		if isReference {
+			creds.Credentials[key] = resolved
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("AWS secret references in credentials", func() {
+	var g gomega.Gomega
+	var server *httptest.Server
+	var requests []string
+
+	setEnv := func(name string, value string) {
+		saved, found := os.LookupEnv(name)
+		ginkgo.DeferCleanup(func() {
+			if found {
+				os.Setenv(name, saved)
+			} else {
+				os.Unsetenv(name)
+			}
+		})
+		os.Setenv(name, value)
+	}
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		requests = nil
+		server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			target := request.Header.Get("X-Amz-Target")
+			if !strings.HasPrefix(request.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+				writer.WriteHeader(http.StatusForbidden)
+				return
+			}
+			var input map[string]any
+			if err := json.NewDecoder(request.Body).Decode(&input); err != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			requests = append(requests, target)
+			switch {
+			case target == "secretsmanager.GetSecretValue" &&
+				input["SecretId"] == "arn:aws:secretsmanager:eu-west-1:123456789012:secret:deploy-key":
+				_, _ = writer.Write([]byte(`{"SecretString": "private key"}`))
+			case target == "AmazonSSM.GetParameter" &&
+				input["Name"] == "/ci/token" && input["WithDecryption"] == true:
+				_, _ = writer.Write([]byte(`{"Parameter": {"Value": "token"}}`))
+			default:
+				writer.WriteHeader(http.StatusBadRequest)
+				_, _ = writer.Write([]byte(`{"__type": "ResourceNotFoundException", "message": "not found"}`))
+			}
+		}))
+		ginkgo.DeferCleanup(server.Close)
+		setEnv("AWS_ACCESS_KEY_ID", "AKIAEXAMPLE")
+		setEnv("AWS_SECRET_ACCESS_KEY", "secret")
+		setEnv("AWS_REGION", "us-east-1")
+		setEnv("AWS_CONFIG_FILE", os.DevNull)
+		setEnv("AWS_SHARED_CREDENTIALS_FILE", os.DevNull)
+		setEnv("AWS_ENDPOINT_URL", server.URL)
+	})
+
+	ginkgo.It("resolves secrets and parameters once", func() {
+		input := bytes.NewBufferString(strings.Join([]string{
+			"ssh://git@github.com/:",
+			"  credentials:",
+			"    identity: aws-secretsmanager:arn:aws:secretsmanager:eu-west-1:123456789012:secret:deploy-key",
+			"https://github.com/:",
+			"  credentials:",
+			"    username: git",
+			"    password: ssm:/ci/token",
+			"https://gitlab.com/:",
+			"  credentials:",
+			"    password: ssm:/ci/token",
+		}, "\n"))
+		creds, err := ReadCredentials(context.Background(), input)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(creds["ssh://git@github.com/"].Credentials).To(gomega.Equal(map[string]string{
+			"identity": "private key",
+		}))
+		g.Expect(creds["https://github.com/"].Credentials).To(gomega.Equal(map[string]string{
+			"username": "git",
+			"password": "token",
+		}))
+		g.Expect(creds["https://gitlab.com/"].Credentials["password"]).To(gomega.Equal("token"))
+		g.Expect(requests).To(gomega.ConsistOf(
+			"secretsmanager.GetSecretValue",
+			"AmazonSSM.GetParameter",
+		))
+	})
+
+	ginkgo.It("fails on missing secrets", func() {
+		input := bytes.NewBufferString(strings.Join([]string{
+			"https://github.com/:",
+			"  credentials:",
+			"    password: ssm:/ci/missing",
+		}, "\n"))
+		_, err := ReadCredentials(context.Background(), input)
+		g.Expect(err).To(gomega.MatchError(gomega.And(
+			gomega.HavePrefix(
+				"unable to resolve credentials for https://github.com/: "+
+					"unable to get credential password: unable to resolve ssm:/ci/missing: ",
+			),
+			gomega.ContainSubstring("ResourceNotFoundException"),
+		)))
+	})
+
+	ginkgo.It("gets regions of ARNs", func() {
+		g.Expect(getARNRegion("arn:aws:ssm:eu-central-1:123456789012:parameter/ci/token")).
+			To(gomega.Equal("eu-central-1"))
+		g.Expect(getARNRegion("/ci/token")).To(gomega.BeEmpty())
+	})
+})
@@ -0,0 +1,292 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// BumpPolicy limits the versions chart versions are bumped to.
+type BumpPolicy string
+
+const (
+	// BumpPolicyMajor bumps chart versions to the latest versions.
+	BumpPolicyMajor BumpPolicy = "major"
+	// BumpPolicyMinor bumps chart versions to the latest versions with the
+	// same major versions.
+	BumpPolicyMinor BumpPolicy = "minor"
+	// BumpPolicyPatch bumps chart versions to the latest versions with the
+	// same major and minor versions.
+	BumpPolicyPatch BumpPolicy = "patch"
+)
+
+// ParseBumpPolicy returns the bump policy named by the text.
+func ParseBumpPolicy(text string) (BumpPolicy, error) {
+	switch policy := BumpPolicy(text); policy {
+	case BumpPolicyMajor, BumpPolicyMinor, BumpPolicyPatch:
+		return policy, nil
+	}
+	return "", fmt.Errorf(
+		"unknown bump policy %s (valid values are major, minor, or patch)",
+		text,
+	)
+}
+
+// getConstraint returns the version constraint admitting the versions the
+// policy allows bumping the version to.
+func (policy BumpPolicy) getConstraint(version *semver.Version) string {
+	switch policy {
+	case BumpPolicyMinor:
+		return fmt.Sprintf(">=%s <%d.0.0", version, version.Major()+1)
+	case BumpPolicyPatch:
+		return fmt.Sprintf(">=%s <%d.%d.0", version, version.Major(), version.Minor()+1)
+	default:
+		return ">=" + version.String()
+	}
+}
+
+// BumpedRelease is a HelmRelease whose chart version is bumped.
+type BumpedRelease struct {
+	FileName    string `json:"fileName"`
+	Namespace   string `json:"namespace"`
+	Name        string `json:"name"`
+	Chart       string `json:"chart"`
+	Version     string `json:"version"`
+	NewVersion  string `json:"newVersion"`
+	versionNode *yaml.Node
+}
+
+// versionLocation is the spec.chart.spec.version field of a HelmRelease in
+// an input file.
+type versionLocation struct {
+	fileName string
+	node     *yaml.Node
+}
+
+// getNodeField returns the value of the field of the mapping node, or nil if
+// there is none.
+func getNodeField(node *yaml.Node, path ...string) *yaml.Node {
+	for _, field := range path {
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		var value *yaml.Node
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == field {
+				value = node.Content[i+1]
+				break
+			}
+		}
+		if value == nil {
+			return nil
+		}
+		node = value
+	}
+	return node
+}
+
+// findVersionLocations returns the spec.chart.spec.version fields of the
+// HelmRelease objects in the file content, keyed by the namespaces and names
+// of the objects.
+func findVersionLocations(
+	fileName string,
+	content []byte,
+	locations map[string]versionLocation,
+) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	for {
+		var document yaml.Node
+		err := decoder.Decode(&document)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("unable to parse %s: %w", fileName, err)
+		}
+		if len(document.Content) == 0 {
+			continue
+		}
+		root := document.Content[0]
+		kind := getNodeField(root, "kind")
+		apiVersion := getNodeField(root, "apiVersion")
+		if kind == nil || kind.Value != "HelmRelease" || apiVersion == nil ||
+			!strings.HasPrefix(apiVersion.Value, "helm.toolkit.fluxcd.io/") {
+			continue
+		}
+		version := getNodeField(root, "spec", "chart", "spec", "version")
+		if version == nil || version.Kind != yaml.ScalarNode {
+			continue
+		}
+		var namespace, name string
+		if node := getNodeField(root, "metadata", "namespace"); node != nil {
+			namespace = node.Value
+		}
+		if node := getNodeField(root, "metadata", "name"); node != nil {
+			name = node.Value
+		}
+		locations[namespace+"/"+name] = versionLocation{fileName: fileName, node: version}
+	}
+}
+
+// replaceScalar replaces the plain or quoted scalar of the node in the lines
+// of its file with the value, keeping the quotes.
+func replaceScalar(lines []string, node *yaml.Node, value string) error {
+	token := node.Value
+	switch node.Style {
+	case yaml.DoubleQuotedStyle:
+		token, value = `"`+token+`"`, `"`+value+`"`
+	case yaml.SingleQuotedStyle:
+		token, value = "'"+token+"'", "'"+value+"'"
+	case 0:
+	default:
+		return fmt.Errorf("unsupported style of version %s", node.Value)
+	}
+	if node.Line < 1 || node.Line > len(lines) {
+		return fmt.Errorf("version %s is not at line %d", node.Value, node.Line)
+	}
+	line := lines[node.Line-1]
+	start := node.Column - 1
+	if start < 0 || !strings.HasPrefix(line[min(start, len(line)):], token) {
+		return fmt.Errorf("version %s is not at line %d", node.Value, node.Line)
+	}
+	lines[node.Line-1] = line[:start] + value + line[start+len(token):]
+	return nil
+}
+
+// BumpChartVersions bumps the chart versions of the HelmRelease objects in
+// the files pinned to exact versions in Helm and OCI repositories to the
+// latest versions the policy allows, rewriting the spec.chart.spec.version
+// fields in place and leaving the rest of the files as they are.  Version
+// ranges are left alone, as they already float.  The sources of the
+// HelmRelease objects are looked up in all of the files, and the charts are
+// loaded with the options, as ResolveHelmReleases describes.  With dryRun
+// set, the files are not changed.  It returns the bumped HelmRelease objects.
+func (expander *HelmReleaseExpander) BumpChartVersions(
+	fileNames []string,
+	policy BumpPolicy,
+	dryRun bool,
+	options ExpandOptions,
+) ([]BumpedRelease, error) {
+	contents := map[string][]byte{}
+	locations := map[string]versionLocation{}
+	var input bytes.Buffer
+	for _, fileName := range fileNames {
+		content, err := os.ReadFile(fileName)
+		if err != nil {
+			return nil, NewClassifiedError(
+				ErrorClassInput,
+				fmt.Errorf("unable to read %s: %w", fileName, err),
+			)
+		}
+		if err := findVersionLocations(fileName, content, locations); err != nil {
+			return nil, NewClassifiedError(ErrorClassInput, err)
+		}
+		contents[fileName] = content
+		input.WriteString("\n---\n")
+		input.Write(content)
+	}
+
+	config, releaseRepos, cleanUp, err := expander.prepareResolution(&input, options)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanUp()
+
+	result := []BumpedRelease{}
+	for _, pair := range releaseRepos {
+		if pair.repo.GetKind() == "GitRepository" {
+			continue
+		}
+		location, ok := locations[pair.release.GetNamespace()+"/"+pair.release.GetName()]
+		if !ok {
+			continue
+		}
+		current, err := semver.NewVersion(location.node.Value)
+		if err != nil {
+			expander.logger.
+				With("namespace", pair.release.GetNamespace()).
+				With("name", pair.release.GetName()).
+				With("version", location.node.Value).
+				Debug("Leaving chart version range alone")
+			continue
+		}
+		chart, err := pair.release.GetString("spec.chart.spec.chart")
+		if err != nil {
+			return nil, fmt.Errorf(
+				"unable to get chart of Helm release %s/%s: %w",
+				pair.release.GetNamespace(),
+				pair.release.GetName(),
+				err,
+			)
+		}
+		loader, err := getLoaderForRepo(pair.repo, config)
+		if err != nil {
+			return nil, err
+		}
+		latest, err := loader.resolveChartVersion(pair.repo, "", chart, policy.getConstraint(current))
+		if err != nil {
+			return nil, fmt.Errorf(
+				"unable to find the latest chart version for Helm release %s/%s: %w",
+				pair.release.GetNamespace(),
+				pair.release.GetName(),
+				err,
+			)
+		}
+		if !isNewerVersion(current.Original(), latest) {
+			continue
+		}
+		result = append(result, BumpedRelease{
+			FileName:    location.fileName,
+			Namespace:   pair.release.GetNamespace(),
+			Name:        pair.release.GetName(),
+			Chart:       chart,
+			Version:     location.node.Value,
+			NewVersion:  latest,
+			versionNode: location.node,
+		})
+	}
+	if dryRun {
+		return result, nil
+	}
+
+	fileLines := map[string][]string{}
+	for _, bumped := range result {
+		lines, ok := fileLines[bumped.FileName]
+		if !ok {
+			lines = strings.Split(string(contents[bumped.FileName]), "\n")
+			fileLines[bumped.FileName] = lines
+		}
+		if err := replaceScalar(lines, bumped.versionNode, bumped.NewVersion); err != nil {
+			return nil, fmt.Errorf(
+				"unable to bump chart version of Helm release %s/%s in %s: %w",
+				bumped.Namespace,
+				bumped.Name,
+				bumped.FileName,
+				err,
+			)
+		}
+	}
+	for _, fileName := range fileNames {
+		lines, ok := fileLines[fileName]
+		if !ok {
+			continue
+		}
+		stat, err := os.Stat(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("unable to write %s: %w", fileName, err)
+		}
+		err = os.WriteFile(fileName, []byte(strings.Join(lines, "\n")), stat.Mode().Perm())
+		if err != nil {
+			return nil, fmt.Errorf("unable to write %s: %w", fileName, err)
+		}
+	}
+	return result, nil
+}
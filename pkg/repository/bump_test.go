@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Chart version bumps", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+	})
+
+	ginkgo.DescribeTable(
+		"gets constraints of policies",
+		func(policy BumpPolicy, expected string) {
+			g.Expect(policy.getConstraint(semver.MustParse("1.2.3"))).To(gomega.Equal(expected))
+		},
+		ginkgo.Entry("major", BumpPolicyMajor, ">=1.2.3"),
+		ginkgo.Entry("minor", BumpPolicyMinor, ">=1.2.3 <2.0.0"),
+		ginkgo.Entry("patch", BumpPolicyPatch, ">=1.2.3 <1.3.0"),
+	)
+
+	ginkgo.It("rejects unknown policies", func() {
+		_, err := ParseBumpPolicy("latest")
+		g.Expect(err).To(gomega.MatchError(
+			"unknown bump policy latest (valid values are major, minor, or patch)",
+		))
+	})
+
+	ginkgo.It("bumps pinned chart versions in place", func() {
+		repoRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(repoRoot)
+		server, port, serverDone, err := serveDirectory(repoRoot, logger, nil)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		for _, version := range []string{"0.1.0", "0.1.1", "0.2.0", "1.0.0"} {
+			err = createChartArchiveInDir("test-chart", version, map[string]string{
+				"Chart.yaml": strings.Join([]string{
+					"apiVersion: v2",
+					"name: test-chart",
+					"version: " + version,
+				}, "\n"),
+			}, repoRoot)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+		}
+		err = indexRepository(repoRoot, port)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		inputDir, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(inputDir)
+		getRelease := func(name string, version string) string {
+			return strings.Join([]string{
+				"# The release of " + name + ".",
+				"apiVersion: helm.toolkit.fluxcd.io/v2",
+				"kind: HelmRelease",
+				"metadata:",
+				"  namespace: testns",
+				"  name: " + name,
+				"spec:",
+				"    chart:",
+				"        spec:",
+				"            chart: test-chart",
+				"            version: " + version + "  # Pinned.",
+				"            sourceRef:",
+				"                kind: HelmRepository",
+				"                name: local",
+			}, "\n")
+		}
+		releases := strings.Join([]string{
+			getRelease("plain", "0.1.0"),
+			getRelease("quoted", `"0.1.1"`),
+			getRelease("range", `">=0.1.0"`),
+			getRelease("latest", "1.0.0"),
+		}, "\n---\n") + "\n"
+		releasesFile := filepath.Join(inputDir, "releases.yaml")
+		g.Expect(os.WriteFile(releasesFile, []byte(releases), 0o644)).To(gomega.Succeed())
+		sourcesFile := filepath.Join(inputDir, "sources.yaml")
+		g.Expect(os.WriteFile(sourcesFile, []byte(strings.Join([]string{
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: HelmRepository",
+			"metadata:",
+			"  namespace: testns",
+			"  name: local",
+			"spec:",
+			fmt.Sprintf("  url: http://localhost:%d", port),
+		}, "\n")), 0o644)).To(gomega.Succeed())
+
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		files := []string{releasesFile, sourcesFile}
+		bumped, err := expander.BumpChartVersions(files, BumpPolicyMinor, true, ExpandOptions{})
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(bumped).To(gomega.HaveLen(2))
+		content, err := os.ReadFile(releasesFile)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(string(content)).To(gomega.Equal(releases))
+
+		bumped, err = expander.BumpChartVersions(files, BumpPolicyMinor, false, ExpandOptions{})
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		err = stopServing(server, serverDone)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(bumped).To(gomega.HaveLen(2))
+		g.Expect(bumped[0].Name).To(gomega.Equal("plain"))
+		g.Expect(bumped[0].Version).To(gomega.Equal("0.1.0"))
+		g.Expect(bumped[0].NewVersion).To(gomega.Equal("0.2.0"))
+		g.Expect(bumped[1].Name).To(gomega.Equal("quoted"))
+		g.Expect(bumped[1].Version).To(gomega.Equal("0.1.1"))
+		g.Expect(bumped[1].NewVersion).To(gomega.Equal("0.2.0"))
+
+		content, err = os.ReadFile(releasesFile)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(string(content)).To(gomega.Equal(strings.Join([]string{
+			getRelease("plain", "0.2.0"),
+			getRelease("quoted", `"0.2.0"`),
+			getRelease("range", `">=0.1.0"`),
+			getRelease("latest", "1.0.0"),
+		}, "\n---\n") + "\n"))
+	})
+})
@@ -0,0 +1,196 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CreateBundle fetches the charts of all HelmRelease objects in the input into
+// a temporary chart cache and writes the cache contents as a gzipped tar
+// archive to the output.  Extracting the bundle with ExtractBundle populates a
+// chart cache for rendering the input without network access.
+func (expander *HelmReleaseExpander) CreateBundle(
+	credentials Credentials,
+	input io.Reader,
+	output io.Writer,
+) error {
+	cacheDir, err := os.MkdirTemp("", "chart-bundle-")
+	if err != nil {
+		return fmt.Errorf("unable to create a bundle cache dir: %w", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(cacheDir); err != nil {
+			expander.logger.
+				With("error", err).
+				With("dir", cacheDir).
+				Error("Unable to clean the bundle cache directory")
+		}
+	}()
+
+	if err := expander.WarmCache(credentials, input, cacheDir); err != nil {
+		return err
+	}
+	return writeBundle(cacheDir, output)
+}
+
+func writeBundle(cacheDir string, output io.Writer) error {
+	gzipWriter := gzip.NewWriter(output)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	err := filepath.WalkDir(cacheDir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(cacheDir, filePath)
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			return nil
+		}
+		// Symbolic links are not bundled, as extracting them is unsafe.
+		if entry.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		file, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = file.Close() }()
+		_, err = io.Copy(tarWriter, file)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("unable to write bundle: %w", err)
+	}
+	if err := tarWriter.Close(); err != nil {
+		return fmt.Errorf("unable to write bundle: %w", err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return fmt.Errorf("unable to write bundle: %w", err)
+	}
+	return nil
+}
+
+func extractBundleEntry(header *tar.Header, reader io.Reader, dir string) error {
+	if !filepath.IsLocal(header.Name) {
+		return fmt.Errorf("invalid bundle entry %s", header.Name)
+	}
+	entryPath := filepath.Join(dir, header.Name)
+	switch header.Typeflag {
+	case tar.TypeDir:
+		return os.MkdirAll(entryPath, 0700)
+	case tar.TypeReg:
+		if err := os.MkdirAll(filepath.Dir(entryPath), 0700); err != nil {
+			return err
+		}
+		file, err := os.OpenFile(entryPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(file, reader); err != nil {
+			_ = file.Close()
+			return err
+		}
+		return file.Close()
+	default:
+		return fmt.Errorf("unsupported type of bundle entry %s", header.Name)
+	}
+}
+
+// ExtractBundle populates the chart cache from a bundle created with
+// CreateBundle.  The bundle is extracted into a temporary directory first and
+// its entries are then moved into the cache, so that invocations sharing the
+// cache never observe partially extracted entries.  Helm repository indexes in
+// the bundle replace the cached ones, other entries already in the cache are
+// kept.
+func ExtractBundle(input io.Reader, chartCacheDir string) error {
+	if err := os.MkdirAll(chartCacheDir, 0700); err != nil {
+		return fmt.Errorf("unable to create cache directory %s: %w", chartCacheDir, err)
+	}
+	extractDir, err := os.MkdirTemp(chartCacheDir, ".tmp-bundle-")
+	if err != nil {
+		return fmt.Errorf("unable to create a bundle extraction dir: %w", err)
+	}
+	// Failures to remove temporary directories are not interesting.
+	defer func() { _ = os.RemoveAll(extractDir) }()
+
+	gzipReader, err := gzip.NewReader(input)
+	if err != nil {
+		return fmt.Errorf("unable to read bundle: %w", err)
+	}
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read bundle: %w", err)
+		}
+		if err := extractBundleEntry(header, tarReader, extractDir); err != nil {
+			return fmt.Errorf("unable to extract bundle entry %s: %w", header.Name, err)
+		}
+	}
+
+	// Bundles created by earlier versions carry the previous cache layout.
+	if err := migrateCacheLayout(extractDir); err != nil {
+		return fmt.Errorf("unable to migrate extracted bundle: %w", err)
+	}
+
+	// Move the entries of each repository directory into the cache.
+	repoDirs, err := os.ReadDir(extractDir)
+	if err != nil {
+		return fmt.Errorf("unable to list extracted bundle: %w", err)
+	}
+	for _, repoDir := range repoDirs {
+		if !repoDir.IsDir() || strings.HasPrefix(repoDir.Name(), ".") {
+			continue
+		}
+		sourceDir := filepath.Join(extractDir, repoDir.Name())
+		targetDir := filepath.Join(chartCacheDir, repoDir.Name())
+		if err := os.MkdirAll(targetDir, 0700); err != nil {
+			return fmt.Errorf("unable to create cache directory %s: %w", targetDir, err)
+		}
+		entries, err := os.ReadDir(sourceDir)
+		if err != nil {
+			return fmt.Errorf("unable to list extracted bundle: %w", err)
+		}
+		for _, entry := range entries {
+			targetPath := filepath.Join(targetDir, entry.Name())
+			if entry.IsDir() {
+				if _, err := os.Stat(targetPath); err == nil {
+					continue
+				}
+			}
+			err := os.Rename(filepath.Join(sourceDir, entry.Name()), targetPath)
+			if err != nil && !entry.IsDir() {
+				return fmt.Errorf("unable to move %s into the cache: %w", targetPath, err)
+			}
+		}
+	}
+	return nil
+}
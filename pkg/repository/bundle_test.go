@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Chart bundles", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+	})
+
+	ginkgo.It("transfers charts for rendering without network access", func() {
+		repoRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(repoRoot)
+		server, port, serverDone, err := serveDirectory(repoRoot, logger, nil)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		err = createSingleChartHelmRepository(
+			"test-chart",
+			"0.1.0",
+			map[string]string{
+				"Chart.yaml": strings.Join([]string{
+					"apiVersion: v2",
+					"name: test-chart",
+					"version: 0.1.0",
+				}, "\n"),
+				"templates/configmap.yaml": strings.Join([]string{
+					"apiVersion: v1",
+					"kind: ConfigMap",
+					"metadata:",
+					"  name: {{ .Release.Name }}-configmap",
+				}, "\n"),
+			},
+			port,
+			repoRoot,
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		input := strings.Join([]string{
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"spec:",
+			"  chart:",
+			"    spec:",
+			"      chart: test-chart",
+			"      sourceRef:",
+			"        kind: HelmRepository",
+			"        name: local",
+			"---",
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: HelmRepository",
+			"metadata:",
+			"  namespace: testns",
+			"  name: local",
+			"spec:",
+			fmt.Sprintf("  url: http://localhost:%d", port),
+		}, "\n")
+
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		bundle := &bytes.Buffer{}
+		err = expander.CreateBundle(Credentials{}, bytes.NewBufferString(input), bundle)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		err = stopServing(server, serverDone)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		cacheRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(cacheRoot)
+		err = ExtractBundle(bundle, cacheRoot)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		output := &bytes.Buffer{}
+		err = expander.Expand(
+			bytes.NewBufferString(input),
+			output,
+			ExpandOptions{
+				ChartCacheDir: cacheRoot,
+				Offline:       true,
+			},
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(output.String()).To(gomega.ContainSubstring("name: test-configmap"))
+
+		entries, err := os.ReadDir(cacheRoot)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(entries).To(gomega.HaveLen(1))
+	})
+
+	ginkgo.It("rejects entries outside of the cache directory", func() {
+		bundle := &bytes.Buffer{}
+		gzipWriter := gzip.NewWriter(bundle)
+		tarWriter := tar.NewWriter(gzipWriter)
+		err := tarWriter.WriteHeader(&tar.Header{
+			Name:     "../escaped.yaml",
+			Typeflag: tar.TypeReg,
+			Mode:     0600,
+			Size:     int64(len("data")),
+		})
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		_, err = tarWriter.Write([]byte("data"))
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(tarWriter.Close()).To(gomega.Succeed())
+		g.Expect(gzipWriter.Close()).To(gomega.Succeed())
+
+		cacheRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(cacheRoot)
+		err = ExtractBundle(bundle, cacheRoot)
+		g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(
+			"invalid bundle entry ../escaped.yaml",
+		)))
+	})
+})
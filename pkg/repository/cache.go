@@ -0,0 +1,83 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"fmt"
+	"io"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+)
+
+// WarmCache downloads the charts of all HelmRelease objects in the input,
+// together with their dependencies, into the chart cache without rendering
+// them.  HelmRelease objects produced by rendering charts are not discovered.
+func (expander *HelmReleaseExpander) WarmCache(
+	credentials Credentials,
+	input io.Reader,
+	chartCacheDir string,
+) error {
+	if chartCacheDir == "" {
+		return fmt.Errorf("chart cache directory is required to warm the cache")
+	}
+	expander.migrateCache(chartCacheDir)
+	defer expander.cleanUpEphemeralCache(chartCacheDir)
+
+	nodes, err := (&kio.ByteReader{Reader: input}).Read()
+	if err != nil {
+		return NewClassifiedError(
+			ErrorClassInput,
+			fmt.Errorf("unable to parse input: %w", err),
+		)
+	}
+
+	releaseRepos, err := getReleaseRepos(nodes, nodes)
+	if err != nil {
+		return fmt.Errorf("unable to get release repos: %w", err)
+	}
+	if err := checkMissingSources(releaseRepos); err != nil {
+		return err
+	}
+
+	config := expander.getLoaderConfig()
+	config.cacheRoot = chartCacheDir
+	config.credentials = credentials
+	for _, pair := range releaseRepos {
+		if err := expander.ctx.Err(); err != nil {
+			return fmt.Errorf("fetching charts canceled: %w", err)
+		}
+		var release helmv2.HelmRelease
+		if err := decodeToObject(pair.release, &release); err != nil {
+			return fmt.Errorf(
+				"unable to decode HelmRelease %s/%s: %w",
+				pair.release.GetNamespace(),
+				pair.release.GetName(),
+				err,
+			)
+		}
+		if pair.repo == nil {
+			return fmt.Errorf(
+				"missing chart repository %s for Helm release %s/%s",
+				release.Spec.Chart.Spec.SourceRef.Name,
+				release.Namespace,
+				release.Name,
+			)
+		}
+
+		_, _, err := loadRepositoryChart(config, &release, pair.repo)
+		if err != nil {
+			return fmt.Errorf(
+				"unable to fetch chart for Helm release %s/%s: %w",
+				release.Namespace,
+				release.Name,
+				err,
+			)
+		}
+		expander.logger.
+			With("namespace", release.Namespace).
+			With("name", release.Name).
+			Info("Fetched chart for Helm release")
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Cache warming", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+	})
+
+	ginkgo.It("fetches charts for rendering without network access", func() {
+		cacheRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(cacheRoot)
+		repoRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(repoRoot)
+		recorder := logRecorder{}
+		server, port, serverDone, err := serveDirectory(repoRoot, logger, &recorder)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		err = createSingleChartHelmRepository(
+			"test-chart",
+			"0.1.0",
+			map[string]string{
+				"Chart.yaml": strings.Join([]string{
+					"apiVersion: v2",
+					"name: test-chart",
+					"version: 0.1.0",
+				}, "\n"),
+				"templates/configmap.yaml": strings.Join([]string{
+					"apiVersion: v1",
+					"kind: ConfigMap",
+					"metadata:",
+					"  name: {{ .Release.Name }}-configmap",
+				}, "\n"),
+			},
+			port,
+			repoRoot,
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		input := strings.Join([]string{
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"spec:",
+			"  chart:",
+			"    spec:",
+			"      chart: test-chart",
+			"      version: '>=0.1.0'",
+			"      sourceRef:",
+			"        kind: HelmRepository",
+			"        name: local",
+			"---",
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: HelmRepository",
+			"metadata:",
+			"  namespace: testns",
+			"  name: local",
+			"spec:",
+			fmt.Sprintf("  url: http://localhost:%d", port),
+		}, "\n")
+
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		err = expander.WarmCache(Credentials{}, bytes.NewBufferString(input), cacheRoot)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		err = stopServing(server, serverDone)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(recorder.records).To(gomega.HaveLen(2))
+		g.Expect(recorder.records[0]).To(gomega.HaveField("URL.Path", "/index.yaml"))
+		g.Expect(recorder.records[1]).To(gomega.HaveField("URL.Path", "/test-chart-0.1.0.tgz"))
+
+		output := &bytes.Buffer{}
+		err = expander.Expand(
+			bytes.NewBufferString(input),
+			output,
+			ExpandOptions{
+				ChartCacheDir: cacheRoot,
+				Offline:       true,
+			},
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(output.String()).To(gomega.ContainSubstring("name: test-configmap"))
+	})
+
+	ginkgo.It("lists all entries missing from the cache in the offline mode", func() {
+		cacheRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(cacheRoot)
+
+		getRelease := func(name string, repoKind string) string {
+			return strings.Join([]string{
+				"apiVersion: helm.toolkit.fluxcd.io/v2",
+				"kind: HelmRelease",
+				"metadata:",
+				"  namespace: testns",
+				"  name: " + name,
+				"spec:",
+				"  chart:",
+				"    spec:",
+				"      chart: test-chart",
+				"      version: 0.1.0",
+				"      sourceRef:",
+				"        kind: " + repoKind,
+				"        name: local",
+			}, "\n")
+		}
+		input := strings.Join([]string{
+			getRelease("first", "HelmRepository"),
+			"---",
+			getRelease("second", "GitRepository"),
+			"---",
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: HelmRepository",
+			"metadata:",
+			"  namespace: testns",
+			"  name: local",
+			"spec:",
+			"  url: http://localhost:1",
+			"---",
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: GitRepository",
+			"metadata:",
+			"  namespace: testns",
+			"  name: local",
+			"spec:",
+			"  url: " + repoURL,
+			"  ref:",
+			"    tag: v1.0.0",
+		}, "\n")
+
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		err = expander.Expand(
+			bytes.NewBufferString(input),
+			&bytes.Buffer{},
+			ExpandOptions{
+				ChartCacheDir: cacheRoot,
+				Offline:       true,
+			},
+		)
+		g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(strings.Join([]string{
+			"entries missing from the chart cache in the offline mode:",
+			"  index of Helm repository http://localhost:1/ is not in the chart cache " +
+				"(Helm release testns/first)",
+			"  Git repository " + repoURL + " at tag v1.0.0 is not in the chart cache " +
+				"(Helm release testns/second)",
+		}, "\n"))))
+	})
+
+	ginkgo.It("requires a cache directory", func() {
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		err := expander.WarmCache(Credentials{}, bytes.NewBufferString(""), "")
+		g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(
+			"chart cache directory is required",
+		)))
+	})
+})
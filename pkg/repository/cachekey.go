@@ -0,0 +1,154 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	// maxCacheKeyLabelLength bounds the human-readable part of cache keys,
+	// keeping the cache paths well within file name length limits.
+	maxCacheKeyLabelLength = 48
+	// cacheKeyHashLength is the number of hex digits of the SHA-256 hash in
+	// cache keys, 128 bits.
+	cacheKeyHashLength = 32
+)
+
+// unsafeCacheKeyPattern matches the runs of characters which are not safe in
+// file names on all file systems.
+var unsafeCacheKeyPattern = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// getCacheKey returns a file name identifying the value in the chart cache.
+// It consists of the label reduced to safe characters and truncated, so that
+// people browsing the cache can tell the entries apart, and the hash of the
+// value, which makes the keys of different values distinct.
+func getCacheKey(label string, value string) string {
+	label = strings.Trim(unsafeCacheKeyPattern.ReplaceAllString(label, "_"), "_.")
+	if len(label) > maxCacheKeyLabelLength {
+		label = label[:maxCacheKeyLabelLength]
+	}
+	hash := sha256.Sum256([]byte(value))
+	key := hex.EncodeToString(hash[:])[:cacheKeyHashLength]
+	if label == "" {
+		return key
+	}
+	return label + "-" + key
+}
+
+// getRepoCacheKey returns the name of the cache directory of the repository.
+// The label leaves out the scheme and the user information of the URL, which
+// may contain credentials.
+func getRepoCacheKey(repoURL string) string {
+	repoURL = strings.TrimSuffix(repoURL, "/")
+	label := repoURL
+	if parsedURL, err := url.Parse(repoURL); err == nil && parsedURL.Host != "" {
+		label = parsedURL.Host + parsedURL.Path
+	}
+	return getCacheKey(label, repoURL)
+}
+
+// getGitRefCacheKey returns the name of the directory a Git repository is
+// checked out to at the reference, given as
+// <branch>#<tag>#<semver>#<name>#<commit>, in the cache directory of the
+// repository.
+func getGitRefCacheKey(gitRef string) string {
+	return getCacheKey(gitRef, gitRef)
+}
+
+// migrateCacheEntry moves a cache entry to its new path, discarding it if the
+// new path is already populated, e.g. by a concurrent invocation.
+func migrateCacheEntry(oldPath string, newPath string) error {
+	err := os.Rename(oldPath, newPath)
+	if err == nil {
+		return nil
+	}
+	if _, statErr := os.Stat(oldPath); os.IsNotExist(statErr) {
+		// Another invocation has migrated the entry.
+		return nil
+	}
+	if _, statErr := os.Stat(newPath); statErr == nil {
+		return os.RemoveAll(oldPath)
+	}
+	return fmt.Errorf("unable to move cache entry %s to %s: %w", oldPath, newPath, err)
+}
+
+// migrateCacheLayout moves the entries of the previous cache layout, where
+// repository directories were named after their URLs with / replaced by #
+// and Git references were joined by #, to the paths of the current layout.
+// The entries of the current layout never contain #, so the entries are only
+// migrated once.
+func migrateCacheLayout(cacheRoot string) error {
+	entries, err := os.ReadDir(cacheRoot)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to list cache directory %s: %w", cacheRoot, err)
+	}
+	var errs []error
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.Contains(entry.Name(), "#") {
+			continue
+		}
+		repoPath := path.Join(cacheRoot, entry.Name())
+		if err := migrateGitRefs(repoPath); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		repoURL := strings.ReplaceAll(entry.Name(), "#", "/")
+		newRepoPath := getCachePathForRepo(cacheRoot, repoURL, false)
+		if err := migrateCacheEntry(repoPath, newRepoPath); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// migrateGitRefs moves the Git repository checkouts in the cache directory of
+// a repository in the previous layout, named after the references with / in
+// reference names replaced by %, to the paths of the current layout.
+func migrateGitRefs(repoPath string) error {
+	entries, err := os.ReadDir(repoPath)
+	if err != nil {
+		return fmt.Errorf("unable to list cache directory %s: %w", repoPath, err)
+	}
+	var errs []error
+	for _, entry := range entries {
+		parts := strings.Split(entry.Name(), "#")
+		if !entry.IsDir() || len(parts) != 5 {
+			continue
+		}
+		parts[3] = strings.ReplaceAll(parts[3], "%", "/")
+		err := migrateCacheEntry(
+			path.Join(repoPath, entry.Name()),
+			path.Join(repoPath, getGitRefCacheKey(strings.Join(parts, "#"))),
+		)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// migrateCache migrates the chart cache from the previous layout, logging
+// failures, as the entries left behind are merely downloaded again.
+func (expander *HelmReleaseExpander) migrateCache(chartCacheDir string) {
+	if chartCacheDir == "" {
+		return
+	}
+	if err := migrateCacheLayout(chartCacheDir); err != nil {
+		expander.logger.
+			With("directory", chartCacheDir).
+			With("error", err).
+			Warn("Unable to migrate chart cache entries from the previous layout")
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"helm.sh/helm/v4/pkg/chart/common"
+)
+
+const (
+	// KubeVersionAnnotation on a HelmRelease overrides the Kubernetes version
+	// its chart is rendered for.
+	KubeVersionAnnotation = "fouskoti.sage.com/kube-version"
+	// APIVersionsAnnotation on a HelmRelease overrides the API versions its
+	// chart is rendered with, separated by commas or whitespace.
+	APIVersionsAnnotation = "fouskoti.sage.com/api-versions"
+)
+
+// ReadAPIVersions reads API versions, one per line, as printed by kubectl
+// api-versions.  Empty lines and lines starting with # are ignored.
+func ReadAPIVersions(input io.Reader) ([]string, error) {
+	result := []string{}
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		result = append(result, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read API versions: %w", err)
+	}
+	return result, nil
+}
+
+// nonResourceKinds are the kinds with OpenAPI schemas which are not served as
+// resources, so they are not in the API versions of a cluster.
+var nonResourceKinds = map[string]struct{}{
+	"APIGroup":        {},
+	"APIGroupList":    {},
+	"APIResourceList": {},
+	"APIVersions":     {},
+	"DeleteOptions":   {},
+	"Status":          {},
+	"WatchEvent":      {},
+}
+
+type groupVersionKind struct {
+	Group   string `json:"group"`
+	Version string `json:"version"`
+	Kind    string `json:"kind"`
+}
+
+func (gvk groupVersionKind) groupVersion() string {
+	if gvk.Group == "" {
+		return gvk.Version
+	}
+	return gvk.Group + "/" + gvk.Version
+}
+
+type openAPISchema struct {
+	GroupVersionKinds []groupVersionKind `json:"x-kubernetes-group-version-kind"`
+}
+
+// openAPIDocument holds the schemas of OpenAPI v2 documents, as served by the
+// /openapi/v2 endpoint, or of OpenAPI v3 documents, as served by the
+// /openapi/v3/apis/<group>/<version> endpoints.
+type openAPIDocument struct {
+	Definitions map[string]openAPISchema `json:"definitions"`
+	Components  struct {
+		Schemas map[string]openAPISchema `json:"schemas"`
+	} `json:"components"`
+}
+
+// readOpenAPIResources returns the kinds of the resources defined in the
+// OpenAPI document.
+func readOpenAPIResources(data []byte) ([]groupVersionKind, error) {
+	var document openAPIDocument
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("unable to parse OpenAPI document: %w", err)
+	}
+	var result []groupVersionKind
+	for _, schemas := range []map[string]openAPISchema{
+		document.Definitions,
+		document.Components.Schemas,
+	} {
+		for _, schema := range schemas {
+			// Schemas shared by all groups, like DeleteOptions, list many
+			// kinds, resources have a single one.
+			if len(schema.GroupVersionKinds) != 1 {
+				continue
+			}
+			gvk := schema.GroupVersionKinds[0]
+			_, nonResource := nonResourceKinds[gvk.Kind]
+			if nonResource || strings.HasSuffix(gvk.Kind, "List") {
+				continue
+			}
+			result = append(result, gvk)
+		}
+	}
+	return result, nil
+}
+
+// readAPIResourcesTable returns the kinds of the resources in the output of
+// kubectl api-resources, locating the APIVERSION and KIND columns by the
+// header, since the SHORTNAMES column may be empty.
+func readAPIResourcesTable(data []byte) ([]groupVersionKind, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	if !scanner.Scan() {
+		return nil, errors.New("missing api-resources header")
+	}
+	header := scanner.Text()
+	columns := []int{}
+	for i, r := range header {
+		if r != ' ' && (i == 0 || header[i-1] == ' ') {
+			columns = append(columns, i)
+		}
+	}
+	// Returns the function extracting the value of the named column from the
+	// lines, which kubectl aligns with the header.
+	getColumn := func(name string) (func(line string) string, error) {
+		for i, start := range columns {
+			end := math.MaxInt
+			if i+1 < len(columns) {
+				end = columns[i+1]
+			}
+			if strings.TrimSpace(header[start:min(end, len(header))]) != name {
+				continue
+			}
+			return func(line string) string {
+				if start >= len(line) {
+					return ""
+				}
+				return strings.TrimSpace(line[start:min(end, len(line))])
+			}, nil
+		}
+		return nil, fmt.Errorf("missing %s column in api-resources header", name)
+	}
+	getAPIVersion, err := getColumn("APIVERSION")
+	if err != nil {
+		return nil, err
+	}
+	getKind, err := getColumn("KIND")
+	if err != nil {
+		return nil, err
+	}
+
+	var result []groupVersionKind
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		apiVersion := getAPIVersion(line)
+		kind := getKind(line)
+		if apiVersion == "" || kind == "" {
+			return nil, fmt.Errorf("invalid api-resources line %q", line)
+		}
+		group, version, found := strings.Cut(apiVersion, "/")
+		if !found {
+			group, version = "", apiVersion
+		}
+		result = append(result, groupVersionKind{
+			Group:   group,
+			Version: version,
+			Kind:    kind,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read api-resources: %w", err)
+	}
+	return result, nil
+}
+
+// ReadAPIResources reads the resources served by a cluster from the output of
+// kubectl api-resources (optionally with -o wide) or from an OpenAPI v2 or v3
+// document, e.g. the output of kubectl get --raw /openapi/v2.  It returns the
+// API versions together with the group/version/Kind entries, which a cluster
+// reports in .Capabilities.APIVersions, so that checks for kinds behave as on
+// the cluster.
+func ReadAPIResources(input io.Reader) ([]string, error) {
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read API resources: %w", err)
+	}
+	var resources []groupVersionKind
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		resources, err = readOpenAPIResources(data)
+	} else {
+		resources, err = readAPIResourcesTable(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0, 2*len(resources))
+	for _, resource := range resources {
+		result = append(
+			result,
+			resource.groupVersion(),
+			resource.groupVersion()+"/"+resource.Kind,
+		)
+	}
+	slices.Sort(result)
+	return slices.Compact(result), nil
+}
+
+// getReleaseCapabilities returns the capabilities to render the chart of the
+// release with: the Kubernetes version and API versions from the release
+// annotations if present, since a single input can target clusters on
+// different Kubernetes versions, or the given ones otherwise.  The API
+// resources of a cluster, if given, replace the Helm default API versions.
+func getReleaseCapabilities(
+	release *helmv2.HelmRelease,
+	kubeVersion *common.KubeVersion,
+	apiVersions []string,
+	apiResources []string,
+) (*common.Capabilities, error) {
+	if version, ok := release.Annotations[KubeVersionAnnotation]; ok {
+		var err error
+		kubeVersion, err = common.ParseKubeVersion(version)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"invalid %s annotation %s: %w",
+				KubeVersionAnnotation,
+				version,
+				err,
+			)
+		}
+	}
+	if versions, ok := release.Annotations[APIVersionsAnnotation]; ok {
+		apiVersions = strings.FieldsFunc(versions, func(r rune) bool {
+			return r == ',' || unicode.IsSpace(r)
+		})
+	}
+
+	capabilities := common.DefaultCapabilities.Copy()
+	if kubeVersion != nil {
+		capabilities.KubeVersion = *kubeVersion
+	}
+	if len(apiResources) > 0 {
+		capabilities.APIVersions = common.VersionSet(slices.Clone(apiResources))
+	}
+	if len(apiVersions) > 0 {
+		capabilities.APIVersions = append(
+			capabilities.APIVersions,
+			common.VersionSet(apiVersions)...,
+		)
+	}
+	return capabilities, nil
+}
@@ -0,0 +1,162 @@
+package repository
+
+import (
+	"bytes"
+	"strings"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"helm.sh/helm/v4/pkg/chart/common"
+)
+
+var _ = ginkgo.Describe("ReadAPIVersions", func() {
+	var g gomega.Gomega
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+	})
+
+	ginkgo.It("reads API versions one per line", func() {
+		apiVersions, err := ReadAPIVersions(bytes.NewBufferString(strings.Join([]string{
+			"# kubectl api-versions",
+			"apps/v1",
+			"",
+			"  monitoring.coreos.com/v1  ",
+			"v1",
+		}, "\n")))
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(apiVersions).To(gomega.Equal([]string{
+			"apps/v1",
+			"monitoring.coreos.com/v1",
+			"v1",
+		}))
+	})
+})
+
+var _ = ginkgo.Describe("ReadAPIResources", func() {
+	var g gomega.Gomega
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+	})
+
+	ginkgo.It("reads the output of kubectl api-resources", func() {
+		apiResources, err := ReadAPIResources(bytes.NewBufferString(strings.Join([]string{
+			"NAME          SHORTNAMES   APIVERSION                NAMESPACED   KIND             VERBS",
+			"configmaps    cm           v1                        true         ConfigMap        create,get",
+			"deployments   deploy       apps/v1                   true         Deployment       create,get",
+			"prometheuses               monitoring.coreos.com/v1  true         Prometheus       get",
+			"",
+		}, "\n")))
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(apiResources).To(gomega.Equal([]string{
+			"apps/v1",
+			"apps/v1/Deployment",
+			"monitoring.coreos.com/v1",
+			"monitoring.coreos.com/v1/Prometheus",
+			"v1",
+			"v1/ConfigMap",
+		}))
+	})
+
+	ginkgo.It("rejects tables without API versions", func() {
+		_, err := ReadAPIResources(bytes.NewBufferString(strings.Join([]string{
+			"NAME          SHORTNAMES   APIGROUP   NAMESPACED   KIND",
+			"configmaps    cm                      true         ConfigMap",
+		}, "\n")))
+		g.Expect(err).To(gomega.MatchError("missing APIVERSION column in api-resources header"))
+	})
+
+	ginkgo.It("reads OpenAPI documents", func() {
+		apiResources, err := ReadAPIResources(bytes.NewBufferString(`{
+			"definitions": {
+				"io.k8s.api.apps.v1.Deployment": {
+					"x-kubernetes-group-version-kind": [
+						{"group": "apps", "version": "v1", "kind": "Deployment"}
+					]
+				},
+				"io.k8s.api.apps.v1.DeploymentList": {
+					"x-kubernetes-group-version-kind": [
+						{"group": "apps", "version": "v1", "kind": "DeploymentList"}
+					]
+				},
+				"io.k8s.apimachinery.pkg.apis.meta.v1.DeleteOptions": {
+					"x-kubernetes-group-version-kind": [
+						{"group": "", "version": "v1", "kind": "DeleteOptions"},
+						{"group": "apps", "version": "v1", "kind": "DeleteOptions"}
+					]
+				},
+				"io.k8s.api.core.v1.PodSpec": {}
+			}
+		}`))
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(apiResources).To(gomega.Equal([]string{"apps/v1", "apps/v1/Deployment"}))
+	})
+})
+
+var _ = ginkgo.Describe("Release capabilities", func() {
+	var g gomega.Gomega
+	var kubeVersion *common.KubeVersion
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		var err error
+		kubeVersion, err = common.ParseKubeVersion("1.28")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+	})
+
+	ginkgo.It("uses the global versions without annotations", func() {
+		capabilities, err := getReleaseCapabilities(
+			&helmv2.HelmRelease{},
+			kubeVersion,
+			[]string{"example.com/v1"},
+			nil,
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(capabilities.KubeVersion.Version).To(gomega.Equal("v1.28.0"))
+		g.Expect(capabilities.APIVersions.Has("example.com/v1")).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("overrides the versions with annotations", func() {
+		release := &helmv2.HelmRelease{}
+		release.Annotations = map[string]string{
+			KubeVersionAnnotation: "1.31",
+			APIVersionsAnnotation: "monitoring.coreos.com/v1,\ncert-manager.io/v1",
+		}
+		capabilities, err := getReleaseCapabilities(
+			release,
+			kubeVersion,
+			[]string{"example.com/v1"},
+			nil,
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(capabilities.KubeVersion.Version).To(gomega.Equal("v1.31.0"))
+		g.Expect(capabilities.APIVersions.Has("monitoring.coreos.com/v1")).To(gomega.BeTrue())
+		g.Expect(capabilities.APIVersions.Has("cert-manager.io/v1")).To(gomega.BeTrue())
+		g.Expect(capabilities.APIVersions.Has("example.com/v1")).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("replaces the default versions with the API resources", func() {
+		capabilities, err := getReleaseCapabilities(
+			&helmv2.HelmRelease{},
+			kubeVersion,
+			[]string{"example.com/v1"},
+			[]string{"apps/v1", "apps/v1/Deployment", "v1", "v1/ConfigMap"},
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(capabilities.APIVersions.Has("apps/v1/Deployment")).To(gomega.BeTrue())
+		g.Expect(capabilities.APIVersions.Has("example.com/v1")).To(gomega.BeTrue())
+		g.Expect(capabilities.APIVersions.Has("batch/v1")).To(gomega.BeFalse())
+		g.Expect(capabilities.APIVersions.Has("apps/v1/StatefulSet")).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("rejects invalid Kubernetes versions", func() {
+		release := &helmv2.HelmRelease{}
+		release.Annotations = map[string]string{KubeVersionAnnotation: "latest"}
+		_, err := getReleaseCapabilities(release, kubeVersion, nil, nil)
+		g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(
+			"invalid fouskoti.sage.com/kube-version annotation latest",
+		)))
+	})
+})
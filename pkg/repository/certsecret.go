@@ -0,0 +1,68 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"fmt"
+
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// tlsSecretKeys are the entries of the Secrets referenced by
+// spec.certSecretRef holding the certificate authority and the client
+// certificate, with the deprecated ones Flux still accepts.
+var tlsSecretKeys = []string{"ca.crt", "tls.crt", "tls.key", "caFile", "certFile", "keyFile"}
+
+// newSecretIndex returns the index of the Secret objects among the nodes.
+func newSecretIndex(nodes []*yaml.RNode) sourceIndex {
+	var secrets []*yaml.RNode
+	for _, node := range nodes {
+		if node.GetKind() == "Secret" && node.GetApiVersion() == "v1" {
+			secrets = append(secrets, node)
+		}
+	}
+	return newSourceIndex(secrets)
+}
+
+// getTLSCredentials returns the TLS entries of the credentials of the
+// repository in the credentials file or, without any, of the Secret in the
+// input that spec.certSecretRef of the repository refers to, if any.
+func (config *loaderConfig) getTLSCredentials(
+	repo *sourcev1.HelmRepository,
+	credentials map[string]string,
+) (map[string]string, error) {
+	result := map[string]string{}
+	for _, key := range tlsSecretKeys {
+		if value, ok := credentials[key]; ok {
+			result[key] = value
+		}
+	}
+	if len(result) > 0 || repo == nil || repo.Spec.CertSecretRef == nil {
+		return result, nil
+	}
+	secretName := repo.Spec.CertSecretRef.Name
+	secret := config.secrets.find("Secret", repo.Namespace, secretName, "")
+	if secret == nil {
+		config.logger.
+			With("namespace", repo.Namespace).
+			With("name", secretName).
+			Debug("Certificate Secret of repository is not in the input")
+		return result, nil
+	}
+	entries := map[string]string{}
+	if err := addSourceVariables(entries, secret); err != nil {
+		return nil, fmt.Errorf(
+			"unable to read certificate Secret %s/%s: %w",
+			repo.Namespace,
+			secretName,
+			err,
+		)
+	}
+	for _, key := range tlsSecretKeys {
+		if value, ok := entries[key]; ok {
+			result[key] = value
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+var _ = ginkgo.Describe("Repository certificate Secrets", func() {
+	var g gomega.Gomega
+	var config loaderConfig
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		nodes, err := (&kio.ByteReader{Reader: bytes.NewBufferString(strings.Join([]string{
+			"apiVersion: v1",
+			"kind: Secret",
+			"metadata:",
+			"  namespace: flux-system",
+			"  name: charts-tls",
+			"data:",
+			"  ca.crt: Y2EgY2VydGlmaWNhdGU=",
+			"  other: b3RoZXI=",
+			"stringData:",
+			"  tls.crt: client certificate",
+			"  tls.key: client key",
+			"---",
+			"apiVersion: v1",
+			"kind: ConfigMap",
+			"metadata:",
+			"  namespace: flux-system",
+			"  name: other-tls",
+			"data:",
+			"  ca.crt: not a secret",
+		}, "\n"))}).Read()
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		config = loaderConfig{logger: slog.New(handler), secrets: newSecretIndex(nodes)}
+	})
+
+	getRepo := func(secretName string) *sourcev1.HelmRepository {
+		node := yaml.MustParse(strings.Join([]string{
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: HelmRepository",
+			"metadata:",
+			"  namespace: flux-system",
+			"  name: charts",
+			"spec:",
+			"  url: https://charts.example.com",
+			"  certSecretRef:",
+			"    name: " + secretName,
+		}, "\n"))
+		repo := &sourcev1.HelmRepository{}
+		g.Expect(decodeToObject(node, repo)).To(gomega.Succeed())
+		return repo
+	}
+
+	ginkgo.It("takes the certificates from the Secret in the input", func() {
+		credentials, err := config.getTLSCredentials(getRepo("charts-tls"), nil)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(credentials).To(gomega.Equal(map[string]string{
+			"ca.crt":  "ca certificate",
+			"tls.crt": "client certificate",
+			"tls.key": "client key",
+		}))
+	})
+
+	ginkgo.It("prefers the credentials file", func() {
+		credentials, err := config.getTLSCredentials(
+			getRepo("charts-tls"),
+			map[string]string{"username": "user", "caFile": "file certificate"},
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(credentials).To(gomega.Equal(map[string]string{"caFile": "file certificate"}))
+	})
+
+	ginkgo.It("ignores Secrets missing from the input", func() {
+		credentials, err := config.getTLSCredentials(getRepo("other-tls"), nil)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(credentials).To(gomega.BeEmpty())
+
+		credentials, err = config.getTLSCredentials(nil, nil)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(credentials).To(gomega.BeEmpty())
+	})
+})
@@ -0,0 +1,83 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"sync"
+
+	"golang.org/x/sync/singleflight"
+	chart "helm.sh/helm/v4/pkg/chart/v2"
+)
+
+// inMemoryChartCache keeps the charts loaded during an expansion in memory, so that
+// releases using the same chart load it once.  It is safe for concurrent use,
+// and concurrent loads of the same chart share a single fetch.  A nil cache
+// loads the charts every time.
+type inMemoryChartCache struct {
+	mutex  sync.RWMutex
+	charts map[string]*chart.Chart
+	group  singleflight.Group
+}
+
+func newInMemoryChartCache() *inMemoryChartCache {
+	return &inMemoryChartCache{charts: map[string]*chart.Chart{}}
+}
+
+// ChartCache keeps the charts loaded by expansions in memory for later
+// expansions to reuse, e.g., in a long-running process.  Charts of moving
+// references like Git branches are reused for as long as the cache is, so
+// callers replace the cache when those may have changed.
+type ChartCache struct {
+	charts *inMemoryChartCache
+}
+
+// NewChartCache creates an empty chart cache.
+func NewChartCache() *ChartCache {
+	return &ChartCache{charts: newInMemoryChartCache()}
+}
+
+// load returns the chart cached under the key, or loads it with the function
+// and caches it unless loading fails.  It also reports whether the chart was
+// reused rather than loaded by this call.  The charts are shared, so callers
+// need to copy them before modifying them.
+func (cache *inMemoryChartCache) load(
+	key string,
+	load func() (*chart.Chart, error),
+) (*chart.Chart, bool, error) {
+	if cache == nil {
+		result, err := load()
+		return result, false, err
+	}
+
+	cache.mutex.RLock()
+	result, ok := cache.charts[key]
+	cache.mutex.RUnlock()
+	if ok {
+		return result, true, nil
+	}
+
+	loaded := false
+	value, err, _ := cache.group.Do(key, func() (any, error) {
+		// The chart may have been cached since the lookup above.
+		cache.mutex.RLock()
+		result, ok := cache.charts[key]
+		cache.mutex.RUnlock()
+		if ok {
+			return result, nil
+		}
+
+		result, err := load()
+		if err != nil || result == nil {
+			return result, err
+		}
+		loaded = true
+		cache.mutex.Lock()
+		cache.charts[key] = result
+		cache.mutex.Unlock()
+		return result, nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return value.(*chart.Chart), !loaded, nil
+}
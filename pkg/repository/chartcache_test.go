@@ -0,0 +1,172 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	chart "helm.sh/helm/v4/pkg/chart/v2"
+)
+
+var _ = ginkgo.Describe("In-memory chart cache", func() {
+	var g gomega.Gomega
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+	})
+
+	ginkgo.It("loads each chart once for concurrent callers", func() {
+		cache := newInMemoryChartCache()
+		var loads atomic.Int32
+		release := make(chan struct{})
+		load := func() (*chart.Chart, error) {
+			loads.Add(1)
+			<-release
+			return &chart.Chart{Metadata: &chart.Metadata{Name: "test-chart"}}, nil
+		}
+
+		const callers = 10
+		var wait sync.WaitGroup
+		var started sync.WaitGroup
+		results := make([]*chart.Chart, callers)
+		for i := range callers {
+			wait.Add(1)
+			started.Add(1)
+			go func() {
+				defer ginkgo.GinkgoRecover()
+				defer wait.Done()
+				started.Done()
+				result, _, err := cache.load("key", load)
+				g.Expect(err).ToNot(gomega.HaveOccurred())
+				results[i] = result
+			}()
+		}
+		started.Wait()
+		close(release)
+		wait.Wait()
+
+		g.Expect(loads.Load()).To(gomega.Equal(int32(1)))
+		for _, result := range results {
+			g.Expect(result).To(gomega.BeIdenticalTo(results[0]))
+		}
+
+		result, cached, err := cache.load("key", load)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(cached).To(gomega.BeTrue())
+		g.Expect(result).To(gomega.BeIdenticalTo(results[0]))
+	})
+
+	ginkgo.It("does not cache failed loads", func() {
+		cache := newInMemoryChartCache()
+		_, _, err := cache.load("key", func() (*chart.Chart, error) {
+			return nil, errors.New("unable to fetch")
+		})
+		g.Expect(err).To(gomega.MatchError("unable to fetch"))
+
+		expected := &chart.Chart{Metadata: &chart.Metadata{Name: "test-chart"}}
+		result, cached, err := cache.load("key", func() (*chart.Chart, error) {
+			return expected, nil
+		})
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(cached).To(gomega.BeFalse())
+		g.Expect(result).To(gomega.BeIdenticalTo(expected))
+	})
+
+	ginkgo.It("loads every time without a cache", func() {
+		var cache *inMemoryChartCache
+		var loads int
+		for range 2 {
+			_, cached, err := cache.load("key", func() (*chart.Chart, error) {
+				loads++
+				return &chart.Chart{}, nil
+			})
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+			g.Expect(cached).To(gomega.BeFalse())
+		}
+		g.Expect(loads).To(gomega.Equal(2))
+	})
+
+	ginkgo.It("shares the charts across expansions", func() {
+		logger := slog.New(slog.NewTextHandler(ginkgo.GinkgoWriter, nil))
+		repoRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(repoRoot)
+		recorder := logRecorder{}
+		server, port, serverDone, err := serveDirectory(repoRoot, logger, &recorder)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer func() {
+			g.Expect(stopServing(server, serverDone)).To(gomega.Succeed())
+		}()
+
+		err = createSingleChartHelmRepository(
+			"test-chart",
+			"0.1.0",
+			map[string]string{
+				"Chart.yaml": strings.Join([]string{
+					"apiVersion: v2",
+					"name: test-chart",
+					"version: 0.1.0",
+				}, "\n"),
+				"templates/configmap.yaml": strings.Join([]string{
+					"apiVersion: v1",
+					"kind: ConfigMap",
+					"metadata:",
+					"  name: {{ .Release.Name }}-configmap",
+				}, "\n"),
+			},
+			port,
+			repoRoot,
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		input := strings.Join([]string{
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"spec:",
+			"  chart:",
+			"    spec:",
+			"      chart: test-chart",
+			"      sourceRef:",
+			"        kind: HelmRepository",
+			"        name: local",
+			"---",
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: HelmRepository",
+			"metadata:",
+			"  namespace: testns",
+			"  name: local",
+			"spec:",
+			fmt.Sprintf("  url: http://localhost:%d", port),
+		}, "\n")
+
+		expander := NewHelmReleaseExpander(context.Background(), logger, nil, nil)
+		chartCache := NewChartCache()
+		for range 2 {
+			output := &bytes.Buffer{}
+			err = expander.Expand(
+				bytes.NewBufferString(input),
+				output,
+				ExpandOptions{ChartCache: chartCache},
+			)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+			g.Expect(output.String()).To(gomega.ContainSubstring("name: test-configmap"))
+		}
+		// The index is fetched for each expansion to resolve the version, the
+		// chart only once.
+		g.Expect(recorder.records).To(gomega.HaveLen(3))
+		g.Expect(recorder.records[0]).To(gomega.HaveField("URL.Path", "/index.yaml"))
+		g.Expect(recorder.records[1]).To(gomega.HaveField("URL.Path", "/test-chart-0.1.0.tgz"))
+		g.Expect(recorder.records[2]).To(gomega.HaveField("URL.Path", "/index.yaml"))
+	})
+})
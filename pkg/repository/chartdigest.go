@@ -0,0 +1,104 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// chartDigestSuffix is appended to the path of a cached chart to name the
+// file recording the digest of the chart in its source when it was cached.
+const chartDigestSuffix = ".digest"
+
+// chartDigestRecord records the digest of a cached chart: the SHA-256 digest
+// of the archive, as in the index, for charts from Helm repositories, and the
+// digest of the manifest for charts from OCI registries.
+type chartDigestRecord struct {
+	Digest string `json:"digest"`
+}
+
+// getArchiveDigest returns the digest of the chart archive, as it appears in
+// Helm repository indexes.
+func getArchiveDigest(data []byte) string {
+	digest := sha256.Sum256(data)
+	return hex.EncodeToString(digest[:])
+}
+
+// readChartDigest returns the digest recorded for the cached chart, or an
+// empty one if there is none.
+func readChartDigest(chartPath string) string {
+	var record chartDigestRecord
+	content, err := os.ReadFile(chartPath + chartDigestSuffix)
+	if err == nil {
+		// Unreadable records only make the chart be downloaded again.
+		_ = json.Unmarshal(content, &record)
+	}
+	return record.Digest
+}
+
+// writeChartDigest records the digest of the cached chart.
+func writeChartDigest(chartPath string, digest string) error {
+	content, err := json.Marshal(chartDigestRecord{Digest: digest})
+	if err != nil {
+		return fmt.Errorf("unable to encode digest of chart %s: %w", chartPath, err)
+	}
+	err = os.WriteFile(chartPath+chartDigestSuffix, content, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to save digest of chart %s: %w", chartPath, err)
+	}
+	return nil
+}
+
+// isVerifyingCache tells whether the cached charts are verified against their
+// sources.
+func (config *loaderConfig) isVerifyingCache() bool {
+	return !config.verifyCacheSince.IsZero()
+}
+
+// invalidateCachedChart removes the cached chart whose digest differs from the
+// digest of the chart in its source, as happens when a version is published
+// again, and tells whether it did.  Charts cached without a digest cannot be
+// verified and are removed as well.
+func (config *loaderConfig) invalidateCachedChart(chartPath string, digest string) bool {
+	recorded := readChartDigest(chartPath)
+	if recorded == digest {
+		return false
+	}
+	if recorded == "" {
+		config.logger.
+			With("dir", chartPath).
+			Debug("Cached chart has no recorded digest, downloading it again")
+	} else {
+		config.logger.
+			With("dir", chartPath).
+			With("cachedDigest", recorded).
+			With("digest", digest).
+			Warn("Cached chart differs from its source, downloading it again")
+	}
+	for _, filePath := range []string{chartPath, chartPath + chartDigestSuffix} {
+		if err := os.RemoveAll(filePath); err != nil {
+			config.logger.
+				With("error", err).
+				With("path", filePath).
+				Error("Unable to remove the stale chart from the cache")
+		}
+	}
+	return true
+}
+
+// saveChartDigest records the digest of the cached chart.  Failures are
+// logged, as the digest only serves the verification of the cache.
+func (config *loaderConfig) saveChartDigest(chartPath string, digest string) {
+	if digest == "" {
+		return
+	}
+	if err := writeChartDigest(chartPath, digest); err != nil {
+		config.logger.
+			With("error", err).
+			Warn("Unable to record the digest of the cached chart")
+	}
+}
@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Chart cache verification", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+	var cacheRoot string
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+
+		var err error
+		cacheRoot, err = os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		ginkgo.DeferCleanup(os.RemoveAll, cacheRoot)
+	})
+
+	ginkgo.It("invalidates cached charts with different digests", func() {
+		config := loaderConfig{logger: logger}
+		chartPath := filepath.Join(cacheRoot, "test-chart-0.1.0")
+		g.Expect(os.MkdirAll(chartPath, 0700)).To(gomega.Succeed())
+
+		g.Expect(writeChartDigest(chartPath, "one")).To(gomega.Succeed())
+		g.Expect(readChartDigest(chartPath)).To(gomega.Equal("one"))
+		g.Expect(config.invalidateCachedChart(chartPath, "one")).To(gomega.BeFalse())
+		g.Expect(chartPath).To(gomega.BeADirectory())
+
+		g.Expect(config.invalidateCachedChart(chartPath, "two")).To(gomega.BeTrue())
+		g.Expect(chartPath).ToNot(gomega.BeAnExistingFile())
+		g.Expect(chartPath + chartDigestSuffix).ToNot(gomega.BeAnExistingFile())
+	})
+
+	ginkgo.It("invalidates cached charts without digests", func() {
+		config := loaderConfig{logger: logger}
+		chartPath := filepath.Join(cacheRoot, "test-chart-0.1.0")
+		g.Expect(os.MkdirAll(chartPath, 0700)).To(gomega.Succeed())
+
+		g.Expect(config.invalidateCachedChart(chartPath, "one")).To(gomega.BeTrue())
+		g.Expect(chartPath).ToNot(gomega.BeAnExistingFile())
+	})
+
+	ginkgo.It("downloads OCI charts published again", func() {
+		chartArchive, err := createChartArchive("test-chart", "0.1.0", map[string]string{
+			"Chart.yaml": strings.Join([]string{
+				"apiVersion: v2",
+				"name: test-chart",
+				"version: 0.1.0",
+			}, "\n"),
+		})
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		input := strings.Join([]string{
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"spec:",
+			"  chart:",
+			"    spec:",
+			"      chart: test-chart",
+			"      version: 0.1.0",
+			"      sourceRef:",
+			"        kind: HelmRepository",
+			"        name: local",
+			"---",
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: HelmRepository",
+			"metadata:",
+			"  namespace: testns",
+			"  name: local",
+			"spec:",
+			"  type: oci",
+			"  insecure: true",
+			"  url: oci://localhost:8888",
+		}, "\n")
+
+		repoClient := &repoClientMock{}
+		// The first run caches the chart, the second one finds it published
+		// again, and the third one finds it unchanged.
+		repoClient.
+			On("Resolve", "localhost:8888/test-chart:0.1.0").
+			Once().
+			Return("sha256:one", nil)
+		repoClient.
+			On("Resolve", "localhost:8888/test-chart:0.1.0").
+			Twice().
+			Return("sha256:two", nil)
+		repoClient.
+			On("Get", "localhost:8888/test-chart:0.1.0").
+			Once().
+			Return(bytes.NewBuffer(chartArchive.Bytes()), nil)
+		repoClient.
+			On("Get", "localhost:8888/test-chart:0.1.0").
+			Once().
+			Return(bytes.NewBuffer(chartArchive.Bytes()), nil)
+
+		for range 3 {
+			expander := NewHelmReleaseExpander(
+				ctx,
+				logger,
+				nil,
+				func(insecure bool, transport *http.Transport) (repositoryClient, error) {
+					return repoClient, nil
+				},
+			).WithCacheVerification(true)
+			err := expander.ExpandHelmReleases(
+				Credentials{},
+				bytes.NewBufferString(input),
+				io.Discard,
+				nil,
+				nil,
+				nil,
+				1,
+				cacheRoot,
+				false,
+			)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+		}
+		repoClient.AssertExpectations(ginkgo.GinkgoT())
+		chartPath := getChartPath(
+			getCachePathForRepo(cacheRoot, "oci://localhost:8888", false),
+			"test-chart",
+			"0.1.0",
+		)
+		g.Expect(readChartDigest(chartPath)).To(gomega.Equal("sha256:two"))
+	})
+})
@@ -0,0 +1,66 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"fmt"
+
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const (
+	// ChartMetadataLabel marks the ConfigMap objects recording the chart of
+	// each HelmRelease, so that they are easy to select in the output.
+	ChartMetadataLabel = "fouskoti.sage.com/chart-metadata"
+	// localConfigAnnotation marks objects which KRM tools like kustomize and
+	// kpt don't apply to the cluster.
+	localConfigAnnotation = "config.kubernetes.io/local-config"
+)
+
+// getChartMetadataName returns the name of the ConfigMap recording the chart
+// of a HelmRelease.
+func getChartMetadataName(releaseName string) string {
+	return releaseName + "-chart-metadata"
+}
+
+// newChartMetadataNode returns a ConfigMap recording the chart the release was
+// expanded from: its name, resolved version, app version, source URL and
+// digest, leaving out empty ones.  It lets reviewers see which chart versions
+// the ranges in the HelmRelease objects resolved to without reading the
+// rendered resources.
+func newChartMetadataNode(release *ExpandedRelease) (*yaml.RNode, error) {
+	node := yaml.NewMapRNode(nil)
+	node.SetApiVersion("v1")
+	node.SetKind("ConfigMap")
+	if err := node.SetName(getChartMetadataName(release.Name)); err != nil {
+		return nil, fmt.Errorf("unable to set chart metadata name: %w", err)
+	}
+	if err := node.SetNamespace(release.Namespace); err != nil {
+		return nil, fmt.Errorf("unable to set chart metadata namespace: %w", err)
+	}
+	if err := node.SetLabels(map[string]string{ChartMetadataLabel: "true"}); err != nil {
+		return nil, fmt.Errorf("unable to set chart metadata labels: %w", err)
+	}
+	if err := node.SetAnnotations(map[string]string{localConfigAnnotation: "true"}); err != nil {
+		return nil, fmt.Errorf("unable to set chart metadata annotations: %w", err)
+	}
+	// Keep the fields in a fixed order so that the output is reproducible.
+	data := yaml.NewMapRNode(nil)
+	fields := []struct{ name, value string }{
+		{"release", release.Namespace + "/" + release.Name},
+		{"chart", release.Chart},
+		{"chartVersion", release.ChartVersion},
+		{"appVersion", release.AppVersion},
+		{"sourceURL", release.SourceURL},
+		{"digest", release.Digest},
+	}
+	for _, field := range fields {
+		if err := data.PipeE(yaml.SetField(field.name, yaml.NewStringRNode(field.value))); err != nil {
+			return nil, fmt.Errorf("unable to set chart metadata %s: %w", field.name, err)
+		}
+	}
+	if err := node.PipeE(yaml.SetField("data", data)); err != nil {
+		return nil, fmt.Errorf("unable to set chart metadata data: %w", err)
+	}
+	return node, nil
+}
@@ -0,0 +1,139 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Chart metadata", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+	})
+
+	ginkgo.It("records the chart in a ConfigMap", func() {
+		node, err := newChartMetadataNode(&ExpandedRelease{
+			Namespace:    "testns",
+			Name:         "test",
+			Chart:        "test-chart",
+			ChartVersion: "1.0",
+			SourceURL:    "https://charts.example.com",
+			Digest:       "sha256:abc",
+		})
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(node.MustString()).To(gomega.Equal(strings.Join([]string{
+			"apiVersion: v1",
+			"kind: ConfigMap",
+			"metadata:",
+			"  name: test-chart-metadata",
+			"  namespace: testns",
+			"  labels:",
+			"    fouskoti.sage.com/chart-metadata: \"true\"",
+			"  annotations:",
+			"    config.kubernetes.io/local-config: \"true\"",
+			"data:",
+			"  release: testns/test",
+			"  chart: test-chart",
+			"  chartVersion: \"1.0\"",
+			"  sourceURL: https://charts.example.com",
+			"  digest: sha256:abc",
+			"",
+		}, "\n")))
+	})
+
+	ginkgo.It("adds the metadata of each release to the output if requested", func() {
+		repoRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(repoRoot)
+		server, port, serverDone, err := serveDirectory(repoRoot, logger, nil)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer func() {
+			g.Expect(stopServing(server, serverDone)).To(gomega.Succeed())
+		}()
+
+		err = createSingleChartHelmRepository(
+			"test-chart",
+			"0.1.0",
+			map[string]string{
+				"Chart.yaml": strings.Join([]string{
+					"apiVersion: v2",
+					"name: test-chart",
+					"version: 0.1.0",
+					"appVersion: 2.3.4",
+				}, "\n"),
+				"templates/configmap.yaml": strings.Join([]string{
+					"apiVersion: v1",
+					"kind: ConfigMap",
+					"metadata:",
+					"  name: {{ .Release.Name }}-configmap",
+				}, "\n"),
+			},
+			port,
+			repoRoot,
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		input := strings.Join([]string{
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: HelmRepository",
+			"metadata:",
+			"  namespace: testns",
+			"  name: local",
+			"spec:",
+			fmt.Sprintf("  url: http://localhost:%d", port),
+			"---",
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"spec:",
+			"  chart:",
+			"    spec:",
+			"      chart: test-chart",
+			"      version: ^0.1",
+			"      sourceRef:",
+			"        kind: HelmRepository",
+			"        name: local",
+		}, "\n")
+
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		var output bytes.Buffer
+		err = expander.Expand(
+			bytes.NewBufferString(input),
+			&output,
+			ExpandOptions{ChartMetadata: true},
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(output.String()).To(gomega.ContainSubstring(strings.Join([]string{
+			"data:",
+			"  release: testns/test",
+			"  chart: test-chart",
+			"  chartVersion: 0.1.0",
+			"  appVersion: 2.3.4",
+			fmt.Sprintf("  sourceURL: http://localhost:%d", port),
+			"  digest: sha256:",
+		}, "\n")))
+
+		output.Reset()
+		err = expander.Expand(bytes.NewBufferString(input), &output, ExpandOptions{})
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(output.String()).ToNot(gomega.ContainSubstring("test-chart-metadata"))
+	})
+})
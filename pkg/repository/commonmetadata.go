@@ -0,0 +1,44 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// addCommonMetadata sets the labels and the annotations on the generated
+// nodes, overriding the ones the charts set.  Only the metadata of the objects
+// is changed, not the selectors or the templates in them.
+func addCommonMetadata(
+	nodes []*yaml.RNode,
+	labels map[string]string,
+	annotations map[string]string,
+) error {
+	for _, node := range nodes {
+		filters := []yaml.Filter{}
+		for _, key := range slices.Sorted(maps.Keys(labels)) {
+			filters = append(filters, yaml.SetLabel(key, labels[key]))
+		}
+		for _, key := range slices.Sorted(maps.Keys(annotations)) {
+			filters = append(filters, yaml.SetAnnotation(key, annotations[key]))
+		}
+		// Each setter is applied to the object itself, not to the result of
+		// the previous one.
+		for _, filter := range filters {
+			if err := node.PipeE(filter); err != nil {
+				return fmt.Errorf(
+					"unable to add common metadata to %s %s/%s: %w",
+					node.GetKind(),
+					node.GetNamespace(),
+					node.GetName(),
+					err,
+				)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+var _ = ginkgo.Describe("Common metadata", func() {
+	var g gomega.Gomega
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+	})
+
+	ginkgo.It("sets labels and annotations on the object metadata only", func() {
+		node := yaml.MustParse(strings.Join([]string{
+			"apiVersion: apps/v1",
+			"kind: Deployment",
+			"metadata:",
+			"  name: app",
+			"  labels:",
+			"    env: dev",
+			"spec:",
+			"  selector:",
+			"    matchLabels:",
+			"      app: app",
+			"  template:",
+			"    metadata:",
+			"      labels:",
+			"        app: app",
+		}, "\n"))
+		err := addCommonMetadata(
+			[]*yaml.RNode{node},
+			map[string]string{"env": "prod", "team": "platform"},
+			map[string]string{"build": "42"},
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(node.GetLabels()).To(gomega.Equal(map[string]string{
+			"env":  "prod",
+			"team": "platform",
+		}))
+		g.Expect(node.GetAnnotations()).To(gomega.Equal(map[string]string{"build": "42"}))
+		g.Expect(node.MustString()).To(gomega.ContainSubstring(strings.Join([]string{
+			"spec:",
+			"  selector:",
+			"    matchLabels:",
+			"      app: app",
+			"  template:",
+			"    metadata:",
+			"      labels:",
+			"        app: app",
+		}, "\n")))
+	})
+})
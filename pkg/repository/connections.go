@@ -0,0 +1,159 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+)
+
+// connectionPool shares the HTTP transports, and so their keep-alive
+// connections, the cloud provider registry tokens, and the logged-in registry
+// clients between the fetches of a run, so that fetching many charts from a
+// repository connects and logs in once.  It is safe for concurrent use, and a
+// nil pool shares nothing.
+type connectionPool struct {
+	mutex           sync.Mutex
+	transports      map[string]*http.Transport
+	providerAuths   map[providerAuthKey]*providerAuthEntry
+	registryClients map[registryClientKey]*registryClientEntry
+}
+
+// providerAuthKey identifies a cloud provider registry login.
+type providerAuthKey struct {
+	providerName string
+	registryHost string
+}
+
+type providerAuthEntry struct {
+	once       sync.Once
+	authConfig *authn.AuthConfig
+	err        error
+}
+
+// registryClientKey identifies a registry client by its settings and the
+// registry it is logged in to.
+type registryClientKey struct {
+	insecure     bool
+	transportKey string
+	registryHost string
+	username     string
+	password     string
+}
+
+type registryClientEntry struct {
+	once   sync.Once
+	client repositoryClient
+	err    error
+}
+
+func newConnectionPool() *connectionPool {
+	return &connectionPool{
+		transports:      map[string]*http.Transport{},
+		providerAuths:   map[providerAuthKey]*providerAuthEntry{},
+		registryClients: map[registryClientKey]*registryClientEntry{},
+	}
+}
+
+// getTransportKey returns the key identifying the TLS settings in the
+// credentials, or an empty one if they have none.
+func getTransportKey(credentials map[string]string) string {
+	caData := cmp.Or(credentials["ca.crt"], credentials["caFile"])
+	certData := cmp.Or(credentials["tls.crt"], credentials["certFile"])
+	keyData := cmp.Or(credentials["tls.key"], credentials["keyFile"])
+	if caData == "" && certData == "" && keyData == "" {
+		return ""
+	}
+	hash := sha256.New()
+	for _, data := range []string{caData, certData, keyData} {
+		hash.Write([]byte(data))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// getTransport returns the transport shared by the repositories with the TLS
+// settings in the credentials, as newHelmRepoTransport describes.  Without
+// TLS settings, it returns a transport with the default ones, and without a
+// pool, nil.
+func (pool *connectionPool) getTransport(credentials map[string]string) (*http.Transport, error) {
+	if pool == nil {
+		return newHelmRepoTransport(credentials)
+	}
+	key := getTransportKey(credentials)
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	if transport, ok := pool.transports[key]; ok {
+		return transport, nil
+	}
+	transport, err := newHelmRepoTransport(credentials)
+	if err != nil {
+		return nil, err
+	}
+	if transport == nil {
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	}
+	// Like the Helm getters, don't let the transport decompress the gzipped
+	// charts.
+	transport.DisableCompression = true
+	pool.transports[key] = transport
+	return transport, nil
+}
+
+// getProviderAuth returns the credentials from the cloud provider login to
+// the registry, logging in with the function the first time.  Failed logins
+// are not retried within the run.
+func (pool *connectionPool) getProviderAuth(
+	key providerAuthKey,
+	login func() (*authn.AuthConfig, error),
+) (*authn.AuthConfig, error) {
+	if pool == nil {
+		return login()
+	}
+	pool.mutex.Lock()
+	entry, ok := pool.providerAuths[key]
+	if !ok {
+		entry = &providerAuthEntry{}
+		pool.providerAuths[key] = entry
+	}
+	pool.mutex.Unlock()
+	entry.once.Do(func() {
+		entry.authConfig, entry.err = login()
+	})
+	return entry.authConfig, entry.err
+}
+
+// getRegistryClient returns the registry client with the settings logged in
+// to the registry, creating and logging it in with the function the first
+// time.  Clients failing to log in are not cached, so that they are retried.
+func (pool *connectionPool) getRegistryClient(
+	key registryClientKey,
+	create func() (repositoryClient, error),
+) (repositoryClient, error) {
+	if pool == nil {
+		return create()
+	}
+	pool.mutex.Lock()
+	entry, ok := pool.registryClients[key]
+	if !ok {
+		entry = &registryClientEntry{}
+		pool.registryClients[key] = entry
+	}
+	pool.mutex.Unlock()
+	entry.once.Do(func() {
+		entry.client, entry.err = create()
+	})
+	if entry.err != nil {
+		pool.mutex.Lock()
+		if pool.registryClients[key] == entry {
+			delete(pool.registryClients, key)
+		}
+		pool.mutex.Unlock()
+	}
+	return entry.client, entry.err
+}
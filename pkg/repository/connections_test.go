@@ -0,0 +1,129 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Connection pool", func() {
+	var g gomega.Gomega
+	var logger *slog.Logger
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+	})
+
+	ginkgo.It("shares transports between repositories", func() {
+		pool := newConnectionPool()
+		transport, err := pool.getTransport(nil)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(transport).ToNot(gomega.BeNil())
+		g.Expect(transport.DisableCompression).To(gomega.BeTrue())
+
+		other, err := pool.getTransport(map[string]string{"username": "user"})
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(other).To(gomega.BeIdenticalTo(transport))
+
+		_, err = pool.getTransport(map[string]string{"ca.crt": "not a certificate"})
+		g.Expect(err).To(gomega.HaveOccurred())
+	})
+
+	ginkgo.It("uses the default transport without a pool", func() {
+		var pool *connectionPool
+		transport, err := pool.getTransport(nil)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(transport).To(gomega.BeNil())
+	})
+
+	ginkgo.It("logs in to each registry once", func() {
+		repoClient := &repoClientMock{}
+		repoClient.
+			On("Login", "registry.example.com", "user", "pass").
+			Return(nil).
+			Once()
+		factoryCalls := 0
+		loader := &ociRepoChartLoader{loaderConfig{
+			ctx:    context.Background(),
+			logger: logger,
+			repoClientFactory: func(insecure bool, transport *http.Transport) (repositoryClient, error) {
+				factoryCalls++
+				return repoClient, nil
+			},
+			credentials: Credentials{
+				"registry.example.com": {
+					Credentials: map[string]string{"username": "user", "password": "pass"},
+				},
+			},
+			connections: newConnectionPool(),
+		}}
+
+		for _, repoURL := range []string{
+			"oci://registry.example.com/charts",
+			"oci://registry.example.com/other-charts",
+		} {
+			client, err := loader.getRepositoryClient(nil, repoURL)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+			g.Expect(client).To(gomega.BeIdenticalTo(repoClient))
+		}
+		g.Expect(factoryCalls).To(gomega.Equal(1))
+		repoClient.AssertExpectations(ginkgo.GinkgoT())
+	})
+
+	ginkgo.It("retries failed registry logins", func() {
+		repoClient := &repoClientMock{}
+		repoClient.
+			On("Login", "registry.example.com", "user", "pass").
+			Return(errors.New("unauthorized")).
+			Once()
+		repoClient.
+			On("Login", "registry.example.com", "user", "pass").
+			Return(nil).
+			Once()
+		loader := &ociRepoChartLoader{loaderConfig{
+			ctx:    context.Background(),
+			logger: logger,
+			repoClientFactory: func(insecure bool, transport *http.Transport) (repositoryClient, error) {
+				return repoClient, nil
+			},
+			credentials: Credentials{
+				"registry.example.com": {
+					Credentials: map[string]string{"username": "user", "password": "pass"},
+				},
+			},
+			connections: newConnectionPool(),
+		}}
+
+		_, err := loader.getRepositoryClient(nil, "oci://registry.example.com/charts")
+		g.Expect(err).To(gomega.HaveOccurred())
+		_, err = loader.getRepositoryClient(nil, "oci://registry.example.com/charts")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		repoClient.AssertExpectations(ginkgo.GinkgoT())
+	})
+
+	ginkgo.It("logs in to cloud providers once", func() {
+		pool := newConnectionPool()
+		logins := 0
+		login := func() (*authn.AuthConfig, error) {
+			logins++
+			return &authn.AuthConfig{Username: "user", Password: "token"}, nil
+		}
+		key := providerAuthKey{providerName: "aws", registryHost: "registry.example.com"}
+		for range 2 {
+			authConfig, err := pool.getProviderAuth(key, login)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+			g.Expect(authConfig.Password).To(gomega.Equal("token"))
+		}
+		g.Expect(logins).To(gomega.Equal(1))
+	})
+})
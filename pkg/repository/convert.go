@@ -0,0 +1,106 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"fmt"
+	"slices"
+
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// fluxAPIVersions maps the older Flux API versions accepted in the input to
+// the versions their objects are decoded as.
+var fluxAPIVersions = map[string]string{
+	"helm.toolkit.fluxcd.io/v2beta1":   "helm.toolkit.fluxcd.io/v2",
+	"helm.toolkit.fluxcd.io/v2beta2":   "helm.toolkit.fluxcd.io/v2",
+	"source.toolkit.fluxcd.io/v1beta2": "source.toolkit.fluxcd.io/v1",
+}
+
+// getCurrentFluxAPIVersion returns the current API version for an older Flux
+// API version, or the API version itself.
+func getCurrentFluxAPIVersion(apiVersion string) string {
+	if current, ok := fluxAPIVersions[apiVersion]; ok {
+		return current
+	}
+	return apiVersion
+}
+
+// moveValuesFile moves the deprecated valuesFile field of the chart spec at
+// the path to the front of its valuesFiles, where the file was merged.
+func moveValuesFile(node *yaml.RNode, path ...string) error {
+	chartSpec, err := node.Pipe(yaml.Lookup(path...))
+	if err != nil {
+		return fmt.Errorf("unable to get chart spec: %w", err)
+	}
+	if chartSpec == nil {
+		return nil
+	}
+	valuesFile := chartSpec.Field("valuesFile")
+	if valuesFile == nil {
+		return nil
+	}
+	fileName := yaml.GetValue(valuesFile.Value)
+	if err := chartSpec.PipeE(yaml.Clear("valuesFile")); err != nil {
+		return fmt.Errorf("unable to remove valuesFile: %w", err)
+	}
+	if fileName == "" {
+		return nil
+	}
+
+	valuesFiles, err := chartSpec.Pipe(yaml.LookupCreate(yaml.SequenceNode, "valuesFiles"))
+	if err != nil {
+		return fmt.Errorf("unable to get valuesFiles: %w", err)
+	}
+	fileNames := []string{}
+	for _, element := range valuesFiles.Content() {
+		fileNames = append(fileNames, element.Value)
+	}
+	if !slices.Contains(fileNames, fileName) {
+		valuesFiles.YNode().Content = slices.Insert(
+			valuesFiles.YNode().Content,
+			0,
+			yaml.NewStringRNode(fileName).YNode(),
+		)
+	}
+	return nil
+}
+
+// convertFluxObject returns the node converted to the current version of its
+// Flux API, renaming the fields changed since the older version, or the node
+// itself if it already uses the current version.
+func convertFluxObject(node *yaml.RNode) (*yaml.RNode, error) {
+	current, ok := fluxAPIVersions[node.GetApiVersion()]
+	if !ok {
+		return node, nil
+	}
+
+	result := node.Copy()
+	err := result.PipeE(yaml.SetField("apiVersion", yaml.NewScalarRNode(current)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to set apiVersion: %w", err)
+	}
+	switch result.GetKind() {
+	case "HelmRelease":
+		if err := moveValuesFile(result, "spec", "chart", "spec"); err != nil {
+			return nil, err
+		}
+		sourceRef, err := result.Pipe(yaml.Lookup("spec", "chart", "spec", "sourceRef"))
+		if err != nil {
+			return nil, fmt.Errorf("unable to get chart sourceRef: %w", err)
+		}
+		if sourceRef == nil {
+			break
+		}
+		if apiVersion := sourceRef.Field("apiVersion"); apiVersion != nil {
+			apiVersion.Value.YNode().Value = getCurrentFluxAPIVersion(
+				yaml.GetValue(apiVersion.Value),
+			)
+		}
+	case "HelmChart":
+		if err := moveValuesFile(result, "spec"); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
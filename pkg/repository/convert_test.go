@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"bytes"
+	"strings"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+var _ = ginkgo.Describe("Older Flux API versions", func() {
+	var g gomega.Gomega
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+	})
+
+	readNodes := func(lines ...string) []*yaml.RNode {
+		nodes, err := (&kio.ByteReader{
+			Reader:                bytes.NewBufferString(strings.Join(lines, "\n")),
+			OmitReaderAnnotations: true,
+		}).Read()
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		return nodes
+	}
+
+	ginkgo.It("decodes v2beta1 HelmRelease objects as v2", func() {
+		nodes := readNodes(
+			"apiVersion: helm.toolkit.fluxcd.io/v2beta1",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"spec:",
+			"  chart:",
+			"    spec:",
+			"      chart: test-chart",
+			"      valuesFile: values-base.yaml",
+			"      valuesFiles:",
+			"      - values-prod.yaml",
+			"      sourceRef:",
+			"        apiVersion: source.toolkit.fluxcd.io/v1beta2",
+			"        kind: HelmRepository",
+			"        name: local",
+		)
+		original := nodes[0].MustString()
+
+		var release helmv2.HelmRelease
+		g.Expect(decodeToObject(nodes[0], &release)).To(gomega.Succeed())
+		g.Expect(release.Spec.Chart.Spec.ValuesFiles).To(gomega.Equal(
+			[]string{"values-base.yaml", "values-prod.yaml"},
+		))
+		g.Expect(release.Spec.Chart.Spec.SourceRef.APIVersion).To(gomega.Equal(
+			"source.toolkit.fluxcd.io/v1",
+		))
+		// The input object is left as is.
+		g.Expect(nodes[0].MustString()).To(gomega.Equal(original))
+	})
+
+	ginkgo.It("decodes v1beta2 sources as v1", func() {
+		nodes := readNodes(
+			"apiVersion: source.toolkit.fluxcd.io/v1beta2",
+			"kind: HelmChart",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"spec:",
+			"  chart: test-chart",
+			"  valuesFile: values-base.yaml",
+			"  sourceRef:",
+			"    kind: HelmRepository",
+			"    name: local",
+		)
+
+		var helmChart sourcev1.HelmChart
+		g.Expect(decodeToObject(nodes[0], &helmChart)).To(gomega.Succeed())
+		g.Expect(helmChart.Spec.ValuesFiles).To(gomega.Equal(
+			[]string{"values-base.yaml"},
+		))
+	})
+
+	ginkgo.It("finds sources referenced with another API version", func() {
+		nodes := readNodes(
+			"apiVersion: helm.toolkit.fluxcd.io/v2beta2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"spec:",
+			"  chart:",
+			"    spec:",
+			"      chart: test-chart",
+			"      sourceRef:",
+			"        apiVersion: source.toolkit.fluxcd.io/v1beta2",
+			"        kind: HelmRepository",
+			"        name: local",
+			"---",
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: HelmRepository",
+			"metadata:",
+			"  namespace: testns",
+			"  name: local",
+			"spec:",
+			"  url: https://charts.example.com",
+		)
+
+		repo, err := getRepositoryForHelmRelease(newSourceIndex(nodes), nodes[0])
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(repo).To(gomega.Equal(nodes[1]))
+	})
+})
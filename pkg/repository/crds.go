@@ -0,0 +1,107 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	chart "helm.sh/helm/v4/pkg/chart/v2"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// CRDsMode determines whether the custom resource definitions in the crds
+// directories of the charts are added to the output.
+type CRDsMode string
+
+const (
+	// CRDsModeNone leaves the custom resource definitions out, the way helm
+	// template does by default.
+	CRDsModeNone CRDsMode = "none"
+	// CRDsModeInstall adds the custom resource definitions of the releases
+	// whose spec.install policies create them, as Flux does on install.
+	CRDsModeInstall CRDsMode = "install"
+	// CRDsModeUpgrade adds the custom resource definitions of the releases
+	// whose spec.upgrade policies create them, as Flux does on upgrade.
+	CRDsModeUpgrade CRDsMode = "upgrade"
+)
+
+// ParseCRDsMode parses the CRDs mode given as a string.
+func ParseCRDsMode(value string) (CRDsMode, error) {
+	switch mode := CRDsMode(value); mode {
+	case CRDsModeNone, CRDsModeInstall, CRDsModeUpgrade:
+		return mode, nil
+	default:
+		return "", fmt.Errorf(
+			"invalid CRDs mode %s, expected %s, %s, or %s",
+			value,
+			CRDsModeNone,
+			CRDsModeInstall,
+			CRDsModeUpgrade,
+		)
+	}
+}
+
+// getCRDsPolicy returns the policy of the release for its custom resource
+// definitions in the mode, with the Flux defaults: Create on install, unless
+// the deprecated spec.install.skipCRDs is set, and Skip on upgrade.
+func getCRDsPolicy(release *helmv2.HelmRelease, mode CRDsMode) helmv2.CRDsPolicy {
+	switch mode {
+	case CRDsModeInstall:
+		install := release.Spec.Install
+		switch {
+		case install == nil:
+			return helmv2.Create
+		case install.CRDs != "":
+			return install.CRDs
+		case install.SkipCRDs:
+			return helmv2.Skip
+		default:
+			return helmv2.Create
+		}
+	case CRDsModeUpgrade:
+		if upgrade := release.Spec.Upgrade; upgrade != nil && upgrade.CRDs != "" {
+			return upgrade.CRDs
+		}
+		return helmv2.Skip
+	default:
+		return helmv2.Skip
+	}
+}
+
+// getCRDNodes returns the custom resource definitions in the crds directories
+// of the chart and its enabled dependencies if the policy of the release in
+// the mode creates them.
+func getCRDNodes(
+	loadedChart *chart.Chart,
+	release *helmv2.HelmRelease,
+	mode CRDsMode,
+) ([]*yaml.RNode, error) {
+	if getCRDsPolicy(release, mode) == helmv2.Skip {
+		return nil, nil
+	}
+	crds := loadedChart.CRDObjects()
+	slices.SortFunc(crds, func(a, b chart.CRD) int {
+		return strings.Compare(a.Filename, b.Filename)
+	})
+	result := []*yaml.RNode{}
+	for _, crd := range crds {
+		nodes, err := (&kio.ByteReader{Reader: bytes.NewReader(crd.File.Data)}).Read()
+		if err != nil {
+			return nil, NewClassifiedError(ErrorClassRender, fmt.Errorf(
+				"unable to parse custom resource definitions %s: %w",
+				crd.Filename,
+				err,
+			))
+		}
+		for _, node := range nodes {
+			node.YNode().HeadComment = fmt.Sprintf("Source: %s", crd.Filename)
+			result = append(result, node)
+		}
+	}
+	return result, nil
+}
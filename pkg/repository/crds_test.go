@@ -0,0 +1,85 @@
+package repository
+
+import (
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"helm.sh/helm/v4/pkg/chart/common"
+	chart "helm.sh/helm/v4/pkg/chart/v2"
+)
+
+var _ = ginkgo.Describe("Custom resource definitions", func() {
+	var g gomega.Gomega
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+	})
+
+	getCRD := func(name string) []byte {
+		return []byte("apiVersion: apiextensions.k8s.io/v1\n" +
+			"kind: CustomResourceDefinition\n" +
+			"metadata:\n  name: " + name + "\n")
+	}
+
+	testChart := &chart.Chart{
+		Metadata: &chart.Metadata{Name: "test-chart", Version: "0.1.0"},
+		Files: []*common.File{
+			{Name: "crds/widgets.yaml", Data: getCRD("widgets.example.com")},
+			{Name: "crds/gadgets.yaml", Data: getCRD("gadgets.example.com")},
+			{Name: "README.md", Data: []byte("# Test chart")},
+		},
+	}
+
+	getRelease := func(install *helmv2.Install, upgrade *helmv2.Upgrade) *helmv2.HelmRelease {
+		return &helmv2.HelmRelease{Spec: helmv2.HelmReleaseSpec{
+			Install: install,
+			Upgrade: upgrade,
+		}}
+	}
+
+	getNames := func(release *helmv2.HelmRelease, mode CRDsMode) []string {
+		nodes, err := getCRDNodes(testChart, release, mode)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		names := []string{}
+		for _, node := range nodes {
+			names = append(names, node.GetName())
+		}
+		return names
+	}
+
+	ginkgo.It("follows the install policies of the releases", func() {
+		g.Expect(getNames(getRelease(nil, nil), CRDsModeInstall)).To(gomega.Equal([]string{
+			"gadgets.example.com",
+			"widgets.example.com",
+		}))
+		g.Expect(getNames(
+			getRelease(&helmv2.Install{SkipCRDs: true}, nil),
+			CRDsModeInstall,
+		)).To(gomega.BeEmpty())
+		g.Expect(getNames(
+			getRelease(&helmv2.Install{SkipCRDs: true, CRDs: helmv2.CreateReplace}, nil),
+			CRDsModeInstall,
+		)).To(gomega.HaveLen(2))
+		g.Expect(getNames(
+			getRelease(&helmv2.Install{CRDs: helmv2.Skip}, nil),
+			CRDsModeInstall,
+		)).To(gomega.BeEmpty())
+	})
+
+	ginkgo.It("follows the upgrade policies of the releases", func() {
+		g.Expect(getNames(getRelease(nil, nil), CRDsModeUpgrade)).To(gomega.BeEmpty())
+		g.Expect(getNames(
+			getRelease(nil, &helmv2.Upgrade{CRDs: helmv2.Create}),
+			CRDsModeUpgrade,
+		)).To(gomega.HaveLen(2))
+	})
+
+	ginkgo.It("leaves the definitions out by default", func() {
+		g.Expect(getNames(getRelease(nil, nil), CRDsModeNone)).To(gomega.BeEmpty())
+		mode, err := ParseCRDsMode("install")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(mode).To(gomega.Equal(CRDsModeInstall))
+		_, err = ParseCRDsMode("all")
+		g.Expect(err).To(gomega.HaveOccurred())
+	})
+})
@@ -3,16 +3,29 @@
 package repository
 
 import (
+	"cmp"
+	"context"
 	"fmt"
 	"io"
+	"maps"
 	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
+// RepositoryConfig holds the settings of the repositories matching an entry
+// of the credentials file.
 type RepositoryConfig struct {
+	// FullClone overrides GitCloneOptions.FullClone for Git repositories.
+	FullClone *bool `yaml:"fullClone,omitempty"`
+	// AllBranches overrides GitCloneOptions.AllBranches for Git
+	// repositories.
+	AllBranches *bool `yaml:"allBranches,omitempty"`
 }
 
 type RepositoryCreds struct {
@@ -29,54 +42,371 @@ func (creds *RepositoryCreds) AsBytesMap() map[string][]byte {
 	return result
 }
 
+// envVarReference matches the ${ENV_VAR} references in credentials values
+// and the $$ escapes of dollar signs.
+var envVarReference = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// hasEnvVarReferences tells whether the value has ${ENV_VAR} references
+// outside of $$ escapes.
+func hasEnvVarReferences(value string) bool {
+	for _, match := range envVarReference.FindAllStringSubmatch(value, -1) {
+		if match[1] != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// expandEnvVars replaces the values consisting of a $ENV_VAR reference, and
+// the ${ENV_VAR} references within other values, with the values of the
+// environment variables, which are empty for unset ones.  $$ stands for a
+// dollar sign only within values with ${ENV_VAR} references, so that other
+// values, like passwords with dollar signs, are taken literally.
 func (creds RepositoryCreds) expandEnvVars() {
 	for key, value := range creds.Credentials {
-		if rest, found := strings.CutPrefix(value, "$"); found && len(rest) > 0 {
-			creds.Credentials[key] = os.Getenv(rest)
+		if variable, ok := getEnvVarValue(value); ok {
+			creds.Credentials[key] = os.Getenv(variable)
+			continue
 		}
+		if !hasEnvVarReferences(value) {
+			continue
+		}
+		creds.Credentials[key] = envVarReference.ReplaceAllStringFunc(
+			value,
+			func(reference string) string {
+				if reference == "$$" {
+					return "$"
+				}
+				return os.Getenv(reference[2 : len(reference)-1])
+			},
+		)
 	}
 }
 
+// getEnvVarValue returns the environment variable of the values consisting
+// of a $ENV_VAR reference.
+func getEnvVarValue(value string) (string, bool) {
+	rest, found := strings.CutPrefix(value, "$")
+	if found && len(rest) > 0 && !strings.HasPrefix(rest, "{") && !strings.HasPrefix(rest, "$") {
+		return rest, true
+	}
+	return "", false
+}
+
+// getEnvVars returns the environment variables the values reference.
+func (creds RepositoryCreds) getEnvVars() []string {
+	var result []string
+	for _, value := range creds.Credentials {
+		if variable, ok := getEnvVarValue(value); ok {
+			result = append(result, variable)
+			continue
+		}
+		for _, match := range envVarReference.FindAllStringSubmatch(value, -1) {
+			if match[1] != "" {
+				result = append(result, match[1])
+			}
+		}
+	}
+	return result
+}
+
 type Credentials map[string]RepositoryCreds
 
-func ReadCredentials(input io.Reader) (Credentials, error) {
+func ReadCredentials(ctx context.Context, input io.Reader) (Credentials, error) {
+	credentials, _, err := ReadCredentialsFile(ctx, input)
+	return credentials, err
+}
+
+// ReadCredentialsFile reads both the repository credentials and the mirrors
+// section of the credentials file, fetching the AWS secrets and parameters
+// the credentials refer to within the context.
+func ReadCredentialsFile(ctx context.Context, input io.Reader) (Credentials, Mirrors, error) {
 	bytes, err := io.ReadAll(input)
 	if err != nil {
-		return nil, fmt.Errorf("unable to read input: %w", err)
+		return nil, nil, fmt.Errorf("unable to read input: %w", err)
 	}
 
-	credentials := Credentials{}
-	err = yaml.Unmarshal(bytes, credentials)
+	content := map[string]yaml.Node{}
+	err = yaml.Unmarshal(bytes, content)
 	if err != nil {
-		return nil, fmt.Errorf("unable to parse credentials YAML: %w", err)
+		return nil, nil, fmt.Errorf("unable to parse credentials YAML: %w", err)
 	}
 
-	for _, value := range credentials {
+	credentials := Credentials{}
+	var mirrors Mirrors
+	resolver := newAWSSecretResolver()
+	for key, node := range content {
+		if key == mirrorsKey {
+			if err := node.Decode(&mirrors); err != nil {
+				return nil, nil, fmt.Errorf("unable to parse mirrors YAML: %w", err)
+			}
+			continue
+		}
+		var value RepositoryCreds
+		if err := node.Decode(&value); err != nil {
+			return nil, nil, fmt.Errorf(
+				"unable to parse credentials YAML for %s: %w",
+				key,
+				err,
+			)
+		}
 		value.expandEnvVars()
+		if err := value.resolveSecretReferences(ctx, resolver); err != nil {
+			return nil, nil, fmt.Errorf("unable to resolve credentials for %s: %w", key, err)
+		}
+		credentials[key] = value
+	}
+
+	mirrors, err = mirrors.normalize()
+	if err != nil {
+		return nil, nil, err
+	}
+	return credentials, mirrors, nil
+}
+
+// getCredentialsFileNames returns the files the paths name, replacing the
+// directories with the YAML files in them in the order of their names.
+func getCredentialsFileNames(paths []string) ([]string, error) {
+	var result []string
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open credentials file %s: %w", path, err)
+		}
+		if !info.IsDir() {
+			result = append(result, path)
+			continue
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read credentials directory %s: %w", path, err)
+		}
+		// The entries are sorted by name.
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || strings.HasPrefix(name, ".") {
+				continue
+			}
+			switch filepath.Ext(name) {
+			case ".yaml", ".yml":
+				result = append(result, filepath.Join(path, name))
+			}
+		}
 	}
-	return credentials, nil
+	return result, nil
 }
 
+// ReadCredentialsFiles reads the credentials and the mirrors from the files
+// and from the YAML files in the directories the paths name, merging them.
+// The entries of later files replace the entries of earlier ones for the same
+// repository URLs or mirrored prefixes, so that local files listed last can
+// override shared ones.
+func ReadCredentialsFiles(ctx context.Context, paths []string) (Credentials, Mirrors, error) {
+	fileNames, err := getCredentialsFileNames(paths)
+	if err != nil {
+		return nil, nil, err
+	}
+	credentials := Credentials{}
+	var mirrors Mirrors
+	for _, fileName := range fileNames {
+		fileCredentials, fileMirrors, err := func() (Credentials, Mirrors, error) {
+			file, err := os.Open(fileName)
+			if err != nil {
+				return nil, nil, fmt.Errorf(
+					"unable to open credentials file %s: %w",
+					fileName,
+					err,
+				)
+			}
+			defer func() { _ = file.Close() }()
+			fileCredentials, fileMirrors, err := ReadCredentialsFile(ctx, file)
+			if err != nil {
+				return nil, nil, fmt.Errorf(
+					"unable to read credentials from %s: %w",
+					fileName,
+					err,
+				)
+			}
+			return fileCredentials, fileMirrors, nil
+		}()
+		if err != nil {
+			return nil, nil, err
+		}
+		maps.Copy(credentials, fileCredentials)
+		if len(fileMirrors) > 0 {
+			if mirrors == nil {
+				mirrors = Mirrors{}
+			}
+			maps.Copy(mirrors, fileMirrors)
+		}
+	}
+	return credentials, mirrors, nil
+}
+
+// GetCredentialsEnvVars returns the environment variables referenced by the
+// credentials in the files and in the YAML files in the directories the paths
+// name, sorted, without resolving the credentials.
+func GetCredentialsEnvVars(paths []string) ([]string, error) {
+	fileNames, err := getCredentialsFileNames(paths)
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for _, fileName := range fileNames {
+		bytes, err := os.ReadFile(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read credentials file %s: %w", fileName, err)
+		}
+		content := map[string]yaml.Node{}
+		if err := yaml.Unmarshal(bytes, content); err != nil {
+			return nil, fmt.Errorf("unable to parse credentials YAML in %s: %w", fileName, err)
+		}
+		for key, node := range content {
+			if key == mirrorsKey {
+				continue
+			}
+			var value RepositoryCreds
+			if err := node.Decode(&value); err != nil {
+				return nil, fmt.Errorf(
+					"unable to parse credentials YAML for %s in %s: %w",
+					key,
+					fileName,
+					err,
+				)
+			}
+			result = append(result, value.getEnvVars()...)
+		}
+	}
+	slices.Sort(result)
+	return slices.Compact(result), nil
+}
+
+// credentialsPattern is a key of the credentials file as a pattern of the
+// repository URLs the credentials apply to.
+type credentialsPattern struct {
+	// scheme is empty for bare host patterns, which match URLs of any scheme
+	// and user.
+	scheme   string
+	username string
+	// host matches the host of URLs, with * standing for any characters.
+	host *regexp.Regexp
+	// literalLength is the number of characters of the host pattern other
+	// than wildcards.
+	literalLength int
+	wildcard      bool
+	// path is the path prefix, without the trailing slash.
+	path string
+}
+
+// parseCredentialsPattern parses the key of the credentials file, a URL or a
+// bare host, whose host can contain * wildcards.
+func parseCredentialsPattern(key string) (*credentialsPattern, error) {
+	text := key
+	if !strings.Contains(text, "://") {
+		text = "//" + text
+	}
+	parsedURL, err := url.Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	host := strings.ToLower(parsedURL.Host)
+	parts := strings.Split(host, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return &credentialsPattern{
+		scheme:        parsedURL.Scheme,
+		username:      parsedURL.User.Username(),
+		host:          regexp.MustCompile("^" + strings.Join(parts, ".*") + "$"),
+		literalLength: len(host) - len(parts) + 1,
+		wildcard:      len(parts) > 1,
+		path:          strings.TrimSuffix(parsedURL.Path, "/"),
+	}, nil
+}
+
+// matches tells whether the pattern matches the scheme, the user, and the
+// host of the URL, and whether its path prefix matches the path of the URL
+// too, up to a path segment boundary.
+func (pattern *credentialsPattern) matches(repoURL *url.URL) (bool, bool) {
+	if pattern.scheme != "" &&
+		(pattern.scheme != repoURL.Scheme || pattern.username != repoURL.User.Username()) {
+		return false, false
+	}
+	if !pattern.host.MatchString(strings.ToLower(repoURL.Host)) {
+		return false, false
+	}
+	rest, found := strings.CutPrefix(repoURL.Path, pattern.path)
+	return true, found && (rest == "" || strings.HasPrefix(rest, "/"))
+}
+
+// FindForRepo returns the credentials for the repository URL, or nil if
+// there are none.  A key equal to the URL wins.  Otherwise, the most specific
+// of the keys matching the scheme, the user, and the host of the URL wins:
+// keys whose paths are prefixes of the path of the URL before the others,
+// longer paths first, then keys with exact hosts before the ones with
+// wildcards, which win by the number of characters other than wildcards, and
+// then keys with schemes before bare hosts.
 func (credentials Credentials) FindForRepo(
 	repoURL *url.URL,
 ) (*RepositoryCreds, error) {
 	if creds, ok := credentials[repoURL.String()]; ok {
 		return &creds, nil
 	}
-	for storedRepoURL, creds := range credentials {
-		parsedURL, err := url.Parse(storedRepoURL)
+	type match struct {
+		key         string
+		pattern     *credentialsPattern
+		pathMatches bool
+	}
+	var best *match
+	// Compare two matches, returning a positive number if the first one is
+	// more specific.
+	compare := func(a *match, b *match) int {
+		return cmp.Or(
+			compareBool(a.pathMatches, b.pathMatches),
+			cmp.Compare(len(a.pattern.path), len(b.pattern.path)),
+			compareBool(!a.pattern.wildcard, !b.pattern.wildcard),
+			cmp.Compare(a.pattern.literalLength, b.pattern.literalLength),
+			compareBool(a.pattern.scheme != "", b.pattern.scheme != ""),
+			// Keep the choice stable.
+			strings.Compare(b.key, a.key),
+		)
+	}
+	for key := range credentials {
+		pattern, err := parseCredentialsPattern(key)
 		if err != nil {
 			return nil, fmt.Errorf(
 				"unable to parse configured repository URL %s:%w",
-				storedRepoURL,
+				key,
 				err,
 			)
 		}
-		if repoURL.Scheme == parsedURL.Scheme &&
-			repoURL.Host == parsedURL.Host &&
-			repoURL.User.Username() == parsedURL.User.Username() {
-			return &creds, nil
+		hostMatches, pathMatches := pattern.matches(repoURL)
+		if !hostMatches {
+			continue
+		}
+		candidate := &match{key: key, pattern: pattern, pathMatches: pathMatches}
+		if best == nil || compare(candidate, best) > 0 {
+			best = candidate
 		}
 	}
-	return nil, nil
+	if best == nil {
+		return nil, nil
+	}
+	creds := credentials[best.key]
+	return &creds, nil
+}
+
+// compareBool compares the booleans with false before true.
+func compareBool(a bool, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	default:
+		return -1
+	}
 }
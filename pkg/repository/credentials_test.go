@@ -4,7 +4,10 @@ package repository
 
 import (
 	"bytes"
+	"context"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/onsi/ginkgo/v2"
@@ -29,7 +32,7 @@ var _ = ginkgo.Describe("repository credentials", func() {
 			"    known_hosts: |",
 			"      github.com ssh-ed25519 <pubic-key>",
 		}, "\n"))
-		creds, err := ReadCredentials(input)
+		creds, err := ReadCredentials(context.Background(), input)
 		g.Expect(err).ToNot(gomega.HaveOccurred())
 		g.Expect(creds).To(gomega.HaveLen(1))
 		g.Expect(creds).To(gomega.HaveKey("ssh://git@github.com/"))
@@ -56,7 +59,7 @@ var _ = ginkgo.Describe("repository credentials", func() {
 			"  credentials:",
 			"    token: $GITHUB_TOKEN",
 		}, "\n"))
-		creds, err := ReadCredentials(input)
+		creds, err := ReadCredentials(context.Background(), input)
 		g.Expect(err).ToNot(gomega.HaveOccurred())
 		g.Expect(creds).To(gomega.HaveKey("https://github.com/"))
 		repoCreds := creds["https://github.com/"]
@@ -65,4 +68,182 @@ var _ = ginkgo.Describe("repository credentials", func() {
 			"foo",
 		))
 	})
+
+	ginkgo.It("expand environment variable references within values", func() {
+		defer os.Unsetenv("CI_USER")
+		defer os.Unsetenv("CI_TOKEN")
+		os.Setenv("CI_USER", "ci")
+		os.Setenv("CI_TOKEN", "secret")
+		input := bytes.NewBufferString(strings.Join([]string{
+			"https://charts.example.com/:",
+			"  credentials:",
+			"    username: ${CI_USER}",
+			"    password: prefix-${CI_TOKEN}-${FOUSKOTI_UNSET_VARIABLE}",
+			"    bearerToken: $$${CI_TOKEN}$${CI_USER}",
+			"    caFile: /etc/ssl/$CI_USER",
+			"https://git.example.com/:",
+			"  credentials:",
+			"    password: pa$$word",
+			"    bearerToken: $${CI_TOKEN}",
+		}, "\n"))
+		creds, err := ReadCredentials(context.Background(), input)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(creds["https://charts.example.com/"].Credentials).To(gomega.Equal(map[string]string{
+			"username":    "ci",
+			"password":    "prefix-secret-",
+			"bearerToken": "$secret${CI_USER}",
+			"caFile":      "/etc/ssl/$CI_USER",
+		}))
+		g.Expect(creds["https://git.example.com/"].Credentials).To(gomega.Equal(map[string]string{
+			"password":    "pa$$word",
+			"bearerToken": "$${CI_TOKEN}",
+		}))
+	})
+
+	ginkgo.It("list the environment variables the credentials reference", func() {
+		dir, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(dir)
+		fileName := filepath.Join(dir, "credentials.yaml")
+		err = os.WriteFile(fileName, []byte(strings.Join([]string{
+			"https://charts.example.com/:",
+			"  credentials:",
+			"    username: ${CI_USER}",
+			"    password: prefix-${CI_TOKEN}-$${CI_USER}",
+			"https://github.com/:",
+			"  credentials:",
+			"    token: $GITHUB_TOKEN",
+			"    caFile: /etc/ssl/$CI_CA",
+			"mirrors:",
+			"  docker.io: oci://proxy.example.com/${MIRROR}",
+		}, "\n")), 0600)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		variables, err := GetCredentialsEnvVars([]string{fileName})
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(variables).To(gomega.Equal([]string{"CI_TOKEN", "CI_USER", "GITHUB_TOKEN"}))
+	})
+
+	ginkgo.It("merge files and directories with later entries taking precedence", func() {
+		dir, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(dir)
+		writeFile := func(fileName string, lines ...string) string {
+			fileName = filepath.Join(dir, fileName)
+			g.Expect(os.MkdirAll(filepath.Dir(fileName), 0755)).To(gomega.Succeed())
+			err := os.WriteFile(fileName, []byte(strings.Join(lines, "\n")), 0644)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+			return fileName
+		}
+		writeFile(
+			"shared/10-github.yaml",
+			"https://github.com/:",
+			"  credentials:",
+			"    username: shared",
+			"https://gitlab.com/:",
+			"  credentials:",
+			"    username: shared",
+			"mirrors:",
+			"  docker.io: oci://proxy.example.com/docker.io",
+		)
+		writeFile(
+			"shared/20-gitlab.yml",
+			"https://gitlab.com/:",
+			"  credentials:",
+			"    username: team",
+		)
+		writeFile("shared/README.md", "Not credentials.")
+		writeFile("shared/.hidden.yaml", "invalid: [")
+		local := writeFile(
+			"local.yaml",
+			"https://github.com/:",
+			"  credentials:",
+			"    username: local",
+		)
+
+		creds, mirrors, err := ReadCredentialsFiles(context.Background(), []string{
+			filepath.Join(dir, "shared"),
+			local,
+			"",
+		})
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(creds).To(gomega.Equal(Credentials{
+			"https://github.com/": {Credentials: map[string]string{"username": "local"}},
+			"https://gitlab.com/": {Credentials: map[string]string{"username": "team"}},
+		}))
+		g.Expect(mirrors).To(gomega.Equal(Mirrors{
+			"oci://docker.io": "oci://proxy.example.com/docker.io",
+		}))
+
+		_, _, err = ReadCredentialsFiles(context.Background(), []string{filepath.Join(dir, "missing.yaml")})
+		g.Expect(err).To(gomega.MatchError(gomega.HavePrefix(
+			"unable to open credentials file " + filepath.Join(dir, "missing.yaml"),
+		)))
+	})
+
+	ginkgo.DescribeTable(
+		"find the most specific entry for repositories",
+		func(repoURL string, expected string) {
+			credentials := Credentials{}
+			for _, key := range []string{
+				"https://github.com/",
+				"https://github.com/org",
+				"https://github.com/org/team/",
+				"*.github.com",
+				"https://*.github.com/",
+				"https://api.*.github.com/",
+				"oci://1234*.dkr.ecr.*",
+				"ssh://git@gitlab.com/",
+			} {
+				credentials[key] = RepositoryCreds{
+					Credentials: map[string]string{"username": key},
+				}
+			}
+			parsedURL, err := url.Parse(repoURL)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+			creds, err := credentials.FindForRepo(parsedURL)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+			if expected == "" {
+				g.Expect(creds).To(gomega.BeNil())
+				return
+			}
+			g.Expect(creds).ToNot(gomega.BeNil())
+			g.Expect(creds.Credentials["username"]).To(gomega.Equal(expected))
+		},
+		ginkgo.Entry("exact URL", "https://github.com/org", "https://github.com/org"),
+		ginkgo.Entry(
+			"longest path prefix",
+			"https://github.com/org/team/charts.git",
+			"https://github.com/org/team/",
+		),
+		ginkgo.Entry("path prefix", "https://github.com/org/charts.git", "https://github.com/org"),
+		ginkgo.Entry(
+			"path prefix on segment boundaries",
+			"https://github.com/organization/charts.git",
+			"https://github.com/",
+		),
+		ginkgo.Entry(
+			"host with a different path",
+			"https://github.com",
+			"https://github.com/",
+		),
+		ginkgo.Entry(
+			"wildcard with the most literal characters",
+			"https://api.eu.github.com/charts",
+			"https://api.*.github.com/",
+		),
+		ginkgo.Entry("wildcard with a scheme", "https://raw.github.com/", "https://*.github.com/"),
+		ginkgo.Entry("bare host wildcard", "oci://ghcr.github.com/charts", "*.github.com"),
+		ginkgo.Entry(
+			"wildcards in registry hosts",
+			"oci://123456789012.dkr.ecr.us-east-1.amazonaws.com/charts",
+			"oci://1234*.dkr.ecr.*",
+		),
+		ginkgo.Entry(
+			"no wildcard match",
+			"oci://567890123456.dkr.ecr.us-east-1.amazonaws.com/charts",
+			"",
+		),
+		ginkgo.Entry("user of the scheme", "ssh://git@gitlab.com/org/charts.git", "ssh://git@gitlab.com/"),
+		ginkgo.Entry("other user", "ssh://admin@gitlab.com/org/charts.git", ""),
+	)
 })
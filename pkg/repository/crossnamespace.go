@@ -0,0 +1,52 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"fmt"
+	"strings"
+)
+
+// checkCrossNamespaceRefs fails on the HelmRelease objects to expand which
+// reference chart sources in other namespaces, the way Flux does when run with
+// --no-cross-namespace-refs.  The sources of releases referencing HelmChart
+// objects are in the namespaces of the HelmChart objects.
+func (renderer *releaseRepoRenderer) checkCrossNamespaceRefs(
+	releaseRepos []releaseRepo,
+) error {
+	if !renderer.options.NoCrossNamespaceRefs {
+		return nil
+	}
+	var refs []string
+	for _, pair := range releaseRepos {
+		if renderer.options.SkipList.contains(pair.release) {
+			continue
+		}
+		sourceRef, err := getSourceReference(pair.release)
+		if err != nil {
+			return fmt.Errorf(
+				"unable to get chart source of Helm release %s/%s: %w",
+				pair.release.GetNamespace(),
+				pair.release.GetName(),
+				err,
+			)
+		}
+		if sourceRef.namespace != pair.release.GetNamespace() {
+			refs = append(refs, fmt.Sprintf(
+				"%s/%s -> %s %s/%s",
+				pair.release.GetNamespace(),
+				pair.release.GetName(),
+				sourceRef.kind,
+				sourceRef.namespace,
+				sourceRef.name,
+			))
+		}
+	}
+	if len(refs) > 0 {
+		return NewClassifiedError(ErrorClassValidation, fmt.Errorf(
+			"cross-namespace chart source references are not allowed:\n  %s",
+			strings.Join(refs, "\n  "),
+		))
+	}
+	return nil
+}
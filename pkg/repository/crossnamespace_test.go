@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Cross-namespace source references", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+	})
+
+	getInput := func(sourceNamespace string) string {
+		return strings.Join([]string{
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"spec:",
+			"  chart:",
+			"    spec:",
+			"      chart: test-chart",
+			"      sourceRef:",
+			"        kind: HelmRepository",
+			"        name: charts",
+			"        namespace: " + sourceNamespace,
+		}, "\n")
+	}
+
+	expand := func(input string, noCrossNamespaceRefs bool) error {
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		// The sources are left out, the references are checked up front.
+		return expander.Expand(
+			bytes.NewBufferString(input),
+			&bytes.Buffer{},
+			ExpandOptions{
+				AllowMissingSources:  true,
+				NoCrossNamespaceRefs: noCrossNamespaceRefs,
+			},
+		)
+	}
+
+	ginkgo.It("rejects cross-namespace references if requested", func() {
+		err := expand(getInput("flux-system"), true)
+		g.Expect(err).To(gomega.MatchError(strings.Join([]string{
+			"cross-namespace chart source references are not allowed:",
+			"  testns/test -> HelmRepository flux-system/charts",
+		}, "\n")))
+		g.Expect(GetErrorClass(err)).To(gomega.Equal(ErrorClassValidation))
+	})
+
+	ginkgo.It("allows references in the same namespace", func() {
+		g.Expect(expand(getInput("testns"), true)).To(gomega.Succeed())
+	})
+
+	ginkgo.It("allows cross-namespace references by default", func() {
+		g.Expect(expand(getInput("flux-system"), false)).To(gomega.Succeed())
+	})
+})
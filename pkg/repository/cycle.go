@@ -0,0 +1,77 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+
+	yamlutil "github.com/sageailabs/fouskoti/pkg/yaml"
+)
+
+// ExpansionStep is the expansion of a HelmRelease with a chart version in a
+// chain of HelmRelease objects generated by expanding one another.
+type ExpansionStep struct {
+	Namespace    string
+	Name         string
+	Chart        string
+	ChartVersion string
+}
+
+func (step ExpansionStep) String() string {
+	return fmt.Sprintf(
+		"%s/%s (%s %s)",
+		step.Namespace,
+		step.Name,
+		step.Chart,
+		step.ChartVersion,
+	)
+}
+
+// ExpansionCycleError reports a HelmRelease which is generated again, with the
+// same chart version, by the expansion of itself or of HelmRelease objects it
+// generates, which would otherwise repeat until the expansion limit.
+type ExpansionCycleError struct {
+	// Chain lists the expansions from the first expansion of the repeated
+	// HelmRelease to the repeated one.
+	Chain []ExpansionStep
+}
+
+func (err *ExpansionCycleError) Error() string {
+	steps := make([]string, 0, len(err.Chain))
+	for _, step := range err.Chain {
+		steps = append(steps, step.String())
+	}
+	return "cycle in recursive expansion of Helm releases: " + strings.Join(steps, " -> ")
+}
+
+// checkExpansionCycle fails if the step repeats one of the steps in the chain
+// of expansions which generated its HelmRelease.
+func checkExpansionCycle(chain []ExpansionStep, step ExpansionStep) error {
+	index := slices.Index(chain, step)
+	if index < 0 {
+		return nil
+	}
+	return NewClassifiedError(
+		ErrorClassRender,
+		&ExpansionCycleError{Chain: append(slices.Clone(chain[index:]), step)},
+	)
+}
+
+// recordGeneratedReleases notes the chain of expansions which generated each
+// HelmRelease among the nodes, for the next round to check for cycles.
+func recordGeneratedReleases(
+	lineage map[string][]ExpansionStep,
+	chain []ExpansionStep,
+	nodes []*yaml.RNode,
+) {
+	for _, node := range nodes {
+		if yamlutil.GetGroup(node) == "helm.toolkit.fluxcd.io" &&
+			node.GetKind() == "HelmRelease" {
+			lineage[getReleaseKey(node)] = chain
+		}
+	}
+}
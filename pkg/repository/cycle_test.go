@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Expansion cycles", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+	var port int
+	var stop func()
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+
+		repoRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		server, serverPort, serverDone, err := serveDirectory(repoRoot, logger, nil)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		port = serverPort
+		stop = func() {
+			g.Expect(stopServing(server, serverDone)).To(gomega.Succeed())
+			os.RemoveAll(repoRoot)
+		}
+
+		// The chart generates a HelmRelease named after .Values.next using the
+		// same chart, with .Values.after as its next.
+		err = createSingleChartHelmRepository(
+			"app-of-apps",
+			"0.1.0",
+			map[string]string{
+				"Chart.yaml": strings.Join([]string{
+					"apiVersion: v2",
+					"name: app-of-apps",
+					"version: 0.1.0",
+				}, "\n"),
+				"templates/release.yaml": strings.Join([]string{
+					"{{- if .Values.next }}",
+					"apiVersion: helm.toolkit.fluxcd.io/v2",
+					"kind: HelmRelease",
+					"metadata:",
+					"  namespace: {{ .Release.Namespace }}",
+					"  name: {{ .Values.next }}",
+					"spec:",
+					"  chart:",
+					"    spec:",
+					"      chart: app-of-apps",
+					"      sourceRef:",
+					"        kind: HelmRepository",
+					"        name: local",
+					"  values:",
+					"    next: {{ .Values.after }}",
+					"    after: {{ .Release.Name }}",
+					"{{- end }}",
+				}, "\n"),
+			},
+			port,
+			repoRoot,
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+	})
+
+	ginkgo.AfterEach(func() {
+		stop()
+	})
+
+	getInput := func(next string, after string) string {
+		return strings.Join([]string{
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: HelmRepository",
+			"metadata:",
+			"  namespace: testns",
+			"  name: local",
+			"spec:",
+			fmt.Sprintf("  url: http://localhost:%d", port),
+			"---",
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: first",
+			"spec:",
+			"  chart:",
+			"    spec:",
+			"      chart: app-of-apps",
+			"      sourceRef:",
+			"        kind: HelmRepository",
+			"        name: local",
+			"  values:",
+			"    next: " + next,
+			"    after: " + after,
+		}, "\n")
+	}
+
+	ginkgo.It("reports the chain of a release generating itself", func() {
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		err := expander.Expand(
+			bytes.NewBufferString(getInput("second", "first")),
+			&bytes.Buffer{},
+			ExpandOptions{MaxExpansions: 10},
+		)
+		g.Expect(err).To(gomega.MatchError(
+			"cycle in recursive expansion of Helm releases: " +
+				"testns/first (app-of-apps 0.1.0) -> " +
+				"testns/second (app-of-apps 0.1.0) -> " +
+				"testns/first (app-of-apps 0.1.0)",
+		))
+		g.Expect(GetErrorClass(err)).To(gomega.Equal(ErrorClassRender))
+	})
+
+	ginkgo.It("expands chains without cycles", func() {
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		var output bytes.Buffer
+		err := expander.Expand(
+			bytes.NewBufferString(getInput("second", "\"\"")),
+			&output,
+			ExpandOptions{MaxExpansions: 10},
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(output.String()).To(gomega.ContainSubstring("name: second"))
+	})
+})
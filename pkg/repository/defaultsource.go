@@ -0,0 +1,91 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"fmt"
+	"strings"
+
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// defaultSourceAPIVersions are the API versions of the chart sources built
+// for HelmRelease objects referencing sources missing from the input.
+var defaultSourceAPIVersions = map[string]string{
+	"GitRepository":  "source.toolkit.fluxcd.io/v1",
+	"HelmRepository": "source.toolkit.fluxcd.io/v1",
+	"OCIRepository":  "source.toolkit.fluxcd.io/v1beta2",
+}
+
+// newDefaultSourceNode returns the chart source the reference points to with
+// the URL, or nil if the kind of the source cannot be built.  The type of Helm
+// repositories is inferred from the URL scheme.
+func newDefaultSourceNode(sourceRef *sourceReference, url string) (*yaml.RNode, error) {
+	apiVersion, ok := defaultSourceAPIVersions[sourceRef.kind]
+	if !ok {
+		return nil, nil
+	}
+	if sourceRef.apiVersion != "" {
+		apiVersion = sourceRef.apiVersion
+	}
+	node := yaml.NewMapRNode(nil)
+	node.SetApiVersion(apiVersion)
+	node.SetKind(sourceRef.kind)
+	if err := node.SetName(sourceRef.name); err != nil {
+		return nil, fmt.Errorf("unable to set default source name: %w", err)
+	}
+	if err := node.SetNamespace(sourceRef.namespace); err != nil {
+		return nil, fmt.Errorf("unable to set default source namespace: %w", err)
+	}
+	fields := []struct{ name, value string }{{"url", url}}
+	if sourceRef.kind == "HelmRepository" && strings.HasPrefix(url, "oci://") {
+		fields = append(fields, struct{ name, value string }{"type", "oci"})
+	}
+	for _, field := range fields {
+		err := node.PipeE(
+			yaml.LookupCreate(yaml.MappingNode, "spec"),
+			yaml.SetField(field.name, yaml.NewStringRNode(field.value)),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("unable to set default source %s: %w", field.name, err)
+		}
+	}
+	return node, nil
+}
+
+// addDefaultSources pairs the HelmRelease objects with chart sources missing
+// from the input with sources built from the default source URL, so that
+// partial inputs, like a single HelmRelease, can be rendered.  The built
+// sources are not added to the output.
+func (renderer *releaseRepoRenderer) addDefaultSources(
+	releaseRepos []releaseRepo,
+) ([]releaseRepo, error) {
+	result := make([]releaseRepo, 0, len(releaseRepos))
+	for _, pair := range releaseRepos {
+		if pair.repo == nil && !renderer.options.SkipList.contains(pair.release) {
+			sourceRef, err := getSourceReference(pair.release)
+			if err != nil {
+				return nil, fmt.Errorf(
+					"unable to get chart source of Helm release %s/%s: %w",
+					pair.release.GetNamespace(),
+					pair.release.GetName(),
+					err,
+				)
+			}
+			pair.repo, err = newDefaultSourceNode(sourceRef, renderer.options.DefaultSourceURL)
+			if err != nil {
+				return nil, err
+			}
+			if pair.repo != nil {
+				renderer.config.logger.
+					With("namespace", pair.release.GetNamespace()).
+					With("name", pair.release.GetName()).
+					With("source", fmt.Sprintf("%s %s/%s", sourceRef.kind, sourceRef.namespace, sourceRef.name)).
+					With("url", renderer.options.DefaultSourceURL).
+					Warn("Using the default source URL for a missing chart source")
+			}
+		}
+		result = append(result, pair)
+	}
+	return result, nil
+}
@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Default chart sources", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+	})
+
+	input := strings.Join([]string{
+		"apiVersion: helm.toolkit.fluxcd.io/v2",
+		"kind: HelmRelease",
+		"metadata:",
+		"  namespace: testns",
+		"  name: test",
+		"spec:",
+		"  chart:",
+		"    spec:",
+		"      chart: test-chart",
+		"      sourceRef:",
+		"        kind: HelmRepository",
+		"        name: charts",
+	}, "\n")
+
+	ginkgo.It("renders releases with missing sources from the default URL", func() {
+		repoRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(repoRoot)
+		server, port, serverDone, err := serveDirectory(repoRoot, logger, nil)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer func() {
+			g.Expect(stopServing(server, serverDone)).To(gomega.Succeed())
+		}()
+
+		err = createSingleChartHelmRepository(
+			"test-chart",
+			"0.1.0",
+			map[string]string{
+				"Chart.yaml": strings.Join([]string{
+					"apiVersion: v2",
+					"name: test-chart",
+					"version: 0.1.0",
+				}, "\n"),
+				"templates/configmap.yaml": strings.Join([]string{
+					"apiVersion: v1",
+					"kind: ConfigMap",
+					"metadata:",
+					"  namespace: {{ .Release.Namespace }}",
+					"  name: {{ .Release.Name }}-configmap",
+				}, "\n"),
+			},
+			port,
+			repoRoot,
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		var output bytes.Buffer
+		err = expander.Expand(
+			bytes.NewBufferString(input),
+			&output,
+			ExpandOptions{DefaultSourceURL: fmt.Sprintf("http://localhost:%d", port)},
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(output.String()).To(gomega.Equal(strings.Join([]string{
+			input,
+			"---",
+			"# Source: test-chart/templates/configmap.yaml",
+			"apiVersion: v1",
+			"kind: ConfigMap",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test-configmap",
+			"",
+		}, "\n")))
+	})
+
+	ginkgo.It("infers OCI Helm repositories from the URL scheme", func() {
+		node, err := newDefaultSourceNode(
+			&sourceReference{kind: "HelmRepository", namespace: "testns", name: "charts"},
+			"oci://registry.example.com/charts",
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(node.MustString()).To(gomega.Equal(strings.Join([]string{
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: HelmRepository",
+			"metadata:",
+			"  name: charts",
+			"  namespace: testns",
+			"spec:",
+			"  url: oci://registry.example.com/charts",
+			"  type: oci",
+			"",
+		}, "\n")))
+	})
+
+	ginkgo.It("leaves sources of other kinds missing", func() {
+		node, err := newDefaultSourceNode(
+			&sourceReference{kind: "Bucket", namespace: "testns", name: "charts"},
+			"https://charts.example.com",
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(node).To(gomega.BeNil())
+	})
+})
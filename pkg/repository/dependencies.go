@@ -0,0 +1,136 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+
+	yamlutil "github.com/sageailabs/fouskoti/pkg/yaml"
+)
+
+// Renovate datasources of the charts.
+const (
+	helmDatasource    = "helm"
+	dockerDatasource  = "docker"
+	gitTagsDatasource = "git-tags"
+)
+
+// Dependency describes a chart a HelmRelease deploys in the terms of
+// Renovate's package dependencies, so that update bots can track it.
+type Dependency struct {
+	// HelmRelease is the HelmRelease deploying the chart, as
+	// <namespace>/<name>.
+	HelmRelease string `json:"helmRelease"`
+	// Datasource is helm for Helm repositories, docker for OCI repositories,
+	// and git-tags for Git repositories.
+	Datasource string `json:"datasource"`
+	// DepName is the name of the chart, or the URL of the Git repository.
+	DepName string `json:"depName"`
+	// PackageName is the reference of the chart in the OCI registry, or the
+	// URL of the Git repository.
+	PackageName string `json:"packageName,omitempty"`
+	// CurrentValue is the version constraint of the chart, or the tag of the
+	// Git repository.
+	CurrentValue string `json:"currentValue,omitempty"`
+	// RegistryURL is the URL of the Helm repository.
+	RegistryURL string `json:"registryUrl,omitempty"`
+	// SkipReason tells why the dependency cannot be updated, if it cannot.
+	SkipReason string `json:"skipReason,omitempty"`
+}
+
+// getDependency returns the dependency on the chart the release deploys from
+// the repository.
+func getDependency(release *yaml.RNode, repo *yaml.RNode) (*Dependency, error) {
+	repoURL, err := repo.GetString("spec.url")
+	if err != nil {
+		return nil, fmt.Errorf(
+			"unable to get URL for %s %s/%s: %w",
+			repo.GetKind(),
+			repo.GetNamespace(),
+			repo.GetName(),
+			err,
+		)
+	}
+	result := &Dependency{
+		HelmRelease: release.GetNamespace() + "/" + release.GetName(),
+	}
+
+	if repo.GetKind() == "GitRepository" {
+		// The chart has the version of the Git reference, which only tags pin.
+		result.Datasource = gitTagsDatasource
+		result.DepName = repoURL
+		result.PackageName = repoURL
+		result.CurrentValue, err = yamlutil.GetStringOr(repo, "spec.ref.tag", "")
+		if err != nil {
+			return nil, err
+		}
+		if result.CurrentValue == "" {
+			result.SkipReason = "unversioned-reference"
+		}
+		return result, nil
+	}
+
+	result.DepName, err = release.GetString("spec.chart.spec.chart")
+	if err != nil {
+		return nil, fmt.Errorf("unable to get chart name: %w", err)
+	}
+	result.CurrentValue, err = yamlutil.GetStringOr(release, "spec.chart.spec.version", "")
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(repoURL, ociSchemePrefix) {
+		result.Datasource = dockerDatasource
+		result.PackageName = strings.TrimSuffix(
+			strings.TrimPrefix(repoURL, ociSchemePrefix),
+			"/",
+		) + "/" + result.DepName
+	} else {
+		result.Datasource = helmDatasource
+		result.RegistryURL = repoURL
+	}
+	// Flux deploys the latest version without a constraint.
+	if result.CurrentValue == "" {
+		result.SkipReason = "unspecified-version"
+	}
+	return result, nil
+}
+
+// ListDependencies returns the charts the HelmRelease objects in the input
+// deploy as Renovate package dependencies, looking up their sources like
+// ResolveHelmReleases without fetching anything.
+func (expander *HelmReleaseExpander) ListDependencies(
+	input io.Reader,
+	options ExpandOptions,
+) ([]Dependency, error) {
+	_, releaseRepos, cleanUp, err := expander.prepareResolution(input, options)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanUp()
+
+	result := make([]Dependency, 0, len(releaseRepos))
+	for _, pair := range releaseRepos {
+		if pair.repo == nil {
+			return nil, fmt.Errorf(
+				"missing chart repository for Helm release %s/%s",
+				pair.release.GetNamespace(),
+				pair.release.GetName(),
+			)
+		}
+		dependency, err := getDependency(pair.release, pair.repo)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"unable to get chart dependency of Helm release %s/%s: %w",
+				pair.release.GetNamespace(),
+				pair.release.GetName(),
+				err,
+			)
+		}
+		result = append(result, *dependency)
+	}
+	return result, nil
+}
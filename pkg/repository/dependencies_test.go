@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Chart dependency listing", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+	})
+
+	ginkgo.It("lists charts of all source kinds without fetching", func() {
+		getRelease := func(name string, version string, kind string, source string) string {
+			lines := []string{
+				"apiVersion: helm.toolkit.fluxcd.io/v2",
+				"kind: HelmRelease",
+				"metadata:",
+				"  namespace: testns",
+				"  name: " + name,
+				"spec:",
+				"  chart:",
+				"    spec:",
+				"      chart: test-chart",
+				"      sourceRef:",
+				"        kind: " + kind,
+				"        name: " + source,
+			}
+			if version != "" {
+				lines = append(lines, "      version: "+version)
+			}
+			return strings.Join(lines, "\n")
+		}
+		getSource := func(kind string, name string, spec ...string) string {
+			return strings.Join(append([]string{
+				"apiVersion: source.toolkit.fluxcd.io/v1",
+				"kind: " + kind,
+				"metadata:",
+				"  namespace: testns",
+				"  name: " + name,
+				"spec:",
+			}, spec...), "\n")
+		}
+		input := strings.Join([]string{
+			getRelease("helm", "^1.0", "HelmRepository", "helm"),
+			getRelease("oci", "1.2.3", "HelmRepository", "oci"),
+			getRelease("unversioned", "", "HelmRepository", "helm"),
+			getRelease("git-tag", "", "GitRepository", "tag"),
+			getRelease("git-branch", "", "GitRepository", "branch"),
+			getSource("HelmRepository", "helm", "  url: https://charts.example.com"),
+			getSource(
+				"HelmRepository",
+				"oci",
+				"  type: oci",
+				"  url: oci://registry.example.com/charts/",
+			),
+			getSource(
+				"GitRepository",
+				"tag",
+				"  url: https://git.example.com/charts.git",
+				"  ref:",
+				"    tag: v1.0.0",
+			),
+			getSource(
+				"GitRepository",
+				"branch",
+				"  url: https://git.example.com/charts.git",
+				"  ref:",
+				"    branch: main",
+			),
+		}, "\n---\n")
+
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		dependencies, err := expander.ListDependencies(
+			bytes.NewBufferString(input),
+			ExpandOptions{},
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(dependencies).To(gomega.Equal([]Dependency{
+			{
+				HelmRelease:  "testns/helm",
+				Datasource:   "helm",
+				DepName:      "test-chart",
+				CurrentValue: "^1.0",
+				RegistryURL:  "https://charts.example.com",
+			},
+			{
+				HelmRelease:  "testns/oci",
+				Datasource:   "docker",
+				DepName:      "test-chart",
+				PackageName:  "registry.example.com/charts/test-chart",
+				CurrentValue: "1.2.3",
+			},
+			{
+				HelmRelease: "testns/unversioned",
+				Datasource:  "helm",
+				DepName:     "test-chart",
+				RegistryURL: "https://charts.example.com",
+				SkipReason:  "unspecified-version",
+			},
+			{
+				HelmRelease:  "testns/git-tag",
+				Datasource:   "git-tags",
+				DepName:      "https://git.example.com/charts.git",
+				PackageName:  "https://git.example.com/charts.git",
+				CurrentValue: "v1.0.0",
+			},
+			{
+				HelmRelease: "testns/git-branch",
+				Datasource:  "git-tags",
+				DepName:     "https://git.example.com/charts.git",
+				PackageName: "https://git.example.com/charts.git",
+				SkipReason:  "unversioned-reference",
+			},
+		}))
+	})
+})
@@ -0,0 +1,113 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+
+	yamlutil "github.com/sageailabs/fouskoti/pkg/yaml"
+)
+
+func getReleaseKey(release *yaml.RNode) string {
+	return release.GetNamespace() + "/" + release.GetName()
+}
+
+// getReleaseDependencies returns the HelmRelease objects, as namespace/name,
+// listed in spec.dependsOn of the release.
+func getReleaseDependencies(release *yaml.RNode) ([]string, error) {
+	dependsOn, err := release.Pipe(yaml.Lookup("spec", "dependsOn"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to get spec.dependsOn: %w", err)
+	}
+	if dependsOn == nil {
+		return nil, nil
+	}
+	elements, err := dependsOn.Elements()
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse spec.dependsOn: %w", err)
+	}
+
+	result := []string{}
+	for _, element := range elements {
+		name, err := element.GetString("name")
+		if err != nil {
+			return nil, fmt.Errorf("unable to get dependency name: %w", err)
+		}
+		namespace, err := yamlutil.GetStringOr(
+			element,
+			"namespace",
+			release.GetNamespace(),
+		)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, namespace+"/"+name)
+	}
+	return result, nil
+}
+
+// orderReleasesByDependencies orders the releases so that each one comes after
+// the releases it depends on, otherwise keeping their order.  Dependencies on
+// releases not in the list are ignored.
+func orderReleasesByDependencies(releaseRepos []releaseRepo) ([]releaseRepo, error) {
+	// Indexes of the releases by namespace/name.
+	indexes := map[string][]int{}
+	dependencies := make([][]string, len(releaseRepos))
+	for i, pair := range releaseRepos {
+		key := getReleaseKey(pair.release)
+		indexes[key] = append(indexes[key], i)
+		releaseDependencies, err := getReleaseDependencies(pair.release)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"unable to get dependencies of Helm release %s: %w",
+				key,
+				err,
+			)
+		}
+		dependencies[i] = releaseDependencies
+	}
+
+	result := []releaseRepo{}
+	done := make([]bool, len(releaseRepos))
+	// Releases on the current dependency path, for reporting cycles.
+	path := []int{}
+	var visit func(index int) error
+	visit = func(index int) error {
+		if done[index] {
+			return nil
+		}
+		if start := slices.Index(path, index); start >= 0 {
+			cycle := []string{}
+			for _, i := range append(path[start:], index) {
+				cycle = append(cycle, getReleaseKey(releaseRepos[i].release))
+			}
+			return fmt.Errorf(
+				"dependency cycle among Helm releases: %s",
+				strings.Join(cycle, " -> "),
+			)
+		}
+		path = append(path, index)
+		for _, dependency := range dependencies[index] {
+			for _, dependencyIndex := range indexes[dependency] {
+				if err := visit(dependencyIndex); err != nil {
+					return err
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		done[index] = true
+		result = append(result, releaseRepos[index])
+		return nil
+	}
+
+	for i := range releaseRepos {
+		if err := visit(i); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+)
+
+var _ = ginkgo.Describe("Helm release dependencies", func() {
+	var g gomega.Gomega
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+	})
+
+	getRelease := func(namespace string, name string, dependsOn ...string) string {
+		lines := []string{
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: " + namespace,
+			"  name: " + name,
+			"spec:",
+		}
+		if len(dependsOn) > 0 {
+			lines = append(lines, "  dependsOn:")
+			for _, dependency := range dependsOn {
+				parts := strings.Split(dependency, "/")
+				if len(parts) == 2 {
+					lines = append(
+						lines,
+						"  - namespace: "+parts[0],
+						"    name: "+parts[1],
+					)
+				} else {
+					lines = append(lines, "  - name: "+dependency)
+				}
+			}
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	order := func(releases ...string) ([]string, error) {
+		nodes, err := (&kio.ByteReader{
+			Reader: bytes.NewBufferString(strings.Join(releases, "\n---\n")),
+		}).Read()
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		releaseRepos := []releaseRepo{}
+		for _, node := range nodes {
+			releaseRepos = append(releaseRepos, releaseRepo{release: node})
+		}
+
+		ordered, err := orderReleasesByDependencies(releaseRepos)
+		if err != nil {
+			return nil, err
+		}
+		keys := []string{}
+		for _, pair := range ordered {
+			keys = append(keys, getReleaseKey(pair.release))
+		}
+		return keys, nil
+	}
+
+	ginkgo.It("orders dependencies before dependents", func() {
+		keys, err := order(
+			getRelease("apps", "frontend", "backend"),
+			getRelease("apps", "backend", "infra/database"),
+			getRelease("apps", "unrelated"),
+			getRelease("infra", "database", "missing"),
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(keys).To(gomega.Equal([]string{
+			"infra/database",
+			"apps/backend",
+			"apps/frontend",
+			"apps/unrelated",
+		}))
+	})
+
+	ginkgo.It("reports dependency cycles", func() {
+		_, err := order(
+			getRelease("apps", "first", "second"),
+			getRelease("apps", "second", "third"),
+			getRelease("apps", "third", "first"),
+		)
+		g.Expect(err).To(gomega.MatchError(
+			"dependency cycle among Helm releases: " +
+				"apps/first -> apps/second -> apps/third -> apps/first",
+		))
+	})
+})
@@ -0,0 +1,383 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// DeprecatedAPI describes a Kubernetes API version of a kind which is
+// deprecated and removed in the given Kubernetes versions.
+type DeprecatedAPI struct {
+	APIVersion   string `json:"apiVersion"`
+	Kind         string `json:"kind"`
+	DeprecatedIn string `json:"deprecatedIn"`
+	RemovedIn    string `json:"removedIn"`
+	// Replacement is the API version to use instead, if any.
+	Replacement string `json:"replacement,omitempty"`
+}
+
+func newDeprecatedAPIs(
+	apiVersion string,
+	kinds []string,
+	deprecatedIn string,
+	removedIn string,
+	replacement string,
+) []DeprecatedAPI {
+	result := []DeprecatedAPI{}
+	for _, kind := range kinds {
+		result = append(result, DeprecatedAPI{
+			APIVersion:   apiVersion,
+			Kind:         kind,
+			DeprecatedIn: deprecatedIn,
+			RemovedIn:    removedIn,
+			Replacement:  replacement,
+		})
+	}
+	return result
+}
+
+// deprecatedAPIs lists the API versions deprecated and removed according to
+// the Kubernetes deprecated API migration guide.
+var deprecatedAPIs = slices.Concat(
+	newDeprecatedAPIs(
+		"extensions/v1beta1",
+		[]string{"DaemonSet", "Deployment", "ReplicaSet"},
+		"1.9",
+		"1.16",
+		"apps/v1",
+	),
+	newDeprecatedAPIs(
+		"apps/v1beta1",
+		[]string{"Deployment", "StatefulSet"},
+		"1.9",
+		"1.16",
+		"apps/v1",
+	),
+	newDeprecatedAPIs(
+		"apps/v1beta2",
+		[]string{"DaemonSet", "Deployment", "ReplicaSet", "StatefulSet"},
+		"1.9",
+		"1.16",
+		"apps/v1",
+	),
+	newDeprecatedAPIs(
+		"extensions/v1beta1",
+		[]string{"NetworkPolicy"},
+		"1.9",
+		"1.16",
+		"networking.k8s.io/v1",
+	),
+	newDeprecatedAPIs(
+		"extensions/v1beta1",
+		[]string{"PodSecurityPolicy"},
+		"1.10",
+		"1.16",
+		"policy/v1beta1",
+	),
+	newDeprecatedAPIs(
+		"admissionregistration.k8s.io/v1beta1",
+		[]string{"MutatingWebhookConfiguration", "ValidatingWebhookConfiguration"},
+		"1.16",
+		"1.22",
+		"admissionregistration.k8s.io/v1",
+	),
+	newDeprecatedAPIs(
+		"apiextensions.k8s.io/v1beta1",
+		[]string{"CustomResourceDefinition"},
+		"1.16",
+		"1.22",
+		"apiextensions.k8s.io/v1",
+	),
+	newDeprecatedAPIs(
+		"apiregistration.k8s.io/v1beta1",
+		[]string{"APIService"},
+		"1.19",
+		"1.22",
+		"apiregistration.k8s.io/v1",
+	),
+	newDeprecatedAPIs(
+		"authentication.k8s.io/v1beta1",
+		[]string{"TokenReview"},
+		"1.19",
+		"1.22",
+		"authentication.k8s.io/v1",
+	),
+	newDeprecatedAPIs(
+		"authorization.k8s.io/v1beta1",
+		[]string{
+			"LocalSubjectAccessReview",
+			"SelfSubjectAccessReview",
+			"SubjectAccessReview",
+		},
+		"1.19",
+		"1.22",
+		"authorization.k8s.io/v1",
+	),
+	newDeprecatedAPIs(
+		"certificates.k8s.io/v1beta1",
+		[]string{"CertificateSigningRequest"},
+		"1.19",
+		"1.22",
+		"certificates.k8s.io/v1",
+	),
+	newDeprecatedAPIs(
+		"coordination.k8s.io/v1beta1",
+		[]string{"Lease"},
+		"1.19",
+		"1.22",
+		"coordination.k8s.io/v1",
+	),
+	newDeprecatedAPIs(
+		"extensions/v1beta1",
+		[]string{"Ingress"},
+		"1.14",
+		"1.22",
+		"networking.k8s.io/v1",
+	),
+	newDeprecatedAPIs(
+		"networking.k8s.io/v1beta1",
+		[]string{"Ingress", "IngressClass"},
+		"1.19",
+		"1.22",
+		"networking.k8s.io/v1",
+	),
+	newDeprecatedAPIs(
+		"rbac.authorization.k8s.io/v1beta1",
+		[]string{"ClusterRole", "ClusterRoleBinding", "Role", "RoleBinding"},
+		"1.17",
+		"1.22",
+		"rbac.authorization.k8s.io/v1",
+	),
+	newDeprecatedAPIs(
+		"scheduling.k8s.io/v1beta1",
+		[]string{"PriorityClass"},
+		"1.14",
+		"1.22",
+		"scheduling.k8s.io/v1",
+	),
+	newDeprecatedAPIs(
+		"storage.k8s.io/v1beta1",
+		[]string{"CSIDriver", "CSINode", "StorageClass", "VolumeAttachment"},
+		"1.19",
+		"1.22",
+		"storage.k8s.io/v1",
+	),
+	newDeprecatedAPIs(
+		"batch/v1beta1",
+		[]string{"CronJob"},
+		"1.21",
+		"1.25",
+		"batch/v1",
+	),
+	newDeprecatedAPIs(
+		"discovery.k8s.io/v1beta1",
+		[]string{"EndpointSlice"},
+		"1.21",
+		"1.25",
+		"discovery.k8s.io/v1",
+	),
+	newDeprecatedAPIs(
+		"events.k8s.io/v1beta1",
+		[]string{"Event"},
+		"1.19",
+		"1.25",
+		"events.k8s.io/v1",
+	),
+	newDeprecatedAPIs(
+		"autoscaling/v2beta1",
+		[]string{"HorizontalPodAutoscaler"},
+		"1.22",
+		"1.25",
+		"autoscaling/v2",
+	),
+	newDeprecatedAPIs(
+		"policy/v1beta1",
+		[]string{"PodDisruptionBudget"},
+		"1.21",
+		"1.25",
+		"policy/v1",
+	),
+	newDeprecatedAPIs(
+		"policy/v1beta1",
+		[]string{"PodSecurityPolicy"},
+		"1.21",
+		"1.25",
+		"",
+	),
+	newDeprecatedAPIs(
+		"node.k8s.io/v1beta1",
+		[]string{"RuntimeClass"},
+		"1.20",
+		"1.25",
+		"node.k8s.io/v1",
+	),
+	newDeprecatedAPIs(
+		"flowcontrol.apiserver.k8s.io/v1beta1",
+		[]string{"FlowSchema", "PriorityLevelConfiguration"},
+		"1.23",
+		"1.26",
+		"flowcontrol.apiserver.k8s.io/v1",
+	),
+	newDeprecatedAPIs(
+		"autoscaling/v2beta2",
+		[]string{"HorizontalPodAutoscaler"},
+		"1.23",
+		"1.26",
+		"autoscaling/v2",
+	),
+	newDeprecatedAPIs(
+		"storage.k8s.io/v1beta1",
+		[]string{"CSIStorageCapacity"},
+		"1.24",
+		"1.27",
+		"storage.k8s.io/v1",
+	),
+	newDeprecatedAPIs(
+		"flowcontrol.apiserver.k8s.io/v1beta2",
+		[]string{"FlowSchema", "PriorityLevelConfiguration"},
+		"1.26",
+		"1.29",
+		"flowcontrol.apiserver.k8s.io/v1",
+	),
+	newDeprecatedAPIs(
+		"flowcontrol.apiserver.k8s.io/v1beta3",
+		[]string{"FlowSchema", "PriorityLevelConfiguration"},
+		"1.29",
+		"1.32",
+		"flowcontrol.apiserver.k8s.io/v1",
+	),
+)
+
+// APIDeprecation reports a resource rendered from a HelmRelease using an API
+// version deprecated or removed in the target Kubernetes version.
+type APIDeprecation struct {
+	DeprecatedAPI
+	Removed          bool   `json:"removed"`
+	Namespace        string `json:"namespace,omitempty"`
+	Name             string `json:"name"`
+	ReleaseNamespace string `json:"releaseNamespace"`
+	ReleaseName      string `json:"releaseName"`
+	Chart            string `json:"chart"`
+	ChartVersion     string `json:"chartVersion"`
+	// Template is the chart template the resource was rendered from.
+	Template string `json:"template"`
+}
+
+// findDeprecatedAPI returns the deprecation of the API version of the kind if
+// it's deprecated in the Kubernetes version, and whether it's also removed.
+func findDeprecatedAPI(
+	apiVersion string,
+	kind string,
+	kubeVersion *semver.Version,
+) (*DeprecatedAPI, bool) {
+	for i := range deprecatedAPIs {
+		api := &deprecatedAPIs[i]
+		if api.APIVersion != apiVersion || api.Kind != kind {
+			continue
+		}
+		deprecatedIn := semver.MustParse(api.DeprecatedIn)
+		if kubeVersion.LessThan(deprecatedIn) {
+			return nil, false
+		}
+		removedIn := semver.MustParse(api.RemovedIn)
+		return api, !kubeVersion.LessThan(removedIn)
+	}
+	return nil, false
+}
+
+func parseTargetKubeVersion(version string) (*semver.Version, error) {
+	kubeVersion, err := semver.NewVersion(version)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"unable to parse Kubernetes version %s: %w",
+			version,
+			err,
+		)
+	}
+	// Pre-release versions are treated as the releases they precede.
+	return semver.New(
+		kubeVersion.Major(),
+		kubeVersion.Minor(),
+		kubeVersion.Patch(),
+		"",
+		"",
+	), nil
+}
+
+// FindAPIDeprecations expands the HelmRelease objects in the input and reports
+// the rendered resources that use API versions deprecated or removed in
+// options.KubeVersion, or in the Kubernetes version the release annotation
+// overrides it with.
+func (expander *HelmReleaseExpander) FindAPIDeprecations(
+	input io.Reader,
+	options ExpandOptions,
+) ([]APIDeprecation, error) {
+	if options.KubeVersion == nil {
+		return nil, fmt.Errorf("target Kubernetes version is required")
+	}
+	kubeVersion, err := parseTargetKubeVersion(options.KubeVersion.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []APIDeprecation{}
+	onReleaseExpanded := options.OnReleaseExpanded
+	options.OnReleaseExpanded = func(release *ExpandedRelease) {
+		// Releases can target other Kubernetes versions with an annotation.
+		releaseKubeVersion, err := parseTargetKubeVersion(release.KubeVersion)
+		if err != nil {
+			releaseKubeVersion = kubeVersion
+		}
+		for _, node := range release.Resources {
+			api, removed := findDeprecatedAPI(
+				node.GetApiVersion(),
+				node.GetKind(),
+				releaseKubeVersion,
+			)
+			if api == nil {
+				continue
+			}
+			result = append(result, APIDeprecation{
+				DeprecatedAPI:    *api,
+				Removed:          removed,
+				Namespace:        node.GetNamespace(),
+				Name:             node.GetName(),
+				ReleaseNamespace: release.Namespace,
+				ReleaseName:      release.Name,
+				Chart:            release.Chart,
+				ChartVersion:     release.ChartVersion,
+				Template: strings.TrimPrefix(
+					node.YNode().HeadComment,
+					"Source: ",
+				),
+			})
+		}
+		if onReleaseExpanded != nil {
+			onReleaseExpanded(release)
+		}
+	}
+	// Only the findings are reported, resources only need to be collected for
+	// expanding HelmRelease objects they contain.
+	options.Streaming = true
+	if err := expander.Expand(input, io.Discard, options); err != nil {
+		return nil, err
+	}
+
+	slices.SortStableFunc(result, func(a, b APIDeprecation) int {
+		return cmp.Or(
+			cmp.Compare(a.ReleaseNamespace, b.ReleaseNamespace),
+			cmp.Compare(a.ReleaseName, b.ReleaseName),
+			cmp.Compare(a.Kind, b.Kind),
+			cmp.Compare(a.Namespace, b.Namespace),
+			cmp.Compare(a.Name, b.Name),
+		)
+	})
+	return result, nil
+}
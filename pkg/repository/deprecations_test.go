@@ -0,0 +1,148 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"helm.sh/helm/v4/pkg/chart/common"
+)
+
+var _ = ginkgo.Describe("API deprecations", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+	})
+
+	ginkgo.DescribeTable(
+		"finds deprecated APIs",
+		func(
+			apiVersion string,
+			kind string,
+			kubeVersion string,
+			expectedRemovedIn string,
+			expectedRemoved bool,
+		) {
+			api, removed := findDeprecatedAPI(
+				apiVersion,
+				kind,
+				semver.MustParse(kubeVersion),
+			)
+			if expectedRemovedIn == "" {
+				g.Expect(api).To(gomega.BeNil())
+				return
+			}
+			g.Expect(api).ToNot(gomega.BeNil())
+			g.Expect(api.RemovedIn).To(gomega.Equal(expectedRemovedIn))
+			g.Expect(removed).To(gomega.Equal(expectedRemoved))
+		},
+		ginkgo.Entry("current API", "batch/v1", "CronJob", "1.30", "", false),
+		ginkgo.Entry("before deprecation", "batch/v1beta1", "CronJob", "1.20", "", false),
+		ginkgo.Entry("deprecated API", "batch/v1beta1", "CronJob", "1.21", "1.25", false),
+		ginkgo.Entry("removed API", "batch/v1beta1", "CronJob", "1.25.3", "1.25", true),
+		ginkgo.Entry("other kind", "extensions/v1beta1", "Ingress", "1.22", "1.22", true),
+	)
+
+	ginkgo.It("reports deprecated APIs in rendered resources", func() {
+		repoRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(repoRoot)
+		server, port, serverDone, err := serveDirectory(repoRoot, logger, nil)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer func() {
+			err := stopServing(server, serverDone)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+		}()
+
+		err = createSingleChartHelmRepository(
+			"test-chart",
+			"0.1.0",
+			map[string]string{
+				"Chart.yaml": strings.Join([]string{
+					"apiVersion: v2",
+					"name: test-chart",
+					"version: 0.1.0",
+				}, "\n"),
+				"templates/cronjob.yaml": strings.Join([]string{
+					"apiVersion: batch/v1beta1",
+					"kind: CronJob",
+					"metadata:",
+					"  name: {{ .Release.Name }}-cronjob",
+				}, "\n"),
+				"templates/configmap.yaml": strings.Join([]string{
+					"apiVersion: v1",
+					"kind: ConfigMap",
+					"metadata:",
+					"  name: {{ .Release.Name }}-configmap",
+				}, "\n"),
+			},
+			port,
+			repoRoot,
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		input := strings.Join([]string{
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"spec:",
+			"  chart:",
+			"    spec:",
+			"      chart: test-chart",
+			"      sourceRef:",
+			"        kind: HelmRepository",
+			"        name: local",
+			"---",
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: HelmRepository",
+			"metadata:",
+			"  namespace: testns",
+			"  name: local",
+			"spec:",
+			fmt.Sprintf("  url: http://localhost:%d", port),
+		}, "\n")
+
+		kubeVersion, err := common.ParseKubeVersion("1.25")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		deprecations, err := expander.FindAPIDeprecations(
+			bytes.NewBufferString(input),
+			ExpandOptions{KubeVersion: kubeVersion},
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(deprecations).To(gomega.Equal([]APIDeprecation{{
+			DeprecatedAPI: DeprecatedAPI{
+				APIVersion:   "batch/v1beta1",
+				Kind:         "CronJob",
+				DeprecatedIn: "1.21",
+				RemovedIn:    "1.25",
+				Replacement:  "batch/v1",
+			},
+			Removed:          true,
+			Namespace:        "testns",
+			Name:             "test-cronjob",
+			ReleaseNamespace: "testns",
+			ReleaseName:      "test",
+			Chart:            "test-chart",
+			ChartVersion:     "0.1.0",
+			Template:         "test-chart/templates/cronjob.yaml",
+		}}))
+	})
+})
@@ -0,0 +1,324 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"helm.sh/helm/v4/pkg/engine"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/kustomize/api/filters/namespace"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+
+	yamlutil "github.com/sageailabs/fouskoti/pkg/yaml"
+)
+
+// helmStorageSecretType is the type of the Secrets Helm stores the revisions
+// of releases in.
+const helmStorageSecretType = "helm.sh/release.v1"
+
+// helmControllerLabels are the labels helm-controller adds to all of the
+// resources of the releases it installs.
+var helmControllerLabels = []string{ReleaseNameLabel, ReleaseNamespaceLabel}
+
+// ReleaseDrift is a HelmRelease whose rendered resources differ from the
+// manifest of its deployed Helm release.
+type ReleaseDrift struct {
+	Namespace string
+	Name      string
+	// ReleaseName and StorageNamespace identify the Helm release.
+	ReleaseName      string
+	StorageNamespace string
+	// Missing tells that there is no deployed revision of the Helm release.
+	Missing bool
+	// Diff is a unified diff from the deployed resources to the rendered ones,
+	// in the normalized form.
+	Diff string
+}
+
+// decodeHelmStorageRelease returns the manifest of the release stored in the
+// data of a Helm storage Secret: base64 of the gzipped JSON of the release,
+// base64-encoded again as Secret data.
+func decodeHelmStorageRelease(data string) (string, error) {
+	encoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", fmt.Errorf("unable to decode Secret data: %w", err)
+	}
+	content, err := base64.StdEncoding.DecodeString(string(encoded))
+	if err != nil {
+		return "", fmt.Errorf("unable to decode release: %w", err)
+	}
+	if bytes.HasPrefix(content, []byte{0x1f, 0x8b}) {
+		reader, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return "", fmt.Errorf("unable to decompress release: %w", err)
+		}
+		content, err = io.ReadAll(reader)
+		if err != nil {
+			return "", fmt.Errorf("unable to decompress release: %w", err)
+		}
+	}
+	var release struct {
+		Manifest string `json:"manifest"`
+	}
+	if err := json.Unmarshal(content, &release); err != nil {
+		return "", fmt.Errorf("unable to parse release: %w", err)
+	}
+	return release.Manifest, nil
+}
+
+// getDeployedManifest returns the manifest of the deployed revision of the Helm
+// release from the Helm storage Secrets in the namespace served by the
+// provider, or false if there is none.
+func getDeployedManifest(
+	provider engine.ClientProvider,
+	storageNamespace string,
+	releaseName string,
+) (string, bool, error) {
+	client, _, err := provider.GetClientFor("v1", "Secret")
+	if err != nil {
+		return "", false, err
+	}
+	labels := map[string]string{"owner": "helm", "name": releaseName, "status": "deployed"}
+	list, err := client.Namespace(storageNamespace).List(
+		context.Background(),
+		metav1.ListOptions{LabelSelector: "owner=helm,name=" + releaseName + ",status=deployed"},
+	)
+	if err != nil {
+		return "", false, fmt.Errorf("unable to list Helm storage Secrets: %w", err)
+	}
+	var latest *unstructured.Unstructured
+	latestVersion := -1
+	for i := range list.Items {
+		secret := &list.Items[i]
+		secretType, _, _ := unstructured.NestedString(secret.Object, "type")
+		if secretType != helmStorageSecretType {
+			continue
+		}
+		secretLabels := secret.GetLabels()
+		matches := true
+		for key, value := range labels {
+			matches = matches && secretLabels[key] == value
+		}
+		version, err := strconv.Atoi(secretLabels["version"])
+		if matches && err == nil && version > latestVersion {
+			latest, latestVersion = secret, version
+		}
+	}
+	if latest == nil {
+		return "", false, nil
+	}
+	data, _, _ := unstructured.NestedString(latest.Object, "data", "release")
+	manifest, err := decodeHelmStorageRelease(data)
+	if err != nil {
+		return "", false, fmt.Errorf(
+			"unable to read Helm storage Secret %s/%s: %w",
+			storageNamespace,
+			latest.GetName(),
+			err,
+		)
+	}
+	return manifest, true, nil
+}
+
+// getNormalizedResources returns the resources in the normalized form, sorted
+// by their groups, kinds, namespaces, and names, without the fields the drift
+// detection rules ignore.  Helm hooks are left out, as Helm stores them apart
+// from the manifests of releases.
+func getNormalizedResources(
+	nodes []*yaml.RNode,
+	rules []driftIgnoreRule,
+) (string, error) {
+	resources := map[string]string{}
+	for _, node := range nodes {
+		if _, found := node.GetAnnotations()[helmHookAnnotation]; found {
+			continue
+		}
+		normalized := node.Copy()
+		removeIgnoredFields([]*yaml.RNode{normalized}, rules)
+		normalizeNode(normalized.Document(), "")
+		text, err := serializeNode(normalized)
+		if err != nil {
+			return "", err
+		}
+		key := strings.Join([]string{
+			yamlutil.GetGroup(node),
+			node.GetKind(),
+			node.GetNamespace(),
+			node.GetName(),
+		}, "/")
+		resources[key] = text
+	}
+	result := &strings.Builder{}
+	for _, key := range slices.Sorted(maps.Keys(resources)) {
+		result.WriteString("---\n")
+		result.WriteString(resources[key])
+	}
+	return result.String(), nil
+}
+
+// getReleaseDrift compares the resources rendered from the release, in the
+// form returned by getNormalizedResources, with the manifest of its deployed
+// Helm release, and returns nil if they match.
+func getReleaseDrift(
+	provider engine.ClientProvider,
+	release *ExpandedRelease,
+	actual string,
+) (*ReleaseDrift, error) {
+	drift := &ReleaseDrift{
+		Namespace:        release.Namespace,
+		Name:             release.Name,
+		ReleaseName:      release.ReleaseName,
+		StorageNamespace: release.StorageNamespace,
+	}
+	manifest, found, err := getDeployedManifest(
+		provider,
+		release.StorageNamespace,
+		release.ReleaseName,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		drift.Missing = true
+		return drift, nil
+	}
+
+	deployed, err := (&kio.ByteReader{
+		Reader:                strings.NewReader(manifest),
+		OmitReaderAnnotations: true,
+	}).Read()
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse deployed manifest: %w", err)
+	}
+	// The namespaces are assigned the same way as to the rendered resources.
+	deployed, err = (&namespace.Filter{
+		Namespace:              release.Namespace,
+		UnsetOnly:              true,
+		SetRoleBindingSubjects: namespace.NoSubjects,
+	}).Filter(deployed)
+	if err != nil {
+		return nil, fmt.Errorf("unable to assign namespace to deployed resources: %w", err)
+	}
+	for _, node := range deployed {
+		for _, label := range helmControllerLabels {
+			err := node.PipeE(yaml.Lookup("metadata", "labels"), yaml.Clear(label))
+			if err != nil {
+				return nil, fmt.Errorf("unable to remove helm-controller labels: %w", err)
+			}
+		}
+		err := node.PipeE(yaml.Lookup("metadata"), yaml.FieldClearer{Name: "labels", IfEmpty: true})
+		if err != nil {
+			return nil, fmt.Errorf("unable to remove helm-controller labels: %w", err)
+		}
+	}
+	expected, err := getNormalizedResources(deployed, release.driftIgnoreRules)
+	if err != nil {
+		return nil, err
+	}
+	if expected == actual {
+		return nil, nil
+	}
+	drift.Diff, err = difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(expected),
+		B:        difflib.SplitLines(actual),
+		FromFile: "deployed/" + release.StorageNamespace + "/" + release.ReleaseName,
+		ToFile:   "rendered/" + release.Namespace + "/" + release.Name,
+		Context:  3,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to compare resources: %w", err)
+	}
+	return drift, nil
+}
+
+// CheckDrift expands the HelmRelease objects in the input and compares the
+// resources rendered from each of them with the manifest of the deployed
+// revision of its Helm release, read from the Helm storage Secrets served by
+// the provider, e.g., the ones helm-controller wrote into a cluster.  It
+// returns the HelmRelease objects whose resources differ or which are not
+// deployed, ordered by their namespaces and names.
+func (expander *HelmReleaseExpander) CheckDrift(
+	input io.Reader,
+	provider engine.ClientProvider,
+	options ExpandOptions,
+) ([]ReleaseDrift, error) {
+	// The resources are normalized right away, as the expansion changes them
+	// after the callback.
+	type renderedRelease struct {
+		release   ExpandedRelease
+		resources string
+	}
+	var releases []renderedRelease
+	var errs []error
+	var mutex sync.Mutex
+	onReleaseExpanded := options.OnReleaseExpanded
+	options.OnReleaseExpanded = func(release *ExpandedRelease) {
+		if onReleaseExpanded != nil {
+			onReleaseExpanded(release)
+		}
+		resources, err := getNormalizedResources(release.Resources, release.driftIgnoreRules)
+		mutex.Lock()
+		defer mutex.Unlock()
+		if err != nil {
+			errs = append(errs, fmt.Errorf(
+				"unable to normalize resources of Helm release %s/%s: %w",
+				release.Namespace,
+				release.Name,
+				err,
+			))
+			return
+		}
+		copied := *release
+		copied.Resources = nil
+		releases = append(releases, renderedRelease{release: copied, resources: resources})
+	}
+	if err := expander.Expand(input, io.Discard, options); err != nil {
+		return nil, err
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	slices.SortFunc(releases, func(a, b renderedRelease) int {
+		return strings.Compare(
+			a.release.Namespace+"/"+a.release.Name,
+			b.release.Namespace+"/"+b.release.Name,
+		)
+	})
+
+	result := []ReleaseDrift{}
+	for _, rendered := range releases {
+		drift, err := getReleaseDrift(provider, &rendered.release, rendered.resources)
+		if err != nil {
+			errs = append(errs, fmt.Errorf(
+				"unable to check drift of Helm release %s/%s: %w",
+				rendered.release.Namespace,
+				rendered.release.Name,
+				err,
+			))
+			continue
+		}
+		if drift != nil {
+			result = append(result, *drift)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return result, nil
+}
@@ -0,0 +1,235 @@
+package repository
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+// getHelmStorageSecret returns a Helm storage Secret of the deployed revision
+// of a release with the manifest, the way Helm encodes it.
+func getHelmStorageSecret(
+	namespace string,
+	releaseName string,
+	version int,
+	status string,
+	manifest string,
+) (string, error) {
+	content, err := json.Marshal(map[string]any{
+		"name":      releaseName,
+		"namespace": namespace,
+		"version":   version,
+		"manifest":  manifest,
+	})
+	if err != nil {
+		return "", err
+	}
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write(content); err != nil {
+		return "", err
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+	release := base64.StdEncoding.EncodeToString(compressed.Bytes())
+	return strings.Join([]string{
+		"apiVersion: v1",
+		"kind: Secret",
+		"type: helm.sh/release.v1",
+		"metadata:",
+		"  namespace: " + namespace,
+		fmt.Sprintf("  name: sh.helm.release.v1.%s.v%d", releaseName, version),
+		"  labels:",
+		"    owner: helm",
+		"    name: " + releaseName,
+		"    status: " + status,
+		fmt.Sprintf("    version: %q", fmt.Sprint(version)),
+		"data:",
+		"  release: " + base64.StdEncoding.EncodeToString([]byte(release)),
+	}, "\n"), nil
+}
+
+var _ = ginkgo.Describe("Drift report", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+	})
+
+	getConfigMap := func(name string, value string, labels ...string) string {
+		lines := []string{
+			"apiVersion: v1",
+			"kind: ConfigMap",
+			"metadata:",
+			"  name: " + name + "-config",
+		}
+		if len(labels) > 0 {
+			lines = append(lines, "  labels:")
+			lines = append(lines, labels...)
+		}
+		return strings.Join(append(lines, "data:", "  value: "+value), "\n")
+	}
+
+	ginkgo.It("reads manifests of the deployed revisions", func() {
+		var secrets []string
+		for _, revision := range []struct {
+			version int
+			status  string
+		}{{1, "superseded"}, {2, "deployed"}, {3, "failed"}} {
+			secret, err := getHelmStorageSecret(
+				"testns",
+				"test",
+				revision.version,
+				revision.status,
+				getConfigMap("test", fmt.Sprint(revision.version)),
+			)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+			secrets = append(secrets, secret)
+		}
+		provider, err := NewFixtureLookupProvider(
+			strings.NewReader(strings.Join(secrets, "\n---\n")),
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		manifest, found, err := getDeployedManifest(provider, "testns", "test")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(found).To(gomega.BeTrue())
+		g.Expect(manifest).To(gomega.Equal(getConfigMap("test", "2")))
+
+		_, found, err = getDeployedManifest(provider, "testns", "other")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(found).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("reports releases differing from the deployed ones", func() {
+		repoRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(repoRoot)
+		server, port, serverDone, err := serveDirectory(repoRoot, logger, nil)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer func() {
+			g.Expect(stopServing(server, serverDone)).To(gomega.Succeed())
+		}()
+
+		err = createSingleChartHelmRepository(
+			"test-chart",
+			"0.1.0",
+			map[string]string{
+				"Chart.yaml": strings.Join([]string{
+					"apiVersion: v2",
+					"name: test-chart",
+					"version: 0.1.0",
+				}, "\n"),
+				"templates/configmap.yaml": strings.Join([]string{
+					"apiVersion: v1",
+					"kind: ConfigMap",
+					"metadata:",
+					"  name: {{ .Release.Name }}-config",
+					"data:",
+					"  value: {{ .Values.value }}",
+				}, "\n"),
+			},
+			port,
+			repoRoot,
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		getRelease := func(name string) string {
+			return strings.Join([]string{
+				"apiVersion: helm.toolkit.fluxcd.io/v2",
+				"kind: HelmRelease",
+				"metadata:",
+				"  namespace: testns",
+				"  name: " + name,
+				"spec:",
+				"  chart:",
+				"    spec:",
+				"      chart: test-chart",
+				"      sourceRef:",
+				"        kind: HelmRepository",
+				"        name: local",
+				"  values:",
+				"    value: current",
+			}, "\n")
+		}
+		input := strings.Join([]string{
+			strings.Join([]string{
+				"apiVersion: source.toolkit.fluxcd.io/v1",
+				"kind: HelmRepository",
+				"metadata:",
+				"  namespace: testns",
+				"  name: local",
+				"spec:",
+				fmt.Sprintf("  url: http://localhost:%d", port),
+			}, "\n"),
+			getRelease("matching"),
+			getRelease("drifted"),
+			getRelease("missing"),
+		}, "\n---\n")
+
+		var secrets []string
+		for name, value := range map[string]string{
+			"matching": "current",
+			"drifted":  "previous",
+		} {
+			secret, err := getHelmStorageSecret(
+				"testns",
+				name,
+				1,
+				"deployed",
+				getConfigMap(
+					name,
+					value,
+					"    helm.toolkit.fluxcd.io/name: "+name,
+					"    helm.toolkit.fluxcd.io/namespace: testns",
+				),
+			)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+			secrets = append(secrets, secret)
+		}
+		provider, err := NewFixtureLookupProvider(
+			strings.NewReader(strings.Join(secrets, "\n---\n")),
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		drifts, err := expander.CheckDrift(
+			bytes.NewBufferString(input),
+			provider,
+			ExpandOptions{},
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(drifts).To(gomega.HaveLen(2))
+
+		g.Expect(drifts[0].Name).To(gomega.Equal("drifted"))
+		g.Expect(drifts[0].ReleaseName).To(gomega.Equal("drifted"))
+		g.Expect(drifts[0].Missing).To(gomega.BeFalse())
+		g.Expect(drifts[0].Diff).To(gomega.ContainSubstring(
+			"--- deployed/testns/drifted\n+++ rendered/testns/drifted\n",
+		))
+		g.Expect(drifts[0].Diff).To(gomega.ContainSubstring("-  value: previous\n"))
+		g.Expect(drifts[0].Diff).To(gomega.ContainSubstring("+  value: current\n"))
+		g.Expect(drifts[0].Diff).ToNot(gomega.ContainSubstring("helm.toolkit.fluxcd.io"))
+
+		g.Expect(drifts[1].Name).To(gomega.Equal("missing"))
+		g.Expect(drifts[1].Missing).To(gomega.BeTrue())
+	})
+})
@@ -0,0 +1,152 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// driftIgnoreRule is a rule of the drift detection of a HelmRelease, listing
+// the fields, as JSON pointers, helm-controller disregards in the resources
+// the rule targets.
+type driftIgnoreRule struct {
+	paths    [][]string
+	matchers []func(node *yaml.RNode) bool
+}
+
+// jsonPointerReplacer unescapes the reference tokens of JSON pointers.
+var jsonPointerReplacer = strings.NewReplacer("~1", "/", "~0", "~")
+
+// parseJSONPointer returns the unescaped reference tokens of the JSON pointer.
+func parseJSONPointer(pointer string) ([]string, error) {
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q: must start with /", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = jsonPointerReplacer.Replace(token)
+	}
+	return tokens, nil
+}
+
+// getDriftIgnoreRules returns the rules of spec.driftDetection.ignore of the
+// release.
+func getDriftIgnoreRules(release *helmv2.HelmRelease) ([]driftIgnoreRule, error) {
+	if release.Spec.DriftDetection == nil {
+		return nil, nil
+	}
+	var result []driftIgnoreRule
+	for i, ignore := range release.Spec.DriftDetection.Ignore {
+		var rule driftIgnoreRule
+		for _, path := range ignore.Paths {
+			tokens, err := parseJSONPointer(path)
+			if err != nil {
+				return nil, fmt.Errorf("invalid drift detection ignore rule %d: %w", i+1, err)
+			}
+			rule.paths = append(rule.paths, tokens)
+		}
+		if target := ignore.Target; target != nil {
+			matchers, err := getTargetMatchers(&PatchTarget{
+				Group:              target.Group,
+				Version:            target.Version,
+				Kind:               target.Kind,
+				Name:               target.Name,
+				Namespace:          target.Namespace,
+				LabelSelector:      target.LabelSelector,
+				AnnotationSelector: target.AnnotationSelector,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("invalid drift detection ignore rule %d: %w", i+1, err)
+			}
+			rule.matchers = matchers
+		}
+		result = append(result, rule)
+	}
+	return result, nil
+}
+
+// removeField removes the field or the item of a list the reference tokens
+// point to from the node, if there is one.
+func removeField(node *yaml.Node, tokens []string) {
+	for _, token := range tokens[:len(tokens)-1] {
+		node = getFieldNode(node, token)
+		if node == nil {
+			return
+		}
+	}
+	last := tokens[len(tokens)-1]
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == last {
+				node.Content = slices.Delete(node.Content, i, i+2)
+				return
+			}
+		}
+	case yaml.SequenceNode:
+		index, err := strconv.Atoi(last)
+		if err == nil && index >= 0 && index < len(node.Content) {
+			node.Content = slices.Delete(node.Content, index, index+1)
+		}
+	}
+}
+
+// getFieldNode returns the value of the field or the item of a list the
+// reference token points to in the node, or nil if there is none.
+func getFieldNode(node *yaml.Node, token string) *yaml.Node {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == token {
+				return node.Content[i+1]
+			}
+		}
+	case yaml.SequenceNode:
+		index, err := strconv.Atoi(token)
+		if err == nil && index >= 0 && index < len(node.Content) {
+			return node.Content[index]
+		}
+	}
+	return nil
+}
+
+// removeIgnoredFields removes the fields the rules ignore from the resources
+// they target, in place.
+func removeIgnoredFields(nodes []*yaml.RNode, rules []driftIgnoreRule) {
+	for _, node := range nodes {
+		for _, rule := range rules {
+			if !matchesAll(rule.matchers, node) {
+				continue
+			}
+			for _, path := range rule.paths {
+				removeField(node.YNode(), path)
+			}
+		}
+	}
+}
+
+// removeIgnoredFieldsFromYAML returns the resources in the YAML text without
+// the fields the rules ignore.
+func removeIgnoredFieldsFromYAML(text string, rules []driftIgnoreRule) (string, error) {
+	nodes, err := (&kio.ByteReader{
+		Reader:                strings.NewReader(text),
+		OmitReaderAnnotations: true,
+	}).Read()
+	if err != nil {
+		return "", fmt.Errorf("unable to parse resources: %w", err)
+	}
+	removeIgnoredFields(nodes, rules)
+	var buffer bytes.Buffer
+	if err := (kio.ByteWriter{Writer: &buffer}).Write(nodes); err != nil {
+		return "", fmt.Errorf("unable to write resources: %w", err)
+	}
+	return buffer.String(), nil
+}
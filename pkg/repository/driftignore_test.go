@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"strings"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/fluxcd/pkg/apis/kustomize"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+var _ = ginkgo.Describe("Drift detection ignore rules", func() {
+	var g gomega.Gomega
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+	})
+
+	resources := strings.Join([]string{
+		"apiVersion: apps/v1",
+		"kind: Deployment",
+		"metadata:",
+		"  namespace: testns",
+		"  name: test",
+		"  annotations:",
+		"    example.com/restarted-at: yesterday",
+		"spec:",
+		"  replicas: 3",
+		"  template:",
+		"    spec:",
+		"      containers:",
+		"      - name: first",
+		"      - name: second",
+		"---",
+		"apiVersion: v1",
+		"kind: ConfigMap",
+		"metadata:",
+		"  namespace: testns",
+		"  name: test",
+		"  annotations:",
+		"    example.com/restarted-at: yesterday",
+		"data:",
+		"  value: test",
+	}, "\n")
+
+	ginkgo.It("parses JSON pointers", func() {
+		tokens, err := parseJSONPointer("/metadata/annotations/example.com~1restarted-at~0")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(tokens).To(gomega.Equal(
+			[]string{"metadata", "annotations", "example.com/restarted-at~"},
+		))
+		_, err = parseJSONPointer("spec/replicas")
+		g.Expect(err).To(gomega.HaveOccurred())
+	})
+
+	ginkgo.It("removes ignored fields from targeted resources", func() {
+		rules, err := getDriftIgnoreRules(&helmv2.HelmRelease{
+			Spec: helmv2.HelmReleaseSpec{
+				DriftDetection: &helmv2.DriftDetection{
+					Ignore: []helmv2.IgnoreRule{
+						{Paths: []string{"/metadata/annotations/example.com~1restarted-at"}},
+						{
+							Paths: []string{
+								"/spec/replicas",
+								"/spec/template/spec/containers/1",
+								"/spec/missing/field",
+							},
+							Target: &kustomize.Selector{Kind: "Deployment"},
+						},
+					},
+				},
+			},
+		})
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		result, err := removeIgnoredFieldsFromYAML(resources, rules)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(result).To(gomega.Equal(strings.Join([]string{
+			"apiVersion: apps/v1",
+			"kind: Deployment",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"  annotations: {}",
+			"spec:",
+			"  template:",
+			"    spec:",
+			"      containers:",
+			"      - name: first",
+			"---",
+			"apiVersion: v1",
+			"kind: ConfigMap",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"  annotations: {}",
+			"data:",
+			"  value: test",
+			"",
+		}, "\n")))
+	})
+
+	ginkgo.It("rejects invalid rules", func() {
+		_, err := getDriftIgnoreRules(&helmv2.HelmRelease{
+			Spec: helmv2.HelmReleaseSpec{
+				DriftDetection: &helmv2.DriftDetection{
+					Ignore: []helmv2.IgnoreRule{
+						{
+							Paths:  []string{"/spec"},
+							Target: &kustomize.Selector{LabelSelector: "a in (b"},
+						},
+					},
+				},
+			},
+		})
+		g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(
+			"invalid drift detection ignore rule 1",
+		)))
+	})
+
+	ginkgo.It("leaves out ignored fields of normalized resources", func() {
+		nodes, err := yaml.Parse(strings.Join([]string{
+			"apiVersion: v1",
+			"kind: ConfigMap",
+			"metadata:",
+			"  name: test",
+			"data:",
+			"  ignored: value",
+			"  kept: value",
+		}, "\n"))
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		rules := []driftIgnoreRule{{paths: [][]string{{"data", "ignored"}}}}
+
+		normalized, err := getNormalizedResources([]*yaml.RNode{nodes}, rules)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(normalized).ToNot(gomega.ContainSubstring("ignored"))
+		g.Expect(normalized).To(gomega.ContainSubstring("kept: value"))
+		g.Expect(nodes.MustString()).To(gomega.ContainSubstring("ignored: value"))
+	})
+})
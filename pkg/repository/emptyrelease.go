@@ -0,0 +1,31 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import "fmt"
+
+// checkEmptyExpansion warns about HelmRelease objects rendering no resources,
+// e.g., because all of the templates of their charts are disabled by the
+// values, or fails if options.FailOnEmpty is set, as they are rarely
+// intended.
+func (renderer *releaseRepoRenderer) checkEmptyExpansion(expanded *ExpandedRelease) error {
+	if len(expanded.Resources) > 0 {
+		return nil
+	}
+	if renderer.options.FailOnEmpty {
+		return NewClassifiedError(ErrorClassValidation, fmt.Errorf(
+			"no resources rendered from chart %s %s of Helm release %s/%s",
+			expanded.Chart,
+			expanded.ChartVersion,
+			expanded.Namespace,
+			expanded.Name,
+		))
+	}
+	renderer.config.logger.
+		With("namespace", expanded.Namespace).
+		With("name", expanded.Name).
+		With("chart", expanded.Chart).
+		With("version", expanded.ChartVersion).
+		Warn("Helm release rendered no resources")
+	return nil
+}
@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"bytes"
+	"log/slog"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+var _ = ginkgo.Describe("Empty expansions", func() {
+	var g gomega.Gomega
+	var logs *bytes.Buffer
+	var config loaderConfig
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		logs = &bytes.Buffer{}
+		config = loaderConfig{logger: slog.New(slog.NewTextHandler(logs, nil))}
+	})
+
+	expanded := &ExpandedRelease{
+		Namespace:    "testns",
+		Name:         "test",
+		Chart:        "test-chart",
+		ChartVersion: "0.1.0",
+	}
+
+	ginkgo.It("warns about releases rendering no resources", func() {
+		renderer := newReleaseRepoRenderer(config, ExpandOptions{})
+		g.Expect(renderer.checkEmptyExpansion(expanded)).To(gomega.Succeed())
+		g.Expect(logs.String()).To(gomega.ContainSubstring(
+			`msg="Helm release rendered no resources" namespace=testns name=test`,
+		))
+	})
+
+	ginkgo.It("fails on releases rendering no resources if requested", func() {
+		renderer := newReleaseRepoRenderer(config, ExpandOptions{FailOnEmpty: true})
+		err := renderer.checkEmptyExpansion(expanded)
+		g.Expect(err).To(gomega.MatchError(
+			"no resources rendered from chart test-chart 0.1.0 of Helm release testns/test",
+		))
+		g.Expect(GetErrorClass(err)).To(gomega.Equal(ErrorClassValidation))
+	})
+
+	ginkgo.It("accepts releases rendering resources", func() {
+		renderer := newReleaseRepoRenderer(config, ExpandOptions{FailOnEmpty: true})
+		nonEmpty := *expanded
+		nonEmpty.Resources = []*yaml.RNode{
+			yaml.MustParse("apiVersion: v1\nkind: ConfigMap\nmetadata: {name: test}\n"),
+		}
+		g.Expect(renderer.checkEmptyExpansion(&nonEmpty)).To(gomega.Succeed())
+		g.Expect(logs.String()).To(gomega.BeEmpty())
+	})
+})
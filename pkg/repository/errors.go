@@ -0,0 +1,123 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"oras.land/oras-go/v2/registry/remote/errcode"
+)
+
+// ErrorClass classifies failures, so that callers can react to them
+// differently, e.g. retry on network failures but not on template errors.
+type ErrorClass int
+
+const (
+	ErrorClassUnknown ErrorClass = iota
+	// ErrorClassInput marks input which cannot be parsed or is inconsistent.
+	ErrorClassInput
+	// ErrorClassAuth marks missing credentials and authentication failures.
+	ErrorClassAuth
+	// ErrorClassFetch marks failures to fetch charts, indexes, and
+	// repositories, including cache misses in the offline mode.
+	ErrorClassFetch
+	// ErrorClassRender marks failures to render charts.
+	ErrorClassRender
+	// ErrorClassValidation marks rendered resources or values failing checks,
+	// like chart schemas, locks, and policies.
+	ErrorClassValidation
+)
+
+// ClassifiedError wraps an error with its class.
+type ClassifiedError struct {
+	Class ErrorClass
+	Err   error
+}
+
+func (err *ClassifiedError) Error() string {
+	return err.Err.Error()
+}
+
+func (err *ClassifiedError) Unwrap() error {
+	return err.Err
+}
+
+// NewClassifiedError wraps the error with the class.
+func NewClassifiedError(class ErrorClass, err error) error {
+	return &ClassifiedError{Class: class, Err: err}
+}
+
+// GetErrorClass returns the class of the outermost classified error in the
+// chain, or ErrorClassUnknown if there is none.
+func GetErrorClass(err error) ErrorClass {
+	var classifiedErr *ClassifiedError
+	if errors.As(err, &classifiedErr) {
+		return classifiedErr.Class
+	}
+	var cacheMissErr *CacheMissError
+	if errors.As(err, &cacheMissErr) {
+		return ErrorClassFetch
+	}
+	return ErrorClassUnknown
+}
+
+// httpStatusError reports an unexpected HTTP status of a response.
+type httpStatusError struct {
+	url        string
+	statusCode int
+	status     string
+}
+
+func (err *httpStatusError) Error() string {
+	return fmt.Sprintf("unable to fetch %s: %s", err.url, err.status)
+}
+
+// authFailureStatuses are the HTTP status lines the Helm getters, which don't
+// return typed errors, report authentication failures with.
+var authFailureStatuses = []string{
+	": 401 Unauthorized",
+	": 403 Forbidden",
+}
+
+// isAuthFailure tells whether the error of a Helm, registry, or Git client
+// is an authentication or authorization failure.
+func isAuthFailure(err error) bool {
+	if errors.Is(err, transport.ErrAuthenticationRequired) ||
+		errors.Is(err, transport.ErrAuthorizationFailed) {
+		return true
+	}
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.statusCode == http.StatusUnauthorized ||
+			statusErr.statusCode == http.StatusForbidden
+	}
+	var responseErr *errcode.ErrorResponse
+	if errors.As(err, &responseErr) {
+		if responseErr.StatusCode == http.StatusUnauthorized ||
+			responseErr.StatusCode == http.StatusForbidden {
+			return true
+		}
+		return slices.ContainsFunc(responseErr.Errors, func(err errcode.Error) bool {
+			return err.Code == errcode.ErrorCodeUnauthorized ||
+				err.Code == errcode.ErrorCodeDenied
+		})
+	}
+	message := err.Error()
+	return slices.ContainsFunc(authFailureStatuses, func(status string) bool {
+		return strings.Contains(message, status)
+	})
+}
+
+// newFetchError classifies a failure to fetch from a remote repository as an
+// authentication failure or as a fetch failure.
+func newFetchError(err error) error {
+	if isAuthFailure(err) {
+		return NewClassifiedError(ErrorClassAuth, err)
+	}
+	return NewClassifiedError(ErrorClassFetch, err)
+}
@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"oras.land/oras-go/v2/registry/remote/errcode"
+)
+
+var _ = ginkgo.Describe("Error classes", func() {
+	var g gomega.Gomega
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+	})
+
+	ginkgo.It("finds the class of wrapped errors", func() {
+		err := fmt.Errorf(
+			"unable to load chart: %w",
+			NewClassifiedError(ErrorClassRender, errors.New("template error")),
+		)
+		g.Expect(GetErrorClass(err)).To(gomega.Equal(ErrorClassRender))
+		g.Expect(err).To(gomega.MatchError("unable to load chart: template error"))
+		g.Expect(GetErrorClass(errors.New("other"))).To(gomega.Equal(ErrorClassUnknown))
+	})
+
+	ginkgo.It("classifies cache misses as fetch failures", func() {
+		err := fmt.Errorf("offline: %w", &CacheMissError{Resource: "chart"})
+		g.Expect(GetErrorClass(err)).To(gomega.Equal(ErrorClassFetch))
+	})
+
+	ginkgo.It("tells authentication failures from fetch failures", func() {
+		g.Expect(GetErrorClass(newFetchError(errors.New(
+			"failed to fetch https://charts.example.com/index.yaml : 401 Unauthorized",
+		)))).To(gomega.Equal(ErrorClassAuth))
+		g.Expect(GetErrorClass(newFetchError(errors.New(
+			"dial tcp: lookup charts.example.com: no such host",
+		)))).To(gomega.Equal(ErrorClassFetch))
+	})
+
+	ginkgo.It("tells authentication failures by typed errors", func() {
+		g.Expect(GetErrorClass(newFetchError(fmt.Errorf(
+			"unable to clone: %w",
+			transport.ErrAuthenticationRequired,
+		)))).To(gomega.Equal(ErrorClassAuth))
+		g.Expect(GetErrorClass(newFetchError(&httpStatusError{
+			url:        "https://charts.example.com/index.yaml",
+			statusCode: http.StatusForbidden,
+			status:     "403 Forbidden",
+		}))).To(gomega.Equal(ErrorClassAuth))
+		g.Expect(GetErrorClass(newFetchError(fmt.Errorf(
+			"unable to fetch tags: %w",
+			&errcode.ErrorResponse{
+				Method:     http.MethodGet,
+				StatusCode: http.StatusNotFound,
+				Errors: errcode.Errors{
+					errcode.Error{Code: errcode.ErrorCodeUnauthorized},
+				},
+			},
+		)))).To(gomega.Equal(ErrorClassAuth))
+	})
+
+	ginkgo.It("doesn't take any mention of authorization for a failure", func() {
+		g.Expect(GetErrorClass(newFetchError(errors.New(
+			"unable to download chart unauthorized-chart-1.0.0.tgz: 404 Not Found",
+		)))).To(gomega.Equal(ErrorClassFetch))
+		g.Expect(GetErrorClass(newFetchError(&httpStatusError{
+			url:        "https://charts.example.com/unauthorized/index.yaml",
+			statusCode: http.StatusInternalServerError,
+			status:     "500 Internal Server Error",
+		}))).To(gomega.Equal(ErrorClassFetch))
+	})
+
+	ginkgo.It("classifies input errors of the expansion", func() {
+		logger := slog.New(slog.NewTextHandler(ginkgo.GinkgoWriter, nil))
+		expander := NewHelmReleaseExpander(context.Background(), logger, nil, nil)
+		input := strings.Join([]string{
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"spec:",
+			"  chart:",
+			"    spec:",
+			"      chart: test-chart",
+			"      sourceRef:",
+			"        kind: HelmRepository",
+			"        name: missing",
+		}, "\n")
+
+		var output bytes.Buffer
+		err := expander.Expand(bytes.NewBufferString(input), &output, ExpandOptions{})
+		g.Expect(err).To(gomega.HaveOccurred())
+		g.Expect(GetErrorClass(err)).To(gomega.Equal(ErrorClassInput))
+
+		err = expander.Expand(
+			bytes.NewBufferString("key: [unterminated"),
+			&output,
+			ExpandOptions{},
+		)
+		g.Expect(err).To(gomega.HaveOccurred())
+		g.Expect(GetErrorClass(err)).To(gomega.Equal(ErrorClassInput))
+	})
+})
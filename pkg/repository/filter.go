@@ -0,0 +1,144 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+
+	yamlutil "github.com/sageailabs/fouskoti/pkg/yaml"
+)
+
+// ReleaseFilter selects the HelmRelease objects in the input to expand.
+// HelmRelease objects not matching the filter are passed through as is.
+type ReleaseFilter struct {
+	Selector  labels.Selector
+	Namespace string
+	Name      string
+}
+
+// NewReleaseFilter creates a filter from a label selector and a namespace and
+// a name to match.  Empty values match any HelmRelease.
+func NewReleaseFilter(
+	selector string,
+	namespace string,
+	name string,
+) (*ReleaseFilter, error) {
+	parsedSelector, err := labels.Parse(selector)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"unable to parse label selector %s: %w",
+			selector,
+			err,
+		)
+	}
+	return &ReleaseFilter{
+		Selector:  parsedSelector,
+		Namespace: namespace,
+		Name:      name,
+	}, nil
+}
+
+func (filter *ReleaseFilter) matches(node *yaml.RNode) bool {
+	if filter == nil {
+		return true
+	}
+	if filter.Namespace != "" && node.GetNamespace() != filter.Namespace {
+		return false
+	}
+	if filter.Name != "" && node.GetName() != filter.Name {
+		return false
+	}
+	if filter.Selector != nil &&
+		!filter.Selector.Matches(labels.Set(node.GetLabels())) {
+		return false
+	}
+	return true
+}
+
+// filterReleases returns the nodes excluding the HelmRelease objects not
+// matching the filter.
+func (filter *ReleaseFilter) filterReleases(nodes []*yaml.RNode) []*yaml.RNode {
+	result := []*yaml.RNode{}
+	for _, node := range nodes {
+		if yamlutil.GetGroup(node) == "helm.toolkit.fluxcd.io" &&
+			node.GetKind() == "HelmRelease" &&
+			!filter.matches(node) {
+			continue
+		}
+		result = append(result, node)
+	}
+	return result
+}
+
+// ReleaseSkipList lists HelmRelease objects, as namespace/name, which are not
+// expanded, e.g. because their charts cannot be rendered offline.  Skipped
+// HelmRelease objects are passed through with a comment noting the skip.
+type ReleaseSkipList map[string]struct{}
+
+// NewReleaseSkipList creates a skip list from namespace/name entries.
+func NewReleaseSkipList(entries []string) (ReleaseSkipList, error) {
+	skipList := ReleaseSkipList{}
+	for _, entry := range entries {
+		if err := skipList.add(entry); err != nil {
+			return nil, err
+		}
+	}
+	return skipList, nil
+}
+
+// Read adds namespace/name entries from the input, one per line,
+// to the skip list.  Empty lines and lines starting with # are ignored.
+func (skipList ReleaseSkipList) Read(input io.Reader) error {
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := skipList.add(line); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("unable to read skip list: %w", err)
+	}
+	return nil
+}
+
+func (skipList ReleaseSkipList) add(entry string) error {
+	namespace, name, found := strings.Cut(entry, "/")
+	if !found || namespace == "" || name == "" || strings.Contains(name, "/") {
+		return fmt.Errorf(
+			"invalid Helm release reference %s, expected <namespace>/<name>",
+			entry,
+		)
+	}
+	skipList[entry] = struct{}{}
+	return nil
+}
+
+func (skipList ReleaseSkipList) contains(node *yaml.RNode) bool {
+	_, found := skipList[node.GetNamespace()+"/"+node.GetName()]
+	return found
+}
+
+// filterReleases returns the nodes excluding the HelmRelease objects in the
+// skip list.
+func (skipList ReleaseSkipList) filterReleases(nodes []*yaml.RNode) []*yaml.RNode {
+	result := []*yaml.RNode{}
+	for _, node := range nodes {
+		if yamlutil.GetGroup(node) == "helm.toolkit.fluxcd.io" &&
+			node.GetKind() == "HelmRelease" &&
+			skipList.contains(node) {
+			continue
+		}
+		result = append(result, node)
+	}
+	return result
+}
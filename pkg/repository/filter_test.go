@@ -0,0 +1,279 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+var _ = ginkgo.Describe("Release filter", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+	})
+
+	getRelease := func(namespace string, name string, team string) string {
+		return strings.Join([]string{
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: " + namespace,
+			"  name: " + name,
+			"  labels:",
+			"    team: " + team,
+			"spec:",
+			"  releaseName: " + name,
+			"  chart:",
+			"    spec:",
+			"      chart: test-chart",
+			"      sourceRef:",
+			"        kind: HelmRepository",
+			"        name: local",
+			"        namespace: flux-system",
+		}, "\n")
+	}
+
+	expand := func(
+		releaseFilter *ReleaseFilter,
+		skipList ReleaseSkipList,
+	) (string, []*yaml.RNode) {
+		repoRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(repoRoot)
+		server, port, serverDone, err := serveDirectory(repoRoot, logger, nil)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer func() {
+			err := stopServing(server, serverDone)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+		}()
+
+		err = createSingleChartHelmRepository(
+			"test-chart",
+			"0.1.0",
+			map[string]string{
+				"Chart.yaml": strings.Join([]string{
+					"apiVersion: v2",
+					"name: test-chart",
+					"version: 0.1.0",
+				}, "\n"),
+				"templates/configmap.yaml": strings.Join([]string{
+					"apiVersion: v1",
+					"kind: ConfigMap",
+					"metadata:",
+					"  name: {{ .Release.Name }}-configmap",
+				}, "\n"),
+			},
+			port,
+			repoRoot,
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		input := strings.Join([]string{
+			getRelease("ns1", "first", "alpha"),
+			"---",
+			getRelease("ns2", "second", "beta"),
+			"---",
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: HelmRepository",
+			"metadata:",
+			"  namespace: flux-system",
+			"  name: local",
+			"spec:",
+			fmt.Sprintf("  url: http://localhost:%d", port),
+		}, "\n")
+
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		output := &bytes.Buffer{}
+		err = expander.Expand(
+			bytes.NewBufferString(input),
+			output,
+			ExpandOptions{
+				ReleaseFilter: releaseFilter,
+				SkipList:      skipList,
+			},
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		result := output.String()
+		nodes, err := (&kio.ByteReader{Reader: output}).Read()
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		return result, nodes
+	}
+
+	getConfigMaps := func(nodes []*yaml.RNode) []string {
+		configMaps := []string{}
+		for _, node := range nodes {
+			if node.GetKind() == "ConfigMap" {
+				configMaps = append(configMaps, node.GetNamespace()+"/"+node.GetName())
+			}
+		}
+		return configMaps
+	}
+
+	ginkgo.DescribeTable(
+		"expands only matching Helm releases",
+		func(
+			selector string,
+			namespace string,
+			name string,
+			expectedConfigMaps []string,
+		) {
+			releaseFilter, err := NewReleaseFilter(selector, namespace, name)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+
+			_, nodes := expand(releaseFilter, nil)
+			// All of the input objects are passed through.
+			g.Expect(nodes).To(gomega.HaveLen(len(expectedConfigMaps) + 3))
+			g.Expect(getConfigMaps(nodes)).To(gomega.Equal(expectedConfigMaps))
+		},
+		ginkgo.Entry(
+			"without filters",
+			"",
+			"",
+			"",
+			[]string{"ns1/first-configmap", "ns2/second-configmap"},
+		),
+		ginkgo.Entry(
+			"by label selector",
+			"team=alpha",
+			"",
+			"",
+			[]string{"ns1/first-configmap"},
+		),
+		ginkgo.Entry(
+			"by set-based label selector",
+			"team notin (alpha)",
+			"",
+			"",
+			[]string{"ns2/second-configmap"},
+		),
+		ginkgo.Entry(
+			"by namespace",
+			"",
+			"ns2",
+			"",
+			[]string{"ns2/second-configmap"},
+		),
+		ginkgo.Entry(
+			"by name",
+			"",
+			"",
+			"first",
+			[]string{"ns1/first-configmap"},
+		),
+		ginkgo.Entry(
+			"by all criteria",
+			"team=alpha",
+			"ns2",
+			"",
+			[]string{},
+		),
+	)
+
+	ginkgo.It("passes through skipped Helm releases with a note", func() {
+		skipList, err := NewReleaseSkipList([]string{"ns2/second"})
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		output, nodes := expand(nil, skipList)
+		g.Expect(nodes).To(gomega.HaveLen(4))
+		g.Expect(getConfigMaps(nodes)).To(gomega.Equal([]string{"ns1/first-configmap"}))
+		g.Expect(output).To(gomega.ContainSubstring(strings.Join([]string{
+			"# Expansion skipped: the Helm release is in the skip list",
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: ns2",
+			"  name: second",
+		}, "\n")))
+	})
+
+	ginkgo.It("never looks up the sources of excluded Helm releases", func() {
+		skipList, err := NewReleaseSkipList([]string{"ns2/second"})
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		releaseFilter, err := NewReleaseFilter("team=beta", "", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		// The first release would fail fetching its chart from an unreachable
+		// repository, the second one resolving its missing HelmChart.
+		input := strings.Join([]string{
+			getRelease("ns1", "first", "alpha"),
+			"---",
+			strings.Replace(
+				getRelease("ns2", "second", "beta"),
+				"kind: HelmRepository",
+				"kind: HelmChart",
+				1,
+			),
+			"---",
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: HelmRepository",
+			"metadata:",
+			"  namespace: flux-system",
+			"  name: local",
+			"spec:",
+			"  url: http://127.0.0.1:1",
+		}, "\n")
+
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		output := &bytes.Buffer{}
+		err = expander.Expand(
+			bytes.NewBufferString(input),
+			output,
+			ExpandOptions{ReleaseFilter: releaseFilter, SkipList: skipList},
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		nodes, err := (&kio.ByteReader{Reader: output}).Read()
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(nodes).To(gomega.HaveLen(3))
+
+		resolved, err := expander.ResolveHelmReleases(
+			bytes.NewBufferString(input),
+			ExpandOptions{ReleaseFilter: releaseFilter, SkipList: skipList},
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(resolved).To(gomega.BeEmpty())
+	})
+
+	ginkgo.It("reads skip lists", func() {
+		skipList, err := NewReleaseSkipList([]string{"ns1/first"})
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		err = skipList.Read(bytes.NewBufferString(strings.Join([]string{
+			"# Charts using the lookup function",
+			"ns2/second",
+			"",
+			"  ns3/third  ",
+		}, "\n")))
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(skipList).To(gomega.HaveLen(3))
+		g.Expect(skipList).To(gomega.HaveKey("ns3/third"))
+
+		err = skipList.Read(bytes.NewBufferString("fourth"))
+		g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(
+			"invalid Helm release reference fourth",
+		)))
+	})
+
+	ginkgo.It("rejects invalid label selectors", func() {
+		_, err := NewReleaseFilter("team in alpha", "", "")
+		g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(
+			"unable to parse label selector team in alpha",
+		)))
+	})
+})
@@ -0,0 +1,164 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+
+	yamlutil "github.com/sageailabs/fouskoti/pkg/yaml"
+)
+
+// FluxInventoryFormat determines how the Flux inventory of the output is
+// written.
+type FluxInventoryFormat string
+
+const (
+	// FluxInventoryFormatJSON writes the inventory as JSON in the format of
+	// the status.inventory field of Flux Kustomization objects.
+	FluxInventoryFormatJSON FluxInventoryFormat = "json"
+	// FluxInventoryFormatConfigMap writes the inventory as a ConfigMap in the
+	// format of the inventories of cli-utils, which Flux applies objects
+	// with, whose data keys are the IDs of the objects.
+	FluxInventoryFormatConfigMap FluxInventoryFormat = "configmap"
+)
+
+const (
+	// FluxInventoryName is the name of the inventory ConfigMap.
+	FluxInventoryName = "fouskoti-inventory"
+	// fluxInventoryIDLabel is the label identifying inventory ConfigMap
+	// objects of cli-utils.
+	fluxInventoryIDLabel = "cli-utils.sigs.k8s.io/inventory-id"
+)
+
+// ParseFluxInventoryFormat returns the Flux inventory format named by the
+// value.
+func ParseFluxInventoryFormat(value string) (FluxInventoryFormat, error) {
+	switch format := FluxInventoryFormat(value); format {
+	case FluxInventoryFormatJSON, FluxInventoryFormatConfigMap:
+		return format, nil
+	default:
+		return "", fmt.Errorf(
+			"invalid Flux inventory format %s, expected %s or %s",
+			value,
+			FluxInventoryFormatJSON,
+			FluxInventoryFormatConfigMap,
+		)
+	}
+}
+
+// FluxInventoryEntry identifies an object of the output the way Flux does in
+// its inventories.
+type FluxInventoryEntry struct {
+	// ID is <namespace>_<name>_<group>_<kind>, with colons in the name
+	// replaced by double underscores.
+	ID string `json:"id"`
+	// Version is the API version of the object without the group.
+	Version string `json:"v"`
+}
+
+// FluxInventory lists the objects of the output of an expansion in the
+// format Flux tracks the objects it applies in, so that renders can be
+// compared to the inventories of clusters, e.g., by garbage collection
+// tools.
+type FluxInventory struct {
+	Entries []FluxInventoryEntry `json:"entries"`
+}
+
+// fluxInventoryConfigMap is the inventory written as a ConfigMap.
+type fluxInventoryConfigMap struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Metadata   struct {
+		Name   string            `yaml:"name"`
+		Labels map[string]string `yaml:"labels"`
+	} `yaml:"metadata"`
+	Data map[string]string `yaml:"data"`
+}
+
+// getFluxInventoryEntry returns the inventory entry of the node.
+func getFluxInventoryEntry(node *kyaml.RNode) FluxInventoryEntry {
+	_, version, found := strings.Cut(node.GetApiVersion(), "/")
+	if !found {
+		version = node.GetApiVersion()
+	}
+	return FluxInventoryEntry{
+		ID: strings.Join([]string{
+			node.GetNamespace(),
+			strings.ReplaceAll(node.GetName(), ":", "__"),
+			yamlutil.GetGroup(node),
+			node.GetKind(),
+		}, "_"),
+		Version: version,
+	}
+}
+
+// add records the objects of the output.  Objects which are not applied to
+// clusters, like the chart metadata, are left out.
+func (inventory *FluxInventory) add(nodes []*kyaml.RNode) {
+	for _, node := range nodes {
+		if node.GetAnnotations()[localConfigAnnotation] == "true" {
+			continue
+		}
+		inventory.Entries = append(inventory.Entries, getFluxInventoryEntry(node))
+	}
+}
+
+// Write writes the inventory in the format, with the entries sorted by their
+// IDs as Flux does.
+func (inventory *FluxInventory) Write(output io.Writer, format FluxInventoryFormat) error {
+	slices.SortFunc(inventory.Entries, func(a, b FluxInventoryEntry) int {
+		return cmp.Or(cmp.Compare(a.ID, b.ID), cmp.Compare(a.Version, b.Version))
+	})
+	inventory.Entries = slices.CompactFunc(inventory.Entries, func(a, b FluxInventoryEntry) bool {
+		return a.ID == b.ID
+	})
+	switch format {
+	case FluxInventoryFormatJSON:
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(inventory); err != nil {
+			return fmt.Errorf("unable to write Flux inventory: %w", err)
+		}
+		return nil
+	case FluxInventoryFormatConfigMap:
+		configMap := fluxInventoryConfigMap{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+			Data:       map[string]string{},
+		}
+		configMap.Metadata.Name = FluxInventoryName
+		configMap.Metadata.Labels = map[string]string{fluxInventoryIDLabel: FluxInventoryName}
+		for _, entry := range inventory.Entries {
+			configMap.Data[entry.ID] = ""
+		}
+		encoder := yaml.NewEncoder(output)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(configMap); err != nil {
+			return fmt.Errorf("unable to write Flux inventory: %w", err)
+		}
+		return encoder.Close()
+	default:
+		return fmt.Errorf("unknown Flux inventory format %s", format)
+	}
+}
+
+// fluxInventoryWriter records the objects written to the output in the Flux
+// inventory.
+type fluxInventoryWriter struct {
+	writer    kio.Writer
+	inventory *FluxInventory
+}
+
+func (writer *fluxInventoryWriter) Write(nodes []*kyaml.RNode) error {
+	writer.inventory.add(nodes)
+	return writer.writer.Write(nodes)
+}
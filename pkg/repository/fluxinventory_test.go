@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Flux inventory", func() {
+	var g gomega.Gomega
+	var expander *HelmReleaseExpander
+
+	input := strings.Join([]string{
+		"apiVersion: v1",
+		"kind: ConfigMap",
+		"metadata:",
+		"  namespace: testns",
+		"  name: settings",
+		"---",
+		"apiVersion: rbac.authorization.k8s.io/v1",
+		"kind: ClusterRole",
+		"metadata:",
+		"  name: system:reader",
+		"---",
+		"apiVersion: v1",
+		"kind: ConfigMap",
+		"metadata:",
+		"  namespace: testns",
+		"  name: chart-metadata",
+		"  annotations:",
+		"    config.kubernetes.io/local-config: \"true\"",
+		"",
+	}, "\n")
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		logger := slog.New(slog.NewTextHandler(ginkgo.GinkgoWriter, nil))
+		expander = NewHelmReleaseExpander(context.Background(), logger, nil, nil)
+	})
+
+	expand := func(options ExpandOptions) string {
+		var inventory bytes.Buffer
+		options.FluxInventoryOutput = &inventory
+		err := expander.Expand(bytes.NewBufferString(input), &bytes.Buffer{}, options)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		return inventory.String()
+	}
+
+	ginkgo.It("lists the objects of the output as JSON", func() {
+		g.Expect(expand(ExpandOptions{})).To(gomega.MatchJSON(`{
+			"entries": [
+				{"id": "_system__reader_rbac.authorization.k8s.io_ClusterRole", "v": "v1"},
+				{"id": "testns_settings__ConfigMap", "v": "v1"}
+			]
+		}`))
+	})
+
+	ginkgo.It("lists the objects of the streamed output", func() {
+		g.Expect(expand(ExpandOptions{Streaming: true})).To(
+			gomega.ContainSubstring(`"id": "testns_settings__ConfigMap"`),
+		)
+	})
+
+	ginkgo.It("lists the objects of the output as a ConfigMap", func() {
+		g.Expect(expand(ExpandOptions{FluxInventoryFormat: FluxInventoryFormatConfigMap})).To(
+			gomega.Equal(strings.Join([]string{
+				"apiVersion: v1",
+				"kind: ConfigMap",
+				"metadata:",
+				"  name: fouskoti-inventory",
+				"  labels:",
+				"    cli-utils.sigs.k8s.io/inventory-id: fouskoti-inventory",
+				"data:",
+				"  _system__reader_rbac.authorization.k8s.io_ClusterRole: \"\"",
+				"  testns_settings__ConfigMap: \"\"",
+				"",
+			}, "\n")),
+		)
+	})
+
+	ginkgo.It("rejects unknown formats", func() {
+		_, err := ParseFluxInventoryFormat("xml")
+		g.Expect(err).To(gomega.HaveOccurred())
+	})
+})
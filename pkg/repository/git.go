@@ -4,6 +4,7 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/url"
 	"os"
@@ -11,12 +12,14 @@ import (
 	"strings"
 	"time"
 
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
 	"github.com/fluxcd/pkg/git"
 	"github.com/fluxcd/pkg/git/gogit"
 	"github.com/fluxcd/pkg/git/repository"
 	sourcev1 "github.com/fluxcd/source-controller/api/v1"
 	chart "helm.sh/helm/v4/pkg/chart/v2"
 	helmloader "helm.sh/helm/v4/pkg/chart/v2/loader"
+	chartutil "helm.sh/helm/v4/pkg/chart/v2/util"
 	"sigs.k8s.io/kustomize/kyaml/yaml"
 )
 
@@ -58,6 +61,80 @@ func isFixedGitReference(ref *sourcev1.GitRepositoryRef) bool {
 	return false
 }
 
+func describeGitReference(ref *sourcev1.GitRepositoryRef) string {
+	switch {
+	case ref.Commit != "":
+		return "commit " + ref.Commit
+	case ref.Name != "":
+		return "reference " + ref.Name
+	case ref.SemVer != "":
+		return "semver " + ref.SemVer
+	case ref.Tag != "":
+		return "tag " + ref.Tag
+	default:
+		return "branch " + ref.Branch
+	}
+}
+
+// isArbitraryGitReference tells whether the reference is a reference name
+// outside of branches and tags, e.g., refs/pull/123/head or
+// refs/merge-requests/1/head.  Such references move, but the checkouts of the
+// commits they point to are cached like the checkouts of fixed commits.
+func isArbitraryGitReference(ref *sourcev1.GitRepositoryRef) bool {
+	return ref.Commit == "" &&
+		ref.Name != "" &&
+		!strings.HasPrefix(ref.Name, "refs/heads/") &&
+		!strings.HasPrefix(ref.Name, "refs/tags/")
+}
+
+// getResolvedGitRefCachePath returns the path of the checkout of the
+// repository at the commit in the cache directory of the repository, shared
+// with GitRepository objects referring to the commit directly.
+func getResolvedGitRefCachePath(repoCacheDir string, commit string) string {
+	return path.Join(repoCacheDir, getGitRefCacheKey("####"+commit))
+}
+
+// readGitRevision returns the revision, as <name>@sha1:<commit>, an arbitrary
+// reference was last resolved to, and the path of its checkout, or empty
+// strings if either is not in the cache.
+func readGitRevision(revisionPath string) (string, string) {
+	content, err := os.ReadFile(revisionPath)
+	if err != nil {
+		return "", ""
+	}
+	revision := strings.TrimSpace(string(content))
+	commit := git.ExtractHashFromRevision(revision).String()
+	if commit == "" {
+		return "", ""
+	}
+	repoPath := getResolvedGitRefCachePath(path.Dir(revisionPath), commit)
+	if stat, err := os.Stat(repoPath); err != nil || !stat.IsDir() {
+		return "", ""
+	}
+	return revision, repoPath
+}
+
+// writeGitRevision records the revision an arbitrary reference was resolved
+// to, replacing the file atomically for concurrent invocations.
+func writeGitRevision(revisionPath string, revision string) error {
+	file, err := os.CreateTemp(path.Dir(revisionPath), ".tmp-")
+	if err != nil {
+		return fmt.Errorf("unable to create temporary file for %s: %w", revisionPath, err)
+	}
+	_, err = file.WriteString(revision + "\n")
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), revisionPath)
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return fmt.Errorf("unable to write %s: %w", revisionPath, err)
+	}
+	return nil
+}
+
 func ParseGitRepoSubstitution(subst string) (*GitRepoSubstitution, error) {
 	if subst == "" {
 		return nil, nil
@@ -78,39 +155,71 @@ func ParseGitRepoSubstitution(subst string) (*GitRepoSubstitution, error) {
 			subst,
 		)
 	}
-	var stat os.FileInfo
-	var err error
-	if stat, err = os.Stat(path); err != nil {
-		return nil, fmt.Errorf("unable to access working copy path %s: %w", path, err)
-	}
-	if !stat.IsDir() {
-		return nil, fmt.Errorf("working copy path %s is not a directory", path)
-	}
-	return &GitRepoSubstitution{
+	substitution := &GitRepoSubstitution{
 		URL:    parts[0],
 		Branch: branch,
 		Path:   path,
-	}, nil
+	}
+	// A GitRepository reference has the form <namespace>/<name>, which is
+	// never a valid repository URL.
+	if !strings.Contains(parts[0], "://") {
+		if namespace, name, found := strings.Cut(parts[0], "/"); found {
+			substitution.URL = ""
+			substitution.Namespace = namespace
+			substitution.Name = name
+		}
+	}
+	if err := substitution.validate(); err != nil {
+		return nil, err
+	}
+	return substitution, nil
 }
 
-func (loader *gitRepoChartLoader) isSubstitutionTarget(
+// getSubstitution returns the first substitution matching the repository, or
+// nil if there is none.
+func (loader *gitRepoChartLoader) getSubstitution(
 	repo *sourcev1.GitRepository,
 	repoURL string,
-) bool {
-	if loader.gitRepoSubstitution == nil {
-		return false
-	}
+) *GitRepoSubstitution {
+	var repoNamespace, repoName, repoBranch string
 	if repo != nil {
 		repoURL = repo.Spec.URL
+		repoNamespace = repo.Namespace
+		repoName = repo.Name
+		if repo.Spec.Reference != nil {
+			repoBranch = repo.Spec.Reference.Branch
+		}
 	}
-	if loader.gitRepoSubstitution.URL != repoURL {
-		return false
+	for _, substitution := range loader.gitRepoSubstitutions {
+		if substitution.matches(repoURL, repoNamespace, repoName, repoBranch) {
+			return substitution
+		}
 	}
-	repoBranch := ""
-	if repo != nil && repo.Spec.Reference != nil {
-		repoBranch = repo.Spec.Reference.Branch
+	return nil
+}
+
+// matches reports whether the substitution applies to the repository.
+// Substitutions referencing a GitRepository object by name match any of its
+// branches unless they name one; substitutions by URL without a branch only
+// match the default branch.
+func (substitution *GitRepoSubstitution) matches(
+	repoURL string,
+	repoNamespace string,
+	repoName string,
+	repoBranch string,
+) bool {
+	if substitution.Name != "" {
+		if repoName == "" ||
+			substitution.Namespace != repoNamespace ||
+			substitution.Name != repoName {
+			return false
+		}
+		return substitution.Branch == "" || substitution.Branch == repoBranch
+	}
+	if substitution.URL != repoURL {
+		return false
 	}
-	if loader.gitRepoSubstitution.Branch == "" {
+	if substitution.Branch == "" {
 		switch repoBranch {
 		case "", "master", "main":
 			return true
@@ -118,65 +227,63 @@ func (loader *gitRepoChartLoader) isSubstitutionTarget(
 			return false
 		}
 	}
-	return loader.gitRepoSubstitution.Branch == repoBranch
+	return substitution.Branch == repoBranch
 }
 
-func (loader *gitRepoChartLoader) cloneRepo(
-	repo *sourcev1.GitRepository,
-	repoURL string,
-) (string, error) {
-	normalizedGitRef := normalizeGitReference(repo.Spec.Reference)
-	gitRefString := fmt.Sprintf(
-		"%s#%s#%s#%s#%s",
-		normalizedGitRef.Branch,
-		normalizedGitRef.Tag,
-		normalizedGitRef.SemVer,
-		strings.ReplaceAll(normalizedGitRef.Name, "/", "%"),
-		normalizedGitRef.Commit,
-	)
-	if loader.isSubstitutionTarget(repo, repoURL) {
-		return loader.gitRepoSubstitution.Path, nil
-	}
-	// Git repositories checked out at different revisions should be cached at
-	// different paths in order to avoid cross revision contamination and Git
-	// repositories checked at non-fixed references (e.g., branches) cannot be
-	// cached across program invocations and should be pushed into the ephemeral
-	// subdirectory.
-	repoPath := path.Join(
-		getCachePathForRepo(
-			loader.cacheRoot,
-			repoURL,
-			!isFixedGitReference((normalizedGitRef)),
-		),
-		gitRefString,
-	)
-
-	if stat, err := os.Stat(repoPath); err == nil && stat.IsDir() {
-		loader.logger.Debug("Using cached Git repository")
-		return repoPath, nil
-	}
+// GitCloneOptions control how Git repositories are cloned.  By default, only
+// the checked out commit of the checked out branch or tag is fetched, which
+// some servers mishandle.
+type GitCloneOptions struct {
+	// FullClone fetches the whole history instead of only the checked out
+	// commit.
+	FullClone bool
+	// AllBranches fetches all branches and tags instead of only the checked
+	// out one, as resolving some references requires listing them.
+	AllBranches bool
+}
 
-	parsedURL, err := url.Parse(repoURL)
+// getGitCloneOptions returns the options for cloning the repository at the
+// URL, with the settings of its entry in the credentials file, if any,
+// overriding the ones of the loader.
+func (loader *gitRepoChartLoader) getGitCloneOptions(parsedURL *url.URL) (GitCloneOptions, error) {
+	result := loader.gitCloneOptions
+	repoCreds, err := loader.credentials.FindForRepo(parsedURL)
 	if err != nil {
-		return "", fmt.Errorf(
-			"unable to parse URL %s for GitRepository %s/%s: %w",
-			repoURL,
-			repo.Namespace,
-			repo.Name,
+		return GitCloneOptions{}, fmt.Errorf(
+			"unable to find credentials for repository %s: %w",
+			parsedURL,
 			err,
 		)
 	}
+	if repoCreds == nil || repoCreds.Config == nil {
+		return result, nil
+	}
+	if repoCreds.Config.FullClone != nil {
+		result.FullClone = *repoCreds.Config.FullClone
+	}
+	if repoCreds.Config.AllBranches != nil {
+		result.AllBranches = *repoCreds.Config.AllBranches
+	}
+	return result, nil
+}
 
+// getGitAuthOptions returns the options to authenticate to the remote Git
+// repository with, and its URL, which is re-written to an HTTPS one when
+// only a password is given for an SSH one.
+func (loader *gitRepoChartLoader) getGitAuthOptions(
+	repo *sourcev1.GitRepository,
+	repoURL string,
+	parsedURL *url.URL,
+) (*git.AuthOptions, string, error) {
 	repoCreds, err := loader.credentials.FindForRepo(parsedURL)
 	if err != nil {
-		return "", fmt.Errorf(
+		return nil, "", fmt.Errorf(
 			"unable to find credentials for repository %s: %w",
 			repoURL,
 			err,
 		)
 	}
 
-	var authOpts *git.AuthOptions
 	var credentials map[string][]byte
 
 	if repoCreds != nil {
@@ -194,19 +301,118 @@ func (loader *gitRepoChartLoader) cloneRepo(
 		credentials = nil
 	}
 
-	authOpts, err = git.NewAuthOptions(*parsedURL, credentials)
+	authOpts, err := git.NewAuthOptions(*parsedURL, credentials)
 	if err != nil {
-		return "", fmt.Errorf(
+		return nil, "", fmt.Errorf(
 			"unable to initialize Git auth options for Git repository %s/%s: %w",
 			repo.Namespace,
 			repo.Name,
 			err,
 		)
 	}
+	return authOpts, repoURL, nil
+}
+
+func (loader *gitRepoChartLoader) cloneRepo(
+	repo *sourcev1.GitRepository,
+	repoURL string,
+) (string, error) {
+	normalizedGitRef := normalizeGitReference(repo.Spec.Reference)
+	gitRefString := fmt.Sprintf(
+		"%s#%s#%s#%s#%s",
+		normalizedGitRef.Branch,
+		normalizedGitRef.Tag,
+		normalizedGitRef.SemVer,
+		normalizedGitRef.Name,
+		normalizedGitRef.Commit,
+	)
+	if substitution := loader.getSubstitution(repo, repoURL); substitution != nil {
+		return substitution.Path, nil
+	}
+
+	parsedURL, err := url.Parse(repoURL)
+	if err != nil {
+		return "", fmt.Errorf(
+			"unable to parse URL %s for GitRepository %s/%s: %w",
+			repoURL,
+			repo.Namespace,
+			repo.Name,
+			err,
+		)
+	}
+	// Local repositories need no network access, but they change under our
+	// feet and are never cached across invocations.
+	localRepo := parsedURL.Scheme == "file"
+	if localRepo && !loader.allowLocalSources {
+		return "", NewClassifiedError(ErrorClassInput, fmt.Errorf(
+			"local Git repository %s of GitRepository %s/%s is not allowed",
+			repoURL,
+			repo.Namespace,
+			repo.Name,
+		))
+	}
+
+	// Git repositories checked out at different revisions should be cached at
+	// different paths in order to avoid cross revision contamination and Git
+	// repositories checked at non-fixed references (e.g., branches) cannot be
+	// cached across program invocations and should be pushed into the ephemeral
+	// subdirectory.
+	repoPath := path.Join(
+		getCachePathForRepo(
+			loader.cacheRoot,
+			repoURL,
+			localRepo || !isFixedGitReference((normalizedGitRef)),
+		),
+		getGitRefCacheKey(gitRefString),
+	)
+	// Arbitrary references are resolved remotely on every invocation, and
+	// their checkouts are kept at the commits they were resolved to, recorded
+	// next to them.
+	arbitraryRef := !localRepo && isArbitraryGitReference(normalizedGitRef)
+	var revisionPath, lastRevision string
+	if arbitraryRef {
+		revisionPath = path.Join(
+			getCachePathForRepo(loader.cacheRoot, repoURL, false),
+			getGitRefCacheKey(gitRefString)+".revision",
+		)
+		lastRevision, repoPath = readGitRevision(revisionPath)
+	}
+
+	if !arbitraryRef || loader.offline {
+		if stat, err := os.Stat(repoPath); repoPath != "" && err == nil && stat.IsDir() {
+			loader.logger.Debug("Using cached Git repository")
+			return repoPath, nil
+		}
+	}
+	if loader.offline && !localRepo {
+		return "", &CacheMissError{Resource: fmt.Sprintf(
+			"Git repository %s at %s",
+			repoURL,
+			describeGitReference(normalizedGitRef),
+		)}
+	}
+
+	var authOpts *git.AuthOptions
+	cloneOptions := loader.gitCloneOptions
+	if !localRepo {
+		repoURL = loader.getMirrorURL(repoURL)
+		parsedURL, err = url.Parse(repoURL)
+		if err != nil {
+			return "", fmt.Errorf("unable to parse mirror URL %s: %w", repoURL, err)
+		}
+		cloneOptions, err = loader.getGitCloneOptions(parsedURL)
+		if err != nil {
+			return "", err
+		}
+		authOpts, repoURL, err = loader.getGitAuthOptions(repo, repoURL, parsedURL)
+		if err != nil {
+			return "", err
+		}
+	}
 
 	clientOpts := []gogit.ClientOption{
 		gogit.WithDiskStorage(),
-		gogit.WithSingleBranch(true),
+		gogit.WithSingleBranch(!cloneOptions.AllBranches),
 	}
 
 	timeout := 60 * time.Second
@@ -215,19 +421,11 @@ func (loader *gitRepoChartLoader) cloneRepo(
 		timeout = specTimeout.Duration
 	}
 
-	client, err := loader.gitClientFactory(repoPath, authOpts, clientOpts...)
-	if err != nil {
-		return "", fmt.Errorf(
-			"unable to create Git client to clone repository %s: %w",
-			repoURL,
-			err,
-		)
-	}
 	cloneCtx, cancel := context.WithTimeout(loader.ctx, timeout)
 	defer cancel()
 
 	cloneOpts := repository.CloneConfig{
-		ShallowClone: true,
+		ShallowClone: !cloneOptions.FullClone,
 		CheckoutStrategy: repository.CheckoutStrategy{
 			Branch:  normalizedGitRef.Branch,
 			Tag:     normalizedGitRef.Tag,
@@ -235,17 +433,145 @@ func (loader *gitRepoChartLoader) cloneRepo(
 			RefName: normalizedGitRef.Name,
 			Commit:  normalizedGitRef.Commit,
 		},
+		// The client only resolves an arbitrary reference without cloning when
+		// it still points to the last resolved commit, whose checkout is in the
+		// cache.
+		LastObservedCommit: lastRevision,
+	}
+
+	clone := func(clonePath string) (*git.Commit, error) {
+		client, err := loader.gitClientFactory(clonePath, authOpts, clientOpts...)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"unable to create Git client to clone repository %s: %w",
+				repoURL,
+				err,
+			)
+		}
+
+		releaseFetchSlot, err := loader.acquireFetchSlot(repoURL)
+		if err != nil {
+			return nil, err
+		}
+		commit, err := client.Clone(cloneCtx, repoURL, cloneOpts)
+		releaseFetchSlot()
+		if err != nil {
+			return nil, newFetchError(fmt.Errorf(
+				"unable to clone Git repository %s: %w",
+				repoURL,
+				err,
+			))
+		}
+		return commit, nil
+	}
+
+	if arbitraryRef {
+		return loader.cloneArbitraryReference(repoURL, normalizedGitRef, revisionPath, clone)
 	}
 
-	_, err = client.Clone(cloneCtx, repoURL, cloneOpts)
+	// Clone into a temporary directory published into the cache when complete,
+	// as other invocations sharing the cache may be looking for it.
+	err = publishCacheDir(repoPath, func(clonePath string) error {
+		_, err := clone(clonePath)
+		return err
+	})
 	if err != nil {
-		return "", fmt.Errorf(
-			"unable to clone Git repository %s: %w",
+		return "", err
+	}
+	return repoPath, nil
+}
+
+// cloneArbitraryReference clones the repository at an arbitrary reference
+// into a temporary directory, which is published into the cache at the
+// commit the reference resolves to, unless the cache already has it.
+func (loader *gitRepoChartLoader) cloneArbitraryReference(
+	repoURL string,
+	ref *sourcev1.GitRepositoryRef,
+	revisionPath string,
+	clone func(clonePath string) (*git.Commit, error),
+) (string, error) {
+	cacheDir := path.Dir(revisionPath)
+	if err := os.MkdirAll(cacheDir, 0700); err != nil {
+		return "", fmt.Errorf("unable to create cache directory %s: %w", cacheDir, err)
+	}
+	tempDir, err := os.MkdirTemp(cacheDir, ".tmp-")
+	if err != nil {
+		return "", fmt.Errorf("unable to create temporary directory in %s: %w", cacheDir, err)
+	}
+	// Failures to remove temporary directories are not interesting, and the
+	// directory is gone once published.
+	defer func() { _ = os.RemoveAll(tempDir) }()
+
+	commit, err := clone(tempDir)
+	if err != nil {
+		return "", err
+	}
+	if commit == nil || len(commit.Hash) == 0 {
+		return "", newFetchError(fmt.Errorf(
+			"unable to resolve %s in Git repository %s",
+			describeGitReference(ref),
 			repoURL,
+		))
+	}
+
+	repoPath := getResolvedGitRefCachePath(cacheDir, commit.Hash.String())
+	loader.logger.
+		With("commit", commit.Hash.String()).
+		Debug("Resolved Git reference")
+	if stat, err := os.Stat(repoPath); err == nil && stat.IsDir() {
+		loader.logger.Debug("Using cached Git repository")
+	} else if err := os.Rename(tempDir, repoPath); err != nil {
+		if stat, statErr := os.Stat(repoPath); statErr != nil || !stat.IsDir() {
+			return "", fmt.Errorf("unable to publish cache directory %s: %w", repoPath, err)
+		}
+	}
+
+	err = writeGitRevision(revisionPath, ref.Name+"@"+commit.Hash.Digest())
+	if err != nil {
+		return "", err
+	}
+	return repoPath, nil
+}
+
+func (loader *gitRepoChartLoader) resolveChartVersion(
+	repoNode *yaml.RNode,
+	repoURL string,
+	chartName string,
+	chartVersionSpec string,
+) (string, error) {
+	var repo sourcev1.GitRepository
+
+	err := decodeToObject(repoNode, &repo)
+	if err != nil {
+		return "", fmt.Errorf(
+			"unable to decode GitRepository %s/%s: %w",
+			repoNode.GetNamespace(),
+			repoNode.GetName(),
 			err,
 		)
 	}
-	return repoPath, nil
+	if repoURL == "" {
+		repoURL = repo.Spec.URL
+	}
+
+	// Charts in Git repositories are not versioned separately, so the version
+	// is whatever the chart at the checked out revision declares.
+	repoPath, err := loader.cloneRepo(&repo, repoURL)
+	if err != nil {
+		return "", err
+	}
+	chartFilePath := path.Join(repoPath, chartName, "Chart.yaml")
+	metadata, err := chartutil.LoadChartfile(chartFilePath)
+	if err != nil {
+		return "", fmt.Errorf(
+			"unable to load chart metadata %s from GitRepository %s/%s: %w",
+			path.Join(chartName, "Chart.yaml"),
+			repo.Namespace,
+			repo.Name,
+			err,
+		)
+	}
+	return metadata.Version, nil
 }
 
 func (loader *gitRepoChartLoader) loadRepositoryChart(
@@ -292,68 +618,68 @@ func (loader *gitRepoChartLoader) loadRepositoryChart(
 		ref.Name,
 		ref.Commit,
 	)
-	if loader.chartCache != nil {
-		if chart, ok := loader.chartCache[chartKey]; ok {
-			loader.logger.
-				With(
-					"url", repoURL,
-					"branch", ref.Branch,
-					"tag", ref.Tag,
-					"semver", ref.SemVer,
-					"ref", ref.Name,
-					"commit", ref.Commit,
-				).
-				Debug("Using chart from in-memory cache")
-			return chart, nil
+	chart, cached, err := loader.chartCache.load(chartKey, func() (*chart.Chart, error) {
+		var repoPath string
+		if parentContext != nil {
+			repoPath = parentContext.localRepoPath
+		} else {
+			var err error
+			repoPath, err = loader.cloneRepo(&repo, repoURL)
+			if err != nil {
+				return nil, err
+			}
 		}
-	}
 
-	var repoPath string
-	if parentContext != nil {
-		repoPath = parentContext.localRepoPath
-	} else {
-		var err error
-		repoPath, err = loader.cloneRepo(&repo, repoURL)
+		chartPath := path.Join(repoPath, chartName)
+		chart, err := helmloader.LoadDir(chartPath)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf(
+				"unable to load chart %s from GitRepository %s/%s: %w",
+				chartName,
+				repo.Namespace,
+				repo.Name,
+				err,
+			)
 		}
-	}
 
-	chartPath := path.Join(repoPath, chartName)
-	chart, err := helmloader.LoadDir(chartPath)
-	if err != nil {
-		return nil, fmt.Errorf(
-			"unable to load chart %s from GitRepository %s/%s: %w",
-			chartName,
-			repo.Namespace,
-			repo.Name,
-			err,
+		loader.logger = loader.logger.WithGroup("deps")
+		err = loadChartDependencies(
+			loader.loaderConfig,
+			chart,
+			&chartContext{
+				localRepoPath: repoPath,
+				chartName:     chartName,
+				loader:        loader,
+				repoNode:      repoNode,
+			},
 		)
-	}
+		if err != nil {
+			return nil, fmt.Errorf(
+				"unable to load chart dependencies for %s/%s in %s: %w",
+				chartName,
+				chart.Metadata.Version,
+				repoURL,
+				err,
+			)
+		}
 
-	loader.logger = loader.logger.WithGroup("deps")
-	err = loadChartDependencies(
-		loader.loaderConfig,
-		chart,
-		&chartContext{
-			localRepoPath: repoPath,
-			chartName:     chartName,
-			loader:        loader,
-			repoNode:      repoNode,
-		},
-	)
+		return chart, nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf(
-			"unable to load chart dependencies for %s/%s in %s: %w",
-			chartName,
-			chart.Metadata.Version,
-			repoURL,
-			err,
-		)
-	}
-
-	if loader.chartCache != nil {
-		loader.chartCache[chartKey] = chart
+		return nil, err
+	}
+	if cached {
+		loader.logger.
+			With(
+				"url", repoURL,
+				"branch", ref.Branch,
+				"tag", ref.Tag,
+				"semver", ref.SemVer,
+				"ref", ref.Name,
+				"commit", ref.Commit,
+			).
+			Debug("Using chart from in-memory cache")
+		return chart, nil
 	}
 
 	loader.logger.
@@ -362,3 +688,61 @@ func (loader *gitRepoChartLoader) loadRepositoryChart(
 
 	return chart, nil
 }
+
+// readValuesFiles reads the values files of the chart spec outside of the
+// chart directory from the checkout of the GitRepository, keyed by their
+// cleaned paths relative to the repository root.  Missing files are left out
+// for applyValuesFiles to report.
+func (loader *gitRepoChartLoader) readValuesFiles(
+	repoNode *yaml.RNode,
+	chartSpec *helmv2.HelmChartTemplateSpec,
+) (map[string][]byte, error) {
+	chartDir := path.Clean(chartSpec.Chart)
+	fileNames := []string{}
+	for _, fileName := range chartSpec.ValuesFiles {
+		name := path.Clean(fileName)
+		if chartDir == "." || strings.HasPrefix(name, chartDir+"/") ||
+			isOutsideRepository(name) {
+			continue
+		}
+		fileNames = append(fileNames, name)
+	}
+	if len(fileNames) == 0 {
+		return nil, nil
+	}
+
+	var repo sourcev1.GitRepository
+	err := decodeToObject(repoNode, &repo)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"unable to decode GitRepository %s/%s: %w",
+			repoNode.GetNamespace(),
+			repoNode.GetName(),
+			err,
+		)
+	}
+	// The checkout the chart was loaded from is normally still in the cache.
+	repoPath, err := loader.cloneRepo(&repo, repo.Spec.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string][]byte{}
+	for _, name := range fileNames {
+		data, err := os.ReadFile(path.Join(repoPath, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf(
+				"unable to read values file %s from GitRepository %s/%s: %w",
+				name,
+				repo.Namespace,
+				repo.Name,
+				err,
+			)
+		}
+		files[name] = data
+	}
+	return files, nil
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log/slog"
 	"maps"
+	"net/http"
 	"os"
 	"path"
 	"path/filepath"
@@ -13,6 +14,7 @@ import (
 
 	"github.com/fluxcd/pkg/git"
 	"github.com/fluxcd/pkg/git/gogit"
+	"github.com/fluxcd/pkg/git/repository"
 	"github.com/onsi/ginkgo/v2"
 	"github.com/onsi/gomega"
 	"github.com/stretchr/testify/mock"
@@ -133,7 +135,7 @@ var _ = ginkgo.Describe("GitRepository expansion", func() {
 			"kind: ConfigMap",
 			"metadata:",
 			"  namespace: testns",
-			"  name: testns-test-configmap",
+			"  name: test-configmap",
 			"data:",
 			"  foo: baz",
 			"",
@@ -264,8 +266,8 @@ var _ = ginkgo.Describe("GitRepository expansion", func() {
 			ginkgo.Entry("with repo and main", repoURL, "", "{branch: main}", "absolutely-different", 0),
 			ginkgo.Entry("with repo and master", repoURL, "", "{branch: master}", "absolutely-different", 0),
 			ginkgo.Entry("with repo and matching branch", repoURL, "trunk", "{branch: trunk}", "absolutely-different", 0),
-			ginkgo.Entry("with repo and mismatching branch", repoURL, "main", "{branch: trunk}", "testns-test-configmap", 1),
-			ginkgo.Entry("with mismatching repo", "ssh://git@localhost/other.git", "", "", "testns-test-configmap", 1),
+			ginkgo.Entry("with repo and mismatching branch", repoURL, "main", "{branch: trunk}", "test-configmap", 1),
+			ginkgo.Entry("with mismatching repo", "ssh://git@localhost/other.git", "", "", "test-configmap", 1),
 		)
 	})
 
@@ -367,7 +369,7 @@ var _ = ginkgo.Describe("GitRepository expansion", func() {
 			"kind: ConfigMap",
 			"metadata:",
 			"  namespace: testns",
-			"  name: testns-test-configmap",
+			"  name: test-configmap",
 			"data:",
 			"  foo: baz",
 			"---",
@@ -376,7 +378,7 @@ var _ = ginkgo.Describe("GitRepository expansion", func() {
 			"kind: ConfigMap",
 			"metadata:",
 			"  namespace: testns-other",
-			"  name: testns-other-test-another-configmap",
+			"  name: test-another-configmap",
 			"data:",
 			"  foo: baz",
 			"",
@@ -477,7 +479,7 @@ var _ = ginkgo.Describe("GitRepository expansion", func() {
 				"kind: ConfigMap",
 				"metadata:",
 				"  namespace: testns",
-				"  name: testns-test-configmap",
+				"  name: test-configmap",
 				"data:",
 				"  foo: baz",
 				"",
@@ -519,7 +521,7 @@ var _ = ginkgo.Describe("GitRepository expansion", func() {
 				"kind: ConfigMap",
 				"metadata:",
 				"  namespace: testns",
-				"  name: testns-test-configmap",
+				"  name: test-configmap",
 				"data:",
 				"  foo: baz",
 				"",
@@ -527,11 +529,9 @@ var _ = ginkgo.Describe("GitRepository expansion", func() {
 			))
 			if cacheEnabled {
 				chartDir := filepath.Join(
-					cacheRoot,
-					fmt.Sprintf(
-						"ssh:##git@localhost#dummy.git/%s/charts/test-chart",
-						specDirName,
-					),
+					getCachePathForRepo(cacheRoot, repoURL, false),
+					getGitRefCacheKey(specDirName),
+					"charts/test-chart",
 				)
 				g.Expect(chartDir).To(gomega.BeADirectory())
 				configmapTemplateName := filepath.Join(chartDir, "templates/configmap.yaml")
@@ -549,7 +549,7 @@ var _ = ginkgo.Describe("GitRepository expansion", func() {
 		ginkgo.Entry("is commit", "{commit: 437909a800db720437b972dbf7911b5ffbc90be4}", true, "####437909a800db720437b972dbf7911b5ffbc90be4"),
 		ginkgo.Entry("is tag", "{tag: fixed-tag}", true, "#fixed-tag###"),
 		ginkgo.Entry("is semver", "{semver: v0.1.0}", true, "##v0.1.0##"),
-		ginkgo.Entry("is tag ref", "{name: refs/tags/fixed-tag}", true, "###refs%tags%fixed-tag#"),
+		ginkgo.Entry("is tag ref", "{name: refs/tags/fixed-tag}", true, "###refs/tags/fixed-tag#"),
 	)
 
 	ginkgo.DescribeTable(
@@ -694,7 +694,7 @@ var _ = ginkgo.Describe("GitRepository expansion", func() {
 				"kind: ConfigMap",
 				"metadata:",
 				"  namespace: testns",
-				"  name: testns-another-configmap",
+				"  name: another-configmap",
 				"data:",
 				"  foo: baz",
 				"---",
@@ -703,7 +703,7 @@ var _ = ginkgo.Describe("GitRepository expansion", func() {
 				"kind: ConfigMap",
 				"metadata:",
 				"  namespace: testns",
-				"  name: testns-test-configmap",
+				"  name: test-configmap",
 				"data:",
 				"  foo: bar",
 				"",
@@ -713,7 +713,182 @@ var _ = ginkgo.Describe("GitRepository expansion", func() {
 		},
 		ginkgo.Entry("is default", "", "master####"),
 		ginkgo.Entry("is branch", "{branch: main}", "main####"),
-		ginkgo.Entry("is branch ref", "{name: refs/heads/main}", "###refs%heads%main#"),
+		ginkgo.Entry("is branch ref", "{name: refs/heads/main}", "###refs/heads/main#"),
+	)
+
+	ginkgo.DescribeTable(
+		"caches repositories at arbitrary references by the resolved commit",
+		func(refName string) {
+			cacheRoot, err := os.MkdirTemp("", "")
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+			defer os.RemoveAll(cacheRoot)
+
+			input := strings.Join([]string{
+				"apiVersion: helm.toolkit.fluxcd.io/v2",
+				"kind: HelmRelease",
+				"metadata:",
+				"  namespace: testns",
+				"  name: test",
+				"spec:",
+				"  chart:",
+				"    spec:",
+				"      chart: charts/test-chart",
+				"      sourceRef:",
+				"        kind: GitRepository",
+				"        name: local",
+				"---",
+				"apiVersion: source.toolkit.fluxcd.io/v1",
+				"kind: GitRepository",
+				"metadata:",
+				"  namespace: testns",
+				"  name: local",
+				"spec:",
+				"  url: " + repoURL,
+				"  ref:",
+				"    name: " + refName,
+			}, "\n")
+
+			const commit = "437909a800db720437b972dbf7911b5ffbc90be4"
+			var repoRoot string
+			var cloneConfigs []repository.CloneConfig
+			gitClient := &GitClientMock{}
+			gitClient.
+				On("Clone", mock.Anything, repoURL, mock.Anything).
+				Run(func(args mock.Arguments) {
+					config := args.Get(2).(repository.CloneConfig)
+					cloneConfigs = append(cloneConfigs, config)
+					// The client doesn't clone when the reference hasn't moved.
+					if config.LastObservedCommit == "" {
+						err := createFileTree(path.Join(repoRoot, "charts/test-chart"), chartFiles)
+						g.Expect(err).ToNot(gomega.HaveOccurred())
+					}
+				}).
+				Return(&git.Commit{Hash: git.Hash(commit)}, nil)
+
+			for range 2 {
+				expander := NewHelmReleaseExpander(
+					ctx,
+					logger,
+					func(
+						path string,
+						authOpts *git.AuthOptions,
+						clientOpts ...gogit.ClientOption,
+					) (GitClientInterface, error) {
+						repoRoot = path
+						return gitClient, nil
+					},
+					nil,
+				)
+				output := &bytes.Buffer{}
+				err = expander.Expand(
+					bytes.NewBufferString(input),
+					output,
+					ExpandOptions{
+						Credentials:   getDummySSHCreds(repoURL),
+						ChartCacheDir: cacheRoot,
+					},
+				)
+				g.Expect(err).ToNot(gomega.HaveOccurred())
+				g.Expect(output.String()).To(gomega.ContainSubstring("name: test-configmap"))
+			}
+
+			g.Expect(cloneConfigs).To(gomega.HaveLen(2))
+			g.Expect(cloneConfigs[0].CheckoutStrategy.RefName).To(gomega.Equal(refName))
+			g.Expect(cloneConfigs[0].LastObservedCommit).To(gomega.BeEmpty())
+			g.Expect(cloneConfigs[1].LastObservedCommit).To(
+				gomega.Equal(refName + "@sha1:" + commit))
+			g.Expect(filepath.Join(
+				getResolvedGitRefCachePath(getCachePathForRepo(cacheRoot, repoURL, false), commit),
+				"charts/test-chart/Chart.yaml",
+			)).To(gomega.BeARegularFile())
+
+			// Offline, the last resolved commit is used.
+			expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+			err = expander.Expand(
+				bytes.NewBufferString(input),
+				&bytes.Buffer{},
+				ExpandOptions{
+					Credentials:   getDummySSHCreds(repoURL),
+					ChartCacheDir: cacheRoot,
+					Offline:       true,
+				},
+			)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+		},
+		ginkgo.Entry("is pull request ref", "refs/pull/123/head"),
+		ginkgo.Entry("is merge request ref", "refs/merge-requests/7/head"),
+		ginkgo.Entry("is custom ref", "refs/changes/45/12345/2"),
+	)
+
+	ginkgo.DescribeTable(
+		"clones shallowly unless configured otherwise",
+		func(fullClone bool, repoFullClone *bool, expectedShallow bool) {
+			input := strings.Join([]string{
+				"apiVersion: helm.toolkit.fluxcd.io/v2",
+				"kind: HelmRelease",
+				"metadata:",
+				"  namespace: testns",
+				"  name: test",
+				"spec:",
+				"  chart:",
+				"    spec:",
+				"      chart: charts/test-chart",
+				"      sourceRef:",
+				"        kind: GitRepository",
+				"        name: local",
+				"---",
+				"apiVersion: source.toolkit.fluxcd.io/v1",
+				"kind: GitRepository",
+				"metadata:",
+				"  namespace: testns",
+				"  name: local",
+				"spec:",
+				"  url: " + repoURL,
+			}, "\n")
+
+			var repoRoot string
+			var cloneConfig repository.CloneConfig
+			gitClient := &GitClientMock{}
+			gitClient.
+				On("Clone", mock.Anything, repoURL, mock.Anything).
+				Run(func(args mock.Arguments) {
+					cloneConfig = args.Get(2).(repository.CloneConfig)
+					err := createFileTree(path.Join(repoRoot, "charts/test-chart"), chartFiles)
+					g.Expect(err).ToNot(gomega.HaveOccurred())
+				}).
+				Return(&git.Commit{}, nil)
+			expander := NewHelmReleaseExpander(
+				ctx,
+				logger,
+				func(
+					path string,
+					authOpts *git.AuthOptions,
+					clientOpts ...gogit.ClientOption,
+				) (GitClientInterface, error) {
+					repoRoot = path
+					return gitClient, nil
+				},
+				nil,
+			).WithGitCloneOptions(GitCloneOptions{FullClone: fullClone})
+
+			credentials := getDummySSHCreds(repoURL)
+			if repoFullClone != nil {
+				repoCreds := credentials[repoURL]
+				repoCreds.Config = &RepositoryConfig{FullClone: repoFullClone}
+				credentials[repoURL] = repoCreds
+			}
+			err := expander.Expand(
+				bytes.NewBufferString(input),
+				&bytes.Buffer{},
+				ExpandOptions{Credentials: credentials},
+			)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+			g.Expect(cloneConfig.ShallowClone).To(gomega.Equal(expectedShallow))
+		},
+		ginkgo.Entry("by default", false, nil, true),
+		ginkgo.Entry("with full clones", true, nil, false),
+		ginkgo.Entry("with full clones of the repository", false, new(true), false),
+		ginkgo.Entry("with shallow clones of the repository", true, new(false), true),
 	)
 
 	ginkgo.It("handles relative dependency chart paths", func() {
@@ -830,7 +1005,7 @@ var _ = ginkgo.Describe("GitRepository expansion", func() {
 			"kind: ConfigMap",
 			"metadata:",
 			"  namespace: testns",
-			"  name: testns-test-configmap",
+			"  name: test-configmap",
 			"data:",
 			"  foo: baz",
 			"---",
@@ -839,7 +1014,7 @@ var _ = ginkgo.Describe("GitRepository expansion", func() {
 			"kind: ConfigMap",
 			"metadata:",
 			"  namespace: testns",
-			"  name: testns-test-dependency-configmap",
+			"  name: test-dependency-configmap",
 			"data:",
 			"  foo: bar",
 			"",
@@ -961,7 +1136,7 @@ var _ = ginkgo.Describe("GitRepository expansion", func() {
 				repoRoot = path
 				return gitClient, nil
 			},
-			func(insecure bool) (repositoryClient, error) {
+			func(insecure bool, transport *http.Transport) (repositoryClient, error) {
 				return repoClient, nil
 			},
 		)
@@ -986,7 +1161,7 @@ var _ = ginkgo.Describe("GitRepository expansion", func() {
 			"kind: ConfigMap",
 			"metadata:",
 			"  namespace: testns",
-			"  name: testns-test-configmap",
+			"  name: test-configmap",
 			"data:",
 			"  foo: baz",
 			"---",
@@ -995,7 +1170,7 @@ var _ = ginkgo.Describe("GitRepository expansion", func() {
 			"kind: ConfigMap",
 			"metadata:",
 			"  namespace: testns",
-			"  name: testns-test-dependency-configmap",
+			"  name: test-dependency-configmap",
 			"data:",
 			"  foo: bar",
 			"",
@@ -1003,6 +1178,80 @@ var _ = ginkgo.Describe("GitRepository expansion", func() {
 		))
 	})
 
+	ginkgo.When("given a local repository", func() {
+		const localRepoURL = "file:///srv/git/charts"
+		input := strings.Join([]string{
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"spec:",
+			"  chart:",
+			"    spec:",
+			"      chart: charts/test-chart",
+			"      sourceRef:",
+			"        kind: GitRepository",
+			"        name: local",
+			"---",
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: GitRepository",
+			"metadata:",
+			"  namespace: testns",
+			"  name: local",
+			"spec:",
+			"  url: " + localRepoURL,
+		}, "\n")
+
+		ginkgo.It("clones it without credentials, also offline, when allowed", func() {
+			var repoRoot string
+			gitClient := &GitClientMock{}
+			gitClient.
+				On("Clone", mock.Anything, localRepoURL, mock.Anything).
+				Run(func(mock.Arguments) {
+					err := createFileTree(path.Join(repoRoot, "charts/test-chart"), chartFiles)
+					g.Expect(err).ToNot(gomega.HaveOccurred())
+				}).
+				Return(&git.Commit{Hash: git.Hash("dummy")}, nil)
+			expander := NewHelmReleaseExpander(
+				ctx,
+				logger,
+				func(
+					path string,
+					authOpts *git.AuthOptions,
+					clientOpts ...gogit.ClientOption,
+				) (GitClientInterface, error) {
+					g.Expect(authOpts).To(gomega.BeNil())
+					repoRoot = path
+					return gitClient, nil
+				},
+				nil,
+			)
+			output := &bytes.Buffer{}
+			err := expander.Expand(
+				bytes.NewBufferString(input),
+				output,
+				ExpandOptions{AllowLocalSources: true, Offline: true},
+			)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+			g.Expect(output.String()).To(gomega.ContainSubstring("name: test-configmap"))
+			gitClient.AssertNumberOfCalls(ginkgo.GinkgoT(), "Clone", 1)
+		})
+
+		ginkgo.It("rejects it unless allowed", func() {
+			expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+			err := expander.Expand(
+				bytes.NewBufferString(input),
+				&bytes.Buffer{},
+				ExpandOptions{},
+			)
+			g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(
+				"local Git repository file:///srv/git/charts of GitRepository testns/local is not allowed",
+			)))
+			g.Expect(GetErrorClass(err)).To(gomega.Equal(ErrorClassInput))
+		})
+	})
+
 	ginkgo.It("propagates cloning errors", func() {
 		input := strings.Join([]string{
 			"apiVersion: helm.toolkit.fluxcd.io/v2",
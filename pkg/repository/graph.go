@@ -0,0 +1,358 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	chart "helm.sh/helm/v4/pkg/chart/v2"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+
+	yamlutil "github.com/sageailabs/fouskoti/pkg/yaml"
+)
+
+// Kinds of graph nodes other than the kinds of the Flux objects in the input.
+const (
+	// GraphNodeChart is a chart, identified by its origin, name, and version.
+	GraphNodeChart = "Chart"
+	// GraphNodeRepository is a repository chart dependencies are fetched from
+	// which no source object in the input refers to.
+	GraphNodeRepository = "Repository"
+)
+
+// Relations between graph nodes.
+const (
+	// GraphEdgeSource leads from a HelmRelease to its chart source.
+	GraphEdgeSource = "source"
+	// GraphEdgeChart leads from a HelmRelease to the chart it deploys.
+	GraphEdgeChart = "chart"
+	// GraphEdgeFrom leads from a chart to the source or repository it is
+	// fetched from.
+	GraphEdgeFrom = "from"
+	// GraphEdgeDependency leads from a chart to a chart it depends on.
+	GraphEdgeDependency = "dependency"
+)
+
+// GraphNode is a HelmRelease, a chart source, a chart, or a repository in the
+// dependency graph.
+type GraphNode struct {
+	ID        string `json:"id"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	Version   string `json:"version,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+// GraphEdge is a relation between two nodes of the dependency graph.
+type GraphEdge struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Relation string `json:"relation"`
+}
+
+// Graph shows the sources, charts, and chart dependencies the HelmRelease
+// objects in the input pull in.
+type Graph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+func getObjectNodeID(kind string, namespace string, name string) string {
+	return fmt.Sprintf("%s/%s/%s", kind, namespace, name)
+}
+
+// graphBuilder collects the nodes and edges of the graph without duplicates.
+type graphBuilder struct {
+	nodes map[string]GraphNode
+	edges map[GraphEdge]struct{}
+	// sourceIDs maps the URLs of the sources in the input to their node IDs.
+	sourceIDs map[string]string
+}
+
+func newGraphBuilder() *graphBuilder {
+	return &graphBuilder{
+		nodes:     map[string]GraphNode{},
+		edges:     map[GraphEdge]struct{}{},
+		sourceIDs: map[string]string{},
+	}
+}
+
+func (builder *graphBuilder) addNode(node GraphNode) bool {
+	if _, ok := builder.nodes[node.ID]; ok {
+		return false
+	}
+	builder.nodes[node.ID] = node
+	return true
+}
+
+func (builder *graphBuilder) addEdge(from string, to string, relation string) {
+	builder.edges[GraphEdge{From: from, To: to, Relation: relation}] = struct{}{}
+}
+
+// addSource adds a node for the source object and returns its ID.
+func (builder *graphBuilder) addSource(repoNode *yaml.RNode) string {
+	id := getObjectNodeID(repoNode.GetKind(), repoNode.GetNamespace(), repoNode.GetName())
+	repoURL, _ := yamlutil.GetStringOr(repoNode, "spec.url", "")
+	builder.addNode(GraphNode{
+		ID:        id,
+		Kind:      repoNode.GetKind(),
+		Namespace: repoNode.GetNamespace(),
+		Name:      repoNode.GetName(),
+		URL:       repoURL,
+	})
+	return id
+}
+
+// addRepository returns the ID of the source in the input with the URL or,
+// if there is none, adds a node for the repository and returns its ID.
+func (builder *graphBuilder) addRepository(repoURL string) string {
+	repoURL = strings.TrimSuffix(repoURL, "/")
+	if id, ok := builder.sourceIDs[repoURL]; ok {
+		return id
+	}
+	id := GraphNodeRepository + "/" + repoURL
+	builder.addNode(GraphNode{
+		ID:   id,
+		Kind: GraphNodeRepository,
+		Name: repoURL,
+		URL:  repoURL,
+	})
+	return id
+}
+
+// addChart adds nodes for the chart fetched from the origin, a source or a
+// repository, and for its dependencies, and returns the ID of the chart.
+func (builder *graphBuilder) addChart(loadedChart *chart.Chart, originID string) string {
+	id := fmt.Sprintf(
+		"%s/%s/%s@%s",
+		GraphNodeChart,
+		originID,
+		loadedChart.Name(),
+		loadedChart.Metadata.Version,
+	)
+	if !builder.addNode(GraphNode{
+		ID:      id,
+		Kind:    GraphNodeChart,
+		Name:    loadedChart.Name(),
+		Version: loadedChart.Metadata.Version,
+	}) {
+		return id
+	}
+	builder.addEdge(id, originID, GraphEdgeFrom)
+
+	repositories := map[string]string{}
+	for _, dependency := range loadedChart.Metadata.Dependencies {
+		repositories[dependency.Name] = dependency.Repository
+	}
+	for _, dependencyChart := range loadedChart.Dependencies() {
+		// Bundled charts and charts at relative paths come from the origin of
+		// the parent chart.
+		dependencyOriginID := originID
+		repository := repositories[dependencyChart.Name()]
+		if repository != "" && !strings.HasPrefix(repository, "file://") {
+			repoURL, err := normalizeURL(repository)
+			if err != nil {
+				repoURL = repository
+			}
+			dependencyOriginID = builder.addRepository(repoURL)
+		}
+		dependencyID := builder.addChart(dependencyChart, dependencyOriginID)
+		builder.addEdge(id, dependencyID, GraphEdgeDependency)
+	}
+	return id
+}
+
+func (builder *graphBuilder) build() *Graph {
+	result := &Graph{Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+	for _, node := range builder.nodes {
+		result.Nodes = append(result.Nodes, node)
+	}
+	slices.SortFunc(result.Nodes, func(a, b GraphNode) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	for edge := range builder.edges {
+		result.Edges = append(result.Edges, edge)
+	}
+	slices.SortFunc(result.Edges, func(a, b GraphEdge) int {
+		return cmp.Or(
+			cmp.Compare(a.From, b.From),
+			cmp.Compare(a.To, b.To),
+			cmp.Compare(a.Relation, b.Relation),
+		)
+	})
+	return result
+}
+
+// BuildGraph loads the chart of each HelmRelease in the input with its
+// dependencies, without rendering it, and returns the graph of the
+// HelmRelease objects, their sources, their charts, and the chart
+// dependencies, including the repositories dependencies are fetched from
+// which are not among the sources in the input.
+func (expander *HelmReleaseExpander) BuildGraph(
+	credentials Credentials,
+	input io.Reader,
+	gitRepoSubstitutions []*GitRepoSubstitution,
+	chartSubstitutions []*ChartSubstitution,
+	chartCacheDir string,
+) (*Graph, error) {
+	nodes, err := (&kio.ByteReader{Reader: input}).Read()
+	if err != nil {
+		return nil, NewClassifiedError(
+			ErrorClassInput,
+			fmt.Errorf("unable to parse input: %w", err),
+		)
+	}
+
+	if chartCacheDir == "" {
+		chartCacheDir, err = os.MkdirTemp("", "chart-repo-cache-")
+		if err != nil {
+			return nil, fmt.Errorf("unable to create a chart cache dir: %w", err)
+		}
+		defer func() {
+			if err := os.RemoveAll(chartCacheDir); err != nil {
+				expander.logger.
+					With("error", err).
+					With("dir", chartCacheDir).
+					Error("Unable to clean the chart cache directory")
+			}
+		}()
+	} else {
+		expander.migrateCache(chartCacheDir)
+		defer expander.cleanUpEphemeralCache(chartCacheDir)
+	}
+
+	releaseRepos, err := getReleaseRepos(nodes, nodes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get release repos: %w", err)
+	}
+	if err := checkMissingSources(releaseRepos); err != nil {
+		return nil, err
+	}
+
+	builder := newGraphBuilder()
+	for _, node := range nodes {
+		if yamlutil.GetGroup(node) != "source.toolkit.fluxcd.io" {
+			continue
+		}
+		switch node.GetKind() {
+		case "GitRepository", "HelmRepository", "OCIRepository":
+			if repoURL, _ := yamlutil.GetStringOr(node, "spec.url", ""); repoURL != "" {
+				builder.sourceIDs[strings.TrimSuffix(repoURL, "/")] = getObjectNodeID(
+					node.GetKind(),
+					node.GetNamespace(),
+					node.GetName(),
+				)
+			}
+		}
+	}
+
+	config := expander.getLoaderConfig()
+	config.gitRepoSubstitutions = gitRepoSubstitutions
+	config.chartSubstitutions = chartSubstitutions
+	config.cacheRoot = chartCacheDir
+	config.chartCache = newInMemoryChartCache()
+	config.credentials = credentials
+	for _, pair := range releaseRepos {
+		releaseID := getObjectNodeID(
+			pair.release.GetKind(),
+			pair.release.GetNamespace(),
+			pair.release.GetName(),
+		)
+		builder.addNode(GraphNode{
+			ID:        releaseID,
+			Kind:      pair.release.GetKind(),
+			Namespace: pair.release.GetNamespace(),
+			Name:      pair.release.GetName(),
+		})
+		sourceID := builder.addSource(pair.repo)
+		builder.addEdge(releaseID, sourceID, GraphEdgeSource)
+
+		loadedChart, err := loadGraphChart(config, pair)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"unable to load chart of Helm release %s/%s: %w",
+				pair.release.GetNamespace(),
+				pair.release.GetName(),
+				err,
+			)
+		}
+		builder.addEdge(releaseID, builder.addChart(loadedChart, sourceID), GraphEdgeChart)
+	}
+	return builder.build(), nil
+}
+
+func loadGraphChart(config loaderConfig, pair releaseRepo) (*chart.Chart, error) {
+	chartName, err := pair.release.GetString("spec.chart.spec.chart")
+	if err != nil {
+		return nil, fmt.Errorf("unable to get chart name: %w", err)
+	}
+	chartVersion, err := yamlutil.GetStringOr(pair.release, "spec.chart.spec.version", "")
+	if err != nil {
+		return nil, fmt.Errorf("unable to get chart version: %w", err)
+	}
+	loader, err := getLoaderForRepo(pair.repo, config)
+	if err != nil {
+		return nil, err
+	}
+	return loader.loadRepositoryChart(pair.repo, "", nil, chartName, chartVersion)
+}
+
+// getGraphNodeLabel returns the label of the node in DOT output.
+func getGraphNodeLabel(node GraphNode) string {
+	switch node.Kind {
+	case GraphNodeChart:
+		return fmt.Sprintf("%s\n%s@%s", node.Kind, node.Name, node.Version)
+	case GraphNodeRepository:
+		return fmt.Sprintf("%s\n%s", node.Kind, node.URL)
+	}
+	label := fmt.Sprintf("%s\n%s/%s", node.Kind, node.Namespace, node.Name)
+	if node.URL != "" {
+		label += "\n" + node.URL
+	}
+	return label
+}
+
+func getGraphNodeShape(node GraphNode) string {
+	switch node.Kind {
+	case "HelmRelease":
+		return "box"
+	case GraphNodeChart:
+		return "ellipse"
+	default:
+		return "cylinder"
+	}
+}
+
+// WriteDOT writes the graph in the Graphviz DOT language.
+func (graph *Graph) WriteDOT(output io.Writer) error {
+	lines := []string{"digraph fouskoti {", "  rankdir=LR;"}
+	for _, node := range graph.Nodes {
+		lines = append(lines, fmt.Sprintf(
+			"  %s [label=%s, shape=%s];",
+			strconv.Quote(node.ID),
+			strconv.Quote(getGraphNodeLabel(node)),
+			getGraphNodeShape(node),
+		))
+	}
+	for _, edge := range graph.Edges {
+		lines = append(lines, fmt.Sprintf(
+			"  %s -> %s [label=%s];",
+			strconv.Quote(edge.From),
+			strconv.Quote(edge.To),
+			strconv.Quote(edge.Relation),
+		))
+	}
+	lines = append(lines, "}")
+	if _, err := fmt.Fprintln(output, strings.Join(lines, "\n")); err != nil {
+		return fmt.Errorf("unable to write graph: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Dependency graph", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+	})
+
+	ginkgo.It("links releases to sources, charts, and external dependencies", func() {
+		libRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(libRoot)
+		libServer, libPort, libServerDone, err := serveDirectory(libRoot, logger, nil)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer func() {
+			g.Expect(stopServing(libServer, libServerDone)).To(gomega.Succeed())
+		}()
+		err = createSingleChartHelmRepository(
+			"lib-chart",
+			"0.2.0",
+			map[string]string{
+				"Chart.yaml": strings.Join([]string{
+					"apiVersion: v2",
+					"name: lib-chart",
+					"version: 0.2.0",
+				}, "\n"),
+			},
+			libPort,
+			libRoot,
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		appRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(appRoot)
+		appServer, appPort, appServerDone, err := serveDirectory(appRoot, logger, nil)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer func() {
+			g.Expect(stopServing(appServer, appServerDone)).To(gomega.Succeed())
+		}()
+		libURL := fmt.Sprintf("http://localhost:%d", libPort)
+		err = createSingleChartHelmRepository(
+			"app-chart",
+			"1.0.0",
+			map[string]string{
+				"Chart.yaml": strings.Join([]string{
+					"apiVersion: v2",
+					"name: app-chart",
+					"version: 1.0.0",
+					"dependencies:",
+					"- name: lib-chart",
+					"  version: ^0.2",
+					"  repository: " + libURL,
+				}, "\n"),
+			},
+			appPort,
+			appRoot,
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		appURL := fmt.Sprintf("http://localhost:%d", appPort)
+		input := strings.Join([]string{
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: HelmRepository",
+			"metadata:",
+			"  namespace: testns",
+			"  name: apps",
+			"spec:",
+			"  url: " + appURL,
+			"---",
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"spec:",
+			"  chart:",
+			"    spec:",
+			"      chart: app-chart",
+			"      sourceRef:",
+			"        kind: HelmRepository",
+			"        name: apps",
+		}, "\n")
+
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		graph, err := expander.BuildGraph(
+			Credentials{},
+			bytes.NewBufferString(input),
+			nil,
+			nil,
+			"",
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		releaseID := "HelmRelease/testns/test"
+		sourceID := "HelmRepository/testns/apps"
+		appChartID := "Chart/" + sourceID + "/app-chart@1.0.0"
+		repositoryID := "Repository/" + libURL
+		libChartID := "Chart/" + repositoryID + "/lib-chart@0.2.0"
+		g.Expect(graph.Nodes).To(gomega.Equal([]GraphNode{
+			{ID: appChartID, Kind: "Chart", Name: "app-chart", Version: "1.0.0"},
+			{ID: libChartID, Kind: "Chart", Name: "lib-chart", Version: "0.2.0"},
+			{ID: releaseID, Kind: "HelmRelease", Namespace: "testns", Name: "test"},
+			{ID: sourceID, Kind: "HelmRepository", Namespace: "testns", Name: "apps", URL: appURL},
+			{ID: repositoryID, Kind: "Repository", Name: libURL, URL: libURL},
+		}))
+		g.Expect(graph.Edges).To(gomega.Equal([]GraphEdge{
+			{From: appChartID, To: libChartID, Relation: "dependency"},
+			{From: appChartID, To: sourceID, Relation: "from"},
+			{From: libChartID, To: repositoryID, Relation: "from"},
+			{From: releaseID, To: appChartID, Relation: "chart"},
+			{From: releaseID, To: sourceID, Relation: "source"},
+		}))
+
+		var output bytes.Buffer
+		g.Expect(graph.WriteDOT(&output)).To(gomega.Succeed())
+		g.Expect(output.String()).To(gomega.HavePrefix("digraph fouskoti {\n"))
+		g.Expect(output.String()).To(gomega.ContainSubstring(fmt.Sprintf(
+			"  %q -> %q [label=\"source\"];\n",
+			releaseID,
+			sourceID,
+		)))
+	})
+})
@@ -3,7 +3,11 @@
 package repository
 
 import (
+	"cmp"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"net/http"
 	"net/url"
 	"os"
 	"path"
@@ -56,22 +60,17 @@ func newHelmRepositoryLoader(config loaderConfig) repositoryLoader {
 	return &helmRepoChartLoader{loaderConfig: config}
 }
 
-func (loader *helmRepoChartLoader) loadRepositoryChart(
+// getRepositoryURL returns the normalized URL of the Helm repository either
+// defined by repoNode or provided in repoURL.
+func (loader *helmRepoChartLoader) getRepositoryURL(
 	repoNode *yaml.RNode,
 	repoURL string,
-	parentContext *chartContext,
-	chartName string,
-	chartVersionSpec string,
-) (*chart.Chart, error) {
-	start := time.Now()
-	savedLogger := loader.logger
-	defer func() { loader.logger = savedLogger }()
-
+) (string, error) {
 	if repoNode != nil {
 		var repo sourcev1.HelmRepository
 		err := decodeToObject(repoNode, &repo)
 		if err != nil {
-			return nil, fmt.Errorf(
+			return "", fmt.Errorf(
 				"unable to decode HelmRepository %s/%s: %w",
 				repoNode.GetNamespace(),
 				repoNode.GetName(),
@@ -85,31 +84,163 @@ func (loader *helmRepoChartLoader) loadRepositoryChart(
 		repoURL = repo.Spec.URL
 	}
 
-	repoURL, err := normalizeURL(repoURL)
+	normalizedURL, err := normalizeURL(repoURL)
 	if err != nil {
-		return nil, fmt.Errorf(
+		return "", fmt.Errorf(
 			"invalid Helm repository URL %s: %w",
 			repoURL,
 			err,
 		)
 	}
+	return normalizedURL, nil
+}
 
-	loader.logger = loader.logger.With(
-		"url", repoURL,
-		"chart", chartName,
-		"version", chartVersionSpec,
-	)
-	loader.logger.Debug("Loading chart from Helm repository")
+// helmRepoAccess holds the settings for accessing a Helm repository over
+// HTTP: the credentials of the repository from the credentials file, with the
+// certificate authority and the client certificate in the ca.crt, tls.crt,
+// and tls.key entries, or the caFile, certFile, and keyFile ones, like in the
+// Flux secrets, and whether to pass the
+// credentials to other hosts serving the charts, as spec.passCredentials of
+// the HelmRepository object says.
+type helmRepoAccess struct {
+	url                string
+	username           string
+	password           string
+	passCredentialsAll bool
+	// transport is shared by the repositories with the same TLS settings, or
+	// nil without TLS settings to use the default one outside of a run.
+	transport *http.Transport
+}
 
-	repoPath := getCachePathForRepo(loader.cacheRoot, repoURL, false)
+// newHelmRepoTransport returns the transport presenting the client
+// certificate and trusting the certificate authority in the credentials, or
+// nil if they have neither.
+func newHelmRepoTransport(credentials map[string]string) (*http.Transport, error) {
+	caData := cmp.Or(credentials["ca.crt"], credentials["caFile"])
+	certData := cmp.Or(credentials["tls.crt"], credentials["certFile"])
+	keyData := cmp.Or(credentials["tls.key"], credentials["keyFile"])
+	if caData == "" && certData == "" && keyData == "" {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{}
+	if caData != "" {
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM([]byte(caData)) {
+			return nil, fmt.Errorf("no valid certificate authority certificates")
+		}
+	}
+	if certData != "" || keyData != "" {
+		certificate, err := tls.X509KeyPair([]byte(certData), []byte(keyData))
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+// getRepositoryAccess returns the settings for accessing the Helm repository
+// at the normalized URL, optionally defined by repoNode.
+func (loader *helmRepoChartLoader) getRepositoryAccess(
+	repoNode *yaml.RNode,
+	repoURL string,
+) (*helmRepoAccess, error) {
+	access := &helmRepoAccess{url: loader.getMirrorURL(repoURL)}
+	var repo *sourcev1.HelmRepository
+	if repoNode != nil {
+		repo = &sourcev1.HelmRepository{}
+		if err := decodeToObject(repoNode, repo); err != nil {
+			return nil, fmt.Errorf(
+				"unable to decode HelmRepository %s/%s: %w",
+				repoNode.GetNamespace(),
+				repoNode.GetName(),
+				err,
+			)
+		}
+		access.passCredentialsAll = repo.Spec.PassCredentials
+	}
+
+	parsedURL, err := url.Parse(access.url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Helm repository URL %s: %w", access.url, err)
+	}
+	repoCreds, err := loader.credentials.FindForRepo(parsedURL)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"unable to find credentials for repository %s: %w",
+			access.url,
+			err,
+		)
+	}
+	var credentials map[string]string
+	if repoCreds != nil {
+		credentials = repoCreds.Credentials
+		access.username = credentials["username"]
+		access.password = credentials["password"]
+	}
+	tlsCredentials, err := loader.getTLSCredentials(repo, credentials)
+	if err != nil {
+		return nil, err
+	}
+	access.transport, err = loader.connections.getTransport(tlsCredentials)
+	if err != nil {
+		return nil, NewClassifiedError(ErrorClassAuth, fmt.Errorf(
+			"invalid TLS credentials for repository %s: %w",
+			access.url,
+			err,
+		))
+	}
+	return access, nil
+}
+
+// getGetters returns the Helm getters, with the HTTP one using the TLS
+// settings of the repository.
+func (access *helmRepoAccess) getGetters() helmgetter.Providers {
 	getters := helmgetter.All(&cli.EnvSettings{})
+	if access.transport == nil {
+		return getters
+	}
+	// The first getter for a scheme is used.
+	httpGetter := helmgetter.Provider{
+		Schemes: []string{"http", "https"},
+		New: func(options ...helmgetter.Option) (helmgetter.Getter, error) {
+			return helmgetter.NewHTTPGetter(
+				append(options, helmgetter.WithTransport(access.transport))...,
+			)
+		},
+	}
+	return append(helmgetter.Providers{httpGetter}, getters...)
+}
+
+// getGetterOptions returns the options for downloading charts from the
+// repository with the Helm getters.
+func (access *helmRepoAccess) getGetterOptions() []helmgetter.Option {
+	return []helmgetter.Option{
+		helmgetter.WithURL(access.url),
+		helmgetter.WithBasicAuth(access.username, access.password),
+		helmgetter.WithPassCredentialsAll(access.passCredentialsAll),
+	}
+}
+
+// loadChartRepository returns the chart repository object with the index
+// loaded, downloading the index file unless it is already in the file cache
+// and not older than the maximum index age.
+func (loader *helmRepoChartLoader) loadChartRepository(
+	repoURL string,
+	access *helmRepoAccess,
+) (*helmrepo.ChartRepository, error) {
+	repoPath := getCachePathForRepo(loader.cacheRoot, repoURL, false)
 	chartRepo, err := helmrepo.NewChartRepository(
 		&helmrepo.Entry{
-			Name: "repo",
-			URL:  repoURL,
-			// TODO(vlad): Use chart repository options when provided.
+			Name:               "repo",
+			URL:                access.url,
+			Username:           access.username,
+			Password:           access.password,
+			PassCredentialsAll: access.passCredentialsAll,
 		},
-		getters,
+		access.getGetters(),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create chart repository object: %w", err)
@@ -121,14 +252,30 @@ func (loader *helmRepoChartLoader) loadRepositoryChart(
 		repoPath,
 		helmpath.CacheIndexFile(chartRepo.Config.Name),
 	)
-	if _, err := os.Stat(indexFilePath); os.IsNotExist(err) {
-		indexFilePath, err = chartRepo.DownloadIndexFile()
+	stat, err := os.Stat(indexFilePath)
+	if os.IsNotExist(err) && loader.offline {
+		return nil, &CacheMissError{
+			Resource: fmt.Sprintf("index of Helm repository %s", repoURL),
+		}
+	}
+	// Stale index files are still used in the offline mode.
+	if os.IsNotExist(err) || (err == nil && !loader.offline && loader.isIndexStale(stat)) {
+		releaseFetchSlot, err := loader.acquireFetchSlot(chartRepo.Config.URL)
 		if err != nil {
-			return nil, fmt.Errorf(
+			return nil, err
+		}
+		if loader.indexMaxAge > 0 || loader.isVerifyingCache() {
+			err = loader.downloadIndexFile(chartRepo, access, indexFilePath)
+		} else {
+			indexFilePath, err = chartRepo.DownloadIndexFile()
+		}
+		releaseFetchSlot()
+		if err != nil {
+			return nil, newFetchError(fmt.Errorf(
 				"unable to download index file for Helm repository %s: %w",
 				repoURL,
 				err,
-			)
+			))
 		}
 	}
 	repoIndex, err := helmrepo.LoadIndexFile(indexFilePath)
@@ -140,9 +287,41 @@ func (loader *helmRepoChartLoader) loadRepositoryChart(
 		)
 	}
 	chartRepo.IndexFile = repoIndex
-	version, err := repoIndex.Get(chartName, chartVersionSpec)
+	return chartRepo, nil
+}
+
+func (loader *helmRepoChartLoader) resolveChartVersion(
+	repoNode *yaml.RNode,
+	repoURL string,
+	chartName string,
+	chartVersionSpec string,
+) (string, error) {
+	savedLogger := loader.logger
+	defer func() { loader.logger = savedLogger }()
+
+	repoURL, err := loader.getRepositoryURL(repoNode, repoURL)
 	if err != nil {
-		return nil, fmt.Errorf(
+		return "", err
+	}
+	substitutedChart, err := loader.loadSubstitutedChart(repoURL, chartName)
+	if err != nil {
+		return "", err
+	}
+	if substitutedChart != nil {
+		return substitutedChart.Metadata.Version, nil
+	}
+
+	access, err := loader.getRepositoryAccess(repoNode, repoURL)
+	if err != nil {
+		return "", err
+	}
+	chartRepo, err := loader.loadChartRepository(repoURL, access)
+	if err != nil {
+		return "", err
+	}
+	version, err := chartRepo.IndexFile.Get(chartName, chartVersionSpec)
+	if err != nil {
+		return "", fmt.Errorf(
 			"unable to get chart %s/%s from Helm repository %s: %w",
 			chartName,
 			chartVersionSpec,
@@ -150,124 +329,196 @@ func (loader *helmRepoChartLoader) loadRepositoryChart(
 			err,
 		)
 	}
+	return version.Version, nil
+}
 
-	chartVersion := version.Version
-	chartKey := fmt.Sprintf("%s#%s#%s", repoURL, chartName, chartVersion)
-	if loader.chartCache != nil {
-		if chart, ok := loader.chartCache[chartKey]; ok {
-			loader.logger.Debug("Using chart from in-memory cache")
-			return chart, nil
-		}
+func (loader *helmRepoChartLoader) loadRepositoryChart(
+	repoNode *yaml.RNode,
+	repoURL string,
+	parentContext *chartContext,
+	chartName string,
+	chartVersionSpec string,
+) (*chart.Chart, error) {
+	start := time.Now()
+	savedLogger := loader.logger
+	defer func() { loader.logger = savedLogger }()
+
+	repoURL, err := loader.getRepositoryURL(repoNode, repoURL)
+	if err != nil {
+		return nil, err
+	}
+	substitutedChart, err := loader.loadSubstitutedChart(repoURL, chartName)
+	if err != nil {
+		return nil, err
+	}
+	if substitutedChart != nil {
+		return substitutedChart, nil
 	}
 
-	chartDir := filepath.Join(
-		chartRepo.CachePath,
-		fmt.Sprintf("%s-%s", chartName, chartVersion),
+	loader.logger = loader.logger.With(
+		"url", repoURL,
+		"chart", chartName,
+		"version", chartVersionSpec,
 	)
-	var chart *chart.Chart
-	var stat os.FileInfo
-	if stat, err = os.Stat(chartDir); err == nil && stat.IsDir() {
-		chart, err = helmloader.LoadDir(chartDir)
-	}
+	loader.logger.Debug("Loading chart from Helm repository")
 
+	access, err := loader.getRepositoryAccess(repoNode, repoURL)
 	if err != nil {
-		if err := os.RemoveAll(chartDir); err != nil {
-			loader.logger.
-				With("error", err).
-				With("dir", chartDir).
-				Error("Unable to remove the chart cache directory")
-		}
+		return nil, err
+	}
+	getters := access.getGetters()
+	chartRepo, err := loader.loadChartRepository(repoURL, access)
+	if err != nil {
+		return nil, err
+	}
+	version, err := chartRepo.IndexFile.Get(chartName, chartVersionSpec)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"unable to get chart %s/%s from Helm repository %s: %w",
+			chartName,
+			chartVersionSpec,
+			repoURL,
+			err,
+		)
+	}
 
-		parsedURL, err := url.Parse(version.URLs[0])
-		if err != nil {
-			return nil, fmt.Errorf(
-				"unable to parse chart URL %s: %w",
-				version.URLs[0],
-				err,
-			)
-		}
-		if parsedURL.Host == "" && !path.IsAbs(parsedURL.Path) {
-			// Adjust the URL to be absolute.
-			parsedRepoURL, _ := url.Parse(repoURL)
-			parsedRepoURL.Path = path.Join(parsedRepoURL.Path, parsedURL.Path)
-			parsedURL = parsedRepoURL
+	chartVersion := version.Version
+	chartKey := fmt.Sprintf("%s#%s#%s", repoURL, chartName, chartVersion)
+	chart, cached, err := loader.chartCache.load(chartKey, func() (*chart.Chart, error) {
+		chartDir := filepath.Join(
+			chartRepo.CachePath,
+			fmt.Sprintf("%s-%s", chartName, chartVersion),
+		)
+		var chart *chart.Chart
+		var stat os.FileInfo
+		if stat, err = os.Stat(chartDir); err == nil && stat.IsDir() {
+			// Verify the cached chart against the digest in the revalidated
+			// index, when the index has one.
+			if loader.isVerifyingCache() && !loader.offline && version.Digest != "" &&
+				loader.invalidateCachedChart(chartDir, version.Digest) {
+				err = os.ErrNotExist
+			} else {
+				chart, err = helmloader.LoadDir(chartDir)
+			}
 		}
 
-		getter, err := getters.ByScheme(parsedURL.Scheme)
 		if err != nil {
-			return nil, fmt.Errorf(
-				"unknown scheme %s for chart %s: %w",
-				parsedURL.Scheme,
-				version.URLs[0],
-				err,
-			)
-		}
+			if err := os.RemoveAll(chartDir); err != nil {
+				loader.logger.
+					With("error", err).
+					With("dir", chartDir).
+					Error("Unable to remove the chart cache directory")
+			}
+			if loader.offline {
+				return nil, &CacheMissError{Resource: fmt.Sprintf(
+					"chart %s version %s from Helm repository %s",
+					chartName,
+					chartVersion,
+					repoURL,
+				)}
+			}
 
-		chartData, err := getter.Get(
-			parsedURL.String(),
-			[]helmgetter.Option{}...) // TODO(vlad): Set options if necessary.
-		if err != nil {
-			return nil, fmt.Errorf(
-				"unable to download chart %s: %w",
-				parsedURL.String(),
-				err,
-			)
-		}
+			parsedURL, err := url.Parse(loader.getMirrorURL(version.URLs[0]))
+			if err != nil {
+				return nil, fmt.Errorf(
+					"unable to parse chart URL %s: %w",
+					version.URLs[0],
+					err,
+				)
+			}
+			if parsedURL.Host == "" && !path.IsAbs(parsedURL.Path) {
+				// Adjust the URL to be absolute.
+				parsedRepoURL, _ := url.Parse(chartRepo.Config.URL)
+				parsedRepoURL.Path = path.Join(parsedRepoURL.Path, parsedURL.Path)
+				parsedURL = parsedRepoURL
+			}
 
-		files, err := archive.LoadArchiveFiles(chartData)
-		if err != nil {
-			return nil, fmt.Errorf(
-				"unable to load chart archive %s/%s in %s: %w",
-				chartName,
-				chartVersionSpec,
-				repoURL,
-				err,
-			)
-		}
+			getter, err := getters.ByScheme(parsedURL.Scheme)
+			if err != nil {
+				return nil, fmt.Errorf(
+					"unknown scheme %s for chart %s: %w",
+					parsedURL.Scheme,
+					version.URLs[0],
+					err,
+				)
+			}
 
-		chart, err = helmloader.LoadFiles(files)
-		if err != nil {
-			return nil, fmt.Errorf(
-				"unable to load chart files %s/%s in %s: %w",
-				chartName,
-				chartVersionSpec,
-				repoURL,
-				err,
-			)
+			releaseFetchSlot, err := loader.acquireFetchSlot(parsedURL.String())
+			if err != nil {
+				return nil, err
+			}
+			chartData, err := getter.Get(parsedURL.String(), access.getGetterOptions()...)
+			releaseFetchSlot()
+			if err != nil {
+				return nil, newFetchError(fmt.Errorf(
+					"unable to download chart %s: %w",
+					parsedURL.String(),
+					err,
+				))
+			}
+
+			digest := getArchiveDigest(chartData.Bytes())
+			files, err := archive.LoadArchiveFiles(chartData)
+			if err != nil {
+				return nil, fmt.Errorf(
+					"unable to load chart archive %s/%s in %s: %w",
+					chartName,
+					chartVersionSpec,
+					repoURL,
+					err,
+				)
+			}
+
+			chart, err = helmloader.LoadFiles(files)
+			if err != nil {
+				return nil, fmt.Errorf(
+					"unable to load chart files %s/%s in %s: %w",
+					chartName,
+					chartVersionSpec,
+					repoURL,
+					err,
+				)
+			}
+
+			err = saveChartFiles(files, chartDir)
+			if err != nil {
+				return nil, fmt.Errorf(
+					"unable to save chart files %s/%s in %s to cache: %w",
+					chartName,
+					chartVersionSpec,
+					repoURL,
+					err,
+				)
+			}
+			loader.saveChartDigest(chartDir, digest)
+		} else {
+			loader.logger.Debug("Using cached Helm chart")
 		}
 
-		err = saveChartFiles(files, chartDir)
+		startDeps := time.Now()
+		loader.logger = loader.logger.WithGroup("deps")
+		err = loadChartDependencies(loader.loaderConfig, chart, nil)
 		if err != nil {
 			return nil, fmt.Errorf(
-				"unable to save chart files %s/%s in %s to cache: %w",
+				"unable to load chart dependencies for %s/%s in %s: %w",
 				chartName,
-				chartVersionSpec,
+				chart.Metadata.Version,
 				repoURL,
 				err,
 			)
 		}
-	} else {
-		loader.logger.Debug("Using cached Helm chart")
-	}
+		loader.logger.
+			With("duration", time.Since(startDeps)).
+			Debug("Finished loading deps")
 
-	startDeps := time.Now()
-	loader.logger = loader.logger.WithGroup("deps")
-	err = loadChartDependencies(loader.loaderConfig, chart, nil)
+		return chart, nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf(
-			"unable to load chart dependencies for %s/%s in %s: %w",
-			chartName,
-			chart.Metadata.Version,
-			repoURL,
-			err,
-		)
+		return nil, err
 	}
-	loader.logger.
-		With("duration", time.Since(startDeps)).
-		Debug("Finished loading deps")
-
-	if loader.chartCache != nil {
-		loader.chartCache[chartKey] = chart
+	if cached {
+		loader.logger.Debug("Using chart from in-memory cache")
+		return chart, nil
 	}
 
 	loader.logger.
@@ -114,7 +114,7 @@ var _ = ginkgo.Describe("HelmRepository expansion", func() {
 			"kind: ConfigMap",
 			"metadata:",
 			"  namespace: testns",
-			"  name: testns-test-configmap",
+			"  name: test-configmap",
 			"data:",
 			"  foo: baz",
 			"",
@@ -213,7 +213,7 @@ var _ = ginkgo.Describe("HelmRepository expansion", func() {
 			"kind: ConfigMap",
 			"metadata:",
 			"  namespace: testns",
-			"  name: testns-test-configmap",
+			"  name: test-configmap",
 			"data:",
 			"  foo: baz",
 			"---",
@@ -222,7 +222,7 @@ var _ = ginkgo.Describe("HelmRepository expansion", func() {
 			"kind: ConfigMap",
 			"metadata:",
 			"  namespace: testns-different",
-			"  name: testns-different-test-another-configmap",
+			"  name: test-another-configmap",
 			"data:",
 			"  foo: baz",
 			"",
@@ -302,7 +302,7 @@ var _ = ginkgo.Describe("HelmRepository expansion", func() {
 			"kind: ConfigMap",
 			"metadata:",
 			"  namespace: testns",
-			"  name: testns-test-configmap",
+			"  name: test-configmap",
 			"data:",
 			"  foo: baz",
 			"",
@@ -328,7 +328,7 @@ var _ = ginkgo.Describe("HelmRepository expansion", func() {
 		// access to the chart server (it has been stopped). The chart should be
 		// loaded from the file cache.
 		g.Expect(err).ToNot(gomega.HaveOccurred())
-		repoDir := filepath.Join(cacheRoot, fmt.Sprintf("http:##localhost:%d", port))
+		repoDir := getCachePathForRepo(cacheRoot, fmt.Sprintf("http://localhost:%d", port), false)
 		g.Expect(repoDir).To(gomega.BeADirectory())
 		g.Expect(filepath.Join(repoDir, "repo-index.yaml")).To(gomega.BeARegularFile())
 		configmapTemplateName := filepath.Join(
@@ -467,18 +467,18 @@ var _ = ginkgo.Describe("HelmRepository expansion", func() {
 			"# Source: dep-chart/templates/configmap.yaml",
 		))
 		g.Expect(result).To(gomega.ContainSubstring(
-			"name: testns-standalone-release-dep-configmap",
+			"name: standalone-release-dep-configmap",
 		))
 		// Wrapper expansion must also produce its own dependency output.
 		g.Expect(result).To(gomega.ContainSubstring(
 			"# Source: wrapper-chart/charts/dep-chart/templates/configmap.yaml",
 		))
 		g.Expect(result).To(gomega.ContainSubstring(
-			"name: testns-wrapper-release-dep-configmap",
+			"name: wrapper-release-dep-configmap",
 		))
 		// And the wrapper chart's own templates should still render.
 		g.Expect(result).To(gomega.ContainSubstring(
-			"name: testns-wrapper-release-wrapper-configmap",
+			"name: wrapper-release-wrapper-configmap",
 		))
 	})
 
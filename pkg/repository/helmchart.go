@@ -0,0 +1,104 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"fmt"
+
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+
+	yamlutil "github.com/sageailabs/fouskoti/pkg/yaml"
+)
+
+// getHelmChartReference returns the namespace and name of the HelmChart the
+// release references, in spec.chartRef or in spec.chart.spec.sourceRef, and
+// whether it references one.
+func getHelmChartReference(release *yaml.RNode) (string, string, bool, error) {
+	for _, path := range []string{"spec.chartRef", "spec.chart.spec.sourceRef"} {
+		kind, err := yamlutil.GetStringOr(release, path+".kind", "")
+		if err != nil {
+			return "", "", false, err
+		}
+		if kind != "HelmChart" {
+			continue
+		}
+		name, err := release.GetString(path + ".name")
+		if err != nil {
+			return "", "", false, fmt.Errorf("unable to get HelmChart name: %w", err)
+		}
+		namespace, err := yamlutil.GetStringOr(
+			release,
+			path+".namespace",
+			release.GetNamespace(),
+		)
+		if err != nil {
+			return "", "", false, err
+		}
+		return namespace, name, true, nil
+	}
+	return "", "", false, nil
+}
+
+// resolveHelmChartReference returns a copy of the release referencing a
+// HelmChart object with its chart template taken from the spec of the
+// HelmChart, so that the chart is loaded from the source of the HelmChart.
+// Releases not referencing a HelmChart are returned as is.
+func resolveHelmChartReference(
+	sources sourceIndex,
+	release *yaml.RNode,
+) (*yaml.RNode, error) {
+	namespace, name, found, err := getHelmChartReference(release)
+	if err != nil || !found {
+		return release, err
+	}
+
+	helmChart := sources.findSource("HelmChart", namespace, name)
+	if helmChart == nil {
+		return nil, fmt.Errorf("missing HelmChart %s/%s", namespace, name)
+	}
+	helmChart, err = convertFluxObject(helmChart)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"unable to convert HelmChart %s/%s: %w",
+			namespace,
+			name,
+			err,
+		)
+	}
+
+	chartSpec, err := helmChart.Pipe(yaml.Lookup("spec"))
+	if err != nil || chartSpec == nil {
+		return nil, fmt.Errorf("missing spec in HelmChart %s/%s", namespace, name)
+	}
+	chartSpec = chartSpec.Copy()
+	// The source of a HelmChart is in the namespace of the HelmChart.
+	err = chartSpec.PipeE(
+		yaml.Lookup("sourceRef"),
+		yaml.SetField("namespace", yaml.NewStringRNode(namespace)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"unable to set source namespace of HelmChart %s/%s: %w",
+			namespace,
+			name,
+			err,
+		)
+	}
+
+	result := release.Copy()
+	err = result.PipeE(
+		yaml.Lookup("spec"),
+		yaml.Clear("chartRef"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to clear spec.chartRef: %w", err)
+	}
+	err = result.PipeE(
+		yaml.LookupCreate(yaml.MappingNode, "spec", "chart"),
+		yaml.SetField("spec", chartSpec),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to set spec.chart.spec: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,198 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+var _ = ginkgo.Describe("HelmChart references", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+	})
+
+	readNodes := func(lines ...string) []*yaml.RNode {
+		nodes, err := (&kio.ByteReader{
+			Reader:                bytes.NewBufferString(strings.Join(lines, "\n")),
+			OmitReaderAnnotations: true,
+		}).Read()
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		return nodes
+	}
+
+	helmChart := []string{
+		"apiVersion: source.toolkit.fluxcd.io/v1",
+		"kind: HelmChart",
+		"metadata:",
+		"  namespace: flux-system",
+		"  name: test-chart",
+		"spec:",
+		"  chart: test-chart",
+		"  version: 0.1.x",
+		"  valuesFiles:",
+		"  - values-prod.yaml",
+		"  sourceRef:",
+		"    kind: HelmRepository",
+		"    name: local",
+	}
+
+	ginkgo.It("takes the chart template from the HelmChart", func() {
+		nodes := readNodes(append([]string{
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"spec:",
+			"  chartRef:",
+			"    kind: HelmChart",
+			"    name: test-chart",
+			"    namespace: flux-system",
+			"---",
+		}, helmChart...)...)
+
+		release, err := resolveHelmChartReference(newSourceIndex(nodes), nodes[0])
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(release.MustString()).To(gomega.Equal(strings.Join([]string{
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"spec:",
+			"  chart:",
+			"    spec:",
+			"      chart: test-chart",
+			"      version: 0.1.x",
+			"      valuesFiles:",
+			"      - values-prod.yaml",
+			"      sourceRef:",
+			"        kind: HelmRepository",
+			"        name: local",
+			"        namespace: flux-system",
+			"",
+		}, "\n")))
+		// The input object is left as is.
+		g.Expect(nodes[0].MustString()).To(gomega.ContainSubstring("chartRef"))
+	})
+
+	ginkgo.It("fails on missing HelmChart objects", func() {
+		nodes := readNodes(
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"spec:",
+			"  chart:",
+			"    spec:",
+			"      chart: test-chart",
+			"      sourceRef:",
+			"        kind: HelmChart",
+			"        name: missing",
+		)
+
+		_, err := resolveHelmChartReference(newSourceIndex(nodes), nodes[0])
+		g.Expect(err).To(gomega.MatchError("missing HelmChart testns/missing"))
+	})
+
+	ginkgo.It("expands releases referencing HelmChart objects", func() {
+		repoRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(repoRoot)
+		server, port, serverDone, err := serveDirectory(repoRoot, logger, nil)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer func() {
+			err := stopServing(server, serverDone)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+		}()
+
+		err = createSingleChartHelmRepository(
+			"test-chart",
+			"0.1.0",
+			map[string]string{
+				"Chart.yaml": strings.Join([]string{
+					"apiVersion: v2",
+					"name: test-chart",
+					"version: 0.1.0",
+				}, "\n"),
+				"templates/configmap.yaml": strings.Join([]string{
+					"apiVersion: v1",
+					"kind: ConfigMap",
+					"metadata:",
+					"  name: {{ .Release.Name }}-configmap",
+				}, "\n"),
+			},
+			port,
+			repoRoot,
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		input := strings.Join([]string{
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"spec:",
+			"  chartRef:",
+			"    kind: HelmChart",
+			"    name: test-chart",
+			"    namespace: flux-system",
+			"---",
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: HelmChart",
+			"metadata:",
+			"  namespace: flux-system",
+			"  name: test-chart",
+			"spec:",
+			"  chart: test-chart",
+			"  sourceRef:",
+			"    kind: HelmRepository",
+			"    name: local",
+			"---",
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: HelmRepository",
+			"metadata:",
+			"  namespace: flux-system",
+			"  name: local",
+			"spec:",
+			fmt.Sprintf("  url: http://localhost:%d", port),
+		}, "\n")
+
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		var output bytes.Buffer
+		err = expander.Expand(
+			bytes.NewBufferString(input),
+			&output,
+			ExpandOptions{},
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(output.String()).To(gomega.ContainSubstring(strings.Join([]string{
+			"# Source: test-chart/templates/configmap.yaml",
+			"apiVersion: v1",
+			"kind: ConfigMap",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test-configmap",
+		}, "\n")))
+	})
+})
@@ -0,0 +1,152 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	helmrepo "helm.sh/helm/v4/pkg/repo/v1"
+)
+
+// indexValidatorsSuffix is appended to the name of a cached index file to
+// name the file keeping the HTTP cache validators of its download.
+const indexValidatorsSuffix = ".validators"
+
+// indexValidators are the HTTP cache validators of a downloaded index file,
+// sent along with the requests revalidating it.
+type indexValidators struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// readIndexValidators returns the validators saved for the index file, or no
+// validators if there are none.
+func readIndexValidators(indexFilePath string) indexValidators {
+	var validators indexValidators
+	content, err := os.ReadFile(indexFilePath + indexValidatorsSuffix)
+	if err == nil {
+		// Unreadable validators only make the index be downloaded again.
+		_ = json.Unmarshal(content, &validators)
+	}
+	return validators
+}
+
+// isIndexStale tells whether the cached index file needs to be revalidated.
+// When verifying the cached charts, the index files are revalidated once
+// since the verification was enabled.
+func (loader *helmRepoChartLoader) isIndexStale(stat os.FileInfo) bool {
+	if loader.isVerifyingCache() && stat.ModTime().Before(loader.verifyCacheSince) {
+		return true
+	}
+	return loader.indexMaxAge > 0 && time.Since(stat.ModTime()) > loader.indexMaxAge
+}
+
+// downloadIndexFile downloads the index file of the chart repository to
+// indexFilePath.  Over HTTP, the request carries the credentials of the
+// repository and the cache validators of the cached index file, if any, and
+// the cached file is kept if the server reports it unchanged.  Other schemes
+// are left to the Helm getters.
+func (loader *helmRepoChartLoader) downloadIndexFile(
+	chartRepo *helmrepo.ChartRepository,
+	access *helmRepoAccess,
+	indexFilePath string,
+) error {
+	indexURL, err := helmrepo.ResolveReferenceURL(chartRepo.Config.URL, "index.yaml")
+	if err != nil {
+		return fmt.Errorf("unable to get index URL of %s: %w", chartRepo.Config.URL, err)
+	}
+	parsedURL, err := url.Parse(indexURL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
+		_, err := chartRepo.DownloadIndexFile()
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(loader.ctx, http.MethodGet, indexURL, nil)
+	if err != nil {
+		return fmt.Errorf("unable to create request for %s: %w", indexURL, err)
+	}
+	if access.username != "" && access.password != "" {
+		request.SetBasicAuth(access.username, access.password)
+	}
+	if _, err := os.Stat(indexFilePath); err == nil {
+		validators := readIndexValidators(indexFilePath)
+		if validators.ETag != "" {
+			request.Header.Set("If-None-Match", validators.ETag)
+		}
+		if validators.LastModified != "" {
+			request.Header.Set("If-Modified-Since", validators.LastModified)
+		}
+	}
+
+	client := &http.Client{}
+	if access.transport != nil {
+		client.Transport = access.transport
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return fmt.Errorf("unable to fetch %s: %w", indexURL, err)
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	switch response.StatusCode {
+	case http.StatusNotModified:
+		loader.logger.With("url", indexURL).Debug("Cached index file is up to date")
+		now := time.Now()
+		if err := os.Chtimes(indexFilePath, now, now); err != nil {
+			return fmt.Errorf("unable to update time of index file %s: %w", indexFilePath, err)
+		}
+		return nil
+	case http.StatusOK:
+	default:
+		return &httpStatusError{
+			url:        indexURL,
+			statusCode: response.StatusCode,
+			status:     response.Status,
+		}
+	}
+
+	indexDir := filepath.Dir(indexFilePath)
+	if err := os.MkdirAll(indexDir, 0700); err != nil {
+		return fmt.Errorf("unable to create cache directory %s: %w", indexDir, err)
+	}
+	tempFile, err := os.CreateTemp(indexDir, ".tmp-index-")
+	if err != nil {
+		return fmt.Errorf("unable to create temporary index file in %s: %w", indexDir, err)
+	}
+	// Failures to remove temporary files are not interesting.
+	defer func() { _ = os.Remove(tempFile.Name()) }()
+	_, err = io.Copy(tempFile, response.Body)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("unable to download %s: %w", indexURL, err)
+	}
+	// Keep the cached index file if the new one is broken.
+	if _, err := helmrepo.LoadIndexFile(tempFile.Name()); err != nil {
+		return fmt.Errorf("invalid index file %s: %w", indexURL, err)
+	}
+
+	validators, err := json.Marshal(indexValidators{
+		ETag:         response.Header.Get("ETag"),
+		LastModified: response.Header.Get("Last-Modified"),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to encode validators of %s: %w", indexURL, err)
+	}
+	err = os.WriteFile(indexFilePath+indexValidatorsSuffix, validators, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to save validators of %s: %w", indexURL, err)
+	}
+	if err := os.Rename(tempFile.Name(), indexFilePath); err != nil {
+		return fmt.Errorf("unable to save index file %s: %w", indexFilePath, err)
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"helm.sh/helm/v4/pkg/helmpath"
+)
+
+var _ = ginkgo.Describe("Helm repository index refreshes", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+	})
+
+	getIndex := func(version string) string {
+		return strings.Join([]string{
+			"apiVersion: v1",
+			"entries:",
+			"  test-chart:",
+			"  - name: test-chart",
+			"    version: " + version,
+			"    urls:",
+			"    - test-chart-" + version + ".tgz",
+		}, "\n")
+	}
+
+	ginkgo.It("downloads stale index files only when they have changed", func() {
+		cacheRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(cacheRoot)
+
+		version := "0.1.0"
+		var statuses []int
+		server := httptest.NewServer(http.HandlerFunc(
+			func(writer http.ResponseWriter, request *http.Request) {
+				etag := fmt.Sprintf("%q", version)
+				if request.Header.Get("If-None-Match") == etag {
+					statuses = append(statuses, http.StatusNotModified)
+					writer.WriteHeader(http.StatusNotModified)
+					return
+				}
+				statuses = append(statuses, http.StatusOK)
+				writer.Header().Set("ETag", etag)
+				_, _ = writer.Write([]byte(getIndex(version)))
+			},
+		))
+		defer server.Close()
+
+		loader := &helmRepoChartLoader{loaderConfig{
+			ctx:         ctx,
+			logger:      logger,
+			cacheRoot:   cacheRoot,
+			indexMaxAge: time.Hour,
+		}}
+		repoURL := server.URL + "/"
+		indexFilePath := filepath.Join(
+			getCachePathForRepo(cacheRoot, repoURL, false),
+			helmpath.CacheIndexFile("repo"),
+		)
+		getVersion := func() string {
+			access, err := loader.getRepositoryAccess(nil, repoURL)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+			chartRepo, err := loader.loadChartRepository(repoURL, access)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+			chartVersion, err := chartRepo.IndexFile.Get("test-chart", "*")
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+			return chartVersion.Version
+		}
+		age := func() {
+			staleTime := time.Now().Add(-2 * time.Hour)
+			g.Expect(os.Chtimes(indexFilePath, staleTime, staleTime)).To(gomega.Succeed())
+		}
+
+		g.Expect(getVersion()).To(gomega.Equal("0.1.0"))
+		g.Expect(getVersion()).To(gomega.Equal("0.1.0"))
+		g.Expect(statuses).To(gomega.Equal([]int{http.StatusOK}))
+
+		age()
+		g.Expect(getVersion()).To(gomega.Equal("0.1.0"))
+		g.Expect(statuses).To(gomega.Equal([]int{http.StatusOK, http.StatusNotModified}))
+		g.Expect(getVersion()).To(gomega.Equal("0.1.0"))
+		g.Expect(statuses).To(gomega.HaveLen(2))
+
+		version = "0.2.0"
+		age()
+		g.Expect(getVersion()).To(gomega.Equal("0.2.0"))
+		g.Expect(statuses).To(gomega.Equal(
+			[]int{http.StatusOK, http.StatusNotModified, http.StatusOK},
+		))
+
+		age()
+		loader.offline = true
+		g.Expect(getVersion()).To(gomega.Equal("0.2.0"))
+		g.Expect(statuses).To(gomega.HaveLen(3))
+	})
+	loadIndex := func(loader *helmRepoChartLoader, repoURL string) error {
+		access, err := loader.getRepositoryAccess(nil, repoURL)
+		if err != nil {
+			return err
+		}
+		_, err = loader.loadChartRepository(repoURL, access)
+		return err
+	}
+
+	ginkgo.It("downloads index files with the repository credentials", func() {
+		cacheRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(cacheRoot)
+
+		server := httptest.NewServer(http.HandlerFunc(
+			func(writer http.ResponseWriter, request *http.Request) {
+				username, password, ok := request.BasicAuth()
+				if !ok || username != "user" || password != "password" {
+					writer.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				_, _ = writer.Write([]byte(getIndex("0.1.0")))
+			},
+		))
+		defer server.Close()
+
+		repoURL := server.URL + "/"
+		loader := &helmRepoChartLoader{loaderConfig{
+			ctx:         ctx,
+			logger:      logger,
+			cacheRoot:   cacheRoot,
+			indexMaxAge: time.Hour,
+		}}
+		err = loadIndex(loader, repoURL)
+		g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("401 Unauthorized")))
+
+		loader.credentials = Credentials{
+			repoURL: RepositoryCreds{
+				Credentials: map[string]string{"username": "user", "password": "password"},
+			},
+		}
+		g.Expect(loadIndex(loader, repoURL)).To(gomega.Succeed())
+	})
+
+	ginkgo.It("keeps the cached index file when the server fails", func() {
+		cacheRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(cacheRoot)
+
+		failing := false
+		server := httptest.NewServer(http.HandlerFunc(
+			func(writer http.ResponseWriter, request *http.Request) {
+				if failing {
+					writer.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				_, _ = writer.Write([]byte(getIndex("0.1.0")))
+			},
+		))
+		defer server.Close()
+
+		repoURL := server.URL + "/"
+		loader := &helmRepoChartLoader{loaderConfig{
+			ctx:         ctx,
+			logger:      logger,
+			cacheRoot:   cacheRoot,
+			indexMaxAge: time.Hour,
+		}}
+		g.Expect(loadIndex(loader, repoURL)).To(gomega.Succeed())
+		indexFilePath := filepath.Join(
+			getCachePathForRepo(cacheRoot, repoURL, false),
+			helmpath.CacheIndexFile("repo"),
+		)
+		content, err := os.ReadFile(indexFilePath)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		failing = true
+		staleTime := time.Now().Add(-2 * time.Hour)
+		g.Expect(os.Chtimes(indexFilePath, staleTime, staleTime)).To(gomega.Succeed())
+		err = loadIndex(loader, repoURL)
+		g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("500 Internal Server Error")))
+		g.Expect(os.ReadFile(indexFilePath)).To(gomega.Equal(content))
+	})
+})
@@ -0,0 +1,223 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"strings"
+
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+
+	yamlutil "github.com/sageailabs/fouskoti/pkg/yaml"
+)
+
+// imagePolicyMarker is the key of the JSON object in the line comments marking
+// the fields Flux image automation updates, e.g.,
+// `# {"$imagepolicy": "flux-system:podinfo:tag"}`.
+const imagePolicyMarker = "$imagepolicy"
+
+// ImagePolicies maps Flux ImagePolicy objects, as <namespace>:<name>, to the
+// latest images they select, as <name>:<tag> or <name>:<tag>@<digest>.
+type ImagePolicies map[string]string
+
+// ReadImagePolicies reads the latest images of image policies from a YAML map.
+func ReadImagePolicies(input io.Reader) (ImagePolicies, error) {
+	bytes, err := io.ReadAll(input)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read input: %w", err)
+	}
+
+	policies := ImagePolicies{}
+	if err := yaml.Unmarshal(bytes, &policies); err != nil {
+		return nil, fmt.Errorf("unable to parse image policies YAML: %w", err)
+	}
+	return policies, nil
+}
+
+// getImagePolicyImage returns the latest image recorded in the status of the
+// ImagePolicy object, or an empty string if there is none.  Newer APIs record
+// it in latestRef, older ones in latestImage.
+func getImagePolicyImage(node *yaml.RNode) string {
+	name, _ := node.GetString("status.latestRef.name")
+	tag, _ := node.GetString("status.latestRef.tag")
+	if name != "" && tag != "" {
+		image := name + ":" + tag
+		if digest, _ := node.GetString("status.latestRef.digest"); digest != "" {
+			image += "@" + digest
+		}
+		return image
+	}
+	image, _ := node.GetString("status.latestImage")
+	return image
+}
+
+// getImagePolicies returns the latest images of the ImagePolicy objects in
+// the nodes with the ones in the options taking precedence.
+func getImagePolicies(nodes []*yaml.RNode, overrides ImagePolicies) ImagePolicies {
+	result := ImagePolicies{}
+	for _, node := range nodes {
+		if yamlutil.GetGroup(node) != "image.toolkit.fluxcd.io" ||
+			node.GetKind() != "ImagePolicy" {
+			continue
+		}
+		if image := getImagePolicyImage(node); image != "" {
+			result[node.GetNamespace()+":"+node.GetName()] = image
+		}
+	}
+	maps.Copy(result, overrides)
+	return result
+}
+
+// getImageField returns the field of the image Flux image automation sets for
+// the marker: the whole image, or its name, tag, or digest.
+func getImageField(image string, field string) (string, error) {
+	name, digest, _ := strings.Cut(image, "@")
+	tag := ""
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, tag = name[:i], name[i+1:]
+	}
+	switch field {
+	case "":
+		return image, nil
+	case "name":
+		return name, nil
+	case "tag":
+		return tag, nil
+	case "digest":
+		return digest, nil
+	default:
+		return "", fmt.Errorf("unknown image policy field %s", field)
+	}
+}
+
+// parseImagePolicyMarker returns the policy, as <namespace>:<name>, and the
+// field of the image marker in the line comment, or an empty policy if the
+// comment is no marker.
+func parseImagePolicyMarker(comment string) (string, string, error) {
+	text := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(comment), "#"))
+	if !strings.HasPrefix(text, "{") {
+		return "", "", nil
+	}
+	var marker map[string]string
+	if err := json.Unmarshal([]byte(text), &marker); err != nil {
+		return "", "", nil
+	}
+	value, found := marker[imagePolicyMarker]
+	if !found {
+		return "", "", nil
+	}
+	parts := strings.Split(value, ":")
+	switch len(parts) {
+	case 2:
+		return value, "", nil
+	case 3:
+		return parts[0] + ":" + parts[1], parts[2], nil
+	default:
+		return "", "", fmt.Errorf(
+			"invalid image policy marker %s, expected <namespace>:<name>[:<field>]",
+			value,
+		)
+	}
+}
+
+// resolveImagePolicies sets the values of the HelmRelease objects in the
+// nodes marked with image policy markers to the latest images of the
+// policies, the way Flux image automation would have written them.  Markers of
+// unknown policies are left alone with a warning.
+func (renderer *releaseRepoRenderer) resolveImagePolicies(nodes []*yaml.RNode) error {
+	policies := getImagePolicies(nodes, renderer.options.ImagePolicies)
+	for _, node := range nodes {
+		if yamlutil.GetGroup(node) != "helm.toolkit.fluxcd.io" ||
+			node.GetKind() != "HelmRelease" {
+			continue
+		}
+		values := node.Field("spec")
+		if values != nil {
+			values = values.Value.Field("values")
+		}
+		if values == nil {
+			continue
+		}
+		err := renderer.resolveImagePolicyMarkers(values.Value.YNode(), policies, node)
+		if err != nil {
+			return NewClassifiedError(ErrorClassInput, fmt.Errorf(
+				"%sunable to resolve image policies of Helm release %s/%s: %w",
+				renderer.getPositionPrefix(node),
+				node.GetNamespace(),
+				node.GetName(),
+				err,
+			))
+		}
+	}
+	return nil
+}
+
+// resolveImagePolicyMarkers sets the scalars in the node marked with image
+// policy markers in their line comments.  The line comments of map entries
+// with scalar values can be attached to either the keys or the values.
+func (renderer *releaseRepoRenderer) resolveImagePolicyMarkers(
+	node *yaml.Node,
+	policies ImagePolicies,
+	release *yaml.RNode,
+) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return renderer.resolveImagePolicyMarker(node, node.LineComment, policies, release)
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if value.Kind == yaml.ScalarNode {
+				comment := cmp.Or(value.LineComment, key.LineComment)
+				err := renderer.resolveImagePolicyMarker(value, comment, policies, release)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			err := renderer.resolveImagePolicyMarkers(value, policies, release)
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		for _, child := range node.Content {
+			err := renderer.resolveImagePolicyMarkers(child, policies, release)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (renderer *releaseRepoRenderer) resolveImagePolicyMarker(
+	node *yaml.Node,
+	comment string,
+	policies ImagePolicies,
+	release *yaml.RNode,
+) error {
+	policy, field, err := parseImagePolicyMarker(comment)
+	if err != nil || policy == "" {
+		return err
+	}
+	image, found := policies[policy]
+	if !found {
+		renderer.config.logger.
+			With("namespace", release.GetNamespace()).
+			With("name", release.GetName()).
+			With("policy", policy).
+			Warn("Leaving the value marked with an unknown image policy as it is")
+		return nil
+	}
+	value, err := getImageField(image, field)
+	if err != nil {
+		return err
+	}
+	node.Value = value
+	node.Tag = "!!str"
+	return nil
+}
@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Image policies", func() {
+	var g gomega.Gomega
+	var expander *HelmReleaseExpander
+
+	policies := strings.Join([]string{
+		"apiVersion: image.toolkit.fluxcd.io/v1",
+		"kind: ImagePolicy",
+		"metadata:",
+		"  namespace: flux-system",
+		"  name: app",
+		"status:",
+		"  latestRef:",
+		"    name: ghcr.io/example/app",
+		"    tag: 1.2.0",
+		"---",
+		"apiVersion: image.toolkit.fluxcd.io/v1beta2",
+		"kind: ImagePolicy",
+		"metadata:",
+		"  namespace: flux-system",
+		"  name: proxy",
+		"status:",
+		"  latestImage: registry.example.com:5000/proxy:2.0",
+		"---",
+	}, "\n")
+	release := strings.Join([]string{
+		"apiVersion: helm.toolkit.fluxcd.io/v2",
+		"kind: HelmRelease",
+		"metadata:",
+		"  namespace: testns",
+		"  name: test",
+		"spec:",
+		"  chart:",
+		"    spec:",
+		"      chart: test-chart",
+		"      sourceRef:",
+		"        kind: HelmRepository",
+		"        name: missing",
+		"  values:",
+		"    app:",
+		"      image: ghcr.io/example/app:1.0.0 # {\"$imagepolicy\": \"flux-system:app\"}",
+		"    proxy:",
+		"      repository: proxy # {\"$imagepolicy\": \"flux-system:proxy:name\"}",
+		"      tag: \"1.0\" # {\"$imagepolicy\": \"flux-system:proxy:tag\"}",
+		"    other:",
+		"      tag: \"1.0\" # {\"$imagepolicy\": \"flux-system:other:tag\"}",
+		"",
+	}, "\n")
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		logger := slog.New(slog.NewTextHandler(ginkgo.GinkgoWriter, nil))
+		expander = NewHelmReleaseExpander(context.Background(), logger, nil, nil)
+	})
+
+	expand := func(options ExpandOptions) (string, error) {
+		skipList, err := NewReleaseSkipList([]string{"testns/test"})
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		options.SkipList = skipList
+		var output bytes.Buffer
+		err = expander.Expand(bytes.NewBufferString(policies+"\n"+release), &output, options)
+		return output.String(), err
+	}
+
+	ginkgo.It("sets the marked values to the latest images", func() {
+		output, err := expand(ExpandOptions{ResolveImagePolicies: true})
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(output).To(gomega.ContainSubstring(
+			"image: ghcr.io/example/app:1.2.0 # {\"$imagepolicy\": \"flux-system:app\"}\n",
+		))
+		g.Expect(output).To(gomega.ContainSubstring(
+			"repository: registry.example.com:5000/proxy # {\"$imagepolicy\": \"flux-system:proxy:name\"}\n",
+		))
+		g.Expect(output).To(gomega.ContainSubstring(
+			"tag: \"2.0\" # {\"$imagepolicy\": \"flux-system:proxy:tag\"}\n",
+		))
+		g.Expect(output).To(gomega.ContainSubstring(
+			"tag: \"1.0\" # {\"$imagepolicy\": \"flux-system:other:tag\"}\n",
+		))
+	})
+
+	ginkgo.It("prefers the given images to the statuses", func() {
+		output, err := expand(ExpandOptions{
+			ResolveImagePolicies: true,
+			ImagePolicies: ImagePolicies{
+				"flux-system:app": "ghcr.io/example/app:1.3.0@sha256:abc",
+			},
+		})
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(output).To(gomega.ContainSubstring(
+			"image: ghcr.io/example/app:1.3.0@sha256:abc # {\"$imagepolicy\": \"flux-system:app\"}\n",
+		))
+	})
+
+	ginkgo.It("leaves the values alone by default", func() {
+		output, err := expand(ExpandOptions{})
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(output).To(gomega.ContainSubstring("image: ghcr.io/example/app:1.0.0 #"))
+	})
+
+	ginkgo.It("fails on invalid markers", func() {
+		err := expander.Expand(
+			bytes.NewBufferString(strings.Replace(release, "flux-system:app", "app", 1)),
+			&bytes.Buffer{},
+			ExpandOptions{ResolveImagePolicies: true},
+		)
+		g.Expect(err).To(gomega.HaveOccurred())
+		g.Expect(GetErrorClass(err)).To(gomega.Equal(ErrorClassInput))
+	})
+})
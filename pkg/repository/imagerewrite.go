@@ -0,0 +1,49 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"strings"
+
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// ImageRewrites maps prefixes of image references to the prefixes replacing
+// them, e.g., `docker.io` to `registry.example.com/dockerhub` for clusters
+// which pull all images from a mirror.
+type ImageRewrites map[string]string
+
+// rewriteImage returns the image with the longest matching prefix replaced.
+// A prefix matches the whole image or a part of it ending before a `/`, `:`,
+// or `@`, unless the prefix itself ends with a `/`, so that `ghcr.io/org`
+// does not match `ghcr.io/organization/app`.
+func (rewrites ImageRewrites) rewriteImage(image string) string {
+	match := ""
+	for prefix := range rewrites {
+		rest, found := strings.CutPrefix(image, prefix)
+		if !found || len(prefix) <= len(match) {
+			continue
+		}
+		if rest == "" || strings.HasSuffix(prefix, "/") ||
+			strings.ContainsRune("/:@", rune(rest[0])) {
+			match = prefix
+		}
+	}
+	if match == "" {
+		return image
+	}
+	return rewrites[match] + strings.TrimPrefix(image, match)
+}
+
+// rewriteImages rewrites the images of the containers in the pod specs of the
+// nodes.
+func rewriteImages(nodes []*yaml.RNode, rewrites ImageRewrites) {
+	if len(rewrites) == 0 {
+		return
+	}
+	for _, node := range nodes {
+		visitContainerImages(node.YNode(), func(image *yaml.Node) {
+			image.Value = rewrites.rewriteImage(image.Value)
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+var _ = ginkgo.Describe("Image rewriting", func() {
+	var g gomega.Gomega
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+	})
+
+	rewrites := ImageRewrites{
+		"docker.io":         "mirror.example.com/dockerhub",
+		"docker.io/library": "mirror.example.com/library",
+		"ghcr.io/org":       "mirror.example.com/org",
+		"quay.io/":          "mirror.example.com/quay/",
+	}
+
+	ginkgo.It("replaces the longest matching prefix", func() {
+		for image, expected := range map[string]string{
+			"docker.io/library/nginx:1.25": "mirror.example.com/library/nginx:1.25",
+			"docker.io/bitnami/redis":      "mirror.example.com/dockerhub/bitnami/redis",
+			"ghcr.io/org:1.0":              "mirror.example.com/org:1.0",
+			"ghcr.io/org/app@sha256:abc":   "mirror.example.com/org/app@sha256:abc",
+			"ghcr.io/organization/app":     "ghcr.io/organization/app",
+			"quay.io/prometheus/node":      "mirror.example.com/quay/prometheus/node",
+			"nginx:1.25":                   "nginx:1.25",
+		} {
+			g.Expect(rewrites.rewriteImage(image)).To(gomega.Equal(expected), image)
+		}
+	})
+
+	ginkgo.It("rewrites the images of containers", func() {
+		node := yaml.MustParse(strings.Join([]string{
+			"apiVersion: batch/v1",
+			"kind: CronJob",
+			"metadata:",
+			"  name: job",
+			"  annotations:",
+			"    image: docker.io/library/busybox",
+			"spec:",
+			"  jobTemplate:",
+			"    spec:",
+			"      template:",
+			"        spec:",
+			"          initContainers:",
+			"          - name: init",
+			"            image: docker.io/library/busybox",
+			"          containers:",
+			"          - name: job",
+			"            image: ghcr.io/org/job:1.0",
+		}, "\n"))
+		rewriteImages([]*yaml.RNode{node}, rewrites)
+		g.Expect(getContainerImages(node)).To(gomega.Equal([]string{
+			"mirror.example.com/library/busybox",
+			"mirror.example.com/org/job:1.0",
+		}))
+		g.Expect(node.GetAnnotations()).To(
+			gomega.HaveKeyWithValue("image", "docker.io/library/busybox"),
+		)
+	})
+})
@@ -0,0 +1,187 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// containerListFields are the fields of pod specs with lists of containers.
+var containerListFields = []string{"containers", "initContainers", "ephemeralContainers"}
+
+// visitContainerImages calls the visitor with the image scalars of the
+// containers in the pod specs anywhere in the node, in the order they appear
+// in.
+func visitContainerImages(node *yaml.Node, visitor func(image *yaml.Node)) {
+	if node.Kind != yaml.MappingNode {
+		for _, child := range node.Content {
+			visitContainerImages(child, visitor)
+		}
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if slices.Contains(containerListFields, key.Value) &&
+			value.Kind == yaml.SequenceNode {
+			for _, container := range value.Content {
+				image := yaml.NewRNode(container).Field("image")
+				if image != nil && image.Value.YNode().Kind == yaml.ScalarNode &&
+					image.Value.YNode().Value != "" {
+					visitor(image.Value.YNode())
+				}
+			}
+		}
+		visitContainerImages(value, visitor)
+	}
+}
+
+// getContainerImages returns the images of the containers in the pod specs
+// anywhere in the object, which covers the workloads of all kinds, including
+// custom resources embedding pod templates, in the order they appear in.
+func getContainerImages(node *yaml.RNode) []string {
+	images := []string{}
+	visitContainerImages(node.YNode(), func(image *yaml.Node) {
+		images = append(images, image.Value)
+	})
+	return images
+}
+
+// ImagePath locates images in objects outside of the containers of pod specs,
+// e.g., the images custom resources set in their own fields.
+type ImagePath struct {
+	// Kind of the objects, or an empty string for objects of all kinds.
+	Kind string
+	// Fields leading to the images.  Fields with a `[*]` suffix hold lists
+	// whose items are all looked into.
+	Fields []string
+}
+
+// ParseImagePath parses an image path given as [<kind>=]<path>, where the
+// path is a JSONPath-like list of fields separated by dots, e.g.,
+// `Prometheus=.spec.image` or `.spec.workers[*].template`.
+func ParseImagePath(value string) (*ImagePath, error) {
+	kind, path, found := strings.Cut(value, "=")
+	if !found {
+		kind, path = "", value
+	}
+	path = strings.TrimPrefix(path, ".")
+	if path == "" {
+		return nil, fmt.Errorf("invalid image path %s, expected [<kind>=]<path>", value)
+	}
+	fields := strings.Split(path, ".")
+	for _, field := range fields {
+		name := strings.TrimSuffix(field, "[*]")
+		if name == "" || strings.ContainsAny(name, "[]*") {
+			return nil, fmt.Errorf("invalid field %q in image path %s", field, value)
+		}
+	}
+	return &ImagePath{Kind: kind, Fields: fields}, nil
+}
+
+// getImages returns the images at the path in the node.  Paths leading to
+// pod templates or pod specs yield the images of their containers.
+func (path *ImagePath) getImages(node *yaml.RNode) []string {
+	if path.Kind != "" && path.Kind != node.GetKind() {
+		return nil
+	}
+	images := []string{}
+	var walk func(node *yaml.Node, fields []string)
+	walk = func(node *yaml.Node, fields []string) {
+		if len(fields) == 0 {
+			switch node.Kind {
+			case yaml.ScalarNode:
+				if node.Value != "" {
+					images = append(images, node.Value)
+				}
+			case yaml.MappingNode:
+				images = append(images, getContainerImages(yaml.NewRNode(node))...)
+			}
+			return
+		}
+		name, all := strings.CutSuffix(fields[0], "[*]")
+		field := yaml.NewRNode(node).Field(name)
+		if field == nil {
+			return
+		}
+		value := field.Value.YNode()
+		if !all {
+			walk(value, fields[1:])
+			return
+		}
+		if value.Kind == yaml.SequenceNode {
+			for _, item := range value.Content {
+				walk(item, fields[1:])
+			}
+		}
+	}
+	if node.YNode().Kind == yaml.MappingNode {
+		walk(node.YNode(), path.Fields)
+	}
+	return images
+}
+
+// getResourceImages returns the images of the containers in the object
+// together with the images at the paths.
+func getResourceImages(node *yaml.RNode, paths []*ImagePath) []string {
+	images := getContainerImages(node)
+	for _, path := range paths {
+		images = append(images, path.getImages(node)...)
+	}
+	return images
+}
+
+// ReleaseImage is a container image referenced by the resources rendered from
+// HelmRelease objects.
+type ReleaseImage struct {
+	Image string `json:"image"`
+	// Releases whose resources reference the image, as <namespace>/<name>.
+	Releases []string `json:"releases"`
+}
+
+// ListImages expands the HelmRelease objects in the input and returns the
+// container images their resources reference, in the containers of pod specs
+// or at the paths, with the releases referencing each.
+func (expander *HelmReleaseExpander) ListImages(
+	input io.Reader,
+	options ExpandOptions,
+	paths []*ImagePath,
+) ([]ReleaseImage, error) {
+	images := map[string]map[string]bool{}
+	var mutex sync.Mutex
+	onReleaseExpanded := options.OnReleaseExpanded
+	options.OnReleaseExpanded = func(release *ExpandedRelease) {
+		name := release.Namespace + "/" + release.Name
+		mutex.Lock()
+		for _, node := range release.Resources {
+			for _, image := range getResourceImages(node, paths) {
+				if images[image] == nil {
+					images[image] = map[string]bool{}
+				}
+				images[image][name] = true
+			}
+		}
+		mutex.Unlock()
+		if onReleaseExpanded != nil {
+			onReleaseExpanded(release)
+		}
+	}
+	if err := expander.Expand(input, io.Discard, options); err != nil {
+		return nil, err
+	}
+
+	result := []ReleaseImage{}
+	for _, image := range slices.Sorted(maps.Keys(images)) {
+		result = append(result, ReleaseImage{
+			Image:    image,
+			Releases: slices.Sorted(maps.Keys(images[image])),
+		})
+	}
+	return result, nil
+}
@@ -0,0 +1,177 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+var _ = ginkgo.Describe("Container images", func() {
+	var g gomega.Gomega
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+	})
+
+	getPaths := func(values ...string) []*ImagePath {
+		paths := []*ImagePath{}
+		for _, value := range values {
+			path, err := ParseImagePath(value)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+			paths = append(paths, path)
+		}
+		return paths
+	}
+
+	ginkgo.It("parses image paths", func() {
+		g.Expect(getPaths("Prometheus=.spec.image", "spec.workers[*].template")).
+			To(gomega.Equal([]*ImagePath{
+				{Kind: "Prometheus", Fields: []string{"spec", "image"}},
+				{Fields: []string{"spec", "workers[*]", "template"}},
+			}))
+		for _, value := range []string{"", "Kind=", ".spec..image", "spec.items[0]", "spec.*"} {
+			_, err := ParseImagePath(value)
+			g.Expect(err).To(gomega.HaveOccurred(), value)
+		}
+	})
+
+	ginkgo.It("finds the images at the paths", func() {
+		node := yaml.MustParse(strings.Join([]string{
+			"apiVersion: example.com/v1",
+			"kind: Runner",
+			"spec:",
+			"  image: example/runner:1.0",
+			"  workers:",
+			"  - template:",
+			"      spec:",
+			"        containers:",
+			"        - name: worker",
+			"          image: example/worker:1.0",
+			"  - template:",
+			"      spec:",
+			"        containers:",
+			"        - name: worker",
+			"          image: example/worker:2.0",
+			"  sidecar:",
+			"    containers:",
+			"    - name: proxy",
+			"      image: example/proxy:1.0",
+		}, "\n"))
+		images := getResourceImages(node, getPaths(
+			"Runner=.spec.image",
+			"Other=.spec.image",
+			".spec.workers[*].template",
+			".spec.missing",
+		))
+		g.Expect(images).To(gomega.Equal([]string{
+			"example/worker:1.0",
+			"example/worker:2.0",
+			"example/proxy:1.0",
+			"example/runner:1.0",
+			"example/worker:1.0",
+			"example/worker:2.0",
+		}))
+	})
+
+	ginkgo.It("lists the images of the releases", func() {
+		logger := slog.New(slog.NewTextHandler(ginkgo.GinkgoWriter, nil))
+		repoRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(repoRoot)
+		server, port, serverDone, err := serveDirectory(repoRoot, logger, nil)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer func() {
+			g.Expect(stopServing(server, serverDone)).To(gomega.Succeed())
+		}()
+
+		err = createSingleChartHelmRepository(
+			"test-chart",
+			"0.1.0",
+			map[string]string{
+				"Chart.yaml": strings.Join([]string{
+					"apiVersion: v2",
+					"name: test-chart",
+					"version: 0.1.0",
+				}, "\n"),
+				"templates/cronjob.yaml": strings.Join([]string{
+					"apiVersion: batch/v1",
+					"kind: CronJob",
+					"metadata:",
+					"  name: {{ .Release.Name }}-job",
+					"spec:",
+					"  schedule: '@daily'",
+					"  jobTemplate:",
+					"    spec:",
+					"      template:",
+					"        spec:",
+					"          containers:",
+					"          - name: job",
+					"            image: example/job:{{ .Values.tag }}",
+				}, "\n"),
+				"templates/database.yaml": strings.Join([]string{
+					"apiVersion: example.com/v1",
+					"kind: Database",
+					"metadata:",
+					"  name: {{ .Release.Name }}-db",
+					"spec:",
+					"  image: example/db:1.0",
+				}, "\n"),
+			},
+			port,
+			repoRoot,
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		getRelease := func(name string, tag string) string {
+			return strings.Join([]string{
+				"apiVersion: helm.toolkit.fluxcd.io/v2",
+				"kind: HelmRelease",
+				"metadata:",
+				"  namespace: testns",
+				"  name: " + name,
+				"spec:",
+				"  chart:",
+				"    spec:",
+				"      chart: test-chart",
+				"      sourceRef:",
+				"        kind: HelmRepository",
+				"        name: local",
+				"  values:",
+				"    tag: " + tag,
+			}, "\n")
+		}
+		input := strings.Join([]string{
+			strings.Join([]string{
+				"apiVersion: source.toolkit.fluxcd.io/v1",
+				"kind: HelmRepository",
+				"metadata:",
+				"  namespace: testns",
+				"  name: local",
+				"spec:",
+				fmt.Sprintf("  url: http://localhost:%d", port),
+			}, "\n"),
+			getRelease("first", "v1"),
+			getRelease("second", "v2"),
+		}, "\n---\n")
+
+		expander := NewHelmReleaseExpander(context.Background(), logger, nil, nil)
+		images, err := expander.ListImages(
+			bytes.NewBufferString(input),
+			ExpandOptions{},
+			getPaths("Database=.spec.image"),
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(images).To(gomega.Equal([]ReleaseImage{
+			{Image: "example/db:1.0", Releases: []string{"testns/first", "testns/second"}},
+			{Image: "example/job:v1", Releases: []string{"testns/first"}},
+			{Image: "example/job:v2", Releases: []string{"testns/second"}},
+		}))
+	})
+})
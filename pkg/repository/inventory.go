@@ -0,0 +1,121 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+
+	yamlutil "github.com/sageailabs/fouskoti/pkg/yaml"
+)
+
+// InventoryEntry records a resource rendered from a HelmRelease.
+type InventoryEntry struct {
+	Group     string `yaml:"group"`
+	Kind      string `yaml:"kind"`
+	Namespace string `yaml:"namespace"`
+	Name      string `yaml:"name"`
+	// Release is the HelmRelease the resource was rendered from, as
+	// <namespace>/<name>.
+	Release string `yaml:"release"`
+	// Digest of the content of the resource in the normalized form, which
+	// ignores comments, key order, and formatting.
+	Digest string `yaml:"digest"`
+}
+
+// Inventory lists the resources rendered by an expansion, for drift detection
+// and auditing.
+type Inventory struct {
+	// Digest of the whole render, computed from the entries.
+	Digest    string           `yaml:"digest"`
+	Resources []InventoryEntry `yaml:"resources"`
+
+	mutex sync.Mutex
+}
+
+// getResourceDigest returns the digest of the resource in the normalized form.
+func getResourceDigest(node *kyaml.RNode) (string, error) {
+	normalized := node.Copy()
+	normalizeNode(normalized.Document(), "")
+	text, err := serializeNode(normalized)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(text))), nil
+}
+
+// add records the resources rendered from the release.
+func (inventory *Inventory) add(release *ExpandedRelease, resources []*kyaml.RNode) error {
+	entries := make([]InventoryEntry, 0, len(resources))
+	for _, node := range resources {
+		digest, err := getResourceDigest(node)
+		if err != nil {
+			return fmt.Errorf(
+				"unable to compute digest of %s %s/%s: %w",
+				node.GetKind(),
+				node.GetNamespace(),
+				node.GetName(),
+				err,
+			)
+		}
+		entries = append(entries, InventoryEntry{
+			Group:     yamlutil.GetGroup(node),
+			Kind:      node.GetKind(),
+			Namespace: node.GetNamespace(),
+			Name:      node.GetName(),
+			Release:   release.Namespace + "/" + release.Name,
+			Digest:    digest,
+		})
+	}
+
+	inventory.mutex.Lock()
+	defer inventory.mutex.Unlock()
+	inventory.Resources = append(inventory.Resources, entries...)
+	return nil
+}
+
+// Write writes the inventory as YAML, with the entries sorted and the digest
+// of the render computed from them.
+func (inventory *Inventory) Write(output io.Writer) error {
+	inventory.mutex.Lock()
+	defer inventory.mutex.Unlock()
+
+	slices.SortFunc(inventory.Resources, func(a, b InventoryEntry) int {
+		return cmp.Or(
+			cmp.Compare(a.Release, b.Release),
+			cmp.Compare(a.Group, b.Group),
+			cmp.Compare(a.Kind, b.Kind),
+			cmp.Compare(a.Namespace, b.Namespace),
+			cmp.Compare(a.Name, b.Name),
+			cmp.Compare(a.Digest, b.Digest),
+		)
+	})
+	hash := sha256.New()
+	for _, entry := range inventory.Resources {
+		fmt.Fprintf(
+			hash,
+			"%s %s %s %s %s %s\n",
+			entry.Release,
+			entry.Group,
+			entry.Kind,
+			entry.Namespace,
+			entry.Name,
+			entry.Digest,
+		)
+	}
+	inventory.Digest = fmt.Sprintf("sha256:%x", hash.Sum(nil))
+
+	encoder := yaml.NewEncoder(output)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(inventory); err != nil {
+		return fmt.Errorf("unable to encode inventory YAML: %w", err)
+	}
+	return encoder.Close()
+}
@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"bytes"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"gopkg.in/yaml.v3"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+var _ = ginkgo.Describe("Inventory", func() {
+	var g gomega.Gomega
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+	})
+
+	getRelease := func(resources ...string) *ExpandedRelease {
+		release := &ExpandedRelease{Namespace: "testns", Name: "test"}
+		for _, resource := range resources {
+			release.Resources = append(release.Resources, kyaml.MustParse(resource))
+		}
+		return release
+	}
+
+	write := func(releases ...*ExpandedRelease) *Inventory {
+		inventory := &Inventory{}
+		for _, release := range releases {
+			g.Expect(inventory.add(release, release.Resources)).To(gomega.Succeed())
+		}
+		var output bytes.Buffer
+		g.Expect(inventory.Write(&output)).To(gomega.Succeed())
+		result := &Inventory{}
+		g.Expect(yaml.Unmarshal(output.Bytes(), result)).To(gomega.Succeed())
+		return result
+	}
+
+	deployment := "apiVersion: apps/v1\nkind: Deployment\nmetadata: {namespace: testns, name: app}\n"
+	configMap := "apiVersion: v1\nkind: ConfigMap\nmetadata: {namespace: testns, name: settings}\n" +
+		"data: {a: '1', b: '2'}\n"
+
+	ginkgo.It("lists the rendered resources with their releases", func() {
+		inventory := write(getRelease(deployment, configMap))
+		g.Expect(inventory.Digest).To(gomega.HavePrefix("sha256:"))
+		g.Expect(inventory.Resources).To(gomega.HaveLen(2))
+		g.Expect(inventory.Resources[0].Group).To(gomega.Equal(""))
+		g.Expect(inventory.Resources[0].Kind).To(gomega.Equal("ConfigMap"))
+		g.Expect(inventory.Resources[0].Namespace).To(gomega.Equal("testns"))
+		g.Expect(inventory.Resources[0].Name).To(gomega.Equal("settings"))
+		g.Expect(inventory.Resources[0].Release).To(gomega.Equal("testns/test"))
+		g.Expect(inventory.Resources[0].Digest).To(gomega.HavePrefix("sha256:"))
+		g.Expect(inventory.Resources[1].Group).To(gomega.Equal("apps"))
+		g.Expect(inventory.Resources[1].Kind).To(gomega.Equal("Deployment"))
+	})
+
+	ginkgo.It("ignores formatting and resource order in the digests", func() {
+		first := write(getRelease(deployment, configMap))
+		second := write(getRelease(
+			"# Settings\nkind: ConfigMap\napiVersion: v1\n"+
+				"metadata:\n  name: settings\n  namespace: testns\ndata:\n  b: \"2\"\n  a: \"1\"\n",
+			deployment,
+		))
+		g.Expect(second).To(gomega.Equal(first))
+	})
+
+	ginkgo.It("changes the digests when the content changes", func() {
+		first := write(getRelease(deployment, configMap))
+		second := write(getRelease(
+			deployment,
+			"apiVersion: v1\nkind: ConfigMap\nmetadata: {namespace: testns, name: settings}\n"+
+				"data: {a: '1', b: '3'}\n",
+		))
+		g.Expect(second.Digest).ToNot(gomega.Equal(first.Digest))
+		g.Expect(second.Resources[0].Digest).ToNot(gomega.Equal(first.Resources[0].Digest))
+		g.Expect(second.Resources[1].Digest).To(gomega.Equal(first.Resources[1].Digest))
+	})
+})
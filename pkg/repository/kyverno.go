@@ -0,0 +1,697 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+
+	yamlutil "github.com/sageailabs/fouskoti/pkg/yaml"
+)
+
+type kyvernoResourceFilter struct {
+	Kinds             []string              `json:"kinds"`
+	Name              string                `json:"name"`
+	Names             []string              `json:"names"`
+	Namespaces        []string              `json:"namespaces"`
+	Selector          *metav1.LabelSelector `json:"selector"`
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector"`
+	Annotations       map[string]string     `json:"annotations"`
+	Operations        any                   `json:"operations"`
+}
+
+// kyvernoUserInfo filters admission requests by the user making them, which
+// rendered resources don't have.
+type kyvernoUserInfo struct {
+	Subjects     any `json:"subjects"`
+	Roles        any `json:"roles"`
+	ClusterRoles any `json:"clusterRoles"`
+}
+
+type kyvernoFilter struct {
+	kyvernoUserInfo
+	Resources kyvernoResourceFilter `json:"resources"`
+}
+
+type kyvernoMatch struct {
+	Any []kyvernoFilter `json:"any"`
+	All []kyvernoFilter `json:"all"`
+	// Resources and the user info are the legacy form of a single filter.
+	kyvernoUserInfo
+	Resources *kyvernoResourceFilter `json:"resources"`
+}
+
+type kyvernoValidation struct {
+	Message     string `json:"message"`
+	Pattern     any    `json:"pattern"`
+	AnyPattern  []any  `json:"anyPattern"`
+	Deny        any    `json:"deny"`
+	Foreach     any    `json:"foreach"`
+	CEL         any    `json:"cel"`
+	PodSecurity any    `json:"podSecurity"`
+	Manifests   any    `json:"manifests"`
+}
+
+type kyvernoRule struct {
+	Name             string             `json:"name"`
+	Match            kyvernoMatch       `json:"match"`
+	Exclude          *kyvernoMatch      `json:"exclude"`
+	Context          any                `json:"context"`
+	Preconditions    any                `json:"preconditions"`
+	CELPreconditions any                `json:"celPreconditions"`
+	Validate         *kyvernoValidation `json:"validate"`
+}
+
+type kyvernoPolicyObject struct {
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+	Spec struct {
+		Rules []kyvernoRule `json:"rules"`
+	} `json:"spec"`
+}
+
+// KyvernoPolicy is a Kyverno ClusterPolicy or Policy, evaluated natively
+// rather than by Kyverno.  Only a subset of the policies is supported:
+// validation rules with pattern or anyPattern, matching resources by kinds,
+// names, namespaces, label selectors, and annotations.  Mutation, generation,
+// and image verification rules are ignored.  Policies with rules using
+// anything else, like deny, foreach, cel, or podSecurity validations,
+// variables, context, preconditions, namespace selectors, or user
+// information, are rejected, as are the CEL-based ValidatingPolicy objects.
+// A namespaced Policy applies to the resources in its namespace, with the
+// resources rendered without a namespace taken to be in the namespace of
+// their HelmRelease.
+type KyvernoPolicy struct {
+	Kind      string
+	Namespace string
+	Name      string
+
+	rules []kyvernoRule
+}
+
+func newKyvernoPolicy(node *kyaml.RNode) (*KyvernoPolicy, error) {
+	bytes, err := node.MarshalJSON()
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode node to JSON: %w", err)
+	}
+	object := kyvernoPolicyObject{}
+	if err := json.Unmarshal(bytes, &object); err != nil {
+		return nil, fmt.Errorf("unable to parse Kyverno policy: %w", err)
+	}
+
+	policy := &KyvernoPolicy{
+		Kind:      node.GetKind(),
+		Name:      object.Metadata.Name,
+		Namespace: object.Metadata.Namespace,
+	}
+	for _, rule := range object.Spec.Rules {
+		if rule.Validate == nil {
+			continue
+		}
+		if feature := rule.getUnsupportedFeature(); feature != "" {
+			return nil, fmt.Errorf(
+				"rule %s of Kyverno policy %s is not supported: it uses %s",
+				rule.Name,
+				policy.Name,
+				feature,
+			)
+		}
+		policy.rules = append(policy.rules, rule)
+	}
+	return policy, nil
+}
+
+// getUnsupportedFeature returns the feature the validation rule uses outside
+// of the subset supported by KyvernoPolicy, or an empty string.
+func (rule *kyvernoRule) getUnsupportedFeature() string {
+	validation := rule.Validate
+	switch {
+	case validation.CEL != nil:
+		return "a cel validation"
+	case validation.Deny != nil:
+		return "a deny validation"
+	case validation.Foreach != nil:
+		return "a foreach validation"
+	case validation.PodSecurity != nil:
+		return "a podSecurity validation"
+	case validation.Manifests != nil:
+		return "a manifests validation"
+	case validation.Pattern == nil && len(validation.AnyPattern) == 0:
+		return "a validation without pattern or anyPattern"
+	case rule.Context != nil:
+		return "context"
+	case rule.Preconditions != nil, rule.CELPreconditions != nil:
+		return "preconditions"
+	case hasKyvernoVariables(validation.Pattern) ||
+		hasKyvernoVariables(validation.AnyPattern) ||
+		hasKyvernoVariables(validation.Message):
+		return "variables"
+	}
+	for _, match := range []*kyvernoMatch{&rule.Match, rule.Exclude} {
+		if match == nil {
+			continue
+		}
+		if feature := match.getUnsupportedFeature(); feature != "" {
+			return feature
+		}
+	}
+	return ""
+}
+
+// getUnsupportedFeature returns the feature the match uses outside of the
+// subset supported by KyvernoPolicy, or an empty string.
+func (match *kyvernoMatch) getUnsupportedFeature() string {
+	userInfos := []kyvernoUserInfo{match.kyvernoUserInfo}
+	for _, filter := range slices.Concat(match.Any, match.All) {
+		userInfos = append(userInfos, filter.kyvernoUserInfo)
+	}
+	for _, userInfo := range userInfos {
+		if userInfo.Subjects != nil || userInfo.Roles != nil || userInfo.ClusterRoles != nil {
+			return "user information"
+		}
+	}
+	for _, filter := range match.filters() {
+		if filter.NamespaceSelector != nil {
+			return "namespaceSelector"
+		}
+		if filter.Operations != nil {
+			return "operations"
+		}
+	}
+	return ""
+}
+
+// hasKyvernoVariables tells whether the value, e.g., a pattern, has variables
+// like {{request.object.metadata.name}}, which are not substituted.
+func hasKyvernoVariables(value any) bool {
+	bytes, err := json.Marshal(value)
+	return err == nil && strings.Contains(string(bytes), "{{")
+}
+
+// ReadKyvernoPolicies returns the Kyverno ClusterPolicy and Policy objects in
+// the YAML stream, ignoring any other objects.
+func ReadKyvernoPolicies(input io.Reader) ([]*KyvernoPolicy, error) {
+	nodes, err := (&kio.ByteReader{Reader: input}).Read()
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse input: %w", err)
+	}
+
+	policies := []*KyvernoPolicy{}
+	for _, node := range nodes {
+		switch yamlutil.GetGroup(node) {
+		case "kyverno.io":
+			if node.GetKind() != "ClusterPolicy" && node.GetKind() != "Policy" {
+				continue
+			}
+			policy, err := newKyvernoPolicy(node)
+			if err != nil {
+				return nil, err
+			}
+			policies = append(policies, policy)
+		case "policies.kyverno.io":
+			return nil, fmt.Errorf(
+				"unsupported Kyverno %s %s: CEL expressions are not supported, "+
+					"only ClusterPolicy and Policy objects are",
+				node.GetKind(),
+				node.GetName(),
+			)
+		}
+	}
+	return policies, nil
+}
+
+// ReadKyvernoPolicyFiles reads the Kyverno policies from a file or from all
+// YAML files in a directory.
+func ReadKyvernoPolicyFiles(path string) ([]*KyvernoPolicy, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read Kyverno policies %s: %w", path, err)
+	}
+	fileNames := []string{path}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"unable to read Kyverno policy directory %s: %w",
+				path,
+				err,
+			)
+		}
+		fileNames = []string{}
+		for _, entry := range entries {
+			extension := filepath.Ext(entry.Name())
+			if !entry.IsDir() && (extension == ".yaml" || extension == ".yml") {
+				fileNames = append(fileNames, filepath.Join(path, entry.Name()))
+			}
+		}
+	}
+
+	policies := []*KyvernoPolicy{}
+	for _, fileName := range fileNames {
+		file, err := os.Open(fileName)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"unable to open Kyverno policy file %s: %w",
+				fileName,
+				err,
+			)
+		}
+		filePolicies, err := ReadKyvernoPolicies(file)
+		// Failures to close files opened for reading are not interesting.
+		_ = file.Close()
+		if err != nil {
+			return nil, fmt.Errorf(
+				"unable to read Kyverno policy file %s: %w",
+				fileName,
+				err,
+			)
+		}
+		policies = append(policies, filePolicies...)
+	}
+	return policies, nil
+}
+
+func (match *kyvernoMatch) filters() []kyvernoResourceFilter {
+	result := []kyvernoResourceFilter{}
+	for _, filter := range slices.Concat(match.Any, match.All) {
+		result = append(result, filter.Resources)
+	}
+	if match.Resources != nil {
+		result = append(result, *match.Resources)
+	}
+	return result
+}
+
+// matches reports whether the resource, in the given namespace, is selected.
+// An empty match selects nothing.
+func (match *kyvernoMatch) matches(node *kyaml.RNode, namespace string) bool {
+	if len(match.Any) > 0 {
+		return slices.ContainsFunc(match.Any, func(filter kyvernoFilter) bool {
+			return filter.Resources.matches(node, namespace)
+		})
+	}
+	if len(match.All) > 0 {
+		return !slices.ContainsFunc(match.All, func(filter kyvernoFilter) bool {
+			return !filter.Resources.matches(node, namespace)
+		})
+	}
+	return match.Resources != nil && match.Resources.matches(node, namespace)
+}
+
+func (filter *kyvernoResourceFilter) matches(
+	node *kyaml.RNode,
+	namespace string,
+) bool {
+	if len(filter.Kinds) > 0 &&
+		!slices.ContainsFunc(filter.Kinds, func(kind string) bool {
+			return kyvernoKindMatches(kind, node.GetApiVersion(), node.GetKind())
+		}) {
+		return false
+	}
+	names := filter.Names
+	if filter.Name != "" {
+		names = append(slices.Clone(names), filter.Name)
+	}
+	if len(names) > 0 && !matchesAnyWildcard(names, node.GetName()) {
+		return false
+	}
+	if len(filter.Namespaces) > 0 && !matchesAnyWildcard(filter.Namespaces, namespace) {
+		return false
+	}
+	if filter.Selector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(filter.Selector)
+		if err != nil || !selector.Matches(labels.Set(node.GetLabels())) {
+			return false
+		}
+	}
+	annotations := node.GetAnnotations()
+	for key, pattern := range filter.Annotations {
+		value, ok := annotations[key]
+		if !ok || !wildcardMatches(pattern, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// kyvernoKindMatches matches a kind in the Kind, version/Kind, or
+// group/version/Kind forms, each part possibly with wildcards.
+func kyvernoKindMatches(pattern string, apiVersion string, kind string) bool {
+	parts := strings.Split(pattern, "/")
+	if !wildcardMatches(parts[len(parts)-1], kind) {
+		return false
+	}
+	group, version, found := strings.Cut(apiVersion, "/")
+	if !found {
+		group, version = "", apiVersion
+	}
+	switch len(parts) {
+	case 1:
+		return true
+	case 2:
+		return wildcardMatches(parts[0], version)
+	default:
+		return wildcardMatches(parts[len(parts)-3], group) &&
+			wildcardMatches(parts[len(parts)-2], version)
+	}
+}
+
+// wildcardMatches matches the value against a pattern where * matches any
+// sequence of characters and ? matches any single character.
+func wildcardMatches(pattern string, value string) bool {
+	if !strings.ContainsAny(pattern, "*?") {
+		return pattern == value
+	}
+	expression := regexp.QuoteMeta(pattern)
+	expression = strings.ReplaceAll(expression, `\*`, ".*")
+	expression = strings.ReplaceAll(expression, `\?`, ".")
+	return regexp.MustCompile("^" + expression + "$").MatchString(value)
+}
+
+func matchesAnyWildcard(patterns []string, value string) bool {
+	return slices.ContainsFunc(patterns, func(pattern string) bool {
+		return wildcardMatches(pattern, value)
+	})
+}
+
+type patternResult int
+
+const (
+	patternPass patternResult = iota
+	patternFail
+	// patternSkip means a global anchor didn't match, so the rule doesn't
+	// apply to the resource.
+	patternSkip
+)
+
+var anchorRegexp = regexp.MustCompile(`^([=X^<+]?)\((.+)\)$`)
+
+// parseAnchor returns the anchor of a pattern key, or "(" for a conditional
+// anchor, and the key without it.
+func parseAnchor(key string) (string, string) {
+	match := anchorRegexp.FindStringSubmatch(key)
+	if match == nil {
+		return "", key
+	}
+	if match[1] == "" {
+		return "(", match[2]
+	}
+	return match[1], match[2]
+}
+
+// validatePattern validates the value against a Kyverno pattern.  On failure,
+// it also returns the path of the failing value.
+func validatePattern(pattern any, value any, path string) (patternResult, string) {
+	switch pattern := pattern.(type) {
+	case map[string]any:
+		object, ok := value.(map[string]any)
+		if !ok {
+			return patternFail, path
+		}
+		return validateMapPattern(pattern, object, path)
+	case []any:
+		elements, ok := value.([]any)
+		if !ok {
+			return patternFail, path
+		}
+		return validateArrayPattern(pattern, elements, path)
+	case string:
+		if matchesScalarPattern(pattern, value) {
+			return patternPass, ""
+		}
+		return patternFail, path
+	default:
+		if pattern == value {
+			return patternPass, ""
+		}
+		return patternFail, path
+	}
+}
+
+func validateMapPattern(
+	pattern map[string]any,
+	object map[string]any,
+	path string,
+) (patternResult, string) {
+	keys := slices.Sorted(maps.Keys(pattern))
+
+	// Conditions are checked before anything else at the same level.
+	for _, key := range keys {
+		anchor, name := parseAnchor(key)
+		if anchor != "(" && anchor != "^" {
+			continue
+		}
+		value, ok := object[name]
+		result := patternFail
+		if ok {
+			result, _ = validatePattern(pattern[key], value, path+name+"/")
+		}
+		if result == patternSkip || (result == patternFail && anchor == "^") {
+			return patternSkip, ""
+		}
+		if result == patternFail {
+			return patternPass, ""
+		}
+	}
+
+	for _, key := range keys {
+		anchor, name := parseAnchor(key)
+		value, ok := object[name]
+		valuePath := path + name + "/"
+		switch anchor {
+		case "(", "^", "+":
+			continue
+		case "X":
+			if ok {
+				return patternFail, valuePath
+			}
+			continue
+		case "=":
+			if !ok {
+				continue
+			}
+		case "<":
+			elements, isArray := value.([]any)
+			patterns, isPatternArray := pattern[key].([]any)
+			if !isArray || !isPatternArray {
+				return patternFail, valuePath
+			}
+			for _, elementPattern := range patterns {
+				if !slices.ContainsFunc(elements, func(element any) bool {
+					result, _ := validatePattern(elementPattern, element, valuePath)
+					return result == patternPass
+				}) {
+					return patternFail, valuePath
+				}
+			}
+			continue
+		default:
+			if !ok {
+				return patternFail, valuePath
+			}
+		}
+		if result, failedPath := validatePattern(pattern[key], value, valuePath); result != patternPass {
+			return result, failedPath
+		}
+	}
+	return patternPass, ""
+}
+
+// validateArrayPattern validates every element against a single pattern, or
+// the elements one to one against multiple patterns.
+func validateArrayPattern(
+	pattern []any,
+	elements []any,
+	path string,
+) (patternResult, string) {
+	if len(pattern) > 1 && len(pattern) != len(elements) {
+		return patternFail, path
+	}
+	for i, element := range elements {
+		if len(pattern) == 0 {
+			break
+		}
+		elementPattern := pattern[0]
+		if len(pattern) > 1 {
+			elementPattern = pattern[i]
+		}
+		result, failedPath := validatePattern(
+			elementPattern,
+			element,
+			path+strconv.Itoa(i)+"/",
+		)
+		if result != patternPass {
+			return result, failedPath
+		}
+	}
+	return patternPass, ""
+}
+
+// matchesScalarPattern matches a value against a string pattern, which can be
+// a wildcard, alternatives separated by |, conditions joined by &, and
+// conditions with the !, <, <=, >, and >= operators.  The comparisons accept
+// numbers and Kubernetes quantities.
+func matchesScalarPattern(pattern string, value any) bool {
+	if pattern == "*" {
+		return true
+	}
+	if strings.Contains(pattern, "|") {
+		for alternative := range strings.SplitSeq(pattern, "|") {
+			if matchesScalarPattern(strings.TrimSpace(alternative), value) {
+				return true
+			}
+		}
+		return false
+	}
+	if strings.Contains(pattern, "&") {
+		for condition := range strings.SplitSeq(pattern, "&") {
+			if !matchesScalarPattern(strings.TrimSpace(condition), value) {
+				return false
+			}
+		}
+		return true
+	}
+	if value == nil {
+		return pattern == "" || pattern == "null"
+	}
+
+	var text string
+	switch value := value.(type) {
+	case string:
+		text = value
+	case float64:
+		text = strconv.FormatFloat(value, 'f', -1, 64)
+	case bool:
+		text = strconv.FormatBool(value)
+	default:
+		return false
+	}
+
+	for _, operator := range []string{">=", "<=", ">", "<"} {
+		if operand, found := strings.CutPrefix(pattern, operator); found {
+			comparison, ok := compareQuantities(text, strings.TrimSpace(operand))
+			if !ok {
+				return false
+			}
+			switch operator {
+			case ">=":
+				return comparison >= 0
+			case "<=":
+				return comparison <= 0
+			case ">":
+				return comparison > 0
+			default:
+				return comparison < 0
+			}
+		}
+	}
+	if operand, found := strings.CutPrefix(pattern, "!"); found {
+		return !matchesScalarPattern(operand, value)
+	}
+	if wildcardMatches(pattern, text) {
+		return true
+	}
+	comparison, ok := compareQuantities(text, pattern)
+	return ok && comparison == 0
+}
+
+func compareQuantities(a string, b string) (int, bool) {
+	quantityA, err := resource.ParseQuantity(a)
+	if err != nil {
+		return 0, false
+	}
+	quantityB, err := resource.ParseQuantity(b)
+	if err != nil {
+		return 0, false
+	}
+	return quantityA.Cmp(quantityB), true
+}
+
+// kyvernoFailure is a failure of a resource to pass a Kyverno policy rule.
+type kyvernoFailure struct {
+	rule    string
+	message string
+}
+
+// evaluate returns the failures of the resource to pass the policy rules.
+func (policy *KyvernoPolicy) evaluate(
+	release *ExpandedRelease,
+	node *kyaml.RNode,
+) ([]kyvernoFailure, error) {
+	namespace := node.GetNamespace()
+	if namespace == "" {
+		namespace = release.Namespace
+	}
+	if policy.Kind == "Policy" && namespace != policy.Namespace {
+		return nil, nil
+	}
+
+	var object any
+	failures := []kyvernoFailure{}
+	for _, rule := range policy.rules {
+		if !rule.Match.matches(node, namespace) ||
+			(rule.Exclude != nil && rule.Exclude.matches(node, namespace)) {
+			continue
+		}
+		if object == nil {
+			bytes, err := node.MarshalJSON()
+			if err != nil {
+				return nil, fmt.Errorf("unable to encode node to JSON: %w", err)
+			}
+			if err := json.Unmarshal(bytes, &object); err != nil {
+				return nil, fmt.Errorf("unable to decode resource JSON: %w", err)
+			}
+		}
+
+		patterns := rule.Validate.AnyPattern
+		if rule.Validate.Pattern != nil {
+			patterns = []any{rule.Validate.Pattern}
+		}
+		result := patternSkip
+		failedPaths := []string{}
+		for _, pattern := range patterns {
+			patternResult, failedPath := validatePattern(pattern, object, "/")
+			if patternResult == patternPass {
+				result = patternPass
+				break
+			}
+			if patternResult == patternFail {
+				result = patternFail
+				failedPaths = append(failedPaths, failedPath)
+			}
+		}
+		if result != patternFail {
+			continue
+		}
+
+		message := rule.Validate.Message
+		if message == "" {
+			message = "validation failed"
+		}
+		failures = append(failures, kyvernoFailure{
+			rule: rule.Name,
+			message: fmt.Sprintf(
+				"%s (failed at %s)",
+				message,
+				strings.Join(failedPaths, ", "),
+			),
+		})
+	}
+	return failures, nil
+}
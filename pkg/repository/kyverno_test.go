@@ -0,0 +1,411 @@
+package repository
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+)
+
+var _ = ginkgo.Describe("Kyverno policies", func() {
+	var g gomega.Gomega
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+	})
+
+	readPolicies := func(content ...string) ([]*KyvernoPolicy, error) {
+		return ReadKyvernoPolicies(bytes.NewBufferString(strings.Join(content, "\n")))
+	}
+
+	getRelease := func() *ExpandedRelease {
+		nodes, err := (&kio.ByteReader{
+			Reader: bytes.NewBufferString(strings.Join([]string{
+				"apiVersion: apps/v1",
+				"kind: Deployment",
+				"metadata:",
+				"  name: good",
+				"  labels:",
+				"    team: alpha",
+				"spec:",
+				"  replicas: 2",
+				"  template:",
+				"    spec:",
+				"      containers:",
+				"      - name: app",
+				"        image: registry.example.com/app:1.0",
+				"        resources:",
+				"          limits:",
+				"            memory: 512Mi",
+				"---",
+				"apiVersion: apps/v1",
+				"kind: Deployment",
+				"metadata:",
+				"  name: bad",
+				"spec:",
+				"  replicas: 1",
+				"  template:",
+				"    spec:",
+				"      hostNetwork: true",
+				"      containers:",
+				"      - name: app",
+				"        image: docker.io/app:latest",
+				"        resources:",
+				"          limits:",
+				"            memory: 4Gi",
+				"---",
+				"apiVersion: v1",
+				"kind: ConfigMap",
+				"metadata:",
+				"  namespace: other",
+				"  name: config",
+			}, "\n")),
+			OmitReaderAnnotations: true,
+		}).Read()
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		for _, node := range nodes {
+			node.YNode().HeadComment = "Source: test-chart/templates/" +
+				strings.ToLower(node.GetKind()) + ".yaml"
+		}
+		return &ExpandedRelease{
+			Namespace:    "testns",
+			Name:         "test",
+			Chart:        "test-chart",
+			ChartVersion: "0.1.0",
+			Resources:    nodes,
+		}
+	}
+
+	check := func(policies []*KyvernoPolicy) []PolicyViolation {
+		checker := NewPolicyChecker(nil).WithKyvernoPolicies(policies)
+		checker.Check(getRelease())
+		return checker.Violations()
+	}
+
+	ginkgo.It("reports pattern violations with the release they come from", func() {
+		policies, err := readPolicies(
+			"apiVersion: kyverno.io/v1",
+			"kind: ClusterPolicy",
+			"metadata:",
+			"  name: workloads",
+			"spec:",
+			"  rules:",
+			"  - name: require-team",
+			"    match:",
+			"      any:",
+			"      - resources:",
+			"          kinds: [apps/v1/Deployment]",
+			"    validate:",
+			"      message: A team label is required.",
+			"      pattern:",
+			"        metadata:",
+			"          labels:",
+			"            team: \"?*\"",
+			"  - name: limit-memory",
+			"    match:",
+			"      any:",
+			"      - resources:",
+			"          kinds: [Deployment]",
+			"    validate:",
+			"      message: Memory limits must not exceed 1Gi.",
+			"      pattern:",
+			"        spec:",
+			"          template:",
+			"            spec:",
+			"              containers:",
+			"              - resources:",
+			"                  limits:",
+			"                    memory: <=1Gi",
+			"  - name: no-host-network",
+			"    match:",
+			"      resources:",
+			"        kinds: [Deployment]",
+			"    validate:",
+			"      message: Host network is not allowed.",
+			"      pattern:",
+			"        spec:",
+			"          template:",
+			"            spec:",
+			"              =(hostNetwork): false",
+			"  - name: add-labels",
+			"    match:",
+			"      any:",
+			"      - resources:",
+			"          kinds: [Deployment]",
+			"    mutate:",
+			"      patchStrategicMerge:",
+			"        metadata:",
+			"          labels:",
+			"            managed: \"true\"",
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		g.Expect(check(policies)).To(gomega.Equal([]PolicyViolation{
+			{
+				Policy:           "workloads/limit-memory",
+				Message:          "Memory limits must not exceed 1Gi. (failed at /spec/template/spec/containers/0/resources/limits/memory/)",
+				APIVersion:       "apps/v1",
+				Kind:             "Deployment",
+				Name:             "bad",
+				ReleaseNamespace: "testns",
+				ReleaseName:      "test",
+				Chart:            "test-chart",
+				ChartVersion:     "0.1.0",
+				Template:         "test-chart/templates/deployment.yaml",
+			},
+			{
+				Policy:           "workloads/no-host-network",
+				Message:          "Host network is not allowed. (failed at /spec/template/spec/hostNetwork/)",
+				APIVersion:       "apps/v1",
+				Kind:             "Deployment",
+				Name:             "bad",
+				ReleaseNamespace: "testns",
+				ReleaseName:      "test",
+				Chart:            "test-chart",
+				ChartVersion:     "0.1.0",
+				Template:         "test-chart/templates/deployment.yaml",
+			},
+			{
+				Policy:           "workloads/require-team",
+				Message:          "A team label is required. (failed at /metadata/labels/)",
+				APIVersion:       "apps/v1",
+				Kind:             "Deployment",
+				Name:             "bad",
+				ReleaseNamespace: "testns",
+				ReleaseName:      "test",
+				Chart:            "test-chart",
+				ChartVersion:     "0.1.0",
+				Template:         "test-chart/templates/deployment.yaml",
+			},
+		}))
+	})
+
+	ginkgo.It("supports conditional anchors, anyPattern, and exclusions", func() {
+		policies, err := readPolicies(
+			"apiVersion: kyverno.io/v1",
+			"kind: ClusterPolicy",
+			"metadata:",
+			"  name: images",
+			"spec:",
+			"  rules:",
+			"  - name: no-latest",
+			"    match:",
+			"      any:",
+			"      - resources:",
+			"          kinds: [Deployment]",
+			"    exclude:",
+			"      any:",
+			"      - resources:",
+			"          selector:",
+			"            matchLabels:",
+			"              team: alpha",
+			"    validate:",
+			"      message: Images must be pinned.",
+			"      pattern:",
+			"        spec:",
+			"          template:",
+			"            spec:",
+			"              containers:",
+			"              - (name): app",
+			"                image: \"!*:latest\"",
+			"  - name: registries",
+			"    match:",
+			"      any:",
+			"      - resources:",
+			"          kinds: [Deployment]",
+			"    validate:",
+			"      anyPattern:",
+			"      - spec:",
+			"          template:",
+			"            spec:",
+			"              containers:",
+			"              - image: registry.example.com/*",
+			"      - spec:",
+			"          replicas: \">1\"",
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		violations := check(policies)
+		g.Expect(violations).To(gomega.HaveLen(2))
+		g.Expect(violations[0].Policy).To(gomega.Equal("images/no-latest"))
+		g.Expect(violations[0].Name).To(gomega.Equal("bad"))
+		g.Expect(violations[1].Policy).To(gomega.Equal("images/registries"))
+		g.Expect(violations[1].Name).To(gomega.Equal("bad"))
+		g.Expect(violations[1].Message).To(gomega.Equal(
+			"validation failed (failed at /spec/template/spec/containers/0/image/, /spec/replicas/)",
+		))
+	})
+
+	ginkgo.It("applies namespaced policies within their namespace", func() {
+		policies, err := readPolicies(
+			"apiVersion: kyverno.io/v1",
+			"kind: Policy",
+			"metadata:",
+			"  namespace: testns",
+			"  name: namespaced",
+			"spec:",
+			"  rules:",
+			"  - name: require-labels",
+			"    match:",
+			"      any:",
+			"      - resources:",
+			"          kinds: ['*']",
+			"    validate:",
+			"      pattern:",
+			"        metadata:",
+			"          labels: {}",
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		violations := check(policies)
+		g.Expect(violations).To(gomega.HaveLen(1))
+		g.Expect(violations[0].Name).To(gomega.Equal("bad"))
+	})
+
+	ginkgo.It("ignores other objects in the input", func() {
+		policies, err := readPolicies(
+			"apiVersion: v1",
+			"kind: ConfigMap",
+			"metadata:",
+			"  name: config",
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(policies).To(gomega.BeEmpty())
+	})
+
+	ginkgo.It("rejects unsupported validations", func() {
+		_, err := readPolicies(
+			"apiVersion: kyverno.io/v1",
+			"kind: ClusterPolicy",
+			"metadata:",
+			"  name: unsupported",
+			"spec:",
+			"  rules:",
+			"  - name: deny",
+			"    match:",
+			"      any:",
+			"      - resources:",
+			"          kinds: [Deployment]",
+			"    validate:",
+			"      deny:",
+			"        conditions:",
+			"          any:",
+			"          - key: \"{{ request.object.spec.replicas }}\"",
+			"            operator: GreaterThan",
+			"            value: 3",
+		)
+		g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(
+			"rule deny of Kyverno policy unsupported is not supported: it uses a deny validation",
+		)))
+
+		_, err = readPolicies(
+			"apiVersion: policies.kyverno.io/v1alpha1",
+			"kind: ValidatingPolicy",
+			"metadata:",
+			"  name: cel",
+		)
+		g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(
+			"CEL expressions are not supported",
+		)))
+	})
+
+	ginkgo.DescribeTable(
+		"rejects rules outside of the supported subset",
+		func(rule []string, feature string) {
+			_, err := readPolicies(append([]string{
+				"apiVersion: kyverno.io/v1",
+				"kind: ClusterPolicy",
+				"metadata:",
+				"  name: unsupported",
+				"spec:",
+				"  rules:",
+				"  - name: rule",
+			}, rule...)...)
+			g.Expect(err).To(gomega.MatchError(
+				"rule rule of Kyverno policy unsupported is not supported: it uses " + feature,
+			))
+		},
+		ginkgo.Entry(
+			"has variables",
+			[]string{
+				"    match:",
+				"      any:",
+				"      - resources:",
+				"          kinds: [Deployment]",
+				"    validate:",
+				"      pattern:",
+				"        metadata:",
+				"          name: \"{{request.object.metadata.labels.app}}\"",
+			},
+			"variables",
+		),
+		ginkgo.Entry(
+			"has namespace selector",
+			[]string{
+				"    match:",
+				"      any:",
+				"      - resources:",
+				"          kinds: [Deployment]",
+				"          namespaceSelector:",
+				"            matchLabels:",
+				"              env: prod",
+				"    validate:",
+				"      pattern:",
+				"        spec:",
+				"          replicas: \">1\"",
+			},
+			"namespaceSelector",
+		),
+		ginkgo.Entry(
+			"has preconditions",
+			[]string{
+				"    match:",
+				"      resources:",
+				"        kinds: [Deployment]",
+				"    preconditions:",
+				"      all:",
+				"      - key: a",
+				"        operator: Equals",
+				"        value: a",
+				"    validate:",
+				"      pattern:",
+				"        spec:",
+				"          replicas: \">1\"",
+			},
+			"preconditions",
+		),
+		ginkgo.Entry(
+			"has user information",
+			[]string{
+				"    match:",
+				"      any:",
+				"      - resources:",
+				"          kinds: [Deployment]",
+				"        subjects:",
+				"        - kind: User",
+				"          name: admin",
+				"    validate:",
+				"      pattern:",
+				"        spec:",
+				"          replicas: \">1\"",
+			},
+			"user information",
+		),
+		ginkgo.Entry(
+			"has CEL validation",
+			[]string{
+				"    match:",
+				"      any:",
+				"      - resources:",
+				"          kinds: [Deployment]",
+				"    validate:",
+				"      cel:",
+				"        expressions:",
+				"        - expression: object.spec.replicas > 1",
+			},
+			"a cel validation",
+		),
+	)
+})
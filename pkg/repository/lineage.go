@@ -0,0 +1,43 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"fmt"
+	"strconv"
+
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const (
+	// ExpansionRoundAnnotation records the expansion round, starting with 1
+	// for the HelmRelease objects in the input, which generated a resource.
+	ExpansionRoundAnnotation = "fouskoti.sage.com/expansion-round"
+	// ParentReleaseAnnotation records the HelmRelease, as
+	// <namespace>/<name>, which generated a resource.
+	ParentReleaseAnnotation = "fouskoti.sage.com/parent-release"
+)
+
+// annotateExpansion records the expansion round and the HelmRelease which
+// generated the nodes in their annotations, so that resources generated by
+// nested HelmRelease objects can be traced back to the input.
+func annotateExpansion(nodes []*yaml.RNode, round int, release *ExpandedRelease) error {
+	parent := release.Namespace + "/" + release.Name
+	for _, node := range nodes {
+		err := node.PipeE(yaml.SetAnnotation(ExpansionRoundAnnotation, strconv.Itoa(round)))
+		if err == nil {
+			err = node.PipeE(yaml.SetAnnotation(ParentReleaseAnnotation, parent))
+		}
+		if err != nil {
+			return fmt.Errorf(
+				"unable to annotate %s %s/%s generated by Helm release %s: %w",
+				node.GetKind(),
+				node.GetNamespace(),
+				node.GetName(),
+				parent,
+				err,
+			)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+)
+
+var _ = ginkgo.Describe("Expansion annotations", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+	})
+
+	ginkgo.It("records the round and the parent release of generated resources", func() {
+		repoRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(repoRoot)
+		server, port, serverDone, err := serveDirectory(repoRoot, logger, nil)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer func() {
+			g.Expect(stopServing(server, serverDone)).To(gomega.Succeed())
+		}()
+
+		err = createSingleChartHelmRepository(
+			"app-of-apps",
+			"0.1.0",
+			map[string]string{
+				"Chart.yaml": strings.Join([]string{
+					"apiVersion: v2",
+					"name: app-of-apps",
+					"version: 0.1.0",
+				}, "\n"),
+				"templates/configmap.yaml": strings.Join([]string{
+					"apiVersion: v1",
+					"kind: ConfigMap",
+					"metadata:",
+					"  namespace: {{ .Release.Namespace }}",
+					"  name: {{ .Release.Name }}-configmap",
+				}, "\n"),
+				"templates/release.yaml": strings.Join([]string{
+					"{{- if .Values.child }}",
+					"apiVersion: helm.toolkit.fluxcd.io/v2",
+					"kind: HelmRelease",
+					"metadata:",
+					"  namespace: {{ .Release.Namespace }}",
+					"  name: {{ .Values.child }}",
+					"spec:",
+					"  chart:",
+					"    spec:",
+					"      chart: app-of-apps",
+					"      sourceRef:",
+					"        kind: HelmRepository",
+					"        name: local",
+					"{{- end }}",
+				}, "\n"),
+			},
+			port,
+			repoRoot,
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		input := strings.Join([]string{
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: HelmRepository",
+			"metadata:",
+			"  namespace: testns",
+			"  name: local",
+			"spec:",
+			fmt.Sprintf("  url: http://localhost:%d", port),
+			"---",
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: parent",
+			"spec:",
+			"  chart:",
+			"    spec:",
+			"      chart: app-of-apps",
+			"      sourceRef:",
+			"        kind: HelmRepository",
+			"        name: local",
+			"  values:",
+			"    child: child",
+		}, "\n")
+
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		var output bytes.Buffer
+		err = expander.Expand(
+			bytes.NewBufferString(input),
+			&output,
+			ExpandOptions{MaxExpansions: 2, ExpansionAnnotations: true},
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		nodes, err := (&kio.ByteReader{Reader: &output}).Read()
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		annotations := map[string]map[string]string{}
+		for _, node := range nodes {
+			annotations[node.GetKind()+"/"+node.GetName()] = node.GetAnnotations(
+				ExpansionRoundAnnotation,
+				ParentReleaseAnnotation,
+			)
+		}
+		g.Expect(annotations).To(gomega.Equal(map[string]map[string]string{
+			"HelmRepository/local": {},
+			"HelmRelease/parent":   {},
+			"HelmRelease/child": {
+				ExpansionRoundAnnotation: "1",
+				ParentReleaseAnnotation:  "testns/parent",
+			},
+			"ConfigMap/parent-configmap": {
+				ExpansionRoundAnnotation: "1",
+				ParentReleaseAnnotation:  "testns/parent",
+			},
+			"ConfigMap/child-configmap": {
+				ExpansionRoundAnnotation: "2",
+				ParentReleaseAnnotation:  "testns/child",
+			},
+		}))
+	})
+})
@@ -0,0 +1,134 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"fmt"
+	"io"
+
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+
+	yamlutil "github.com/sageailabs/fouskoti/pkg/yaml"
+)
+
+// ListedRelease describes a HelmRelease in the input and the chart source it
+// refers to, as declared in the input.
+type ListedRelease struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// HelmChart is the HelmChart object the release refers to, as
+	// <namespace>/<name>, if any.  The source is then the source of the
+	// HelmChart, or the HelmChart itself if it is missing from the input.
+	HelmChart       string `json:"helmChart,omitempty"`
+	SourceKind      string `json:"sourceKind"`
+	SourceNamespace string `json:"sourceNamespace"`
+	SourceName      string `json:"sourceName"`
+	Chart           string `json:"chart,omitempty"`
+	VersionSpec     string `json:"versionSpec,omitempty"`
+	// SourceFound reports whether the source object is in the input.
+	SourceFound bool `json:"sourceFound"`
+}
+
+func listHelmRelease(sources sourceIndex, release *yaml.RNode) (*ListedRelease, error) {
+	result := &ListedRelease{
+		Namespace: release.GetNamespace(),
+		Name:      release.GetName(),
+	}
+
+	namespace, name, found, err := getHelmChartReference(release)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		result.HelmChart = namespace + "/" + name
+		if sources.findSource("HelmChart", namespace, name) == nil {
+			result.SourceKind = "HelmChart"
+			result.SourceNamespace = namespace
+			result.SourceName = name
+			return result, nil
+		}
+		release, err = resolveHelmChartReference(sources, release)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Releases can refer to OCIRepository objects with the chart as their
+	// artifact directly.
+	chartRefKind, err := yamlutil.GetStringOr(release, "spec.chartRef.kind", "")
+	if err != nil {
+		return nil, err
+	}
+	if chartRefKind != "" {
+		result.SourceKind = chartRefKind
+		result.SourceName, err = release.GetString("spec.chartRef.name")
+		if err != nil {
+			return nil, fmt.Errorf("unable to get chart reference name: %w", err)
+		}
+		result.SourceNamespace, err = yamlutil.GetStringOr(
+			release,
+			"spec.chartRef.namespace",
+			release.GetNamespace(),
+		)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		sourceRef, err := getSourceReference(release)
+		if err != nil {
+			return nil, err
+		}
+		result.SourceKind = sourceRef.kind
+		result.SourceNamespace = sourceRef.namespace
+		result.SourceName = sourceRef.name
+		result.Chart, err = release.GetString("spec.chart.spec.chart")
+		if err != nil {
+			return nil, fmt.Errorf("unable to get chart name: %w", err)
+		}
+		result.VersionSpec, err = yamlutil.GetStringOr(release, "spec.chart.spec.version", "")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result.SourceFound = sources.findSource(
+		result.SourceKind,
+		result.SourceNamespace,
+		result.SourceName,
+	) != nil
+	return result, nil
+}
+
+// ListHelmReleases lists the HelmRelease objects in the input with the chart
+// sources they refer to and whether those are in the input.  It only parses
+// the input, without fetching anything.
+func ListHelmReleases(input io.Reader) ([]ListedRelease, error) {
+	nodes, err := (&kio.ByteReader{Reader: input}).Read()
+	if err != nil {
+		return nil, NewClassifiedError(
+			ErrorClassInput,
+			fmt.Errorf("unable to parse input: %w", err),
+		)
+	}
+
+	result := []ListedRelease{}
+	sources := newSourceIndex(nodes)
+	for _, node := range nodes {
+		if yamlutil.GetGroup(node) != "helm.toolkit.fluxcd.io" ||
+			node.GetKind() != "HelmRelease" {
+			continue
+		}
+		listed, err := listHelmRelease(sources, node)
+		if err != nil {
+			return nil, NewClassifiedError(ErrorClassInput, fmt.Errorf(
+				"unable to list Helm release %s/%s: %w",
+				node.GetNamespace(),
+				node.GetName(),
+				err,
+			))
+		}
+		result = append(result, *listed)
+	}
+	return result, nil
+}
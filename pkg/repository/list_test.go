@@ -0,0 +1,139 @@
+package repository
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("HelmRelease listing", func() {
+	var g gomega.Gomega
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+	})
+
+	ginkgo.It("lists releases with their sources without fetching", func() {
+		input := strings.Join([]string{
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: found",
+			"spec:",
+			"  chart:",
+			"    spec:",
+			"      chart: test-chart",
+			"      version: ^1.0",
+			"      sourceRef:",
+			"        kind: HelmRepository",
+			"        name: charts",
+			"        namespace: flux-system",
+			"---",
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: missing",
+			"spec:",
+			"  chart:",
+			"    spec:",
+			"      chart: ./charts/app",
+			"      sourceRef:",
+			"        kind: GitRepository",
+			"        name: awol",
+			"---",
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: via-helm-chart",
+			"spec:",
+			"  chartRef:",
+			"    kind: HelmChart",
+			"    name: app",
+			"---",
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: HelmChart",
+			"metadata:",
+			"  namespace: testns",
+			"  name: app",
+			"spec:",
+			"  chart: app-chart",
+			"  version: 2.x",
+			"  sourceRef:",
+			"    kind: HelmRepository",
+			"    name: charts",
+			"---",
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: HelmRepository",
+			"metadata:",
+			"  namespace: flux-system",
+			"  name: charts",
+			"spec:",
+			"  url: https://charts.example.com",
+		}, "\n")
+
+		listed, err := ListHelmReleases(bytes.NewBufferString(input))
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(listed).To(gomega.Equal([]ListedRelease{
+			{
+				Namespace:       "testns",
+				Name:            "found",
+				SourceKind:      "HelmRepository",
+				SourceNamespace: "flux-system",
+				SourceName:      "charts",
+				Chart:           "test-chart",
+				VersionSpec:     "^1.0",
+				SourceFound:     true,
+			},
+			{
+				Namespace:       "testns",
+				Name:            "missing",
+				SourceKind:      "GitRepository",
+				SourceNamespace: "testns",
+				SourceName:      "awol",
+				Chart:           "./charts/app",
+			},
+			{
+				Namespace:       "testns",
+				Name:            "via-helm-chart",
+				HelmChart:       "testns/app",
+				SourceKind:      "HelmRepository",
+				SourceNamespace: "testns",
+				SourceName:      "charts",
+				Chart:           "app-chart",
+				VersionSpec:     "2.x",
+			},
+		}))
+	})
+
+	ginkgo.It("reports missing HelmChart objects as sources", func() {
+		input := strings.Join([]string{
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"spec:",
+			"  chartRef:",
+			"    kind: HelmChart",
+			"    name: awol",
+		}, "\n")
+
+		listed, err := ListHelmReleases(bytes.NewBufferString(input))
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(listed).To(gomega.Equal([]ListedRelease{
+			{
+				Namespace:       "testns",
+				Name:            "test",
+				HelmChart:       "testns/awol",
+				SourceKind:      "HelmChart",
+				SourceNamespace: "testns",
+				SourceName:      "awol",
+			},
+		}))
+	})
+})
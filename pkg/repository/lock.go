@@ -0,0 +1,224 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/Masterminds/semver/v3"
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"gopkg.in/yaml.v3"
+	chart "helm.sh/helm/v4/pkg/chart/v2"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// LockedRelease records the chart a HelmRelease was rendered from.
+type LockedRelease struct {
+	Namespace  string `yaml:"namespace"`
+	Name       string `yaml:"name"`
+	SourceKind string `yaml:"sourceKind"`
+	URL        string `yaml:"url"`
+	Chart      string `yaml:"chart"`
+	Version    string `yaml:"version"`
+	Digest     string `yaml:"digest"`
+}
+
+// Lock holds the charts HelmReleases were rendered from, so that subsequent
+// renders can use exactly the same charts even with floating version
+// constraints.
+type Lock struct {
+	Releases []LockedRelease `yaml:"releases"`
+
+	mutex sync.Mutex
+}
+
+func ReadLock(input io.Reader) (*Lock, error) {
+	bytes, err := io.ReadAll(input)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read input: %w", err)
+	}
+
+	lock := &Lock{}
+	err = yaml.Unmarshal(bytes, lock)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse lock YAML: %w", err)
+	}
+	return lock, nil
+}
+
+func (lock *Lock) Write(output io.Writer) error {
+	lock.mutex.Lock()
+	defer lock.mutex.Unlock()
+
+	slices.SortFunc(lock.Releases, func(a, b LockedRelease) int {
+		return cmp.Or(
+			cmp.Compare(a.Namespace, b.Namespace),
+			cmp.Compare(a.Name, b.Name),
+		)
+	})
+	encoder := yaml.NewEncoder(output)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(lock); err != nil {
+		return fmt.Errorf("unable to encode lock YAML: %w", err)
+	}
+	return encoder.Close()
+}
+
+func (lock *Lock) add(release LockedRelease) {
+	lock.mutex.Lock()
+	defer lock.mutex.Unlock()
+
+	for i := range lock.Releases {
+		if lock.Releases[i].Namespace == release.Namespace &&
+			lock.Releases[i].Name == release.Name {
+			lock.Releases[i] = release
+			return
+		}
+	}
+	lock.Releases = append(lock.Releases, release)
+}
+
+// getLockedRelease finds the locked chart for the release and verifies that
+// the release still refers to the same chart with a version constraint
+// admitting the locked version.
+func (lock *Lock) getLockedRelease(
+	release *helmv2.HelmRelease,
+	repoNode *kyaml.RNode,
+) (*LockedRelease, error) {
+	var locked *LockedRelease
+	for i := range lock.Releases {
+		if lock.Releases[i].Namespace == release.Namespace &&
+			lock.Releases[i].Name == release.Name {
+			locked = &lock.Releases[i]
+			break
+		}
+	}
+	if locked == nil {
+		return nil, fmt.Errorf(
+			"lock has no entry for Helm release %s/%s",
+			release.Namespace,
+			release.Name,
+		)
+	}
+
+	repoURL, err := repoNode.GetString("spec.url")
+	if err != nil {
+		return nil, fmt.Errorf(
+			"unable to get URL for %s %s/%s: %w",
+			repoNode.GetKind(),
+			repoNode.GetNamespace(),
+			repoNode.GetName(),
+			err,
+		)
+	}
+	chartSpec := release.Spec.Chart.Spec
+	if locked.SourceKind != repoNode.GetKind() ||
+		locked.URL != repoURL ||
+		locked.Chart != chartSpec.Chart {
+		return nil, fmt.Errorf(
+			"chart %s of Helm release %s/%s is in %s %s, "+
+				"but the lock has chart %s in %s %s",
+			chartSpec.Chart,
+			release.Namespace,
+			release.Name,
+			repoNode.GetKind(),
+			repoURL,
+			locked.Chart,
+			locked.SourceKind,
+			locked.URL,
+		)
+	}
+
+	// Versions of charts in Git repositories are not selected by constraints.
+	if repoNode.GetKind() != "GitRepository" && chartSpec.Version != "" {
+		constraint, err := semver.NewConstraint(chartSpec.Version)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"unable to parse version constraint '%s' of Helm release %s/%s: %w",
+				chartSpec.Version,
+				release.Namespace,
+				release.Name,
+				err,
+			)
+		}
+		version, err := semver.NewVersion(locked.Version)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"unable to parse locked version '%s' of Helm release %s/%s: %w",
+				locked.Version,
+				release.Namespace,
+				release.Name,
+				err,
+			)
+		}
+		if !constraint.Check(version) {
+			return nil, fmt.Errorf(
+				"version constraint '%s' of Helm release %s/%s does not match "+
+					"the locked version %s",
+				chartSpec.Version,
+				release.Namespace,
+				release.Name,
+				locked.Version,
+			)
+		}
+	}
+	return locked, nil
+}
+
+// verify checks that the loaded chart is the one recorded in the lock.
+func (locked *LockedRelease) verify(version string, digest string) error {
+	if locked.Version != version {
+		return fmt.Errorf(
+			"chart %s of Helm release %s/%s has version %s, but the lock has %s",
+			locked.Chart,
+			locked.Namespace,
+			locked.Name,
+			version,
+			locked.Version,
+		)
+	}
+	if locked.Digest != digest {
+		return fmt.Errorf(
+			"chart %s/%s of Helm release %s/%s has digest %s, but the lock has %s",
+			locked.Chart,
+			version,
+			locked.Namespace,
+			locked.Name,
+			digest,
+			locked.Digest,
+		)
+	}
+	return nil
+}
+
+func writeChartFiles(writer io.Writer, chart *chart.Chart) {
+	files := slices.Clone(chart.Raw)
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	for _, file := range files {
+		_, _ = fmt.Fprintf(writer, "%s\n%d\n", file.Name, len(file.Data))
+		_, _ = writer.Write(file.Data)
+	}
+
+	dependencies := slices.Clone(chart.Dependencies())
+	sort.Slice(dependencies, func(i, j int) bool {
+		return dependencies[i].Name() < dependencies[j].Name()
+	})
+	for _, dependency := range dependencies {
+		_, _ = fmt.Fprintf(writer, "%s\n", dependency.Name())
+		writeChartFiles(writer, dependency)
+	}
+}
+
+// getChartDigest computes a digest of the chart files, including the files of
+// its dependencies.
+func getChartDigest(chart *chart.Chart) string {
+	hash := sha256.New()
+	writeChartFiles(hash, chart)
+	return fmt.Sprintf("sha256:%x", hash.Sum(nil))
+}
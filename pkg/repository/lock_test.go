@@ -0,0 +1,177 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Chart lock", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+
+	getChartFiles := func(version string) map[string]string {
+		return map[string]string{
+			"Chart.yaml": strings.Join([]string{
+				"apiVersion: v2",
+				"name: test-chart",
+				"version: " + version,
+			}, "\n"),
+			"templates/configmap.yaml": strings.Join([]string{
+				"apiVersion: v1",
+				"kind: ConfigMap",
+				"metadata:",
+				"  namespace: {{ .Release.Namespace }}",
+				"  name: {{ .Release.Name }}-configmap",
+				"data:",
+				"  version: {{ .Chart.Version }}",
+			}, "\n"),
+		}
+	}
+
+	getInput := func(versionSpec string, port int) string {
+		return strings.Join([]string{
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"spec:",
+			"  chart:",
+			"    spec:",
+			"      chart: test-chart",
+			fmt.Sprintf("      version: %q", versionSpec),
+			"      sourceRef:",
+			"        kind: HelmRepository",
+			"        name: local",
+			"---",
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: HelmRepository",
+			"metadata:",
+			"  namespace: testns",
+			"  name: local",
+			"spec:",
+			fmt.Sprintf("  url: http://localhost:%d", port),
+		}, "\n")
+	}
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+	})
+
+	ginkgo.It("records resolved charts and renders from them", func() {
+		repoRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(repoRoot)
+		server, port, serverDone, err := serveDirectory(repoRoot, logger, nil)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer func() {
+			err := stopServing(server, serverDone)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+		}()
+
+		err = createSingleChartHelmRepository(
+			"test-chart",
+			"0.1.0",
+			getChartFiles("0.1.0"),
+			port,
+			repoRoot,
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		lockOutput := &bytes.Buffer{}
+		err = expander.Expand(
+			bytes.NewBufferString(getInput(">=0.1.0", port)),
+			&bytes.Buffer{},
+			ExpandOptions{
+				LockOutput: lockOutput,
+			},
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		lock, err := ReadLock(bytes.NewBuffer(lockOutput.Bytes()))
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(lock.Releases).To(gomega.HaveLen(1))
+		g.Expect(lock.Releases[0].Namespace).To(gomega.Equal("testns"))
+		g.Expect(lock.Releases[0].Name).To(gomega.Equal("test"))
+		g.Expect(lock.Releases[0].SourceKind).To(gomega.Equal("HelmRepository"))
+		g.Expect(lock.Releases[0].Chart).To(gomega.Equal("test-chart"))
+		g.Expect(lock.Releases[0].Version).To(gomega.Equal("0.1.0"))
+		g.Expect(lock.Releases[0].Digest).To(gomega.HavePrefix("sha256:"))
+
+		// Publish a newer version matching the floating constraint.
+		err = createSingleChartHelmRepository(
+			"test-chart",
+			"0.2.0",
+			getChartFiles("0.2.0"),
+			port,
+			repoRoot,
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		output := &bytes.Buffer{}
+		err = expander.Expand(
+			bytes.NewBufferString(getInput(">=0.1.0", port)),
+			output,
+			ExpandOptions{
+				Lock: lock,
+			},
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(output.String()).To(gomega.ContainSubstring("version: 0.1.0"))
+
+		// The constraint no longer admits the locked version.
+		err = expander.Expand(
+			bytes.NewBufferString(getInput(">=0.2.0", port)),
+			&bytes.Buffer{},
+			ExpandOptions{
+				Lock: lock,
+			},
+		)
+		g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(
+			"version constraint '>=0.2.0' of Helm release testns/test does not " +
+				"match the locked version 0.1.0",
+		)))
+
+		// The locked chart content changed.
+		lock.Releases[0].Digest = "sha256:0000"
+		err = expander.Expand(
+			bytes.NewBufferString(getInput(">=0.1.0", port)),
+			&bytes.Buffer{},
+			ExpandOptions{
+				Lock: lock,
+			},
+		)
+		g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(
+			"chart drifted from the lock",
+		)))
+	})
+
+	ginkgo.It("rejects releases missing from the lock", func() {
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		err := expander.Expand(
+			bytes.NewBufferString(getInput(">=0.1.0", 8888)),
+			&bytes.Buffer{},
+			ExpandOptions{
+				Lock: &Lock{},
+			},
+		)
+		g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(
+			"lock has no entry for Helm release testns/test",
+		)))
+	})
+})
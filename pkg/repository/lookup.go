@@ -0,0 +1,249 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"helm.sh/helm/v4/pkg/engine"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// fixtureResourceClient serves the objects of a single kind from the
+// fixtures.  It only implements the methods used by the lookup function, the
+// other methods of the embedded interface are never called.
+type fixtureResourceClient struct {
+	dynamic.NamespaceableResourceInterface
+	resource  schema.GroupVersionResource
+	objects   []*unstructured.Unstructured
+	namespace string
+}
+
+func (client *fixtureResourceClient) Namespace(namespace string) dynamic.ResourceInterface {
+	return &fixtureResourceClient{
+		resource:  client.resource,
+		objects:   client.objects,
+		namespace: namespace,
+	}
+}
+
+func (client *fixtureResourceClient) Get(
+	ctx context.Context,
+	name string,
+	options metav1.GetOptions,
+	subresources ...string,
+) (*unstructured.Unstructured, error) {
+	for _, object := range client.objects {
+		if object.GetName() == name &&
+			(client.namespace == "" || object.GetNamespace() == client.namespace) {
+			return object.DeepCopy(), nil
+		}
+	}
+	return nil, apierrors.NewNotFound(client.resource.GroupResource(), name)
+}
+
+func (client *fixtureResourceClient) List(
+	ctx context.Context,
+	options metav1.ListOptions,
+) (*unstructured.UnstructuredList, error) {
+	result := &unstructured.UnstructuredList{Object: map[string]any{
+		"apiVersion": "v1",
+		"kind":       "List",
+	}}
+	for _, object := range client.objects {
+		if client.namespace == "" || object.GetNamespace() == client.namespace {
+			result.Items = append(result.Items, *object.DeepCopy())
+		}
+	}
+	return result, nil
+}
+
+type fixtureClientProvider struct {
+	objects []*unstructured.Unstructured
+}
+
+func (provider *fixtureClientProvider) GetClientFor(
+	apiVersion string,
+	kind string,
+) (dynamic.NamespaceableResourceInterface, bool, error) {
+	groupVersion, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid API version %s: %w", apiVersion, err)
+	}
+	gvk := groupVersion.WithKind(kind)
+	resource, _ := meta.UnsafeGuessKindToResource(gvk)
+	client := &fixtureResourceClient{resource: resource}
+	// Kinds without fixtures are assumed to be namespaced.
+	namespaced := true
+	for _, object := range provider.objects {
+		if object.GroupVersionKind() == gvk {
+			client.objects = append(client.objects, object)
+			if object.GetNamespace() == "" {
+				namespaced = false
+			}
+		}
+	}
+	return client, namespaced, nil
+}
+
+func appendFixture(objects []*unstructured.Unstructured, node *yaml.RNode) (
+	[]*unstructured.Unstructured,
+	error,
+) {
+	data, err := node.MarshalJSON()
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode lookup fixture to JSON: %w", err)
+	}
+	object := &unstructured.Unstructured{}
+	if err := object.UnmarshalJSON(data); err != nil {
+		return nil, fmt.Errorf(
+			"unable to decode lookup fixture %s %s/%s: %w",
+			node.GetKind(),
+			node.GetNamespace(),
+			node.GetName(),
+			err,
+		)
+	}
+	return append(objects, object), nil
+}
+
+// NewFixtureLookupProvider returns a provider serving the lookup function in
+// chart templates from the objects in the input, given as YAML documents or
+// as List objects like the output of kubectl get -o yaml.  Lookups of objects
+// missing from the input return empty objects, like on a cluster.
+func NewFixtureLookupProvider(input io.Reader) (engine.ClientProvider, error) {
+	nodes, err := (&kio.ByteReader{Reader: input, OmitReaderAnnotations: true}).Read()
+	if err != nil {
+		return nil, NewClassifiedError(
+			ErrorClassInput,
+			fmt.Errorf("unable to parse lookup fixtures: %w", err),
+		)
+	}
+	provider := &fixtureClientProvider{}
+	for _, node := range nodes {
+		if node.GetKind() != "List" {
+			provider.objects, err = appendFixture(provider.objects, node)
+			if err != nil {
+				return nil, NewClassifiedError(ErrorClassInput, err)
+			}
+			continue
+		}
+		items, err := node.Pipe(yaml.Lookup("items"))
+		if err != nil {
+			return nil, NewClassifiedError(ErrorClassInput, fmt.Errorf(
+				"unable to get items of lookup fixtures list: %w",
+				err,
+			))
+		}
+		if items == nil {
+			continue
+		}
+		elements, err := items.Elements()
+		if err != nil {
+			return nil, NewClassifiedError(ErrorClassInput, fmt.Errorf(
+				"unable to get items of lookup fixtures list: %w",
+				err,
+			))
+		}
+		for _, element := range elements {
+			provider.objects, err = appendFixture(provider.objects, element)
+			if err != nil {
+				return nil, NewClassifiedError(ErrorClassInput, err)
+			}
+		}
+	}
+	return provider, nil
+}
+
+type clusterClientProvider struct {
+	client dynamic.Interface
+	mapper meta.RESTMapper
+}
+
+func (provider *clusterClientProvider) GetClientFor(
+	apiVersion string,
+	kind string,
+) (dynamic.NamespaceableResourceInterface, bool, error) {
+	groupVersion, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid API version %s: %w", apiVersion, err)
+	}
+	mapping, err := provider.mapper.RESTMapping(
+		groupVersion.WithKind(kind).GroupKind(),
+		groupVersion.Version,
+	)
+	if err != nil {
+		return nil, false, fmt.Errorf(
+			"unable to find resource for %s %s: %w",
+			apiVersion,
+			kind,
+			err,
+		)
+	}
+	namespaced := mapping.Scope.Name() == meta.RESTScopeNameNamespace
+	return provider.client.Resource(mapping.Resource), namespaced, nil
+}
+
+// NewClusterLookupProvider returns a provider serving the lookup function in
+// chart templates from the cluster of the kubeconfig context, or of the
+// current context when empty.  The lookup function only gets and lists
+// objects, so the cluster is never modified.
+func NewClusterLookupProvider(kubeContext string) (engine.ClientProvider, error) {
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(),
+		&clientcmd.ConfigOverrides{CurrentContext: kubeContext},
+	).ClientConfig()
+	if err != nil {
+		return nil, NewClassifiedError(
+			ErrorClassAuth,
+			fmt.Errorf("unable to load kubeconfig: %w", err),
+		)
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create cluster client: %w", err)
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create discovery client: %w", err)
+	}
+	return &clusterClientProvider{
+		client: client,
+		mapper: restmapper.NewDeferredDiscoveryRESTMapper(
+			memory.NewMemCacheClient(discoveryClient),
+		),
+	}, nil
+}
+
+type failingClientProvider struct{}
+
+func (provider failingClientProvider) GetClientFor(
+	apiVersion string,
+	kind string,
+) (dynamic.NamespaceableResourceInterface, bool, error) {
+	return nil, false, fmt.Errorf(
+		"lookup of %s %s is not allowed, the chart depends on the cluster state",
+		apiVersion,
+		kind,
+	)
+}
+
+// NewFailingLookupProvider returns a provider failing the lookup function in
+// chart templates, to flag charts which render differently depending on the
+// state of the cluster.
+func NewFailingLookupProvider() engine.ClientProvider {
+	return failingClientProvider{}
+}
@@ -0,0 +1,171 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"helm.sh/helm/v4/pkg/engine"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = ginkgo.Describe("Lookup function", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+	})
+
+	fixtures := strings.Join([]string{
+		"apiVersion: v1",
+		"kind: List",
+		"items:",
+		"- apiVersion: v1",
+		"  kind: Secret",
+		"  metadata:",
+		"    namespace: testns",
+		"    name: credentials",
+		"  data:",
+		"    password: c2VjcmV0",
+		"- apiVersion: v1",
+		"  kind: Secret",
+		"  metadata:",
+		"    namespace: other",
+		"    name: credentials",
+		"---",
+		"apiVersion: v1",
+		"kind: Namespace",
+		"metadata:",
+		"  name: testns",
+	}, "\n")
+
+	ginkgo.It("serves objects from fixtures", func() {
+		provider, err := NewFixtureLookupProvider(strings.NewReader(fixtures))
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		client, namespaced, err := provider.GetClientFor("v1", "Secret")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(namespaced).To(gomega.BeTrue())
+		secret, err := client.Namespace("testns").
+			Get(ctx, "credentials", metav1.GetOptions{})
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(secret.Object["data"]).To(gomega.Equal(
+			map[string]any{"password": "c2VjcmV0"},
+		))
+		_, err = client.Namespace("testns").Get(ctx, "missing", metav1.GetOptions{})
+		g.Expect(apierrors.IsNotFound(err)).To(gomega.BeTrue())
+		secrets, err := client.Namespace("other").List(ctx, metav1.ListOptions{})
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(secrets.Items).To(gomega.HaveLen(1))
+
+		client, namespaced, err = provider.GetClientFor("v1", "Namespace")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(namespaced).To(gomega.BeFalse())
+		_, err = client.Get(ctx, "testns", metav1.GetOptions{})
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+	})
+
+	expand := func(lookupProvider engine.ClientProvider) (string, error) {
+		repoRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(repoRoot)
+		server, port, serverDone, err := serveDirectory(repoRoot, logger, nil)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer func() {
+			err := stopServing(server, serverDone)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+		}()
+
+		err = createSingleChartHelmRepository(
+			"test-chart",
+			"0.1.0",
+			map[string]string{
+				"Chart.yaml": strings.Join([]string{
+					"apiVersion: v2",
+					"name: test-chart",
+					"version: 0.1.0",
+				}, "\n"),
+				"templates/secret.yaml": strings.Join([]string{
+					"{{- $existing := lookup \"v1\" \"Secret\" .Release.Namespace \"credentials\" }}",
+					"apiVersion: v1",
+					"kind: Secret",
+					"metadata:",
+					"  name: credentials",
+					"data:",
+					"  password: {{ dig \"data\" \"password\" \"Z2VuZXJhdGVk\" $existing }}",
+				}, "\n"),
+			},
+			port,
+			repoRoot,
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		input := strings.Join([]string{
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"spec:",
+			"  chart:",
+			"    spec:",
+			"      chart: test-chart",
+			"      sourceRef:",
+			"        kind: HelmRepository",
+			"        name: local",
+			"---",
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: HelmRepository",
+			"metadata:",
+			"  namespace: testns",
+			"  name: local",
+			"spec:",
+			fmt.Sprintf("  url: http://localhost:%d", port),
+		}, "\n")
+
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		var output bytes.Buffer
+		err = expander.Expand(
+			bytes.NewBufferString(input),
+			&output,
+			ExpandOptions{LookupProvider: lookupProvider},
+		)
+		return output.String(), err
+	}
+
+	ginkgo.It("returns empty objects by default", func() {
+		output, err := expand(nil)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(output).To(gomega.ContainSubstring("password: Z2VuZXJhdGVk"))
+	})
+
+	ginkgo.It("renders charts with lookup results from fixtures", func() {
+		provider, err := NewFixtureLookupProvider(strings.NewReader(fixtures))
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		output, err := expand(provider)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(output).To(gomega.ContainSubstring("password: c2VjcmV0"))
+	})
+
+	ginkgo.It("fails on lookup if requested", func() {
+		_, err := expand(NewFailingLookupProvider())
+		g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(
+			"lookup of v1 Secret is not allowed",
+		)))
+		g.Expect(GetErrorClass(err)).To(gomega.Equal(ErrorClassRender))
+	})
+})
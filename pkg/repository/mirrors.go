@@ -0,0 +1,87 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// mirrorsKey is the key of the mirrors section in the credentials file, which
+// cannot be mistaken for a repository URL.
+const mirrorsKey = "mirrors"
+
+// Mirrors maps URL prefixes of Helm repositories, OCI registries, and Git
+// repositories to the URL prefixes of their mirrors, e.g.,
+// oci://registry-1.docker.io/bitnamicharts to
+// oci://proxy.example.com/bitnamicharts.
+type Mirrors map[string]string
+
+// ReadMirrors reads the mirrors section of the credentials file.
+func ReadMirrors(ctx context.Context, input io.Reader) (Mirrors, error) {
+	_, mirrors, err := ReadCredentialsFile(ctx, input)
+	return mirrors, err
+}
+
+// normalize returns the mirrors with bare registry hosts, like docker.io,
+// turned into OCI URLs, like oci://docker.io, failing on invalid URLs.
+func (mirrors Mirrors) normalize() (Mirrors, error) {
+	if mirrors == nil {
+		return nil, nil
+	}
+	result := Mirrors{}
+	for prefix, mirror := range mirrors {
+		values := []string{prefix, mirror}
+		for i, value := range values {
+			if !strings.Contains(value, "://") {
+				values[i] = "oci://" + value
+			}
+			if _, err := url.Parse(values[i]); err != nil {
+				return nil, fmt.Errorf("unable to parse mirror URL %s: %w", value, err)
+			}
+		}
+		result[values[0]] = values[1]
+	}
+	return result, nil
+}
+
+// hasURLPrefix tells whether the prefix matches the URL up to a path segment
+// boundary, so that https://charts.example.com doesn't match
+// https://charts.example.com.evil.
+func hasURLPrefix(repoURL string, prefix string) bool {
+	rest, found := strings.CutPrefix(repoURL, prefix)
+	return found &&
+		(rest == "" || strings.HasSuffix(prefix, "/") || strings.HasPrefix(rest, "/"))
+}
+
+// Rewrite replaces the longest prefix of the URL with a mirror by the mirror,
+// returning the URL unchanged if none matches.
+func (mirrors Mirrors) Rewrite(repoURL string) string {
+	var matched string
+	for prefix := range mirrors {
+		if len(prefix) > len(matched) && hasURLPrefix(repoURL, prefix) {
+			matched = prefix
+		}
+	}
+	if matched == "" {
+		return repoURL
+	}
+	return mirrors[matched] + strings.TrimPrefix(repoURL, matched)
+}
+
+// getMirrorURL returns the URL to fetch from in place of the URL, which is
+// the URL of its mirror, if any.  The original URL keeps identifying the
+// repository in the chart cache.
+func (config *loaderConfig) getMirrorURL(repoURL string) string {
+	mirrorURL := config.mirrors.Rewrite(repoURL)
+	if mirrorURL != repoURL {
+		config.logger.
+			With("url", repoURL).
+			With("mirror", mirrorURL).
+			Debug("Using mirror")
+	}
+	return mirrorURL
+}
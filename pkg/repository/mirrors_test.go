@@ -0,0 +1,152 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Repository mirrors", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+	})
+
+	credentialsFile := strings.Join([]string{
+		"mirrors:",
+		"  https://charts.example.com: https://mirror.example.com/charts",
+		"  https://charts.example.com/stable/: https://stable.example.com/",
+		"https://mirror.example.com/:",
+		"  credentials:",
+		"    username: ci",
+	}, "\n")
+
+	ginkgo.It("reads the mirrors section of the credentials file", func() {
+		mirrors, err := ReadMirrors(context.Background(), bytes.NewBufferString(credentialsFile))
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(mirrors).To(gomega.Equal(Mirrors{
+			"https://charts.example.com":         "https://mirror.example.com/charts",
+			"https://charts.example.com/stable/": "https://stable.example.com/",
+		}))
+
+		credentials, err := ReadCredentials(context.Background(), bytes.NewBufferString(credentialsFile))
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(credentials).To(gomega.HaveLen(1))
+		g.Expect(credentials).To(gomega.HaveKey("https://mirror.example.com/"))
+	})
+
+	ginkgo.It("reads bare registry hosts as OCI URLs", func() {
+		credentials, mirrors, err := ReadCredentialsFile(context.Background(), bytes.NewBufferString(strings.Join([]string{
+			"mirrors:",
+			"  docker.io: proxy.example.com/docker.io",
+			"  ghcr.io/org: oci://proxy.example.com/ghcr.io/org",
+			"oci://proxy.example.com/:",
+			"  credentials:",
+			"    username: ci",
+		}, "\n")))
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(mirrors).To(gomega.Equal(Mirrors{
+			"oci://docker.io":   "oci://proxy.example.com/docker.io",
+			"oci://ghcr.io/org": "oci://proxy.example.com/ghcr.io/org",
+		}))
+		g.Expect(credentials).To(gomega.HaveKey("oci://proxy.example.com/"))
+		g.Expect(mirrors.Rewrite("oci://docker.io/bitnamicharts")).To(gomega.Equal(
+			"oci://proxy.example.com/docker.io/bitnamicharts",
+		))
+	})
+
+	ginkgo.DescribeTable(
+		"rewrites URLs with the longest matching prefix",
+		func(repoURL string, expected string) {
+			mirrors := Mirrors{
+				"https://charts.example.com":         "https://mirror.example.com/charts",
+				"https://charts.example.com/stable/": "https://stable.example.com/",
+			}
+			g.Expect(mirrors.Rewrite(repoURL)).To(gomega.Equal(expected))
+		},
+		ginkgo.Entry("is prefix", "https://charts.example.com/incubator", "https://mirror.example.com/charts/incubator"),
+		ginkgo.Entry("is longer prefix", "https://charts.example.com/stable/app", "https://stable.example.com/app"),
+		ginkgo.Entry("is whole URL", "https://charts.example.com", "https://mirror.example.com/charts"),
+		ginkgo.Entry("is partial host", "https://charts.example.com.evil/", "https://charts.example.com.evil/"),
+		ginkgo.Entry("is other URL", "https://other.example.com/", "https://other.example.com/"),
+	)
+
+	ginkgo.It("fetches charts from the mirror", func() {
+		repoRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(repoRoot)
+		server, port, serverDone, err := serveDirectory(repoRoot, logger, nil)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer func() {
+			g.Expect(stopServing(server, serverDone)).To(gomega.Succeed())
+		}()
+
+		err = createSingleChartHelmRepository(
+			"test-chart",
+			"0.1.0",
+			map[string]string{
+				"Chart.yaml": strings.Join([]string{
+					"apiVersion: v2",
+					"name: test-chart",
+					"version: 0.1.0",
+				}, "\n"),
+				"templates/configmap.yaml": strings.Join([]string{
+					"apiVersion: v1",
+					"kind: ConfigMap",
+					"metadata:",
+					"  namespace: {{ .Release.Namespace }}",
+					"  name: {{ .Release.Name }}-configmap",
+				}, "\n"),
+			},
+			port,
+			repoRoot,
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		input := strings.Join([]string{
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"spec:",
+			"  chart:",
+			"    spec:",
+			"      chart: test-chart",
+			"      sourceRef:",
+			"        kind: HelmRepository",
+			"        name: charts",
+			"---",
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: HelmRepository",
+			"metadata:",
+			"  namespace: testns",
+			"  name: charts",
+			"spec:",
+			"  url: https://charts.invalid",
+		}, "\n")
+
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil).WithMirrors(Mirrors{
+			"https://charts.invalid": fmt.Sprintf("http://localhost:%d", port),
+		})
+		var output bytes.Buffer
+		err = expander.Expand(bytes.NewBufferString(input), &output, ExpandOptions{})
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(output.String()).To(gomega.ContainSubstring("name: test-configmap"))
+	})
+})
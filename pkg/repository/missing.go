@@ -0,0 +1,130 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MissingSource is a HelmRelease referencing a chart source which is not in
+// the input.
+type MissingSource struct {
+	ReleaseNamespace string
+	ReleaseName      string
+	Kind             string
+	Namespace        string
+	Name             string
+}
+
+func (source MissingSource) String() string {
+	return fmt.Sprintf(
+		"%s/%s -> %s %s/%s",
+		source.ReleaseNamespace,
+		source.ReleaseName,
+		source.Kind,
+		source.Namespace,
+		source.Name,
+	)
+}
+
+// MissingSourcesError lists the HelmRelease objects with chart sources missing
+// from the input.
+type MissingSourcesError struct {
+	Sources []MissingSource
+}
+
+func (err *MissingSourcesError) Error() string {
+	lines := make([]string, 0, len(err.Sources)+1)
+	lines = append(lines, "missing chart sources for Helm releases:")
+	for _, source := range err.Sources {
+		lines = append(lines, "  "+source.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// findMissingSources returns the HelmRelease objects among the pairs which
+// have no repository in the input.
+func findMissingSources(releaseRepos []releaseRepo) ([]MissingSource, error) {
+	var result []MissingSource
+	for _, pair := range releaseRepos {
+		if pair.repo != nil {
+			continue
+		}
+		sourceRef, err := getSourceReference(pair.release)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"unable to get chart source of Helm release %s/%s: %w",
+				pair.release.GetNamespace(),
+				pair.release.GetName(),
+				err,
+			)
+		}
+		result = append(result, MissingSource{
+			ReleaseNamespace: pair.release.GetNamespace(),
+			ReleaseName:      pair.release.GetName(),
+			Kind:             sourceRef.kind,
+			Namespace:        sourceRef.namespace,
+			Name:             sourceRef.name,
+		})
+	}
+	return result, nil
+}
+
+// checkMissingSources fails listing all of the HelmRelease objects among the
+// pairs which have no repository in the input, so that they can all be fixed
+// at once.
+func checkMissingSources(releaseRepos []releaseRepo) error {
+	missingSources, err := findMissingSources(releaseRepos)
+	if err != nil {
+		return err
+	}
+	if len(missingSources) > 0 {
+		return NewClassifiedError(
+			ErrorClassInput,
+			&MissingSourcesError{Sources: missingSources},
+		)
+	}
+	return nil
+}
+
+// skipMissingSources fails on the HelmRelease objects to expand with chart
+// sources missing from the input or, when those are allowed, warns about them
+// and leaves them out of the pairs, so that they are passed through without
+// expansion.
+func (renderer *releaseRepoRenderer) skipMissingSources(
+	releaseRepos []releaseRepo,
+) ([]releaseRepo, error) {
+	toExpand := make([]releaseRepo, 0, len(releaseRepos))
+	for _, pair := range releaseRepos {
+		// Releases in the skip list are not expanded and need no source.
+		if !renderer.options.SkipList.contains(pair.release) {
+			toExpand = append(toExpand, pair)
+		}
+	}
+	if !renderer.options.AllowMissingSources {
+		if err := checkMissingSources(toExpand); err != nil {
+			return nil, err
+		}
+		return releaseRepos, nil
+	}
+
+	missingSources, err := findMissingSources(toExpand)
+	if err != nil {
+		return nil, err
+	}
+	for _, source := range missingSources {
+		renderer.config.logger.
+			With("namespace", source.ReleaseNamespace).
+			With("name", source.ReleaseName).
+			With("source", fmt.Sprintf("%s %s/%s", source.Kind, source.Namespace, source.Name)).
+			Warn("Skipping expansion of Helm release with a missing chart source")
+	}
+	result := make([]releaseRepo, 0, len(releaseRepos))
+	for _, pair := range releaseRepos {
+		if pair.repo != nil || renderer.options.SkipList.contains(pair.release) {
+			result = append(result, pair)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Missing chart sources", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+	})
+
+	getRelease := func(name string, sourceKind string, sourceName string) string {
+		return strings.Join([]string{
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: " + name,
+			"spec:",
+			"  chart:",
+			"    spec:",
+			"      chart: test-chart",
+			"      sourceRef:",
+			"        kind: " + sourceKind,
+			"        name: " + sourceName,
+			"        namespace: sources",
+		}, "\n")
+	}
+	input := strings.Join([]string{
+		getRelease("first", "HelmRepository", "awol"),
+		getRelease("second", "GitRepository", "gone"),
+	}, "\n---\n")
+
+	ginkgo.It("lists all of the missing sources", func() {
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		err := expander.Expand(
+			bytes.NewBufferString(input),
+			&bytes.Buffer{},
+			ExpandOptions{},
+		)
+		g.Expect(err).To(gomega.MatchError(strings.Join([]string{
+			"missing chart sources for Helm releases:",
+			"  testns/first -> HelmRepository sources/awol",
+			"  testns/second -> GitRepository sources/gone",
+		}, "\n")))
+		g.Expect(GetErrorClass(err)).To(gomega.Equal(ErrorClassInput))
+	})
+
+	ginkgo.It("passes releases with missing sources through if allowed", func() {
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		var output bytes.Buffer
+		err := expander.Expand(
+			bytes.NewBufferString(input),
+			&output,
+			ExpandOptions{AllowMissingSources: true},
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(output.String()).To(gomega.ContainSubstring("name: first"))
+		g.Expect(output.String()).To(gomega.ContainSubstring("name: second"))
+	})
+
+	ginkgo.It("ignores missing sources of skipped releases", func() {
+		skipList, err := NewReleaseSkipList([]string{"testns/first", "testns/second"})
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		err = expander.Expand(
+			bytes.NewBufferString(input),
+			&bytes.Buffer{},
+			ExpandOptions{SkipList: skipList},
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+	})
+})
@@ -0,0 +1,40 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"fmt"
+
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// recordNamespaces records the Namespace objects among the nodes, which need
+// no Namespace objects created for them.
+func (renderer *releaseRepoRenderer) recordNamespaces(nodes []*yaml.RNode) {
+	for _, node := range nodes {
+		if node.GetKind() == "Namespace" && node.GetApiVersion() == "v1" {
+			renderer.namespaces[node.GetName()] = true
+		}
+	}
+}
+
+// getNamespaceNodes returns the Namespace object for the target namespace of
+// the release the way Flux creates it on install, unless the target namespace
+// is the namespace of the release or a Namespace object for it has already
+// been seen.
+func (renderer *releaseRepoRenderer) getNamespaceNodes(
+	release *ExpandedRelease,
+) ([]*yaml.RNode, error) {
+	if release.TargetNamespace == release.Namespace ||
+		renderer.namespaces[release.TargetNamespace] {
+		return nil, nil
+	}
+	node := yaml.NewMapRNode(nil)
+	node.SetApiVersion("v1")
+	node.SetKind("Namespace")
+	if err := node.SetName(release.TargetNamespace); err != nil {
+		return nil, fmt.Errorf("unable to set namespace name: %w", err)
+	}
+	renderer.namespaces[release.TargetNamespace] = true
+	return []*yaml.RNode{node}, nil
+}
@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Namespace creation", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+	var port int
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+
+		repoRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		ginkgo.DeferCleanup(os.RemoveAll, repoRoot)
+		server, serverPort, serverDone, err := serveDirectory(repoRoot, logger, nil)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		ginkgo.DeferCleanup(func() {
+			g.Expect(stopServing(server, serverDone)).To(gomega.Succeed())
+		})
+		port = serverPort
+
+		err = createSingleChartHelmRepository(
+			"test-chart",
+			"0.1.0",
+			map[string]string{
+				"Chart.yaml": strings.Join([]string{
+					"apiVersion: v2",
+					"name: test-chart",
+					"version: 0.1.0",
+				}, "\n"),
+				"templates/configmap.yaml": strings.Join([]string{
+					"apiVersion: v1",
+					"kind: ConfigMap",
+					"metadata:",
+					"  namespace: {{ .Release.Namespace }}",
+					"  name: {{ .Release.Name }}-configmap",
+				}, "\n"),
+			},
+			port,
+			repoRoot,
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+	})
+
+	getRelease := func(name string, targetNamespace string) string {
+		lines := []string{
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: " + name,
+			"spec:",
+			"  chart:",
+			"    spec:",
+			"      chart: test-chart",
+			"      sourceRef:",
+			"        kind: HelmRepository",
+			"        name: local",
+		}
+		if targetNamespace != "" {
+			lines = append(lines, "  targetNamespace: "+targetNamespace)
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	expand := func(options ExpandOptions, documents ...string) string {
+		input := strings.Join(append([]string{
+			strings.Join([]string{
+				"apiVersion: source.toolkit.fluxcd.io/v1",
+				"kind: HelmRepository",
+				"metadata:",
+				"  namespace: testns",
+				"  name: local",
+				"spec:",
+				fmt.Sprintf("  url: http://localhost:%d", port),
+			}, "\n"),
+		}, documents...), "\n---\n")
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		var output bytes.Buffer
+		err := expander.Expand(bytes.NewBufferString(input), &output, options)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		return output.String()
+	}
+
+	namespace := func(name string) string {
+		return "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: " + name + "\n"
+	}
+
+	ginkgo.It("adds a Namespace object for each target namespace once", func() {
+		output := expand(
+			ExpandOptions{CreateNamespaces: true},
+			getRelease("first", "apps"),
+			getRelease("second", "apps"),
+			getRelease("third", ""),
+		)
+		g.Expect(strings.Count(output, namespace("apps"))).To(gomega.Equal(1))
+		g.Expect(output).ToNot(gomega.ContainSubstring(namespace("testns")))
+		g.Expect(output).To(gomega.ContainSubstring("  namespace: apps\n  name: apps-first-configmap\n"))
+	})
+
+	ginkgo.It("skips the target namespaces with Namespace objects in the input", func() {
+		output := expand(
+			ExpandOptions{CreateNamespaces: true},
+			strings.TrimSuffix(namespace("apps"), "\n"),
+			getRelease("first", "apps"),
+		)
+		g.Expect(strings.Count(output, "kind: Namespace\n")).To(gomega.Equal(1))
+	})
+
+	ginkgo.It("adds no Namespace objects by default", func() {
+		output := expand(ExpandOptions{}, getRelease("first", "apps"))
+		g.Expect(output).ToNot(gomega.ContainSubstring("kind: Namespace\n"))
+	})
+})
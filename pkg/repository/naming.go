@@ -0,0 +1,77 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// getNamingViolations returns the violations of the Kubernetes and Helm
+// length and character set constraints by the name of the Helm release of
+// the HelmRelease, its target and storage namespaces, and the namespaces and
+// labels of the resources rendered from it, which would otherwise only fail
+// when helm-controller reconciles it.
+func getNamingViolations(release *helmv2.HelmRelease, expanded *ExpandedRelease) []string {
+	var violations []string
+	add := func(subject string, value string, messages []string) {
+		for _, message := range messages {
+			violations = append(violations, fmt.Sprintf("%s %q: %s", subject, value, message))
+		}
+	}
+
+	// helm-controller shortens the names it derives, but not in the legacy
+	// mode, and the custom resource definition limits spec.releaseName.
+	if len(release.Spec.ReleaseName) > maxReleaseNameLength {
+		add("spec.releaseName", release.Spec.ReleaseName, []string{
+			fmt.Sprintf("must be no more than %d characters", maxReleaseNameLength),
+		})
+	} else if len(expanded.ReleaseName) > maxReleaseNameLength {
+		add("release name", expanded.ReleaseName, []string{
+			fmt.Sprintf("must be no more than %d characters", maxReleaseNameLength),
+		})
+	}
+	add("release name", expanded.ReleaseName, validation.IsDNS1123Subdomain(expanded.ReleaseName))
+	add("target namespace", expanded.TargetNamespace, validation.IsDNS1123Label(expanded.TargetNamespace))
+	if expanded.StorageNamespace != release.Namespace {
+		add(
+			"storage namespace",
+			expanded.StorageNamespace,
+			validation.IsDNS1123Label(expanded.StorageNamespace),
+		)
+	}
+
+	for _, node := range expanded.Resources {
+		resource := fmt.Sprintf("%s %s/%s", node.GetKind(), node.GetNamespace(), node.GetName())
+		namespace := node.GetNamespace()
+		if namespace != "" && namespace != expanded.TargetNamespace && namespace != release.Namespace {
+			add(resource+" namespace", namespace, validation.IsDNS1123Label(namespace))
+		}
+		labels := node.GetLabels()
+		for _, key := range slices.Sorted(maps.Keys(labels)) {
+			add(resource+" label", key, validation.IsQualifiedName(key))
+			add(resource+" label "+key+" value", labels[key], validation.IsValidLabelValue(labels[key]))
+		}
+	}
+	return violations
+}
+
+// checkNaming returns a validation error listing the naming violations of the
+// HelmRelease, if there are any.
+func checkNaming(release *helmv2.HelmRelease, expanded *ExpandedRelease) error {
+	violations := getNamingViolations(release, expanded)
+	if len(violations) == 0 {
+		return nil
+	}
+	return NewClassifiedError(ErrorClassValidation, fmt.Errorf(
+		"invalid names in Helm release %s/%s: %s",
+		release.Namespace,
+		release.Name,
+		strings.Join(violations, "; "),
+	))
+}
@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"strings"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+var _ = ginkgo.Describe("Naming validation", func() {
+	var g gomega.Gomega
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+	})
+
+	release := &helmv2.HelmRelease{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "testns", Name: "test"},
+	}
+
+	getExpanded := func(releaseName string, resources ...string) *ExpandedRelease {
+		var nodes []*yaml.RNode
+		for _, resource := range resources {
+			nodes = append(nodes, yaml.MustParse(resource))
+		}
+		return &ExpandedRelease{
+			Namespace:        "testns",
+			Name:             "test",
+			TargetNamespace:  "testns",
+			ReleaseName:      releaseName,
+			StorageNamespace: "testns",
+			Resources:        nodes,
+		}
+	}
+
+	ginkgo.It("accepts valid names", func() {
+		expanded := getExpanded("test", strings.Join([]string{
+			"apiVersion: v1",
+			"kind: ConfigMap",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test-configmap",
+			"  labels:",
+			"    app.kubernetes.io/name: test",
+			"    empty: \"\"",
+		}, "\n"))
+		g.Expect(checkNaming(release, expanded)).To(gomega.Succeed())
+	})
+
+	ginkgo.It("reports invalid names", func() {
+		expanded := getExpanded(strings.Repeat("a", 54), strings.Join([]string{
+			"apiVersion: v1",
+			"kind: ConfigMap",
+			"metadata:",
+			"  namespace: Other_Namespace",
+			"  name: test-configmap",
+			"  labels:",
+			"    version: " + strings.Repeat("1", 64),
+			"    invalid key: value",
+		}, "\n"))
+		violations := getNamingViolations(release, expanded)
+		g.Expect(violations).To(gomega.HaveLen(4))
+		g.Expect(violations[0]).To(gomega.HavePrefix(
+			`release name "` + strings.Repeat("a", 54) + `": must be no more than 53 characters`,
+		))
+		g.Expect(violations[1]).To(gomega.HavePrefix(
+			`ConfigMap Other_Namespace/test-configmap namespace "Other_Namespace": `,
+		))
+		g.Expect(violations[2]).To(gomega.HavePrefix(
+			`ConfigMap Other_Namespace/test-configmap label "invalid key": `,
+		))
+		g.Expect(violations[3]).To(gomega.HavePrefix(
+			`ConfigMap Other_Namespace/test-configmap label version value "` +
+				strings.Repeat("1", 64) + `": must be no more than 63 characters`,
+		))
+
+		err := checkNaming(release, expanded)
+		g.Expect(err).To(gomega.MatchError(gomega.HavePrefix(
+			"invalid names in Helm release testns/test: ",
+		)))
+		g.Expect(GetErrorClass(err)).To(gomega.Equal(ErrorClassValidation))
+	})
+
+	ginkgo.It("reports long custom release names", func() {
+		withReleaseName := release.DeepCopy()
+		withReleaseName.Spec.ReleaseName = strings.Repeat("b", 54)
+		violations := getNamingViolations(withReleaseName, getExpanded(strings.Repeat("b", 54)))
+		g.Expect(violations).To(gomega.ConsistOf(
+			`spec.releaseName "` + strings.Repeat("b", 54) + `": must be no more than 53 characters`,
+		))
+	})
+})
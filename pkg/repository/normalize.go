@@ -0,0 +1,108 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// namedSetFields are the fields of Kubernetes objects with lists of objects
+// identified by their names whose order has no meaning, which are sorted by
+// the names in the normalized output.  Lists like env, initContainers, and
+// volumeMounts are left alone, as their order matters.
+var namedSetFields = map[string]bool{
+	"containers":          true,
+	"ephemeralContainers": true,
+	"imagePullSecrets":    true,
+	"ports":               true,
+	"volumes":             true,
+}
+
+// normalizeNode rewrites the node in place in the normalized form: without
+// comments, with the keys of maps sorted, with block styles, and with scalars
+// only quoted where needed.  The field is the name of the field holding the
+// node, if any.
+func normalizeNode(node *yaml.Node, field string) {
+	node.HeadComment = ""
+	node.LineComment = ""
+	node.FootComment = ""
+	switch node.Kind {
+	case yaml.ScalarNode:
+		node.Style = 0
+		if strings.Contains(node.Value, "\n") {
+			node.Style = yaml.LiteralStyle
+		}
+	case yaml.MappingNode:
+		node.Style = 0
+		type pair struct{ key, value *yaml.Node }
+		pairs := make([]pair, 0, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			normalizeNode(key, "")
+			normalizeNode(value, key.Value)
+			pairs = append(pairs, pair{key, value})
+		}
+		slices.SortStableFunc(pairs, func(a, b pair) int {
+			return cmp.Compare(a.key.Value, b.key.Value)
+		})
+		node.Content = node.Content[:0]
+		for _, pair := range pairs {
+			node.Content = append(node.Content, pair.key, pair.value)
+		}
+	case yaml.SequenceNode:
+		node.Style = 0
+		for _, element := range node.Content {
+			normalizeNode(element, "")
+		}
+		if names := getElementNames(node); namedSetFields[field] && names != nil {
+			slices.SortStableFunc(node.Content, func(a, b *yaml.Node) int {
+				return cmp.Compare(names[a], names[b])
+			})
+		}
+	default:
+		for _, child := range node.Content {
+			normalizeNode(child, field)
+		}
+	}
+}
+
+// getElementNames returns the names of the elements of the sequence node if
+// all of them are maps with distinct names, or nil.
+func getElementNames(node *yaml.Node) map[*yaml.Node]string {
+	names := map[*yaml.Node]string{}
+	seen := map[string]bool{}
+	for _, element := range node.Content {
+		name := yaml.NewRNode(element).Field("name")
+		if name == nil || name.Value.YNode().Kind != yaml.ScalarNode {
+			return nil
+		}
+		value := name.Value.YNode().Value
+		if seen[value] {
+			return nil
+		}
+		names[element] = value
+		seen[value] = true
+	}
+	return names
+}
+
+// normalizingWriter writes copies of the nodes in the normalized form, so that
+// semantically equal output is written the same.
+type normalizingWriter struct {
+	writer kio.Writer
+}
+
+func (writer *normalizingWriter) Write(nodes []*yaml.RNode) error {
+	normalized := make([]*yaml.RNode, 0, len(nodes))
+	for _, node := range nodes {
+		node = node.Copy()
+		normalizeNode(node.Document(), "")
+		normalized = append(normalized, node)
+	}
+	return writer.writer.Write(normalized)
+}
@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Output normalization", func() {
+	var g gomega.Gomega
+	var expander *HelmReleaseExpander
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		logger := slog.New(slog.NewTextHandler(ginkgo.GinkgoWriter, nil))
+		expander = NewHelmReleaseExpander(context.Background(), logger, nil, nil)
+	})
+
+	expand := func(input string, options ExpandOptions) (string, error) {
+		var output bytes.Buffer
+		err := expander.Expand(bytes.NewBufferString(input), &output, options)
+		return output.String(), err
+	}
+
+	ginkgo.It("writes semantically equal documents the same", func() {
+		first, err := expand(strings.Join([]string{
+			"# A pod",
+			"kind: Pod",
+			"apiVersion: v1",
+			"metadata: {name: app, labels: {tier: 'web', app: \"app\"}}",
+			"spec:",
+			"  containers:",
+			"  - name: sidecar",
+			"    image: proxy",
+			"  - name: app # The application",
+			"    image: app",
+			"    env:",
+			"    - {name: B, value: '1'}",
+			"    - {name: A, value: $(B)}",
+		}, "\n"), ExpandOptions{Normalize: true})
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		second, err := expand(strings.Join([]string{
+			"apiVersion: v1",
+			"kind: Pod",
+			"metadata:",
+			"  labels:",
+			"    app: app",
+			"    tier: web",
+			"  name: app",
+			"spec:",
+			"  containers:",
+			"    - image: app",
+			"      name: app",
+			"      env:",
+			"        - name: B",
+			"          value: \"1\"",
+			"        - name: A",
+			"          value: $(B)",
+			"    - image: proxy",
+			"      name: sidecar",
+		}, "\n"), ExpandOptions{Normalize: true})
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(first).To(gomega.Equal(second))
+		g.Expect(first).To(gomega.Equal(strings.Join([]string{
+			"apiVersion: v1",
+			"kind: Pod",
+			"metadata:",
+			"  labels:",
+			"    app: app",
+			"    tier: web",
+			"  name: app",
+			"spec:",
+			"  containers:",
+			"  - env:",
+			"    - name: B",
+			"      value: \"1\"",
+			"    - name: A",
+			"      value: $(B)",
+			"    image: app",
+			"    name: app",
+			"  - image: proxy",
+			"    name: sidecar",
+			"",
+		}, "\n")))
+	})
+
+	ginkgo.It("cannot be combined with preserving the input", func() {
+		_, err := expand("kind: Namespace", ExpandOptions{
+			Normalize:     true,
+			PreserveInput: true,
+		})
+		g.Expect(err).To(gomega.HaveOccurred())
+		g.Expect(GetErrorClass(err)).To(gomega.Equal(ErrorClassInput))
+	})
+})
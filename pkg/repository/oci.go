@@ -4,7 +4,9 @@ package repository
 
 import (
 	"bytes"
+	"cmp"
 	"fmt"
+	"net/http"
 	"net/url"
 	"os"
 	"path"
@@ -31,6 +33,10 @@ import (
 
 var ociSchemePrefix string = fmt.Sprintf("%s://", registry.OCIScheme)
 var ecrRepoRegex regexp.Regexp = *regexp.MustCompile("^[0-9]+[.]dkr[.]ecr[.][a-z0-9-]+[.]amazonaws.com$")
+
+// The public ECR gallery serves anonymous pulls with low rate limits, which
+// are lifted for pulls with tokens of the ECR Public service.
+var ecrPublicRepoRegex regexp.Regexp = *regexp.MustCompile("^public[.]ecr[.]aws$")
 var acrRepoRegex regexp.Regexp = *regexp.MustCompile("^.+[.]azurecr[.](?:io|cn|de|us)$")
 var gcrRepoRegex regexp.Regexp = *regexp.MustCompile("^(?:(?:.+[.])?gcr[.]io|.+-docker[.]pkg[.]dev)$")
 
@@ -46,7 +52,7 @@ func getRepoProviderName(repo *sourcev1.HelmRepository, repoHost string) string
 	if repo != nil {
 		return repo.Spec.Provider
 	}
-	if ecrRepoRegex.MatchString(repoHost) {
+	if ecrRepoRegex.MatchString(repoHost) || ecrPublicRepoRegex.MatchString(repoHost) {
 		return aws.ProviderName
 	}
 	if acrRepoRegex.MatchString(repoHost) {
@@ -59,9 +65,21 @@ func getRepoProviderName(repo *sourcev1.HelmRepository, repoHost string) string
 	return ""
 }
 
+// providerLogin logs into the registry with the automatic authentication of
+// the cloud provider, once per run for each registry.
 func (loader *ociRepoChartLoader) providerLogin(
 	providerName string,
 	registryHost string,
+) (*authn.AuthConfig, error) {
+	key := providerAuthKey{providerName: providerName, registryHost: registryHost}
+	return loader.connections.getProviderAuth(key, func() (*authn.AuthConfig, error) {
+		return loader.loginToProvider(providerName, registryHost)
+	})
+}
+
+func (loader *ociRepoChartLoader) loginToProvider(
+	providerName string,
+	registryHost string,
 ) (*authn.AuthConfig, error) {
 	authenticator, err := authutils.GetArtifactRegistryCredentials(
 		loader.ctx,
@@ -135,10 +153,28 @@ func (loader *ociRepoChartLoader) getChartVersion(
 		return chartVersionSpec, nil
 	}
 
-	chartRef := path.Join(strings.TrimPrefix(repoURL, ociSchemePrefix), chartName)
-	tags, err := client.Tags(chartRef)
+	if loader.offline {
+		return loader.getCachedChartVersion(repoURL, chartName, chartVersionSpec)
+	}
+
+	mirrorURL := loader.getMirrorURL(repoURL)
+	chartRef := path.Join(strings.TrimPrefix(mirrorURL, ociSchemePrefix), chartName)
+	tags, err := loader.tagCache.getTags(chartRef, func() ([]string, error) {
+		releaseFetchSlot, err := loader.acquireFetchSlot(mirrorURL)
+		if err != nil {
+			return nil, err
+		}
+		defer releaseFetchSlot()
+		tags, err := client.Tags(chartRef)
+		if err != nil {
+			return nil, newFetchError(
+				fmt.Errorf("unable to fetch tags for %s: %w", chartRef, err),
+			)
+		}
+		return tags, nil
+	})
 	if err != nil {
-		return "", fmt.Errorf("unable to fetch tags for %s: %w", chartRef, err)
+		return "", err
 	}
 	if len(tags) == 0 {
 		return "", fmt.Errorf("unable to locate any tags for %s: %w", chartRef, err)
@@ -155,6 +191,58 @@ func (loader *ociRepoChartLoader) getChartVersion(
 	return result, nil
 }
 
+// resolveChartDigest returns the digest of the manifest of the chart
+// reference in the registry, requesting only the headers of the manifest.
+func (loader *ociRepoChartLoader) resolveChartDigest(
+	client repositoryClient,
+	mirrorURL string,
+	chartRef string,
+) (string, error) {
+	releaseFetchSlot, err := loader.acquireFetchSlot(mirrorURL)
+	if err != nil {
+		return "", err
+	}
+	digest, err := client.Resolve(chartRef)
+	releaseFetchSlot()
+	if err != nil {
+		return "", newFetchError(
+			fmt.Errorf("unable to resolve digest of %s: %w", chartRef, err),
+		)
+	}
+	return digest, nil
+}
+
+// getCachedChartVersion finds the latest version of the chart matching the
+// constraint among the versions in the file cache, as the registry tags cannot
+// be listed in the offline mode.
+func (loader *ociRepoChartLoader) getCachedChartVersion(
+	repoURL string,
+	chartName string,
+	chartVersionSpec string,
+) (string, error) {
+	repoPath := getCachePathForRepo(loader.cacheRoot, repoURL, false)
+	entries, err := os.ReadDir(repoPath)
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("unable to list cached charts in %s: %w", repoPath, err)
+	}
+	var tags []string
+	for _, entry := range entries {
+		if tag, found := strings.CutPrefix(entry.Name(), chartName+"-"); found && entry.IsDir() {
+			tags = append(tags, tag)
+		}
+	}
+	result, err := getLatestMatchingVersion(tags, chartVersionSpec)
+	if err != nil {
+		return "", &CacheMissError{Resource: fmt.Sprintf(
+			"chart %s matching version %s from OCI repository %s",
+			chartName,
+			cmp.Or(chartVersionSpec, "*"),
+			repoURL,
+		)}
+	}
+	return result, nil
+}
+
 func getChartPath(
 	repoPath string,
 	chartName string,
@@ -167,19 +255,31 @@ type repositoryClient interface {
 	Login(registryHost string, username string, password string) error
 	Tags(chartRef string) ([]string, error)
 	Get(chartRef string) (*bytes.Buffer, error)
+	// Resolve returns the digest of the manifest of the chart reference.
+	Resolve(chartRef string) (string, error)
 }
 
 type ociRepoClient struct {
 	client registry.Client
 }
 
-type repositoryClientFactoryFunc func(insecure bool) (repositoryClient, error)
-
-func NewOciRepositoryClient(insecure bool) (repositoryClient, error) {
-	options := []registry.ClientOption{}
+// repositoryClientFactoryFunc creates registry clients, using plain HTTP for
+// insecure registries and the transport, if any, for their TLS settings.
+type repositoryClientFactoryFunc func(
+	insecure bool,
+	transport *http.Transport,
+) (repositoryClient, error)
+
+func NewOciRepositoryClient(insecure bool, transport *http.Transport) (repositoryClient, error) {
+	// Cache the tokens of the registry, so that requests for the same
+	// repositories don't negotiate them anew.
+	options := []registry.ClientOption{registry.ClientOptEnableCache(true)}
 	if insecure {
 		options = append(options, registry.ClientOptPlainHTTP())
 	}
+	if transport != nil {
+		options = append(options, registry.ClientOptHTTPClient(&http.Client{Transport: transport}))
+	}
 	registryClient, err := registry.NewClient(options...)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create registry client: %w", err)
@@ -202,6 +302,14 @@ func (client *ociRepoClient) Tags(chartRef string) ([]string, error) {
 	return client.client.Tags(chartRef)
 }
 
+func (client *ociRepoClient) Resolve(chartRef string) (string, error) {
+	descriptor, err := client.client.Resolve(chartRef)
+	if err != nil {
+		return "", err
+	}
+	return descriptor.Digest.String(), nil
+}
+
 func (client *ociRepoClient) Get(chartRef string) (*bytes.Buffer, error) {
 	getter, err := helmgetter.NewOCIGetter(
 		helmgetter.WithRegistryClient(&client.client),
@@ -228,22 +336,18 @@ func isRepoInsecure(repo *sourcev1.HelmRepository, repoURL *url.URL) bool {
 	return true
 }
 
-func (loader *ociRepoChartLoader) loadRepositoryChart(
+// getRepository returns the HelmRepository object defined by repoNode (nil
+// if repoNode is nil) and the normalized URL of the repository.
+func (loader *ociRepoChartLoader) getRepository(
 	repoNode *yaml.RNode,
 	repoURL string,
-	parentContext *chartContext,
-	chartName string,
-	chartVersionSpec string,
-) (*chart.Chart, error) {
-	savedLogger := loader.logger
-	defer func() { loader.logger = savedLogger }()
-
+) (*sourcev1.HelmRepository, string, error) {
 	var repo *sourcev1.HelmRepository
 	if repoNode != nil {
 		repo = &sourcev1.HelmRepository{}
 		err := decodeToObject(repoNode, repo)
 		if err != nil {
-			return nil, fmt.Errorf(
+			return nil, "", fmt.Errorf(
 				"unable to decode OCIRepository %s/%s: %w",
 				repoNode.GetNamespace(),
 				repoNode.GetName(),
@@ -257,25 +361,29 @@ func (loader *ociRepoChartLoader) loadRepositoryChart(
 		repoURL = repo.Spec.URL
 	}
 
-	loader.logger = loader.logger.With(
-		"url", repoURL,
-		"chart", chartName,
-	)
+	loader.logger = loader.logger.With("url", repoURL)
 
-	repoURL, err := normalizeURL(repoURL)
+	normalizedURL, err := normalizeURL(repoURL)
 	if err != nil {
-		return nil, fmt.Errorf(
+		return nil, "", fmt.Errorf(
 			"invalid Helm repository URL %s: %w",
 			repoURL,
 			err,
 		)
 	}
+	return repo, normalizedURL, nil
+}
 
-	loader.logger.
-		With("version", chartVersionSpec).
-		Debug("Loading chart from OCI Helm repository")
-
-	repoPath := getCachePathForRepo(loader.cacheRoot, repoURL, false)
+// getRepositoryClient returns a registry client for the repository, or its
+// mirror, logged in with the credentials from the first source in the chain
+// of getRegistryAuth to have them.  The clients, and so their connections and
+// tokens, are shared by the repositories in the registry with the same
+// settings for the rest of the run.
+func (loader *ociRepoChartLoader) getRepositoryClient(
+	repo *sourcev1.HelmRepository,
+	repoURL string,
+) (repositoryClient, error) {
+	repoURL = loader.getMirrorURL(repoURL)
 	parsedURL, err := url.Parse(repoURL)
 	if err != nil {
 		return nil, fmt.Errorf(
@@ -285,17 +393,6 @@ func (loader *ociRepoChartLoader) loadRepositoryChart(
 		)
 	}
 
-	repoClient, err := loader.repoClientFactory(isRepoInsecure(repo, parsedURL))
-	if err != nil {
-		return nil, fmt.Errorf(
-			"unable to create repository client: %w",
-			err,
-		)
-	}
-
-	var username string
-	var password string
-
 	repoCreds, err := loader.credentials.FindForRepo(parsedURL)
 	if err != nil {
 		return nil, fmt.Errorf(
@@ -304,41 +401,93 @@ func (loader *ociRepoChartLoader) loadRepositoryChart(
 			err,
 		)
 	}
+	var credentials map[string]string
 	if repoCreds != nil {
-		username = string(repoCreds.Credentials["username"])
-		password = string(repoCreds.Credentials["password"])
-		loader.logger.Debug("Using password from credentials file")
+		credentials = repoCreds.Credentials
+	}
+	tlsCredentials, err := loader.getTLSCredentials(repo, credentials)
+	if err != nil {
+		return nil, err
+	}
+	key := registryClientKey{
+		insecure:     isRepoInsecure(repo, parsedURL),
+		transportKey: getTransportKey(tlsCredentials),
+		registryHost: parsedURL.Host,
+	}
+	var transport *http.Transport
+	if key.transportKey != "" {
+		// Without TLS settings, the registry client uses its own transport,
+		// which retries failed requests.
+		transport, err = loader.connections.getTransport(tlsCredentials)
+		if err != nil {
+			return nil, NewClassifiedError(ErrorClassAuth, fmt.Errorf(
+				"invalid TLS credentials for repository %s: %w",
+				repoURL,
+				err,
+			))
+		}
 	}
 
-	if username == "" && password == "" {
-		providerName := getRepoProviderName(repo, parsedURL.Host)
-		if providerName != "" {
-			authConfig, err := loader.providerLogin(providerName, parsedURL.Host)
-			if err != nil {
-				return nil, fmt.Errorf(
-					"unable to log in to the %s registry %s: %w",
-					strings.ToUpper(providerName),
-					parsedURL.Host,
-					err,
-				)
-			}
-
-			username = authConfig.Username
-			password = authConfig.Password
+	var authConfig *authn.AuthConfig
+	// Logging in requires access to the registry.
+	if !loader.offline {
+		authConfig, err = loader.getRegistryAuth(repo, parsedURL)
+		if err != nil {
+			return nil, err
 		}
 	}
+	if authConfig != nil {
+		key.username = authConfig.Username
+		key.password = authConfig.Password
+	}
 
-	if username != "" || password != "" {
-		err = repoClient.Login(parsedURL.Host, username, password)
+	return loader.connections.getRegistryClient(key, func() (repositoryClient, error) {
+		repoClient, err := loader.repoClientFactory(key.insecure, transport)
 		if err != nil {
 			return nil, fmt.Errorf(
+				"unable to create repository client: %w",
+				err,
+			)
+		}
+		if authConfig == nil {
+			return repoClient, nil
+		}
+		err = repoClient.Login(parsedURL.Host, authConfig.Username, authConfig.Password)
+		if err != nil {
+			return nil, NewClassifiedError(ErrorClassAuth, fmt.Errorf(
 				"unable to log in to registry %s: %w",
 				parsedURL.Host,
 				err,
-			)
+			))
 		}
-	}
+		return repoClient, nil
+	})
+}
+
+func (loader *ociRepoChartLoader) resolveChartVersion(
+	repoNode *yaml.RNode,
+	repoURL string,
+	chartName string,
+	chartVersionSpec string,
+) (string, error) {
+	savedLogger := loader.logger
+	defer func() { loader.logger = savedLogger }()
 
+	repo, repoURL, err := loader.getRepository(repoNode, repoURL)
+	if err != nil {
+		return "", err
+	}
+	substitutedChart, err := loader.loadSubstitutedChart(repoURL, chartName)
+	if err != nil {
+		return "", err
+	}
+	if substitutedChart != nil {
+		return substitutedChart.Metadata.Version, nil
+	}
+	repoClient, err := loader.getRepositoryClient(repo, repoURL)
+	if err != nil {
+		return "", err
+	}
 	chartVersion, err := loader.getChartVersion(
 		repoClient,
 		repoURL,
@@ -346,7 +495,7 @@ func (loader *ociRepoChartLoader) loadRepositoryChart(
 		chartVersionSpec,
 	)
 	if err != nil {
-		return nil, fmt.Errorf(
+		return "", fmt.Errorf(
 			"unable to find version %s for chart %s in repository %s: %w",
 			chartVersionSpec,
 			chartName,
@@ -354,102 +503,178 @@ func (loader *ociRepoChartLoader) loadRepositoryChart(
 			err,
 		)
 	}
+	return chartVersion, nil
+}
 
-	chartPath := getChartPath(repoPath, chartName, chartVersion)
-	chartKey := fmt.Sprintf("%s#%s#%s", repoURL, chartName, chartVersion)
-	if loader.chartCache != nil {
-		if chart, ok := loader.chartCache[chartKey]; ok {
-			loader.logger.
-				With("version", chartVersion).
-				Debug("Using chart from in-memory cache")
-			return chart, nil
-		}
-	}
+func (loader *ociRepoChartLoader) loadRepositoryChart(
+	repoNode *yaml.RNode,
+	repoURL string,
+	parentContext *chartContext,
+	chartName string,
+	chartVersionSpec string,
+) (*chart.Chart, error) {
+	savedLogger := loader.logger
+	defer func() { loader.logger = savedLogger }()
 
-	if stat, err := os.Stat(chartPath); err == nil && stat.IsDir() {
-		loader.logger.
-			With("version", chartVersion).
-			Debug("Using chart from file cache")
-		chart, err := helmloader.LoadDir(chartPath)
-		if err != nil {
-			loader.logger.
-				With("error", err).
-				With("version", chartVersion).
-				Error("Unable to load chart from file cache")
-			err := os.RemoveAll(chartPath)
-			if err != nil {
-				loader.logger.
-					With("error", err).
-					With("dir", chartPath).
-					Error("Unable to clean the chart from file cache")
-			}
-		}
-		return chart, nil
+	repo, repoURL, err := loader.getRepository(repoNode, repoURL)
+	if err != nil {
+		return nil, err
 	}
-
-	chartRef := fmt.Sprintf(
-		"%s:%s",
-		path.Join(strings.TrimPrefix(repoURL, ociSchemePrefix), chartName),
-		chartVersion,
-	)
-
-	chartData, err := repoClient.Get(chartRef)
+	substitutedChart, err := loader.loadSubstitutedChart(repoURL, chartName)
 	if err != nil {
-		return nil, fmt.Errorf(
-			"unable to download chart %s for version constraint %s: %w",
-			chartRef,
-			chartVersion,
-			err,
-		)
+		return nil, err
+	}
+	if substitutedChart != nil {
+		return substitutedChart, nil
 	}
 
-	files, err := archive.LoadArchiveFiles(chartData)
+	loader.logger = loader.logger.With("chart", chartName)
+	loader.logger.
+		With("version", chartVersionSpec).
+		Debug("Loading chart from OCI Helm repository")
+
+	repoPath := getCachePathForRepo(loader.cacheRoot, repoURL, false)
+	repoClient, err := loader.getRepositoryClient(repo, repoURL)
 	if err != nil {
-		return nil, fmt.Errorf(
-			"unable to load chart files from archive for chart %s/%s in %s: %w",
-			chartName,
-			chartVersion,
-			repoURL,
-			err,
-		)
+		return nil, err
 	}
 
-	chart, err := helmloader.LoadFiles(files)
+	chartVersion, err := loader.getChartVersion(
+		repoClient,
+		repoURL,
+		chartName,
+		chartVersionSpec,
+	)
 	if err != nil {
 		return nil, fmt.Errorf(
-			"unable to load chart %s/%s in %s: %w",
+			"unable to find version %s for chart %s in repository %s: %w",
+			chartVersionSpec,
 			chartName,
-			chartVersion,
 			repoURL,
 			err,
 		)
 	}
 
-	err = saveChartFiles(files, chartPath)
-	if err != nil {
-		return nil, fmt.Errorf(
-			"unable to save chart files to cache for chart %s/%s in %s: %w",
-			chartName,
+	chartPath := getChartPath(repoPath, chartName, chartVersion)
+	chartKey := fmt.Sprintf("%s#%s#%s", repoURL, chartName, chartVersion)
+	chart, cached, err := loader.chartCache.load(chartKey, func() (*chart.Chart, error) {
+		mirrorURL := loader.getMirrorURL(repoURL)
+		chartRef := fmt.Sprintf(
+			"%s:%s",
+			path.Join(strings.TrimPrefix(mirrorURL, ociSchemePrefix), chartName),
 			chartVersion,
-			repoURL,
-			err,
 		)
-	}
+		var digest string
+		if loader.isVerifyingCache() && !loader.offline {
+			var err error
+			digest, err = loader.resolveChartDigest(repoClient, mirrorURL, chartRef)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if stat, err := os.Stat(chartPath); err == nil && stat.IsDir() &&
+			(digest == "" || !loader.invalidateCachedChart(chartPath, digest)) {
+			loader.logger.
+				With("version", chartVersion).
+				Debug("Using chart from file cache")
+			chart, err := helmloader.LoadDir(chartPath)
+			if err != nil {
+				loader.logger.
+					With("error", err).
+					With("version", chartVersion).
+					Error("Unable to load chart from file cache")
+				err := os.RemoveAll(chartPath)
+				if err != nil {
+					loader.logger.
+						With("error", err).
+						With("dir", chartPath).
+						Error("Unable to clean the chart from file cache")
+				}
+			}
+			return chart, nil
+		}
+
+		if loader.offline {
+			return nil, &CacheMissError{Resource: fmt.Sprintf(
+				"chart %s version %s from OCI repository %s",
+				chartName,
+				chartVersion,
+				repoURL,
+			)}
+		}
+
+		releaseFetchSlot, err := loader.acquireFetchSlot(mirrorURL)
+		if err != nil {
+			return nil, err
+		}
+		chartData, err := repoClient.Get(chartRef)
+		releaseFetchSlot()
+		if err != nil {
+			return nil, newFetchError(fmt.Errorf(
+				"unable to download chart %s for version constraint %s: %w",
+				chartRef,
+				chartVersion,
+				err,
+			))
+		}
 
-	loader.logger = loader.logger.WithGroup("deps")
-	err = loadChartDependencies(loader.loaderConfig, chart, nil)
+		files, err := archive.LoadArchiveFiles(chartData)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"unable to load chart files from archive for chart %s/%s in %s: %w",
+				chartName,
+				chartVersion,
+				repoURL,
+				err,
+			)
+		}
+
+		chart, err := helmloader.LoadFiles(files)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"unable to load chart %s/%s in %s: %w",
+				chartName,
+				chartVersion,
+				repoURL,
+				err,
+			)
+		}
+
+		err = saveChartFiles(files, chartPath)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"unable to save chart files to cache for chart %s/%s in %s: %w",
+				chartName,
+				chartVersion,
+				repoURL,
+				err,
+			)
+		}
+		loader.saveChartDigest(chartPath, digest)
+
+		loader.logger = loader.logger.WithGroup("deps")
+		err = loadChartDependencies(loader.loaderConfig, chart, nil)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"unable to load chart dependencies for %s/%s in %s: %w",
+				chartName,
+				chart.Metadata.Version,
+				repoURL,
+				err,
+			)
+		}
+
+		return chart, nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf(
-			"unable to load chart dependencies for %s/%s in %s: %w",
-			chartName,
-			chart.Metadata.Version,
-			repoURL,
-			err,
-		)
+		return nil, err
 	}
-
-	if loader.chartCache != nil {
-		loader.chartCache[chartKey] = chart
+	if cached {
+		loader.logger.
+			With("version", chartVersion).
+			Debug("Using chart from in-memory cache")
+		return chart, nil
 	}
 
 	loader.logger.
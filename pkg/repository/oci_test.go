@@ -5,6 +5,7 @@ import (
 	"context"
 	"io"
 	"log/slog"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
@@ -37,6 +38,11 @@ func (mock *repoClientMock) Get(chartRef string) (*bytes.Buffer, error) {
 	return args.Get(0).(*bytes.Buffer), args.Error(1)
 }
 
+func (mock *repoClientMock) Resolve(chartRef string) (string, error) {
+	args := mock.Called(chartRef)
+	return args.String(0), args.Error(1)
+}
+
 var _ = ginkgo.Describe("OCIRepository expansion", func() {
 	var g gomega.Gomega
 	var ctx context.Context
@@ -122,7 +128,7 @@ var _ = ginkgo.Describe("OCIRepository expansion", func() {
 			ctx,
 			logger,
 			nil,
-			func(insecure bool) (repositoryClient, error) {
+			func(insecure bool, transport *http.Transport) (repositoryClient, error) {
 				return repoClient, nil
 			},
 		)
@@ -147,7 +153,7 @@ var _ = ginkgo.Describe("OCIRepository expansion", func() {
 			"kind: ConfigMap",
 			"metadata:",
 			"  namespace: testns",
-			"  name: testns-test-configmap",
+			"  name: test-configmap",
 			"data:",
 			"  foo: baz",
 			"",
@@ -203,12 +209,12 @@ var _ = ginkgo.Describe("OCIRepository expansion", func() {
 		}, "\n")
 
 		repoClient := &repoClientMock{}
-		// The tags are requested twice - once for each HelmRelease being expanded.
-		// But Get is invoked only once. For the second HelmRelease, the
-		// memory-cached chart should be used.
+		// The tags and the chart are requested only once. For the second
+		// HelmRelease, the tags listed for the first one and the memory-cached
+		// chart should be used.
 		repoClient.
 			On("Tags", "localhost:8888/test-chart").
-			Twice().
+			Once().
 			Return([]string{"0.1.0"}, nil)
 		repoClient.
 			On("Get", "localhost:8888/test-chart:0.1.0").
@@ -219,7 +225,7 @@ var _ = ginkgo.Describe("OCIRepository expansion", func() {
 			ctx,
 			logger,
 			nil,
-			func(insecure bool) (repositoryClient, error) {
+			func(insecure bool, transport *http.Transport) (repositoryClient, error) {
 				return repoClient, nil
 			},
 		)
@@ -244,7 +250,7 @@ var _ = ginkgo.Describe("OCIRepository expansion", func() {
 			"kind: ConfigMap",
 			"metadata:",
 			"  namespace: testns",
-			"  name: testns-test-configmap",
+			"  name: test-configmap",
 			"data:",
 			"  foo: bar",
 			"---",
@@ -253,7 +259,7 @@ var _ = ginkgo.Describe("OCIRepository expansion", func() {
 			"kind: ConfigMap",
 			"metadata:",
 			"  namespace: testns",
-			"  name: testns-test2-configmap",
+			"  name: test2-configmap",
 			"data:",
 			"  foo: baz",
 			"",
@@ -312,7 +318,7 @@ var _ = ginkgo.Describe("OCIRepository expansion", func() {
 			ctx,
 			logger,
 			nil,
-			func(insecure bool) (repositoryClient, error) {
+			func(insecure bool, transport *http.Transport) (repositoryClient, error) {
 				return repoClient, nil
 			},
 		)
@@ -333,13 +339,13 @@ var _ = ginkgo.Describe("OCIRepository expansion", func() {
 			ctx,
 			logger,
 			nil,
-			func(insecure bool) (repositoryClient, error) {
+			func(insecure bool, transport *http.Transport) (repositoryClient, error) {
 				return repoClient, nil
 			},
 		)
 		configmapTemplateName := filepath.Join(
-			cacheRoot,
-			"oci:##localhost:8888/test-chart-0.1.0/templates/configmap.yaml",
+			getCachePathForRepo(cacheRoot, "oci://localhost:8888", false),
+			"test-chart-0.1.0/templates/configmap.yaml",
 		)
 		g.Expect(err).ToNot(gomega.HaveOccurred())
 
@@ -372,7 +378,7 @@ var _ = ginkgo.Describe("OCIRepository expansion", func() {
 			"kind: ConfigMap",
 			"metadata:",
 			"  namespace: testns",
-			"  name: testns-test-configmap",
+			"  name: test-configmap",
 			"data:",
 			"  foo: baz",
 			"",
@@ -0,0 +1,147 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// registryAuthSource is a step of the chain of sources of registry
+// credentials.  It returns nil if it has no credentials for the registry.
+type registryAuthSource struct {
+	name           string
+	getCredentials func() (*authn.AuthConfig, error)
+}
+
+// getCredentialsFileAuth returns the credentials of the repository from the
+// credentials file.
+func (loader *ociRepoChartLoader) getCredentialsFileAuth(
+	parsedURL *url.URL,
+) (*authn.AuthConfig, error) {
+	repoCreds, err := loader.credentials.FindForRepo(parsedURL)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"unable to find credentials for repository %s: %w",
+			parsedURL,
+			err,
+		)
+	}
+	if repoCreds == nil {
+		return nil, nil
+	}
+	return &authn.AuthConfig{
+		Username: repoCreds.Credentials["username"],
+		Password: repoCreds.Credentials["password"],
+	}, nil
+}
+
+// getProviderAuth logs into the registry with the automatic authentication of
+// the cloud provider set in the HelmRepository object or, without the object,
+// recognized from the registry host.  Failures to log in with a provider
+// recognized from the host are not errors, as the registry may not need it.
+func (loader *ociRepoChartLoader) getProviderAuth(
+	repo *sourcev1.HelmRepository,
+	registryHost string,
+) (*authn.AuthConfig, error) {
+	providerName := getRepoProviderName(repo, registryHost)
+	if providerName == "" || providerName == "generic" {
+		return nil, nil
+	}
+	authConfig, err := loader.providerLogin(providerName, registryHost)
+	if err != nil {
+		if repo == nil {
+			loader.logger.
+				With("provider", providerName).
+				With("error", err).
+				Debug("Unable to log in with the provider recognized from the registry host")
+			return nil, nil
+		}
+		return nil, NewClassifiedError(ErrorClassAuth, fmt.Errorf(
+			"unable to log in to the %s registry %s: %w",
+			strings.ToUpper(providerName),
+			registryHost,
+			err,
+		))
+	}
+	return authConfig, nil
+}
+
+// getDockerKeychainAuth returns the credentials of the registry from the
+// Docker configuration, i.e., $DOCKER_CONFIG/config.json or
+// ~/.docker/config.json, including its credential helpers.  Broken Docker
+// configurations, like missing credential helpers, are common on CI machines
+// and are not errors.
+func (loader *ociRepoChartLoader) getDockerKeychainAuth(
+	registryHost string,
+) (*authn.AuthConfig, error) {
+	registry, err := name.NewRegistry(registryHost)
+	if err != nil {
+		return nil, fmt.Errorf("invalid registry host %s: %w", registryHost, err)
+	}
+	authenticator, err := authn.Resolve(loader.ctx, authn.DefaultKeychain, registry)
+	if err == nil && authenticator == authn.Anonymous {
+		return nil, nil
+	}
+	var authConfig *authn.AuthConfig
+	if err == nil {
+		authConfig, err = authenticator.Authorization()
+	}
+	if err != nil {
+		loader.logger.
+			With("error", err).
+			Debug("Unable to get registry credentials from the Docker configuration")
+		return nil, nil
+	}
+	return authConfig, nil
+}
+
+// getRegistryAuth returns the credentials to log into the registry with,
+// taken from the first of the credentials file, the cloud provider's
+// automatic authentication, and the Docker configuration to have them, or nil
+// for anonymous access.  Only user names and passwords are supported, so
+// sources with tokens alone are skipped.
+func (loader *ociRepoChartLoader) getRegistryAuth(
+	repo *sourcev1.HelmRepository,
+	parsedURL *url.URL,
+) (*authn.AuthConfig, error) {
+	sources := []registryAuthSource{
+		{
+			name: "credentials file",
+			getCredentials: func() (*authn.AuthConfig, error) {
+				return loader.getCredentialsFileAuth(parsedURL)
+			},
+		},
+		{
+			name: "cloud provider",
+			getCredentials: func() (*authn.AuthConfig, error) {
+				return loader.getProviderAuth(repo, parsedURL.Host)
+			},
+		},
+		{
+			name: "Docker configuration",
+			getCredentials: func() (*authn.AuthConfig, error) {
+				return loader.getDockerKeychainAuth(parsedURL.Host)
+			},
+		},
+	}
+	for _, source := range sources {
+		authConfig, err := source.getCredentials()
+		if err != nil {
+			return nil, err
+		}
+		if authConfig != nil && (authConfig.Username != "" || authConfig.Password != "") {
+			loader.logger.
+				With("source", source.name).
+				Debug("Using registry credentials")
+			return authConfig, nil
+		}
+	}
+	loader.logger.Debug("Using anonymous registry access")
+	return nil, nil
+}
@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("OCI registry authentication", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+	var homeDir string
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+
+		var err error
+		homeDir, err = os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		// Keep the Docker and Podman configurations of the machine out of the
+		// tests.
+		for _, name := range []string{"HOME", "DOCKER_CONFIG", "REGISTRY_AUTH_FILE", "XDG_RUNTIME_DIR"} {
+			saved, found := os.LookupEnv(name)
+			ginkgo.DeferCleanup(func() {
+				if found {
+					os.Setenv(name, saved)
+				} else {
+					os.Unsetenv(name)
+				}
+			})
+			os.Unsetenv(name)
+		}
+		os.Setenv("HOME", homeDir)
+		ginkgo.DeferCleanup(os.RemoveAll, homeDir)
+	})
+
+	writeDockerConfig := func(host string, username string, password string) {
+		dockerDir := filepath.Join(homeDir, ".docker")
+		g.Expect(os.MkdirAll(dockerDir, 0700)).To(gomega.Succeed())
+		auth := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+		err := os.WriteFile(
+			filepath.Join(dockerDir, "config.json"),
+			fmt.Appendf(nil, `{"auths": {%q: {"auth": %q}}}`, host, auth),
+			0600,
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+	}
+
+	getRegistryAuth := func(credentials Credentials) *authn.AuthConfig {
+		loader := &ociRepoChartLoader{loaderConfig{
+			ctx:         ctx,
+			logger:      logger,
+			credentials: credentials,
+		}}
+		parsedURL, err := url.Parse("oci://registry.example.com/charts")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		authConfig, err := loader.getRegistryAuth(nil, parsedURL)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		return authConfig
+	}
+
+	ginkgo.It("prefers the credentials file to the Docker configuration", func() {
+		writeDockerConfig("registry.example.com", "docker-user", "docker-password")
+		authConfig := getRegistryAuth(Credentials{
+			"oci://registry.example.com/": RepositoryCreds{
+				Credentials: map[string]string{"username": "user", "password": "password"},
+			},
+		})
+		g.Expect(authConfig).ToNot(gomega.BeNil())
+		g.Expect(authConfig.Username).To(gomega.Equal("user"))
+		g.Expect(authConfig.Password).To(gomega.Equal("password"))
+	})
+
+	ginkgo.It("falls back to the Docker configuration", func() {
+		writeDockerConfig("registry.example.com", "docker-user", "docker-password")
+		authConfig := getRegistryAuth(Credentials{
+			"oci://other.example.com/": RepositoryCreds{
+				Credentials: map[string]string{"username": "user", "password": "password"},
+			},
+		})
+		g.Expect(authConfig).ToNot(gomega.BeNil())
+		g.Expect(authConfig.Username).To(gomega.Equal("docker-user"))
+		g.Expect(authConfig.Password).To(gomega.Equal("docker-password"))
+	})
+
+	ginkgo.It("accesses registries without credentials anonymously", func() {
+		writeDockerConfig("other.example.com", "docker-user", "docker-password")
+		g.Expect(getRegistryAuth(nil)).To(gomega.BeNil())
+	})
+
+	ginkgo.It("recognizes the cloud provider from the registry host", func() {
+		for host, provider := range map[string]string{
+			"123456789012.dkr.ecr.eu-west-1.amazonaws.com": "aws",
+			"public.ecr.aws":             "aws",
+			"example.azurecr.io":         "azure",
+			"europe-docker.pkg.dev":      "gcp",
+			"registry.example.com":       "",
+			"public.ecr.aws.example.com": "",
+		} {
+			g.Expect(getRepoProviderName(nil, host)).To(gomega.Equal(provider), host)
+		}
+	})
+})
@@ -0,0 +1,54 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import "sync"
+
+// tagCache keeps the tags listed for the charts in OCI registries for the
+// rest of a run, so that HelmRelease objects using the same chart don't list
+// its tags again.  It is safe for concurrent use, and a nil cache keeps
+// nothing.
+type tagCache struct {
+	mutex   sync.Mutex
+	entries map[string]*tagCacheEntry
+}
+
+type tagCacheEntry struct {
+	once sync.Once
+	tags []string
+	err  error
+}
+
+func newTagCache() *tagCache {
+	return &tagCache{entries: map[string]*tagCacheEntry{}}
+}
+
+// getTags returns the tags of the chart reference, i.e., the registry host
+// and the repository path of the chart, listing them with the function the
+// first time.  Failures are not cached, so that they are retried.
+func (cache *tagCache) getTags(
+	chartRef string,
+	listTags func() ([]string, error),
+) ([]string, error) {
+	if cache == nil {
+		return listTags()
+	}
+	cache.mutex.Lock()
+	entry, ok := cache.entries[chartRef]
+	if !ok {
+		entry = &tagCacheEntry{}
+		cache.entries[chartRef] = entry
+	}
+	cache.mutex.Unlock()
+	entry.once.Do(func() {
+		entry.tags, entry.err = listTags()
+	})
+	if entry.err != nil {
+		cache.mutex.Lock()
+		if cache.entries[chartRef] == entry {
+			delete(cache.entries, chartRef)
+		}
+		cache.mutex.Unlock()
+	}
+	return entry.tags, entry.err
+}
@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"errors"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("OCI tag cache", func() {
+	var g gomega.Gomega
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+	})
+
+	ginkgo.It("lists the tags of each chart once", func() {
+		cache := newTagCache()
+		calls := map[string]int{}
+		for _, chartRef := range []string{
+			"registry.example.com/charts/one",
+			"registry.example.com/charts/two",
+			"registry.example.com/charts/one",
+		} {
+			tags, err := cache.getTags(chartRef, func() ([]string, error) {
+				calls[chartRef]++
+				return []string{"0.1.0"}, nil
+			})
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+			g.Expect(tags).To(gomega.Equal([]string{"0.1.0"}))
+		}
+		g.Expect(calls).To(gomega.Equal(map[string]int{
+			"registry.example.com/charts/one": 1,
+			"registry.example.com/charts/two": 1,
+		}))
+	})
+
+	ginkgo.It("retries failed listings", func() {
+		cache := newTagCache()
+		calls := 0
+		listTags := func() ([]string, error) {
+			calls++
+			if calls == 1 {
+				return nil, errors.New("unavailable")
+			}
+			return []string{"0.1.0"}, nil
+		}
+		_, err := cache.getTags("registry.example.com/charts/one", listTags)
+		g.Expect(err).To(gomega.HaveOccurred())
+		tags, err := cache.getTags("registry.example.com/charts/one", listTags)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(tags).To(gomega.Equal([]string{"0.1.0"}))
+	})
+
+	ginkgo.It("lists the tags every time without a cache", func() {
+		var cache *tagCache
+		calls := 0
+		for range 2 {
+			_, err := cache.getTags("registry.example.com/charts/one", func() ([]string, error) {
+				calls++
+				return []string{"0.1.0"}, nil
+			})
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+		}
+		g.Expect(calls).To(gomega.Equal(2))
+	})
+})
@@ -0,0 +1,83 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// OutdatedRelease compares the chart version a HelmRelease resolves to with
+// the latest version of the chart in its source.
+type OutdatedRelease struct {
+	ResolvedRelease
+	// LatestVersion is the latest stable version of the chart, whether the
+	// constraint of the HelmRelease admits it or not.
+	LatestVersion string `json:"latestVersion"`
+	// Outdated tells that the latest version is newer than the resolved one,
+	// so that taking it requires changing the constraint.
+	Outdated bool `json:"outdated"`
+}
+
+// isNewerVersion tells whether the latest version is newer than the current
+// one, comparing them as strings when they are not semantic versions.
+func isNewerVersion(current string, latest string) bool {
+	currentVersion, currentErr := semver.NewVersion(current)
+	latestVersion, latestErr := semver.NewVersion(latest)
+	if currentErr != nil || latestErr != nil {
+		return current != latest
+	}
+	return latestVersion.GreaterThan(currentVersion)
+}
+
+// FindOutdatedReleases resolves the chart version of each HelmRelease in the
+// input like ResolveHelmReleases and finds the latest stable version of the
+// chart in its source, regardless of the version constraint.  Charts from
+// GitRepository objects have a single version, which is always the latest.
+func (expander *HelmReleaseExpander) FindOutdatedReleases(
+	input io.Reader,
+	options ExpandOptions,
+) ([]OutdatedRelease, error) {
+	config, releaseRepos, cleanUp, err := expander.prepareResolution(input, options)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanUp()
+
+	result := make([]OutdatedRelease, 0, len(releaseRepos))
+	for _, pair := range releaseRepos {
+		resolved, err := resolveHelmRelease(config, pair.release, pair.repo)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"unable to resolve Helm release %s/%s: %w",
+				pair.release.GetNamespace(),
+				pair.release.GetName(),
+				err,
+			)
+		}
+		latestVersion := resolved.Version
+		if resolved.SourceKind != "GitRepository" {
+			loader, err := getLoaderForRepo(pair.repo, config)
+			if err != nil {
+				return nil, err
+			}
+			latestVersion, err = loader.resolveChartVersion(pair.repo, "", resolved.Chart, "")
+			if err != nil {
+				return nil, fmt.Errorf(
+					"unable to find the latest chart version for Helm release %s/%s: %w",
+					resolved.Namespace,
+					resolved.Name,
+					err,
+				)
+			}
+		}
+		result = append(result, OutdatedRelease{
+			ResolvedRelease: *resolved,
+			LatestVersion:   latestVersion,
+			Outdated:        isNewerVersion(resolved.Version, latestVersion),
+		})
+	}
+	return result, nil
+}
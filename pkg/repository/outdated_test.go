@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Outdated releases", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+	})
+
+	ginkgo.It("finds the latest chart versions", func() {
+		repoRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(repoRoot)
+		server, port, serverDone, err := serveDirectory(repoRoot, logger, nil)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		for _, version := range []string{"0.1.0", "0.2.0", "1.0.0", "1.1.0-rc.1"} {
+			err = createChartArchiveInDir("test-chart", version, map[string]string{
+				"Chart.yaml": strings.Join([]string{
+					"apiVersion: v2",
+					"name: test-chart",
+					"version: " + version,
+				}, "\n"),
+			}, repoRoot)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+		}
+		err = indexRepository(repoRoot, port)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		getRelease := func(name string, constraint string) string {
+			return strings.Join([]string{
+				"apiVersion: helm.toolkit.fluxcd.io/v2",
+				"kind: HelmRelease",
+				"metadata:",
+				"  namespace: testns",
+				"  name: " + name,
+				"spec:",
+				"  chart:",
+				"    spec:",
+				"      chart: test-chart",
+				"      version: \"" + constraint + "\"",
+				"      sourceRef:",
+				"        kind: HelmRepository",
+				"        name: local",
+			}, "\n")
+		}
+		repoURL := fmt.Sprintf("http://localhost:%d", port)
+		input := strings.Join([]string{
+			getRelease("pinned", "0.1.0"),
+			getRelease("current", ">=0.1.0"),
+			strings.Join([]string{
+				"apiVersion: source.toolkit.fluxcd.io/v1",
+				"kind: HelmRepository",
+				"metadata:",
+				"  namespace: testns",
+				"  name: local",
+				"spec:",
+				"  url: " + repoURL,
+			}, "\n"),
+		}, "\n---\n")
+
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		releases, err := expander.FindOutdatedReleases(
+			bytes.NewBufferString(input),
+			ExpandOptions{},
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		err = stopServing(server, serverDone)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(releases).To(gomega.HaveLen(2))
+		g.Expect(releases[0].Name).To(gomega.Equal("pinned"))
+		g.Expect(releases[0].Version).To(gomega.Equal("0.1.0"))
+		g.Expect(releases[0].LatestVersion).To(gomega.Equal("1.0.0"))
+		g.Expect(releases[0].Outdated).To(gomega.BeTrue())
+		g.Expect(releases[1].Name).To(gomega.Equal("current"))
+		g.Expect(releases[1].Version).To(gomega.Equal("1.0.0"))
+		g.Expect(releases[1].LatestVersion).To(gomega.Equal("1.0.0"))
+		g.Expect(releases[1].Outdated).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("compares versions", func() {
+		g.Expect(isNewerVersion("1.0.0", "1.1.0")).To(gomega.BeTrue())
+		g.Expect(isNewerVersion("1.1.0", "1.1.0")).To(gomega.BeFalse())
+		g.Expect(isNewerVersion("1.2.0-rc.1", "1.1.0")).To(gomega.BeFalse())
+		g.Expect(isNewerVersion("abc", "def")).To(gomega.BeTrue())
+	})
+})
@@ -0,0 +1,277 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	yamlv3 "gopkg.in/yaml.v3"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// KustomizationFileName is the name of the kustomization file written into
+// the output directory.
+const KustomizationFileName = "kustomization.yaml"
+
+// OutputSplit groups the objects written into the output directory into
+// files.
+type OutputSplit string
+
+const (
+	// OutputSplitObject writes each object into a file of its own.
+	OutputSplitObject OutputSplit = "object"
+	// OutputSplitNamespace writes the objects in each namespace into
+	// <namespace>.yaml, and the objects without namespaces into
+	// _cluster.yaml.
+	OutputSplitNamespace OutputSplit = "namespace"
+	// OutputSplitRelease writes the objects rendered from each HelmRelease
+	// into <namespace>/<name>.yaml of the HelmRelease, and the other objects
+	// into _input.yaml.
+	OutputSplitRelease OutputSplit = "release"
+	// OutputSplitKind writes the objects of each kind into <kind>.yaml.
+	OutputSplitKind OutputSplit = "kind"
+)
+
+const (
+	// clusterFileName is the name of the file of the objects without
+	// namespaces, which cannot clash with namespaces, as they cannot contain
+	// underscores.
+	clusterFileName = "_cluster.yaml"
+	// inputFileName is the name of the file of the objects not rendered from
+	// HelmRelease objects.
+	inputFileName = "_input.yaml"
+)
+
+// ParseOutputSplit returns the output split named by the value.
+func ParseOutputSplit(value string) (OutputSplit, error) {
+	switch split := OutputSplit(value); split {
+	case OutputSplitObject, OutputSplitNamespace, OutputSplitRelease, OutputSplitKind:
+		return split, nil
+	default:
+		return "", fmt.Errorf(
+			"invalid output split %s, expected %s, %s, %s, or %s",
+			value,
+			OutputSplitObject,
+			OutputSplitNamespace,
+			OutputSplitRelease,
+			OutputSplitKind,
+		)
+	}
+}
+
+var fileNameUnsafeCharsRegex = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+// kustomization is the kustomization file listing the files of the output
+// directory.
+type kustomization struct {
+	APIVersion string               `yaml:"apiVersion"`
+	Kind       string               `yaml:"kind"`
+	Labels     []kustomizationLabel `yaml:"labels,omitempty"`
+	Resources  []string             `yaml:"resources"`
+}
+
+type kustomizationLabel struct {
+	Pairs map[string]string `yaml:"pairs"`
+	// IncludeSelectors is always false, as changing the selectors of rendered
+	// workloads would change their immutable fields.
+	IncludeSelectors bool `yaml:"includeSelectors"`
+}
+
+// directoryWriter writes each node into a file of its own in the directory,
+// named after the namespace, the kind, and the name of the object, or into
+// the file of its group, as the split says.
+type directoryWriter struct {
+	dir   string
+	split OutputSplit
+	// newWriter returns the writer of the nodes into a file.
+	newWriter func(output io.Writer) kio.Writer
+	// files are the paths of the written files relative to the directory,
+	// in the order they were written in.
+	files   []string
+	written map[string]bool
+	// used are the paths taken by objects when writing each object into a
+	// file of its own.
+	used map[string]bool
+	// releases maps the nodes rendered from HelmRelease objects to the paths
+	// of the files of the HelmRelease objects when splitting by release.
+	releases map[*yaml.Node]string
+}
+
+func newDirectoryWriter(
+	dir string,
+	split OutputSplit,
+	newWriter func(output io.Writer) kio.Writer,
+) *directoryWriter {
+	return &directoryWriter{
+		dir:       dir,
+		split:     split,
+		newWriter: newWriter,
+		written:   map[string]bool{},
+		used:      map[string]bool{},
+		releases:  map[*yaml.Node]string{},
+	}
+}
+
+// recordRelease records the release the nodes were rendered from, for the
+// split by release.
+func (writer *directoryWriter) recordRelease(nodes []*yaml.RNode, release *ExpandedRelease) {
+	if writer == nil || writer.split != OutputSplitRelease {
+		return
+	}
+	name := path.Join(
+		cmp.Or(getSafeFileName(release.Namespace), "default"),
+		cmp.Or(getSafeFileName(release.Name), "unnamed"),
+	) + ".yaml"
+	for _, node := range nodes {
+		writer.releases[node.YNode()] = name
+	}
+}
+
+// getSafeFileName returns the name in lower case with the characters other
+// than letters, digits, dots, dashes, and underscores replaced with dashes.
+func getSafeFileName(name string) string {
+	return strings.Trim(fileNameUnsafeCharsRegex.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+// getFileName returns an unused path for the node relative to the directory:
+// <namespace>/<kind>-<name>.yaml, or <kind>-<name>.yaml for objects without
+// namespaces.
+func (writer *directoryWriter) getFileName(node *yaml.RNode) string {
+	base := cmp.Or(getSafeFileName(node.GetKind()), "object") + "-" +
+		cmp.Or(getSafeFileName(node.GetName()), "unnamed")
+	if namespace := getSafeFileName(node.GetNamespace()); namespace != "" {
+		base = path.Join(namespace, base)
+	}
+	name := base + ".yaml"
+	for i := 2; writer.used[name]; i++ {
+		name = fmt.Sprintf("%s-%d.yaml", base, i)
+	}
+	writer.used[name] = true
+	return name
+}
+
+// getGroupFileName returns the path of the file of the group of the node
+// relative to the directory.
+func (writer *directoryWriter) getGroupFileName(node *yaml.RNode) string {
+	switch writer.split {
+	case OutputSplitNamespace:
+		if namespace := getSafeFileName(node.GetNamespace()); namespace != "" {
+			return namespace + ".yaml"
+		}
+		return clusterFileName
+	case OutputSplitRelease:
+		return cmp.Or(writer.releases[node.YNode()], inputFileName)
+	default:
+		return cmp.Or(getSafeFileName(node.GetKind()), "object") + ".yaml"
+	}
+}
+
+func (writer *directoryWriter) Write(nodes []*yaml.RNode) error {
+	if writer.split == OutputSplitObject {
+		for _, node := range nodes {
+			name := writer.getFileName(node)
+			if err := writer.writeFile(name, []*yaml.RNode{node}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Streamed expansions write the nodes in batches, which are appended to
+	// the files of their groups.
+	var names []string
+	groups := map[string][]*yaml.RNode{}
+	for _, node := range nodes {
+		name := writer.getGroupFileName(node)
+		if _, ok := groups[name]; !ok {
+			names = append(names, name)
+		}
+		groups[name] = append(groups[name], node)
+	}
+	for _, name := range names {
+		if err := writer.writeFile(name, groups[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFile writes the nodes into the file at the path relative to the
+// directory, replacing the file left from earlier runs, if any, or appending
+// to the file written earlier in the run.
+func (writer *directoryWriter) writeFile(name string, nodes []*yaml.RNode) error {
+	fileName := filepath.Join(writer.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return fmt.Errorf("unable to create output directory: %w", err)
+	}
+	appending := writer.written[name]
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appending {
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+	file, err := os.OpenFile(fileName, flags, 0666)
+	if err != nil {
+		return fmt.Errorf("unable to create output file %s: %w", fileName, err)
+	}
+	if appending {
+		if _, err := io.WriteString(file, "---\n"); err != nil {
+			file.Close()
+			return fmt.Errorf("unable to write output file %s: %w", fileName, err)
+		}
+	}
+	if err := writer.newWriter(file).Write(nodes); err != nil {
+		file.Close()
+		return fmt.Errorf("unable to write output file %s: %w", fileName, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("unable to write output file %s: %w", fileName, err)
+	}
+	if !appending {
+		writer.files = append(writer.files, name)
+		writer.written[name] = true
+	}
+	return nil
+}
+
+// writeKustomization writes the kustomization file listing the written files
+// into the directory, adding the labels to all of the objects if there are
+// any.
+func (writer *directoryWriter) writeKustomization(labels map[string]string) error {
+	content := kustomization{
+		APIVersion: "kustomize.config.k8s.io/v1beta1",
+		Kind:       "Kustomization",
+		Resources:  writer.files,
+	}
+	if content.Resources == nil {
+		content.Resources = []string{}
+	}
+	if len(labels) > 0 {
+		content.Labels = []kustomizationLabel{{Pairs: labels}}
+	}
+	if err := os.MkdirAll(writer.dir, 0755); err != nil {
+		return fmt.Errorf("unable to create output directory: %w", err)
+	}
+	fileName := filepath.Join(writer.dir, KustomizationFileName)
+	file, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("unable to create kustomization file: %w", err)
+	}
+	defer file.Close()
+	encoder := yamlv3.NewEncoder(file)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(content); err != nil {
+		return fmt.Errorf("unable to write kustomization file: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return fmt.Errorf("unable to write kustomization file: %w", err)
+	}
+	return file.Close()
+}
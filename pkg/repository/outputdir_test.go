@@ -0,0 +1,195 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+)
+
+var _ = ginkgo.Describe("Output directory", func() {
+	var g gomega.Gomega
+	var expander *HelmReleaseExpander
+	var dir string
+
+	input := strings.Join([]string{
+		"apiVersion: v1",
+		"kind: ConfigMap",
+		"metadata:",
+		"  namespace: testns",
+		"  name: settings",
+		"---",
+		"apiVersion: rbac.authorization.k8s.io/v1",
+		"kind: ClusterRole",
+		"metadata:",
+		"  name: system:reader",
+		"---",
+		"apiVersion: v1",
+		"kind: ConfigMap",
+		"metadata:",
+		"  namespace: testns",
+		"  name: Settings",
+		"",
+	}, "\n")
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		logger := slog.New(slog.NewTextHandler(ginkgo.GinkgoWriter, nil))
+		expander = NewHelmReleaseExpander(context.Background(), logger, nil, nil)
+		dir = filepath.Join(ginkgo.GinkgoT().TempDir(), "output")
+	})
+
+	expand := func(options ExpandOptions) string {
+		var output bytes.Buffer
+		options.OutputDir = dir
+		err := expander.Expand(bytes.NewBufferString(input), &output, options)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		return output.String()
+	}
+
+	readFile := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		return string(data)
+	}
+
+	ginkgo.It("writes each object into a file of its own", func() {
+		g.Expect(expand(ExpandOptions{SortOrder: SortOrderNone})).To(gomega.BeEmpty())
+		g.Expect(readFile("testns/configmap-settings.yaml")).To(gomega.Equal(strings.Join([]string{
+			"apiVersion: v1",
+			"kind: ConfigMap",
+			"metadata:",
+			"  namespace: testns",
+			"  name: settings",
+			"",
+		}, "\n")))
+		g.Expect(readFile("testns/configmap-settings-2.yaml")).To(
+			gomega.ContainSubstring("name: Settings\n"),
+		)
+		g.Expect(readFile("clusterrole-system-reader.yaml")).To(
+			gomega.ContainSubstring("name: system:reader\n"),
+		)
+		_, err := os.Stat(filepath.Join(dir, KustomizationFileName))
+		g.Expect(os.IsNotExist(err)).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("writes a kustomization listing the files", func() {
+		expand(ExpandOptions{
+			SortOrder:           SortOrderNone,
+			Kustomization:       true,
+			KustomizationLabels: map[string]string{"team": "platform"},
+		})
+		g.Expect(readFile(KustomizationFileName)).To(gomega.Equal(strings.Join([]string{
+			"apiVersion: kustomize.config.k8s.io/v1beta1",
+			"kind: Kustomization",
+			"labels:",
+			"  - pairs:",
+			"      team: platform",
+			"    includeSelectors: false",
+			"resources:",
+			"  - testns/configmap-settings.yaml",
+			"  - clusterrole-system-reader.yaml",
+			"  - testns/configmap-settings-2.yaml",
+			"",
+		}, "\n")))
+	})
+
+	ginkgo.It("writes the objects of each namespace into a file", func() {
+		expand(ExpandOptions{
+			SortOrder:     SortOrderNone,
+			OutputSplit:   OutputSplitNamespace,
+			Kustomization: true,
+		})
+		g.Expect(readFile("testns.yaml")).To(gomega.Equal(strings.Join([]string{
+			"apiVersion: v1",
+			"kind: ConfigMap",
+			"metadata:",
+			"  namespace: testns",
+			"  name: settings",
+			"---",
+			"apiVersion: v1",
+			"kind: ConfigMap",
+			"metadata:",
+			"  namespace: testns",
+			"  name: Settings",
+			"",
+		}, "\n")))
+		g.Expect(readFile("_cluster.yaml")).To(
+			gomega.ContainSubstring("name: system:reader\n"),
+		)
+		g.Expect(readFile(KustomizationFileName)).To(gomega.HaveSuffix(strings.Join([]string{
+			"resources:",
+			"  - testns.yaml",
+			"  - _cluster.yaml",
+			"",
+		}, "\n")))
+	})
+
+	ginkgo.It("writes the objects of each kind into a file", func() {
+		expand(ExpandOptions{SortOrder: SortOrderNone, OutputSplit: OutputSplitKind})
+		g.Expect(readFile("configmap.yaml")).To(gomega.ContainSubstring("name: Settings\n"))
+		g.Expect(readFile("clusterrole.yaml")).To(
+			gomega.ContainSubstring("name: system:reader\n"),
+		)
+	})
+
+	ginkgo.It("writes the objects rendered from each release into a file", func() {
+		nodes, err := (&kio.ByteReader{
+			Reader:                bytes.NewBufferString(input),
+			OmitReaderAnnotations: true,
+		}).Read()
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		writer := newDirectoryWriter(
+			dir,
+			OutputSplitRelease,
+			func(output io.Writer) kio.Writer { return kio.ByteWriter{Writer: output} },
+		)
+		writer.recordRelease(nodes[1:], &ExpandedRelease{Namespace: "apps", Name: "web"})
+		// Streamed expansions write the objects in batches.
+		g.Expect(writer.Write(nodes[:2])).To(gomega.Succeed())
+		g.Expect(writer.Write(nodes[2:])).To(gomega.Succeed())
+
+		g.Expect(readFile("_input.yaml")).To(gomega.ContainSubstring("name: settings\n"))
+		g.Expect(readFile("apps/web.yaml")).To(gomega.Equal(strings.Join([]string{
+			"apiVersion: rbac.authorization.k8s.io/v1",
+			"kind: ClusterRole",
+			"metadata:",
+			"  name: system:reader",
+			"---",
+			"apiVersion: v1",
+			"kind: ConfigMap",
+			"metadata:",
+			"  namespace: testns",
+			"  name: Settings",
+			"",
+		}, "\n")))
+		g.Expect(writer.files).To(gomega.Equal([]string{"_input.yaml", "apps/web.yaml"}))
+	})
+
+	ginkgo.It("requires the output directory for splitting the output", func() {
+		err := expander.Expand(
+			bytes.NewBufferString(input),
+			&bytes.Buffer{},
+			ExpandOptions{OutputSplit: OutputSplitKind},
+		)
+		g.Expect(err).To(gomega.HaveOccurred())
+		g.Expect(GetErrorClass(err)).To(gomega.Equal(ErrorClassInput))
+	})
+
+	ginkgo.It("requires the output directory for the kustomization", func() {
+		err := expander.Expand(
+			bytes.NewBufferString(input),
+			&bytes.Buffer{},
+			ExpandOptions{Kustomization: true},
+		)
+		g.Expect(err).To(gomega.HaveOccurred())
+		g.Expect(GetErrorClass(err)).To(gomega.Equal(ErrorClassInput))
+	})
+})
@@ -0,0 +1,110 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"helm.sh/helm/v4/pkg/strvals"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// ValuesOverride holds extra values to apply on top of spec.values of the
+// HelmRelease objects it matches, for what-if renders without editing the
+// manifests.  It matches either the HelmRelease with the given namespace and
+// name or the HelmRelease objects matching the label selector.
+type ValuesOverride struct {
+	Namespace string
+	Name      string
+	Selector  labels.Selector
+	Values    map[string]any
+}
+
+// parseOverrideTarget splits an override into the target HelmRelease objects,
+// given either as <namespace>/<name> or as a label selector, and the rest of
+// the override.  Neither label keys nor label values contain colons, so the
+// target ends at the first colon.
+func parseOverrideTarget(override string) (*ValuesOverride, string, error) {
+	target, rest, found := strings.Cut(override, ":")
+	if !found || target == "" {
+		return nil, "", fmt.Errorf("missing target Helm releases in %s", override)
+	}
+	if strings.Contains(target, "/") && !strings.ContainsAny(target, "=!,() ") {
+		namespace, name, _ := strings.Cut(target, "/")
+		if namespace == "" || name == "" || strings.Contains(name, "/") {
+			return nil, "", fmt.Errorf(
+				"invalid Helm release reference %s, expected <namespace>/<name>",
+				target,
+			)
+		}
+		return &ValuesOverride{Namespace: namespace, Name: name}, rest, nil
+	}
+	selector, err := labels.Parse(target)
+	if err != nil {
+		return nil, "", fmt.Errorf(
+			"unable to parse label selector %s: %w",
+			target,
+			err,
+		)
+	}
+	return &ValuesOverride{Selector: selector}, rest, nil
+}
+
+// ParseSetOverride parses an override in the form <target>:<key>=<value>,
+// where the values use the syntax of the helm --set option and the target is
+// either <namespace>/<name> of a HelmRelease or a label selector.
+func ParseSetOverride(override string) (*ValuesOverride, error) {
+	result, values, err := parseOverrideTarget(override)
+	if err != nil {
+		return nil, err
+	}
+	result.Values = map[string]any{}
+	if err := strvals.ParseInto(values, result.Values); err != nil {
+		return nil, fmt.Errorf("unable to parse values %s: %w", values, err)
+	}
+	return result, nil
+}
+
+// ParseValuesOverlay parses an override in the form <target>:<file>, reading
+// the values from the YAML file.  The target is either <namespace>/<name> of a
+// HelmRelease or a label selector.
+func ParseValuesOverlay(overlay string) (*ValuesOverride, error) {
+	result, fileName, err := parseOverrideTarget(overlay)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read values file %s: %w", fileName, err)
+	}
+	result.Values, err = parseValues(data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse values file %s: %w", fileName, err)
+	}
+	return result, nil
+}
+
+func (override *ValuesOverride) matches(release *helmv2.HelmRelease) bool {
+	if override.Selector != nil {
+		return override.Selector.Matches(labels.Set(release.Labels))
+	}
+	return release.Namespace == override.Namespace && release.Name == override.Name
+}
+
+// getOverriddenValues returns the values of the release with the matching
+// overrides merged on top in order.
+func getOverriddenValues(
+	release *helmv2.HelmRelease,
+	values map[string]any,
+	overrides []*ValuesOverride,
+) map[string]any {
+	for _, override := range overrides {
+		if override.matches(release) {
+			values = mergeValues(values, override.Values)
+		}
+	}
+	return values
+}
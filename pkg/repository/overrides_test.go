@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Values overrides", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+	})
+
+	ginkgo.It("parses overrides of a single release", func() {
+		override, err := ParseSetOverride("apps/web:image.tag=2.0,replicas=3")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(override.Namespace).To(gomega.Equal("apps"))
+		g.Expect(override.Name).To(gomega.Equal("web"))
+		g.Expect(override.Selector).To(gomega.BeNil())
+		g.Expect(override.Values).To(gomega.Equal(map[string]any{
+			"image":    map[string]any{"tag": "2.0"},
+			"replicas": int64(3),
+		}))
+	})
+
+	ginkgo.It("parses overrides of releases matching a selector", func() {
+		override, err := ParseSetOverride("app.kubernetes.io/part-of=shop:debug=true")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(override.Selector.String()).To(gomega.Equal(
+			"app.kubernetes.io/part-of=shop",
+		))
+
+		release := &helmv2.HelmRelease{}
+		release.Labels = map[string]string{"app.kubernetes.io/part-of": "shop"}
+		g.Expect(override.matches(release)).To(gomega.BeTrue())
+		release.Labels = nil
+		g.Expect(override.matches(release)).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("rejects overrides without a target", func() {
+		_, err := ParseSetOverride("image.tag=2.0")
+		g.Expect(err).To(gomega.MatchError(
+			"missing target Helm releases in image.tag=2.0",
+		))
+	})
+
+	ginkgo.It("renders releases with overridden values", func() {
+		repoRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(repoRoot)
+		server, port, serverDone, err := serveDirectory(repoRoot, logger, nil)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer func() {
+			err := stopServing(server, serverDone)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+		}()
+
+		err = createSingleChartHelmRepository(
+			"test-chart",
+			"0.1.0",
+			map[string]string{
+				"Chart.yaml": strings.Join([]string{
+					"apiVersion: v2",
+					"name: test-chart",
+					"version: 0.1.0",
+				}, "\n"),
+				"templates/configmap.yaml": strings.Join([]string{
+					"apiVersion: v1",
+					"kind: ConfigMap",
+					"metadata:",
+					"  name: {{ .Release.Name }}-configmap",
+					"data:",
+					"  foo: {{ .Values.foo }}",
+					"  bar: {{ .Values.bar }}",
+				}, "\n"),
+			},
+			port,
+			repoRoot,
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		overlayFile, err := os.CreateTemp(repoRoot, "overlay-*.yaml")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		_, err = overlayFile.WriteString("foo: overlay\nbar: overlay\n")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(overlayFile.Close()).To(gomega.Succeed())
+
+		overlay, err := ParseValuesOverlay("testns/test:" + overlayFile.Name())
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		set, err := ParseSetOverride("testns/test:bar=set")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		other, err := ParseSetOverride("testns/other:foo=other")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		input := strings.Join([]string{
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"spec:",
+			"  chart:",
+			"    spec:",
+			"      chart: test-chart",
+			"      sourceRef:",
+			"        kind: HelmRepository",
+			"        name: local",
+			"  values:",
+			"    foo: release",
+			"---",
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: HelmRepository",
+			"metadata:",
+			"  namespace: testns",
+			"  name: local",
+			"spec:",
+			fmt.Sprintf("  url: http://localhost:%d", port),
+		}, "\n")
+
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		var output bytes.Buffer
+		err = expander.Expand(
+			bytes.NewBufferString(input),
+			&output,
+			ExpandOptions{
+				ValuesOverrides: []*ValuesOverride{overlay, set, other},
+			},
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(output.String()).To(gomega.ContainSubstring(strings.Join([]string{
+			"data:",
+			"  foo: overlay",
+			"  bar: set",
+		}, "\n")))
+	})
+})
@@ -0,0 +1,43 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"fmt"
+
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const (
+	// ReleaseNameLabel is the label helm-controller sets to the name of the
+	// HelmRelease on all of the resources of the releases it installs.
+	ReleaseNameLabel = "helm.toolkit.fluxcd.io/name"
+	// ReleaseNamespaceLabel is the label helm-controller sets to the
+	// namespace of the HelmRelease on all of the resources of the releases it
+	// installs.
+	ReleaseNamespaceLabel = "helm.toolkit.fluxcd.io/namespace"
+)
+
+// addOwnerLabels sets the labels helm-controller adds to the resources of the
+// HelmRelease on the rendered nodes, so that they match the objects in the
+// cluster.
+func addOwnerLabels(nodes []*yaml.RNode, release *ExpandedRelease) error {
+	for _, node := range nodes {
+		err := node.PipeE(yaml.SetLabel(ReleaseNameLabel, release.Name))
+		if err == nil {
+			err = node.PipeE(yaml.SetLabel(ReleaseNamespaceLabel, release.Namespace))
+		}
+		if err != nil {
+			return fmt.Errorf(
+				"unable to label %s %s/%s rendered from Helm release %s/%s: %w",
+				node.GetKind(),
+				node.GetNamespace(),
+				node.GetName(),
+				release.Namespace,
+				release.Name,
+				err,
+			)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+var _ = ginkgo.Describe("Owner labels", func() {
+	var g gomega.Gomega
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+	})
+
+	ginkgo.It("labels rendered objects with their HelmRelease", func() {
+		node := yaml.MustParse(strings.Join([]string{
+			"apiVersion: v1",
+			"kind: ConfigMap",
+			"metadata:",
+			"  namespace: apps",
+			"  name: settings",
+			"  labels:",
+			"    app: web",
+		}, "\n"))
+		err := addOwnerLabels(
+			[]*yaml.RNode{node},
+			&ExpandedRelease{Namespace: "flux-system", Name: "web"},
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(node.GetLabels()).To(gomega.Equal(map[string]string{
+			"app":                              "web",
+			"helm.toolkit.fluxcd.io/name":      "web",
+			"helm.toolkit.fluxcd.io/namespace": "flux-system",
+		}))
+	})
+})
@@ -0,0 +1,376 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/ext"
+	"gopkg.in/yaml.v3"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// PolicyMatch selects the resources a policy applies to.  Empty lists match
+// all resources.
+type PolicyMatch struct {
+	APIVersions []string `yaml:"apiVersions"`
+	Kinds       []string `yaml:"kinds"`
+}
+
+// PolicyValidation is a CEL expression which has to evaluate to true for the
+// resources matching the policy, like the validations of Kubernetes
+// ValidatingAdmissionPolicy objects.  The violation is reported with the
+// result of MessageExpression, if set, or with Message.
+type PolicyValidation struct {
+	Expression        string `yaml:"expression"`
+	Message           string `yaml:"message"`
+	MessageExpression string `yaml:"messageExpression"`
+
+	program        cel.Program
+	messageProgram cel.Program
+}
+
+// Policy checks resources rendered from HelmRelease objects with CEL
+// validations.  The expressions have the resource as object, the HelmRelease
+// it was rendered from as release (with namespace, name, chart, and
+// chartVersion), and the chart template path as template.
+type Policy struct {
+	Name        string             `yaml:"name"`
+	Description string             `yaml:"description"`
+	Match       PolicyMatch        `yaml:"match"`
+	Validations []PolicyValidation `yaml:"validations"`
+}
+
+// PolicyViolation reports a resource rendered from a HelmRelease violating a
+// policy.
+type PolicyViolation struct {
+	Policy           string `json:"policy"`
+	Message          string `json:"message"`
+	APIVersion       string `json:"apiVersion"`
+	Kind             string `json:"kind"`
+	Namespace        string `json:"namespace,omitempty"`
+	Name             string `json:"name"`
+	ReleaseNamespace string `json:"releaseNamespace"`
+	ReleaseName      string `json:"releaseName"`
+	Chart            string `json:"chart"`
+	ChartVersion     string `json:"chartVersion"`
+	Template         string `json:"template"`
+}
+
+func (violation PolicyViolation) String() string {
+	resource := violation.Kind + " " + violation.Name
+	if violation.Namespace != "" {
+		resource = violation.Kind + " " + violation.Namespace + "/" + violation.Name
+	}
+	return fmt.Sprintf(
+		"%s: %s (%s, template %s of chart %s %s, Helm release %s/%s)",
+		violation.Policy,
+		violation.Message,
+		resource,
+		violation.Template,
+		violation.Chart,
+		violation.ChartVersion,
+		violation.ReleaseNamespace,
+		violation.ReleaseName,
+	)
+}
+
+// newPolicyEnv returns the CEL environment of policy expressions.
+func newPolicyEnv() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("object", cel.DynType),
+		cel.Variable("release", cel.MapType(cel.StringType, cel.StringType)),
+		cel.Variable("template", cel.StringType),
+		ext.Strings(),
+	)
+}
+
+// compilePolicyExpression compiles the CEL expression, which has to evaluate
+// to the output type.
+func compilePolicyExpression(
+	env *cel.Env,
+	expression string,
+	outputType *cel.Type,
+) (cel.Program, error) {
+	ast, issues := env.Compile(expression)
+	if issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if !ast.OutputType().IsExactType(outputType) && !ast.OutputType().IsExactType(cel.DynType) {
+		return nil, fmt.Errorf(
+			"expression evaluates to %s instead of %s",
+			ast.OutputType(),
+			outputType,
+		)
+	}
+	return env.Program(ast)
+}
+
+// compile compiles the expressions of the policy validations.
+func (policy *Policy) compile(env *cel.Env) error {
+	for i := range policy.Validations {
+		validation := &policy.Validations[i]
+		if validation.Expression == "" {
+			return fmt.Errorf("validation %d has no expression", i)
+		}
+		var err error
+		validation.program, err = compilePolicyExpression(
+			env,
+			validation.Expression,
+			cel.BoolType,
+		)
+		if err != nil {
+			return fmt.Errorf("invalid expression %q: %w", validation.Expression, err)
+		}
+		if validation.MessageExpression != "" {
+			validation.messageProgram, err = compilePolicyExpression(
+				env,
+				validation.MessageExpression,
+				cel.StringType,
+			)
+			if err != nil {
+				return fmt.Errorf(
+					"invalid message expression %q: %w",
+					validation.MessageExpression,
+					err,
+				)
+			}
+		}
+	}
+	return nil
+}
+
+// ReadPolicies reads the policies from all YAML files in the directory.  A
+// file can hold multiple policies as separate YAML documents.
+func ReadPolicies(dir string) ([]*Policy, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read policy directory %s: %w", dir, err)
+	}
+	env, err := newPolicyEnv()
+	if err != nil {
+		return nil, fmt.Errorf("unable to create CEL environment: %w", err)
+	}
+
+	policies := []*Policy{}
+	for _, entry := range entries {
+		extension := filepath.Ext(entry.Name())
+		if entry.IsDir() || (extension != ".yaml" && extension != ".yml") {
+			continue
+		}
+		fileName := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read policy file %s: %w", fileName, err)
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		for {
+			policy := &Policy{}
+			err := decoder.Decode(policy)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse policy file %s: %w", fileName, err)
+			}
+			if policy.Name == "" || len(policy.Validations) == 0 {
+				return nil, fmt.Errorf(
+					"policy in %s must have a name and validations",
+					fileName,
+				)
+			}
+			if err := policy.compile(env); err != nil {
+				return nil, fmt.Errorf("unable to compile policy %s: %w", policy.Name, err)
+			}
+			policies = append(policies, policy)
+		}
+	}
+	return policies, nil
+}
+
+func (policy *Policy) matches(node *kyaml.RNode) bool {
+	return (len(policy.Match.APIVersions) == 0 ||
+		slices.Contains(policy.Match.APIVersions, node.GetApiVersion())) &&
+		(len(policy.Match.Kinds) == 0 ||
+			slices.Contains(policy.Match.Kinds, node.GetKind()))
+}
+
+// evaluate returns the messages of the violations of the policy by the node.
+func (policy *Policy) evaluate(
+	release *ExpandedRelease,
+	templatePath string,
+	node *kyaml.RNode,
+) ([]string, error) {
+	object, err := node.Map()
+	if err != nil {
+		return nil, fmt.Errorf("unable to convert resource to a map: %w", err)
+	}
+	variables := map[string]any{
+		"object": object,
+		"release": map[string]string{
+			"namespace":    release.Namespace,
+			"name":         release.Name,
+			"chart":        release.Chart,
+			"chartVersion": release.ChartVersion,
+		},
+		"template": templatePath,
+	}
+
+	messages := []string{}
+	for _, validation := range policy.Validations {
+		result, _, err := validation.program.Eval(variables)
+		if err != nil {
+			return nil, fmt.Errorf("unable to evaluate %q: %w", validation.Expression, err)
+		}
+		valid, ok := result.Value().(bool)
+		if !ok {
+			return nil, fmt.Errorf(
+				"expression %q evaluated to %v instead of a boolean",
+				validation.Expression,
+				result.Value(),
+			)
+		}
+		if valid {
+			continue
+		}
+		message, err := validation.getMessage(variables)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+// getMessage returns the message reporting the failure of the validation.
+func (validation *PolicyValidation) getMessage(variables map[string]any) (string, error) {
+	if validation.messageProgram != nil {
+		result, _, err := validation.messageProgram.Eval(variables)
+		if err != nil {
+			return "", fmt.Errorf(
+				"unable to evaluate %q: %w",
+				validation.MessageExpression,
+				err,
+			)
+		}
+		if message, ok := result.Value().(string); ok && strings.TrimSpace(message) != "" {
+			return strings.TrimSpace(message), nil
+		}
+	}
+	if validation.Message != "" {
+		return validation.Message, nil
+	}
+	return fmt.Sprintf("failed expression: %s", validation.Expression), nil
+}
+
+// PolicyChecker evaluates policies against the resources rendered from
+// HelmRelease objects.  Its Check method is meant to be passed as
+// ExpandOptions.OnReleaseExpanded.
+type PolicyChecker struct {
+	policies        []*Policy
+	kyvernoPolicies []*KyvernoPolicy
+	violations      []PolicyViolation
+	mutex           sync.Mutex
+}
+
+func NewPolicyChecker(policies []*Policy) *PolicyChecker {
+	return &PolicyChecker{policies: policies}
+}
+
+// WithKyvernoPolicies adds Kyverno policies to check the resources against.
+// Violations of Kyverno policy rules are reported with the policy name
+// <policy>/<rule>.
+func (checker *PolicyChecker) WithKyvernoPolicies(
+	policies []*KyvernoPolicy,
+) *PolicyChecker {
+	checker.kyvernoPolicies = append(checker.kyvernoPolicies, policies...)
+	return checker
+}
+
+// Check evaluates the policies against the resources rendered from the
+// release.  Failures to evaluate a policy are reported as violations.
+func (checker *PolicyChecker) Check(release *ExpandedRelease) {
+	violations := []PolicyViolation{}
+	for _, node := range release.Resources {
+		templatePath := strings.TrimPrefix(node.YNode().HeadComment, "Source: ")
+		newViolation := func(policy string, message string) PolicyViolation {
+			return PolicyViolation{
+				Policy:           policy,
+				Message:          message,
+				APIVersion:       node.GetApiVersion(),
+				Kind:             node.GetKind(),
+				Namespace:        node.GetNamespace(),
+				Name:             node.GetName(),
+				ReleaseNamespace: release.Namespace,
+				ReleaseName:      release.Name,
+				Chart:            release.Chart,
+				ChartVersion:     release.ChartVersion,
+				Template:         templatePath,
+			}
+		}
+
+		for _, policy := range checker.policies {
+			if !policy.matches(node) {
+				continue
+			}
+			messages, err := policy.evaluate(release, templatePath, node)
+			if err != nil {
+				messages = []string{fmt.Sprintf("unable to evaluate policy: %v", err)}
+			}
+			for _, message := range messages {
+				violations = append(violations, newViolation(policy.Name, message))
+			}
+		}
+
+		for _, policy := range checker.kyvernoPolicies {
+			failures, err := policy.evaluate(release, node)
+			if err != nil {
+				violations = append(violations, newViolation(
+					policy.Name,
+					fmt.Sprintf("unable to evaluate policy: %v", err),
+				))
+			}
+			for _, failure := range failures {
+				violations = append(violations, newViolation(
+					policy.Name+"/"+failure.rule,
+					failure.message,
+				))
+			}
+		}
+	}
+
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+	checker.violations = append(checker.violations, violations...)
+}
+
+// Violations returns the violations found so far, ordered by the HelmRelease
+// and the resource.
+func (checker *PolicyChecker) Violations() []PolicyViolation {
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+
+	result := slices.Clone(checker.violations)
+	slices.SortStableFunc(result, func(a, b PolicyViolation) int {
+		return cmp.Or(
+			cmp.Compare(a.ReleaseNamespace, b.ReleaseNamespace),
+			cmp.Compare(a.ReleaseName, b.ReleaseName),
+			cmp.Compare(a.Kind, b.Kind),
+			cmp.Compare(a.Namespace, b.Namespace),
+			cmp.Compare(a.Name, b.Name),
+			cmp.Compare(a.Policy, b.Policy),
+		)
+	})
+	return result
+}
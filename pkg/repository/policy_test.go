@@ -0,0 +1,164 @@
+package repository
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+)
+
+var _ = ginkgo.Describe("Policies", func() {
+	var g gomega.Gomega
+	var policyDir string
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		var err error
+		policyDir, err = os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+	})
+
+	ginkgo.AfterEach(func() {
+		g.Expect(os.RemoveAll(policyDir)).To(gomega.Succeed())
+	})
+
+	writePolicies := func(content ...string) {
+		err := os.WriteFile(
+			filepath.Join(policyDir, "policies.yaml"),
+			[]byte(strings.Join(content, "\n")),
+			0600,
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+	}
+
+	getRelease := func() *ExpandedRelease {
+		nodes, err := (&kio.ByteReader{
+			Reader: bytes.NewBufferString(strings.Join([]string{
+				"apiVersion: apps/v1",
+				"kind: Deployment",
+				"metadata:",
+				"  namespace: testns",
+				"  name: labeled",
+				"  labels:",
+				"    team: alpha",
+				"---",
+				"apiVersion: apps/v1",
+				"kind: Deployment",
+				"metadata:",
+				"  namespace: testns",
+				"  name: unlabeled",
+				"---",
+				"apiVersion: v1",
+				"kind: ConfigMap",
+				"metadata:",
+				"  namespace: testns",
+				"  name: config",
+			}, "\n")),
+			OmitReaderAnnotations: true,
+		}).Read()
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		for _, node := range nodes {
+			template := "deployment.yaml"
+			if node.GetKind() == "ConfigMap" {
+				template = "configmap.yaml"
+			}
+			node.YNode().HeadComment = "Source: test-chart/templates/" + template
+		}
+		return &ExpandedRelease{
+			Namespace:    "testns",
+			Name:         "test",
+			Chart:        "test-chart",
+			ChartVersion: "0.1.0",
+			Resources:    nodes,
+		}
+	}
+
+	ginkgo.It("reports violations with the release they come from", func() {
+		writePolicies(
+			"name: require-team-label",
+			"match:",
+			"  kinds: [Deployment]",
+			"validations:",
+			"- expression: has(object.metadata.labels) && 'team' in object.metadata.labels",
+			"  messageExpression: \"'missing team label in release ' + release.name\"",
+			"---",
+			"name: require-config-template",
+			"match:",
+			"  kinds: [ConfigMap]",
+			"validations:",
+			"- expression: template.endsWith('/configmap.yaml')",
+			"  message: unexpected template",
+		)
+		policies, err := ReadPolicies(policyDir)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(policies).To(gomega.HaveLen(2))
+
+		checker := NewPolicyChecker(policies)
+		checker.Check(getRelease())
+		g.Expect(checker.Violations()).To(gomega.Equal([]PolicyViolation{{
+			Policy:           "require-team-label",
+			Message:          "missing team label in release test",
+			APIVersion:       "apps/v1",
+			Kind:             "Deployment",
+			Namespace:        "testns",
+			Name:             "unlabeled",
+			ReleaseNamespace: "testns",
+			ReleaseName:      "test",
+			Chart:            "test-chart",
+			ChartVersion:     "0.1.0",
+			Template:         "test-chart/templates/deployment.yaml",
+		}}))
+	})
+
+	ginkgo.It("reports policy evaluation failures", func() {
+		writePolicies(
+			"name: broken",
+			"match:",
+			"  kinds: [ConfigMap]",
+			"validations:",
+			"- expression: object.spec.replicas > 0",
+		)
+		policies, err := ReadPolicies(policyDir)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		checker := NewPolicyChecker(policies)
+		checker.Check(getRelease())
+		violations := checker.Violations()
+		g.Expect(violations).To(gomega.HaveLen(1))
+		g.Expect(violations[0].Name).To(gomega.Equal("config"))
+		g.Expect(violations[0].Message).To(gomega.ContainSubstring("unable to evaluate policy"))
+	})
+
+	ginkgo.DescribeTable(
+		"rejects invalid policies",
+		func(content []string, expectedError string) {
+			writePolicies(content...)
+			_, err := ReadPolicies(policyDir)
+			g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(expectedError)))
+		},
+		ginkgo.Entry(
+			"has no validations",
+			[]string{"name: empty"},
+			"must have a name and validations",
+		),
+		ginkgo.Entry(
+			"has invalid expression",
+			[]string{"name: invalid", "validations:", "- expression: object.metadata.("},
+			"invalid expression",
+		),
+		ginkgo.Entry(
+			"has non-boolean expression",
+			[]string{"name: invalid", "validations:", "- expression: template"},
+			"evaluates to string instead of bool",
+		),
+		ginkgo.Entry(
+			"has Go template",
+			[]string{"name: template", "deny: '{{ fail \"policy error\" }}'"},
+			"field deny not found",
+		),
+	)
+})
@@ -0,0 +1,144 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"fmt"
+	"strings"
+
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// inputFileMarker starts the comment lines marking where the documents of an
+// input file start in the input.
+const inputFileMarker = "# fouskoti.sage.com/input-file: "
+
+// MarkInputFile returns the line to put in front of the contents of the named
+// file in the input of Expand, for the errors of the expansion to point to
+// the documents in the file.  The line is removed from the input before it is
+// parsed.
+func MarkInputFile(fileName string) string {
+	return inputFileMarker + fileName + "\n"
+}
+
+// inputPosition is the position of the first line of a document in the
+// input, in the file marked by MarkInputFile, if any.
+type inputPosition struct {
+	fileName string
+	line     int
+}
+
+func (position inputPosition) String() string {
+	if position.fileName == "" {
+		return fmt.Sprintf("line %d", position.line)
+	}
+	return fmt.Sprintf("%s:%d", position.fileName, position.line)
+}
+
+// inputDocument is a document of the input.
+type inputDocument struct {
+	position inputPosition
+	// text is the document as it is in the input, without the separators.
+	text string
+	// serialized is the document as it is written after it is parsed, set
+	// only for the input to be preserved.
+	serialized string
+}
+
+// isDocumentContent tells whether the line has content of a document rather
+// than only comments, directives, or document markers.
+func isDocumentContent(line string) bool {
+	line = strings.TrimSpace(line)
+	return line != "" &&
+		!strings.HasPrefix(line, "#") &&
+		!strings.HasPrefix(line, "%") &&
+		!strings.HasPrefix(line, "---") &&
+		line != "..."
+}
+
+// splitInput returns the input without the input file markers, replaced by
+// empty lines, together with its documents in the order kio.ByteReader reads
+// them.
+func splitInput(input string) (string, []inputDocument) {
+	lines := strings.Split(input, "\n")
+	documents := []inputDocument{}
+	fileName := ""
+	// The index of the line before the first line of the current file.
+	fileStart := -1
+	// The lines of the current document, which is only added to the documents
+	// once it has content.
+	documentLines := []string{}
+	hasContent := false
+	endDocument := func() {
+		// Empty lines at the end, like the ones added between input files,
+		// are not a part of the document.
+		for len(documentLines) > 0 &&
+			strings.TrimSpace(documentLines[len(documentLines)-1]) == "" {
+			documentLines = documentLines[:len(documentLines)-1]
+		}
+		if hasContent {
+			documents[len(documents)-1].text = strings.Join(documentLines, "\n") + "\n"
+		}
+		documentLines = []string{}
+		hasContent = false
+	}
+	for i, line := range lines {
+		if name, found := strings.CutPrefix(line, inputFileMarker); found {
+			fileName = strings.TrimSuffix(name, "\r")
+			fileStart = i
+			lines[i] = ""
+			continue
+		}
+		// The same separators as kio.ByteReader splits the documents at.
+		if strings.HasPrefix(line, "---") {
+			endDocument()
+			continue
+		}
+		if !hasContent && isDocumentContent(line) {
+			documents = append(documents, inputDocument{
+				position: inputPosition{fileName: fileName, line: i - fileStart},
+			})
+			hasContent = true
+		}
+		documentLines = append(documentLines, line)
+	}
+	endDocument()
+	return strings.Join(lines, "\n"), documents
+}
+
+// setInputDocuments records the input documents of the parsed nodes, if they
+// are known for all of them, to report the positions of the nodes with the
+// expansion errors and to preserve the input.
+func (renderer *releaseRepoRenderer) setInputDocuments(
+	nodes []*yaml.RNode,
+	documents []inputDocument,
+) error {
+	if len(nodes) != len(documents) {
+		// Documents like lists are read differently, so the documents are
+		// not reliable.
+		return nil
+	}
+	renderer.documents = make(map[*yaml.Node]inputDocument, len(nodes))
+	for i, node := range nodes {
+		if renderer.options.PreserveInput {
+			serialized, err := serializeNode(node)
+			if err != nil {
+				return err
+			}
+			documents[i].serialized = serialized
+		}
+		renderer.documents[node.YNode()] = documents[i]
+	}
+	return nil
+}
+
+// getPositionPrefix returns the position of the input document in the form
+// to start error messages with, or an empty string for documents not in the
+// input, like the ones generated by the expansion.
+func (renderer *releaseRepoRenderer) getPositionPrefix(node *yaml.RNode) string {
+	document, ok := renderer.documents[node.YNode()]
+	if !ok {
+		return ""
+	}
+	return document.position.String() + ": "
+}
@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Input positions", func() {
+	var g gomega.Gomega
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+	})
+
+	ginkgo.It("finds the documents in the marked input files", func() {
+		input, documents := splitInput(strings.Join([]string{
+			"# Leading comment",
+			"apiVersion: v1",
+			"kind: ConfigMap",
+			"---",
+			MarkInputFile("apps/base.yaml") + "---",
+			"# Comment",
+			"",
+			"apiVersion: v1",
+			"kind: Secret",
+			"---",
+			"---",
+			"apiVersion: v1",
+			"kind: Namespace",
+			"---",
+			MarkInputFile("apps/prod.yaml") + "apiVersion: v1",
+			"kind: Service",
+		}, "\n"))
+		g.Expect(input).ToNot(gomega.ContainSubstring(inputFileMarker))
+		g.Expect(strings.Count(input, "\n")).To(gomega.Equal(17))
+		g.Expect(documents).To(gomega.Equal([]inputDocument{
+			{
+				position: inputPosition{fileName: "", line: 2},
+				text:     "# Leading comment\napiVersion: v1\nkind: ConfigMap\n",
+			},
+			{
+				position: inputPosition{fileName: "apps/base.yaml", line: 4},
+				text:     "# Comment\n\napiVersion: v1\nkind: Secret\n",
+			},
+			{
+				position: inputPosition{fileName: "apps/base.yaml", line: 8},
+				text:     "apiVersion: v1\nkind: Namespace\n",
+			},
+			{
+				position: inputPosition{fileName: "apps/prod.yaml", line: 1},
+				text:     "apiVersion: v1\nkind: Service\n",
+			},
+		}))
+	})
+
+	ginkgo.It("reports the position of HelmRelease objects failing to expand", func() {
+		logger := slog.New(slog.NewTextHandler(ginkgo.GinkgoWriter, nil))
+		expander := NewHelmReleaseExpander(context.Background(), logger, nil, nil)
+		input := strings.Join([]string{
+			MarkInputFile("apps/prod.yaml") + "apiVersion: v1",
+			"kind: Namespace",
+			"metadata:",
+			"  name: testns",
+			"---",
+			"# The release",
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"spec:",
+			"  chart:",
+			"    spec:",
+			"      chart: test-chart",
+			"      sourceRef:",
+			"        kind: HelmRepository",
+			"        name: charts",
+			"---",
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: HelmRepository",
+			"metadata:",
+			"  namespace: testns",
+			"  name: charts",
+			"spec:",
+			"  url: https://charts.example.com",
+		}, "\n")
+
+		var output bytes.Buffer
+		err := expander.Expand(
+			bytes.NewBufferString(input),
+			&output,
+			ExpandOptions{Lock: &Lock{}},
+		)
+		g.Expect(err).To(gomega.MatchError(
+			"apps/prod.yaml:7: unable to expand Helm release testns/test: " +
+				"lock has no entry for Helm release testns/test",
+		))
+	})
+})
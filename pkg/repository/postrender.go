@@ -0,0 +1,229 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/kustomize/api/filters/patchjson6902"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+	"sigs.k8s.io/kustomize/kyaml/yaml/merge2"
+
+	yamlutil "github.com/sageailabs/fouskoti/pkg/yaml"
+)
+
+// PostRenderPatch is a patch applied to the resources rendered from all of
+// the HelmRelease objects, in the format of the patches of kustomizations and
+// of Flux post-renderers.
+type PostRenderPatch struct {
+	// Patch is a strategic merge patch or a JSON6902 patch, as YAML or JSON.
+	Patch string `yaml:"patch"`
+	// Target selects the resources to patch; all of them when not set.
+	Target *PatchTarget `yaml:"target,omitempty"`
+
+	// strategicMerge is the parsed strategic merge patch, nil for JSON6902
+	// patches.
+	strategicMerge *yaml.RNode
+	matchers       []func(node *yaml.RNode) bool
+}
+
+// PatchTarget selects resources by their group, version, kind, name, and
+// namespace, given as regular expressions matching the whole values, and by
+// label and annotation selectors.  Empty fields match any resource.
+type PatchTarget struct {
+	Group              string `yaml:"group,omitempty"`
+	Version            string `yaml:"version,omitempty"`
+	Kind               string `yaml:"kind,omitempty"`
+	Name               string `yaml:"name,omitempty"`
+	Namespace          string `yaml:"namespace,omitempty"`
+	LabelSelector      string `yaml:"labelSelector,omitempty"`
+	AnnotationSelector string `yaml:"annotationSelector,omitempty"`
+}
+
+// ReadPostRenderPatches reads a YAML list of post-render patches.
+func ReadPostRenderPatches(input io.Reader) ([]*PostRenderPatch, error) {
+	bytes, err := io.ReadAll(input)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read input: %w", err)
+	}
+
+	var patches []*PostRenderPatch
+	if err := yaml.Unmarshal(bytes, &patches); err != nil {
+		return nil, fmt.Errorf("unable to parse post-render patches YAML: %w", err)
+	}
+	for i, patch := range patches {
+		if err := patch.parse(); err != nil {
+			return nil, fmt.Errorf("invalid post-render patch %d: %w", i+1, err)
+		}
+	}
+	return patches, nil
+}
+
+func (patch *PostRenderPatch) parse() error {
+	node, err := yaml.Parse(patch.Patch)
+	if err != nil {
+		return fmt.Errorf("unable to parse patch: %w", err)
+	}
+	switch node.YNode().Kind {
+	case yaml.MappingNode:
+		patch.strategicMerge = node
+	case yaml.SequenceNode:
+		// Let the filter validate the operations, with no resources to patch.
+		if _, err := (patchjson6902.Filter{Patch: patch.Patch}).Filter(nil); err != nil {
+			return fmt.Errorf("unable to parse JSON6902 patch: %w", err)
+		}
+	default:
+		return fmt.Errorf("expected a strategic merge patch or a list of JSON6902 operations")
+	}
+	if patch.Target != nil {
+		return patch.parseTarget()
+	}
+	return nil
+}
+
+func (patch *PostRenderPatch) parseTarget() error {
+	matchers, err := getTargetMatchers(patch.Target)
+	if err != nil {
+		return err
+	}
+	patch.matchers = matchers
+	return nil
+}
+
+// getTargetMatchers returns the functions telling whether a resource matches
+// each of the set fields of the target.
+func getTargetMatchers(target *PatchTarget) ([]func(node *yaml.RNode) bool, error) {
+	var matchers []func(node *yaml.RNode) bool
+	fields := []struct {
+		name    string
+		pattern string
+		get     func(node *yaml.RNode) string
+	}{
+		{"group", target.Group, yamlutil.GetGroup},
+		{"version", target.Version, getVersion},
+		{"kind", target.Kind, (*yaml.RNode).GetKind},
+		{"name", target.Name, (*yaml.RNode).GetName},
+		{"namespace", target.Namespace, (*yaml.RNode).GetNamespace},
+	}
+	for _, field := range fields {
+		if field.pattern == "" {
+			continue
+		}
+		regex, err := regexp.Compile("^(?:" + field.pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid target %s %s: %w", field.name, field.pattern, err)
+		}
+		get := field.get
+		matchers = append(matchers, func(node *yaml.RNode) bool {
+			return regex.MatchString(get(node))
+		})
+	}
+	selectors := []struct {
+		name     string
+		selector string
+		get      func(node *yaml.RNode) map[string]string
+	}{
+		{"label selector", target.LabelSelector, func(node *yaml.RNode) map[string]string {
+			return node.GetLabels()
+		}},
+		{"annotation selector", target.AnnotationSelector, func(node *yaml.RNode) map[string]string {
+			return node.GetAnnotations()
+		}},
+	}
+	for _, field := range selectors {
+		if field.selector == "" {
+			continue
+		}
+		selector, err := labels.Parse(field.selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid target %s %s: %w", field.name, field.selector, err)
+		}
+		get := field.get
+		matchers = append(matchers, func(node *yaml.RNode) bool {
+			return selector.Matches(labels.Set(get(node)))
+		})
+	}
+	return matchers, nil
+}
+
+// getVersion returns the version of the API of the node, without the group.
+func getVersion(node *yaml.RNode) string {
+	apiVersion := node.GetApiVersion()
+	return apiVersion[strings.LastIndex(apiVersion, "/")+1:]
+}
+
+func (patch *PostRenderPatch) matches(node *yaml.RNode) bool {
+	return matchesAll(patch.matchers, node)
+}
+
+// matchesAll tells whether the node matches all of the matchers.
+func matchesAll(matchers []func(node *yaml.RNode) bool, node *yaml.RNode) bool {
+	for _, matcher := range matchers {
+		if !matcher(node) {
+			return false
+		}
+	}
+	return true
+}
+
+// applyTo returns the node patched, or nil if the patch deletes it.
+func (patch *PostRenderPatch) applyTo(node *yaml.RNode) (*yaml.RNode, error) {
+	if patch.strategicMerge == nil {
+		nodes, err := (patchjson6902.Filter{Patch: patch.Patch}).Filter([]*yaml.RNode{node})
+		if err != nil {
+			return nil, err
+		}
+		return nodes[0], nil
+	}
+	// The patch applies to the resources the target selects, whatever the
+	// resource its own metadata names, the way kustomize applies it.
+	patchNode := patch.strategicMerge.Copy()
+	patchNode.SetApiVersion(node.GetApiVersion())
+	patchNode.SetKind(node.GetKind())
+	if err := patchNode.SetName(node.GetName()); err != nil {
+		return nil, err
+	}
+	if err := patchNode.SetNamespace(node.GetNamespace()); err != nil {
+		return nil, err
+	}
+	return merge2.Merge(patchNode, node, yaml.MergeOptions{
+		ListIncreaseDirection: yaml.MergeOptionsListPrepend,
+	})
+}
+
+// applyPostRenderPatches applies the patches in turn to the resources they
+// select, dropping the resources deleted by them.
+func applyPostRenderPatches(
+	nodes []*yaml.RNode,
+	patches []*PostRenderPatch,
+) ([]*yaml.RNode, error) {
+	for i, patch := range patches {
+		result := make([]*yaml.RNode, 0, len(nodes))
+		for _, node := range nodes {
+			if !patch.matches(node) {
+				result = append(result, node)
+				continue
+			}
+			patched, err := patch.applyTo(node)
+			if err != nil {
+				return nil, fmt.Errorf(
+					"unable to apply post-render patch %d to %s %s/%s: %w",
+					i+1,
+					node.GetKind(),
+					node.GetNamespace(),
+					node.GetName(),
+					err,
+				)
+			}
+			if patched != nil {
+				result = append(result, patched)
+			}
+		}
+		nodes = result
+	}
+	return nodes, nil
+}
@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+var _ = ginkgo.Describe("Post-render patches", func() {
+	var g gomega.Gomega
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+	})
+
+	readPatches := func(lines ...string) []*PostRenderPatch {
+		patches, err := ReadPostRenderPatches(bytes.NewBufferString(strings.Join(lines, "\n")))
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		return patches
+	}
+
+	getNodes := func() []*yaml.RNode {
+		return []*yaml.RNode{
+			yaml.MustParse(strings.Join([]string{
+				"apiVersion: apps/v1",
+				"kind: Deployment",
+				"metadata:",
+				"  namespace: testns",
+				"  name: api",
+				"  labels:",
+				"    app: api",
+				"spec:",
+				"  template:",
+				"    spec:",
+				"      containers:",
+				"      - name: api",
+				"        image: api",
+			}, "\n")),
+			yaml.MustParse(strings.Join([]string{
+				"apiVersion: v1",
+				"kind: ConfigMap",
+				"metadata:",
+				"  namespace: testns",
+				"  name: settings",
+			}, "\n")),
+		}
+	}
+
+	ginkgo.It("applies strategic merge patches to the targets", func() {
+		patches := readPatches(
+			"- patch: |",
+			"    apiVersion: apps/v1",
+			"    kind: Deployment",
+			"    metadata:",
+			"      name: any",
+			"    spec:",
+			"      template:",
+			"        spec:",
+			"          imagePullSecrets:",
+			"          - name: registry",
+			"  target:",
+			"    kind: Deployment|StatefulSet",
+		)
+		nodes, err := applyPostRenderPatches(getNodes(), patches)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(nodes).To(gomega.HaveLen(2))
+		g.Expect(nodes[0].GetName()).To(gomega.Equal("api"))
+		g.Expect(nodes[0].MustString()).To(gomega.ContainSubstring(strings.Join([]string{
+			"  template:",
+			"    spec:",
+			"      containers:",
+			"      - name: api",
+			"        image: api",
+			"      imagePullSecrets:",
+			"      - name: registry",
+		}, "\n")))
+		g.Expect(nodes[1].MustString()).ToNot(gomega.ContainSubstring("imagePullSecrets"))
+	})
+
+	ginkgo.It("applies JSON6902 patches to the resources selected by labels", func() {
+		patches := readPatches(
+			"- patch: |",
+			"    - op: add",
+			"      path: /metadata/labels/tier",
+			"      value: backend",
+			"  target:",
+			"    labelSelector: app=api",
+		)
+		nodes, err := applyPostRenderPatches(getNodes(), patches)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(nodes[0].GetLabels()).To(gomega.HaveKeyWithValue("tier", "backend"))
+		g.Expect(nodes[1].GetLabels()).ToNot(gomega.HaveKey("tier"))
+	})
+
+	ginkgo.It("patches all of the resources without a target", func() {
+		patches := readPatches(
+			"- patch: |",
+			"    metadata:",
+			"      annotations:",
+			"        team: platform",
+		)
+		nodes, err := applyPostRenderPatches(getNodes(), patches)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		for _, node := range nodes {
+			g.Expect(node.GetAnnotations()).To(gomega.HaveKeyWithValue("team", "platform"))
+		}
+	})
+
+	ginkgo.It("drops the resources deleted by patches", func() {
+		patches := readPatches(
+			"- patch: |",
+			"    $patch: delete",
+			"    metadata:",
+			"      name: any",
+			"  target:",
+			"    kind: ConfigMap",
+		)
+		nodes, err := applyPostRenderPatches(getNodes(), patches)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(nodes).To(gomega.HaveLen(1))
+		g.Expect(nodes[0].GetKind()).To(gomega.Equal("Deployment"))
+	})
+
+	ginkgo.It("rejects invalid patches", func() {
+		for _, input := range []string{
+			"- patch: value",
+			"- patch: '[1]'",
+			"- patch: 'metadata: {}'\n  target: {labelSelector: '=='}",
+			"- patch: 'metadata: {}'\n  target: {kind: '('}",
+		} {
+			_, err := ReadPostRenderPatches(bytes.NewBufferString(input))
+			g.Expect(err).To(gomega.HaveOccurred(), input)
+		}
+	})
+})
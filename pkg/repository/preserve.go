@@ -0,0 +1,78 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// serializeNode returns the node as kio.ByteWriter writes it.
+func serializeNode(node *yaml.RNode) (string, error) {
+	var buffer bytes.Buffer
+	if err := (kio.ByteWriter{Writer: &buffer}).Write([]*yaml.RNode{node}); err != nil {
+		return "", fmt.Errorf("unable to serialize node: %w", err)
+	}
+	return buffer.String(), nil
+}
+
+// inputPreservingWriter writes nodes like kio.ByteWriter, except for the
+// nodes of the input which are unchanged, which are written as they are in
+// the input.
+type inputPreservingWriter struct {
+	writer    io.Writer
+	documents map[*yaml.Node]inputDocument
+}
+
+func (writer *inputPreservingWriter) Write(nodes []*yaml.RNode) error {
+	for i, node := range nodes {
+		text, err := serializeNode(node)
+		if err != nil {
+			return err
+		}
+		document, ok := writer.documents[node.YNode()]
+		if ok && document.serialized == text {
+			text = document.text
+		}
+		if i > 0 {
+			text = "---\n" + text
+		}
+		if _, err := io.WriteString(writer.writer, text); err != nil {
+			return fmt.Errorf("unable to write output: %w", err)
+		}
+	}
+	return nil
+}
+
+// newWriter returns the writer of the output of the expansion, which
+// preserves the input documents or normalizes the output if the options say
+// so.
+func (renderer *releaseRepoRenderer) newWriter(output io.Writer) kio.Writer {
+	if renderer.options.Normalize {
+		return &normalizingWriter{writer: kio.ByteWriter{Writer: output}}
+	}
+	if !renderer.options.PreserveInput || renderer.documents == nil {
+		return kio.ByteWriter{Writer: output}
+	}
+	return &inputPreservingWriter{writer: output, documents: renderer.documents}
+}
+
+// getOutputWriter returns the writer of the output of the expansion, which
+// writes into the output directory if the options have one, and records the
+// written objects in the Flux inventory if there is one.
+func (renderer *releaseRepoRenderer) getOutputWriter(output io.Writer) kio.Writer {
+	var writer kio.Writer
+	if renderer.outputDir != nil {
+		writer = renderer.outputDir
+	} else {
+		writer = renderer.newWriter(output)
+	}
+	if renderer.fluxInventory != nil {
+		writer = &fluxInventoryWriter{writer: writer, inventory: renderer.fluxInventory}
+	}
+	return writer
+}
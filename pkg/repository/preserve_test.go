@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Input preservation", func() {
+	var g gomega.Gomega
+	var expander *HelmReleaseExpander
+
+	configMap := strings.Join([]string{
+		"# The settings",
+		"apiVersion: v1",
+		"kind: ConfigMap",
+		"metadata:",
+		"  name: settings   # Referenced by the apps",
+		"data:",
+		"  enabled: 'true'",
+		"  list: [a, b]",
+		"",
+	}, "\n")
+	release := strings.Join([]string{
+		"apiVersion: helm.toolkit.fluxcd.io/v2",
+		"kind: HelmRelease",
+		"metadata:",
+		"  namespace: testns",
+		"  name: test",
+		"spec:",
+		"  chart:",
+		"    spec:",
+		"      chart: test-chart",
+		"      sourceRef:",
+		"        kind: HelmRepository",
+		"        name: missing",
+		"",
+	}, "\n")
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		logger := slog.New(slog.NewTextHandler(ginkgo.GinkgoWriter, nil))
+		expander = NewHelmReleaseExpander(context.Background(), logger, nil, nil)
+	})
+
+	expand := func(input string, options ExpandOptions) string {
+		var output bytes.Buffer
+		err := expander.Expand(bytes.NewBufferString(input), &output, options)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		return output.String()
+	}
+
+	ginkgo.It("writes unchanged input documents as they are", func() {
+		input := MarkInputFile("settings.yaml") + configMap + "---\n" + configMap
+		g.Expect(expand(input, ExpandOptions{PreserveInput: true})).To(
+			gomega.Equal(configMap + "---\n" + configMap),
+		)
+		g.Expect(expand(input, ExpandOptions{})).ToNot(
+			gomega.Equal(configMap + "---\n" + configMap),
+		)
+	})
+
+	ginkgo.It("serializes changed input documents", func() {
+		skipList, err := NewReleaseSkipList([]string{"testns/test"})
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		output := expand(configMap+"---\n"+release, ExpandOptions{
+			PreserveInput: true,
+			SkipList:      skipList,
+		})
+		g.Expect(output).To(gomega.HavePrefix(configMap + "---\n"))
+		g.Expect(output).To(gomega.ContainSubstring(
+			"# Expansion skipped: the Helm release is in the skip list\n" +
+				"apiVersion: helm.toolkit.fluxcd.io/v2\n",
+		))
+	})
+
+	ginkgo.It("writes unchanged input documents as they are when streaming", func() {
+		g.Expect(expand(configMap, ExpandOptions{PreserveInput: true, Streaming: true})).To(
+			gomega.Equal(configMap),
+		)
+	})
+})
@@ -0,0 +1,100 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"golang.org/x/sync/semaphore"
+	"golang.org/x/time/rate"
+)
+
+// hostLimiter limits the rate and the concurrency of requests to each host of
+// Git repositories, Helm repositories, and OCI registries, shared by all the
+// loaders of an expansion.
+type hostLimiter struct {
+	requestsPerSecond float64
+	maxConcurrent     int
+	mutex             sync.Mutex
+	hosts             map[string]*hostLimit
+}
+
+type hostLimit struct {
+	rateLimiter *rate.Limiter
+	semaphore   *semaphore.Weighted
+}
+
+// newHostLimiter returns a limiter allowing the given number of requests per
+// second and concurrent requests to each host, or nil if neither is limited.
+// Zero means no limit.
+func newHostLimiter(requestsPerSecond float64, maxConcurrent int) *hostLimiter {
+	if requestsPerSecond <= 0 && maxConcurrent <= 0 {
+		return nil
+	}
+	return &hostLimiter{
+		requestsPerSecond: requestsPerSecond,
+		maxConcurrent:     maxConcurrent,
+		hosts:             map[string]*hostLimit{},
+	}
+}
+
+// getURLHost returns the host of the repository URL, including the SCP-like
+// Git URLs, e.g., git@github.com:org/repo.
+func getURLHost(repoURL string) string {
+	if parsedURL, err := url.Parse(repoURL); err == nil && parsedURL.Host != "" {
+		return parsedURL.Host
+	}
+	host, _, _ := strings.Cut(repoURL, ":")
+	if index := strings.LastIndex(host, "@"); index >= 0 {
+		host = host[index+1:]
+	}
+	return host
+}
+
+func (limiter *hostLimiter) getHostLimit(host string) *hostLimit {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	limit, ok := limiter.hosts[host]
+	if !ok {
+		limit = &hostLimit{}
+		if limiter.requestsPerSecond > 0 {
+			// Allow bursts of a single request, so that the requests are spread
+			// evenly.
+			limit.rateLimiter = rate.NewLimiter(rate.Limit(limiter.requestsPerSecond), 1)
+		}
+		if limiter.maxConcurrent > 0 {
+			limit.semaphore = semaphore.NewWeighted(int64(limiter.maxConcurrent))
+		}
+		limiter.hosts[host] = limit
+	}
+	return limit
+}
+
+// acquire blocks until a request to the host of the URL is allowed and
+// returns a function to call once the request completes.
+func (limiter *hostLimiter) acquire(ctx context.Context, repoURL string) (func(), error) {
+	if limiter == nil {
+		return func() {}, nil
+	}
+	host := getURLHost(repoURL)
+	limit := limiter.getHostLimit(host)
+	release := func() {}
+	if limit.semaphore != nil {
+		if err := limit.semaphore.Acquire(ctx, 1); err != nil {
+			return nil, fmt.Errorf("unable to wait for a request slot for %s: %w", host, err)
+		}
+		release = func() { limit.semaphore.Release(1) }
+	}
+	if limit.rateLimiter != nil {
+		if err := limit.rateLimiter.Wait(ctx); err != nil {
+			release()
+			return nil, fmt.Errorf("unable to wait for the request rate limit of %s: %w", host, err)
+		}
+	}
+	return release, nil
+}
@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Per-host request limits", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+	})
+
+	ginkgo.DescribeTable(
+		"gets hosts of repository URLs",
+		func(repoURL string, expected string) {
+			g.Expect(getURLHost(repoURL)).To(gomega.Equal(expected))
+		},
+		ginkgo.Entry("is HTTPS", "https://charts.example.com/stable", "charts.example.com"),
+		ginkgo.Entry("has port", "http://localhost:8080/charts", "localhost:8080"),
+		ginkgo.Entry("is OCI", "oci://registry.example.com/charts/app", "registry.example.com"),
+		ginkgo.Entry("is SSH", "ssh://git@github.com/org/repo", "github.com"),
+		ginkgo.Entry("is SCP-like", "git@github.com:org/repo", "github.com"),
+	)
+
+	ginkgo.It("limits concurrent requests to each host", func() {
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil).
+			WithHostRateLimit(0, 1)
+		timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		config := loaderConfig{ctx: timeoutCtx, hostLimiter: expander.hostLimiter}
+
+		releaseFetchSlot, err := config.acquireFetchSlot("oci://registry.example.com/a")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		_, err = config.acquireFetchSlot("oci://registry.example.com/b")
+		g.Expect(err).To(gomega.MatchError(context.DeadlineExceeded))
+		releaseOtherSlot, err := config.acquireFetchSlot("https://charts.example.com")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		releaseOtherSlot()
+
+		releaseFetchSlot()
+		config.ctx = ctx
+		releaseFetchSlot, err = config.acquireFetchSlot("oci://registry.example.com/b")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		releaseFetchSlot()
+	})
+
+	ginkgo.It("limits the request rate to each host", func() {
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil).
+			WithHostRateLimit(20, 0)
+		config := loaderConfig{ctx: ctx, hostLimiter: expander.hostLimiter}
+
+		start := time.Now()
+		for range 3 {
+			releaseFetchSlot, err := config.acquireFetchSlot("https://charts.example.com")
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+			releaseFetchSlot()
+		}
+		g.Expect(time.Since(start)).To(gomega.BeNumerically(">=", 90*time.Millisecond))
+
+		expander.WithHostRateLimit(0, 0)
+		g.Expect(expander.hostLimiter).To(gomega.BeNil())
+	})
+})
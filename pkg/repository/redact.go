@@ -0,0 +1,187 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const redactedText = "***"
+
+// nonSecretCredentials are the credential keys with values which are not
+// secret and are too common to mask, e.g. the git user name.
+var nonSecretCredentials = map[string]struct{}{
+	"username":    {},
+	"known_hosts": {},
+}
+
+// urlPasswordPattern matches passwords in the user information of URLs.
+var urlPasswordPattern = regexp.MustCompile(`(://[^/:@\s]*):[^/@\s]+@`)
+
+// Redactor masks credential material, the values from the credentials file
+// and passwords in URLs, in text written to logs and error messages.  Clients
+// occasionally embed those in their errors.
+type Redactor struct {
+	mutex    sync.RWMutex
+	secrets  []string
+	replacer *strings.Replacer
+}
+
+// AddCredentials adds the secret values of the credentials to the values to
+// mask.
+func (redactor *Redactor) AddCredentials(credentials Credentials) {
+	var secrets []string
+	for _, creds := range credentials {
+		for key, value := range creds.Credentials {
+			if _, ok := nonSecretCredentials[key]; ok {
+				continue
+			}
+			value = strings.TrimSpace(value)
+			if value == "" {
+				continue
+			}
+			secrets = append(secrets, value)
+		}
+	}
+	redactor.AddSecrets(secrets...)
+}
+
+// AddSecrets adds values to mask.
+func (redactor *Redactor) AddSecrets(secrets ...string) {
+	redactor.mutex.Lock()
+	defer redactor.mutex.Unlock()
+
+	for _, secret := range secrets {
+		if secret != "" && !slices.Contains(redactor.secrets, secret) {
+			redactor.secrets = append(redactor.secrets, secret)
+		}
+	}
+	// Mask longer secrets first in case one contains another.
+	slices.SortFunc(redactor.secrets, func(a, b string) int {
+		return cmp.Compare(len(b), len(a))
+	})
+	replacements := make([]string, 0, 2*len(redactor.secrets))
+	for _, secret := range redactor.secrets {
+		replacements = append(replacements, secret, redactedText)
+	}
+	redactor.replacer = strings.NewReplacer(replacements...)
+}
+
+// Redact masks the credential material in the text.
+func (redactor *Redactor) Redact(text string) string {
+	if redactor == nil {
+		return text
+	}
+	redactor.mutex.RLock()
+	replacer := redactor.replacer
+	redactor.mutex.RUnlock()
+
+	if replacer != nil {
+		text = replacer.Replace(text)
+	}
+	return urlPasswordPattern.ReplaceAllString(text, "$1:"+redactedText+"@")
+}
+
+type redactedError struct {
+	message string
+	err     error
+}
+
+func (err *redactedError) Error() string {
+	return err.message
+}
+
+func (err *redactedError) Unwrap() error {
+	return err.err
+}
+
+// RedactError returns an error with the credential material masked in its
+// message, which still wraps the original error.
+func (redactor *Redactor) RedactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	message := err.Error()
+	redacted := redactor.Redact(message)
+	if redacted == message {
+		return err
+	}
+	return &redactedError{message: redacted, err: err}
+}
+
+type redactingHandler struct {
+	redactor *Redactor
+	handler  slog.Handler
+}
+
+// NewRedactingHandler returns a log handler masking the credential material
+// in the messages and attributes before passing the records to the handler.
+func NewRedactingHandler(redactor *Redactor, handler slog.Handler) slog.Handler {
+	return &redactingHandler{redactor: redactor, handler: handler}
+}
+
+func (handler *redactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return handler.handler.Enabled(ctx, level)
+}
+
+func (handler *redactingHandler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(
+		record.Time,
+		record.Level,
+		handler.redactor.Redact(record.Message),
+		record.PC,
+	)
+	record.Attrs(func(attr slog.Attr) bool {
+		redacted.AddAttrs(handler.redactAttr(attr))
+		return true
+	})
+	return handler.handler.Handle(ctx, redacted)
+}
+
+func (handler *redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, 0, len(attrs))
+	for _, attr := range attrs {
+		redacted = append(redacted, handler.redactAttr(attr))
+	}
+	return &redactingHandler{
+		redactor: handler.redactor,
+		handler:  handler.handler.WithAttrs(redacted),
+	}
+}
+
+func (handler *redactingHandler) WithGroup(name string) slog.Handler {
+	return &redactingHandler{
+		redactor: handler.redactor,
+		handler:  handler.handler.WithGroup(name),
+	}
+}
+
+func (handler *redactingHandler) redactAttr(attr slog.Attr) slog.Attr {
+	value := attr.Value.Resolve()
+	switch value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, handler.redactor.Redact(value.String()))
+	case slog.KindGroup:
+		group := value.Group()
+		redacted := make([]slog.Attr, 0, len(group))
+		for _, member := range group {
+			redacted = append(redacted, handler.redactAttr(member))
+		}
+		return slog.Attr{Key: attr.Key, Value: slog.GroupValue(redacted...)}
+	case slog.KindAny:
+		text := fmt.Sprint(value.Any())
+		if redacted := handler.redactor.Redact(text); redacted != text {
+			return slog.String(attr.Key, redacted)
+		}
+		return attr
+	default:
+		return attr
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+)
+
+// maxReleaseNameLength is the maximum length of the names of Helm releases.
+const maxReleaseNameLength = 53
+
+// shortenedReleaseNameLength is the length of the prefix of the names longer
+// than maxReleaseNameLength kept by helm-controller, which appends a hash of
+// the whole name to it.
+const shortenedReleaseNameLength = 40
+
+// getReleaseName returns the name of the Helm release of the HelmRelease the
+// way helm-controller derives it: spec.releaseName if set, or the name of the
+// HelmRelease prefixed with spec.targetNamespace if set, with the names
+// longer than maxReleaseNameLength shortened by replacing their ends with a
+// hash.  With legacy set, it returns spec.releaseName if set, or the name
+// prefixed with the target namespace, defaulting to the namespace of the
+// HelmRelease, as earlier versions of this tool did.
+func getReleaseName(release *helmv2.HelmRelease, legacy bool) string {
+	if legacy {
+		if release.Spec.ReleaseName != "" {
+			return release.Spec.ReleaseName
+		}
+		return cmp.Or(release.Spec.TargetNamespace, release.Namespace) + "-" + release.Name
+	}
+
+	name := release.Name
+	switch {
+	case release.Spec.ReleaseName != "":
+		name = release.Spec.ReleaseName
+	case release.Spec.TargetNamespace != "":
+		name = release.Spec.TargetNamespace + "-" + release.Name
+	}
+	return shortenReleaseName(name)
+}
+
+// shortenReleaseName returns the name shortened to maxReleaseNameLength the
+// way helm-controller does, keeping a prefix of it followed by a prefix of
+// the hex SHA-256 hash of the whole name.
+func shortenReleaseName(name string) string {
+	if len(name) <= maxReleaseNameLength {
+		return name
+	}
+	hash := sha256.Sum256([]byte(name))
+	hashLength := maxReleaseNameLength - shortenedReleaseNameLength - 1
+	return name[:shortenedReleaseNameLength] + "-" + hex.EncodeToString(hash[:])[:hashLength]
+}
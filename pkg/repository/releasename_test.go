@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"strings"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = ginkgo.Describe("Release names", func() {
+	var g gomega.Gomega
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+	})
+
+	getRelease := func(name string, targetNamespace string, releaseName string) *helmv2.HelmRelease {
+		return &helmv2.HelmRelease{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "testns", Name: name},
+			Spec: helmv2.HelmReleaseSpec{
+				TargetNamespace: targetNamespace,
+				ReleaseName:     releaseName,
+			},
+		}
+	}
+
+	ginkgo.DescribeTable(
+		"derives release names",
+		func(release *helmv2.HelmRelease, legacy bool, expected string) {
+			g.Expect(getReleaseName(release, legacy)).To(gomega.Equal(expected))
+		},
+		ginkgo.Entry("from the name", getRelease("test", "", ""), false, "test"),
+		ginkgo.Entry("with the target namespace", getRelease("test", "apps", ""), false, "apps-test"),
+		ginkgo.Entry("from the release name", getRelease("test", "apps", "custom"), false, "custom"),
+		ginkgo.Entry(
+			"shortening long names",
+			getRelease(strings.Repeat("a", 60), "", ""),
+			false,
+			strings.Repeat("a", 40)+"-11ee391211c6",
+		),
+		ginkgo.Entry("in legacy mode", getRelease("test", "", ""), true, "testns-test"),
+		ginkgo.Entry(
+			"in legacy mode with the target namespace",
+			getRelease("test", "apps", ""),
+			true,
+			"apps-test",
+		),
+		ginkgo.Entry(
+			"in legacy mode without shortening",
+			getRelease(strings.Repeat("a", 60), "", ""),
+			true,
+			"testns-"+strings.Repeat("a", 60),
+		),
+	)
+
+	ginkgo.It("shortens names to the maximum length", func() {
+		name := shortenReleaseName(strings.Repeat("b", 54))
+		g.Expect(name).To(gomega.HaveLen(maxReleaseNameLength))
+		g.Expect(shortenReleaseName(strings.Repeat("b", 53))).To(
+			gomega.Equal(strings.Repeat("b", 53)),
+		)
+	})
+})
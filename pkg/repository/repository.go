@@ -4,23 +4,29 @@ package repository
 
 import (
 	"bytes"
+	"cmp"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"log/slog"
+	"maps"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"slices"
 	"strings"
+	"time"
 
 	helmv2 "github.com/fluxcd/helm-controller/api/v2"
 	"github.com/fluxcd/pkg/git"
 	"github.com/fluxcd/pkg/git/gogit"
 	"github.com/fluxcd/pkg/git/repository"
+	"golang.org/x/sync/semaphore"
 	"helm.sh/helm/v4/pkg/chart/common"
 	commonutil "helm.sh/helm/v4/pkg/chart/common/util"
 	"helm.sh/helm/v4/pkg/chart/loader/archive"
@@ -52,6 +58,14 @@ type repositoryLoader interface {
 		chartName string,
 		chartVersion string,
 	) (*chart.Chart, error)
+	// resolveChartVersion finds the concrete version of the chart matching
+	// chartVersionSpec without loading the chart.
+	resolveChartVersion(
+		repoNode *yaml.RNode,
+		repoURL string,
+		chartName string,
+		chartVersionSpec string,
+	) (string, error)
 }
 
 type GitClientInterface interface {
@@ -69,14 +83,67 @@ type gitClientFactoryFunc func(
 ) (GitClientInterface, error)
 
 type loaderConfig struct {
-	ctx                 context.Context
-	logger              *slog.Logger
-	gitClientFactory    gitClientFactoryFunc
-	repoClientFactory   repositoryClientFactoryFunc
-	gitRepoSubstitution *GitRepoSubstitution
-	cacheRoot           string
-	chartCache          map[string]*chart.Chart
-	credentials         Credentials
+	ctx                  context.Context
+	logger               *slog.Logger
+	gitClientFactory     gitClientFactoryFunc
+	repoClientFactory    repositoryClientFactoryFunc
+	gitRepoSubstitutions []*GitRepoSubstitution
+	chartSubstitutions   []*ChartSubstitution
+	cacheRoot            string
+	chartCache           *inMemoryChartCache
+	credentials          Credentials
+	fetchLimiter         *semaphore.Weighted
+	mirrors              Mirrors
+	hostLimiter          *hostLimiter
+	indexMaxAge          time.Duration
+	gitCloneOptions      GitCloneOptions
+	// verifyCacheSince is when the verification of the cached charts was
+	// enabled, or zero without it.
+	verifyCacheSince  time.Time
+	offline           bool
+	allowLocalSources bool
+	// secrets are the Secret objects in the input, which can hold the
+	// certificates of the repositories.
+	secrets     sourceIndex
+	connections *connectionPool
+	tagCache    *tagCache
+}
+
+// CacheMissError reports a repository, index, or chart which is not in the
+// chart cache in the offline mode.
+type CacheMissError struct {
+	Resource string
+}
+
+func (err *CacheMissError) Error() string {
+	return fmt.Sprintf("%s is not in the chart cache", err.Resource)
+}
+
+// acquireFetchSlot blocks until fetching from the remote repository at the
+// URL is allowed and returns a function releasing the slot.
+func (config *loaderConfig) acquireFetchSlot(repoURL string) (func(), error) {
+	// Downloads of Helm charts and indexes cannot be interrupted, so don't
+	// start new ones once the expansion is canceled.
+	if err := config.ctx.Err(); err != nil {
+		return nil, fmt.Errorf("fetch canceled: %w", err)
+	}
+	// Wait for the host first, so that waiting for a busy host doesn't hold
+	// up fetches from other hosts.
+	releaseHost, err := config.hostLimiter.acquire(config.ctx, repoURL)
+	if err != nil {
+		return nil, err
+	}
+	if config.fetchLimiter == nil {
+		return releaseHost, nil
+	}
+	if err := config.fetchLimiter.Acquire(config.ctx, 1); err != nil {
+		releaseHost()
+		return nil, fmt.Errorf("unable to wait for a fetch slot: %w", err)
+	}
+	return func() {
+		config.fetchLimiter.Release(1)
+		releaseHost()
+	}, nil
 }
 
 type repositoryLoaderFactory func(config loaderConfig) repositoryLoader
@@ -183,8 +250,14 @@ func joinPath(a string, b string) string {
 	return path.Join(a, b)
 }
 
+// decodeToObject decodes the node into the object, converting Flux objects of
+// older API versions to the current ones first.
 func decodeToObject(node *yaml.RNode, out runtime.Object) error {
-	bytes, err := node.MarshalJSON()
+	converted, err := convertFluxObject(node)
+	if err != nil {
+		return fmt.Errorf("unable to convert %s: %w", node.GetApiVersion(), err)
+	}
+	bytes, err := converted.MarshalJSON()
 	if err != nil {
 		return fmt.Errorf("unable to encode node to JSON: %w", err)
 	}
@@ -226,50 +299,82 @@ func copyChart(src *chart.Chart) *chart.Chart {
 	return &cpy
 }
 
+// ephemeralCacheID separates the ephemeral cache subtrees of program
+// invocations sharing a cache directory, so that they don't clean up each
+// other's entries.
+var ephemeralCacheID = fmt.Sprintf("%d-%d", os.Getpid(), time.Now().UnixNano())
+
+func getEphemeralCacheDir(cacheRoot string) string {
+	return path.Join(cacheRoot, "ephemeral", ephemeralCacheID)
+}
+
 func getCachePathForRepo(cacheRoot string, repoURL string, ephemeral bool) string {
-	urlPath := strings.ReplaceAll(strings.TrimSuffix(repoURL, "/"), "/", "#")
-	parts := []string{cacheRoot}
 	if ephemeral {
-		parts = append(parts, "ephemeral")
+		cacheRoot = getEphemeralCacheDir(cacheRoot)
 	}
-	parts = append(parts, urlPath)
-	return path.Join(parts...)
+	return path.Join(cacheRoot, getRepoCacheKey(repoURL))
 }
 
-func saveChartFiles(files []*archive.BufferedFile, chartDir string) error {
-	for _, file := range files {
-		filePath := path.Join(chartDir, file.Name)
-		fileDir := path.Dir(filePath)
-		err := os.MkdirAll(fileDir, 0700)
-		if err != nil {
-			return fmt.Errorf("unable to create chart cache directory %s: %w", fileDir, err)
-		}
-		err = os.WriteFile(filePath, file.Data, 0660)
-		if err != nil {
-			return fmt.Errorf("unable to write cached chart file %s: %w", filePath, err)
+// publishCacheDir populates a cache directory atomically, so that concurrent
+// invocations sharing the cache never observe partially written entries.  The
+// populate function writes into a temporary directory next to the target one,
+// which is then renamed to the target.  If another invocation publishes the
+// target first, its contents are kept.
+func publishCacheDir(targetDir string, populate func(dir string) error) error {
+	parentDir := path.Dir(targetDir)
+	if err := os.MkdirAll(parentDir, 0700); err != nil {
+		return fmt.Errorf("unable to create cache directory %s: %w", parentDir, err)
+	}
+	tempDir, err := os.MkdirTemp(parentDir, ".tmp-")
+	if err != nil {
+		return fmt.Errorf("unable to create temporary directory in %s: %w", parentDir, err)
+	}
+	if err := populate(tempDir); err != nil {
+		// Failures to remove temporary directories are not interesting.
+		_ = os.RemoveAll(tempDir)
+		return err
+	}
+	if err := os.Rename(tempDir, targetDir); err != nil {
+		_ = os.RemoveAll(tempDir)
+		if stat, statErr := os.Stat(targetDir); statErr == nil && stat.IsDir() {
+			return nil
 		}
+		return fmt.Errorf("unable to publish cache directory %s: %w", targetDir, err)
 	}
 	return nil
 }
 
-// loadRepositoryChart downloads the chart and returns it.
+func saveChartFiles(files []*archive.BufferedFile, chartDir string) error {
+	return publishCacheDir(chartDir, func(dir string) error {
+		for _, file := range files {
+			filePath := path.Join(dir, file.Name)
+			fileDir := path.Dir(filePath)
+			err := os.MkdirAll(fileDir, 0700)
+			if err != nil {
+				return fmt.Errorf("unable to create chart cache directory %s: %w", fileDir, err)
+			}
+			err = os.WriteFile(filePath, file.Data, 0660)
+			if err != nil {
+				return fmt.Errorf("unable to write cached chart file %s: %w", filePath, err)
+			}
+		}
+		return nil
+	})
+}
+
+// loadRepositoryChart downloads the chart and returns it together with the
+// values files of charts from GitRepository objects outside of the chart
+// directory.  Without a cache root in the configuration, the chart is
+// downloaded to a temporary directory.
 func loadRepositoryChart(
-	ctx context.Context,
-	logger *slog.Logger,
-	gitClientFactory gitClientFactoryFunc,
-	repoClientFactory repositoryClientFactoryFunc,
-	gitRepoSubstitution *GitRepoSubstitution,
-	chartCacheDir string,
-	chartCache map[string]*chart.Chart,
-	credentials Credentials,
+	config loaderConfig,
 	release *helmv2.HelmRelease,
 	repoNode *yaml.RNode,
-) (*chart.Chart, error) {
-	if chartCacheDir == "" {
-		var err error
-		chartCacheDir, err = os.MkdirTemp("", "chart-repo-cache-")
+) (*chart.Chart, map[string][]byte, error) {
+	if config.cacheRoot == "" {
+		cacheRoot, err := os.MkdirTemp("", "chart-repo-cache-")
 		if err != nil {
-			return nil, fmt.Errorf(
+			return nil, nil, fmt.Errorf(
 				"unable to create a cache dir for repo %s/%s/%s: %w",
 				repoNode.GetKind(),
 				repoNode.GetNamespace(),
@@ -278,39 +383,41 @@ func loadRepositoryChart(
 			)
 		}
 		defer func() {
-			if err := os.RemoveAll(chartCacheDir); err != nil {
-				logger.
+			if err := os.RemoveAll(cacheRoot); err != nil {
+				config.logger.
 					With("error", err).
-					With("dir", chartCacheDir).
+					With("dir", cacheRoot).
 					Error("Unable to clean the chart cache directory")
 			}
 		}()
+		config.cacheRoot = cacheRoot
 	}
 
-	loader, err := getLoaderForRepo(
-		repoNode,
-		loaderConfig{
-			ctx,
-			logger,
-			gitClientFactory,
-			repoClientFactory,
-			gitRepoSubstitution,
-			chartCacheDir,
-			chartCache,
-			credentials,
-		},
-	)
+	loader, err := getLoaderForRepo(repoNode, config)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return loader.loadRepositoryChart(
+	chart, err := loader.loadRepositoryChart(
 		repoNode,
 		"",
 		nil,
 		release.Spec.Chart.Spec.Chart,
 		release.Spec.Chart.Spec.Version,
 	)
+	if err != nil {
+		return nil, nil, err
+	}
+	gitLoader, ok := loader.(*gitRepoChartLoader)
+	if !ok {
+		return chart, nil, nil
+	}
+	// The values files are read while the checkout is still around.
+	repoFiles, err := gitLoader.readValuesFiles(repoNode, &release.Spec.Chart.Spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	return chart, repoFiles, nil
 }
 
 func loadChartDependencies(
@@ -386,99 +493,195 @@ func loadChartDependencies(
 	return nil
 }
 
-func expandHelmRelease(
-	ctx context.Context,
-	logger *slog.Logger,
-	gitClientFactory gitClientFactoryFunc,
-	repoClientFactory repositoryClientFactoryFunc,
-	kubeVersion *common.KubeVersion,
-	apiVersions []string,
-	gitRepoSubstitution *GitRepoSubstitution,
-	chartCacheDir string,
-	chartCache map[string]*chart.Chart,
-	credentials Credentials,
+// expandHelmRelease renders the chart of the HelmRelease from the repository.
+func (renderer *releaseRepoRenderer) expandHelmRelease(
 	releaseNode *yaml.RNode,
 	repoNode *yaml.RNode,
-) ([]*yaml.RNode, error) {
+) (*ExpandedRelease, error) {
 	var release helmv2.HelmRelease
 	err := decodeToObject(releaseNode, &release)
 	if err != nil {
-		return nil, fmt.Errorf(
+		return nil, NewClassifiedError(ErrorClassInput, fmt.Errorf(
 			"unable to decode HelmRelease: %w",
 			err,
-		)
+		))
 	}
 
 	if repoNode == nil {
-		return nil, fmt.Errorf(
+		return nil, NewClassifiedError(ErrorClassInput, fmt.Errorf(
 			"missing chart repository %s for Helm release %s/%s",
 			release.Spec.Chart.Spec.SourceRef.Name,
 			release.Namespace,
 			release.Name,
-		)
+		))
 	}
 
-	chart, err := loadRepositoryChart(
-		ctx,
-		logger,
-		gitClientFactory,
-		repoClientFactory,
-		gitRepoSubstitution,
-		chartCacheDir,
-		chartCache,
-		credentials,
-		&release,
-		repoNode,
-	)
+	var lockedRelease *LockedRelease
+	if renderer.options.Lock != nil {
+		lockedRelease, err = renderer.options.Lock.getLockedRelease(&release, repoNode)
+		if err != nil {
+			return nil, err
+		}
+		if repoNode.GetKind() != "GitRepository" {
+			// Load exactly the locked version regardless of the constraint.
+			release.Spec.Chart.Spec.Version = lockedRelease.Version
+		}
+	}
+
+	var chart *chart.Chart
+	var repoFiles map[string][]byte
+	if vendorManifest := renderer.options.VendorManifest; vendorManifest != nil {
+		chart, err = vendorManifest.loadVendoredChart(renderer.config.logger, &release, repoNode)
+		if err != nil {
+			return nil, err
+		}
+	}
+	resolvedFromRepository := chart == nil && lockedRelease == nil &&
+		repoNode.GetKind() != "GitRepository"
+	if chart == nil {
+		chart, repoFiles, err = loadRepositoryChart(renderer.config, &release, repoNode)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"unable to load chart for %s %s/%s: %w",
+				repoNode.GetKind(),
+				repoNode.GetNamespace(),
+				repoNode.GetName(),
+				err,
+			)
+		}
+	}
+
+	digest := getChartDigest(chart)
+	if lockedRelease != nil {
+		err = lockedRelease.verify(chart.Metadata.Version, digest)
+		if err != nil {
+			return nil, NewClassifiedError(
+				ErrorClassValidation,
+				fmt.Errorf("chart drifted from the lock: %w", err),
+			)
+		}
+	}
+	repoURL, err := repoNode.GetString("spec.url")
 	if err != nil {
 		return nil, fmt.Errorf(
-			"unable to load chart for %s %s/%s: %w",
+			"unable to get URL for %s %s/%s: %w",
 			repoNode.GetKind(),
 			repoNode.GetNamespace(),
 			repoNode.GetName(),
 			err,
 		)
 	}
+	if resolvedFromRepository {
+		renderer.resolutions.record(
+			repoURL,
+			release.Spec.Chart.Spec.Chart,
+			release.Spec.Chart.Spec.Version,
+			chart.Metadata.Version,
+		)
+	}
+	if renderer.resolvedLock != nil {
+		renderer.resolvedLock.add(LockedRelease{
+			Namespace:  release.Namespace,
+			Name:       release.Name,
+			SourceKind: repoNode.GetKind(),
+			URL:        repoURL,
+			Chart:      release.Spec.Chart.Spec.Chart,
+			Version:    chart.Metadata.Version,
+			Digest:     digest,
+		})
+	}
+
+	chart, err = applyValuesFiles(
+		chart,
+		&release.Spec.Chart.Spec,
+		repoNode.GetKind(),
+		repoFiles,
+	)
+	if err != nil {
+		return nil, NewClassifiedError(ErrorClassRender, fmt.Errorf(
+			"unable to apply values files for Helm release %s/%s: %w",
+			release.Namespace,
+			release.Name,
+			err,
+		))
+	}
 
+	releaseValues, err := renderer.getValuesWithEnv(&release)
+	if err != nil {
+		return nil, NewClassifiedError(ErrorClassInput, fmt.Errorf(
+			"unable to get values of Helm release %s/%s: %w",
+			release.Namespace,
+			release.Name,
+			err,
+		))
+	}
+	releaseValues = getOverriddenValues(&release, releaseValues, renderer.options.ValuesOverrides)
+	renderer.warnUndeclaredValues(&release, chart, releaseValues)
 	// Remove charts disabled by conditions.
-	err = chartutil.ProcessDependencies(chart, release.GetValues())
+	err = chartutil.ProcessDependencies(chart, releaseValues)
 	if err != nil {
-		return nil, fmt.Errorf(
+		return nil, NewClassifiedError(ErrorClassRender, fmt.Errorf(
 			"unable to process dependencies for chart %s: %w",
 			chart.Name(),
 			err,
-		)
+		))
 	}
 
-	values, err := commonutil.CoalesceValues(chart, release.GetValues())
+	values, err := commonutil.CoalesceValues(chart, releaseValues)
 	if err != nil {
-		return nil, fmt.Errorf(
+		return nil, NewClassifiedError(ErrorClassRender, fmt.Errorf(
 			"unable to coalesce values from the chart for release %s/%s: %w",
 			release.Namespace,
 			release.Name,
 			err,
-		)
+		))
 	}
 
-	capabilities := common.DefaultCapabilities.Copy()
-	if kubeVersion != nil {
-		capabilities.KubeVersion = *kubeVersion
+	// Same as helm-controller, validate the values against the JSON schemas
+	// shipped with the chart and its subcharts unless the release opts out.
+	skipSchemaValidation := release.GetInstall().DisableSchemaValidation
+	if !skipSchemaValidation {
+		err = commonutil.ValidateAgainstSchema(chart, values)
+		if err != nil {
+			return nil, NewClassifiedError(ErrorClassValidation, fmt.Errorf(
+				"values for Helm release %s/%s do not match the chart schema: %w",
+				release.Namespace,
+				release.Name,
+				err,
+			))
+		}
 	}
-	if len(apiVersions) > 0 {
-		capabilities.APIVersions = append(
-			capabilities.APIVersions,
-			common.VersionSet(apiVersions)...,
-		)
+
+	capabilities, err := getReleaseCapabilities(
+		&release,
+		renderer.options.KubeVersion,
+		renderer.options.APIVersions,
+		renderer.options.APIResources,
+	)
+	if err != nil {
+		return nil, NewClassifiedError(ErrorClassInput, fmt.Errorf(
+			"unable to get capabilities for Helm release %s/%s: %w",
+			release.Namespace,
+			release.Name,
+			err,
+		))
+	}
+
+	driftIgnoreRules, err := getDriftIgnoreRules(&release)
+	if err != nil {
+		return nil, NewClassifiedError(ErrorClassInput, fmt.Errorf(
+			"unable to get drift detection rules of Helm release %s/%s: %w",
+			release.Namespace,
+			release.Name,
+			err,
+		))
 	}
 
 	targetNamespace := release.Spec.TargetNamespace
 	if targetNamespace == "" {
 		targetNamespace = release.Namespace
 	}
-	releaseName := release.Spec.ReleaseName
-	if releaseName == "" {
-		releaseName = fmt.Sprintf("%s-%s", targetNamespace, release.Name)
-	}
+	releaseName := getReleaseName(&release, renderer.options.LegacyReleaseNames)
 
 	options := common.ReleaseOptions{
 		Name:      releaseName,
@@ -487,52 +690,81 @@ func expandHelmRelease(
 		IsInstall: true,
 		IsUpgrade: false,
 	}
-	valuesToRender, err := commonutil.ToRenderValues(chart, values, options, capabilities)
+	// The values have been validated above, if necessary.
+	valuesToRender, err := commonutil.ToRenderValuesWithSchemaValidation(
+		chart,
+		values,
+		options,
+		capabilities,
+		true,
+	)
 	if err != nil {
-		return nil, fmt.Errorf(
+		return nil, NewClassifiedError(ErrorClassRender, fmt.Errorf(
 			"unable to compose values to render Helm release %s/%s: %w",
 			release.Namespace,
 			release.Name,
 			err,
-		)
+		))
+	}
+	var manifests map[string]string
+	lookupProvider := renderer.options.LookupProvider
+	switch {
+	case renderer.options.Reproducible:
+		funcs := getReproducibleFuncs(release.Namespace + "/" + release.Name)
+		if lookupProvider != nil {
+			funcs["lookup"] = newLookupFunction(lookupProvider)
+		}
+		manifests, err = engine.Engine{CustomTemplateFuncs: funcs}.Render(chart, valuesToRender)
+	case lookupProvider != nil:
+		manifests, err = engine.RenderWithClientProvider(chart, valuesToRender, lookupProvider)
+	default:
+		manifests, err = engine.Render(chart, valuesToRender)
 	}
-	manifests, err := engine.Render(chart, valuesToRender)
 	if err != nil {
-		return nil, fmt.Errorf(
+		return nil, NewClassifiedError(ErrorClassRender, fmt.Errorf(
 			"unable to render values for Helm release %s/%s: %w",
 			release.Namespace,
 			release.Name,
 			err,
-		)
+		))
 	}
 
+	// The manifests are iterated in a stable order to keep the output
+	// deterministic when it is not sorted afterwards.
+	orderedManifests, err := getOrderedManifests(manifests, renderer.options.SortOrder)
+	if err != nil {
+		return nil, NewClassifiedError(ErrorClassRender, fmt.Errorf(
+			"unable to sort manifests from Helm release %s/%s: %w",
+			release.Namespace,
+			release.Name,
+			err,
+		))
+	}
 	var results []*yaml.RNode
-	for key, manifest := range manifests {
-		if strings.TrimSpace(manifest) == "" {
-			continue
-		}
-		if filepath.Base(key) == "NOTES.txt" {
-			continue
-		}
+	for _, manifest := range orderedManifests {
 		reader := kio.ByteReader{
-			Reader: bytes.NewBufferString(manifest),
+			Reader: bytes.NewBufferString(manifest.Content),
 		}
 		result, err := reader.Read()
 		if err != nil {
-			return nil, fmt.Errorf(
+			return nil, NewClassifiedError(ErrorClassRender, fmt.Errorf(
 				"unable to parse manifest %s from Helm release %s/%s: %w",
-				key,
+				manifest.Name,
 				release.Namespace,
 				release.Name,
 				err,
-			)
+			))
 		}
 		for _, node := range result {
-			node.YNode().HeadComment = fmt.Sprintf("Source: %s", key)
+			node.YNode().HeadComment = fmt.Sprintf("Source: %s", manifest.Name)
 			results = append(results, node)
 		}
 	}
 
+	if !renderer.options.KeepTestHooks {
+		results = dropTestHooks(results)
+	}
+
 	filter := &namespace.Filter{
 		Namespace:              release.Namespace,
 		UnsetOnly:              true,
@@ -548,29 +780,57 @@ func expandHelmRelease(
 			err,
 		)
 	}
-	return results, nil
+	// The custom resource definitions are cluster-scoped, so they are added
+	// after the namespaces have been assigned.
+	crds, err := getCRDNodes(chart, &release, renderer.options.CRDs)
+	if err != nil {
+		return nil, err
+	}
+	results = append(crds, results...)
+	results, err = applyPostRenderPatches(results, renderer.options.PostRenderPatches)
+	if err != nil {
+		return nil, NewClassifiedError(ErrorClassRender, err)
+	}
+	rewriteImages(results, renderer.options.ImageRewrites)
+	expanded := &ExpandedRelease{
+		Namespace:        release.Namespace,
+		Name:             release.Name,
+		TargetNamespace:  targetNamespace,
+		ReleaseName:      releaseName,
+		StorageNamespace: cmp.Or(release.Spec.StorageNamespace, release.Namespace),
+		Chart:            chart.Name(),
+		ChartVersion:     chart.Metadata.Version,
+		AppVersion:       chart.Metadata.AppVersion,
+		SourceURL:        repoURL,
+		Digest:           digest,
+		KubeVersion:      capabilities.KubeVersion.Version,
+		Resources:        results,
+		driftIgnoreRules: driftIgnoreRules,
+	}
+	if err := checkNaming(&release, expanded); err != nil {
+		return nil, err
+	}
+	if err := renderer.checkEmptyExpansion(expanded); err != nil {
+		return nil, err
+	}
+	return expanded, nil
 }
 
-func getRepositoryForHelmRelease(
-	nodes []*yaml.RNode,
-	helmRelease *yaml.RNode,
-) (*yaml.RNode, error) {
+// sourceReference is the chart source referenced by a HelmRelease, with the
+// namespace defaulted to the namespace of the HelmRelease.
+type sourceReference struct {
+	apiVersion string
+	kind       string
+	namespace  string
+	name       string
+}
+
+func getSourceReference(helmRelease *yaml.RNode) (*sourceReference, error) {
 	repoKind, err := helmRelease.GetString("spec.chart.spec.sourceRef.kind")
 	if err != nil {
 		return nil, fmt.Errorf("unable to get kind for the repository: %w", err)
 	}
 
-	switch repoKind {
-	case "GitRepository":
-	case "HelmRepository":
-	case "OCIRepository":
-		break
-	case "Bucket":
-		return nil, fmt.Errorf("unsupported chart repository kind %s", repoKind)
-	default:
-		return nil, fmt.Errorf("invalid chart repository kind %s", repoKind)
-	}
-
 	repoName, err := helmRelease.GetString("spec.chart.spec.sourceRef.name")
 	if err != nil {
 		return nil, fmt.Errorf("unable to get name for the repository: %w", err)
@@ -594,15 +854,40 @@ func getRepositoryForHelmRelease(
 		return nil, err
 	}
 
-	for _, node := range nodes {
-		if node.GetKind() == repoKind &&
-			node.GetName() == repoName &&
-			node.GetNamespace() == repoNamespace &&
-			(repoApiVersion == "" || node.GetApiVersion() == repoApiVersion) {
-			return node, nil
-		}
+	return &sourceReference{
+		apiVersion: repoApiVersion,
+		kind:       repoKind,
+		namespace:  repoNamespace,
+		name:       repoName,
+	}, nil
+}
+
+func getRepositoryForHelmRelease(
+	sources sourceIndex,
+	helmRelease *yaml.RNode,
+) (*yaml.RNode, error) {
+	sourceRef, err := getSourceReference(helmRelease)
+	if err != nil {
+		return nil, err
+	}
+
+	switch sourceRef.kind {
+	case "GitRepository":
+	case "HelmRepository":
+	case "OCIRepository":
+		break
+	case "Bucket":
+		return nil, fmt.Errorf("unsupported chart repository kind %s", sourceRef.kind)
+	default:
+		return nil, fmt.Errorf("invalid chart repository kind %s", sourceRef.kind)
 	}
-	return nil, nil
+
+	return sources.find(
+		sourceRef.kind,
+		sourceRef.namespace,
+		sourceRef.name,
+		sourceRef.apiVersion,
+	), nil
 }
 
 type releaseRepo struct {
@@ -616,6 +901,7 @@ func getReleaseRepos(
 ) ([]releaseRepo, error) {
 	result := []releaseRepo{}
 	helmReleases := []*yaml.RNode{}
+	sources := newSourceIndex(repoNodes)
 
 	for _, node := range releaseNodes {
 		if yamlutil.GetGroup(node) == "helm.toolkit.fluxcd.io" &&
@@ -625,7 +911,16 @@ func getReleaseRepos(
 	}
 
 	for _, helmRelease := range helmReleases {
-		repository, err := getRepositoryForHelmRelease(repoNodes, helmRelease)
+		resolvedRelease, err := resolveHelmChartReference(sources, helmRelease)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"unable to resolve HelmChart for HelmRelease %s/%s: %w",
+				helmRelease.GetNamespace(),
+				helmRelease.GetName(),
+				err)
+		}
+		helmRelease = resolvedRelease
+		repository, err := getRepositoryForHelmRelease(sources, helmRelease)
 		if err != nil {
 			return nil, fmt.Errorf(
 				"unable to find repository for HelmRelease %s/%s: %w",
@@ -639,45 +934,62 @@ func getReleaseRepos(
 }
 
 type releaseRepoRenderer struct {
-	ctx                 context.Context
-	logger              *slog.Logger
-	gitClientFactory    gitClientFactoryFunc
-	repoClientFactory   repositoryClientFactoryFunc
-	kubeVersion         *common.KubeVersion
-	apiVersions         []string
-	maxExpansions       int
-	gitRepoSubstitution *GitRepoSubstitution
-	chartCacheDir       string
-	chartCache          map[string]*chart.Chart
-	credentials         Credentials
+	config  loaderConfig
+	options ExpandOptions
+	// resolvedLock collects the rendered charts when options.LockOutput is
+	// set.
+	resolvedLock *Lock
+	// resolutions tracks the versions the chart version constraints resolve
+	// to when there is a persistent chart cache.
+	resolutions *resolutionHistory
+	// inventory collects the rendered resources when options.InventoryOutput
+	// is set.
+	inventory *Inventory
+	// fluxInventory collects the objects of the output when
+	// options.FluxInventoryOutput is set.
+	fluxInventory *FluxInventory
+	// outputDir writes the output into files when options.OutputDir is set.
+	outputDir *directoryWriter
+	// namespaces are the names of the Namespace objects in the output when
+	// options.CreateNamespaces is set.
+	namespaces  map[string]bool
+	cacheMisses []string
+	stream      *nodeStreamWriter
+	// lineage maps the HelmRelease objects to render in the current round to
+	// the chains of expansions which generated them.
+	lineage map[string][]ExpansionStep
+	// documents maps the nodes of the input to their documents in it.
+	documents map[*yaml.Node]inputDocument
 }
 
+// newReleaseRepoRenderer creates a renderer loading charts with the
+// configuration and expanding HelmRelease objects with the options, which are
+// expected to have the defaults applied.
 func newReleaseRepoRenderer(
-	ctx context.Context,
-	logger *slog.Logger,
-	gitClientFactory gitClientFactoryFunc,
-	repoClientFactory repositoryClientFactoryFunc,
-	kubeVersion *common.KubeVersion,
-	apiVersions []string,
-	maxExpansions int,
-	gitRepoSubstitution *GitRepoSubstitution,
-	chartCacheDir string,
-	chartCache map[string]*chart.Chart,
-	credentials Credentials,
+	config loaderConfig,
+	options ExpandOptions,
 ) *releaseRepoRenderer {
-	return &releaseRepoRenderer{
-		ctx:                 ctx,
-		logger:              logger,
-		gitClientFactory:    gitClientFactory,
-		repoClientFactory:   repoClientFactory,
-		kubeVersion:         kubeVersion,
-		apiVersions:         apiVersions,
-		maxExpansions:       maxExpansions,
-		gitRepoSubstitution: gitRepoSubstitution,
-		chartCacheDir:       chartCacheDir,
-		chartCache:          chartCache,
-		credentials:         credentials,
+	renderer := &releaseRepoRenderer{
+		config:  config,
+		options: options,
+	}
+	if options.LockOutput != nil {
+		renderer.resolvedLock = &Lock{}
+	}
+	// Substituted charts don't resolve their versions.
+	if options.ChartCacheDir != "" && len(options.ChartSubstitutions) == 0 {
+		renderer.resolutions = newResolutionHistory(config.logger, options.ChartCacheDir)
 	}
+	if options.InventoryOutput != nil {
+		renderer.inventory = &Inventory{}
+	}
+	if options.FluxInventoryOutput != nil {
+		renderer.fluxInventory = &FluxInventory{}
+	}
+	if options.CreateNamespaces {
+		renderer.namespaces = map[string]bool{}
+	}
+	return renderer
 }
 
 func (renderer *releaseRepoRenderer) filterStep(
@@ -686,79 +998,213 @@ func (renderer *releaseRepoRenderer) filterStep(
 ) ([]*yaml.RNode, []*yaml.RNode, error) {
 	result := []*yaml.RNode{}
 
-	releaseRepos, err := getReleaseRepos(allNodes, nodesToRender)
+	// The sources of releases in the skip list are never looked up, so that
+	// neither their charts nor their repositories are fetched.  Releases in
+	// the skip list are marked by markSkippedReleases.
+	releaseRepos, err := getReleaseRepos(
+		allNodes,
+		renderer.options.SkipList.filterReleases(nodesToRender),
+	)
 	if err != nil {
 		return nil, nil, fmt.Errorf("unable to get release repos: %w", err)
 	}
+	if err := renderer.checkCrossNamespaceRefs(releaseRepos); err != nil {
+		return nil, nil, err
+	}
+	if renderer.options.DefaultSourceURL != "" {
+		releaseRepos, err = renderer.addDefaultSources(releaseRepos)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	releaseRepos, err = renderer.skipMissingSources(releaseRepos)
+	if err != nil {
+		return nil, nil, err
+	}
+	if renderer.options.OrderByDependencies {
+		releaseRepos, err = orderReleasesByDependencies(releaseRepos)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
 
+	nextLineage := map[string][]ExpansionStep{}
 	for _, pair := range releaseRepos {
-		expanded, err := expandHelmRelease(
-			renderer.ctx,
-			renderer.logger,
-			renderer.gitClientFactory,
-			renderer.repoClientFactory,
-			renderer.kubeVersion,
-			renderer.apiVersions,
-			renderer.gitRepoSubstitution,
-			renderer.chartCacheDir,
-			renderer.chartCache,
-			renderer.credentials,
-			pair.release,
-			pair.repo,
-		)
+		if err := renderer.config.ctx.Err(); err != nil {
+			return nil, nil, fmt.Errorf("expansion canceled: %w", err)
+		}
+		expandedRelease, err := renderer.expandHelmRelease(pair.release, pair.repo)
+		var cacheMissErr *CacheMissError
+		if renderer.config.offline && errors.As(err, &cacheMissErr) {
+			// Report all of the missing entries at once at the end.
+			renderer.cacheMisses = append(renderer.cacheMisses, fmt.Sprintf(
+				"%s (Helm release %s/%s)",
+				cacheMissErr.Resource,
+				pair.release.GetNamespace(),
+				pair.release.GetName(),
+			))
+			continue
+		}
 		if err != nil {
 			return nil, nil, fmt.Errorf(
-				"unable to expand Helm release %s/%s: %w",
+				"%sunable to expand Helm release %s/%s: %w",
+				renderer.getPositionPrefix(pair.release),
 				pair.release.GetNamespace(),
 				pair.release.GetName(),
 				err,
 			)
 		}
+		chain := renderer.lineage[getReleaseKey(pair.release)]
+		step := ExpansionStep{
+			Namespace:    expandedRelease.Namespace,
+			Name:         expandedRelease.Name,
+			Chart:        expandedRelease.Chart,
+			ChartVersion: expandedRelease.ChartVersion,
+		}
+		if err := checkExpansionCycle(chain, step); err != nil {
+			return nil, nil, err
+		}
+		recordGeneratedReleases(
+			nextLineage,
+			append(slices.Clone(chain), step),
+			expandedRelease.Resources,
+		)
+		if renderer.options.OnReleaseExpanded != nil {
+			renderer.options.OnReleaseExpanded(expandedRelease)
+		}
+		expanded := expandedRelease.Resources
+		if renderer.options.OwnerLabels {
+			if err := addOwnerLabels(expanded, expandedRelease); err != nil {
+				return nil, nil, err
+			}
+		}
+		if renderer.options.CreateNamespaces {
+			renderer.recordNamespaces(expanded)
+			namespaces, err := renderer.getNamespaceNodes(expandedRelease)
+			if err != nil {
+				return nil, nil, err
+			}
+			expanded = append(namespaces, expanded...)
+		}
+		if renderer.options.ChartMetadata {
+			metadata, err := newChartMetadataNode(expandedRelease)
+			if err != nil {
+				return nil, nil, err
+			}
+			expanded = append(slices.Clone(expanded), metadata)
+		}
+		if renderer.options.ExpansionAnnotations {
+			// The chains of releases in round N have N-1 expansions.
+			if err := annotateExpansion(expanded, len(chain)+1, expandedRelease); err != nil {
+				return nil, nil, err
+			}
+		}
+		if len(renderer.options.CommonLabels) > 0 || len(renderer.options.CommonAnnotations) > 0 {
+			err := addCommonMetadata(
+				expanded,
+				renderer.options.CommonLabels,
+				renderer.options.CommonAnnotations,
+			)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		renderer.markSkippedReleases(expanded)
+		renderer.outputDir.recordRelease(expanded, expandedRelease)
+		if renderer.inventory != nil {
+			if err := renderer.inventory.add(expandedRelease, expanded); err != nil {
+				return nil, nil, err
+			}
+		}
+		if renderer.sortsPerRelease() {
+			if err := sortNodes(expanded, renderer.options.SortOrder); err != nil {
+				return nil, nil, fmt.Errorf("unable to sort generated resources: %w", err)
+			}
+		}
+		if renderer.stream != nil {
+			if err := renderer.stream.write(expanded); err != nil {
+				return nil, nil, err
+			}
+			expanded = getExpansionInputs(expanded)
+		}
 		result = append(result, expanded...)
 	}
+	renderer.lineage = nextLineage
 
-	slices.SortStableFunc(result, func(a, b *yaml.RNode) int {
-		aKind := a.GetKind()
-		bKind := b.GetKind()
-		if aKind < bKind {
-			return -1
-		} else if aKind > bKind {
-			return 1
+	if !renderer.sortsPerRelease() {
+		if err := sortNodes(result, renderer.options.SortOrder); err != nil {
+			return nil, nil, fmt.Errorf("unable to sort generated resources: %w", err)
 		}
+	}
+	return append(allNodes, result...), result, nil
+}
 
-		aVersion := a.GetApiVersion()
-		bVersion := b.GetApiVersion()
-		if aVersion < bVersion {
-			return -1
-		} else if aVersion > bVersion {
-			return 1
-		}
+// sortsPerRelease tells whether the resources generated from each HelmRelease
+// are sorted separately instead of all resources generated in a round being
+// sorted together.
+func (renderer *releaseRepoRenderer) sortsPerRelease() bool {
+	return renderer.stream != nil || renderer.options.OrderByDependencies
+}
 
-		aNamespace := a.GetNamespace()
-		bNamespace := b.GetNamespace()
-		if aNamespace < bNamespace {
-			return -1
-		} else if aNamespace > bNamespace {
-			return 1
+// markSkippedReleases notes in the comments of HelmRelease objects in the skip
+// list that they are passed through without expansion.
+func (renderer *releaseRepoRenderer) markSkippedReleases(nodes []*yaml.RNode) {
+	for _, node := range nodes {
+		if yamlutil.GetGroup(node) != "helm.toolkit.fluxcd.io" ||
+			node.GetKind() != "HelmRelease" ||
+			!renderer.options.SkipList.contains(node) {
+			continue
 		}
-
-		aName := a.GetName()
-		bName := b.GetName()
-		if aName < bName {
-			return -1
-		} else if aName > bName {
-			return 1
+		renderer.config.logger.
+			With("namespace", node.GetNamespace()).
+			With("name", node.GetName()).
+			Warn("Skipping expansion of Helm release in the skip list")
+		note := "Expansion skipped: the Helm release is in the skip list"
+		if comment := node.YNode().HeadComment; comment != "" {
+			note = comment + "\n" + note
 		}
-		return 0
-	})
-	return append(allNodes, result...), result, nil
+		node.YNode().HeadComment = note
+	}
 }
 
 func (renderer *releaseRepoRenderer) Filter(
 	nodes []*yaml.RNode,
 ) ([]*yaml.RNode, error) {
-	newNodes := nodes
-	for range renderer.maxExpansions {
+	// The filter only applies to the input, HelmRelease objects produced by
+	// expansion are all expanded further.
+	newNodes := renderer.options.ReleaseFilter.filterReleases(nodes)
+	renderer.markSkippedReleases(newNodes)
+	return renderer.expand(nodes, newNodes)
+}
+
+// streamNodes expands HelmRelease objects like Filter, but writes the input
+// and the resources rendered from each HelmRelease to the output as soon as
+// they are available instead of holding all of them in memory.  The generated
+// resources are sorted per HelmRelease rather than all together.
+func (renderer *releaseRepoRenderer) streamNodes(
+	nodes []*yaml.RNode,
+	output io.Writer,
+) error {
+	renderer.stream = &nodeStreamWriter{
+		writer:     output,
+		nodeWriter: renderer.getOutputWriter(output),
+	}
+	newNodes := renderer.options.ReleaseFilter.filterReleases(nodes)
+	renderer.markSkippedReleases(newNodes)
+	if err := renderer.stream.write(nodes); err != nil {
+		return err
+	}
+	_, err := renderer.expand(getExpansionInputs(nodes), newNodes)
+	return err
+}
+
+// expand performs the expansion rounds, starting with rendering the
+// HelmRelease objects in newNodes.
+func (renderer *releaseRepoRenderer) expand(
+	nodes []*yaml.RNode,
+	newNodes []*yaml.RNode,
+) ([]*yaml.RNode, error) {
+	for round := range renderer.options.MaxExpansions {
 		var err error
 		nodes, newNodes, err = renderer.filterStep(nodes, newNodes)
 		if err != nil {
@@ -767,6 +1213,18 @@ func (renderer *releaseRepoRenderer) Filter(
 		if len(newNodes) == 0 {
 			break
 		}
+		if round == renderer.options.MaxExpansions-1 && len(renderer.lineage) > 0 {
+			renderer.config.logger.
+				With("releases", slices.Sorted(maps.Keys(renderer.lineage))).
+				With("maxExpansions", renderer.options.MaxExpansions).
+				Warn("Reached the expansion limit, generated Helm releases are left unexpanded")
+		}
+	}
+	if len(renderer.cacheMisses) > 0 {
+		return nil, NewClassifiedError(ErrorClassFetch, fmt.Errorf(
+			"entries missing from the chart cache in the offline mode:\n  %s",
+			strings.Join(renderer.cacheMisses, "\n  "),
+		))
 	}
 	return nodes, nil
 }
@@ -776,12 +1234,25 @@ type HelmReleaseExpander struct {
 	logger            *slog.Logger
 	gitClientFactory  gitClientFactoryFunc
 	repoClientFactory repositoryClientFactoryFunc
+	fetchLimiter      *semaphore.Weighted
+	mirrors           Mirrors
+	hostLimiter       *hostLimiter
+	indexMaxAge       time.Duration
+	gitCloneOptions   GitCloneOptions
+	connections       *connectionPool
+	tagCache          *tagCache
+	verifyCacheSince  time.Time
 }
 
+// GitRepoSubstitution replaces a Git repository, identified either by its URL
+// or by the namespace and name of its GitRepository object, with a local
+// working copy.
 type GitRepoSubstitution struct {
-	URL    string
-	Branch string
-	Path   string
+	URL       string `yaml:"url,omitempty"`
+	Namespace string `yaml:"namespace,omitempty"`
+	Name      string `yaml:"name,omitempty"`
+	Branch    string `yaml:"branch,omitempty"`
+	Path      string `yaml:"path"`
 }
 
 func NewHelmReleaseExpander(
@@ -795,9 +1266,500 @@ func NewHelmReleaseExpander(
 		logger:            logger,
 		gitClientFactory:  gitClientFactory,
 		repoClientFactory: repoClientFactory,
+		connections:       newConnectionPool(),
+		tagCache:          newTagCache(),
+	}
+}
+
+// WithMaxConcurrentFetches limits the number of concurrent Git clones and
+// chart, index, and tag downloads.  Zero means no limit.
+func (expander *HelmReleaseExpander) WithMaxConcurrentFetches(
+	maxFetches int,
+) *HelmReleaseExpander {
+	if maxFetches > 0 {
+		expander.fetchLimiter = semaphore.NewWeighted(int64(maxFetches))
+	} else {
+		expander.fetchLimiter = nil
+	}
+	return expander
+}
+
+// WithHostRateLimit limits the requests per second and the concurrent
+// requests to each host of the Git repositories, Helm repositories, and OCI
+// registries.  Zero means no limit.
+func (expander *HelmReleaseExpander) WithHostRateLimit(
+	requestsPerSecond float64,
+	maxConcurrentRequests int,
+) *HelmReleaseExpander {
+	expander.hostLimiter = newHostLimiter(requestsPerSecond, maxConcurrentRequests)
+	return expander
+}
+
+// WithIndexMaxAge makes the Helm repository indexes cached for longer than
+// the maximum age be revalidated, downloading them again only if they have
+// changed.  Zero means that cached indexes are never refreshed.
+func (expander *HelmReleaseExpander) WithIndexMaxAge(maxAge time.Duration) *HelmReleaseExpander {
+	expander.indexMaxAge = maxAge
+	return expander
+}
+
+// WithGitCloneOptions sets how Git repositories are cloned, unless their
+// entries in the credentials override it.
+func (expander *HelmReleaseExpander) WithGitCloneOptions(options GitCloneOptions) *HelmReleaseExpander {
+	expander.gitCloneOptions = options
+	return expander
+}
+
+// WithTagCaching sets whether the tags listed for the charts in OCI
+// registries are kept for the rest of the run, which they are by default.
+// Without caching, the tags are listed for every HelmRelease, picking up the
+// tags pushed during the run.
+func (expander *HelmReleaseExpander) WithTagCaching(enabled bool) *HelmReleaseExpander {
+	if enabled {
+		expander.tagCache = newTagCache()
+	} else {
+		expander.tagCache = nil
+	}
+	return expander
+}
+
+// WithCacheVerification makes the charts in the file cache be verified
+// against their sources before they are used, downloading them again when
+// their versions have been published again with different content.  Charts
+// from Helm repositories are checked against the digests in the revalidated
+// indexes, revalidated once per run, and charts from OCI registries against
+// the digests of their manifests.
+func (expander *HelmReleaseExpander) WithCacheVerification(enabled bool) *HelmReleaseExpander {
+	if enabled {
+		expander.verifyCacheSince = time.Now()
+	} else {
+		expander.verifyCacheSince = time.Time{}
+	}
+	return expander
+}
+
+// WithMirrors makes the charts and repositories be fetched from the mirrors
+// of their URLs.
+func (expander *HelmReleaseExpander) WithMirrors(mirrors Mirrors) *HelmReleaseExpander {
+	expander.mirrors = mirrors
+	return expander
+}
+
+// getLoaderConfig returns the configuration of the repository loaders with
+// the settings of the expander, to be completed with the settings of the
+// operation.
+func (expander *HelmReleaseExpander) getLoaderConfig() loaderConfig {
+	return loaderConfig{
+		ctx:               expander.ctx,
+		logger:            expander.logger,
+		gitClientFactory:  expander.gitClientFactory,
+		repoClientFactory: expander.repoClientFactory,
+		fetchLimiter:      expander.fetchLimiter,
+		mirrors:           expander.mirrors,
+		hostLimiter:       expander.hostLimiter,
+		indexMaxAge:       expander.indexMaxAge,
+		gitCloneOptions:   expander.gitCloneOptions,
+		connections:       expander.connections,
+		tagCache:          expander.tagCache,
+		verifyCacheSince:  expander.verifyCacheSince,
+	}
+}
+
+// cleanUpEphemeralCache removes the ephemeral subtree of the chart cache.
+// Non-fixed GitRepository references like branches are not cacheable and are
+// left in the ephemeral subtree, which we need to clean up at the end.
+func (expander *HelmReleaseExpander) cleanUpEphemeralCache(chartCacheDir string) {
+	if chartCacheDir == "" {
+		return
+	}
+	ephemeralCacheDir := getEphemeralCacheDir(chartCacheDir)
+	if err := os.RemoveAll(ephemeralCacheDir); err != nil {
+		expander.logger.
+			With("directory", ephemeralCacheDir).
+			With("error", err).
+			Error("Unable to clean up ephemeral repository directory")
+	}
+	// Other invocations may still be using the parent directory, in which case
+	// it is not empty and is not removed.
+	_ = os.Remove(filepath.Dir(ephemeralCacheDir))
+}
+
+// DefaultMaxExpansions is the number of expansion rounds performed when
+// ExpandOptions.MaxExpansions is not set.
+const DefaultMaxExpansions = 1
+
+// ExpandOptions configures expansion of HelmRelease objects.  The zero value
+// expands every HelmRelease in the input once, without a persistent chart
+// cache, and sorts the output by kind.
+type ExpandOptions struct {
+	// Credentials for the chart repositories.
+	Credentials Credentials
+	// KubeVersion to pass to the charts in .Capabilities.KubeVersion; the Helm
+	// default is used when nil.
+	KubeVersion *common.KubeVersion
+	// APIVersions to pass to the charts in .Capabilities.APIVersions.
+	APIVersions []string
+	// APIResources are the API versions and the group/version/Kind entries of
+	// a cluster, e.g. read by ReadAPIResources, which replace the Helm default
+	// API versions in .Capabilities.APIVersions when set.
+	APIResources []string
+	// GitRepoSubstitutions replace Git repositories with local working copies.
+	// The first substitution matching a repository is used.
+	GitRepoSubstitutions []*GitRepoSubstitution
+	// ChartSubstitutions replace charts from Helm and OCI repositories with
+	// local chart directories or archives.
+	ChartSubstitutions []*ChartSubstitution
+	// GitRepoSubstitution replaces a Git repository with a local working copy.
+	//
+	// Deprecated: use GitRepoSubstitutions.
+	GitRepoSubstitution *GitRepoSubstitution
+	// MaxExpansions limits the rounds of expansion of HelmRelease objects
+	// produced by rendering charts; DefaultMaxExpansions when zero.
+	MaxExpansions int
+	// ChartCacheDir is the persistent chart cache directory; charts are not
+	// cached between invocations when empty.
+	ChartCacheDir string
+	// EnableChartInMemoryCache reuses charts loaded once for multiple releases.
+	EnableChartInMemoryCache bool
+	// ChartCache, when set, keeps the loaded charts for later expansions and
+	// takes precedence over EnableChartInMemoryCache.  Expansions with
+	// substitutions don't use it, as the working copies and the local charts
+	// change between expansions.
+	ChartCache *ChartCache
+	// Lock, when set, makes releases render from exactly the locked charts.
+	Lock *Lock
+	// LockOutput, when set, receives the lock with the rendered charts.
+	LockOutput io.Writer
+	// InventoryOutput, when set, receives the inventory of the resources
+	// rendered from the HelmRelease objects.
+	InventoryOutput io.Writer
+	// FluxInventoryOutput, when set, receives the inventory of all of the
+	// objects of the output in the format Flux tracks applied objects in,
+	// see FluxInventory.
+	FluxInventoryOutput io.Writer
+	// FluxInventoryFormat is the format of the Flux inventory;
+	// FluxInventoryFormatJSON when empty.
+	FluxInventoryFormat FluxInventoryFormat
+	// OutputDir, when set, is the directory to write the output into, each
+	// object into a file of its own, instead of the output writer.
+	OutputDir string
+	// OutputSplit groups the objects written into OutputDir into files;
+	// OutputSplitObject when empty.
+	OutputSplit OutputSplit
+	// Kustomization makes the expansion write a kustomization file listing
+	// the files into OutputDir.
+	Kustomization bool
+	// KustomizationLabels are the labels for the kustomization to add to all
+	// of the objects, not including selectors.
+	KustomizationLabels map[string]string
+	// SortOrder of the output resources; DefaultSortOrder when empty.
+	SortOrder SortOrder
+	// OrderByDependencies emits the resources generated from HelmRelease
+	// objects after the ones generated from the HelmRelease objects they
+	// depend on via spec.dependsOn, sorting them per HelmRelease, and fails on
+	// dependency cycles.
+	OrderByDependencies bool
+	// ReleaseFilter selects the HelmRelease objects in the input to expand.
+	ReleaseFilter *ReleaseFilter
+	// SkipList lists HelmRelease objects to pass through without expanding.
+	SkipList ReleaseSkipList
+	// Offline forbids network access, failing on cache misses.
+	Offline bool
+	// AllowLocalSources allows GitRepository objects with file:// URLs, which
+	// are cloned from the local file system, also in the offline mode.
+	AllowLocalSources bool
+	// AllowMissingSources passes HelmRelease objects with chart sources
+	// missing from the input through without expansion, logging warnings,
+	// instead of failing.
+	AllowMissingSources bool
+	// DefaultSourceURL, when set, is the URL of the chart sources built for
+	// HelmRelease objects referencing sources missing from the input, of the
+	// kind they reference.
+	DefaultSourceURL string
+	// NoCrossNamespaceRefs fails on HelmRelease objects referencing chart
+	// sources in other namespaces, like Flux run with --no-cross-namespace-refs.
+	NoCrossNamespaceRefs bool
+	// VendorManifest, when set, makes releases render from vendored charts.
+	VendorManifest *VendorManifest
+	// ValuesOverrides are merged in order on top of spec.values of the
+	// HelmRelease objects they match.
+	ValuesOverrides []*ValuesOverride
+	// ValuesFromEnv merges the YAML values of the environment variables named
+	// by the ValuesEnvAnnotation of HelmRelease objects into their
+	// spec.values, below ValuesOverrides.  The annotation is ignored with a
+	// warning otherwise.
+	ValuesFromEnv bool
+	// LookupProvider, when set, serves the lookup function in chart templates,
+	// which returns empty objects otherwise.
+	LookupProvider engine.ClientProvider
+	// ChartMetadata adds a ConfigMap recording the chart of each HelmRelease
+	// to the output, see newChartMetadataNode.
+	ChartMetadata bool
+	// ExpansionAnnotations records the expansion round and the HelmRelease
+	// which generated each resource in its annotations.
+	ExpansionAnnotations bool
+	// OwnerLabels sets the labels helm-controller adds to the resources of
+	// each HelmRelease, ReleaseNameLabel and ReleaseNamespaceLabel, on the
+	// resources rendered from it.
+	OwnerLabels bool
+	// Streaming writes the resources rendered from each HelmRelease as soon as
+	// they are available instead of all of them at the end, bounding memory
+	// use on large inputs.  The resources are then sorted per HelmRelease, and
+	// the output may be incomplete when the expansion fails.
+	Streaming bool
+	// OnReleaseExpanded, when set, is called with the resources rendered from
+	// each HelmRelease before they are sorted and written to the output.
+	OnReleaseExpanded func(release *ExpandedRelease)
+	// Strict fails on fields of the HelmRelease and Flux source objects in the
+	// input which their APIs don't define instead of ignoring them.
+	Strict bool
+	// FailOnEmpty fails the expansion of HelmRelease objects which render no
+	// resources instead of warning about them.
+	FailOnEmpty bool
+	// PreserveInput writes the documents of the input which pass through the
+	// expansion unchanged as they are in the input, with their comments and
+	// formatting, and serializes only the generated and changed documents.
+	PreserveInput bool
+	// Normalize writes all of the output without comments, with the keys of
+	// maps sorted, and with the lists of named containers, volumes, ports,
+	// and image pull secrets sorted by name, so that semantically equal
+	// output is written byte for byte the same.  It cannot be used with
+	// PreserveInput.
+	Normalize bool
+	// CreateNamespaces adds a Namespace object for the target namespace of
+	// each HelmRelease to the output, unless there is one already.
+	CreateNamespaces bool
+	// CommonLabels are set on all of the objects generated from the
+	// HelmRelease objects, but not on the objects of the input.
+	CommonLabels map[string]string
+	// CommonAnnotations are set on all of the objects generated from the
+	// HelmRelease objects, but not on the objects of the input.
+	CommonAnnotations map[string]string
+	// KeepTestHooks keeps the resources of the charts annotated as Helm
+	// tests, which Flux only runs as tests if enabled and never applies as
+	// regular resources, in the output.
+	KeepTestHooks bool
+	// CRDs determines whether the custom resource definitions of the charts
+	// are added to the output according to the CRD policies of each
+	// HelmRelease; CRDsModeNone when empty.
+	CRDs CRDsMode
+	// PostRenderPatches are applied in turn to the resources rendered from
+	// all of the HelmRelease objects.
+	PostRenderPatches []*PostRenderPatch
+	// ResolveImagePolicies sets the values of HelmRelease objects marked with
+	// Flux image policy markers to the latest images of the ImagePolicy
+	// objects in the input and of ImagePolicies.
+	ResolveImagePolicies bool
+	// ImagePolicies are the latest images of image policies, which take
+	// precedence over the statuses of the ImagePolicy objects in the input.
+	ImagePolicies ImagePolicies
+	// ImageRewrites replace the prefixes of the images of the containers in
+	// the resources rendered from the HelmRelease objects.
+	ImageRewrites ImageRewrites
+	// Reproducible renders the charts with template functions returning the
+	// same values on every expansion in place of the ones returning the
+	// current time, random values, and generated keys and certificates, which
+	// are replaced with placeholders.
+	Reproducible bool
+	// DigestOutput, when set, receives the SHA-256 digest of the output, or
+	// of the files written into OutputDir, as sha256:<hex>.
+	DigestOutput io.Writer
+	// LegacyReleaseNames derives the names of Helm releases without
+	// spec.releaseName as <target namespace>-<name>, with the target namespace
+	// defaulting to the namespace of the HelmRelease, and without shortening
+	// them, instead of the way helm-controller does.
+	LegacyReleaseNames bool
+}
+
+// ExpandedRelease holds the resources rendered from a HelmRelease.
+type ExpandedRelease struct {
+	Namespace string
+	Name      string
+	// TargetNamespace is the namespace the resources are rendered into.
+	TargetNamespace string
+	// ReleaseName is the name of the Helm release.
+	ReleaseName string
+	// StorageNamespace is the namespace of the Helm storage of the release.
+	StorageNamespace string
+	Chart            string
+	ChartVersion     string
+	AppVersion       string
+	// SourceURL is the URL of the repository the chart comes from.
+	SourceURL string
+	// Digest of the chart files, as recorded in locks.
+	Digest      string
+	KubeVersion string
+	Resources   []*yaml.RNode
+	// driftIgnoreRules are the rules of the drift detection of the
+	// HelmRelease, applied when comparing the resources.
+	driftIgnoreRules []driftIgnoreRule
+}
+
+func (options ExpandOptions) withDefaults() ExpandOptions {
+	if options.MaxExpansions == 0 {
+		options.MaxExpansions = DefaultMaxExpansions
+	}
+	if options.SortOrder == "" {
+		options.SortOrder = DefaultSortOrder
+	}
+	if options.FluxInventoryFormat == "" {
+		options.FluxInventoryFormat = FluxInventoryFormatJSON
+	}
+	if options.CRDs == "" {
+		options.CRDs = CRDsModeNone
+	}
+	if options.OutputSplit == "" {
+		options.OutputSplit = OutputSplitObject
+	}
+	if options.GitRepoSubstitution != nil {
+		options.GitRepoSubstitutions = append(
+			slices.Clone(options.GitRepoSubstitutions),
+			options.GitRepoSubstitution,
+		)
+		options.GitRepoSubstitution = nil
+	}
+	return options
+}
+
+// Expand renders the HelmRelease objects in the input and writes the input
+// objects together with the rendered resources to the output.
+func (expander *HelmReleaseExpander) Expand(
+	input io.Reader,
+	output io.Writer,
+	options ExpandOptions,
+) error {
+	options = options.withDefaults()
+	if options.Normalize && options.PreserveInput {
+		return NewClassifiedError(ErrorClassInput, errors.New(
+			"the output cannot be both normalized and preserve the input",
+		))
+	}
+	if options.Kustomization && options.OutputDir == "" {
+		return NewClassifiedError(ErrorClassInput, errors.New(
+			"a kustomization can only be written with an output directory",
+		))
+	}
+	if options.OutputSplit != OutputSplitObject && options.OutputDir == "" {
+		return NewClassifiedError(ErrorClassInput, errors.New(
+			"the output can only be split with an output directory",
+		))
+	}
+
+	config := expander.getLoaderConfig()
+	config.gitRepoSubstitutions = options.GitRepoSubstitutions
+	config.chartSubstitutions = options.ChartSubstitutions
+	config.cacheRoot = options.ChartCacheDir
+	config.credentials = options.Credentials
+	config.offline = options.Offline
+	config.allowLocalSources = options.AllowLocalSources
+	if options.ChartCache != nil &&
+		len(options.GitRepoSubstitutions) == 0 &&
+		len(options.ChartSubstitutions) == 0 {
+		config.chartCache = options.ChartCache.charts
+	} else if options.EnableChartInMemoryCache {
+		config.chartCache = newInMemoryChartCache()
+	}
+
+	expander.migrateCache(options.ChartCacheDir)
+	defer expander.cleanUpEphemeralCache(options.ChartCacheDir)
+
+	var digest hash.Hash
+	if options.DigestOutput != nil {
+		digest = sha256.New()
+		output = io.MultiWriter(output, digest)
+	}
+	filter := newReleaseRepoRenderer(config, options)
+	if options.OutputDir != "" {
+		newWriter := filter.newWriter
+		if digest != nil {
+			newWriter = func(output io.Writer) kio.Writer {
+				return filter.newWriter(io.MultiWriter(output, digest))
+			}
+		}
+		filter.outputDir = newDirectoryWriter(options.OutputDir, options.OutputSplit, newWriter)
+		output = io.Discard
+	}
+
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return fmt.Errorf("unable to read input: %w", err)
+	}
+	inputText, documents := splitInput(string(data))
+	nodes, err := (&kio.ByteReader{Reader: strings.NewReader(inputText)}).Read()
+	if err != nil {
+		return NewClassifiedError(
+			ErrorClassInput,
+			fmt.Errorf("unable to parse input: %w", err),
+		)
+	}
+	if err := filter.setInputDocuments(nodes, documents); err != nil {
+		return err
+	}
+	filter.config.secrets = newSecretIndex(nodes)
+	if options.CreateNamespaces {
+		filter.recordNamespaces(nodes)
+	}
+	if options.ResolveImagePolicies {
+		if err := filter.resolveImagePolicies(nodes); err != nil {
+			return err
+		}
+	}
+	if options.Strict {
+		if err := checkUnknownFields(nodes); err != nil {
+			return err
+		}
 	}
+	if options.Streaming {
+		if err := filter.streamNodes(nodes, output); err != nil {
+			return err
+		}
+	} else {
+		// The input is parsed up front to tell parse errors from the
+		// expansion ones.
+		err := kio.Pipeline{
+			Inputs:  []kio.Reader{&kio.PackageBuffer{Nodes: nodes}},
+			Filters: []kio.Filter{filter},
+			Outputs: []kio.Writer{filter.getOutputWriter(output)},
+		}.Execute()
+		if err != nil {
+			return err
+		}
+	}
+
+	filter.resolutions.write()
+	if filter.resolvedLock != nil {
+		if err := filter.resolvedLock.Write(options.LockOutput); err != nil {
+			return fmt.Errorf("unable to write lock: %w", err)
+		}
+	}
+	if filter.inventory != nil {
+		if err := filter.inventory.Write(options.InventoryOutput); err != nil {
+			return fmt.Errorf("unable to write inventory: %w", err)
+		}
+	}
+	if filter.fluxInventory != nil {
+		err := filter.fluxInventory.Write(options.FluxInventoryOutput, options.FluxInventoryFormat)
+		if err != nil {
+			return err
+		}
+	}
+	if options.Kustomization {
+		if err := filter.outputDir.writeKustomization(options.KustomizationLabels); err != nil {
+			return err
+		}
+	}
+	if digest != nil {
+		if _, err := fmt.Fprintf(options.DigestOutput, "sha256:%x\n", digest.Sum(nil)); err != nil {
+			return fmt.Errorf("unable to write digest: %w", err)
+		}
+	}
+	return nil
 }
 
+// ExpandHelmReleases renders the HelmRelease objects in the input.
+//
+// Deprecated: use Expand, which takes ExpandOptions and is not affected by
+// new settings being added.
 func (expander *HelmReleaseExpander) ExpandHelmReleases(
 	credentials Credentials,
 	input io.Reader,
@@ -809,43 +1771,13 @@ func (expander *HelmReleaseExpander) ExpandHelmReleases(
 	chartCacheDir string,
 	enableChartInMemoryCache bool,
 ) error {
-	var chartCache map[string]*chart.Chart
-	if enableChartInMemoryCache {
-		chartCache = make(map[string]*chart.Chart)
-	}
-
-	// Non-fixed GitRepository references like branches are not cacheable and
-	// are left in the ephemeral subtree, which we need to clean up at the
-	// end.
-	defer func() {
-		if chartCacheDir != "" {
-			ephemeralCacheDir := filepath.Join(chartCacheDir, "ephemeral")
-			if err := os.RemoveAll(ephemeralCacheDir); err != nil {
-				expander.logger.
-					With("directory", ephemeralCacheDir).
-					With("error", err).
-					Error("Unable to clean up ephemeral repository directory")
-			}
-		}
-	}()
-
-	filter := newReleaseRepoRenderer(
-		expander.ctx,
-		expander.logger,
-		expander.gitClientFactory,
-		expander.repoClientFactory,
-		kubeVersion,
-		apiVersions,
-		maxExpansions,
-		gitRepoSubstitution,
-		chartCacheDir,
-		chartCache,
-		credentials,
-	)
-
-	return kio.Pipeline{
-		Inputs:  []kio.Reader{&kio.ByteReader{Reader: input}},
-		Filters: []kio.Filter{filter},
-		Outputs: []kio.Writer{kio.ByteWriter{Writer: output}},
-	}.Execute()
+	return expander.Expand(input, output, ExpandOptions{
+		Credentials:              credentials,
+		KubeVersion:              kubeVersion,
+		APIVersions:              apiVersions,
+		GitRepoSubstitution:      gitRepoSubstitution,
+		MaxExpansions:            maxExpansions,
+		ChartCacheDir:            chartCacheDir,
+		EnableChartInMemoryCache: enableChartInMemoryCache,
+	})
 }
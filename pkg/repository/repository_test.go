@@ -436,7 +436,7 @@ var _ = ginkgo.Describe("HelmRelease expansion check", func() {
 			"kind: ConfigMap",
 			"metadata:",
 			"  namespace: testns",
-			"  name: testns-test-configmap",
+			"  name: test-configmap",
 			"data:",
 			"  foo: baz",
 			"---",
@@ -445,7 +445,7 @@ var _ = ginkgo.Describe("HelmRelease expansion check", func() {
 			"kind: ConfigMap",
 			"metadata:",
 			"  namespace: testns",
-			"  name: testns-test-configmap-2",
+			"  name: test-configmap-2",
 			"data:",
 			"  foo: baz",
 			"",
@@ -667,7 +667,7 @@ var _ = ginkgo.Describe("HelmRelease expansion check", func() {
 			"kind: ConfigMap",
 			"metadata:",
 			"  namespace: testns",
-			"  name: testns-test-configmap",
+			"  name: test-configmap",
 			"data:",
 			"  foo: baz",
 			"", // Templates from the disabled dependency charts do not show up.
@@ -767,7 +767,7 @@ var _ = ginkgo.Describe("HelmRelease expansion check", func() {
 			"apiVersion: v1",
 			"kind: ServiceAccount",
 			"metadata:",
-			"  name: testns-test-serviceaccount",
+			"  name: test-serviceaccount",
 			"  namespace: testns", // Namespace is added as the last metadata attribute.
 			"",
 		}, "\n"),
@@ -872,7 +872,7 @@ var _ = ginkgo.Describe("HelmRelease expansion check", func() {
 			"kind: ConfigMap",
 			"metadata:",
 			"  namespace: testns",
-			"  name: testns-test-configmap",
+			"  name: test-configmap",
 			"data:",
 			"  kube-version: v1.222",
 			"",
@@ -976,7 +976,7 @@ var _ = ginkgo.Describe("HelmRelease expansion check", func() {
 			"kind: ConfigMap",
 			"metadata:",
 			"  namespace: testns",
-			"  name: testns-test-configmap",
+			"  name: test-configmap",
 			"data:",
 			"  keeps-default-capabilities: true", // The chart also has access to default capabilities.
 			"",
@@ -1086,7 +1086,7 @@ var _ = ginkgo.Describe("HelmRelease expansion check", func() {
 			"kind: ConfigMap",
 			"metadata:",
 			"  namespace: testns",
-			"  name: testns-test-configmap",
+			"  name: test-configmap",
 			"data:",
 			"  foo: bar",
 			"",
@@ -1272,7 +1272,7 @@ var _ = ginkgo.Describe("HelmRelease expansion check", func() {
 			"kind: HelmRelease",
 			"metadata:",
 			"  namespace: testns",
-			"  name: testns-test-release",
+			"  name: test-release",
 			"spec:",
 			"  chart:",
 			"    spec:",
@@ -1290,7 +1290,7 @@ var _ = ginkgo.Describe("HelmRelease expansion check", func() {
 			"kind: ConfigMap",
 			"metadata:",
 			"  namespace: testns",
-			"  name: testns-testns-test-release-configmap",
+			"  name: test-release-configmap",
 			"data:",
 			"  foo: brrrr",
 			"",
@@ -1440,9 +1440,222 @@ var _ = ginkgo.Describe("HelmRelease expansion check", func() {
 			false,
 		)
 		g.Expect(err).To(gomega.MatchError(
-			gomega.ContainSubstring("missing chart repository awol for Helm release testns/test"),
+			gomega.ContainSubstring("testns/test -> GitRepository testns/awol"),
 		))
 		err = stopServing(server, serverDone)
 		g.Expect(err).ToNot(gomega.HaveOccurred())
 	})
+
+	ginkgo.DescribeTable(
+		"validates values against the chart schema",
+		func(installSpec []string, expectedError string) {
+			repoRoot, err := os.MkdirTemp("", "")
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+			defer os.RemoveAll(repoRoot)
+			server, port, serverDone, err := serveDirectory(repoRoot, logger, nil)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+
+			chartFiles := map[string]string{
+				"Chart.yaml": strings.Join([]string{
+					"apiVersion: v2",
+					"name: test-chart",
+					"version: 0.1.0",
+				}, "\n"),
+				"values.yaml": strings.Join([]string{
+					"replicas: 1",
+				}, "\n"),
+				"values.schema.json": strings.Join([]string{
+					"{",
+					`  "$schema": "http://json-schema.org/draft-07/schema#",`,
+					`  "type": "object",`,
+					`  "properties": {`,
+					`    "replicas": {"type": "integer"}`,
+					"  }",
+					"}",
+				}, "\n"),
+				"templates/configmap.yaml": strings.Join([]string{
+					"apiVersion: v1",
+					"kind: ConfigMap",
+					"metadata:",
+					"  namespace: {{ .Release.Namespace }}",
+					"  name: {{ .Release.Name }}-configmap",
+					"data:",
+					"  replicas: {{ .Values.replicas | quote }}",
+				}, "\n"),
+			}
+			err = createSingleChartHelmRepository(
+				"test-chart",
+				"0.1.0",
+				chartFiles,
+				port,
+				repoRoot,
+			)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+
+			input := strings.Join(append(append([]string{
+				"apiVersion: helm.toolkit.fluxcd.io/v2",
+				"kind: HelmRelease",
+				"metadata:",
+				"  namespace: testns",
+				"  name: test",
+				"spec:",
+				"  chart:",
+				"    spec:",
+				"      chart: test-chart",
+				"      version: \">=0.1.0\"",
+				"      sourceRef:",
+				"        kind: HelmRepository",
+				"        name: local",
+			}, installSpec...),
+				"  values:",
+				"    replicas: many",
+				"---",
+				"apiVersion: source.toolkit.fluxcd.io/v1",
+				"kind: HelmRepository",
+				"metadata:",
+				"  namespace: testns",
+				"  name: local",
+				"spec:",
+				fmt.Sprintf("  url: http://localhost:%d", port),
+			), "\n")
+
+			expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+			err = expander.Expand(
+				bytes.NewBufferString(input),
+				&bytes.Buffer{},
+				ExpandOptions{},
+			)
+			if expectedError != "" {
+				g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(expectedError)))
+			} else {
+				g.Expect(err).ToNot(gomega.HaveOccurred())
+			}
+			err = stopServing(server, serverDone)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+		},
+		ginkgo.Entry(
+			"rejects values violating the schema",
+			[]string{},
+			"values for Helm release testns/test do not match the chart schema",
+		),
+		ginkgo.Entry(
+			"skips validation when disabled in the release",
+			[]string{
+				"  install:",
+				"    disableSchemaValidation: true",
+			},
+			"",
+		),
+	)
+
+	ginkgo.It("limits concurrent fetches", func() {
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil).
+			WithMaxConcurrentFetches(1)
+		timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		config := loaderConfig{ctx: timeoutCtx, fetchLimiter: expander.fetchLimiter}
+
+		releaseFetchSlot, err := config.acquireFetchSlot(repoURL)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		_, err = config.acquireFetchSlot(repoURL)
+		g.Expect(err).To(gomega.MatchError(context.DeadlineExceeded))
+
+		releaseFetchSlot()
+		config.ctx = ctx
+		releaseFetchSlot, err = config.acquireFetchSlot(repoURL)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		releaseFetchSlot()
+
+		expander.WithMaxConcurrentFetches(0)
+		g.Expect(expander.fetchLimiter).To(gomega.BeNil())
+	})
+
+	ginkgo.It("keeps the first published cache directory", func() {
+		cacheRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(cacheRoot)
+
+		targetDir := filepath.Join(cacheRoot, "repo", "chart-0.1.0")
+		populate := func(content string) func(dir string) error {
+			return func(dir string) error {
+				return os.WriteFile(filepath.Join(dir, "Chart.yaml"), []byte(content), 0600)
+			}
+		}
+		err = publishCacheDir(targetDir, populate("first"))
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		err = publishCacheDir(targetDir, populate("second"))
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		content, err := os.ReadFile(filepath.Join(targetDir, "Chart.yaml"))
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(string(content)).To(gomega.Equal("first"))
+		entries, err := os.ReadDir(filepath.Dir(targetDir))
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(entries).To(gomega.HaveLen(1))
+	})
+
+	ginkgo.It("cleans up only its own ephemeral cache", func() {
+		cacheRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(cacheRoot)
+
+		ownDir := getCachePathForRepo(cacheRoot, repoURL, true)
+		otherDir := filepath.Join(cacheRoot, "ephemeral", "other")
+		g.Expect(os.MkdirAll(ownDir, 0700)).To(gomega.Succeed())
+		g.Expect(os.MkdirAll(otherDir, 0700)).To(gomega.Succeed())
+
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		expander.cleanUpEphemeralCache(cacheRoot)
+		g.Expect(getEphemeralCacheDir(cacheRoot)).ToNot(gomega.BeADirectory())
+		g.Expect(otherDir).To(gomega.BeADirectory())
+	})
+
+	ginkgo.It("stops when canceled", func() {
+		cacheRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(cacheRoot)
+
+		canceledCtx, cancel := context.WithCancel(ctx)
+		cancel()
+		config := loaderConfig{ctx: canceledCtx}
+		_, err = config.acquireFetchSlot(repoURL)
+		g.Expect(err).To(gomega.MatchError(context.Canceled))
+
+		input := strings.Join([]string{
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"spec:",
+			"  chart:",
+			"    spec:",
+			"      chart: test-chart",
+			"      sourceRef:",
+			"        kind: HelmRepository",
+			"        name: local",
+			"---",
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: HelmRepository",
+			"metadata:",
+			"  namespace: testns",
+			"  name: local",
+			"spec:",
+			"  url: http://localhost:1",
+		}, "\n")
+		expander := NewHelmReleaseExpander(canceledCtx, logger, nil, nil)
+		err = expander.Expand(
+			bytes.NewBufferString(input),
+			&bytes.Buffer{},
+			ExpandOptions{
+				ChartCacheDir: cacheRoot,
+			},
+		)
+		g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(
+			"expansion canceled: context canceled",
+		)))
+		entries, err := os.ReadDir(cacheRoot)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(entries).To(gomega.BeEmpty())
+	})
 })
@@ -0,0 +1,179 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"math/rand/v2"
+	"text/template"
+	"time"
+
+	"helm.sh/helm/v4/pkg/engine"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+)
+
+// reproducibleTime is what the now function in chart templates returns in the
+// reproducible mode.
+var reproducibleTime = time.Unix(0, 0).UTC()
+
+// reproduciblePlaceholder stands in for the keys, certificates, and hashes
+// chart templates generate, which cannot be generated deterministically.
+const reproduciblePlaceholder = "REPRODUCIBLE-PLACEHOLDER"
+
+const (
+	alphaChars   = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	numericChars = "0123456789"
+)
+
+// reproducibleCertificate has the fields of the certificates the genCA,
+// genSelfSignedCert, and genSignedCert template functions return.
+type reproducibleCertificate struct {
+	Cert string
+	Key  string
+}
+
+// getRandomString returns a string of the count characters chosen from the
+// chars at random.
+func getRandomString(random *rand.Rand, count int, chars string) string {
+	result := make([]byte, count)
+	for i := range result {
+		result[i] = chars[random.IntN(len(chars))]
+	}
+	return string(result)
+}
+
+// getReproducibleFuncs returns the template functions replacing the ones of
+// Helm returning the current time, random values, or generated keys with
+// ones returning the same values on every rendering.  The random values are
+// drawn from a generator seeded with the seed, so that they differ between
+// releases, but not between renderings of the same release.
+func getReproducibleFuncs(seed string) template.FuncMap {
+	random := rand.New(rand.NewChaCha8(sha256.Sum256([]byte(seed))))
+	asciiChars := make([]byte, 0, 95)
+	for char := byte(' '); char <= '~'; char++ {
+		asciiChars = append(asciiChars, char)
+	}
+	certificate := func(...any) (reproducibleCertificate, error) {
+		return reproducibleCertificate{
+			Cert: reproduciblePlaceholder,
+			Key:  reproduciblePlaceholder,
+		}, nil
+	}
+
+	return template.FuncMap{
+		"now": func() time.Time {
+			return reproducibleTime
+		},
+		"ago": func(date any) string {
+			var then time.Time
+			switch date := date.(type) {
+			case time.Time:
+				then = date
+			case int:
+				then = time.Unix(int64(date), 0)
+			case int32:
+				then = time.Unix(int64(date), 0)
+			case int64:
+				then = time.Unix(date, 0)
+			}
+			return reproducibleTime.Sub(then).Round(time.Second).String()
+		},
+		"randAlphaNum": func(count int) string {
+			return getRandomString(random, count, alphaChars+numericChars)
+		},
+		"randAlpha": func(count int) string {
+			return getRandomString(random, count, alphaChars)
+		},
+		"randNumeric": func(count int) string {
+			return getRandomString(random, count, numericChars)
+		},
+		"randAscii": func(count int) string {
+			return getRandomString(random, count, string(asciiChars))
+		},
+		"randBytes": func(count int) (string, error) {
+			bytes := make([]byte, count)
+			for i := range bytes {
+				bytes[i] = byte(random.UintN(256))
+			}
+			return base64.StdEncoding.EncodeToString(bytes), nil
+		},
+		"randInt": func(min int, max int) int {
+			return random.IntN(max-min) + min
+		},
+		"uuidv4": func() string {
+			var uuid [16]byte
+			for i := range uuid {
+				uuid[i] = byte(random.UintN(256))
+			}
+			uuid[6] = uuid[6]&0x0f | 0x40
+			uuid[8] = uuid[8]&0x3f | 0x80
+			return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:])
+		},
+		"shuffle": func(text string) string {
+			runes := []rune(text)
+			random.Shuffle(len(runes), func(i, j int) {
+				runes[i], runes[j] = runes[j], runes[i]
+			})
+			return string(runes)
+		},
+		"genPrivateKey": func(string) string {
+			return reproduciblePlaceholder
+		},
+		"genCA":                    certificate,
+		"genCAWithKey":             certificate,
+		"genSelfSignedCert":        certificate,
+		"genSelfSignedCertWithKey": certificate,
+		"genSignedCert":            certificate,
+		"genSignedCertWithKey":     certificate,
+		"bcrypt": func(string) string {
+			return reproduciblePlaceholder
+		},
+		"htpasswd": func(username string, password string) string {
+			return username + ":" + reproduciblePlaceholder
+		},
+		"encryptAES": func(password string, plaintext string) (string, error) {
+			return reproduciblePlaceholder, nil
+		},
+	}
+}
+
+// newLookupFunction returns the lookup template function of Helm served by
+// the provider, as Helm only serves it from providers when there are no
+// custom template functions.
+func newLookupFunction(
+	provider engine.ClientProvider,
+) func(string, string, string, string) (map[string]any, error) {
+	return func(apiVersion string, kind string, namespace string, name string) (map[string]any, error) {
+		resourceClient, namespaced, err := provider.GetClientFor(apiVersion, kind)
+		if err != nil {
+			return map[string]any{}, err
+		}
+		var client dynamic.ResourceInterface = resourceClient
+		if namespaced && namespace != "" {
+			client = resourceClient.Namespace(namespace)
+		}
+		if name != "" {
+			object, err := client.Get(context.Background(), name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				return map[string]any{}, nil
+			}
+			if err != nil {
+				return map[string]any{}, err
+			}
+			return object.UnstructuredContent(), nil
+		}
+		list, err := client.List(context.Background(), metav1.ListOptions{})
+		if apierrors.IsNotFound(err) {
+			return map[string]any{}, nil
+		}
+		if err != nil {
+			return map[string]any{}, err
+		}
+		return list.UnstructuredContent(), nil
+	}
+}
@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Reproducible expansion", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+	})
+
+	ginkgo.It("draws the same random values for the same seeds", func() {
+		randAlphaNum := func(seed string) []string {
+			function := getReproducibleFuncs(seed)["randAlphaNum"].(func(int) string)
+			return []string{function(16), function(16)}
+		}
+		values := randAlphaNum("testns/test")
+		g.Expect(values[0]).To(gomega.HaveLen(16))
+		g.Expect(values[0]).ToNot(gomega.Equal(values[1]))
+		g.Expect(randAlphaNum("testns/test")).To(gomega.Equal(values))
+		g.Expect(randAlphaNum("testns/other")).ToNot(gomega.Equal(values))
+		uuid := getReproducibleFuncs("testns/test")["uuidv4"].(func() string)()
+		g.Expect(uuid).To(gomega.MatchRegexp(
+			`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`,
+		))
+	})
+
+	ginkgo.It("renders identical output with the same digest", func() {
+		repoRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(repoRoot)
+		server, port, serverDone, err := serveDirectory(repoRoot, logger, nil)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer func() {
+			g.Expect(stopServing(server, serverDone)).To(gomega.Succeed())
+		}()
+
+		err = createSingleChartHelmRepository(
+			"test-chart",
+			"0.1.0",
+			map[string]string{
+				"Chart.yaml": strings.Join([]string{
+					"apiVersion: v2",
+					"name: test-chart",
+					"version: 0.1.0",
+				}, "\n"),
+				"templates/secret.yaml": strings.Join([]string{
+					"{{- $ca := genCA \"test\" 365 }}",
+					"apiVersion: v1",
+					"kind: Secret",
+					"metadata:",
+					"  name: {{ .Release.Name }}-secret",
+					"  annotations:",
+					"    created: {{ now | date \"2006-01-02\" | quote }}",
+					"stringData:",
+					"  password: {{ randAlphaNum 32 | quote }}",
+					"  id: {{ uuidv4 | quote }}",
+					"  ca.crt: {{ $ca.Cert | quote }}",
+				}, "\n"),
+			},
+			port,
+			repoRoot,
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		input := strings.Join([]string{
+			strings.Join([]string{
+				"apiVersion: source.toolkit.fluxcd.io/v1",
+				"kind: HelmRepository",
+				"metadata:",
+				"  namespace: testns",
+				"  name: local",
+				"spec:",
+				fmt.Sprintf("  url: http://localhost:%d", port),
+			}, "\n"),
+			strings.Join([]string{
+				"apiVersion: helm.toolkit.fluxcd.io/v2",
+				"kind: HelmRelease",
+				"metadata:",
+				"  namespace: testns",
+				"  name: test",
+				"spec:",
+				"  chart:",
+				"    spec:",
+				"      chart: test-chart",
+				"      sourceRef:",
+				"        kind: HelmRepository",
+				"        name: local",
+			}, "\n"),
+		}, "\n---\n")
+
+		expand := func() (string, string) {
+			expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+			var output, digest bytes.Buffer
+			err := expander.Expand(
+				bytes.NewBufferString(input),
+				&output,
+				ExpandOptions{Reproducible: true, DigestOutput: &digest},
+			)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+			return output.String(), digest.String()
+		}
+		output, digest := expand()
+		g.Expect(output).To(gomega.ContainSubstring(`created: "1970-01-01"`))
+		g.Expect(output).To(gomega.ContainSubstring(`ca.crt: "REPRODUCIBLE-PLACEHOLDER"`))
+		g.Expect(digest).To(gomega.MatchRegexp(`^sha256:[0-9a-f]{64}\n$`))
+
+		secondOutput, secondDigest := expand()
+		g.Expect(secondOutput).To(gomega.Equal(output))
+		g.Expect(secondDigest).To(gomega.Equal(digest))
+	})
+})
@@ -0,0 +1,189 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"sync"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// resolutionsFileName is the name of the file in the chart cache directory
+// recording the versions the chart version constraints last resolved to.
+const resolutionsFileName = "resolutions.json"
+
+// resolutionKey identifies a chart version constraint of a chart in a
+// repository.
+type resolutionKey struct {
+	URL        string `json:"url"`
+	Chart      string `json:"chart"`
+	Constraint string `json:"constraint"`
+}
+
+// resolutionRecord is an entry of the resolutions file.
+type resolutionRecord struct {
+	resolutionKey
+	Version string `json:"version"`
+}
+
+// resolutionHistory tracks the versions the floating chart version
+// constraints resolve to across runs sharing a chart cache directory, and
+// warns when a constraint resolves to a different version than in the last
+// run, which changes the output without any change in the input.  It is safe
+// for concurrent use, and a nil history tracks nothing.
+type resolutionHistory struct {
+	logger   *slog.Logger
+	filePath string
+	mutex    sync.Mutex
+	previous map[resolutionKey]string
+	resolved map[resolutionKey]string
+}
+
+// readResolutionsFile returns the versions recorded in the resolutions file,
+// or none if there is no file.
+func readResolutionsFile(filePath string) (map[resolutionKey]string, error) {
+	result := map[resolutionKey]string{}
+	data, err := os.ReadFile(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read resolutions file %s: %w", filePath, err)
+	}
+	var records []resolutionRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("unable to parse resolutions file %s: %w", filePath, err)
+	}
+	for _, record := range records {
+		result[record.resolutionKey] = record.Version
+	}
+	return result, nil
+}
+
+// newResolutionHistory returns the resolution history kept in the chart cache
+// directory, or nil without one.  Failures to read it are logged, as the
+// history only serves the warnings.
+func newResolutionHistory(logger *slog.Logger, cacheRoot string) *resolutionHistory {
+	if cacheRoot == "" {
+		return nil
+	}
+	filePath := path.Join(cacheRoot, resolutionsFileName)
+	previous, err := readResolutionsFile(filePath)
+	if err != nil {
+		logger.
+			With("error", err).
+			Warn("Unable to read the chart versions resolved in the last run")
+		previous = map[resolutionKey]string{}
+	}
+	return &resolutionHistory{
+		logger:   logger,
+		filePath: filePath,
+		previous: previous,
+		resolved: map[resolutionKey]string{},
+	}
+}
+
+// isFloatingConstraint tells whether the chart version constraint can resolve
+// to different versions over time, as opposed to naming an exact version.
+func isFloatingConstraint(constraint string) bool {
+	_, err := semver.NewVersion(constraint)
+	return err != nil
+}
+
+// record records the version the constraint resolved to, warning if it
+// resolved to a different one in the last run.
+func (history *resolutionHistory) record(
+	repoURL string,
+	chart string,
+	constraint string,
+	version string,
+) {
+	if history == nil || !isFloatingConstraint(constraint) {
+		return
+	}
+	key := resolutionKey{URL: repoURL, Chart: chart, Constraint: constraint}
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+	history.resolved[key] = version
+	if previous, ok := history.previous[key]; ok && previous != version {
+		history.logger.
+			With("url", repoURL).
+			With("chart", chart).
+			With("constraint", constraint).
+			With("previousVersion", previous).
+			With("version", version).
+			Warn("Chart version constraint resolved to a different version than in the last run")
+		// Warn once per run.
+		history.previous[key] = version
+	}
+}
+
+// write records the resolved versions in the resolutions file, on top of the
+// ones recorded by other runs in the meantime.  Failures are logged, as the
+// history only serves the warnings.
+func (history *resolutionHistory) write() {
+	if history == nil || len(history.resolved) == 0 {
+		return
+	}
+	err := func() error {
+		history.mutex.Lock()
+		defer history.mutex.Unlock()
+		versions, err := readResolutionsFile(history.filePath)
+		if err != nil {
+			// Replace a corrupted file.
+			versions = map[resolutionKey]string{}
+		}
+		maps.Copy(versions, history.resolved)
+		records := make([]resolutionRecord, 0, len(versions))
+		for key, version := range versions {
+			records = append(records, resolutionRecord{resolutionKey: key, Version: version})
+		}
+		slices.SortFunc(records, func(a, b resolutionRecord) int {
+			return cmp.Or(
+				cmp.Compare(a.URL, b.URL),
+				cmp.Compare(a.Chart, b.Chart),
+				cmp.Compare(a.Constraint, b.Constraint),
+			)
+		})
+		data, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			return fmt.Errorf("unable to encode resolutions: %w", err)
+		}
+		dir := path.Dir(history.filePath)
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("unable to create cache directory %s: %w", dir, err)
+		}
+		// Write atomically, so that concurrent runs never read a partial file.
+		file, err := os.CreateTemp(dir, ".tmp-resolutions-")
+		if err != nil {
+			return fmt.Errorf("unable to create temporary file in %s: %w", dir, err)
+		}
+		_, err = file.Write(append(data, '\n'))
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(file.Name(), history.filePath)
+		}
+		if err != nil {
+			_ = os.Remove(file.Name())
+			return fmt.Errorf("unable to write resolutions file %s: %w", history.filePath, err)
+		}
+		return nil
+	}()
+	if err != nil {
+		history.logger.
+			With("error", err).
+			Warn("Unable to record the resolved chart versions")
+	}
+}
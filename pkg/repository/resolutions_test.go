@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Resolution history", func() {
+	var g gomega.Gomega
+	var logOutput *bytes.Buffer
+	var logger *slog.Logger
+	var cacheDir string
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		logOutput = &bytes.Buffer{}
+		logger = slog.New(slog.NewTextHandler(logOutput, nil))
+		var err error
+		cacheDir, err = os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+	})
+
+	ginkgo.AfterEach(func() {
+		g.Expect(os.RemoveAll(cacheDir)).To(gomega.Succeed())
+	})
+
+	ginkgo.It("warns about constraints resolving to different versions", func() {
+		history := newResolutionHistory(logger, cacheDir)
+		history.record("https://charts.example.com", "test-chart", ">=0.1.0", "0.1.0")
+		history.record("https://charts.example.com", "test-chart", "0.1.0", "0.1.0")
+		history.write()
+		g.Expect(logOutput.String()).To(gomega.BeEmpty())
+		g.Expect(filepath.Join(cacheDir, resolutionsFileName)).To(gomega.BeAnExistingFile())
+
+		history = newResolutionHistory(logger, cacheDir)
+		history.record("https://charts.example.com", "test-chart", ">=0.1.0", "0.1.0")
+		history.record("https://charts.example.com", "other-chart", ">=0.1.0", "0.2.0")
+		history.write()
+		g.Expect(logOutput.String()).To(gomega.BeEmpty())
+
+		history = newResolutionHistory(logger, cacheDir)
+		history.record("https://charts.example.com", "test-chart", ">=0.1.0", "0.2.0")
+		history.record("https://charts.example.com", "test-chart", ">=0.1.0", "0.2.0")
+		g.Expect(logOutput.String()).To(gomega.ContainSubstring(
+			"Chart version constraint resolved to a different version than in the last run",
+		))
+		g.Expect(logOutput.String()).To(gomega.ContainSubstring("previousVersion=0.1.0 version=0.2.0"))
+		g.Expect(bytes.Count(logOutput.Bytes(), []byte("\n"))).To(gomega.Equal(1))
+		history.write()
+
+		versions, err := readResolutionsFile(filepath.Join(cacheDir, resolutionsFileName))
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(versions).To(gomega.Equal(map[resolutionKey]string{
+			{URL: "https://charts.example.com", Chart: "test-chart", Constraint: ">=0.1.0"}:  "0.2.0",
+			{URL: "https://charts.example.com", Chart: "other-chart", Constraint: ">=0.1.0"}: "0.2.0",
+		}))
+	})
+
+	ginkgo.It("tells floating constraints from exact versions", func() {
+		g.Expect(isFloatingConstraint("")).To(gomega.BeTrue())
+		g.Expect(isFloatingConstraint("*")).To(gomega.BeTrue())
+		g.Expect(isFloatingConstraint("~1.2")).To(gomega.BeTrue())
+		g.Expect(isFloatingConstraint("1.2.3")).To(gomega.BeFalse())
+		g.Expect(isFloatingConstraint("v1.2.3")).To(gomega.BeFalse())
+	})
+})
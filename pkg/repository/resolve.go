@@ -0,0 +1,194 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// ResolvedRelease describes the chart and its concrete version that a
+// HelmRelease deploys.
+type ResolvedRelease struct {
+	Namespace       string `json:"namespace"`
+	Name            string `json:"name"`
+	SourceKind      string `json:"sourceKind"`
+	SourceNamespace string `json:"sourceNamespace"`
+	SourceName      string `json:"sourceName"`
+	URL             string `json:"url"`
+	Chart           string `json:"chart"`
+	VersionSpec     string `json:"versionSpec,omitempty"`
+	Version         string `json:"version"`
+}
+
+func resolveHelmRelease(
+	config loaderConfig,
+	releaseNode *yaml.RNode,
+	repoNode *yaml.RNode,
+) (*ResolvedRelease, error) {
+	var release helmv2.HelmRelease
+	err := decodeToObject(releaseNode, &release)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode HelmRelease: %w", err)
+	}
+
+	if repoNode == nil {
+		return nil, fmt.Errorf(
+			"missing chart repository %s for Helm release %s/%s",
+			release.Spec.Chart.Spec.SourceRef.Name,
+			release.Namespace,
+			release.Name,
+		)
+	}
+
+	loader, err := getLoaderForRepo(repoNode, config)
+	if err != nil {
+		return nil, err
+	}
+
+	chartSpec := release.Spec.Chart.Spec
+	version, err := loader.resolveChartVersion(
+		repoNode,
+		"",
+		chartSpec.Chart,
+		chartSpec.Version,
+	)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"unable to resolve chart version for %s %s/%s: %w",
+			repoNode.GetKind(),
+			repoNode.GetNamespace(),
+			repoNode.GetName(),
+			err,
+		)
+	}
+
+	repoURL, err := repoNode.GetString("spec.url")
+	if err != nil {
+		return nil, fmt.Errorf(
+			"unable to get URL for %s %s/%s: %w",
+			repoNode.GetKind(),
+			repoNode.GetNamespace(),
+			repoNode.GetName(),
+			err,
+		)
+	}
+
+	return &ResolvedRelease{
+		Namespace:       release.Namespace,
+		Name:            release.Name,
+		SourceKind:      repoNode.GetKind(),
+		SourceNamespace: repoNode.GetNamespace(),
+		SourceName:      repoNode.GetName(),
+		URL:             repoURL,
+		Chart:           chartSpec.Chart,
+		VersionSpec:     chartSpec.Version,
+		Version:         version,
+	}, nil
+}
+
+// prepareResolution parses the input and returns the loader configuration
+// and the HelmRelease objects with their sources to resolve the chart
+// versions of, as ResolveHelmReleases describes, together with a function
+// cleaning up the temporary chart cache, which is to be called when done.
+func (expander *HelmReleaseExpander) prepareResolution(
+	input io.Reader,
+	options ExpandOptions,
+) (loaderConfig, []releaseRepo, func(), error) {
+	options = options.withDefaults()
+	nodes, err := (&kio.ByteReader{Reader: input}).Read()
+	if err != nil {
+		return loaderConfig{}, nil, nil, NewClassifiedError(
+			ErrorClassInput,
+			fmt.Errorf("unable to parse input: %w", err),
+		)
+	}
+	if options.Strict {
+		if err := checkUnknownFields(nodes); err != nil {
+			return loaderConfig{}, nil, nil, err
+		}
+	}
+
+	chartCacheDir := options.ChartCacheDir
+	var cleanUp func()
+	if chartCacheDir == "" {
+		chartCacheDir, err = os.MkdirTemp("", "chart-repo-cache-")
+		if err != nil {
+			return loaderConfig{}, nil, nil, fmt.Errorf("unable to create a chart cache dir: %w", err)
+		}
+		cleanUp = func() {
+			if err := os.RemoveAll(chartCacheDir); err != nil {
+				expander.logger.
+					With("error", err).
+					With("dir", chartCacheDir).
+					Error("Unable to clean the chart cache directory")
+			}
+		}
+	} else {
+		expander.migrateCache(chartCacheDir)
+		cleanUp = func() { expander.cleanUpEphemeralCache(chartCacheDir) }
+	}
+
+	config := expander.getLoaderConfig()
+	config.gitRepoSubstitutions = options.GitRepoSubstitutions
+	config.chartSubstitutions = options.ChartSubstitutions
+	config.cacheRoot = chartCacheDir
+	config.credentials = options.Credentials
+	config.offline = options.Offline
+	config.allowLocalSources = options.AllowLocalSources
+	config.secrets = newSecretIndex(nodes)
+
+	releaseRepos, err := getReleaseRepos(
+		nodes,
+		options.SkipList.filterReleases(options.ReleaseFilter.filterReleases(nodes)),
+	)
+	if err != nil {
+		cleanUp()
+		return loaderConfig{}, nil, nil, fmt.Errorf("unable to get release repos: %w", err)
+	}
+	releaseRepos, err = newReleaseRepoRenderer(config, options).skipMissingSources(releaseRepos)
+	if err != nil {
+		cleanUp()
+		return loaderConfig{}, nil, nil, err
+	}
+	return config, releaseRepos, cleanUp, nil
+}
+
+// ResolveHelmReleases finds the repository, chart, and concrete chart version
+// for each HelmRelease in the input without rendering the charts.  Only the
+// options for loading charts are used: the credentials, the substitutions,
+// the chart cache, the offline mode, the strict checks, the release filter,
+// the skip list, and allowing local and missing sources.  Filtered out and
+// skipped HelmRelease objects, whose sources are never looked up, and, when
+// those are allowed, the ones with missing sources are left out of the
+// result.
+func (expander *HelmReleaseExpander) ResolveHelmReleases(
+	input io.Reader,
+	options ExpandOptions,
+) ([]ResolvedRelease, error) {
+	config, releaseRepos, cleanUp, err := expander.prepareResolution(input, options)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanUp()
+
+	result := make([]ResolvedRelease, 0, len(releaseRepos))
+	for _, pair := range releaseRepos {
+		resolved, err := resolveHelmRelease(config, pair.release, pair.repo)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"unable to resolve Helm release %s/%s: %w",
+				pair.release.GetNamespace(),
+				pair.release.GetName(),
+				err,
+			)
+		}
+		result = append(result, *resolved)
+	}
+	return result, nil
+}
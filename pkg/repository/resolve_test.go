@@ -0,0 +1,282 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("HelmRelease resolution", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+
+	getChartFiles := func(version string) map[string]string {
+		return map[string]string{
+			"Chart.yaml": strings.Join([]string{
+				"apiVersion: v2",
+				"name: test-chart",
+				"version: " + version,
+			}, "\n"),
+			"templates/configmap.yaml": strings.Join([]string{
+				"apiVersion: v1",
+				"kind: ConfigMap",
+				"metadata:",
+				"  name: {{ .Release.Name }}-configmap",
+			}, "\n"),
+		}
+	}
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+	})
+
+	ginkgo.It("resolves chart versions from Helm repositories", func() {
+		repoRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(repoRoot)
+		server, port, serverDone, err := serveDirectory(repoRoot, logger, nil)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		for _, version := range []string{"0.1.0", "0.2.0", "1.0.0"} {
+			err = createChartArchiveInDir("test-chart", version, getChartFiles(version), repoRoot)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+		}
+		err = indexRepository(repoRoot, port)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		repoURL := fmt.Sprintf("http://localhost:%d", port)
+		input := strings.Join([]string{
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"spec:",
+			"  chart:",
+			"    spec:",
+			"      chart: test-chart",
+			"      version: \"<1.0.0\"",
+			"      sourceRef:",
+			"        kind: HelmRepository",
+			"        name: local",
+			"---",
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: HelmRepository",
+			"metadata:",
+			"  namespace: testns",
+			"  name: local",
+			"spec:",
+			"  url: " + repoURL,
+		}, "\n")
+
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		resolved, err := expander.ResolveHelmReleases(
+			bytes.NewBufferString(input),
+			ExpandOptions{},
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		err = stopServing(server, serverDone)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(resolved).To(gomega.Equal([]ResolvedRelease{
+			{
+				Namespace:       "testns",
+				Name:            "test",
+				SourceKind:      "HelmRepository",
+				SourceNamespace: "testns",
+				SourceName:      "local",
+				URL:             repoURL,
+				Chart:           "test-chart",
+				VersionSpec:     "<1.0.0",
+				Version:         "0.2.0",
+			},
+		}))
+	})
+
+	ginkgo.It("resolves chart versions from OCI tags without downloading charts", func() {
+		input := strings.Join([]string{
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"spec:",
+			"  chart:",
+			"    spec:",
+			"      chart: test-chart",
+			"      version: \"~0.1.0\"",
+			"      sourceRef:",
+			"        kind: HelmRepository",
+			"        name: local",
+			"---",
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: HelmRepository",
+			"metadata:",
+			"  namespace: testns",
+			"  name: local",
+			"spec:",
+			"  type: oci",
+			"  insecure: true",
+			"  url: oci://localhost:8888",
+		}, "\n")
+
+		repoClient := &repoClientMock{}
+		repoClient.
+			On("Tags", "localhost:8888/test-chart").
+			Return([]string{"0.1.0", "0.1.3", "0.2.0"}, nil)
+
+		expander := NewHelmReleaseExpander(
+			ctx,
+			logger,
+			nil,
+			func(insecure bool, transport *http.Transport) (repositoryClient, error) {
+				return repoClient, nil
+			},
+		)
+		resolved, err := expander.ResolveHelmReleases(
+			bytes.NewBufferString(input),
+			ExpandOptions{},
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(resolved).To(gomega.HaveLen(1))
+		g.Expect(resolved[0].URL).To(gomega.Equal("oci://localhost:8888"))
+		g.Expect(resolved[0].Version).To(gomega.Equal("0.1.3"))
+		repoClient.AssertNotCalled(ginkgo.GinkgoT(), "Get", "localhost:8888/test-chart:0.1.3")
+	})
+
+	ginkgo.It("resolves chart versions offline from the chart cache", func() {
+		repoRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(repoRoot)
+		cacheRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(cacheRoot)
+		server, port, serverDone, err := serveDirectory(repoRoot, logger, nil)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		err = createChartArchiveInDir("test-chart", "0.1.0", getChartFiles("0.1.0"), repoRoot)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		err = indexRepository(repoRoot, port)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		input := strings.Join([]string{
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"spec:",
+			"  chart:",
+			"    spec:",
+			"      chart: test-chart",
+			"      sourceRef:",
+			"        kind: HelmRepository",
+			"        name: local",
+			"---",
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: HelmRepository",
+			"metadata:",
+			"  namespace: testns",
+			"  name: local",
+			"spec:",
+			fmt.Sprintf("  url: http://localhost:%d", port),
+		}, "\n")
+
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		_, err = expander.ResolveHelmReleases(
+			bytes.NewBufferString(input),
+			ExpandOptions{Offline: true},
+		)
+		var cacheMissErr *CacheMissError
+		g.Expect(errors.As(err, &cacheMissErr)).To(gomega.BeTrue())
+
+		_, err = expander.ResolveHelmReleases(
+			bytes.NewBufferString(input),
+			ExpandOptions{ChartCacheDir: cacheRoot},
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		err = stopServing(server, serverDone)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		resolved, err := expander.ResolveHelmReleases(
+			bytes.NewBufferString(input),
+			ExpandOptions{ChartCacheDir: cacheRoot, Offline: true},
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(resolved).To(gomega.HaveLen(1))
+		g.Expect(resolved[0].Version).To(gomega.Equal("0.1.0"))
+	})
+
+	ginkgo.It("fails on unknown fields in the strict mode", func() {
+		input := strings.Join([]string{
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"spec:",
+			"  chart:",
+			"    spec:",
+			"      chart: test-chart",
+			"      sourceRef:",
+			"        kind: HelmRepository",
+			"        name: local",
+			"  vaules: {}",
+		}, "\n")
+
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		_, err := expander.ResolveHelmReleases(
+			bytes.NewBufferString(input),
+			ExpandOptions{Strict: true},
+		)
+		g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("unknown field spec.vaules")))
+		g.Expect(GetErrorClass(err)).To(gomega.Equal(ErrorClassInput))
+	})
+
+	ginkgo.It("leaves releases with missing sources out if allowed", func() {
+		input := strings.Join([]string{
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"spec:",
+			"  chart:",
+			"    spec:",
+			"      chart: test-chart",
+			"      sourceRef:",
+			"        kind: HelmRepository",
+			"        name: awol",
+		}, "\n")
+
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		_, err := expander.ResolveHelmReleases(
+			bytes.NewBufferString(input),
+			ExpandOptions{},
+		)
+		g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(
+			"testns/test -> HelmRepository testns/awol",
+		)))
+
+		resolved, err := expander.ResolveHelmReleases(
+			bytes.NewBufferString(input),
+			ExpandOptions{AllowMissingSources: true},
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(resolved).To(gomega.BeEmpty())
+	})
+})
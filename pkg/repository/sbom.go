@@ -0,0 +1,363 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SBOMFormat is the format of software bills of materials.
+type SBOMFormat string
+
+const (
+	// SBOMFormatSPDX is the SPDX 2.3 JSON format.
+	SBOMFormatSPDX SBOMFormat = "spdx"
+	// SBOMFormatCycloneDX is the CycloneDX 1.5 JSON format.
+	SBOMFormatCycloneDX SBOMFormat = "cyclonedx"
+)
+
+// ParseSBOMFormat parses the SBOM format given as a string.
+func ParseSBOMFormat(value string) (SBOMFormat, error) {
+	switch format := SBOMFormat(value); format {
+	case SBOMFormatSPDX, SBOMFormatCycloneDX:
+		return format, nil
+	default:
+		return "", fmt.Errorf(
+			"invalid SBOM format %s, expected %s or %s",
+			value,
+			SBOMFormatSPDX,
+			SBOMFormatCycloneDX,
+		)
+	}
+}
+
+// SBOMChart is a chart used by the HelmRelease objects.
+type SBOMChart struct {
+	Name      string
+	Version   string
+	SourceURL string
+	// Digest of the chart files, as recorded in locks.
+	Digest string
+	// Releases using the chart, as <namespace>/<name>.
+	Releases []string
+}
+
+// SBOMImage is a container image referenced by the resources rendered from
+// the HelmRelease objects.
+type SBOMImage struct {
+	Image string
+	// Releases whose resources reference the image, as <namespace>/<name>.
+	Releases []string
+}
+
+// SBOM lists the charts and the container images of an expansion.
+type SBOM struct {
+	Charts []SBOMChart
+	Images []SBOMImage
+}
+
+// BuildSBOM expands the HelmRelease objects in the input and returns the
+// charts they use and the container images their resources reference.
+func (expander *HelmReleaseExpander) BuildSBOM(
+	input io.Reader,
+	options ExpandOptions,
+) (*SBOM, error) {
+	type chartKey struct{ name, version, sourceURL, digest string }
+	charts := map[chartKey]map[string]bool{}
+	images := map[string]map[string]bool{}
+	var mutex sync.Mutex
+	onReleaseExpanded := options.OnReleaseExpanded
+	options.OnReleaseExpanded = func(release *ExpandedRelease) {
+		name := release.Namespace + "/" + release.Name
+		chart := chartKey{
+			release.Chart,
+			release.ChartVersion,
+			release.SourceURL,
+			release.Digest,
+		}
+		mutex.Lock()
+		if charts[chart] == nil {
+			charts[chart] = map[string]bool{}
+		}
+		charts[chart][name] = true
+		for _, node := range release.Resources {
+			for _, image := range getContainerImages(node) {
+				if images[image] == nil {
+					images[image] = map[string]bool{}
+				}
+				images[image][name] = true
+			}
+		}
+		mutex.Unlock()
+		if onReleaseExpanded != nil {
+			onReleaseExpanded(release)
+		}
+	}
+	if err := expander.Expand(input, io.Discard, options); err != nil {
+		return nil, err
+	}
+
+	sbom := &SBOM{Charts: []SBOMChart{}, Images: []SBOMImage{}}
+	for chart, releases := range charts {
+		sbom.Charts = append(sbom.Charts, SBOMChart{
+			Name:      chart.name,
+			Version:   chart.version,
+			SourceURL: chart.sourceURL,
+			Digest:    chart.digest,
+			Releases:  slices.Sorted(maps.Keys(releases)),
+		})
+	}
+	slices.SortFunc(sbom.Charts, func(a, b SBOMChart) int {
+		return cmp.Or(
+			cmp.Compare(a.Name, b.Name),
+			cmp.Compare(a.Version, b.Version),
+			cmp.Compare(a.SourceURL, b.SourceURL),
+			cmp.Compare(a.Digest, b.Digest),
+		)
+	})
+	for _, image := range slices.Sorted(maps.Keys(images)) {
+		sbom.Images = append(sbom.Images, SBOMImage{
+			Image:    image,
+			Releases: slices.Sorted(maps.Keys(images[image])),
+		})
+	}
+	return sbom, nil
+}
+
+// splitImage returns the name of the image and its version: the digest if it
+// has one, otherwise the tag.
+func splitImage(image string) (string, string) {
+	name, digest, found := strings.Cut(image, "@")
+	tag := ""
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, tag = name[:i], name[i+1:]
+	}
+	if found {
+		return name, digest
+	}
+	return name, tag
+}
+
+// splitDigest returns the algorithm and the value of a digest given as
+// <algorithm>:<value>.
+func splitDigest(digest string) (string, string) {
+	algorithm, value, found := strings.Cut(digest, ":")
+	if !found {
+		return "", ""
+	}
+	return strings.ToUpper(algorithm), value
+}
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxPackage struct {
+	Name             string         `json:"name"`
+	SPDXID           string         `json:"SPDXID"`
+	VersionInfo      string         `json:"versionInfo,omitempty"`
+	DownloadLocation string         `json:"downloadLocation"`
+	FilesAnalyzed    bool           `json:"filesAnalyzed"`
+	PrimaryPurpose   string         `json:"primaryPackagePurpose"`
+	Checksums        []spdxChecksum `json:"checksums,omitempty"`
+	Comment          string         `json:"comment,omitempty"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+type spdxDocument struct {
+	SPDXVersion       string `json:"spdxVersion"`
+	DataLicense       string `json:"dataLicense"`
+	SPDXID            string `json:"SPDXID"`
+	Name              string `json:"name"`
+	DocumentNamespace string `json:"documentNamespace"`
+	CreationInfo      struct {
+		Created  string   `json:"created"`
+		Creators []string `json:"creators"`
+	} `json:"creationInfo"`
+	Packages      []spdxPackage      `json:"packages"`
+	Relationships []spdxRelationship `json:"relationships"`
+}
+
+type cycloneDXHash struct {
+	Algorithm string `json:"alg"`
+	Content   string `json:"content"`
+}
+
+type cycloneDXReference struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type cycloneDXProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type cycloneDXComponent struct {
+	Type               string               `json:"type"`
+	BOMRef             string               `json:"bom-ref"`
+	Name               string               `json:"name"`
+	Version            string               `json:"version,omitempty"`
+	Hashes             []cycloneDXHash      `json:"hashes,omitempty"`
+	ExternalReferences []cycloneDXReference `json:"externalReferences,omitempty"`
+	Properties         []cycloneDXProperty  `json:"properties,omitempty"`
+}
+
+type cycloneDXDocument struct {
+	BOMFormat   string `json:"bomFormat"`
+	SpecVersion string `json:"specVersion"`
+	Version     int    `json:"version"`
+	Metadata    struct {
+		Timestamp string `json:"timestamp"`
+		Tools     struct {
+			Components []cycloneDXComponent `json:"components"`
+		} `json:"tools"`
+	} `json:"metadata"`
+	Components []cycloneDXComponent `json:"components"`
+}
+
+// getReleasesComment returns the comment listing the releases using a
+// component.
+func getReleasesComment(releases []string) string {
+	return "Used by Helm releases " + strings.Join(releases, ", ")
+}
+
+// Write writes the SBOM in the format, recording the time it was created at.
+func (sbom *SBOM) Write(output io.Writer, format SBOMFormat, created time.Time) error {
+	var document any
+	switch format {
+	case SBOMFormatSPDX:
+		document = sbom.getSPDXDocument(created)
+	case SBOMFormatCycloneDX:
+		document = sbom.getCycloneDXDocument(created)
+	default:
+		return fmt.Errorf("unknown SBOM format %s", format)
+	}
+	encoder := json.NewEncoder(output)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(document); err != nil {
+		return fmt.Errorf("unable to write SBOM: %w", err)
+	}
+	return nil
+}
+
+func (sbom *SBOM) getSPDXDocument(created time.Time) *spdxDocument {
+	document := &spdxDocument{
+		SPDXVersion: "SPDX-2.3",
+		DataLicense: "CC0-1.0",
+		SPDXID:      "SPDXRef-DOCUMENT",
+		Name:        "fouskoti-render",
+	}
+	document.CreationInfo.Created = created.UTC().Format(time.RFC3339)
+	document.CreationInfo.Creators = []string{"Tool: fouskoti"}
+	document.Packages = []spdxPackage{}
+	document.Relationships = []spdxRelationship{}
+	addPackage := func(spdxPackage spdxPackage) {
+		document.Packages = append(document.Packages, spdxPackage)
+		document.Relationships = append(document.Relationships, spdxRelationship{
+			SPDXElementID:      document.SPDXID,
+			RelationshipType:   "DESCRIBES",
+			RelatedSPDXElement: spdxPackage.SPDXID,
+		})
+	}
+	for i, chart := range sbom.Charts {
+		spdxPackage := spdxPackage{
+			Name:             chart.Name,
+			SPDXID:           fmt.Sprintf("SPDXRef-Chart-%d", i+1),
+			VersionInfo:      chart.Version,
+			DownloadLocation: cmp.Or(chart.SourceURL, "NOASSERTION"),
+			PrimaryPurpose:   "APPLICATION",
+			Comment:          getReleasesComment(chart.Releases),
+		}
+		if algorithm, value := splitDigest(chart.Digest); algorithm != "" {
+			spdxPackage.Checksums = []spdxChecksum{{algorithm, value}}
+		}
+		addPackage(spdxPackage)
+	}
+	for i, image := range sbom.Images {
+		name, version := splitImage(image.Image)
+		spdxPackage := spdxPackage{
+			Name:             name,
+			SPDXID:           fmt.Sprintf("SPDXRef-Image-%d", i+1),
+			VersionInfo:      version,
+			DownloadLocation: "NOASSERTION",
+			PrimaryPurpose:   "CONTAINER",
+			Comment:          getReleasesComment(image.Releases),
+		}
+		if algorithm, value := splitDigest(version); algorithm != "" {
+			spdxPackage.Checksums = []spdxChecksum{{algorithm, value}}
+		}
+		addPackage(spdxPackage)
+	}
+	// The namespace has to be unique for each distinct document.
+	hash := sha256.New()
+	for _, spdxPackage := range document.Packages {
+		fmt.Fprintf(hash, "%s %s %s\n", spdxPackage.Name, spdxPackage.VersionInfo, spdxPackage.Comment)
+	}
+	document.DocumentNamespace = fmt.Sprintf(
+		"https://github.com/sageailabs/fouskoti/spdx/%x",
+		hash.Sum(nil),
+	)
+	return document
+}
+
+func (sbom *SBOM) getCycloneDXDocument(created time.Time) *cycloneDXDocument {
+	document := &cycloneDXDocument{
+		BOMFormat:   "CycloneDX",
+		SpecVersion: "1.5",
+		Version:     1,
+		Components:  []cycloneDXComponent{},
+	}
+	document.Metadata.Timestamp = created.UTC().Format(time.RFC3339)
+	document.Metadata.Tools.Components = []cycloneDXComponent{
+		{Type: "application", BOMRef: "fouskoti", Name: "fouskoti"},
+	}
+	for i, chart := range sbom.Charts {
+		component := cycloneDXComponent{
+			Type:       "application",
+			BOMRef:     fmt.Sprintf("chart-%d", i+1),
+			Name:       chart.Name,
+			Version:    chart.Version,
+			Properties: []cycloneDXProperty{{"fouskoti:releases", strings.Join(chart.Releases, ",")}},
+		}
+		if chart.SourceURL != "" {
+			component.ExternalReferences = []cycloneDXReference{
+				{Type: "distribution", URL: chart.SourceURL},
+			}
+		}
+		if algorithm, value := splitDigest(chart.Digest); algorithm == "SHA256" {
+			component.Hashes = []cycloneDXHash{{"SHA-256", value}}
+		}
+		document.Components = append(document.Components, component)
+	}
+	for i, image := range sbom.Images {
+		name, version := splitImage(image.Image)
+		component := cycloneDXComponent{
+			Type:       "container",
+			BOMRef:     fmt.Sprintf("image-%d", i+1),
+			Name:       name,
+			Version:    version,
+			Properties: []cycloneDXProperty{{"fouskoti:releases", strings.Join(image.Releases, ",")}},
+		}
+		if algorithm, value := splitDigest(version); algorithm == "SHA256" {
+			component.Hashes = []cycloneDXHash{{"SHA-256", value}}
+		}
+		document.Components = append(document.Components, component)
+	}
+	return document
+}
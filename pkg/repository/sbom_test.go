@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+var _ = ginkgo.Describe("Software bill of materials", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+	})
+
+	ginkgo.It("finds the images of containers anywhere in objects", func() {
+		node := yaml.MustParse(strings.Join([]string{
+			"apiVersion: example.com/v1",
+			"kind: Runner",
+			"spec:",
+			"  podTemplate:",
+			"    spec:",
+			"      initContainers:",
+			"      - name: init",
+			"        image: busybox:1.36",
+			"      containers:",
+			"      - name: app",
+			"        image: ghcr.io/example/app@sha256:abc",
+			"      - name: sidecar",
+		}, "\n"))
+		g.Expect(getContainerImages(node)).To(gomega.Equal([]string{
+			"busybox:1.36",
+			"ghcr.io/example/app@sha256:abc",
+		}))
+	})
+
+	ginkgo.It("lists the charts and the images of the releases", func() {
+		repoRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(repoRoot)
+		server, port, serverDone, err := serveDirectory(repoRoot, logger, nil)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer func() {
+			g.Expect(stopServing(server, serverDone)).To(gomega.Succeed())
+		}()
+
+		err = createSingleChartHelmRepository(
+			"test-chart",
+			"0.1.0",
+			map[string]string{
+				"Chart.yaml": strings.Join([]string{
+					"apiVersion: v2",
+					"name: test-chart",
+					"version: 0.1.0",
+				}, "\n"),
+				"templates/deployment.yaml": strings.Join([]string{
+					"apiVersion: apps/v1",
+					"kind: Deployment",
+					"metadata:",
+					"  name: {{ .Release.Name }}-deployment",
+					"spec:",
+					"  template:",
+					"    spec:",
+					"      containers:",
+					"      - name: app",
+					"        image: registry.example.com:5000/app:{{ .Values.tag }}",
+				}, "\n"),
+			},
+			port,
+			repoRoot,
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		getRelease := func(name string, tag string) string {
+			return strings.Join([]string{
+				"apiVersion: helm.toolkit.fluxcd.io/v2",
+				"kind: HelmRelease",
+				"metadata:",
+				"  namespace: testns",
+				"  name: " + name,
+				"spec:",
+				"  chart:",
+				"    spec:",
+				"      chart: test-chart",
+				"      sourceRef:",
+				"        kind: HelmRepository",
+				"        name: local",
+				"  values:",
+				"    tag: " + tag,
+			}, "\n")
+		}
+		input := strings.Join([]string{
+			strings.Join([]string{
+				"apiVersion: source.toolkit.fluxcd.io/v1",
+				"kind: HelmRepository",
+				"metadata:",
+				"  namespace: testns",
+				"  name: local",
+				"spec:",
+				fmt.Sprintf("  url: http://localhost:%d", port),
+			}, "\n"),
+			getRelease("first", "v1"),
+			getRelease("second", "v1"),
+			getRelease("third", "v2"),
+		}, "\n---\n")
+
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		sbom, err := expander.BuildSBOM(bytes.NewBufferString(input), ExpandOptions{})
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(sbom.Charts).To(gomega.HaveLen(1))
+		g.Expect(sbom.Charts[0].Name).To(gomega.Equal("test-chart"))
+		g.Expect(sbom.Charts[0].Version).To(gomega.Equal("0.1.0"))
+		g.Expect(sbom.Charts[0].SourceURL).To(gomega.Equal(fmt.Sprintf("http://localhost:%d", port)))
+		g.Expect(sbom.Charts[0].Digest).To(gomega.HavePrefix("sha256:"))
+		g.Expect(sbom.Charts[0].Releases).To(gomega.Equal([]string{
+			"testns/first",
+			"testns/second",
+			"testns/third",
+		}))
+		g.Expect(sbom.Images).To(gomega.Equal([]SBOMImage{
+			{
+				Image:    "registry.example.com:5000/app:v1",
+				Releases: []string{"testns/first", "testns/second"},
+			},
+			{
+				Image:    "registry.example.com:5000/app:v2",
+				Releases: []string{"testns/third"},
+			},
+		}))
+
+		created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		var output bytes.Buffer
+		g.Expect(sbom.Write(&output, SBOMFormatSPDX, created)).To(gomega.Succeed())
+		var spdx map[string]any
+		g.Expect(json.Unmarshal(output.Bytes(), &spdx)).To(gomega.Succeed())
+		g.Expect(spdx["spdxVersion"]).To(gomega.Equal("SPDX-2.3"))
+		g.Expect(spdx["creationInfo"]).To(gomega.HaveKeyWithValue("created", "2024-01-02T03:04:05Z"))
+		g.Expect(spdx["packages"]).To(gomega.HaveLen(3))
+		g.Expect(output.String()).To(gomega.ContainSubstring(`"name": "registry.example.com:5000/app"`))
+		g.Expect(output.String()).To(gomega.ContainSubstring(`"versionInfo": "v2"`))
+
+		output.Reset()
+		g.Expect(sbom.Write(&output, SBOMFormatCycloneDX, created)).To(gomega.Succeed())
+		var cycloneDX map[string]any
+		g.Expect(json.Unmarshal(output.Bytes(), &cycloneDX)).To(gomega.Succeed())
+		g.Expect(cycloneDX["bomFormat"]).To(gomega.Equal("CycloneDX"))
+		g.Expect(cycloneDX["components"]).To(gomega.HaveLen(3))
+		g.Expect(output.String()).To(gomega.ContainSubstring(`"type": "container"`))
+	})
+
+	ginkgo.It("parses the formats", func() {
+		format, err := ParseSBOMFormat("cyclonedx")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(format).To(gomega.Equal(SBOMFormatCycloneDX))
+		_, err = ParseSBOMFormat("swid")
+		g.Expect(err).To(gomega.HaveOccurred())
+	})
+})
@@ -0,0 +1,218 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+)
+
+// SnapshotMismatch is a snapshot file which doesn't match the resources
+// rendered from its HelmRelease, including snapshot files of HelmRelease
+// objects no longer in the input and missing snapshot files.
+type SnapshotMismatch struct {
+	// FileName of the snapshot relative to the snapshot directory.
+	FileName string
+	// Diff is a unified diff from the snapshot to the rendered resources.
+	Diff string
+}
+
+// getSnapshotFileName returns the name of the snapshot file of a HelmRelease
+// relative to the snapshot directory.
+func getSnapshotFileName(namespace string, name string) string {
+	return filepath.Join(namespace, name+".yaml")
+}
+
+// renderSnapshots expands the HelmRelease objects in the input and returns the
+// resources rendered from each of them as YAML, and the drift detection rules
+// of each of them, keyed by the names of their snapshot files.
+func (expander *HelmReleaseExpander) renderSnapshots(
+	input io.Reader,
+	options ExpandOptions,
+) (map[string]string, map[string][]driftIgnoreRule, error) {
+	sortOrder := options.withDefaults().SortOrder
+	snapshots := map[string]string{}
+	ignoreRules := map[string][]driftIgnoreRule{}
+	var errs []error
+	var mutex sync.Mutex
+	onReleaseExpanded := options.OnReleaseExpanded
+	options.OnReleaseExpanded = func(release *ExpandedRelease) {
+		if onReleaseExpanded != nil {
+			onReleaseExpanded(release)
+		}
+		// The resources are sorted after the callback, so sort a copy.
+		resources := slices.Clone(release.Resources)
+		err := sortNodes(resources, sortOrder)
+		var buffer bytes.Buffer
+		if err == nil {
+			err = kio.ByteWriter{Writer: &buffer}.Write(resources)
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		if err != nil {
+			errs = append(errs, fmt.Errorf(
+				"unable to write snapshot of Helm release %s/%s: %w",
+				release.Namespace,
+				release.Name,
+				err,
+			))
+			return
+		}
+		fileName := getSnapshotFileName(release.Namespace, release.Name)
+		snapshots[fileName] = buffer.String()
+		ignoreRules[fileName] = release.driftIgnoreRules
+	}
+	if err := expander.Expand(input, io.Discard, options); err != nil {
+		return nil, nil, err
+	}
+	if len(errs) > 0 {
+		return nil, nil, errors.Join(errs...)
+	}
+	return snapshots, ignoreRules, nil
+}
+
+// listSnapshotFiles returns the names of the snapshot files in the directory
+// relative to it, or none if the directory doesn't exist.
+func listSnapshotFiles(dir string) ([]string, error) {
+	var result []string
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if entry.IsDir() || filepath.Ext(path) != ".yaml" {
+			return nil
+		}
+		fileName, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		result = append(result, fileName)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list snapshot files in %s: %w", dir, err)
+	}
+	return result, nil
+}
+
+// UpdateSnapshots expands the HelmRelease objects in the input and writes the
+// resources rendered from each of them to a snapshot file in the directory,
+// <namespace>/<name>.yaml, removing the snapshot files of HelmRelease objects
+// no longer in the input.
+func (expander *HelmReleaseExpander) UpdateSnapshots(
+	input io.Reader,
+	dir string,
+	options ExpandOptions,
+) error {
+	snapshots, _, err := expander.renderSnapshots(input, options)
+	if err != nil {
+		return err
+	}
+	existingFiles, err := listSnapshotFiles(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, fileName := range slices.Sorted(maps.Keys(snapshots)) {
+		path := filepath.Join(dir, fileName)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return fmt.Errorf("unable to create snapshot directory: %w", err)
+		}
+		if err := os.WriteFile(path, []byte(snapshots[fileName]), 0o644); err != nil {
+			return fmt.Errorf("unable to write snapshot file %s: %w", path, err)
+		}
+	}
+	for _, fileName := range existingFiles {
+		if _, ok := snapshots[fileName]; ok {
+			continue
+		}
+		path := filepath.Join(dir, fileName)
+		expander.logger.
+			With("file", path).
+			Info("Removing snapshot of a Helm release no longer in the input")
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("unable to remove snapshot file %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// VerifySnapshots expands the HelmRelease objects in the input and compares
+// the resources rendered from each of them with their snapshot files in the
+// directory, written by UpdateSnapshots, disregarding the fields the drift
+// detection rules of the HelmRelease objects ignore.  It returns the
+// mismatches ordered by the snapshot file names.
+func (expander *HelmReleaseExpander) VerifySnapshots(
+	input io.Reader,
+	dir string,
+	options ExpandOptions,
+) ([]SnapshotMismatch, error) {
+	snapshots, ignoreRules, err := expander.renderSnapshots(input, options)
+	if err != nil {
+		return nil, err
+	}
+	existingFiles, err := listSnapshotFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := map[string]string{}
+	for _, fileName := range existingFiles {
+		path := filepath.Join(dir, fileName)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read snapshot file %s: %w", path, err)
+		}
+		expected[fileName] = string(data)
+		if rules := ignoreRules[fileName]; len(rules) > 0 {
+			expected[fileName], err = removeIgnoredFieldsFromYAML(expected[fileName], rules)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read snapshot file %s: %w", path, err)
+			}
+		}
+	}
+	for fileName, rules := range ignoreRules {
+		if len(rules) == 0 {
+			continue
+		}
+		snapshots[fileName], err = removeIgnoredFieldsFromYAML(snapshots[fileName], rules)
+		if err != nil {
+			return nil, fmt.Errorf("unable to compare snapshot file %s: %w", fileName, err)
+		}
+	}
+
+	fileNames := slices.Concat(existingFiles, slices.Collect(maps.Keys(snapshots)))
+	slices.Sort(fileNames)
+	result := []SnapshotMismatch{}
+	for _, fileName := range slices.Compact(fileNames) {
+		if expected[fileName] == snapshots[fileName] {
+			continue
+		}
+		diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(expected[fileName]),
+			B:        difflib.SplitLines(snapshots[fileName]),
+			FromFile: filepath.Join("snapshot", fileName),
+			ToFile:   filepath.Join("rendered", fileName),
+			Context:  3,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to compare snapshot file %s: %w", fileName, err)
+		}
+		result = append(result, SnapshotMismatch{FileName: fileName, Diff: diff})
+	}
+	return result, nil
+}
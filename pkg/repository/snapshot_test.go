@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Snapshots", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+	var repoRoot string
+	var snapshotDir string
+	var port int
+	var stop func()
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+
+		var err error
+		repoRoot, err = os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		snapshotDir, err = os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		server, serverPort, serverDone, err := serveDirectory(repoRoot, logger, nil)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		port = serverPort
+		stop = func() {
+			err := stopServing(server, serverDone)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+			os.RemoveAll(repoRoot)
+			os.RemoveAll(snapshotDir)
+		}
+
+		err = createSingleChartHelmRepository(
+			"test-chart",
+			"0.1.0",
+			map[string]string{
+				"Chart.yaml": strings.Join([]string{
+					"apiVersion: v2",
+					"name: test-chart",
+					"version: 0.1.0",
+				}, "\n"),
+				"templates/configmap.yaml": strings.Join([]string{
+					"apiVersion: v1",
+					"kind: ConfigMap",
+					"metadata:",
+					"  name: {{ .Release.Name }}-configmap",
+					"data:",
+					"  foo: {{ .Values.foo }}",
+				}, "\n"),
+			},
+			port,
+			repoRoot,
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+	})
+
+	ginkgo.AfterEach(func() {
+		stop()
+	})
+
+	getInput := func(names ...string) string {
+		lines := []string{
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: HelmRepository",
+			"metadata:",
+			"  namespace: testns",
+			"  name: local",
+			"spec:",
+			fmt.Sprintf("  url: http://localhost:%d", port),
+		}
+		for _, name := range names {
+			release, value, _ := strings.Cut(name, "=")
+			lines = append(lines,
+				"---",
+				"apiVersion: helm.toolkit.fluxcd.io/v2",
+				"kind: HelmRelease",
+				"metadata:",
+				"  namespace: testns",
+				"  name: "+release,
+				"spec:",
+				"  chart:",
+				"    spec:",
+				"      chart: test-chart",
+				"      sourceRef:",
+				"        kind: HelmRepository",
+				"        name: local",
+				"  values:",
+				"    foo: "+value,
+			)
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	ginkgo.It("verifies renders against updated snapshots", func() {
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		err := expander.UpdateSnapshots(
+			bytes.NewBufferString(getInput("first=one", "second=two")),
+			snapshotDir,
+			ExpandOptions{},
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		snapshot, err := os.ReadFile(filepath.Join(snapshotDir, "testns", "first.yaml"))
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(string(snapshot)).To(gomega.ContainSubstring("  foo: one"))
+
+		mismatches, err := expander.VerifySnapshots(
+			bytes.NewBufferString(getInput("first=one", "second=two")),
+			snapshotDir,
+			ExpandOptions{},
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(mismatches).To(gomega.BeEmpty())
+	})
+
+	ginkgo.It("reports the differences from the snapshots", func() {
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		err := expander.UpdateSnapshots(
+			bytes.NewBufferString(getInput("first=one", "second=two")),
+			snapshotDir,
+			ExpandOptions{},
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		mismatches, err := expander.VerifySnapshots(
+			bytes.NewBufferString(getInput("first=changed", "third=three")),
+			snapshotDir,
+			ExpandOptions{},
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(mismatches).To(gomega.HaveLen(3))
+		g.Expect(mismatches[0].FileName).To(gomega.Equal(filepath.Join("testns", "first.yaml")))
+		g.Expect(mismatches[0].Diff).To(gomega.ContainSubstring(strings.Join([]string{
+			"-  foo: one",
+			"+  foo: changed",
+		}, "\n")))
+		g.Expect(mismatches[1].FileName).To(gomega.Equal(filepath.Join("testns", "second.yaml")))
+		g.Expect(mismatches[1].Diff).To(gomega.ContainSubstring("-  foo: two"))
+		g.Expect(mismatches[2].FileName).To(gomega.Equal(filepath.Join("testns", "third.yaml")))
+		g.Expect(mismatches[2].Diff).To(gomega.ContainSubstring("+  foo: three"))
+	})
+
+	ginkgo.It("removes snapshots of releases no longer in the input", func() {
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		err := expander.UpdateSnapshots(
+			bytes.NewBufferString(getInput("first=one", "second=two")),
+			snapshotDir,
+			ExpandOptions{},
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		err = expander.UpdateSnapshots(
+			bytes.NewBufferString(getInput("first=one")),
+			snapshotDir,
+			ExpandOptions{},
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(filepath.Join(snapshotDir, "testns", "first.yaml")).To(gomega.BeARegularFile())
+		g.Expect(filepath.Join(snapshotDir, "testns", "second.yaml")).ToNot(gomega.BeAnExistingFile())
+	})
+})
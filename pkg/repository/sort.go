@@ -0,0 +1,169 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	releaseutil "helm.sh/helm/v4/pkg/release/v1/util"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// SortOrder determines how the resources generated from Helm releases are
+// ordered in the output.
+type SortOrder string
+
+const (
+	// SortOrderKind sorts resources alphabetically by kind, API version,
+	// namespace, and name.
+	SortOrderKind SortOrder = "kind"
+	// SortOrderInstall sorts resources in the order Helm installs them, so
+	// that the output can be applied in a single pass.
+	SortOrderInstall SortOrder = "install-order"
+	// SortOrderNone keeps resources in the order helm template prints them
+	// in: the resources which are not hooks by kind in the Helm install order
+	// and then by template path, followed by the hooks in the same order.
+	SortOrderNone SortOrder = "none"
+)
+
+// DefaultSortOrder is the order of the output resources when
+// ExpandOptions.SortOrder is not set.
+const DefaultSortOrder = SortOrderKind
+
+// installOrder lists resource kinds in the order Helm installs them.  Kinds
+// not on the list are installed after all of the listed ones.
+var installOrder = []string{
+	"PriorityClass",
+	"Namespace",
+	"NetworkPolicy",
+	"ResourceQuota",
+	"LimitRange",
+	"PodSecurityPolicy",
+	"PodDisruptionBudget",
+	"ServiceAccount",
+	"Secret",
+	"SecretList",
+	"ConfigMap",
+	"StorageClass",
+	"PersistentVolume",
+	"PersistentVolumeClaim",
+	"CustomResourceDefinition",
+	"ClusterRole",
+	"ClusterRoleList",
+	"ClusterRoleBinding",
+	"ClusterRoleBindingList",
+	"Role",
+	"RoleList",
+	"RoleBinding",
+	"RoleBindingList",
+	"Service",
+	"DaemonSet",
+	"Pod",
+	"ReplicationController",
+	"ReplicaSet",
+	"Deployment",
+	"HorizontalPodAutoscaler",
+	"StatefulSet",
+	"Job",
+	"CronJob",
+	"IngressClass",
+	"Ingress",
+	"APIService",
+	"MutatingWebhookConfiguration",
+	"ValidatingWebhookConfiguration",
+}
+
+func ParseSortOrder(value string) (SortOrder, error) {
+	switch sortOrder := SortOrder(value); sortOrder {
+	case SortOrderKind, SortOrderInstall, SortOrderNone:
+		return sortOrder, nil
+	default:
+		return "", fmt.Errorf(
+			"invalid sort order %s, expected %s, %s, or %s",
+			value,
+			SortOrderKind,
+			SortOrderInstall,
+			SortOrderNone,
+		)
+	}
+}
+
+func getInstallOrderRank(kind string) int {
+	if rank := slices.Index(installOrder, kind); rank >= 0 {
+		return rank
+	}
+	return len(installOrder)
+}
+
+func compareNodesByName(a, b *yaml.RNode) int {
+	return cmp.Or(
+		cmp.Compare(a.GetApiVersion(), b.GetApiVersion()),
+		cmp.Compare(a.GetNamespace(), b.GetNamespace()),
+		cmp.Compare(a.GetName(), b.GetName()),
+	)
+}
+
+func compareNodesByKind(a, b *yaml.RNode) int {
+	return cmp.Or(
+		cmp.Compare(a.GetKind(), b.GetKind()),
+		compareNodesByName(a, b),
+	)
+}
+
+func compareNodesByInstallOrder(a, b *yaml.RNode) int {
+	return cmp.Or(
+		cmp.Compare(getInstallOrderRank(a.GetKind()), getInstallOrderRank(b.GetKind())),
+		compareNodesByKind(a, b),
+	)
+}
+
+// sortNodes sorts the nodes in place according to the sort order.
+func sortNodes(nodes []*yaml.RNode, sortOrder SortOrder) error {
+	switch sortOrder {
+	case SortOrderKind:
+		slices.SortStableFunc(nodes, compareNodesByKind)
+	case SortOrderInstall:
+		slices.SortStableFunc(nodes, compareNodesByInstallOrder)
+	case SortOrderNone:
+	default:
+		return fmt.Errorf("unknown sort order %s", sortOrder)
+	}
+	return nil
+}
+
+// getOrderedManifests returns the manifests rendered from the chart templates,
+// leaving out the empty ones and the notes.  For SortOrderNone, they are split
+// into documents in the order helm template prints them, using the sorting of
+// Helm, which also leaves out hooks for events it doesn't know.  Otherwise,
+// they are ordered by template path, as the resources are sorted later.
+func getOrderedManifests(
+	manifests map[string]string,
+	sortOrder SortOrder,
+) ([]releaseutil.Manifest, error) {
+	files := map[string]string{}
+	for key, manifest := range manifests {
+		if strings.TrimSpace(manifest) != "" && filepath.Base(key) != "NOTES.txt" {
+			files[key] = manifest
+		}
+	}
+	if sortOrder != SortOrderNone {
+		result := make([]releaseutil.Manifest, 0, len(files))
+		for _, key := range slices.Sorted(maps.Keys(files)) {
+			result = append(result, releaseutil.Manifest{Name: key, Content: files[key]})
+		}
+		return result, nil
+	}
+	hooks, result, err := releaseutil.SortManifests(files, nil, releaseutil.InstallOrder)
+	if err != nil {
+		return nil, err
+	}
+	for _, hook := range hooks {
+		result = append(result, releaseutil.Manifest{Name: hook.Path, Content: hook.Manifest})
+	}
+	return result, nil
+}
@@ -0,0 +1,250 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+var _ = ginkgo.Describe("Output sorting", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+	})
+
+	getManifest := func(apiVersion string, kind string) string {
+		return strings.Join([]string{
+			"apiVersion: " + apiVersion,
+			"kind: " + kind,
+			"metadata:",
+			"  name: {{ .Release.Name }}-" + strings.ToLower(kind),
+		}, "\n")
+	}
+
+	ginkgo.DescribeTable(
+		"orders generated resources",
+		func(sortOrder SortOrder, expectedKinds []string) {
+			repoRoot, err := os.MkdirTemp("", "")
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+			defer os.RemoveAll(repoRoot)
+			server, port, serverDone, err := serveDirectory(repoRoot, logger, nil)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+			defer func() {
+				err := stopServing(server, serverDone)
+				g.Expect(err).ToNot(gomega.HaveOccurred())
+			}()
+
+			err = createSingleChartHelmRepository(
+				"test-chart",
+				"0.1.0",
+				map[string]string{
+					"Chart.yaml": strings.Join([]string{
+						"apiVersion: v2",
+						"name: test-chart",
+						"version: 0.1.0",
+					}, "\n"),
+					"templates/deployment.yaml":     getManifest("apps/v1", "Deployment"),
+					"templates/configmap.yaml":      getManifest("v1", "ConfigMap"),
+					"templates/namespace.yaml":      getManifest("v1", "Namespace"),
+					"templates/serviceaccount.yaml": getManifest("v1", "ServiceAccount"),
+					"templates/widget.yaml": getManifest("example.com/v1", "Widget") +
+						"\n---\n" + getManifest("v1", "Service"),
+					"templates/crd.yaml": getManifest(
+						"apiextensions.k8s.io/v1",
+						"CustomResourceDefinition",
+					),
+				},
+				port,
+				repoRoot,
+			)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+
+			input := strings.Join([]string{
+				"apiVersion: helm.toolkit.fluxcd.io/v2",
+				"kind: HelmRelease",
+				"metadata:",
+				"  namespace: testns",
+				"  name: test",
+				"spec:",
+				"  chart:",
+				"    spec:",
+				"      chart: test-chart",
+				"      sourceRef:",
+				"        kind: HelmRepository",
+				"        name: local",
+				"---",
+				"apiVersion: source.toolkit.fluxcd.io/v1",
+				"kind: HelmRepository",
+				"metadata:",
+				"  namespace: testns",
+				"  name: local",
+				"spec:",
+				fmt.Sprintf("  url: http://localhost:%d", port),
+			}, "\n")
+
+			expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+			output := &bytes.Buffer{}
+			err = expander.Expand(
+				bytes.NewBufferString(input),
+				output,
+				ExpandOptions{
+					SortOrder: sortOrder,
+				},
+			)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+
+			nodes, err := (&kio.ByteReader{Reader: output}).Read()
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+			g.Expect(nodes).To(gomega.HaveLen(len(expectedKinds) + 2))
+			kinds := []string{}
+			for _, node := range nodes[2:] {
+				kinds = append(kinds, node.GetKind())
+			}
+			g.Expect(kinds).To(gomega.Equal(expectedKinds))
+		},
+		ginkgo.Entry(
+			"alphabetically by kind",
+			SortOrderKind,
+			[]string{
+				"ConfigMap",
+				"CustomResourceDefinition",
+				"Deployment",
+				"Namespace",
+				"Service",
+				"ServiceAccount",
+				"Widget",
+			},
+		),
+		ginkgo.Entry(
+			"alphabetically by kind by default",
+			SortOrder(""),
+			[]string{
+				"ConfigMap",
+				"CustomResourceDefinition",
+				"Deployment",
+				"Namespace",
+				"Service",
+				"ServiceAccount",
+				"Widget",
+			},
+		),
+		ginkgo.Entry(
+			"in the install order",
+			SortOrderInstall,
+			[]string{
+				"Namespace",
+				"ServiceAccount",
+				"ConfigMap",
+				"CustomResourceDefinition",
+				"Service",
+				"Deployment",
+				"Widget",
+			},
+		),
+		ginkgo.Entry(
+			"in the order of helm template",
+			SortOrderNone,
+			[]string{
+				"Namespace",
+				"ServiceAccount",
+				"ConfigMap",
+				"CustomResourceDefinition",
+				"Service",
+				"Deployment",
+				"Widget",
+			},
+		),
+	)
+
+	ginkgo.It("orders manifests like helm template", func() {
+		manifests := map[string]string{
+			"test-chart/templates/NOTES.txt":  "Installed.",
+			"test-chart/templates/empty.yaml": "\n",
+			"test-chart/templates/a-app.yaml": strings.Join([]string{
+				"kind: Deployment",
+				"metadata: {name: a-app}",
+				"---",
+				"kind: Service",
+				"metadata: {name: a-app}",
+			}, "\n"),
+			"test-chart/templates/b-app.yaml": strings.Join([]string{
+				"kind: Service",
+				"metadata: {name: b-app}",
+				"---",
+				"kind: Widget",
+				"metadata: {name: b-app}",
+				"---",
+				"kind: Deployment",
+				"metadata: {name: b-app}",
+			}, "\n"),
+			"test-chart/templates/hooks.yaml": strings.Join([]string{
+				"kind: Job",
+				"metadata:",
+				"  name: migrate",
+				"  annotations: {helm.sh/hook: pre-install}",
+				"---",
+				"kind: ConfigMap",
+				"metadata:",
+				"  name: migrate",
+				"  annotations: {helm.sh/hook: pre-install}",
+			}, "\n"),
+			"test-chart/templates/settings.yaml": strings.Join([]string{
+				"kind: ConfigMap",
+				"metadata: {name: settings}",
+			}, "\n"),
+		}
+		ordered, err := getOrderedManifests(manifests, SortOrderNone)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		// The order of helm template 3.x and 4.x for the chart.
+		names := []string{}
+		for _, manifest := range ordered {
+			node, err := kyaml.Parse(manifest.Content)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+			names = append(names, fmt.Sprintf(
+				"%s %s/%s",
+				manifest.Name,
+				node.GetKind(),
+				node.GetName(),
+			))
+		}
+		g.Expect(names).To(gomega.Equal([]string{
+			"test-chart/templates/settings.yaml ConfigMap/settings",
+			"test-chart/templates/a-app.yaml Service/a-app",
+			"test-chart/templates/b-app.yaml Service/b-app",
+			"test-chart/templates/a-app.yaml Deployment/a-app",
+			"test-chart/templates/b-app.yaml Deployment/b-app",
+			"test-chart/templates/b-app.yaml Widget/b-app",
+			"test-chart/templates/hooks.yaml ConfigMap/migrate",
+			"test-chart/templates/hooks.yaml Job/migrate",
+		}))
+
+		ordered, err = getOrderedManifests(manifests, SortOrderKind)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(ordered).To(gomega.HaveLen(4))
+		g.Expect(ordered[0].Name).To(gomega.Equal("test-chart/templates/a-app.yaml"))
+	})
+
+	ginkgo.It("rejects unknown sort orders", func() {
+		_, err := ParseSortOrder("random")
+		g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(
+			"invalid sort order random",
+		)))
+	})
+})
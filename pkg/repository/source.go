@@ -0,0 +1,226 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var commitHashPattern = regexp.MustCompile("^[0-9a-f]{40}$")
+
+// GitSource refers to a path in a Git repository at a given reference, from
+// which to read the input YAML.
+type GitSource struct {
+	URL  string
+	Ref  string
+	Path string
+}
+
+// ParseGitSource parses a Git source in the form <url>@<ref>[:<path>].  The
+// reference can be a branch name, a full commit hash, or a full reference
+// name, such as refs/tags/v1.0.0.
+func ParseGitSource(source string) (*GitSource, error) {
+	separator := strings.LastIndex(source, "@")
+	if separator <= 0 {
+		return nil, fmt.Errorf(
+			"invalid Git source %s, expected <url>@<ref>[:<path>]",
+			source,
+		)
+	}
+	ref, sourcePath, _ := strings.Cut(source[separator+1:], ":")
+	if ref == "" {
+		return nil, fmt.Errorf("invalid Git source %s, the reference is empty", source)
+	}
+	if sourcePath != "" && !filepath.IsLocal(sourcePath) {
+		return nil, fmt.Errorf(
+			"invalid Git source %s, the path must be relative to the repository root",
+			source,
+		)
+	}
+	return &GitSource{
+		URL:  source[:separator],
+		Ref:  ref,
+		Path: sourcePath,
+	}, nil
+}
+
+func (source *GitSource) getReference() *sourcev1.GitRepositoryRef {
+	switch {
+	case commitHashPattern.MatchString(source.Ref):
+		return &sourcev1.GitRepositoryRef{Commit: source.Ref}
+	case strings.HasPrefix(source.Ref, "refs/"):
+		return &sourcev1.GitRepositoryRef{Name: source.Ref}
+	default:
+		return &sourcev1.GitRepositoryRef{Branch: source.Ref}
+	}
+}
+
+// readYAMLFiles combines all YAML files under the directory in a single YAML
+// stream, in the lexical order of their paths.
+func readYAMLFiles(dir string) ([]byte, error) {
+	var fileNames []string
+	err := filepath.WalkDir(dir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if entry.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch filepath.Ext(filePath) {
+		case ".yaml", ".yml":
+			fileNames = append(fileNames, filePath)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list files in %s: %w", dir, err)
+	}
+	slices.Sort(fileNames)
+
+	result := &bytes.Buffer{}
+	for _, fileName := range fileNames {
+		contents, err := os.ReadFile(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read file %s: %w", fileName, err)
+		}
+		result.WriteString("\n---\n")
+		result.Write(contents)
+	}
+	return result.Bytes(), nil
+}
+
+// ReadGitSource clones the Git repository and returns YAML from all files under
+// the source path.
+func (expander *HelmReleaseExpander) ReadGitSource(
+	credentials Credentials,
+	source *GitSource,
+) ([]byte, error) {
+	cloneDir, err := os.MkdirTemp("", "git-source-")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create a clone dir: %w", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(cloneDir); err != nil {
+			expander.logger.
+				With("error", err).
+				With("dir", cloneDir).
+				Error("Unable to clean the clone directory")
+		}
+	}()
+
+	repoPath, err := expander.cloneGitSource(credentials, source, cloneDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return readYAMLFiles(filepath.Join(repoPath, source.Path))
+}
+
+// newGitSourceLoader returns the loader cloning Git repositories of the
+// sources into the clone directory.
+func (expander *HelmReleaseExpander) newGitSourceLoader(
+	credentials Credentials,
+	repoURL string,
+	cloneDir string,
+) *gitRepoChartLoader {
+	return &gitRepoChartLoader{
+		loaderConfig: loaderConfig{
+			ctx:              expander.ctx,
+			logger:           expander.logger.With("url", repoURL),
+			gitClientFactory: expander.gitClientFactory,
+			cacheRoot:        cloneDir,
+			credentials:      credentials,
+			fetchLimiter:     expander.fetchLimiter,
+			mirrors:          expander.mirrors,
+			hostLimiter:      expander.hostLimiter,
+		},
+	}
+}
+
+// cloneGitSource clones the Git repository of the source into the clone
+// directory and returns the path of the checkout.
+func (expander *HelmReleaseExpander) cloneGitSource(
+	credentials Credentials,
+	source *GitSource,
+	cloneDir string,
+) (string, error) {
+	repo := &sourcev1.GitRepository{
+		ObjectMeta: metav1.ObjectMeta{Name: "input"},
+		Spec: sourcev1.GitRepositorySpec{
+			URL:       source.URL,
+			Reference: source.getReference(),
+		},
+	}
+	return expander.newGitSourceLoader(credentials, source.URL, cloneDir).
+		cloneRepo(repo, source.URL)
+}
+
+// ReadURLSource downloads input YAML from an HTTP(S) URL, using the bearer
+// token or basic credentials for the URL, if any.
+func (expander *HelmReleaseExpander) ReadURLSource(
+	credentials Credentials,
+	sourceURL string,
+) ([]byte, error) {
+	parsedURL, err := url.Parse(sourceURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse URL %s: %w", sourceURL, err)
+	}
+	ctx, cancel := context.WithTimeout(expander.ctx, 60*time.Second)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, sourceURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create request for %s: %w", sourceURL, err)
+	}
+
+	creds, err := credentials.FindForRepo(parsedURL)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"unable to find credentials for %s: %w",
+			sourceURL,
+			err,
+		)
+	}
+	if creds != nil {
+		if token := creds.Credentials["bearerToken"]; token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		} else if username := creds.Credentials["username"]; username != "" {
+			request.SetBasicAuth(username, creds.Credentials["password"])
+		}
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("unable to download %s: %w", sourceURL, err)
+	}
+	defer func() { _ = response.Body.Close() }()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf(
+			"unable to download %s: unexpected status %s",
+			sourceURL,
+			response.Status,
+		)
+	}
+	contents, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", sourceURL, err)
+	}
+	return contents, nil
+}
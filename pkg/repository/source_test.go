@@ -0,0 +1,164 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fluxcd/pkg/git"
+	"github.com/fluxcd/pkg/git/gogit"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+)
+
+var _ = ginkgo.Describe("Remote input", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+	})
+
+	ginkgo.DescribeTable(
+		"parses Git sources",
+		func(source string, expected *GitSource) {
+			result, err := ParseGitSource(source)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+			g.Expect(result).To(gomega.Equal(expected))
+		},
+		ginkgo.Entry(
+			"with an HTTPS URL",
+			"https://example.com/org/repo.git@main:clusters/prod",
+			&GitSource{
+				URL:  "https://example.com/org/repo.git",
+				Ref:  "main",
+				Path: "clusters/prod",
+			},
+		),
+		ginkgo.Entry(
+			"with an SSH URL",
+			"ssh://git@example.com/org/repo.git@refs/tags/v1.0.0:clusters",
+			&GitSource{
+				URL:  "ssh://git@example.com/org/repo.git",
+				Ref:  "refs/tags/v1.0.0",
+				Path: "clusters",
+			},
+		),
+		ginkgo.Entry(
+			"without a path",
+			"https://example.com/org/repo.git@main",
+			&GitSource{
+				URL: "https://example.com/org/repo.git",
+				Ref: "main",
+			},
+		),
+	)
+
+	ginkgo.DescribeTable(
+		"rejects invalid Git sources",
+		func(source string, expectedError string) {
+			_, err := ParseGitSource(source)
+			g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(expectedError)))
+		},
+		ginkgo.Entry(
+			"without a reference",
+			"https://example.com/org/repo.git",
+			"expected <url>@<ref>[:<path>]",
+		),
+		ginkgo.Entry(
+			"with an empty reference",
+			"https://example.com/org/repo.git@:clusters",
+			"the reference is empty",
+		),
+		ginkgo.Entry(
+			"with a path outside of the repository",
+			"https://example.com/org/repo.git@main:../clusters",
+			"the path must be relative to the repository root",
+		),
+	)
+
+	ginkgo.It("reads YAML files from a Git repository", func() {
+		var repoRoot string
+		gitClient := &GitClientMock{}
+		gitClient.
+			On("Clone", mock.Anything, repoURL, mock.Anything).
+			Run(func(mock.Arguments) {
+				err := createFileTree(repoRoot, map[string]string{
+					"clusters/prod/b.yaml":    "kind: B",
+					"clusters/prod/a/a.yml":   "kind: A",
+					"clusters/prod/README.md": "# Production",
+					"clusters/staging/c.yaml": "kind: C",
+				})
+				g.Expect(err).ToNot(gomega.HaveOccurred())
+			}).
+			Return(&git.Commit{Hash: git.Hash("dummy")}, nil)
+		expander := NewHelmReleaseExpander(
+			ctx,
+			logger,
+			func(
+				path string,
+				authOpts *git.AuthOptions,
+				clientOpts ...gogit.ClientOption,
+			) (GitClientInterface, error) {
+				repoRoot = path
+				return gitClient, nil
+			},
+			nil,
+		)
+
+		source, err := ParseGitSource(repoURL + "@main:clusters/prod")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		result, err := expander.ReadGitSource(getDummySSHCreds(repoURL), source)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(string(result)).To(gomega.Equal(strings.Join([]string{
+			"",
+			"---",
+			"kind: A",
+			"---",
+			"kind: B",
+		}, "\n")))
+		_, err = os.Stat(repoRoot)
+		g.Expect(os.IsNotExist(err)).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("reads YAML from a URL", func() {
+		dir, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(dir)
+		err = os.WriteFile(filepath.Join(dir, "input.yaml"), []byte("kind: A"), 0644)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		server, port, serverDone, err := serveDirectory(dir, logger, nil)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer func() {
+			err := stopServing(server, serverDone)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+		}()
+
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		result, err := expander.ReadURLSource(
+			Credentials{},
+			fmt.Sprintf("http://localhost:%d/input.yaml", port),
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(string(result)).To(gomega.Equal("kind: A"))
+
+		_, err = expander.ReadURLSource(
+			Credentials{},
+			fmt.Sprintf("http://localhost:%d/missing.yaml", port),
+		)
+		g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(
+			"unexpected status 404 Not Found",
+		)))
+	})
+})
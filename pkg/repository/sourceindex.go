@@ -0,0 +1,75 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+
+	yamlutil "github.com/sageailabs/fouskoti/pkg/yaml"
+)
+
+// sourceKey identifies the objects HelmRelease objects can refer to.  The API
+// version is the current Flux API version of the objects, or empty in keys of
+// objects of any API version.
+type sourceKey struct {
+	kind       string
+	namespace  string
+	name       string
+	apiVersion string
+}
+
+// sourceIndex maps the keys of the objects in the input to the objects, in
+// the order of the input, so that the sources of the HelmRelease objects can
+// be found without scanning the whole input for each of them.
+type sourceIndex map[sourceKey][]*yaml.RNode
+
+func newSourceIndex(nodes []*yaml.RNode) sourceIndex {
+	index := sourceIndex{}
+	for _, node := range nodes {
+		key := sourceKey{
+			kind:      node.GetKind(),
+			namespace: node.GetNamespace(),
+			name:      node.GetName(),
+		}
+		index[key] = append(index[key], node)
+		if apiVersion := node.GetApiVersion(); apiVersion != "" {
+			key.apiVersion = getCurrentFluxAPIVersion(apiVersion)
+			index[key] = append(index[key], node)
+		}
+	}
+	return index
+}
+
+// find returns the first object of the kind with the namespace and name, and
+// with the API version unless it is empty, or nil if there is none.
+func (index sourceIndex) find(
+	kind string,
+	namespace string,
+	name string,
+	apiVersion string,
+) *yaml.RNode {
+	if apiVersion != "" {
+		apiVersion = getCurrentFluxAPIVersion(apiVersion)
+	}
+	nodes := index[sourceKey{
+		kind:       kind,
+		namespace:  namespace,
+		name:       name,
+		apiVersion: apiVersion,
+	}]
+	if len(nodes) == 0 {
+		return nil
+	}
+	return nodes[0]
+}
+
+// findSource returns the first Flux source object of the kind with the
+// namespace and name, or nil if there is none.
+func (index sourceIndex) findSource(kind string, namespace string, name string) *yaml.RNode {
+	for _, node := range index[sourceKey{kind: kind, namespace: namespace, name: name}] {
+		if yamlutil.GetGroup(node) == "source.toolkit.fluxcd.io" {
+			return node
+		}
+	}
+	return nil
+}
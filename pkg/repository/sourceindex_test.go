@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+)
+
+var _ = ginkgo.Describe("Source index", func() {
+	var g gomega.Gomega
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+	})
+
+	ginkgo.It("finds the first objects with the keys", func() {
+		nodes, err := (&kio.ByteReader{
+			Reader: bytes.NewBufferString(strings.Join([]string{
+				"apiVersion: example.com/v1",
+				"kind: HelmChart",
+				"metadata:",
+				"  namespace: testns",
+				"  name: chart",
+				"---",
+				"apiVersion: source.toolkit.fluxcd.io/v1beta2",
+				"kind: HelmRepository",
+				"metadata:",
+				"  namespace: testns",
+				"  name: local",
+				"---",
+				"apiVersion: source.toolkit.fluxcd.io/v1",
+				"kind: HelmChart",
+				"metadata:",
+				"  namespace: testns",
+				"  name: chart",
+				"---",
+				"apiVersion: source.toolkit.fluxcd.io/v1",
+				"kind: HelmRepository",
+				"metadata:",
+				"  namespace: testns",
+				"  name: local",
+			}, "\n")),
+			OmitReaderAnnotations: true,
+		}).Read()
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		index := newSourceIndex(nodes)
+		g.Expect(index.find("HelmRepository", "testns", "local", "")).To(gomega.Equal(nodes[1]))
+		g.Expect(index.find("HelmRepository", "testns", "local", "source.toolkit.fluxcd.io/v1")).
+			To(gomega.Equal(nodes[1]))
+		g.Expect(index.find("HelmRepository", "testns", "local", "source.toolkit.fluxcd.io/v2")).
+			To(gomega.BeNil())
+		g.Expect(index.find("HelmRepository", "otherns", "local", "")).To(gomega.BeNil())
+		g.Expect(index.find("HelmChart", "testns", "chart", "")).To(gomega.Equal(nodes[0]))
+		g.Expect(index.findSource("HelmChart", "testns", "chart")).To(gomega.Equal(nodes[2]))
+		g.Expect(index.findSource("HelmChart", "testns", "missing")).To(gomega.BeNil())
+	})
+})
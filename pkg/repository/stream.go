@@ -0,0 +1,54 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"fmt"
+	"io"
+
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+
+	yamlutil "github.com/sageailabs/fouskoti/pkg/yaml"
+)
+
+// nodeStreamWriter writes batches of nodes to the output as a single YAML
+// stream.
+type nodeStreamWriter struct {
+	writer     io.Writer
+	nodeWriter kio.Writer
+	started    bool
+}
+
+func (stream *nodeStreamWriter) write(nodes []*yaml.RNode) error {
+	if len(nodes) == 0 {
+		return nil
+	}
+	if stream.started {
+		if _, err := io.WriteString(stream.writer, "---\n"); err != nil {
+			return fmt.Errorf("unable to write output: %w", err)
+		}
+	}
+	stream.started = true
+	if err := stream.nodeWriter.Write(nodes); err != nil {
+		return fmt.Errorf("unable to write output: %w", err)
+	}
+	return nil
+}
+
+// getExpansionInputs returns the nodes required for further expansion rounds:
+// HelmRelease objects and the chart sources they can refer to.
+func getExpansionInputs(nodes []*yaml.RNode) []*yaml.RNode {
+	result := []*yaml.RNode{}
+	for _, node := range nodes {
+		switch node.GetKind() {
+		case "GitRepository", "HelmRepository", "OCIRepository", "HelmChart":
+			result = append(result, node)
+		case "HelmRelease":
+			if yamlutil.GetGroup(node) == "helm.toolkit.fluxcd.io" {
+				result = append(result, node)
+			}
+		}
+	}
+	return result
+}
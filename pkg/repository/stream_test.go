@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Streaming output", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+	})
+
+	getRelease := func(namespace string, name string) string {
+		return strings.Join([]string{
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: " + namespace,
+			"  name: " + name,
+			"spec:",
+			"  releaseName: " + name,
+			"  chart:",
+			"    spec:",
+			"      chart: test-chart",
+			"      sourceRef:",
+			"        kind: HelmRepository",
+			"        name: local",
+			"        namespace: flux-system",
+		}, "\n")
+	}
+
+	expand := func(options ExpandOptions) string {
+		repoRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(repoRoot)
+		server, port, serverDone, err := serveDirectory(repoRoot, logger, nil)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer func() {
+			err := stopServing(server, serverDone)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+		}()
+
+		err = createSingleChartHelmRepository(
+			"test-chart",
+			"0.1.0",
+			map[string]string{
+				"Chart.yaml": strings.Join([]string{
+					"apiVersion: v2",
+					"name: test-chart",
+					"version: 0.1.0",
+				}, "\n"),
+				"templates/configmap.yaml": strings.Join([]string{
+					"apiVersion: v1",
+					"kind: ConfigMap",
+					"metadata:",
+					"  name: {{ .Release.Name }}-configmap",
+				}, "\n"),
+				"templates/secret.yaml": strings.Join([]string{
+					"apiVersion: v1",
+					"kind: Secret",
+					"metadata:",
+					"  name: {{ .Release.Name }}-secret",
+				}, "\n"),
+			},
+			port,
+			repoRoot,
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		input := strings.Join([]string{
+			getRelease("ns1", "first"),
+			"---",
+			getRelease("ns2", "second"),
+			"---",
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: HelmRepository",
+			"metadata:",
+			"  namespace: flux-system",
+			"  name: local",
+			"spec:",
+			fmt.Sprintf("  url: http://localhost:%d", port),
+		}, "\n")
+
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		output := &bytes.Buffer{}
+		err = expander.Expand(bytes.NewBufferString(input), output, options)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		return output.String()
+	}
+
+	ginkgo.It("produces the same output as buffered expansion", func() {
+		buffered := expand(ExpandOptions{SortOrder: SortOrderNone})
+		streamed := expand(ExpandOptions{SortOrder: SortOrderNone, Streaming: true})
+		g.Expect(streamed).To(gomega.Equal(buffered))
+		g.Expect(streamed).To(gomega.ContainSubstring("name: first-configmap"))
+		g.Expect(streamed).To(gomega.ContainSubstring("name: second-secret"))
+	})
+
+	ginkgo.It("marks skipped Helm releases", func() {
+		skipList, err := NewReleaseSkipList([]string{"ns2/second"})
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		output := expand(ExpandOptions{SkipList: skipList, Streaming: true})
+		g.Expect(output).To(gomega.ContainSubstring(strings.Join([]string{
+			"# Expansion skipped: the Helm release is in the skip list",
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: ns2",
+			"  name: second",
+		}, "\n")))
+		g.Expect(output).ToNot(gomega.ContainSubstring("name: second-configmap"))
+	})
+})
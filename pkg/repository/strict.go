@@ -0,0 +1,151 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kjson "sigs.k8s.io/json"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+
+	yamlutil "github.com/sageailabs/fouskoti/pkg/yaml"
+)
+
+// strictObjects maps the group and kind of the Flux objects checked for
+// unknown fields to the functions returning objects to decode them into.
+var strictObjects = map[string]func() runtime.Object{
+	"helm.toolkit.fluxcd.io/HelmRelease": func() runtime.Object {
+		return &helmv2.HelmRelease{}
+	},
+	"source.toolkit.fluxcd.io/GitRepository": func() runtime.Object {
+		return &sourcev1.GitRepository{}
+	},
+	"source.toolkit.fluxcd.io/HelmRepository": func() runtime.Object {
+		return &sourcev1.HelmRepository{}
+	},
+	"source.toolkit.fluxcd.io/OCIRepository": func() runtime.Object {
+		return &sourcev1.OCIRepository{}
+	},
+}
+
+// unknownFieldPattern matches the strict decoding errors for unknown fields,
+// which report the path of the field, e.g. spec.chart.spec.vaules.
+var unknownFieldPattern = regexp.MustCompile(`^unknown field "(.*)"$`)
+
+// pathSegmentPattern matches a field name in a path followed by the indexes
+// of sequence elements, e.g. containers[0].
+var pathSegmentPattern = regexp.MustCompile(`^([^\[]*)((?:\[\d+\])*)$`)
+
+// decodeToObjectStrict decodes the node into the object like decodeToObject
+// but returns the paths of the fields in the node which the object doesn't
+// have.
+func decodeToObjectStrict(node *yaml.RNode, out runtime.Object) ([]string, error) {
+	converted, err := convertFluxObject(node)
+	if err != nil {
+		return nil, fmt.Errorf("unable to convert %s: %w", node.GetApiVersion(), err)
+	}
+	bytes, err := converted.MarshalJSON()
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode node to JSON: %w", err)
+	}
+	strictErrs, err := kjson.UnmarshalStrict(bytes, out, kjson.DisallowUnknownFields)
+	if err != nil {
+		return nil, fmt.Errorf("unable to unmarshal JSON to k8s object: %w", err)
+	}
+	var unknownFields []string
+	for _, strictErr := range strictErrs {
+		match := unknownFieldPattern.FindStringSubmatch(strictErr.Error())
+		if match == nil {
+			return nil, strictErr
+		}
+		unknownFields = append(unknownFields, match[1])
+	}
+	return unknownFields, nil
+}
+
+// getFieldLine returns the line of the field at the path in the node, or the
+// line of the node if the field is not found, e.g. for fields renamed when
+// converting objects of older API versions.
+func getFieldLine(node *yaml.RNode, path string) int {
+	current := node.YNode()
+	line := current.Line
+	for _, segment := range strings.Split(path, ".") {
+		match := pathSegmentPattern.FindStringSubmatch(segment)
+		if match == nil || current.Kind != yaml.MappingNode {
+			return line
+		}
+		found := false
+		for i := 0; i+1 < len(current.Content); i += 2 {
+			if current.Content[i].Value == match[1] {
+				line = current.Content[i].Line
+				current = current.Content[i+1]
+				found = true
+				break
+			}
+		}
+		if !found {
+			return line
+		}
+		for _, index := range strings.Split(strings.Trim(match[2], "[]"), "][") {
+			if index == "" {
+				continue
+			}
+			position, err := strconv.Atoi(index)
+			if err != nil || current.Kind != yaml.SequenceNode ||
+				position >= len(current.Content) {
+				return line
+			}
+			current = current.Content[position]
+			line = current.Line
+		}
+	}
+	return line
+}
+
+// checkUnknownFields checks the HelmRelease and Flux source objects in the
+// input for fields their APIs don't define, which are otherwise ignored,
+// e.g. misspelled spec.values.  The errors report the position of the object
+// in the input and the line of each field in the object's document.
+func checkUnknownFields(nodes []*yaml.RNode) error {
+	var errs []error
+	for index, node := range nodes {
+		newObject, ok := strictObjects[yamlutil.GetGroup(node)+"/"+node.GetKind()]
+		if !ok {
+			continue
+		}
+		unknownFields, err := decodeToObjectStrict(node, newObject())
+		if err != nil {
+			errs = append(errs, fmt.Errorf(
+				"unable to decode document %d, %s %s/%s: %w",
+				index+1,
+				node.GetKind(),
+				node.GetNamespace(),
+				node.GetName(),
+				err,
+			))
+			continue
+		}
+		for _, field := range unknownFields {
+			errs = append(errs, fmt.Errorf(
+				"document %d, %s %s/%s, line %d: unknown field %s",
+				index+1,
+				node.GetKind(),
+				node.GetNamespace(),
+				node.GetName(),
+				getFieldLine(node, field),
+				field,
+			))
+		}
+	}
+	if len(errs) > 0 {
+		return NewClassifiedError(ErrorClassInput, errors.Join(errs...))
+	}
+	return nil
+}
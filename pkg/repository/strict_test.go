@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+)
+
+var _ = ginkgo.Describe("Strict input mode", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+	})
+
+	input := strings.Join([]string{
+		"apiVersion: v1",
+		"kind: ConfigMap",
+		"metadata:",
+		"  name: unrelated",
+		"unknown: ignored",
+		"---",
+		"apiVersion: helm.toolkit.fluxcd.io/v2",
+		"kind: HelmRelease",
+		"metadata:",
+		"  namespace: testns",
+		"  name: test",
+		"spec:",
+		"  chart:",
+		"    spec:",
+		"      chart: test-chart",
+		"      sourceRef:",
+		"        kind: HelmRepository",
+		"        name: local",
+		"  vaules:",
+		"    foo: bar",
+		"---",
+		"apiVersion: source.toolkit.fluxcd.io/v1",
+		"kind: HelmRepository",
+		"metadata:",
+		"  namespace: testns",
+		"  name: local",
+		"spec:",
+		"  url: https://charts.example.com",
+		"  certSecretRef:",
+		"    nmae: certs",
+	}, "\n")
+
+	ginkgo.It("reports unknown fields with their lines", func() {
+		nodes, err := (&kio.ByteReader{Reader: strings.NewReader(input)}).Read()
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		err = checkUnknownFields(nodes)
+		g.Expect(err).To(gomega.MatchError(strings.Join([]string{
+			"document 2, HelmRelease testns/test, line 13: unknown field spec.vaules",
+			"document 3, HelmRepository testns/local, line 9: unknown field spec.certSecretRef.nmae",
+		}, "\n")))
+		g.Expect(GetErrorClass(err)).To(gomega.Equal(ErrorClassInput))
+	})
+
+	ginkgo.It("finds the lines of sequence elements", func() {
+		nodes, err := (&kio.ByteReader{Reader: strings.NewReader(strings.Join([]string{
+			"spec:",
+			"  items:",
+			"  - name: a",
+			"  - name: b",
+			"    extra: true",
+		}, "\n"))}).Read()
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(getFieldLine(nodes[0], "spec.items[1].extra")).To(gomega.Equal(5))
+		g.Expect(getFieldLine(nodes[0], "spec.missing")).To(gomega.Equal(1))
+	})
+
+	ginkgo.It("fails the expansion on unknown fields", func() {
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		var output bytes.Buffer
+		err := expander.Expand(
+			bytes.NewBufferString(input),
+			&output,
+			ExpandOptions{Strict: true},
+		)
+		g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(
+			"line 13: unknown field spec.vaules",
+		)))
+	})
+})
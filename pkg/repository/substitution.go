@@ -0,0 +1,199 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	chart "helm.sh/helm/v4/pkg/chart/v2"
+	helmloader "helm.sh/helm/v4/pkg/chart/v2/loader"
+)
+
+// ChartSubstitution replaces charts from a Helm or OCI repository with a local
+// chart.  With Chart set, only that chart is replaced, and Path is the chart
+// directory or a packaged chart archive.  Otherwise Path is a directory with
+// the charts of the repository in subdirectories named after the charts;
+// charts missing there are loaded from the repository.
+type ChartSubstitution struct {
+	URL   string `yaml:"url"`
+	Chart string `yaml:"chart,omitempty"`
+	Path  string `yaml:"path"`
+}
+
+// Substitutions is the contents of a substitution file.
+type Substitutions struct {
+	// GitRepositories map Git repositories to local working copies.
+	GitRepositories []*GitRepoSubstitution `yaml:"substitutions"`
+	// Charts map charts in Helm and OCI repositories to local charts.
+	Charts []*ChartSubstitution `yaml:"charts"`
+}
+
+func (substitution *GitRepoSubstitution) validate() error {
+	if substitution.URL == "" && substitution.Name == "" {
+		return fmt.Errorf(
+			"missing repository URL or GitRepository name for working copy %s",
+			substitution.Path,
+		)
+	}
+	if substitution.URL != "" && substitution.Name != "" {
+		return fmt.Errorf(
+			"both repository URL and GitRepository name given for working copy %s",
+			substitution.Path,
+		)
+	}
+	if substitution.Name != "" && substitution.Namespace == "" {
+		return fmt.Errorf(
+			"missing namespace of GitRepository %s for working copy %s",
+			substitution.Name,
+			substitution.Path,
+		)
+	}
+	stat, err := os.Stat(substitution.Path)
+	if err != nil {
+		return fmt.Errorf(
+			"unable to access working copy path %s: %w",
+			substitution.Path,
+			err,
+		)
+	}
+	if !stat.IsDir() {
+		return fmt.Errorf("working copy path %s is not a directory", substitution.Path)
+	}
+	return nil
+}
+
+func (substitution *ChartSubstitution) validate() error {
+	if substitution.URL == "" {
+		return fmt.Errorf("missing repository URL for chart path %s", substitution.Path)
+	}
+	stat, err := os.Stat(substitution.Path)
+	if err != nil {
+		return fmt.Errorf("unable to access chart path %s: %w", substitution.Path, err)
+	}
+	if substitution.Chart == "" && !stat.IsDir() {
+		return fmt.Errorf(
+			"chart path %s for all charts of %s is not a directory",
+			substitution.Path,
+			substitution.URL,
+		)
+	}
+	return nil
+}
+
+// ParseChartSubstitution parses a chart substitution in the form
+// <repo-url>#[<chart>#]<path>.
+func ParseChartSubstitution(subst string) (*ChartSubstitution, error) {
+	parts := strings.SplitN(subst, "#", 3)
+	substitution := &ChartSubstitution{URL: parts[0]}
+	switch len(parts) {
+	case 3:
+		substitution.Chart = parts[1]
+		substitution.Path = parts[2]
+	case 2:
+		substitution.Path = parts[1]
+	default:
+		return nil, fmt.Errorf(
+			"invalid chart substitution %s, expected <repo-url>#[<chart>#]<path>",
+			subst,
+		)
+	}
+	if err := substitution.validate(); err != nil {
+		return nil, err
+	}
+	return substitution, nil
+}
+
+// ReadSubstitutions reads the substitutions from a YAML substitution file and
+// checks that their local paths exist.
+func ReadSubstitutions(input io.Reader) (*Substitutions, error) {
+	bytes, err := io.ReadAll(input)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read input: %w", err)
+	}
+
+	substitutions := &Substitutions{}
+	err = yaml.Unmarshal(bytes, substitutions)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse substitutions YAML: %w", err)
+	}
+
+	for _, substitution := range substitutions.GitRepositories {
+		if err := substitution.validate(); err != nil {
+			return nil, err
+		}
+	}
+	for _, substitution := range substitutions.Charts {
+		if err := substitution.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return substitutions, nil
+}
+
+// substitutedPath returns the local path substituting the chart in the
+// repository with the normalized URL, or an empty string if the chart is not
+// substituted.
+func (substitution *ChartSubstitution) substitutedPath(
+	repoURL string,
+	chartName string,
+) (string, error) {
+	substURL, err := normalizeURL(substitution.URL)
+	if err != nil {
+		return "", fmt.Errorf(
+			"invalid repository URL %s in chart substitution: %w",
+			substitution.URL,
+			err,
+		)
+	}
+	if substURL != repoURL {
+		return "", nil
+	}
+	if substitution.Chart != "" {
+		if substitution.Chart != chartName {
+			return "", nil
+		}
+		return substitution.Path, nil
+	}
+	chartPath := path.Join(substitution.Path, chartName)
+	if stat, err := os.Stat(chartPath); err != nil || !stat.IsDir() {
+		return "", nil
+	}
+	return chartPath, nil
+}
+
+// loadSubstitutedChart loads the chart from the local path substituting it,
+// if there is one.  Returns nil if the chart is not substituted.
+func (config *loaderConfig) loadSubstitutedChart(
+	repoURL string,
+	chartName string,
+) (*chart.Chart, error) {
+	for _, substitution := range config.chartSubstitutions {
+		chartPath, err := substitution.substitutedPath(repoURL, chartName)
+		if err != nil {
+			return nil, err
+		}
+		if chartPath == "" {
+			continue
+		}
+		config.logger.
+			With("chart", chartName).
+			With("path", chartPath).
+			Debug("Using substituted local chart")
+		result, err := helmloader.Load(chartPath)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"unable to load substituted chart %s from %s: %w",
+				chartName,
+				chartPath,
+				err,
+			)
+		}
+		return result, nil
+	}
+	return nil, nil
+}
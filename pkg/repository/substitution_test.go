@@ -0,0 +1,282 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Git repository substitutions", func() {
+	var g gomega.Gomega
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+	})
+
+	ginkgo.It("reads substitutions from a file", func() {
+		workingCopy, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(workingCopy)
+
+		substitutions, err := ReadSubstitutions(bytes.NewBufferString(
+			strings.Join([]string{
+				"substitutions:",
+				"- url: https://github.com/org/charts.git",
+				"  path: " + workingCopy,
+				"- url: https://github.com/org/platform.git",
+				"  branch: develop",
+				"  path: " + workingCopy,
+				"charts:",
+				"- url: oci://registry.example.com/charts",
+				"  chart: app",
+				"  path: " + workingCopy,
+			}, "\n"),
+		))
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(substitutions.Charts).To(gomega.Equal([]*ChartSubstitution{
+			{URL: "oci://registry.example.com/charts", Chart: "app", Path: workingCopy},
+		}))
+		g.Expect(substitutions.GitRepositories).To(gomega.Equal([]*GitRepoSubstitution{
+			{URL: "https://github.com/org/charts.git", Path: workingCopy},
+			{
+				URL:    "https://github.com/org/platform.git",
+				Branch: "develop",
+				Path:   workingCopy,
+			},
+		}))
+	})
+
+	ginkgo.It("fails on missing working copies", func() {
+		workingCopy, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(workingCopy)
+		missing := filepath.Join(workingCopy, "missing")
+
+		_, err = ReadSubstitutions(bytes.NewBufferString(
+			strings.Join([]string{
+				"substitutions:",
+				"- url: https://github.com/org/charts.git",
+				"  path: " + missing,
+			}, "\n"),
+		))
+		g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(
+			fmt.Sprintf("unable to access working copy path %s", missing),
+		)))
+	})
+
+	ginkgo.It("uses the first matching substitution", func() {
+		loader := &gitRepoChartLoader{loaderConfig: loaderConfig{
+			gitRepoSubstitutions: []*GitRepoSubstitution{
+				{URL: "https://github.com/org/charts.git", Path: "/default"},
+				{
+					URL:    "https://github.com/org/charts.git",
+					Branch: "develop",
+					Path:   "/develop",
+				},
+				{
+					URL:    "https://github.com/org/charts.git",
+					Branch: "develop",
+					Path:   "/other",
+				},
+			},
+		}}
+		getPath := func(branch string) string {
+			repo := &sourcev1.GitRepository{
+				Spec: sourcev1.GitRepositorySpec{
+					URL:       "https://github.com/org/charts.git",
+					Reference: &sourcev1.GitRepositoryRef{Branch: branch},
+				},
+			}
+			substitution := loader.getSubstitution(repo, "")
+			if substitution == nil {
+				return ""
+			}
+			return substitution.Path
+		}
+
+		g.Expect(getPath("")).To(gomega.Equal("/default"))
+		g.Expect(getPath("main")).To(gomega.Equal("/default"))
+		g.Expect(getPath("develop")).To(gomega.Equal("/develop"))
+		g.Expect(getPath("feature")).To(gomega.BeEmpty())
+	})
+
+	ginkgo.It("matches GitRepository objects by namespace and name", func() {
+		workingCopy, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(workingCopy)
+
+		substitution, err := ParseGitRepoSubstitution(
+			"flux-system/platform-charts#" + workingCopy,
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(substitution).To(gomega.Equal(&GitRepoSubstitution{
+			Namespace: "flux-system",
+			Name:      "platform-charts",
+			Path:      workingCopy,
+		}))
+
+		loader := &gitRepoChartLoader{loaderConfig: loaderConfig{
+			gitRepoSubstitutions: []*GitRepoSubstitution{substitution},
+		}}
+		getPath := func(namespace string, name string, url string) string {
+			repo := &sourcev1.GitRepository{
+				Spec: sourcev1.GitRepositorySpec{
+					URL:       url,
+					Reference: &sourcev1.GitRepositoryRef{Branch: "develop"},
+				},
+			}
+			repo.Namespace = namespace
+			repo.Name = name
+			substitution := loader.getSubstitution(repo, "")
+			if substitution == nil {
+				return ""
+			}
+			return substitution.Path
+		}
+
+		g.Expect(getPath(
+			"flux-system",
+			"platform-charts",
+			"ssh://git@github.com/org/charts.git",
+		)).To(gomega.Equal(workingCopy))
+		g.Expect(getPath(
+			"flux-system",
+			"platform-charts",
+			"https://github.com/org/charts.git",
+		)).To(gomega.Equal(workingCopy))
+		g.Expect(getPath(
+			"apps",
+			"platform-charts",
+			"https://github.com/org/charts.git",
+		)).To(gomega.BeEmpty())
+	})
+})
+
+var _ = ginkgo.Describe("Chart substitutions", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+	var chartsRoot string
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+
+		var err error
+		chartsRoot, err = os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		files := map[string]string{
+			"Chart.yaml": strings.Join([]string{
+				"apiVersion: v2",
+				"name: test-chart",
+				"version: 0.2.0-dev",
+			}, "\n"),
+			"templates/configmap.yaml": strings.Join([]string{
+				"apiVersion: v1",
+				"kind: ConfigMap",
+				"metadata:",
+				"  name: {{ .Release.Name }}-local",
+			}, "\n"),
+		}
+		for name, contents := range files {
+			filePath := filepath.Join(chartsRoot, "test-chart", name)
+			g.Expect(os.MkdirAll(filepath.Dir(filePath), 0700)).To(gomega.Succeed())
+			g.Expect(os.WriteFile(filePath, []byte(contents), 0600)).To(gomega.Succeed())
+		}
+	})
+
+	ginkgo.AfterEach(func() {
+		os.RemoveAll(chartsRoot)
+	})
+
+	expand := func(repoURL string, substitution *ChartSubstitution) (string, error) {
+		input := strings.Join([]string{
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"spec:",
+			"  chart:",
+			"    spec:",
+			"      chart: test-chart",
+			"      version: 0.1.x",
+			"      sourceRef:",
+			"        kind: HelmRepository",
+			"        name: local",
+			"---",
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: HelmRepository",
+			"metadata:",
+			"  namespace: testns",
+			"  name: local",
+			"spec:",
+			"  url: " + repoURL,
+		}, "\n")
+
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		var output bytes.Buffer
+		err := expander.Expand(
+			bytes.NewBufferString(input),
+			&output,
+			ExpandOptions{ChartSubstitutions: []*ChartSubstitution{substitution}},
+		)
+		return output.String(), err
+	}
+
+	ginkgo.It("parses chart substitutions", func() {
+		substitution, err := ParseChartSubstitution(
+			"oci://registry.example.com/charts#test-chart#" + chartsRoot,
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(substitution).To(gomega.Equal(&ChartSubstitution{
+			URL:   "oci://registry.example.com/charts",
+			Chart: "test-chart",
+			Path:  chartsRoot,
+		}))
+
+		_, err = ParseChartSubstitution("oci://registry.example.com/charts")
+		g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(
+			"invalid chart substitution",
+		)))
+	})
+
+	ginkgo.It("renders a substituted chart", func() {
+		output, err := expand("https://charts.example.com", &ChartSubstitution{
+			URL:   "https://charts.example.com/",
+			Chart: "test-chart",
+			Path:  filepath.Join(chartsRoot, "test-chart"),
+		})
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(output).To(gomega.ContainSubstring(strings.Join([]string{
+			"# Source: test-chart/templates/configmap.yaml",
+			"apiVersion: v1",
+			"kind: ConfigMap",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test-local",
+		}, "\n")))
+	})
+
+	ginkgo.It("renders a chart from a substituted repository directory", func() {
+		output, err := expand("https://charts.example.com", &ChartSubstitution{
+			URL:  "https://charts.example.com",
+			Path: chartsRoot,
+		})
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(output).To(gomega.ContainSubstring("  name: test-local"))
+	})
+})
@@ -0,0 +1,217 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// ReleaseSummary holds the number of resources rendered from a HelmRelease.
+type ReleaseSummary struct {
+	Namespace    string `json:"namespace"`
+	Name         string `json:"name"`
+	Chart        string `json:"chart"`
+	ChartVersion string `json:"chartVersion"`
+	Resources    int    `json:"resources"`
+}
+
+// ChartSummary lists the versions of a chart used by HelmRelease objects.
+type ChartSummary struct {
+	Chart    string   `json:"chart"`
+	Versions []string `json:"versions"`
+}
+
+// ExpansionSummary is an inventory of the resources in the expanded output.
+// Requests and Limits add up the container resources of the workloads,
+// multiplied by their replicas; DaemonSet objects are counted once, as the
+// number of nodes is unknown.
+type ExpansionSummary struct {
+	TotalResources int               `json:"totalResources"`
+	Kinds          map[string]int    `json:"kinds"`
+	Namespaces     map[string]int    `json:"namespaces"`
+	Releases       []ReleaseSummary  `json:"releases"`
+	Charts         []ChartSummary    `json:"charts"`
+	Requests       map[string]string `json:"requests"`
+	Limits         map[string]string `json:"limits"`
+}
+
+type summaryPodSpec struct {
+	Containers []struct {
+		Resources struct {
+			Requests map[string]resource.Quantity `json:"requests"`
+			Limits   map[string]resource.Quantity `json:"limits"`
+		} `json:"resources"`
+	} `json:"containers"`
+}
+
+type summaryWorkload struct {
+	Spec struct {
+		// The containers of a Pod.
+		summaryPodSpec
+		Replicas *int64 `json:"replicas"`
+		Template struct {
+			Spec summaryPodSpec `json:"spec"`
+		} `json:"template"`
+		JobTemplate struct {
+			Spec struct {
+				Template struct {
+					Spec summaryPodSpec `json:"spec"`
+				} `json:"template"`
+			} `json:"spec"`
+		} `json:"jobTemplate"`
+	} `json:"spec"`
+}
+
+// addWorkloadResources adds the container resources of the workload to the
+// requests and limits.  Nodes which are not workloads are ignored.
+func addWorkloadResources(
+	node *yaml.RNode,
+	requests map[string]resource.Quantity,
+	limits map[string]resource.Quantity,
+) error {
+	kind := node.GetKind()
+	switch kind {
+	case "Pod", "Deployment", "StatefulSet", "ReplicaSet", "DaemonSet", "Job", "CronJob":
+	default:
+		return nil
+	}
+	data, err := node.MarshalJSON()
+	if err != nil {
+		return fmt.Errorf("unable to encode node to JSON: %w", err)
+	}
+	workload := summaryWorkload{}
+	if err := json.Unmarshal(data, &workload); err != nil {
+		return fmt.Errorf(
+			"unable to parse %s %s: %w",
+			kind,
+			node.GetName(),
+			err,
+		)
+	}
+
+	podSpec := workload.Spec.Template.Spec
+	replicas := int64(1)
+	switch kind {
+	case "Pod":
+		podSpec = workload.Spec.summaryPodSpec
+	case "CronJob":
+		podSpec = workload.Spec.JobTemplate.Spec.Template.Spec
+	case "Deployment", "StatefulSet", "ReplicaSet":
+		if workload.Spec.Replicas != nil {
+			replicas = *workload.Spec.Replicas
+		}
+	}
+
+	for _, container := range podSpec.Containers {
+		for _, totals := range []struct {
+			values map[string]resource.Quantity
+			result map[string]resource.Quantity
+		}{
+			{container.Resources.Requests, requests},
+			{container.Resources.Limits, limits},
+		} {
+			for name, value := range totals.values {
+				for range replicas {
+					total := totals.result[name]
+					total.Add(value)
+					totals.result[name] = total
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func formatQuantities(quantities map[string]resource.Quantity) map[string]string {
+	result := map[string]string{}
+	for name, quantity := range quantities {
+		result[name] = quantity.String()
+	}
+	return result
+}
+
+// Summarize expands the HelmRelease objects in the input and returns an
+// inventory of the resulting output.
+func (expander *HelmReleaseExpander) Summarize(
+	input io.Reader,
+	options ExpandOptions,
+) (*ExpansionSummary, error) {
+	releases := []ReleaseSummary{}
+	var mutex sync.Mutex
+	onReleaseExpanded := options.OnReleaseExpanded
+	options.OnReleaseExpanded = func(release *ExpandedRelease) {
+		mutex.Lock()
+		releases = append(releases, ReleaseSummary{
+			Namespace:    release.Namespace,
+			Name:         release.Name,
+			Chart:        release.Chart,
+			ChartVersion: release.ChartVersion,
+			Resources:    len(release.Resources),
+		})
+		mutex.Unlock()
+		if onReleaseExpanded != nil {
+			onReleaseExpanded(release)
+		}
+	}
+	output := &bytes.Buffer{}
+	if err := expander.Expand(input, output, options); err != nil {
+		return nil, err
+	}
+	nodes, err := (&kio.ByteReader{Reader: output}).Read()
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse expanded output: %w", err)
+	}
+
+	summary := &ExpansionSummary{
+		TotalResources: len(nodes),
+		Kinds:          map[string]int{},
+		Namespaces:     map[string]int{},
+		Releases:       releases,
+		Charts:         []ChartSummary{},
+	}
+	requests := map[string]resource.Quantity{}
+	limits := map[string]resource.Quantity{}
+	for _, node := range nodes {
+		summary.Kinds[node.GetKind()]++
+		summary.Namespaces[node.GetNamespace()]++
+		if err := addWorkloadResources(node, requests, limits); err != nil {
+			return nil, err
+		}
+	}
+	summary.Requests = formatQuantities(requests)
+	summary.Limits = formatQuantities(limits)
+
+	slices.SortFunc(summary.Releases, func(a, b ReleaseSummary) int {
+		return cmp.Or(
+			cmp.Compare(a.Namespace, b.Namespace),
+			cmp.Compare(a.Name, b.Name),
+		)
+	})
+	chartVersions := map[string][]string{}
+	for _, release := range summary.Releases {
+		versions := chartVersions[release.Chart]
+		if !slices.Contains(versions, release.ChartVersion) {
+			chartVersions[release.Chart] = append(versions, release.ChartVersion)
+		}
+	}
+	for _, chart := range slices.Sorted(maps.Keys(chartVersions)) {
+		versions := chartVersions[chart]
+		slices.Sort(versions)
+		summary.Charts = append(summary.Charts, ChartSummary{
+			Chart:    chart,
+			Versions: versions,
+		})
+	}
+	return summary, nil
+}
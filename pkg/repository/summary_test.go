@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Expansion summary", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+	})
+
+	ginkgo.It("summarizes the expanded resources", func() {
+		repoRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(repoRoot)
+		server, port, serverDone, err := serveDirectory(repoRoot, logger, nil)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer func() {
+			err := stopServing(server, serverDone)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+		}()
+
+		err = createSingleChartHelmRepository(
+			"test-chart",
+			"0.1.0",
+			map[string]string{
+				"Chart.yaml": strings.Join([]string{
+					"apiVersion: v2",
+					"name: test-chart",
+					"version: 0.1.0",
+				}, "\n"),
+				"templates/deployment.yaml": strings.Join([]string{
+					"apiVersion: apps/v1",
+					"kind: Deployment",
+					"metadata:",
+					"  name: {{ .Release.Name }}-deployment",
+					"spec:",
+					"  replicas: 2",
+					"  template:",
+					"    spec:",
+					"      containers:",
+					"      - name: app",
+					"        resources:",
+					"          requests:",
+					"            cpu: 100m",
+					"            memory: 128Mi",
+					"          limits:",
+					"            memory: 256Mi",
+				}, "\n"),
+				"templates/configmap.yaml": strings.Join([]string{
+					"apiVersion: v1",
+					"kind: ConfigMap",
+					"metadata:",
+					"  name: {{ .Release.Name }}-configmap",
+				}, "\n"),
+			},
+			port,
+			repoRoot,
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		input := strings.Join([]string{
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"spec:",
+			"  chart:",
+			"    spec:",
+			"      chart: test-chart",
+			"      sourceRef:",
+			"        kind: HelmRepository",
+			"        name: local",
+			"---",
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: HelmRepository",
+			"metadata:",
+			"  namespace: testns",
+			"  name: local",
+			"spec:",
+			fmt.Sprintf("  url: http://localhost:%d", port),
+		}, "\n")
+
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		summary, err := expander.Summarize(
+			bytes.NewBufferString(input),
+			ExpandOptions{},
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(summary).To(gomega.Equal(&ExpansionSummary{
+			TotalResources: 4,
+			Kinds: map[string]int{
+				"ConfigMap":      1,
+				"Deployment":     1,
+				"HelmRelease":    1,
+				"HelmRepository": 1,
+			},
+			Namespaces: map[string]int{"testns": 4},
+			Releases: []ReleaseSummary{{
+				Namespace:    "testns",
+				Name:         "test",
+				Chart:        "test-chart",
+				ChartVersion: "0.1.0",
+				Resources:    2,
+			}},
+			Charts: []ChartSummary{{
+				Chart:    "test-chart",
+				Versions: []string{"0.1.0"},
+			}},
+			Requests: map[string]string{"cpu": "200m", "memory": "256Mi"},
+			Limits:   map[string]string{"memory": "512Mi"},
+		}))
+	})
+})
@@ -0,0 +1,42 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"strings"
+
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// helmHookAnnotation lists the Helm hooks a template is run for instead of
+// being installed as a regular resource.
+const helmHookAnnotation = "helm.sh/hook"
+
+// isTestHook tells whether the node is a Helm test, run only by helm test,
+// i.e., all of the hooks it is annotated with are test hooks.  test-success is
+// the deprecated name of the test hook Helm still accepts.
+func isTestHook(node *yaml.RNode) bool {
+	hooks, found := node.GetAnnotations()[helmHookAnnotation]
+	if !found {
+		return false
+	}
+	for hook := range strings.SplitSeq(hooks, ",") {
+		switch strings.TrimSpace(hook) {
+		case "test", "test-success":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// dropTestHooks returns the nodes which are not Helm tests.
+func dropTestHooks(nodes []*yaml.RNode) []*yaml.RNode {
+	result := make([]*yaml.RNode, 0, len(nodes))
+	for _, node := range nodes {
+		if !isTestHook(node) {
+			result = append(result, node)
+		}
+	}
+	return result
+}
@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+var _ = ginkgo.Describe("Helm test hooks", func() {
+	var g gomega.Gomega
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+	})
+
+	getPod := func(name string, hooks string) *yaml.RNode {
+		node := yaml.MustParse("apiVersion: v1\nkind: Pod\nmetadata:\n  name: " + name + "\n")
+		if hooks != "" {
+			g.Expect(node.PipeE(yaml.SetAnnotation(helmHookAnnotation, hooks))).To(gomega.Succeed())
+		}
+		return node
+	}
+
+	ginkgo.It("drops the resources which are only Helm tests", func() {
+		nodes := dropTestHooks([]*yaml.RNode{
+			getPod("app", ""),
+			getPod("test", "test"),
+			getPod("legacy-test", "test-success"),
+			getPod("tests", "test, test-success"),
+			getPod("migration", "pre-install,test"),
+			getPod("install", "post-install"),
+		})
+		names := []string{}
+		for _, node := range nodes {
+			names = append(names, node.GetName())
+		}
+		g.Expect(names).To(gomega.Equal([]string{"app", "migration", "install"}))
+	})
+})
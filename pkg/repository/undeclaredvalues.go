@@ -0,0 +1,213 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"cmp"
+	"encoding/json"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	chart "helm.sh/helm/v4/pkg/chart/v2"
+)
+
+// getUndeclaredValues returns the paths of the keys of the values, like
+// image.tagg, which neither the default values nor the JSON schema of the
+// chart or of its subcharts declare, sorted, as they are usually typos.  The
+// keys below values the chart leaves open, like empty maps, maps without
+// properties in the schema, and schemas combining or referencing other
+// schemas, are not checked.
+func getUndeclaredValues(loadedChart *chart.Chart, values map[string]any) []string {
+	result := getUndeclaredChartValues(loadedChart, loadedChart.Values, values, "", nil)
+	slices.Sort(result)
+	return result
+}
+
+// getUndeclaredChartValues returns the undeclared keys of the values of the
+// chart with the defaults, prefixing their paths with the prefix.  The
+// declared paths, relative to the chart, are the conditions of the parent
+// chart.
+func getUndeclaredChartValues(
+	loadedChart *chart.Chart,
+	defaults map[string]any,
+	values map[string]any,
+	prefix string,
+	declared map[string]bool,
+) []string {
+	var schema map[string]any
+	if len(loadedChart.Schema) > 0 {
+		if err := json.Unmarshal(loadedChart.Schema, &schema); err != nil {
+			// Invalid schemas fail the schema validation.
+			return nil
+		}
+	}
+	declared = maps.Clone(declared)
+	if declared == nil {
+		declared = map[string]bool{}
+	}
+	declared["global"] = true
+	subcharts := map[string]*chart.Chart{}
+	for _, dependency := range loadedChart.Metadata.Dependencies {
+		key := cmp.Or(dependency.Alias, dependency.Name)
+		for _, subchart := range loadedChart.Dependencies() {
+			if subchart.Name() == dependency.Name {
+				subcharts[key] = subchart
+			}
+		}
+		for condition := range strings.SplitSeq(dependency.Condition, ",") {
+			if condition = strings.TrimSpace(condition); condition != "" {
+				declared[condition] = true
+			}
+		}
+		if len(dependency.Tags) > 0 {
+			declared["tags"] = true
+		}
+	}
+
+	var result []string
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		subchart, ok := subcharts[key]
+		if !ok || declared[key] {
+			continue
+		}
+		subchartValues, _ := values[key].(map[string]any)
+		subchartDefaults := subchart.Values
+		if parentDefaults, ok := defaults[key].(map[string]any); ok {
+			subchartDefaults = mergeValues(subchartDefaults, parentDefaults)
+		}
+		subchartDeclared := map[string]bool{}
+		for path := range declared {
+			if subpath, ok := strings.CutPrefix(path, key+"."); ok {
+				subchartDeclared[subpath] = true
+			}
+		}
+		result = append(result, getUndeclaredChartValues(
+			subchart,
+			subchartDefaults,
+			subchartValues,
+			prefix+key+".",
+			subchartDeclared,
+		)...)
+	}
+	values = maps.Clone(values)
+	for key := range subcharts {
+		delete(values, key)
+	}
+	return append(result, getUndeclaredKeys(values, defaults, schema, prefix, "", declared)...)
+}
+
+// getUndeclaredKeys returns the paths of the keys of the values which are
+// neither in the defaults nor declared by the schema or the declared paths.
+func getUndeclaredKeys(
+	values map[string]any,
+	defaults map[string]any,
+	schema map[string]any,
+	prefix string,
+	path string,
+	declared map[string]bool,
+) []string {
+	if isOpaqueSchema(schema) {
+		return nil
+	}
+	var result []string
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		keyPath := path + key
+		if declared[keyPath] {
+			continue
+		}
+		defaultValue, inDefaults := defaults[key]
+		keySchema, inSchema := getPropertySchema(schema, key)
+		if !inDefaults && !inSchema {
+			result = append(result, prefix+keyPath)
+			continue
+		}
+		valueMap, ok := values[key].(map[string]any)
+		if !ok {
+			continue
+		}
+		defaultMap, _ := defaultValue.(map[string]any)
+		if len(defaultMap) == 0 && !declaresProperties(keySchema) {
+			// The chart leaves the keys of the value open.
+			continue
+		}
+		result = append(result, getUndeclaredKeys(
+			valueMap,
+			defaultMap,
+			keySchema,
+			prefix,
+			keyPath+".",
+			declared,
+		)...)
+	}
+	return result
+}
+
+// isOpaqueSchema tells whether the properties the schema declares cannot be
+// told without resolving references or combining schemas.
+func isOpaqueSchema(schema map[string]any) bool {
+	for _, keyword := range []string{"$ref", "allOf", "anyOf", "oneOf", "if", "dependentSchemas"} {
+		if _, ok := schema[keyword]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// declaresProperties tells whether the schema restricts the keys of objects
+// to the ones it declares.
+func declaresProperties(schema map[string]any) bool {
+	if isOpaqueSchema(schema) {
+		return false
+	}
+	_, hasProperties := schema["properties"]
+	_, hasPatternProperties := schema["patternProperties"]
+	return hasProperties || hasPatternProperties
+}
+
+// getPropertySchema returns the schema of the property of objects matching
+// the schema, and whether the schema declares the property.
+func getPropertySchema(schema map[string]any, key string) (map[string]any, bool) {
+	if properties, ok := schema["properties"].(map[string]any); ok {
+		if property, ok := properties[key]; ok {
+			propertySchema, _ := property.(map[string]any)
+			return propertySchema, true
+		}
+	}
+	if patternProperties, ok := schema["patternProperties"].(map[string]any); ok {
+		for _, pattern := range slices.Sorted(maps.Keys(patternProperties)) {
+			if matched, err := regexp.MatchString(pattern, key); err == nil && matched {
+				propertySchema, _ := patternProperties[pattern].(map[string]any)
+				return propertySchema, true
+			}
+		}
+	}
+	switch additionalProperties := schema["additionalProperties"].(type) {
+	case map[string]any:
+		return additionalProperties, true
+	case bool:
+		return nil, additionalProperties
+	}
+	return nil, false
+}
+
+// warnUndeclaredValues warns about the keys of the values of the HelmRelease
+// which the chart doesn't declare.
+func (renderer *releaseRepoRenderer) warnUndeclaredValues(
+	release *helmv2.HelmRelease,
+	loadedChart *chart.Chart,
+	values map[string]any,
+) {
+	undeclared := getUndeclaredValues(loadedChart, values)
+	if len(undeclared) == 0 {
+		return
+	}
+	renderer.config.logger.
+		With("namespace", release.Namespace).
+		With("name", release.Name).
+		With("chart", loadedChart.Name()).
+		With("keys", undeclared).
+		Warn("Values of Helm release are not declared by its chart")
+}
@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	chart "helm.sh/helm/v4/pkg/chart/v2"
+)
+
+var _ = ginkgo.Describe("Undeclared values", func() {
+	var g gomega.Gomega
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+	})
+
+	ginkgo.It("reports keys missing from the default values", func() {
+		testChart := &chart.Chart{
+			Metadata: &chart.Metadata{Name: "test-chart"},
+			Values: map[string]any{
+				"replicaCount": 1,
+				"image":        map[string]any{"repository": "nginx", "tag": "1.0"},
+				"podLabels":    map[string]any{},
+				"resources":    nil,
+			},
+		}
+		g.Expect(getUndeclaredValues(testChart, map[string]any{
+			"replicaCountt": 2,
+			"image":         map[string]any{"tagg": "2.0"},
+			"podLabels":     map[string]any{"team": "platform"},
+			"resources":     map[string]any{"limits": map[string]any{"cpu": "1"}},
+			"global":        map[string]any{"registry": "example.com"},
+		})).To(gomega.Equal([]string{"image.tagg", "replicaCountt"}))
+	})
+
+	ginkgo.It("accepts keys declared by the schema", func() {
+		testChart := &chart.Chart{
+			Metadata: &chart.Metadata{Name: "test-chart"},
+			Values:   map[string]any{},
+			Schema: []byte(`{
+				"properties": {
+					"ingress": {"properties": {"enabled": {"type": "boolean"}}},
+					"extra": {"$ref": "#/definitions/extra"}
+				},
+				"patternProperties": {"^env[A-Z]": {"type": "string"}}
+			}`),
+		}
+		g.Expect(getUndeclaredValues(testChart, map[string]any{
+			"ingress": map[string]any{"enabled": true, "host": "example.com"},
+			"extra":   map[string]any{"anything": true},
+			"envName": "dev",
+			"other":   1,
+		})).To(gomega.Equal([]string{"ingress.host", "other"}))
+	})
+
+	ginkgo.It("checks the values of subcharts against them", func() {
+		subchart := &chart.Chart{
+			Metadata: &chart.Metadata{Name: "redis"},
+			Values:   map[string]any{"port": 6379},
+		}
+		testChart := &chart.Chart{
+			Metadata: &chart.Metadata{
+				Name: "test-chart",
+				Dependencies: []*chart.Dependency{{
+					Name:      "redis",
+					Alias:     "cache",
+					Condition: "cache.enabled",
+					Tags:      []string{"storage"},
+				}},
+			},
+			Values: map[string]any{"cache": map[string]any{"password": ""}},
+		}
+		testChart.AddDependency(subchart)
+		g.Expect(getUndeclaredValues(testChart, map[string]any{
+			"cache": map[string]any{
+				"enabled":  true,
+				"password": "secret",
+				"port":     6380,
+				"portt":    6381,
+			},
+			"tags": map[string]any{"storage": true},
+		})).To(gomega.Equal([]string{"cache.portt"}))
+	})
+})
@@ -0,0 +1,128 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	chart "helm.sh/helm/v4/pkg/chart/v2"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// mergeValues merges the overrides into the values recursively, the same way
+// Flux merges values files.
+func mergeValues(values map[string]any, overrides map[string]any) map[string]any {
+	result := make(map[string]any, len(values))
+	for key, value := range values {
+		result[key] = value
+	}
+	for key, override := range overrides {
+		if overrideMap, ok := override.(map[string]any); ok {
+			if valueMap, ok := result[key].(map[string]any); ok {
+				result[key] = mergeValues(valueMap, overrideMap)
+				continue
+			}
+		}
+		result[key] = override
+	}
+	return result
+}
+
+func parseValues(data []byte) (map[string]any, error) {
+	values := map[string]any{}
+	node, err := yaml.Parse(string(data))
+	if errors.Is(err, io.EOF) {
+		// The file has no YAML document.
+		return values, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	bytes, err := node.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bytes, &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// applyValuesFiles returns a copy of the chart with its default values
+// replaced by the values files in the chart spec merged in order, as Flux
+// does; the values.yaml of the chart is only used if listed.  The paths are
+// relative to the chart root, except for charts from GitRepository objects,
+// where they are relative to the repository root; the files outside of the
+// chart directory are taken from repoFiles.  Charts are returned as is
+// without values files.
+func applyValuesFiles(
+	loadedChart *chart.Chart,
+	chartSpec *helmv2.HelmChartTemplateSpec,
+	sourceKind string,
+	repoFiles map[string][]byte,
+) (*chart.Chart, error) {
+	if len(chartSpec.ValuesFiles) == 0 {
+		return loadedChart, nil
+	}
+
+	files := map[string][]byte{}
+	for _, file := range loadedChart.Raw {
+		files[path.Clean(file.Name)] = file.Data
+	}
+
+	values := map[string]any{}
+	for _, fileName := range chartSpec.ValuesFiles {
+		name := path.Clean(fileName)
+		data, ok := files[name]
+		if sourceKind == "GitRepository" {
+			if isOutsideRepository(name) {
+				return nil, fmt.Errorf(
+					"values file %s is outside of the repository",
+					fileName,
+				)
+			}
+			chartDir := path.Clean(chartSpec.Chart)
+			relativeName, found := strings.CutPrefix(name, chartDir+"/")
+			if found || chartDir == "." {
+				data, ok = files[relativeName]
+			} else {
+				data, ok = repoFiles[name]
+			}
+		}
+		if !ok {
+			if chartSpec.IgnoreMissingValuesFiles {
+				continue
+			}
+			return nil, fmt.Errorf(
+				"values file %s not found in chart %s",
+				fileName,
+				loadedChart.Name(),
+			)
+		}
+		fileValues, err := parseValues(data)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"unable to parse values file %s: %w",
+				fileName,
+				err,
+			)
+		}
+		values = mergeValues(values, fileValues)
+	}
+
+	result := copyChart(loadedChart)
+	result.Values = values
+	return result, nil
+}
+
+// isOutsideRepository tells whether the cleaned path relative to the root of
+// a repository points outside of it.
+func isOutsideRepository(name string) bool {
+	return path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../")
+}
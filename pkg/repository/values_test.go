@@ -0,0 +1,270 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/fluxcd/pkg/git"
+	"github.com/fluxcd/pkg/git/gogit"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+)
+
+var _ = ginkgo.Describe("Values files", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+	})
+
+	ginkgo.It("merges values recursively", func() {
+		g.Expect(mergeValues(
+			map[string]any{
+				"image":    map[string]any{"repository": "app", "tag": "1.0"},
+				"replicas": 1.0,
+			},
+			map[string]any{
+				"image": map[string]any{"tag": "2.0"},
+				"extra": true,
+			},
+		)).To(gomega.Equal(map[string]any{
+			"image":    map[string]any{"repository": "app", "tag": "2.0"},
+			"replicas": 1.0,
+			"extra":    true,
+		}))
+	})
+
+	expand := func(valuesFiles []string, ignoreMissing bool) (string, error) {
+		repoRoot, err := os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer os.RemoveAll(repoRoot)
+		server, port, serverDone, err := serveDirectory(repoRoot, logger, nil)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		defer func() {
+			err := stopServing(server, serverDone)
+			g.Expect(err).ToNot(gomega.HaveOccurred())
+		}()
+
+		err = createSingleChartHelmRepository(
+			"test-chart",
+			"0.1.0",
+			map[string]string{
+				"Chart.yaml": strings.Join([]string{
+					"apiVersion: v2",
+					"name: test-chart",
+					"version: 0.1.0",
+				}, "\n"),
+				"values.yaml": strings.Join([]string{
+					"foo: default",
+					"bar: default",
+				}, "\n"),
+				"values-prod.yaml": strings.Join([]string{
+					"foo: prod",
+				}, "\n"),
+				"templates/configmap.yaml": strings.Join([]string{
+					"apiVersion: v1",
+					"kind: ConfigMap",
+					"metadata:",
+					"  name: {{ .Release.Name }}-configmap",
+					"data:",
+					"  foo: {{ .Values.foo }}",
+					"  bar: {{ .Values.bar | default \"unset\" }}",
+				}, "\n"),
+			},
+			port,
+			repoRoot,
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		lines := []string{
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"spec:",
+			"  chart:",
+			"    spec:",
+			"      chart: test-chart",
+			fmt.Sprintf("      ignoreMissingValuesFiles: %t", ignoreMissing),
+			"      sourceRef:",
+			"        kind: HelmRepository",
+			"        name: local",
+			"      valuesFiles:",
+		}
+		for _, valuesFile := range valuesFiles {
+			lines = append(lines, "      - "+valuesFile)
+		}
+		input := strings.Join(append(lines,
+			"---",
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: HelmRepository",
+			"metadata:",
+			"  namespace: testns",
+			"  name: local",
+			"spec:",
+			fmt.Sprintf("  url: http://localhost:%d", port),
+		), "\n")
+
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		var output bytes.Buffer
+		err = expander.Expand(
+			bytes.NewBufferString(input),
+			&output,
+			ExpandOptions{},
+		)
+		return output.String(), err
+	}
+
+	ginkgo.It("merges the values files in order", func() {
+		output, err := expand([]string{"values.yaml", "values-prod.yaml"}, false)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(output).To(gomega.ContainSubstring(strings.Join([]string{
+			"data:",
+			"  foo: prod",
+			"  bar: default",
+		}, "\n")))
+	})
+
+	ginkgo.It("uses only the listed values files", func() {
+		output, err := expand([]string{"values-prod.yaml"}, false)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(output).To(gomega.ContainSubstring(strings.Join([]string{
+			"data:",
+			"  foo: prod",
+			"  bar: unset",
+		}, "\n")))
+	})
+
+	ginkgo.It("fails on missing values files", func() {
+		_, err := expand([]string{"values-missing.yaml"}, false)
+		g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(
+			"values file values-missing.yaml not found in chart test-chart",
+		)))
+	})
+
+	ginkgo.It("ignores missing values files if requested", func() {
+		output, err := expand([]string{"values-prod.yaml", "values-missing.yaml"}, true)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(output).To(gomega.ContainSubstring("  foo: prod"))
+	})
+
+	expandFromGit := func(valuesFiles []string) (string, error) {
+		const repoURL = "https://github.com/examplecorp/charts"
+		var repoRoot string
+		gitClient := &GitClientMock{}
+		gitClient.
+			On("Clone", mock.Anything, repoURL, mock.Anything).
+			Run(func(mock.Arguments) {
+				err := createFileTree(repoRoot, map[string]string{
+					"charts/test-chart/Chart.yaml": strings.Join([]string{
+						"apiVersion: v2",
+						"name: test-chart",
+						"version: 0.1.0",
+					}, "\n"),
+					"charts/test-chart/values.yaml": strings.Join([]string{
+						"foo: default",
+						"bar: default",
+					}, "\n"),
+					"charts/test-chart/templates/configmap.yaml": strings.Join([]string{
+						"apiVersion: v1",
+						"kind: ConfigMap",
+						"metadata:",
+						"  name: {{ .Release.Name }}-configmap",
+						"data:",
+						"  foo: {{ .Values.foo }}",
+						"  bar: {{ .Values.bar | default \"unset\" }}",
+					}, "\n"),
+					"environments/prod/values.yaml": "foo: prod",
+				})
+				g.Expect(err).ToNot(gomega.HaveOccurred())
+			}).
+			Return(&git.Commit{Hash: git.Hash("dummy")}, nil)
+
+		lines := []string{
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"spec:",
+			"  chart:",
+			"    spec:",
+			"      chart: charts/test-chart",
+			"      sourceRef:",
+			"        kind: GitRepository",
+			"        name: charts",
+			"      valuesFiles:",
+		}
+		for _, valuesFile := range valuesFiles {
+			lines = append(lines, "      - "+valuesFile)
+		}
+		input := strings.Join(append(lines,
+			"---",
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: GitRepository",
+			"metadata:",
+			"  namespace: testns",
+			"  name: charts",
+			"spec:",
+			"  url: "+repoURL,
+			"  ref:",
+			"    tag: v1.0.0",
+		), "\n")
+
+		expander := NewHelmReleaseExpander(
+			ctx,
+			logger,
+			func(
+				clonePath string,
+				authOpts *git.AuthOptions,
+				clientOpts ...gogit.ClientOption,
+			) (GitClientInterface, error) {
+				repoRoot = path.Clean(clonePath)
+				return gitClient, nil
+			},
+			nil,
+		)
+		var output bytes.Buffer
+		err := expander.Expand(
+			bytes.NewBufferString(input),
+			&output,
+			ExpandOptions{},
+		)
+		return output.String(), err
+	}
+
+	ginkgo.It("reads values files of GitRepository charts from the repository root", func() {
+		output, err := expandFromGit([]string{
+			"charts/test-chart/values.yaml",
+			"environments/prod/values.yaml",
+		})
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(output).To(gomega.ContainSubstring(strings.Join([]string{
+			"data:",
+			"  foo: prod",
+			"  bar: default",
+		}, "\n")))
+	})
+
+	ginkgo.It("rejects values files outside of the GitRepository", func() {
+		_, err := expandFromGit([]string{"charts/../../values.yaml"})
+		g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(
+			"values file charts/../../values.yaml is outside of the repository",
+		)))
+	})
+})
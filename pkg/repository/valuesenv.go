@@ -0,0 +1,47 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"fmt"
+	"os"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+)
+
+// ValuesEnvAnnotation on a HelmRelease names an environment variable with
+// YAML values to merge into its spec.values, e.g., for preview environments
+// configured by CI variables.
+const ValuesEnvAnnotation = "fouskoti.sage.com/values-env"
+
+// getValuesWithEnv returns spec.values of the release with the values of the
+// environment variable named by its ValuesEnvAnnotation merged on top.  The
+// annotation is ignored unless the options allow reading values from the
+// environment, so that the input cannot expose the environment by default.
+func (renderer *releaseRepoRenderer) getValuesWithEnv(
+	release *helmv2.HelmRelease,
+) (map[string]any, error) {
+	values := release.GetValues()
+	variable := release.GetAnnotations()[ValuesEnvAnnotation]
+	if variable == "" {
+		return values, nil
+	}
+	logger := renderer.config.logger.
+		With("namespace", release.Namespace).
+		With("name", release.Name).
+		With("variable", variable)
+	if !renderer.options.ValuesFromEnv {
+		logger.Warn("Ignoring the values environment variable, reading values from the environment is not enabled")
+		return values, nil
+	}
+	data, found := os.LookupEnv(variable)
+	if !found {
+		logger.Warn("Values environment variable is not set")
+		return values, nil
+	}
+	envValues, err := parseValues([]byte(data))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse values of environment variable %s: %w", variable, err)
+	}
+	return mergeValues(values, envValues), nil
+}
@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+var _ = ginkgo.Describe("Values from the environment", func() {
+	var g gomega.Gomega
+	var expander *HelmReleaseExpander
+	var release helmv2.HelmRelease
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		logger := slog.New(slog.NewTextHandler(ginkgo.GinkgoWriter, nil))
+		expander = NewHelmReleaseExpander(context.Background(), logger, nil, nil)
+		node := yaml.MustParse(strings.Join([]string{
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"  annotations:",
+			"    fouskoti.sage.com/values-env: FOUSKOTI_TEST_VALUES",
+			"spec:",
+			"  values:",
+			"    replicas: 1",
+			"    ingress:",
+			"      enabled: true",
+			"      host: example.com",
+		}, "\n"))
+		g.Expect(decodeToObject(node, &release)).To(gomega.Succeed())
+		os.Setenv("FOUSKOTI_TEST_VALUES", "ingress:\n  host: pr-123.example.com\n")
+		ginkgo.DeferCleanup(os.Unsetenv, "FOUSKOTI_TEST_VALUES")
+	})
+
+	getValues := func(options ExpandOptions) (map[string]any, error) {
+		renderer := newReleaseRepoRenderer(expander.getLoaderConfig(), options)
+		return renderer.getValuesWithEnv(&release)
+	}
+
+	ginkgo.It("merges the values of the environment variable", func() {
+		values, err := getValues(ExpandOptions{ValuesFromEnv: true})
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(values).To(gomega.Equal(map[string]any{
+			"replicas": float64(1),
+			"ingress": map[string]any{
+				"enabled": true,
+				"host":    "pr-123.example.com",
+			},
+		}))
+	})
+
+	ginkgo.It("ignores the annotation unless enabled", func() {
+		values, err := getValues(ExpandOptions{})
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(values).To(gomega.HaveKeyWithValue("ingress", map[string]any{
+			"enabled": true,
+			"host":    "example.com",
+		}))
+	})
+
+	ginkgo.It("fails on invalid values", func() {
+		os.Setenv("FOUSKOTI_TEST_VALUES", "- not a map")
+		_, err := getValues(ExpandOptions{ValuesFromEnv: true})
+		g.Expect(err).To(gomega.HaveOccurred())
+		g.Expect(err.Error()).To(gomega.ContainSubstring("FOUSKOTI_TEST_VALUES"))
+	})
+})
@@ -0,0 +1,299 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+
+	"github.com/Masterminds/semver/v3"
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"gopkg.in/yaml.v3"
+	chart "helm.sh/helm/v4/pkg/chart/v2"
+	helmloader "helm.sh/helm/v4/pkg/chart/v2/loader"
+	chartutil "helm.sh/helm/v4/pkg/chart/v2/util"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// VendorManifestFileName is the name of the manifest file in the vendor
+// directory.
+const VendorManifestFileName = "manifest.yaml"
+
+// VendoredRelease records the vendored chart of a HelmRelease.  Path is
+// relative to the vendor directory.
+type VendoredRelease struct {
+	Namespace  string `yaml:"namespace"`
+	Name       string `yaml:"name"`
+	SourceKind string `yaml:"sourceKind"`
+	URL        string `yaml:"url"`
+	Chart      string `yaml:"chart"`
+	Version    string `yaml:"version"`
+	Path       string `yaml:"path"`
+}
+
+// VendorManifest maps HelmReleases to the charts vendored for them.
+type VendorManifest struct {
+	Releases []VendoredRelease `yaml:"releases"`
+
+	dir string
+}
+
+// ReadVendorManifest reads the manifest of the vendor directory.
+func ReadVendorManifest(vendorDir string) (*VendorManifest, error) {
+	bytes, err := os.ReadFile(filepath.Join(vendorDir, VendorManifestFileName))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read vendor manifest: %w", err)
+	}
+
+	manifest := &VendorManifest{}
+	err = yaml.Unmarshal(bytes, manifest)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse vendor manifest YAML: %w", err)
+	}
+	manifest.dir = vendorDir
+	return manifest, nil
+}
+
+func (manifest *VendorManifest) write(output io.Writer) error {
+	slices.SortFunc(manifest.Releases, func(a, b VendoredRelease) int {
+		return cmp.Or(
+			cmp.Compare(a.Namespace, b.Namespace),
+			cmp.Compare(a.Name, b.Name),
+		)
+	})
+	encoder := yaml.NewEncoder(output)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(manifest); err != nil {
+		return fmt.Errorf("unable to encode vendor manifest YAML: %w", err)
+	}
+	return encoder.Close()
+}
+
+// loadVendoredChart loads the chart vendored for the release.  It returns nil
+// when the release has no vendored chart or when the vendored chart no longer
+// matches the chart the release refers to, in which case the chart should be
+// loaded from its repository.
+func (manifest *VendorManifest) loadVendoredChart(
+	logger *slog.Logger,
+	release *helmv2.HelmRelease,
+	repoNode *kyaml.RNode,
+) (*chart.Chart, error) {
+	var vendored *VendoredRelease
+	for i := range manifest.Releases {
+		if manifest.Releases[i].Namespace == release.Namespace &&
+			manifest.Releases[i].Name == release.Name {
+			vendored = &manifest.Releases[i]
+			break
+		}
+	}
+	if vendored == nil {
+		return nil, nil
+	}
+
+	logger = logger.
+		With("namespace", release.Namespace).
+		With("name", release.Name)
+	repoURL, err := repoNode.GetString("spec.url")
+	if err != nil {
+		return nil, fmt.Errorf(
+			"unable to get URL for %s %s/%s: %w",
+			repoNode.GetKind(),
+			repoNode.GetNamespace(),
+			repoNode.GetName(),
+			err,
+		)
+	}
+	chartSpec := release.Spec.Chart.Spec
+	if vendored.SourceKind != repoNode.GetKind() ||
+		vendored.URL != repoURL ||
+		vendored.Chart != chartSpec.Chart {
+		logger.Info("Vendored chart is from a different repository, ignoring it")
+		return nil, nil
+	}
+	// Versions of charts in Git repositories are not selected by constraints.
+	if repoNode.GetKind() != "GitRepository" && chartSpec.Version != "" {
+		constraint, err := semver.NewConstraint(chartSpec.Version)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"unable to parse version constraint '%s' of Helm release %s/%s: %w",
+				chartSpec.Version,
+				release.Namespace,
+				release.Name,
+				err,
+			)
+		}
+		version, err := semver.NewVersion(vendored.Version)
+		if err != nil || !constraint.Check(version) {
+			logger.
+				With("version", vendored.Version).
+				Info("Vendored chart version does not match the constraint, ignoring it")
+			return nil, nil
+		}
+	}
+
+	chartPath := filepath.Join(manifest.dir, filepath.FromSlash(vendored.Path))
+	chart, err := helmloader.LoadDir(chartPath)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"unable to load vendored chart for Helm release %s/%s from %s: %w",
+			release.Namespace,
+			release.Name,
+			chartPath,
+			err,
+		)
+	}
+	logger.With("path", chartPath).Debug("Loaded vendored chart")
+	return chart, nil
+}
+
+// VendorCharts fetches the charts of all HelmRelease objects in the input,
+// together with their dependencies, and saves them as unpacked charts into
+// the vendor directory.  It then writes a manifest mapping the HelmRelease
+// objects to the vendored charts.  Charts vendored earlier and no longer
+// referenced are left in place.
+func (expander *HelmReleaseExpander) VendorCharts(
+	credentials Credentials,
+	input io.Reader,
+	vendorDir string,
+) error {
+	nodes, err := (&kio.ByteReader{Reader: input}).Read()
+	if err != nil {
+		return NewClassifiedError(
+			ErrorClassInput,
+			fmt.Errorf("unable to parse input: %w", err),
+		)
+	}
+
+	releaseRepos, err := getReleaseRepos(nodes, nodes)
+	if err != nil {
+		return fmt.Errorf("unable to get release repos: %w", err)
+	}
+	if err := checkMissingSources(releaseRepos); err != nil {
+		return err
+	}
+
+	cacheDir, err := os.MkdirTemp("", "chart-vendor-")
+	if err != nil {
+		return fmt.Errorf("unable to create a vendor cache dir: %w", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(cacheDir); err != nil {
+			expander.logger.
+				With("error", err).
+				With("dir", cacheDir).
+				Error("Unable to clean the vendor cache directory")
+		}
+	}()
+
+	if err := os.MkdirAll(vendorDir, 0o755); err != nil {
+		return fmt.Errorf("unable to create vendor directory: %w", err)
+	}
+
+	config := expander.getLoaderConfig()
+	config.cacheRoot = cacheDir
+	config.credentials = credentials
+
+	manifest := &VendorManifest{Releases: []VendoredRelease{}}
+	// Digests of the charts saved by this invocation, by the vendor path.
+	digests := map[string]string{}
+	for _, pair := range releaseRepos {
+		if err := expander.ctx.Err(); err != nil {
+			return fmt.Errorf("vendoring charts canceled: %w", err)
+		}
+		var release helmv2.HelmRelease
+		if err := decodeToObject(pair.release, &release); err != nil {
+			return fmt.Errorf(
+				"unable to decode HelmRelease %s/%s: %w",
+				pair.release.GetNamespace(),
+				pair.release.GetName(),
+				err,
+			)
+		}
+		if pair.repo == nil {
+			return fmt.Errorf(
+				"missing chart repository %s for Helm release %s/%s",
+				release.Spec.Chart.Spec.SourceRef.Name,
+				release.Namespace,
+				release.Name,
+			)
+		}
+		repoURL, err := pair.repo.GetString("spec.url")
+		if err != nil {
+			return fmt.Errorf(
+				"unable to get URL for %s %s/%s: %w",
+				pair.repo.GetKind(),
+				pair.repo.GetNamespace(),
+				pair.repo.GetName(),
+				err,
+			)
+		}
+
+		chart, _, err := loadRepositoryChart(config, &release, pair.repo)
+		if err != nil {
+			return fmt.Errorf(
+				"unable to fetch chart for Helm release %s/%s: %w",
+				release.Namespace,
+				release.Name,
+				err,
+			)
+		}
+
+		chartDir := fmt.Sprintf("%s-%s", chart.Name(), chart.Metadata.Version)
+		digest := getChartDigest(chart)
+		if saved, ok := digests[chartDir]; !ok {
+			targetDir := filepath.Join(vendorDir, chartDir)
+			if err := os.RemoveAll(targetDir); err != nil {
+				return fmt.Errorf(
+					"unable to remove previously vendored chart %s: %w",
+					chartDir,
+					err,
+				)
+			}
+			if err := chartutil.SaveDir(chart, targetDir); err != nil {
+				return fmt.Errorf("unable to save chart %s: %w", chartDir, err)
+			}
+			digests[chartDir] = digest
+		} else if saved != digest {
+			return fmt.Errorf(
+				"chart %s of Helm release %s/%s differs from another "+
+					"vendored chart with the same name and version",
+				chartDir,
+				release.Namespace,
+				release.Name,
+			)
+		}
+
+		manifest.Releases = append(manifest.Releases, VendoredRelease{
+			Namespace:  release.Namespace,
+			Name:       release.Name,
+			SourceKind: pair.repo.GetKind(),
+			URL:        repoURL,
+			Chart:      release.Spec.Chart.Spec.Chart,
+			Version:    chart.Metadata.Version,
+			// SaveDir places the chart into a subdirectory named after it.
+			Path: path.Join(chartDir, chart.Name()),
+		})
+		expander.logger.
+			With("namespace", release.Namespace).
+			With("name", release.Name).
+			With("path", chartDir).
+			Info("Vendored chart for Helm release")
+	}
+
+	manifestFile, err := os.Create(filepath.Join(vendorDir, VendorManifestFileName))
+	if err != nil {
+		return fmt.Errorf("unable to create vendor manifest: %w", err)
+	}
+	defer manifestFile.Close()
+	if err := manifest.write(manifestFile); err != nil {
+		return err
+	}
+	return manifestFile.Close()
+}
@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Vendored charts", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+	var repoRoot string
+	var vendorDir string
+	var input string
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+
+		var err error
+		repoRoot, err = os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		server, port, serverDone, err := serveDirectory(repoRoot, logger, nil)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		err = createSingleChartHelmRepository(
+			"test-chart",
+			"0.1.0",
+			map[string]string{
+				"Chart.yaml": strings.Join([]string{
+					"apiVersion: v2",
+					"name: test-chart",
+					"version: 0.1.0",
+				}, "\n"),
+				"templates/configmap.yaml": strings.Join([]string{
+					"apiVersion: v1",
+					"kind: ConfigMap",
+					"metadata:",
+					"  name: {{ .Release.Name }}-configmap",
+				}, "\n"),
+			},
+			port,
+			repoRoot,
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		input = strings.Join([]string{
+			"apiVersion: helm.toolkit.fluxcd.io/v2",
+			"kind: HelmRelease",
+			"metadata:",
+			"  namespace: testns",
+			"  name: test",
+			"spec:",
+			"  chart:",
+			"    spec:",
+			"      chart: test-chart",
+			"      version: 0.1.x",
+			"      sourceRef:",
+			"        kind: HelmRepository",
+			"        name: local",
+			"---",
+			"apiVersion: source.toolkit.fluxcd.io/v1",
+			"kind: HelmRepository",
+			"metadata:",
+			"  namespace: testns",
+			"  name: local",
+			"spec:",
+			fmt.Sprintf("  url: http://localhost:%d", port),
+		}, "\n")
+
+		vendorDir, err = os.MkdirTemp("", "")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		err = expander.VendorCharts(Credentials{}, bytes.NewBufferString(input), vendorDir)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		err = stopServing(server, serverDone)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+	})
+
+	ginkgo.AfterEach(func() {
+		g.Expect(os.RemoveAll(repoRoot)).To(gomega.Succeed())
+		g.Expect(os.RemoveAll(vendorDir)).To(gomega.Succeed())
+	})
+
+	ginkgo.It("writes the charts and the manifest", func() {
+		manifest, err := ReadVendorManifest(vendorDir)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(manifest.Releases).To(gomega.HaveLen(1))
+		g.Expect(manifest.Releases[0].Namespace).To(gomega.Equal("testns"))
+		g.Expect(manifest.Releases[0].Name).To(gomega.Equal("test"))
+		g.Expect(manifest.Releases[0].Version).To(gomega.Equal("0.1.0"))
+		g.Expect(manifest.Releases[0].Path).To(gomega.Equal("test-chart-0.1.0/test-chart"))
+		g.Expect(filepath.Join(
+			vendorDir,
+			"test-chart-0.1.0",
+			"test-chart",
+			"templates",
+			"configmap.yaml",
+		)).To(gomega.BeAnExistingFile())
+	})
+
+	ginkgo.It("renders releases from the vendored charts", func() {
+		manifest, err := ReadVendorManifest(vendorDir)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		output := &bytes.Buffer{}
+		err = expander.Expand(
+			bytes.NewBufferString(input),
+			output,
+			ExpandOptions{
+				VendorManifest: manifest,
+			},
+		)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(output.String()).To(gomega.ContainSubstring("name: test-configmap"))
+	})
+
+	ginkgo.It("ignores vendored charts not matching the version constraint", func() {
+		manifest, err := ReadVendorManifest(vendorDir)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		expander := NewHelmReleaseExpander(ctx, logger, nil, nil)
+		err = expander.Expand(
+			bytes.NewBufferString(strings.Replace(input, "0.1.x", "0.2.x", 1)),
+			&bytes.Buffer{},
+			ExpandOptions{
+				VendorManifest: manifest,
+			},
+		)
+		// The chart is loaded from the repository, which is no longer served.
+		g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(
+			"unable to load chart for HelmRepository testns/local",
+		)))
+	})
+})
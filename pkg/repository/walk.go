@@ -0,0 +1,574 @@
+// Copyright © The Sage Group plc or its licensors.
+
+package repository
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/base64"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	"sigs.k8s.io/kustomize/api/konfig"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+
+	yamlutil "github.com/sageailabs/fouskoti/pkg/yaml"
+)
+
+// walkOverlaysDir is the directory in the checkouts of Git repositories with
+// the overlays applying the settings of Flux Kustomization objects to their
+// paths.
+const walkOverlaysDir = ".fouskoti-walk"
+
+// substituteAnnotation disables the variable substitution of Flux
+// Kustomization objects in the objects annotated with "disabled".
+const substituteAnnotation = "kustomize.toolkit.fluxcd.io/substitute"
+
+// variablePattern matches the variables Flux substitutes after building
+// Kustomization objects, ${name}, optionally with a default value for unset
+// variables, ${name=default}, or for unset and empty ones, ${name:=default},
+// with - allowed in place of =.  Variables prefixed with another $ are
+// escaped.
+var variablePattern = regexp.MustCompile(`\$?\$\{([_a-zA-Z][_a-zA-Z0-9]*)(?:(:?[=-])([^}]*))?\}`)
+
+// repoWalker builds the objects Flux applies from a Git repository.
+type repoWalker struct {
+	expander    *HelmReleaseExpander
+	credentials Credentials
+	source      *GitSource
+	cloneDir    string
+	// root is the checkout of the source.
+	root string
+	// checkouts are the checkouts of the GitRepository objects, by
+	// <namespace>/<name>.
+	checkouts map[string]string
+	// nodes are the built objects, each object only once.
+	nodes []*yaml.RNode
+	ids   map[string]bool
+	// kustomizations are the Flux Kustomization objects among the nodes, in
+	// the order they were built in.
+	kustomizations []*yaml.RNode
+	overlays       int
+}
+
+// Walk clones the Git repository of the source and returns the YAML of the
+// objects Flux would apply from it: the objects built from the path of the
+// source, like Flux builds Kustomization objects, followed by the objects
+// built from the Flux Kustomization objects among them, and so on.  Each
+// object is returned only once, so that the result can be expanded.
+func (expander *HelmReleaseExpander) Walk(
+	credentials Credentials,
+	source *GitSource,
+) ([]byte, error) {
+	cloneDir, err := os.MkdirTemp("", "git-walk-")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create a clone dir: %w", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(cloneDir); err != nil {
+			expander.logger.
+				With("error", err).
+				With("dir", cloneDir).
+				Error("Unable to clean the clone directory")
+		}
+	}()
+
+	walker := &repoWalker{
+		expander:    expander,
+		credentials: credentials,
+		source:      source,
+		cloneDir:    cloneDir,
+		checkouts:   map[string]string{},
+		ids:         map[string]bool{},
+	}
+	walker.root, err = expander.cloneGitSource(credentials, source, cloneDir)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := walker.build(walker.root, source.Path, yaml.NewMapRNode(nil))
+	if err != nil {
+		return nil, fmt.Errorf("unable to build %s: %w", cmp.Or(source.Path, "."), err)
+	}
+	walker.add(nodes)
+	for i := 0; i < len(walker.kustomizations); i++ {
+		kustomization := walker.kustomizations[i]
+		nodes, err := walker.buildKustomization(kustomization)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"unable to build Flux Kustomization %s/%s: %w",
+				kustomization.GetNamespace(),
+				kustomization.GetName(),
+				err,
+			)
+		}
+		walker.add(nodes)
+	}
+
+	result := &bytes.Buffer{}
+	if err := (kio.ByteWriter{Writer: result}).Write(walker.nodes); err != nil {
+		return nil, fmt.Errorf("unable to write the built objects: %w", err)
+	}
+	return result.Bytes(), nil
+}
+
+// add adds the nodes which have not been added yet.
+func (walker *repoWalker) add(nodes []*yaml.RNode) {
+	for _, node := range nodes {
+		group := yamlutil.GetGroup(node)
+		id := strings.Join(
+			[]string{group, node.GetKind(), node.GetNamespace(), node.GetName()},
+			"/",
+		)
+		if walker.ids[id] {
+			continue
+		}
+		walker.ids[id] = true
+		walker.nodes = append(walker.nodes, node)
+		if group == "kustomize.toolkit.fluxcd.io" && node.GetKind() == "Kustomization" {
+			walker.kustomizations = append(walker.kustomizations, node)
+		}
+	}
+}
+
+// normalizeRepoURL returns the URL without the trailing slash and .git suffix,
+// which don't change the repository it refers to.
+func normalizeRepoURL(repoURL string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(repoURL, "/"), ".git")
+}
+
+// getCheckout returns the checkout of the GitRepository object, which is the
+// checkout of the walked source for the GitRepository objects of its
+// repository, or an empty path if the object is missing.
+func (walker *repoWalker) getCheckout(namespace string, name string) (string, error) {
+	key := namespace + "/" + name
+	if checkout, found := walker.checkouts[key]; found {
+		return checkout, nil
+	}
+	node := newSourceIndex(walker.nodes).findSource("GitRepository", namespace, name)
+	if node == nil {
+		return "", nil
+	}
+	var repo sourcev1.GitRepository
+	if err := decodeToObject(node, &repo); err != nil {
+		return "", NewClassifiedError(ErrorClassInput, fmt.Errorf(
+			"unable to decode GitRepository %s: %w",
+			key,
+			err,
+		))
+	}
+
+	checkout := walker.root
+	if normalizeRepoURL(repo.Spec.URL) != normalizeRepoURL(walker.source.URL) {
+		var err error
+		checkout, err = walker.expander.
+			newGitSourceLoader(walker.credentials, repo.Spec.URL, walker.cloneDir).
+			cloneRepo(&repo, repo.Spec.URL)
+		if err != nil {
+			return "", err
+		}
+	}
+	walker.checkouts[key] = checkout
+	return checkout, nil
+}
+
+// buildKustomization builds the objects of the Flux Kustomization object with
+// its settings and variables applied.  Kustomization objects with sources
+// other than GitRepository objects are skipped with a warning, and the ones
+// with GitRepository objects missing from the built objects are built from the
+// walked source.
+func (walker *repoWalker) buildKustomization(kustomization *yaml.RNode) ([]*yaml.RNode, error) {
+	namespace := kustomization.GetNamespace()
+	sourceKind, _ := kustomization.GetString("spec.sourceRef.kind")
+	sourceName, _ := kustomization.GetString("spec.sourceRef.name")
+	sourceNamespace, _ := kustomization.GetString("spec.sourceRef.namespace")
+	sourceNamespace = cmp.Or(sourceNamespace, namespace)
+	logger := walker.expander.logger.
+		With("namespace", namespace).
+		With("name", kustomization.GetName()).
+		With("sourceKind", sourceKind).
+		With("sourceName", sourceNamespace+"/"+sourceName)
+
+	if sourceKind != "GitRepository" {
+		logger.Warn("Skipping Flux Kustomization with a source other than a GitRepository")
+		return nil, nil
+	}
+	root, err := walker.getCheckout(sourceNamespace, sourceName)
+	if err != nil {
+		return nil, err
+	}
+	if root == "" {
+		logger.Warn("GitRepository of Flux Kustomization is missing, building it from the walked source")
+		root = walker.root
+	}
+
+	kustomizationPath, _ := kustomization.GetString("spec.path")
+	spec, err := kustomization.Pipe(yaml.Lookup("spec"))
+	if err != nil || spec == nil {
+		spec = yaml.NewMapRNode(nil)
+	}
+	nodes, err := walker.build(root, kustomizationPath, spec)
+	if err != nil {
+		return nil, err
+	}
+	postBuild, err := kustomization.Pipe(yaml.Lookup("spec", "postBuild"))
+	if err != nil || postBuild == nil {
+		return nodes, nil
+	}
+	variables, err := walker.getVariables(namespace, postBuild)
+	if err != nil {
+		return nil, err
+	}
+	return substituteVariables(nodes, variables)
+}
+
+// build builds the path relative to the root of a checkout through an overlay
+// with the settings of the spec of a Flux Kustomization object, the target
+// namespace, name prefix and suffix, patches, images, components, and common
+// metadata, the same way Flux does.
+func (walker *repoWalker) build(root string, kustomizationPath string, spec *yaml.RNode) ([]*yaml.RNode, error) {
+	kustomizationPath = filepath.FromSlash(cmp.Or(kustomizationPath, "."))
+	if !filepath.IsLocal(kustomizationPath) {
+		return nil, NewClassifiedError(ErrorClassInput, fmt.Errorf(
+			"path %s is outside of the repository",
+			kustomizationPath,
+		))
+	}
+	dir := filepath.Join(root, kustomizationPath)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return nil, NewClassifiedError(ErrorClassInput, fmt.Errorf(
+			"path %s is no directory in the repository",
+			kustomizationPath,
+		))
+	}
+	if err := generateKustomization(dir); err != nil {
+		return nil, err
+	}
+
+	walker.overlays++
+	overlayDir := filepath.Join(root, walkOverlaysDir, strconv.Itoa(walker.overlays))
+	if err := os.MkdirAll(overlayDir, 0755); err != nil {
+		return nil, fmt.Errorf("unable to create overlay directory: %w", err)
+	}
+	relativeDir, err := filepath.Rel(overlayDir, dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get path of %s: %w", kustomizationPath, err)
+	}
+	overlay, err := getOverlay(filepath.ToSlash(relativeDir), spec)
+	if err != nil {
+		return nil, err
+	}
+	fileName := filepath.Join(overlayDir, konfig.DefaultKustomizationFileName())
+	if err := os.WriteFile(fileName, []byte(overlay.MustString()), 0644); err != nil {
+		return nil, fmt.Errorf("unable to write overlay: %w", err)
+	}
+
+	options := krusty.MakeDefaultOptions()
+	options.LoadRestrictions = types.LoadRestrictionsNone
+	resources, err := krusty.MakeKustomizer(options).Run(filesys.MakeFsOnDisk(), overlayDir)
+	if err != nil {
+		return nil, NewClassifiedError(ErrorClassInput, fmt.Errorf(
+			"unable to build %s: %w",
+			kustomizationPath,
+			err,
+		))
+	}
+	return resources.ToRNodeSlice(), nil
+}
+
+// getOverlay returns the kustomization including the directory with the
+// settings of the spec of a Flux Kustomization object.
+func getOverlay(dir string, spec *yaml.RNode) (*yaml.RNode, error) {
+	overlay := yaml.NewMapRNode(&map[string]string{
+		"apiVersion": "kustomize.config.k8s.io/v1beta1",
+		"kind":       "Kustomization",
+	})
+	fields := map[string]*yaml.RNode{"resources": yaml.NewListRNode(dir)}
+	for source, target := range map[string]string{
+		"targetNamespace": "namespace",
+		"namePrefix":      "namePrefix",
+		"nameSuffix":      "nameSuffix",
+	} {
+		if value, _ := spec.GetString(source); value != "" {
+			fields[target] = yaml.NewScalarRNode(value)
+		}
+	}
+	for _, name := range []string{"patches", "images"} {
+		if value, err := spec.Pipe(yaml.Lookup(name)); err == nil && value != nil {
+			fields[name] = value
+		}
+	}
+	// The components are relative to the path.
+	if components, err := spec.GetSlice("components"); err == nil && len(components) > 0 {
+		paths := []string{}
+		for _, component := range components {
+			paths = append(paths, dir+"/"+fmt.Sprint(component))
+		}
+		fields["components"] = yaml.NewListRNode(paths...)
+	}
+	if labels, err := spec.Pipe(yaml.Lookup("commonMetadata", "labels")); err == nil && labels != nil {
+		pairs := yaml.NewMapRNode(nil)
+		if err := pairs.PipeE(yaml.SetField("pairs", labels)); err != nil {
+			return nil, fmt.Errorf("unable to set common labels: %w", err)
+		}
+		fields["labels"] = yaml.NewListRNode()
+		if err := fields["labels"].PipeE(yaml.Append(pairs.YNode())); err != nil {
+			return nil, fmt.Errorf("unable to set common labels: %w", err)
+		}
+	}
+	if annotations, err := spec.Pipe(yaml.Lookup("commonMetadata", "annotations")); err == nil && annotations != nil {
+		fields["commonAnnotations"] = annotations
+	}
+	for _, name := range []string{
+		"resources",
+		"namespace",
+		"namePrefix",
+		"nameSuffix",
+		"components",
+		"patches",
+		"images",
+		"labels",
+		"commonAnnotations",
+	} {
+		if value := fields[name]; value != nil {
+			if err := overlay.SetMapField(value, name); err != nil {
+				return nil, fmt.Errorf("unable to set %s of overlay: %w", name, err)
+			}
+		}
+	}
+	return overlay, nil
+}
+
+// hasKustomizationFile tells whether the directory has a kustomization file.
+func hasKustomizationFile(dir string) bool {
+	for _, name := range konfig.RecognizedKustomizationFileNames() {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// isManifestFile tells whether the YAML file holds Kubernetes objects.
+func isManifestFile(fileName string) bool {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return false
+	}
+	nodes, err := (&kio.ByteReader{Reader: bytes.NewReader(data)}).Read()
+	if err != nil || len(nodes) == 0 {
+		return false
+	}
+	for _, node := range nodes {
+		if node.GetApiVersion() == "" || node.GetKind() == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// generateKustomization writes a kustomization file into the directory unless
+// it has one, listing the manifests in it and in its subdirectories, the same
+// way Flux does for Kustomization objects: the subdirectories with
+// kustomization files of their own are listed instead of their files, and
+// YAML files without Kubernetes objects are left out.
+func generateKustomization(dir string) error {
+	if hasKustomizationFile(dir) {
+		return nil
+	}
+	content := kustomization{
+		APIVersion: "kustomize.config.k8s.io/v1beta1",
+		Kind:       "Kustomization",
+		Resources:  []string{},
+	}
+	err := filepath.WalkDir(dir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if filePath == dir {
+			return nil
+		}
+		relativePath, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			switch {
+			case entry.Name() == ".git" || entry.Name() == walkOverlaysDir:
+				return filepath.SkipDir
+			case hasKustomizationFile(filePath):
+				content.Resources = append(content.Resources, filepath.ToSlash(relativePath))
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch filepath.Ext(filePath) {
+		case ".yaml", ".yml":
+			if isManifestFile(filePath) {
+				content.Resources = append(content.Resources, filepath.ToSlash(relativePath))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to list files in %s: %w", dir, err)
+	}
+	data, err := yaml.Marshal(content)
+	if err != nil {
+		return fmt.Errorf("unable to encode kustomization: %w", err)
+	}
+	fileName := filepath.Join(dir, konfig.DefaultKustomizationFileName())
+	if err := os.WriteFile(fileName, data, 0644); err != nil {
+		return fmt.Errorf("unable to write kustomization file: %w", err)
+	}
+	return nil
+}
+
+// getVariables returns the variables of the post build settings of a Flux
+// Kustomization object in the namespace: the data of the ConfigMap and Secret
+// objects it substitutes from, in order, overridden by the variables it
+// substitutes.
+func (walker *repoWalker) getVariables(namespace string, postBuild *yaml.RNode) (map[string]string, error) {
+	variables := map[string]string{}
+	sources, _ := postBuild.Pipe(yaml.Lookup("substituteFrom"))
+	if sources != nil {
+		elements, err := sources.Elements()
+		if err != nil {
+			return nil, fmt.Errorf("unable to get substituteFrom: %w", err)
+		}
+		index := newSourceIndex(walker.nodes)
+		for _, source := range elements {
+			kind, _ := source.GetString("kind")
+			name, _ := source.GetString("name")
+			optional, _ := source.GetString("optional")
+			node := index.find(kind, namespace, name, "v1")
+			if node == nil {
+				if optional == "true" {
+					continue
+				}
+				return nil, NewClassifiedError(ErrorClassInput, fmt.Errorf(
+					"%s %s/%s to substitute variables from is missing",
+					kind,
+					namespace,
+					name,
+				))
+			}
+			if err := addSourceVariables(variables, node); err != nil {
+				return nil, err
+			}
+		}
+	}
+	substitute, _ := postBuild.Pipe(yaml.Lookup("substitute"))
+	if substitute != nil {
+		err := substitute.VisitFields(func(field *yaml.MapNode) error {
+			variables[field.Key.YNode().Value] = field.Value.YNode().Value
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to get substitute: %w", err)
+		}
+	}
+	return variables, nil
+}
+
+// addSourceVariables adds the data of the ConfigMap or Secret to the
+// variables.
+func addSourceVariables(variables map[string]string, node *yaml.RNode) error {
+	switch node.GetKind() {
+	case "ConfigMap":
+		for key, value := range node.GetDataMap() {
+			variables[key] = value
+		}
+	case "Secret":
+		for key, value := range node.GetDataMap() {
+			decoded, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return NewClassifiedError(ErrorClassInput, fmt.Errorf(
+					"unable to decode %s of Secret %s/%s: %w",
+					key,
+					node.GetNamespace(),
+					node.GetName(),
+					err,
+				))
+			}
+			variables[key] = string(decoded)
+		}
+		stringData, _ := node.Pipe(yaml.Lookup("stringData"))
+		if stringData != nil {
+			err := stringData.VisitFields(func(field *yaml.MapNode) error {
+				variables[field.Key.YNode().Value] = field.Value.YNode().Value
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("unable to get stringData: %w", err)
+			}
+		}
+	default:
+		return NewClassifiedError(ErrorClassInput, fmt.Errorf(
+			"unable to substitute variables from %s, expected a ConfigMap or a Secret",
+			node.GetKind(),
+		))
+	}
+	return nil
+}
+
+// substituteVariable returns the value of the variable matched by
+// variablePattern.
+func substituteVariable(match string, variables map[string]string) string {
+	if strings.HasPrefix(match, "$$") {
+		return match[1:]
+	}
+	groups := variablePattern.FindStringSubmatch(match)
+	name, operator, defaultValue := groups[1], groups[2], groups[3]
+	value, found := variables[name]
+	if operator != "" && (!found || (strings.HasPrefix(operator, ":") && value == "")) {
+		return defaultValue
+	}
+	return value
+}
+
+// substituteVariables replaces the variables in the nodes, except for the ones
+// annotated to disable substitution, the same way Flux does after building
+// Kustomization objects.  Undefined variables without defaults are replaced
+// with empty strings.
+func substituteVariables(nodes []*yaml.RNode, variables map[string]string) ([]*yaml.RNode, error) {
+	result := make([]*yaml.RNode, 0, len(nodes))
+	for _, node := range nodes {
+		if node.GetAnnotations()[substituteAnnotation] == "disabled" {
+			result = append(result, node)
+			continue
+		}
+		text, err := node.String()
+		if err != nil {
+			return nil, fmt.Errorf("unable to encode %s %s: %w", node.GetKind(), node.GetName(), err)
+		}
+		substituted := variablePattern.ReplaceAllStringFunc(text, func(match string) string {
+			return substituteVariable(match, variables)
+		})
+		if substituted == text {
+			result = append(result, node)
+			continue
+		}
+		substitutedNode, err := yaml.Parse(substituted)
+		if err != nil {
+			return nil, NewClassifiedError(ErrorClassInput, fmt.Errorf(
+				"unable to parse %s %s after substituting variables: %w",
+				node.GetKind(),
+				node.GetName(),
+				err,
+			))
+		}
+		result = append(result, substitutedNode)
+	}
+	return result, nil
+}
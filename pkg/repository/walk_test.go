@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/fluxcd/pkg/git"
+	"github.com/fluxcd/pkg/git/gogit"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+var _ = ginkgo.Describe("Repository walk", func() {
+	var g gomega.Gomega
+	var ctx context.Context
+	var logger *slog.Logger
+
+	ginkgo.BeforeEach(func() {
+		g = gomega.NewWithT(ginkgo.GinkgoT())
+		ctx = context.Background()
+		handler := slog.NewTextHandler(
+			ginkgo.GinkgoWriter,
+			&slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug},
+		)
+		logger = slog.New(handler)
+	})
+
+	ginkgo.It("builds the Flux Kustomizations found from the entry path", func() {
+		var repoRoot string
+		gitClient := &GitClientMock{}
+		gitClient.
+			On("Clone", mock.Anything, repoURL, mock.Anything).
+			Run(func(mock.Arguments) {
+				err := createFileTree(repoRoot, map[string]string{
+					"clusters/prod/flux-system/gotk-sync.yaml": strings.Join([]string{
+						"apiVersion: source.toolkit.fluxcd.io/v1",
+						"kind: GitRepository",
+						"metadata:",
+						"  namespace: flux-system",
+						"  name: flux-system",
+						"spec:",
+						"  url: " + repoURL,
+						"  ref:",
+						"    branch: main",
+						"---",
+						"apiVersion: kustomize.toolkit.fluxcd.io/v1",
+						"kind: Kustomization",
+						"metadata:",
+						"  namespace: flux-system",
+						"  name: flux-system",
+						"spec:",
+						"  path: ./clusters/prod",
+						"  sourceRef:",
+						"    kind: GitRepository",
+						"    name: flux-system",
+					}, "\n"),
+					"clusters/prod/apps.yaml": strings.Join([]string{
+						"apiVersion: kustomize.toolkit.fluxcd.io/v1",
+						"kind: Kustomization",
+						"metadata:",
+						"  namespace: flux-system",
+						"  name: apps",
+						"spec:",
+						"  path: ./apps/prod",
+						"  targetNamespace: apps",
+						"  sourceRef:",
+						"    kind: GitRepository",
+						"    name: flux-system",
+						"  postBuild:",
+						"    substitute:",
+						"      environment: prod",
+					}, "\n"),
+					"apps/prod/settings.yaml": strings.Join([]string{
+						"apiVersion: v1",
+						"kind: ConfigMap",
+						"metadata:",
+						"  name: settings",
+						"data:",
+						"  environment: ${environment}",
+						"  region: ${region:=eu-west-1}",
+						"  literal: $${environment}",
+					}, "\n"),
+					"apps/prod/values.yaml": "replicas: 2",
+				})
+				g.Expect(err).ToNot(gomega.HaveOccurred())
+			}).
+			Return(&git.Commit{Hash: git.Hash("dummy")}, nil)
+		expander := NewHelmReleaseExpander(
+			ctx,
+			logger,
+			func(
+				path string,
+				authOpts *git.AuthOptions,
+				clientOpts ...gogit.ClientOption,
+			) (GitClientInterface, error) {
+				repoRoot = path
+				return gitClient, nil
+			},
+			nil,
+		)
+
+		source, err := ParseGitSource(repoURL + "@main:clusters/prod")
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		result, err := expander.Walk(getDummySSHCreds(repoURL), source)
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		nodes, err := (&kio.ByteReader{Reader: strings.NewReader(string(result))}).Read()
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+
+		names := []string{}
+		for _, node := range nodes {
+			names = append(names, node.GetKind()+" "+node.GetNamespace()+"/"+node.GetName())
+		}
+		g.Expect(names).To(gomega.ConsistOf(
+			"GitRepository flux-system/flux-system",
+			"Kustomization flux-system/flux-system",
+			"Kustomization flux-system/apps",
+			"ConfigMap apps/settings",
+		))
+		for _, node := range nodes {
+			if node.GetKind() == "ConfigMap" {
+				g.Expect(node.GetDataMap()).To(gomega.Equal(map[string]string{
+					"environment": "prod",
+					"region":      "eu-west-1",
+					"literal":     "${environment}",
+				}))
+			}
+		}
+	})
+
+	ginkgo.It("leaves the objects with substitution disabled alone", func() {
+		node := yaml.MustParse(strings.Join([]string{
+			"apiVersion: v1",
+			"kind: ConfigMap",
+			"metadata:",
+			"  name: settings",
+			"  annotations:",
+			"    kustomize.toolkit.fluxcd.io/substitute: disabled",
+			"data:",
+			"  environment: ${environment}",
+		}, "\n"))
+		result, err := substituteVariables([]*yaml.RNode{node}, map[string]string{
+			"environment": "prod",
+		})
+		g.Expect(err).ToNot(gomega.HaveOccurred())
+		g.Expect(result[0].GetDataMap()).To(gomega.HaveKeyWithValue("environment", "${environment}"))
+	})
+})